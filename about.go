@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// AboutType is the content type of the FeedAbout bootstrap message
+const AboutType = "gabbygrove/about"
+
+// maxAboutNameLength bounds the name of a feed in bytes
+const maxAboutNameLength = 128
+
+// FeedAbout is the conventional first message of a gabbygrove feed.
+// It declares the name of the feed, an optional image and which extensions its events may carry.
+type FeedAbout struct {
+	Type string `json:"type"`
+
+	Name  string        `json:"name"`
+	Image *refs.BlobRef `json:"image,omitempty"`
+
+	// Extensions lists the keys of the event extensions the feed uses, sorted and without duplicates
+	Extensions []string `json:"extensions,omitempty"`
+}
+
+// NewFeedAbout returns a bootstrap declaration for name, the extensions are sorted and deduplicated.
+func NewFeedAbout(name string, image *refs.BlobRef, extensions ...string) *FeedAbout {
+	var about FeedAbout
+	about.Type = AboutType
+	about.Name = name
+	about.Image = image
+	if len(extensions) > 0 {
+		sorted := append([]string{}, extensions...)
+		sort.Strings(sorted)
+		for i, ext := range sorted {
+			if i > 0 && ext == sorted[i-1] {
+				continue
+			}
+			about.Extensions = append(about.Extensions, ext)
+		}
+	}
+	return &about
+}
+
+// Validate checks that the declaration follows the conventional layout
+func (about FeedAbout) Validate() error {
+	if about.Type != AboutType {
+		return errors.Errorf("about: wrong type: %q", about.Type)
+	}
+	if about.Name == "" {
+		return errors.Errorf("about: name is missing")
+	}
+	if n := len(about.Name); n > maxAboutNameLength {
+		return errors.Errorf("about: name too long (%d bytes)", n)
+	}
+	for i, ext := range about.Extensions {
+		if ext == "" {
+			return errors.Errorf("about: empty extension name")
+		}
+		if i > 0 && about.Extensions[i-1] >= ext {
+			return errors.Errorf("about: extensions not sorted or duplicated: %q", ext)
+		}
+	}
+	return nil
+}
+
+// EncodeAbout encodes about as the first message of the feed of e
+func (e *Encoder) EncodeAbout(about FeedAbout) (*Transfer, refs.MessageRef, error) {
+	if err := about.Validate(); err != nil {
+		return nil, refs.MessageRef{}, err
+	}
+	return e.Encode(1, BinaryRef{}, about)
+}
+
+// ParseAbout validates tr as a bootstrap message and returns its declaration.
+// The transfer needs to be the first message of its feed and carry the matching JSON content.
+// Whether the author signed it isn't checked here, append it to a FeedState or Validator for that.
+func ParseAbout(tr *Transfer) (*FeedAbout, error) {
+	evt, err := tr.getEvent()
+	if err != nil {
+		return nil, errors.Wrap(err, "about: invalid event")
+	}
+	if evt.Sequence != 1 {
+		return nil, errors.Errorf("about: not the first message (sequence %d)", evt.Sequence)
+	}
+	if evt.Content.Type != ContentTypeJSON {
+		return nil, errors.Errorf("about: content is not JSON")
+	}
+
+	if len(tr.Content) == 0 {
+		return nil, errors.Errorf("about: content missing")
+	}
+	if !tr.ContentMatches(tr.Content) {
+		return nil, errors.Errorf("about: content does not match the event")
+	}
+
+	var about FeedAbout
+	if err := json.Unmarshal(tr.Content, &about); err != nil {
+		return nil, errors.Wrap(err, "about: invalid JSON")
+	}
+	if err := about.Validate(); err != nil {
+		return nil, err
+	}
+	return &about, nil
+}
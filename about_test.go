@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+func TestFeedAbout(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	e := NewEncoder(privKey)
+
+	img, err := refs.NewBlobRefFromBytes(bytes.Repeat([]byte{1}, 32), refs.RefAlgoBlobSSB1)
+	r.NoError(err)
+
+	about := NewFeedAbout("alice", &img, "region", "app", "region")
+	a.Equal([]string{"app", "region"}, about.Extensions)
+
+	tr, key, err := e.EncodeAbout(*about)
+	r.NoError(err)
+
+	state := NewFeedState(tr.Author())
+	r.NoError(state.Append(tr))
+	a.Equal(key, *state.Tip)
+
+	got, err := ParseAbout(tr)
+	r.NoError(err)
+	a.Equal("alice", got.Name)
+	r.NotNil(got.Image)
+	a.True(got.Image.Equal(img))
+	a.Equal(about.Extensions, got.Extensions)
+
+	// invalid declarations
+	_, _, err = e.EncodeAbout(FeedAbout{Type: AboutType})
+	a.Error(err)
+	_, _, err = e.EncodeAbout(FeedAbout{Type: AboutType, Name: "bob", Extensions: []string{"b", "a"}})
+	a.Error(err)
+
+	// not the first message
+	second, _, err := e.Encode(2, BinaryRef{}, about)
+	r.NoError(err)
+	_, err = ParseAbout(second)
+	a.Error(err)
+
+	// content was swapped
+	swapped := *tr
+	swapped.Content = []byte(`{"type":"gabbygrove/about","name":"mallory"}`)
+	_, err = ParseAbout(&swapped)
+	a.Error(err)
+
+	// content was dropped
+	swapped.Content = nil
+	_, err = ParseAbout(&swapped)
+	a.Error(err)
+}
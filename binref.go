@@ -8,7 +8,6 @@ import (
 	"fmt"
 
 	"github.com/pkg/errors"
-	"github.com/ugorji/go/codec"
 	"golang.org/x/crypto/ed25519"
 
 	refs "go.mindeco.de/ssb-refs"
@@ -179,37 +178,3 @@ func refFromPubKey(pk ed25519.PublicKey) (BinaryRef, error) {
 		r: fr,
 	}, err
 }
-
-type BinRefExt struct{}
-
-var _ codec.InterfaceExt = (*BinRefExt)(nil)
-
-func (x BinRefExt) ConvertExt(v interface{}) interface{} {
-	br, ok := v.(*BinaryRef)
-	if !ok {
-		panic(fmt.Sprintf("unsupported format expecting to decode into *BinaryRef; got %T", v))
-	}
-	refBytes, err := br.MarshalBinary()
-	if err != nil {
-		panic(err) //hrm...
-	}
-	return refBytes
-}
-
-func (x BinRefExt) UpdateExt(dst interface{}, src interface{}) {
-	br, ok := dst.(*BinaryRef)
-	if !ok {
-		panic(fmt.Sprintf("unsupported format - expecting to decode into *BinaryRef; got %T", dst))
-	}
-
-	input, ok := src.([]byte)
-	if !ok {
-		panic(fmt.Sprintf("unsupported input format - expecting to decode from []byte; got %T", src))
-	}
-
-	err := br.UnmarshalBinary(input)
-	if err != nil {
-		panic(err)
-	}
-
-}
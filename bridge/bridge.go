@@ -0,0 +1,216 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+// Package bridge distributes transfers over a message broker like NATS or MQTT, one topic per author.
+//
+// The broker is abstracted by the Broker interface, so the client library is up to the application.
+// For NATS, Publish is nats.Conn.Publish and Subscribe wraps nats.Conn.Subscribe. For MQTT, they map to the client's
+// Publish and Subscribe with the QoS of choice.
+//
+// Every message on a topic is one encoded transfer. Inbound ones are validated before they are passed on,
+// the broker is not trusted.
+package bridge
+
+import (
+	"encoding/hex"
+	"sync"
+
+	"github.com/pkg/errors"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// Broker is the part of a message broker client the bridge needs
+type Broker interface {
+	// Publish sends data to all subscribers of topic
+	Publish(topic string, data []byte) error
+
+	// Subscribe calls handler with every message on topic until the returned function is called
+	Subscribe(topic string, handler func(data []byte)) (unsubscribe func() error, err error)
+}
+
+// TopicFunc returns the topic of author's feed
+type TopicFunc func(author refs.FeedRef) string
+
+// DefaultTopic returns topics of the form prefix + hex encoded public key.
+// Hex keeps the topic free of separators and wildcards of the common brokers.
+func DefaultTopic(prefix string) TopicFunc {
+	return func(author refs.FeedRef) string {
+		return prefix + hex.EncodeToString(author.PubKey())
+	}
+}
+
+// Bridge publishes transfers to and follows feeds on a broker
+type Bridge struct {
+	broker Broker
+	v      *gabbygrove.Validator
+	sink   func(*gabbygrove.Transfer) error
+
+	topic   TopicFunc
+	onError func(author refs.FeedRef, err error)
+
+	mu   sync.Mutex
+	subs map[string]func() error
+}
+
+// New bridges to broker. Inbound transfers are validated with v and the appended ones passed to sink, i.e. store.Store.Append.
+// Topics default to DefaultTopic("gabbygrove.feed.").
+func New(broker Broker, v *gabbygrove.Validator, sink func(*gabbygrove.Transfer) error) *Bridge {
+	return &Bridge{
+		broker:  broker,
+		v:       v,
+		sink:    sink,
+		topic:   DefaultTopic("gabbygrove.feed."),
+		onError: func(refs.FeedRef, error) {},
+		subs:    make(map[string]func() error),
+	}
+}
+
+// WithTopic changes how feeds map to topics. It needs to be set before the first feed is followed.
+func (b *Bridge) WithTopic(fn TopicFunc) {
+	b.topic = fn
+}
+
+// WithErrorHandler is called with inbound transfers that were rejected and errors of the sink.
+// The broker can't be told about them, by default they are dropped.
+func (b *Bridge) WithErrorHandler(fn func(author refs.FeedRef, err error)) {
+	b.onError = fn
+}
+
+// Publish sends tr to the topic of its author, it should already be validated
+func (b *Bridge) Publish(tr *gabbygrove.Transfer) error {
+	data, err := tr.MarshalCBOR()
+	if err != nil {
+		return errors.Wrap(err, "bridge: failed to encode transfer")
+	}
+	if err := b.broker.Publish(b.topic(tr.Author()), data); err != nil {
+		return errors.Wrap(err, "bridge: failed to publish")
+	}
+	return nil
+}
+
+// Follow subscribes to the topic of author. Following a feed twice is a no-op.
+func (b *Bridge) Follow(author refs.FeedRef) error {
+	topic := b.topic(author)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, has := b.subs[topic]; has {
+		return nil
+	}
+	unsub, err := b.broker.Subscribe(topic, func(data []byte) {
+		if err := b.receive(author, data); err != nil {
+			b.onError(author, err)
+		}
+	})
+	if err != nil {
+		return errors.Wrapf(err, "bridge: failed to subscribe to %s", topic)
+	}
+	b.subs[topic] = unsub
+	return nil
+}
+
+// Unfollow ends the subscription to the topic of author
+func (b *Bridge) Unfollow(author refs.FeedRef) error {
+	topic := b.topic(author)
+
+	b.mu.Lock()
+	unsub, has := b.subs[topic]
+	delete(b.subs, topic)
+	b.mu.Unlock()
+	if !has {
+		return nil
+	}
+	return unsub()
+}
+
+// Close ends all subscriptions
+func (b *Bridge) Close() error {
+	b.mu.Lock()
+	subs := b.subs
+	b.subs = make(map[string]func() error)
+	b.mu.Unlock()
+
+	var firstErr error
+	for _, unsub := range subs {
+		if err := unsub(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// receive validates an inbound transfer of the topic of author.
+// Messages of other authors on the topic are rejected, whoever can publish to it can't inject them.
+func (b *Bridge) receive(author refs.FeedRef, data []byte) error {
+	var tr gabbygrove.Transfer
+	if err := tr.UnmarshalCBOR(data); err != nil {
+		return err
+	}
+	if err := tr.Validate(gabbygrove.DefaultLimits); err != nil {
+		return err
+	}
+	evt, err := tr.DecodedEvent()
+	if err != nil {
+		return err
+	}
+	if !evt.AuthorFeedRef().Equal(author) {
+		return errors.Wrapf(gabbygrove.ErrWrongAuthor, "bridge: message of %s on the topic of %s", evt.AuthorFeedRef().ShortSigil(), author.ShortSigil())
+	}
+	if tr.HasContent() && !tr.ContentMatches(tr.Content) {
+		return gabbygrove.ErrContentHash
+	}
+
+	appended, err := b.v.Append(&tr)
+	if err != nil {
+		return err
+	}
+	for _, a := range appended {
+		if err := b.sink(a); err != nil {
+			return errors.Wrap(err, "bridge: sink failed")
+		}
+	}
+	return nil
+}
+
+// NewMemBroker returns a broker that delivers in process and synchronously, mostly useful for testing
+func NewMemBroker() Broker {
+	return &memBroker{subs: make(map[string]map[int]func([]byte))}
+}
+
+type memBroker struct {
+	mu   sync.Mutex
+	next int
+	subs map[string]map[int]func([]byte)
+}
+
+func (mb *memBroker) Publish(topic string, data []byte) error {
+	mb.mu.Lock()
+	var handlers []func([]byte)
+	for _, h := range mb.subs[topic] {
+		handlers = append(handlers, h)
+	}
+	mb.mu.Unlock()
+	for _, h := range handlers {
+		h(append([]byte(nil), data...))
+	}
+	return nil
+}
+
+func (mb *memBroker) Subscribe(topic string, handler func([]byte)) (func() error, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if mb.subs[topic] == nil {
+		mb.subs[topic] = make(map[int]func([]byte))
+	}
+	id := mb.next
+	mb.next++
+	mb.subs[topic][id] = handler
+	return func() error {
+		mb.mu.Lock()
+		defer mb.mu.Unlock()
+		delete(mb.subs[topic], id)
+		return nil
+	}, nil
+}
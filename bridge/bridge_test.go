@@ -0,0 +1,102 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package bridge
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+)
+
+func makeFeed(t *testing.T, seed string, n int) (refs.FeedRef, []*gabbygrove.Transfer) {
+	r := require.New(t)
+
+	pub, priv, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte(seed), 32/len(seed))))
+	r.NoError(err)
+	author, err := refs.NewFeedRefFromBytes(pub, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+
+	e := gabbygrove.NewEncoder(priv)
+	state := gabbygrove.NewFeedState(author)
+	var trs []*gabbygrove.Transfer
+	for i := 0; i < n; i++ {
+		seq, prev := state.Next()
+		tr, _, err := e.Encode(seq, prev, map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+		r.NoError(state.Append(tr))
+		trs = append(trs, tr)
+	}
+	return author, trs
+}
+
+func TestBridge(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	broker := NewMemBroker()
+	alice, aliceTrs := makeFeed(t, "dead", 3)
+	bob, bobTrs := makeFeed(t, "beef", 1)
+
+	// the publishing side
+	pub := New(broker, gabbygrove.NewValidator(0), func(*gabbygrove.Transfer) error { return nil })
+
+	// the following side
+	var got []*gabbygrove.Transfer
+	var rejected []error
+	follower := New(broker, gabbygrove.NewValidator(4), func(tr *gabbygrove.Transfer) error {
+		got = append(got, tr)
+		return nil
+	})
+	follower.WithErrorHandler(func(author refs.FeedRef, err error) {
+		a.True(author.Equal(alice))
+		rejected = append(rejected, err)
+	})
+	r.NoError(follower.Follow(alice))
+	r.NoError(follower.Follow(alice), "twice")
+
+	r.NoError(pub.Publish(aliceTrs[0]))
+	r.NoError(pub.Publish(bobTrs[0]), "not followed")
+	r.NoError(pub.Publish(aliceTrs[2]))
+	a.Len(got, 1, "out of order is buffered")
+	r.NoError(pub.Publish(aliceTrs[1]))
+	r.Len(got, 3)
+	for i, tr := range got {
+		a.EqualValues(i+1, tr.Seq())
+	}
+	a.Len(rejected, 0)
+
+	// replays are ignored, garbage and foreign messages are rejected
+	r.NoError(pub.Publish(aliceTrs[1]))
+	a.Len(got, 3)
+	topic := DefaultTopic("gabbygrove.feed.")(alice)
+	r.NoError(broker.Publish(topic, []byte("garbage")))
+	b, err := bobTrs[0].MarshalCBOR()
+	r.NoError(err)
+	r.NoError(broker.Publish(topic, b))
+	r.Len(rejected, 2)
+	a.Equal(gabbygrove.ErrWrongAuthor, errors.Cause(rejected[1]))
+
+	r.NoError(follower.Unfollow(alice))
+	_, more := makeFeed(t, "dead", 4)
+	r.NoError(pub.Publish(more[3]))
+	a.Len(got, 3, "unfollowed")
+
+	r.NoError(follower.Follow(bob))
+	r.NoError(follower.Close())
+	r.NoError(pub.Publish(bobTrs[0]))
+	a.Len(got, 3, "closed")
+}
+
+func TestDefaultTopic(t *testing.T) {
+	author, _ := makeFeed(t, "dead", 0)
+	assert.Equal(t, "feeds/"+hex.EncodeToString(author.PubKey()), DefaultTopic("feeds/")(author))
+}
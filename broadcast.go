@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"context"
+	"sync"
+
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// Broadcaster passes transfers on to subscribers over channels, see Validator.Subscribe.
+// The zero value is ready to use and it's safe for concurrent use.
+//
+// Broadcast never blocks: a subscriber that falls more than its buffer behind is dropped
+// and its channel closed, it needs to catch up from a store and subscribe again.
+type Broadcaster struct {
+	mu   sync.Mutex
+	subs map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	ch     chan *Transfer
+	author *refs.FeedRef
+	done   chan struct{}
+}
+
+// Subscribe returns a channel of the transfers of author, or of all authors if it's nil.
+// The channel holds up to buffer transfers and is closed when ctx is done or the subscriber fell behind.
+func (b *Broadcaster) Subscribe(ctx context.Context, author *refs.FeedRef, buffer int) <-chan *Transfer {
+	s := &subscriber{
+		ch:   make(chan *Transfer, buffer),
+		done: make(chan struct{}),
+	}
+	if author != nil {
+		a := *author
+		s.author = &a
+	}
+
+	b.mu.Lock()
+	if b.subs == nil {
+		b.subs = make(map[*subscriber]struct{})
+	}
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			b.mu.Lock()
+			b.drop(s)
+			b.mu.Unlock()
+		case <-s.done:
+		}
+	}()
+	return s.ch
+}
+
+// Broadcast passes tr to the subscribers of its author and those of all authors
+func (b *Broadcaster) Broadcast(tr *Transfer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.subs) == 0 {
+		return
+	}
+	author := tr.Author()
+	for s := range b.subs {
+		if s.author != nil && !s.author.Equal(author) {
+			continue
+		}
+		select {
+		case s.ch <- tr:
+		default:
+			debugLog("event", "broadcast", "author", author.URI(), "note", "dropped slow subscriber")
+			b.drop(s)
+		}
+	}
+}
+
+// Subscribers returns the number of active subscriptions
+func (b *Broadcaster) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+// drop ends the subscription of s, b.mu needs to be held
+func (b *Broadcaster) drop(s *subscriber) {
+	if _, has := b.subs[s]; !has {
+		return
+	}
+	delete(b.subs, s)
+	close(s.ch)
+	close(s.done)
+}
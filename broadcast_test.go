@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatorSubscribe(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice, aliceTrs := makeTestFeed(t, "dead", 3)
+	_, bobTrs := makeTestFeed(t, "beef", 2)
+
+	v := NewValidator(10)
+	all := v.Subscribe(ctx, nil, 10)
+	onlyAlice := v.Subscribe(ctx, &alice, 10)
+	slow := v.Subscribe(ctx, nil, 1)
+	a.Equal(3, v.subs.Subscribers())
+
+	// out of order, the subscribers only see them once they are appended
+	_, err := v.Append(aliceTrs[1])
+	r.NoError(err)
+	a.Len(all, 0)
+	for _, tr := range []*Transfer{aliceTrs[0], bobTrs[0], aliceTrs[2], bobTrs[1]} {
+		_, err := v.Append(tr)
+		r.NoError(err)
+	}
+
+	for i, want := range []*Transfer{aliceTrs[0], aliceTrs[1], bobTrs[0], aliceTrs[2], bobTrs[1]} {
+		got := <-all
+		a.Equal(want.Key(), got.Key(), "all: %d", i)
+	}
+	for i, want := range aliceTrs {
+		got := <-onlyAlice
+		a.Equal(want.Key(), got.Key(), "alice: %d", i)
+	}
+
+	// the slow one was dropped after the first message
+	got, ok := <-slow
+	r.True(ok)
+	a.Equal(aliceTrs[0].Key(), got.Key())
+	_, ok = <-slow
+	a.False(ok)
+	a.Equal(2, v.subs.Subscribers())
+
+	cancel()
+	_, ok = <-all
+	a.False(ok)
+	_, ok = <-onlyAlice
+	a.False(ok)
+	a.Equal(0, v.subs.Subscribers())
+}
+
+func TestBroadcasterZeroValue(t *testing.T) {
+	a := assert.New(t)
+
+	_, trs := makeTestFeed(t, "dead", 1)
+
+	var b Broadcaster
+	b.Broadcast(trs[0])
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := b.Subscribe(ctx, nil, 0)
+	// unbuffered and nobody is reading
+	b.Broadcast(trs[0])
+	_, ok := <-ch
+	a.False(ok)
+
+	// canceling after being dropped is fine
+	cancel()
+	a.Equal(0, b.Subscribers())
+}
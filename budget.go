@@ -0,0 +1,46 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import "github.com/pkg/errors"
+
+// ErrAllocationBudget is returned by a Decoder if decoding would allocate more than its budget
+var ErrAllocationBudget = errors.New("gabbygrove: decode allocation budget exceeded")
+
+// WithMaxAllocation limits the bytes a single Decode, DecodeFrom or DecodeEvent call may allocate.
+// The tracking is approximate, it counts the buffers for the read data, the copies of the fields and the decoded references.
+// Zero or less means no limit, which is the default.
+func (d *Decoder) WithMaxAllocation(bytes int) {
+	d.maxAlloc = bytes
+}
+
+func (d *Decoder) budget() *allocBudget {
+	if d.maxAlloc <= 0 {
+		return nil
+	}
+	return &allocBudget{left: d.maxAlloc}
+}
+
+// allocBudget tracks the allocations of one decode call, nil is unlimited
+type allocBudget struct {
+	left int
+}
+
+func (b *allocBudget) take(n int) error {
+	if b == nil {
+		return nil
+	}
+	if n > b.left {
+		return ErrAllocationBudget
+	}
+	b.left -= n
+	return nil
+}
+
+// decodeOptions are passed down from the Decoder, the zero value is what UnmarshalCBOR does
+type decodeOptions struct {
+	rejectUnknown bool
+	budget        *allocBudget
+}
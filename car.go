@@ -0,0 +1,127 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// multicodec codes used for the blocks of an exported feed
+const (
+	multicodecCBOR    = 0x51
+	multicodecDagCBOR = 0x71
+	multicodecRaw     = 0x55
+)
+
+// ExportCAR writes the transfers of a feed as a CARv1 archive, so it can be imported into IPFS.
+// Each transfer becomes a CBOR block and each available content a raw block,
+// which has the same sha256 digest as the content hash in the event.
+// The single root is a DAG-CBOR block which names the author and links all transfers in order.
+func ExportCAR(w io.Writer, author refs.FeedRef, trs []*Transfer) error {
+	var transferBlocks, transferCIDs [][]byte
+	for _, tr := range trs {
+		data, err := tr.MarshalCBOR()
+		if err != nil {
+			return errors.Wrap(err, "gabbygrove/car: failed to encode transfer")
+		}
+		transferBlocks = append(transferBlocks, data)
+		transferCIDs = append(transferCIDs, blockCID(multicodecCBOR, data))
+	}
+
+	root := appendCBORHead(nil, cborMajorMap, 2)
+	root = appendCBORText(root, "author")
+	root = appendCBORText(root, author.URI())
+	root = appendCBORText(root, "messages")
+	root = appendCBORHead(root, cborMajorArray, uint64(len(transferCIDs)))
+	for _, c := range transferCIDs {
+		root = appendCBORLink(root, c)
+	}
+	rootCID := blockCID(multicodecDagCBOR, root)
+
+	header := appendCBORHead(nil, cborMajorMap, 2)
+	header = appendCBORText(header, "roots")
+	header = appendCBORHead(header, cborMajorArray, 1)
+	header = appendCBORLink(header, rootCID)
+	header = appendCBORText(header, "version")
+	header = appendCBORHead(header, cborMajorUint, 1)
+
+	bw := bufio.NewWriter(w)
+	if err := writeCARSection(bw, header); err != nil {
+		return err
+	}
+	if err := writeCARSection(bw, rootCID, root); err != nil {
+		return err
+	}
+
+	written := make(map[ContentRef]struct{})
+	for i, tr := range trs {
+		if err := writeCARSection(bw, transferCIDs[i], transferBlocks[i]); err != nil {
+			return err
+		}
+
+		// the content might have been dropped
+		evt, err := tr.getEvent()
+		if err != nil {
+			return errors.Wrap(err, "gabbygrove/car: invalid event")
+		}
+		if !tr.HasContent() || len(tr.Content) != int(evt.Content.Size) {
+			continue
+		}
+		contentHash := sum256(tr.Content)
+		cr, _ := NewContentRefFromBytes(contentHash[:])
+		if _, done := written[cr]; done {
+			continue
+		}
+		if err := writeCARSection(bw, blockCID(multicodecRaw, tr.Content), tr.Content); err != nil {
+			return err
+		}
+		written[cr] = struct{}{}
+	}
+	return bw.Flush()
+}
+
+// writeCARSection writes the varint length prefix followed by all parts
+func writeCARSection(w io.Writer, parts ...[]byte) error {
+	var n int
+	for _, p := range parts {
+		n += len(p)
+	}
+	var vbuf [binary.MaxVarintLen64]byte
+	if _, err := w.Write(vbuf[:binary.PutUvarint(vbuf[:], uint64(n))]); err != nil {
+		return errors.Wrap(err, "gabbygrove/car: write failed")
+	}
+	for _, p := range parts {
+		if _, err := w.Write(p); err != nil {
+			return errors.Wrap(err, "gabbygrove/car: write failed")
+		}
+	}
+	return nil
+}
+
+// blockCID returns the binary CIDv1 of data with a sha2-256 multihash
+func blockCID(codec uint64, data []byte) []byte {
+	digest := sum256(data)
+	var vbuf [binary.MaxVarintLen64]byte
+	cid := append([]byte{}, vbuf[:binary.PutUvarint(vbuf[:], 1)]...)
+	cid = append(cid, vbuf[:binary.PutUvarint(vbuf[:], codec)]...)
+	cid = append(cid, multihashSHA256, multihashSHA256Len)
+	return append(cid, digest[:]...)
+}
+
+// the tag for CIDs in DAG-CBOR
+const cborTagCID = 42
+
+// appendCBORLink adds a DAG-CBOR link, a tagged byte string with the identity multibase prefix
+func appendCBORLink(dst []byte, cid []byte) []byte {
+	dst = appendCBORHead(dst, cborMajorTag, cborTagCID)
+	dst = appendCBORHead(dst, cborMajorBytes, uint64(len(cid)+1))
+	dst = append(dst, 0x00)
+	return append(dst, cid...)
+}
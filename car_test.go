@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+//go:build !tinygo
+// +build !tinygo
+
+package gabbygrove
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ugorji/go/codec"
+)
+
+func TestExportCAR(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	author, trs := makeTestFeed(t, "dead", 3)
+	// the same content twice should only be one block
+	trs[2].Content = trs[1].Content
+	// dropped content
+	trs[0].Content = nil
+
+	var buf bytes.Buffer
+	r.NoError(ExportCAR(&buf, author, trs))
+
+	rd := bufio.NewReader(&buf)
+	readSection := func() []byte {
+		n, err := binary.ReadUvarint(rd)
+		r.NoError(err)
+		sec := make([]byte, n)
+		_, err = io.ReadFull(rd, sec)
+		r.NoError(err)
+		return sec
+	}
+
+	var header struct {
+		Roots   []interface{} `codec:"roots"`
+		Version int           `codec:"version"`
+	}
+	r.NoError(codec.NewDecoderBytes(readSection(), new(codec.CborHandle)).Decode(&header))
+	a.Equal(1, header.Version)
+	a.Len(header.Roots, 1)
+
+	var blocks int
+	for {
+		if _, err := rd.Peek(1); err == io.EOF {
+			break
+		}
+		sec := readSection()
+		// the CIDs have a fixed size: version, codec, sha256 multihash
+		cid, data := sec[:36], sec[36:]
+		digest := sha256.Sum256(data)
+		a.Equal(digest[:], cid[4:], "block %d has wrong hash", blocks)
+		blocks++
+	}
+	// root, 3 transfers, 1 content
+	a.Equal(5, blocks)
+}
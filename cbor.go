@@ -0,0 +1,620 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// The core types are encoded and decoded by hand, without reflection.
+// This way encoding and verifying doesn't depend on the codec package (and builds with TinyGo),
+// the output is the same as the one of NewCBORHandle.
+
+// On top of the size limits, these caps bound the work a crafted input can cause.
+// Unknown fields can't nest deeper than cborMaxDepth, tags can't be applied to tags
+// and the only tag in the known fields is the cypherlink.
+const (
+	// cborMaxDepth bounds the nesting of unknown fields
+	cborMaxDepth = 16
+
+	// cborMaxItems bounds the number of items in one event or transfer
+	cborMaxItems = 1024
+
+	// cborMaxUnknownFields bounds the number of elements after the known ones
+	cborMaxUnknownFields = 16
+)
+
+// CBOR major types
+const (
+	cborMajorUint   byte = 0
+	cborMajorNegInt byte = 1
+	cborMajorBytes  byte = 2
+	cborMajorText   byte = 3
+	cborMajorArray  byte = 4
+	cborMajorMap    byte = 5
+	cborMajorTag    byte = 6
+	cborMajorSimple byte = 7
+)
+
+func appendCBORHead(dst []byte, major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return append(dst, major|byte(n))
+	case n <= 0xff:
+		return append(dst, major|24, byte(n))
+	case n <= 0xffff:
+		return append(dst, major|25, byte(n>>8), byte(n))
+	case n <= 0xffffffff:
+		return append(dst, major|26, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	default:
+		dst = append(dst, major|27)
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], n)
+		return append(dst, b[:]...)
+	}
+}
+
+func appendCBORText(dst []byte, s string) []byte {
+	dst = appendCBORHead(dst, cborMajorText, uint64(len(s)))
+	return append(dst, s...)
+}
+
+// cborNull is how nil byte slices and references are encoded
+const cborNull = 0xf6
+
+func appendCBORBytes(dst, b []byte) []byte {
+	if b == nil {
+		return append(dst, cborNull)
+	}
+	dst = appendCBORHead(dst, cborMajorBytes, uint64(len(b)))
+	return append(dst, b...)
+}
+
+func appendCBORInt(dst []byte, v int64) []byte {
+	if v < 0 {
+		return appendCBORHead(dst, cborMajorNegInt, uint64(-1-v))
+	}
+	return appendCBORHead(dst, cborMajorUint, uint64(v))
+}
+
+// appendCBORRef adds ref as a tagged cypherlink, nil as null
+func appendCBORRef(dst []byte, ref *BinaryRef) ([]byte, error) {
+	if ref == nil {
+		return append(dst, cborNull), nil
+	}
+	b, err := ref.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	dst = appendCBORHead(dst, cborMajorTag, CypherLinkCBORTag)
+	return appendCBORBytes(dst, b), nil
+}
+
+func (evt *Event) appendCBOR(dst []byte) ([]byte, error) {
+	n := eventFieldCount + len(evt.unknown)
+	if len(evt.Extensions) > 0 {
+		n++
+	}
+	dst = appendCBORHead(dst, cborMajorArray, uint64(n))
+
+	var err error
+	if dst, err = appendCBORRef(dst, evt.Previous); err != nil {
+		return nil, errors.Wrap(err, "previous")
+	}
+	if dst, err = appendCBORRef(dst, &evt.Author); err != nil {
+		return nil, errors.Wrap(err, "author")
+	}
+	dst = appendCBORHead(dst, cborMajorUint, evt.Sequence)
+	dst = appendCBORInt(dst, evt.Timestamp)
+
+	dst = appendCBORHead(dst, cborMajorArray, 3)
+	if dst, err = appendCBORRef(dst, &evt.Content.Hash); err != nil {
+		return nil, errors.Wrap(err, "content hash")
+	}
+	dst = appendCBORHead(dst, cborMajorUint, uint64(evt.Content.Size))
+	dst = appendCBORHead(dst, cborMajorUint, uint64(evt.Content.Type))
+
+	if len(evt.Extensions) > 0 {
+		extStart := len(dst)
+		dst = evt.Extensions.appendCBOR(dst)
+		if n := len(dst) - extStart; n > maxExtensionsSize {
+			return nil, errors.Errorf("gabbygrove/extensions: too large (%d bytes)", n)
+		}
+	}
+	for _, u := range evt.unknown {
+		dst = append(dst, u...)
+	}
+	return dst, nil
+}
+
+// appendCBOR adds the map with sorted keys
+func (ext Extensions) appendCBOR(dst []byte) []byte {
+	keys := make([]string, 0, len(ext))
+	for k := range ext {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	dst = appendCBORHead(dst, cborMajorMap, uint64(len(keys)))
+	for _, k := range keys {
+		dst = appendCBORText(dst, k)
+		dst = appendCBORBytes(dst, ext[k])
+	}
+	return dst
+}
+
+func (tr *Transfer) appendCBOR(dst []byte) []byte {
+	dst = appendCBORHead(dst, cborMajorArray, uint64(transferFieldCount+len(tr.unknown)))
+	dst = appendCBORBytes(dst, tr.Event)
+	dst = appendCBORBytes(dst, tr.Signature)
+	dst = appendCBORBytes(dst, tr.Content)
+	for _, u := range tr.unknown {
+		dst = append(dst, u...)
+	}
+	return dst
+}
+
+// cborParser reads the items at the start of data
+type cborParser struct {
+	data []byte
+	off  int
+
+	items  int
+	budget *allocBudget
+}
+
+var errCBORShort = errors.New("unexpected end of cbor data")
+
+// head decodes the head of the next item, only definite lengths are supported
+func (p *cborParser) head() (byte, uint64, error) {
+	if p.off >= len(p.data) {
+		return 0, 0, errCBORShort
+	}
+	if p.items++; p.items > cborMaxItems {
+		return 0, 0, errors.Errorf("more than %d cbor items", cborMaxItems)
+	}
+	b := p.data[p.off]
+	major, info := b>>5, b&0x1f
+	p.off++
+
+	var size int
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, 0, errors.Errorf("unsupported cbor head (%x)", b)
+	}
+	if p.off+size > len(p.data) {
+		return 0, 0, errCBORShort
+	}
+	var arg uint64
+	for _, b := range p.data[p.off : p.off+size] {
+		arg = arg<<8 | uint64(b)
+	}
+	p.off += size
+	return major, arg, nil
+}
+
+func (p *cborParser) expect(major byte) (uint64, error) {
+	got, arg, err := p.head()
+	if err != nil {
+		return 0, err
+	}
+	if got != major || (major == cborMajorSimple && arg >= 24) {
+		return 0, errors.Errorf("expected cbor major type %d (got %d)", major, got)
+	}
+	return arg, nil
+}
+
+func (p *cborParser) null() bool {
+	if p.off < len(p.data) && p.data[p.off] == cborNull {
+		p.off++
+		return true
+	}
+	return false
+}
+
+func (p *cborParser) length(major byte) (int, error) {
+	n, err := p.expect(major)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(p.data)-p.off) {
+		return 0, errCBORShort
+	}
+	return int(n), nil
+}
+
+// bytes returns the next byte string, nil for null. It points into data.
+func (p *cborParser) bytes() ([]byte, error) {
+	if p.null() {
+		return nil, nil
+	}
+	n, err := p.length(cborMajorBytes)
+	if err != nil {
+		return nil, err
+	}
+	b := p.data[p.off : p.off+n : p.off+n]
+	p.off += n
+	return b, nil
+}
+
+func (p *cborParser) text() (string, error) {
+	n, err := p.length(cborMajorText)
+	if err != nil {
+		return "", err
+	}
+	if err := p.budget.take(n); err != nil {
+		return "", err
+	}
+	s := string(p.data[p.off : p.off+n])
+	p.off += n
+	return s, nil
+}
+
+func (p *cborParser) uint() (uint64, error) {
+	return p.expect(cborMajorUint)
+}
+
+func (p *cborParser) int() (int64, error) {
+	major, arg, err := p.head()
+	if err != nil {
+		return 0, err
+	}
+	if arg > math.MaxInt64 {
+		return 0, errors.Errorf("cbor integer overflows int64")
+	}
+	switch major {
+	case cborMajorUint:
+		return int64(arg), nil
+	case cborMajorNegInt:
+		return -1 - int64(arg), nil
+	default:
+		return 0, errors.Errorf("expected a cbor integer (got major type %d)", major)
+	}
+}
+
+// ref returns the next tagged cypherlink, nil for null
+func (p *cborParser) ref() (*BinaryRef, error) {
+	if p.null() {
+		return nil, nil
+	}
+	tag, err := p.expect(cborMajorTag)
+	if err != nil {
+		return nil, err
+	}
+	if tag != CypherLinkCBORTag {
+		return nil, errors.Errorf("expected cypherlink tag (got %d)", tag)
+	}
+	b, err := p.bytes()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.budget.take(binrefSize); err != nil {
+		return nil, err
+	}
+	var ref BinaryRef
+	if err := ref.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return &ref, nil
+}
+
+// raw returns a copy of the next item, whatever it is
+func (p *cborParser) raw() ([]byte, error) {
+	start := p.off
+	if err := p.skip(0); err != nil {
+		return nil, err
+	}
+	if err := p.budget.take(p.off - start); err != nil {
+		return nil, err
+	}
+	return append([]byte{}, p.data[start:p.off]...), nil
+}
+
+func (p *cborParser) skip(depth int) error {
+	if depth > cborMaxDepth {
+		return errors.Errorf("cbor nested too deep")
+	}
+	major, arg, err := p.head()
+	if err != nil {
+		return err
+	}
+	switch major {
+	case cborMajorBytes, cborMajorText:
+		if arg > uint64(len(p.data)-p.off) {
+			return errCBORShort
+		}
+		p.off += int(arg)
+	case cborMajorArray, cborMajorMap:
+		if arg > uint64(len(p.data)-p.off) {
+			return errCBORShort
+		}
+		if major == cborMajorMap {
+			arg *= 2
+		}
+		for i := uint64(0); i < arg; i++ {
+			if err := p.skip(depth + 1); err != nil {
+				return err
+			}
+		}
+	case cborMajorTag:
+		if p.off < len(p.data) && p.data[p.off]>>5 == cborMajorTag {
+			return errors.Errorf("nested cbor tags")
+		}
+		return p.skip(depth + 1)
+	}
+	return nil
+}
+
+// parseCBOR decodes the event at the start of data and returns its length
+func (evt *Event) parseCBOR(data []byte, opts decodeOptions) (int, error) {
+	p := cborParser{data: data, budget: opts.budget}
+	n, err := p.expect(cborMajorArray)
+	if err != nil {
+		return 0, err
+	}
+	if n < eventFieldCount {
+		return 0, errors.Errorf("event with %d elements", n)
+	}
+
+	var newEvt Event
+	if newEvt.Previous, err = p.ref(); err != nil {
+		return 0, errors.Wrap(err, "previous")
+	}
+	author, err := p.ref()
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid author")
+	}
+	if author == nil {
+		return 0, errors.Errorf("invalid author: null")
+	}
+	newEvt.Author = *author
+	if newEvt.Sequence, err = p.uint(); err != nil {
+		return 0, errors.Wrap(err, "sequence")
+	}
+	if newEvt.Timestamp, err = p.int(); err != nil {
+		return 0, errors.Wrap(err, "timestamp")
+	}
+
+	if cn, err := p.expect(cborMajorArray); err != nil || cn != 3 {
+		return 0, errors.Errorf("invalid content info")
+	}
+	hash, err := p.ref()
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid content hash")
+	}
+	if hash == nil {
+		return 0, errors.Errorf("invalid content hash: null")
+	}
+	newEvt.Content.Hash = *hash
+	size, err := p.uint()
+	if err != nil || size > math.MaxUint16 {
+		return 0, errors.Errorf("invalid content size")
+	}
+	newEvt.Content.Size = uint16(size)
+	ctype, err := p.uint()
+	if err != nil || ctype > math.MaxUint32 {
+		return 0, errors.Errorf("invalid content type")
+	}
+	newEvt.Content.Type = ContentType(ctype)
+
+	extra := n - eventFieldCount
+	if extra > 0 && p.off < len(data) && data[p.off]>>5 == cborMajorMap {
+		start := p.off
+		if err := p.skip(0); err != nil {
+			return 0, err
+		}
+		if err := p.budget.take(p.off - start); err != nil {
+			return 0, err
+		}
+		if newEvt.Extensions, err = decodeExtensions(data[start:p.off]); err != nil {
+			return 0, err
+		}
+		extra--
+	}
+	if extra > 0 && opts.rejectUnknown {
+		return 0, errors.Wrapf(ErrUnknownFields, "%d unknown elements", extra)
+	}
+	if extra > cborMaxUnknownFields {
+		return 0, errors.Errorf("%d unknown elements (more than %d)", extra, cborMaxUnknownFields)
+	}
+	for i := uint64(0); i < extra; i++ {
+		raw, err := p.raw()
+		if err != nil {
+			return 0, errors.Wrap(err, "unknown field")
+		}
+		newEvt.unknown = append(newEvt.unknown, raw)
+	}
+
+	*evt = newEvt
+	return p.off, nil
+}
+
+// parseExtensions decodes a map of text keys to byte strings
+func parseExtensions(data []byte) (Extensions, error) {
+	p := cborParser{data: data}
+	n, err := p.expect(cborMajorMap)
+	if err != nil {
+		return nil, err
+	}
+	ext := make(Extensions)
+	for i := uint64(0); i < n; i++ {
+		k, err := p.text()
+		if err != nil {
+			return nil, errors.Wrap(err, "key")
+		}
+		if _, has := ext[k]; has {
+			return nil, errors.Errorf("duplicate key %q", k)
+		}
+		v, err := p.bytes()
+		if err != nil {
+			return nil, errors.Wrapf(err, "value of %q", k)
+		}
+		if v != nil {
+			v = append([]byte{}, v...)
+		}
+		ext[k] = v
+	}
+	if p.off != len(data) {
+		return nil, errors.Errorf("trailing bytes")
+	}
+	return ext, nil
+}
+
+// parseCBOR decodes the transfer at the start of data and returns its length.
+// The fields point into data.
+func (tr *Transfer) parseCBOR(data []byte, opts decodeOptions) (int, error) {
+	p := cborParser{data: data, budget: opts.budget}
+	n, err := p.expect(cborMajorArray)
+	if err != nil {
+		return 0, err
+	}
+	if n < transferFieldCount {
+		return 0, errors.Errorf("transfer with %d elements", n)
+	}
+
+	var newTr Transfer
+	for _, f := range []*[]byte{&newTr.Event, &newTr.Signature, &newTr.Content} {
+		if *f, err = p.bytes(); err != nil {
+			return 0, err
+		}
+	}
+
+	extra := n - transferFieldCount
+	if extra > 0 && opts.rejectUnknown {
+		return 0, errors.Wrapf(ErrUnknownFields, "%d unknown elements", extra)
+	}
+	if extra > cborMaxUnknownFields {
+		return 0, errors.Errorf("%d unknown elements (more than %d)", extra, cborMaxUnknownFields)
+	}
+	for i := uint64(0); i < extra; i++ {
+		raw, err := p.raw()
+		if err != nil {
+			return 0, errors.Wrap(err, "unknown field")
+		}
+		newTr.unknown = append(newTr.unknown, raw)
+	}
+	newTr.rejectUnknown = opts.rejectUnknown
+
+	*tr = newTr
+	return p.off, nil
+}
+
+// readCBORItem reads exactly one item from r, but no more than max bytes.
+// It returns io.EOF if r ended before the item started.
+func readCBORItem(r io.Reader, max int, budget *allocBudget) ([]byte, error) {
+	ir := itemReader{r: r, max: max, budget: budget}
+	err := ir.item(0, false)
+	if err == io.EOF && len(ir.buf) > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return ir.buf, err
+}
+
+type itemReader struct {
+	r   io.Reader
+	max int
+	buf []byte
+
+	items  int
+	budget *allocBudget
+}
+
+func (ir *itemReader) read(n uint64) ([]byte, error) {
+	if n > uint64(ir.max-len(ir.buf)) {
+		return nil, errors.Errorf("cbor item larger then %d bytes", ir.max)
+	}
+	if err := ir.budget.take(int(n)); err != nil {
+		return nil, err
+	}
+	start := len(ir.buf)
+	ir.buf = append(ir.buf, make([]byte, n)...)
+	if _, err := io.ReadFull(ir.r, ir.buf[start:]); err != nil {
+		ir.buf = ir.buf[:start]
+		if err == io.ErrUnexpectedEOF || (err == io.EOF && start > 0) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return ir.buf[start:], nil
+}
+
+func (ir *itemReader) item(depth int, tagged bool) error {
+	if depth > cborMaxDepth {
+		return errors.Errorf("cbor nested too deep")
+	}
+	if ir.items++; ir.items > cborMaxItems {
+		return errors.Errorf("more than %d cbor items", cborMaxItems)
+	}
+	b, err := ir.read(1)
+	if err != nil {
+		return err
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+
+	arg := uint64(info)
+	if info >= 24 {
+		var size uint64
+		switch info {
+		case 24:
+			size = 1
+		case 25:
+			size = 2
+		case 26:
+			size = 4
+		case 27:
+			size = 8
+		default:
+			return errors.Errorf("unsupported cbor head (%x)", b[0])
+		}
+		argBytes, err := ir.read(size)
+		if err != nil {
+			return err
+		}
+		arg = 0
+		for _, b := range argBytes {
+			arg = arg<<8 | uint64(b)
+		}
+	}
+
+	switch major {
+	case cborMajorBytes, cborMajorText:
+		_, err := ir.read(arg)
+		return err
+	case cborMajorArray, cborMajorMap:
+		if arg > cborMaxItems {
+			return errors.Errorf("more than %d cbor items", cborMaxItems)
+		}
+		if major == cborMajorMap {
+			arg *= 2
+		}
+		for i := uint64(0); i < arg; i++ {
+			if err := ir.item(depth+1, false); err != nil {
+				return err
+			}
+		}
+	case cborMajorTag:
+		if tagged {
+			return errors.Errorf("nested cbor tags")
+		}
+		return ir.item(depth+1, true)
+	}
+	return nil
+}
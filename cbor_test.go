@@ -0,0 +1,176 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+//go:build !tinygo
+// +build !tinygo
+
+package gabbygrove
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ugorji/go/codec"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// the hand written encoding needs to match the one of the codec package byte for byte
+func TestCBORMatchesCodec(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	rnd := rand.New(rand.NewSource(42))
+	randHash := func() []byte {
+		b := make([]byte, 32)
+		rnd.Read(b)
+		return b
+	}
+
+	for i := 0; i < 200; i++ {
+		author, err := refs.NewFeedRefFromBytes(randHash(), refs.RefAlgoFeedGabby)
+		r.NoError(err)
+		cr, err := NewContentRefFromBytes(randHash())
+		r.NoError(err)
+
+		var evt Event
+		evt.Author, _ = fromRef(author)
+		evt.Content.Hash, _ = fromRef(cr)
+		evt.Content.Size = uint16(rnd.Intn(math.MaxUint16 + 1))
+		evt.Content.Type = ContentType(rnd.Intn(3))
+		switch i % 4 {
+		case 0:
+			evt.Sequence = 1
+		case 1:
+			evt.Sequence = uint64(rnd.Int63())
+		default:
+			evt.Sequence = uint64(rnd.Intn(1 << 20))
+		}
+		evt.Timestamp = rnd.Int63n(math.MaxInt64) - math.MaxInt64/2
+		if i%3 != 0 {
+			prev, err := refs.NewMessageRefFromBytes(randHash(), refs.RefAlgoMessageGabby)
+			r.NoError(err)
+			br, _ := fromRef(prev)
+			evt.Previous = &br
+		}
+
+		fields := []interface{}{evt.Previous, &evt.Author, evt.Sequence, evt.Timestamp, &evt.Content}
+		if i%5 == 0 {
+			evt.Extensions = Extensions{"region": []byte("eu"), "a": nil, "zz": randHash()[:3]}
+			fields = append(fields, map[string][]byte(evt.Extensions))
+		}
+
+		var want bytes.Buffer
+		r.NoError(codec.NewEncoder(&want, NewCBORHandle()).Encode(fields))
+
+		got, err := evt.MarshalCBOR()
+		r.NoError(err)
+		r.Equal(hex.EncodeToString(want.Bytes()), hex.EncodeToString(got), "event %d", i)
+
+		var decoded Event
+		r.NoError(decoded.UnmarshalCBOR(want.Bytes()))
+		reEncoded, err := decoded.MarshalCBOR()
+		r.NoError(err)
+		a.Equal(got, reEncoded)
+
+		tr := Transfer{Event: got, Signature: randHash(), Content: randHash()[:rnd.Intn(32)]}
+		if i%7 == 0 {
+			tr.Content = nil
+		}
+		var wantTr bytes.Buffer
+		r.NoError(codec.NewEncoder(&wantTr, NewCBORHandle()).Encode(tr))
+		gotTr, err := tr.MarshalCBOR()
+		r.NoError(err)
+		r.Equal(wantTr.Bytes(), gotTr, "transfer %d", i)
+
+		raw, err := readCBORItem(bytes.NewReader(append(gotTr, 0xff)), maxTransferSize, nil)
+		r.NoError(err)
+		a.Equal(gotTr, raw)
+	}
+}
+
+func TestCBORDecodeFixture(t *testing.T) {
+	r := require.New(t)
+
+	data, err := hex.DecodeString("85d9041a5821024226e0304155aeea683a98882ca5683579e1cdd5505597fb76498bf4c4973b98d9041a582101aed3dab65ce9e0d6c50d46fceffb552296ed21b6e0b537a6a0184575ce8f5cbd032283d9041a58210327d0b22f26328f03ffce2a7c66b2ee27e337ca5d28cdc89ead668f1dd7f0218b186901")
+	r.NoError(err)
+
+	var viaCodec Event
+	r.NoError(codec.NewDecoderBytes(data, NewCBORHandle()).Decode(&viaCodec))
+
+	var manual Event
+	r.NoError(manual.UnmarshalCBOR(data))
+	r.Equal(viaCodec, manual)
+}
+
+// the original decoding through the codec package, see TestEvtUnmarshalCBOR for the hand written one
+func TestEvtDecode(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	var input = "85d9041a5821024226e0304155aeea683a98882ca5683579e1cdd5505597fb76498bf4c4973b98d9041a582101aed3dab65ce9e0d6c50d46fceffb552296ed21b6e0b537a6a0184575ce8f5cbd032283d9041a58210327d0b22f26328f03ffce2a7c66b2ee27e337ca5d28cdc89ead668f1dd7f0218b186901"
+
+	data, err := hex.DecodeString(input)
+	r.NoError(err)
+	r.NotNil(data)
+
+	var evt Event
+	evtDec := codec.NewDecoder(bytes.NewReader(data), GetCBORHandle())
+	err = evtDec.Decode(&evt)
+	r.NoError(err, "decode failed")
+	a.NotNil(evt.Author)
+	a.NotNil(evt.Previous)
+	a.EqualValues("ssb:message/gabbygrove-v1/QibgMEFVrupoOpiILKVoNXnhzdVQVZf7dkmL9MSXO5g=", evt.Previous.URI())
+	a.EqualValues("ssb:content/gabbygrove-v1/J9CyLyYyjwP_zip8ZrLuJ-M3yl0ozcierWaPHdfwIYs=", evt.Content.Hash.URI())
+	a.Equal(uint64(3), evt.Sequence)
+	a.EqualValues(-3, evt.Timestamp)
+}
+
+func TestCBORMalformed(t *testing.T) {
+	a := assert.New(t)
+
+	_, trs := makeTestFeed(t, "dead", 1)
+	valid := trs[0].Event
+
+	for i := 0; i < len(valid); i++ {
+		var evt Event
+		a.Error(evt.UnmarshalCBOR(valid[:i]), "truncated at %d", i)
+	}
+
+	var evt Event
+	// indefinite length array
+	a.Error(evt.UnmarshalCBOR(append([]byte{0x9f}, valid[1:]...)))
+	// deeply nested unknown field
+	deep := append([]byte{0x86}, valid[1:]...)
+	deep = append(deep, bytes.Repeat([]byte{0x81}, cborMaxDepth+2)...)
+	deep = append(deep, 0x00)
+	dec := NewDecoder()
+	dec.WithUnknownFields(true)
+	_, err := dec.DecodeEvent(deep)
+	a.Error(err)
+}
+
+func TestNewCBORHandleIndependent(t *testing.T) {
+	a := assert.New(t)
+
+	m := map[string]int{"b": 2, "a": 1, "c": 3}
+	encode := func(h *codec.CborHandle) []byte {
+		var buf bytes.Buffer
+		require.NoError(t, codec.NewEncoder(&buf, h).Encode(m))
+		return buf.Bytes()
+	}
+	want := encode(NewCBORHandle())
+
+	changed := NewCBORHandle()
+	changed.Canonical = false
+	changed.IndefiniteLength = true
+	a.NotEqual(want, encode(changed))
+
+	a.Equal(want, encode(NewCBORHandle()), "changes leaked to new handles")
+	a.Equal(want, encode(GetCBORHandle()))
+}
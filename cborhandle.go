@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+//go:build !tinygo
+// +build !tinygo
+
+package gabbygrove
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/ugorji/go/codec"
+)
+
+// The codec package relies on reflection that TinyGo doesn't support.
+// The core types don't need it (see cbor.go), only the optional parts
+// like invites and checkpoints use it and are left out of TinyGo builds.
+
+// GetCBORHandle returns a new handle, see NewCBORHandle.
+//
+// Deprecated: the name suggests a shared handle, which it never was. Use NewCBORHandle.
+func GetCBORHandle() *codec.CborHandle {
+	return NewCBORHandle()
+}
+
+// NewCBORHandle returns a codec.CborHandle that encodes like this package, with an extension
+// for BinaryRef as CypherLinkCBORTag.
+// Every call returns a new handle, so changing its options doesn't affect other users of the package.
+// The core types don't use it, they are always encoded canonically by cbor.go.
+func NewCBORHandle() (h *codec.CborHandle) {
+	h = new(codec.CborHandle)
+	h.IndefiniteLength = false // no streaming
+	h.Canonical = true         // sort map keys
+	h.SignedInteger = true
+
+	h.StructToArray = true
+
+	// don't trust the lengths and nesting of the input
+	h.MaxDepth = cborMaxDepth
+	h.MaxInitLen = cborMaxItems
+
+	var cExt BinRefExt
+	h.SetInterfaceExt(reflect.TypeOf(&BinaryRef{}), CypherLinkCBORTag, cExt)
+	return h
+}
+
+type BinRefExt struct{}
+
+var _ codec.InterfaceExt = (*BinRefExt)(nil)
+
+func (x BinRefExt) ConvertExt(v interface{}) interface{} {
+	br, ok := v.(*BinaryRef)
+	if !ok {
+		panic(fmt.Sprintf("unsupported format expecting to decode into *BinaryRef; got %T", v))
+	}
+	refBytes, err := br.MarshalBinary()
+	if err != nil {
+		panic(err) //hrm...
+	}
+	return refBytes
+}
+
+func (x BinRefExt) UpdateExt(dst interface{}, src interface{}) {
+	br, ok := dst.(*BinaryRef)
+	if !ok {
+		panic(fmt.Sprintf("unsupported format - expecting to decode into *BinaryRef; got %T", dst))
+	}
+
+	input, ok := src.([]byte)
+	if !ok {
+		panic(fmt.Sprintf("unsupported input format - expecting to decode from []byte; got %T", src))
+	}
+
+	err := br.UnmarshalBinary(input)
+	if err != nil {
+		panic(err)
+	}
+
+}
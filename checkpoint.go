@@ -0,0 +1,137 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+//go:build !tinygo
+// +build !tinygo
+
+package gabbygrove
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/ugorji/go/codec"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+)
+
+// checkpointDomain is prepended to the signed bytes, so a checkpoint signature can never be mistaken for one over an event
+const checkpointDomain = "gabbygrove/checkpoint-v1\n"
+
+// Checkpoint is the statement of a witness that it verified Feed up to Sequence, ending in Tip.
+// Any keypair can sign one, which allows peers to trust a feed prefix because enough witnesses they know vouch for it.
+type Checkpoint struct {
+	Feed     BinaryRef
+	Sequence uint64
+	Tip      BinaryRef
+
+	Witness   BinaryRef
+	Timestamp int64
+}
+
+// SignedCheckpoint holds the encoded checkpoint together with the signature of the witness
+type SignedCheckpoint struct {
+	Checkpoint []byte
+	Signature  []byte
+}
+
+// CheckpointFromState creates an (unsigned) checkpoint for the current tip of a validated feed
+func CheckpointFromState(fs FeedState) (*Checkpoint, error) {
+	if fs.Tip == nil {
+		return nil, errors.Errorf("gabbygrove/checkpoint: empty feed")
+	}
+	var cp Checkpoint
+	var err error
+	if cp.Feed, err = fromRef(fs.Author); err != nil {
+		return nil, err
+	}
+	if cp.Tip, err = fromRef(*fs.Tip); err != nil {
+		return nil, err
+	}
+	cp.Sequence = fs.Sequence
+	return &cp, nil
+}
+
+// Sign sets the witness and timestamp and signs the checkpoint with the witness key
+func (cp Checkpoint) Sign(witness ed25519.PrivateKey) (*SignedCheckpoint, error) {
+	var err error
+	cp.Witness, err = refFromPubKey(witness.Public().(ed25519.PublicKey))
+	if err != nil {
+		return nil, errors.Wrap(err, "gabbygrove/checkpoint: invalid witness key")
+	}
+	cp.Timestamp = now().Unix()
+
+	var buf bytes.Buffer
+	if err := codec.NewEncoder(&buf, NewCBORHandle()).Encode(cp); err != nil {
+		return nil, errors.Wrap(err, "gabbygrove/checkpoint: failed to encode")
+	}
+
+	var sc SignedCheckpoint
+	sc.Checkpoint = buf.Bytes()
+	sc.Signature = ed25519.Sign(witness, append([]byte(checkpointDomain), sc.Checkpoint...))
+	return &sc, nil
+}
+
+// Verify checks the signature of the witness and returns the decoded checkpoint
+func (sc SignedCheckpoint) Verify() (*Checkpoint, error) {
+	var cp Checkpoint
+	dec := codec.NewDecoder(io.LimitReader(bytes.NewReader(sc.Checkpoint), maxEventSize), NewCBORHandle())
+	if err := dec.Decode(&cp); err != nil {
+		return nil, errors.Wrap(err, "gabbygrove/checkpoint: failed to decode")
+	}
+
+	for _, check := range []struct {
+		br *BinaryRef
+		t  RefType
+	}{
+		{&cp.Feed, RefTypeFeed},
+		{&cp.Tip, RefTypeMessage},
+		{&cp.Witness, RefTypeFeed},
+	} {
+		if _, err := check.br.GetRef(check.t); err != nil {
+			return nil, errors.Wrap(err, "gabbygrove/checkpoint: invalid reference")
+		}
+	}
+
+	witness := cp.WitnessRef()
+	if !ed25519.Verify(witness.PubKey(), append([]byte(checkpointDomain), sc.Checkpoint...), sc.Signature) {
+		return nil, errors.Wrap(ErrInvalidSignature, "checkpoint")
+	}
+	return &cp, nil
+}
+
+// FeedRef returns the feed the checkpoint is about
+func (cp Checkpoint) FeedRef() refs.FeedRef {
+	return cp.Feed.r.(refs.FeedRef)
+}
+
+// TipRef returns the key of the latest message the witness verified
+func (cp Checkpoint) TipRef() refs.MessageRef {
+	return cp.Tip.r.(refs.MessageRef)
+}
+
+// WitnessRef returns the key of the witness that signed the checkpoint
+func (cp Checkpoint) WitnessRef() refs.FeedRef {
+	return cp.Witness.r.(refs.FeedRef)
+}
+
+func (sc SignedCheckpoint) MarshalCBOR() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := codec.NewEncoder(&buf, NewCBORHandle()).Encode(sc); err != nil {
+		return nil, errors.Wrap(err, "gabbygrove/checkpoint: failed to encode")
+	}
+	return buf.Bytes(), nil
+}
+
+func (sc *SignedCheckpoint) UnmarshalCBOR(data []byte) error {
+	dec := codec.NewDecoder(io.LimitReader(bytes.NewReader(data), 2*maxEventSize), NewCBORHandle())
+	if err := dec.Decode(sc); err != nil {
+		return errors.Wrap(err, "gabbygrove/checkpoint: failed to decode")
+	}
+	if len(sc.Signature) != ed25519.SignatureSize {
+		return errors.Errorf("gabbygrove/checkpoint: wrong signature size")
+	}
+	return nil
+}
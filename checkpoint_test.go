@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+//go:build !tinygo
+// +build !tinygo
+
+package gabbygrove
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpoint(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	author, trs := makeTestFeed(t, "dead", 4)
+	state := NewFeedState(author)
+	for _, tr := range trs {
+		r.NoError(state.Append(tr))
+	}
+
+	cp, err := CheckpointFromState(*state)
+	r.NoError(err)
+
+	witnessPub, witnessKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("w1tn"), 8)))
+	sc, err := cp.Sign(witnessKey)
+	r.NoError(err)
+
+	data, err := sc.MarshalCBOR()
+	r.NoError(err)
+
+	var got SignedCheckpoint
+	r.NoError(got.UnmarshalCBOR(data))
+	gotCp, err := got.Verify()
+	r.NoError(err)
+	a.True(author.Equal(gotCp.FeedRef()))
+	a.EqualValues(4, gotCp.Sequence)
+	a.Equal(trs[3].Key(), gotCp.TipRef())
+	a.Equal([]byte(witnessPub), []byte(gotCp.WitnessRef().PubKey()))
+
+	got.Signature[5] ^= 1
+	_, err = got.Verify()
+	a.Error(err)
+
+	_, err = CheckpointFromState(*NewFeedState(author))
+	a.Error(err)
+}
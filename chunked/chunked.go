@@ -0,0 +1,312 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+// Package chunked frames messages in small chunks of a fixed size, for lossy links like serial lines and BLE.
+//
+// Every chunk is exactly as large as the chunk size the link is set up with:
+//
+//	magic (1) | message id (2) | index (2) | count (2) | length (1) | payload (length) | padding | crc32 (4)
+//
+// The numbers are big-endian and the CRC32 (IEEE) covers everything before it.
+// A message is split into count chunks that all carry its id, the padding fills up the last one.
+// A Reader finds the chunks in a stream that lost or garbled bytes by their magic and checksum,
+// drops what's broken and puts the messages back together, also if their chunks are interleaved.
+// Messages that miss chunks are given up after a while, retransmission is up to the protocol on top.
+package chunked
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+)
+
+const (
+	chunkMagic = 0xc7
+
+	headerSize = 8
+	crcSize    = 4
+
+	// MinChunkSize leaves room for at least 4 bytes of payload
+	MinChunkSize = headerSize + 4 + crcSize
+
+	// MaxChunkSize is the largest chunk, its payload length needs to fit a byte
+	MaxChunkSize = headerSize + 255 + crcSize
+
+	// DefaultChunkSize fits the payload of a BLE notification with the largest ATT MTU of 247
+	DefaultChunkSize = 244
+
+	// DefaultPending is how many incomplete messages a Reassembler keeps
+	DefaultPending = 8
+)
+
+// ErrMessageSize is returned for messages that need more chunks than a message id can count
+var ErrMessageSize = errors.New("chunked: message too large")
+
+func checkSize(size int) error {
+	if size < MinChunkSize || size > MaxChunkSize {
+		return errors.Errorf("chunked: chunk size %d not within %d and %d", size, MinChunkSize, MaxChunkSize)
+	}
+	return nil
+}
+
+// Writer splits messages into chunks
+type Writer struct {
+	mu   sync.Mutex
+	w    io.Writer
+	size int
+	id   uint16
+}
+
+// NewWriter returns a writer of chunks of size bytes to w, every chunk is one call to Write
+func NewWriter(w io.Writer, size int) (*Writer, error) {
+	if err := checkSize(size); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, size: size}, nil
+}
+
+// WriteMessage writes msg as the next message, it's safe for concurrent use
+func (w *Writer) WriteMessage(msg []byte) error {
+	room := w.size - headerSize - crcSize
+	count := (len(msg) + room - 1) / room
+	if count == 0 {
+		count = 1
+	}
+	if count > 0xffff {
+		return errors.Wrapf(ErrMessageSize, "%d bytes", len(msg))
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	id := w.id
+	w.id++
+
+	chunk := make([]byte, w.size)
+	for i := 0; i < count; i++ {
+		part := msg
+		if len(part) > room {
+			part = part[:room]
+		}
+		msg = msg[len(part):]
+
+		for j := range chunk {
+			chunk[j] = 0
+		}
+		chunk[0] = chunkMagic
+		binary.BigEndian.PutUint16(chunk[1:], id)
+		binary.BigEndian.PutUint16(chunk[3:], uint16(i))
+		binary.BigEndian.PutUint16(chunk[5:], uint16(count))
+		chunk[7] = byte(len(part))
+		copy(chunk[headerSize:], part)
+		binary.BigEndian.PutUint32(chunk[w.size-crcSize:], crc32.ChecksumIEEE(chunk[:w.size-crcSize]))
+		if _, err := w.w.Write(chunk); err != nil {
+			return errors.Wrap(err, "chunked: failed to write chunk")
+		}
+	}
+	return nil
+}
+
+// WriteTransfer writes tr as one message
+func (w *Writer) WriteTransfer(tr *gabbygrove.Transfer) error {
+	data, err := tr.MarshalCBOR()
+	if err != nil {
+		return errors.Wrap(err, "chunked: failed to encode transfer")
+	}
+	return w.WriteMessage(data)
+}
+
+// Stats counts what a Reassembler or Reader had to drop
+type Stats struct {
+	// Skipped are the bytes between chunks that didn't belong to one
+	Skipped int
+
+	// Corrupt are the chunks passed to Add that were dropped because their header or checksum was wrong.
+	// A Reader can't tell them from other bytes, it counts them as Skipped.
+	Corrupt int
+
+	// Incomplete are the messages that were given up because chunks were missing
+	Incomplete int
+}
+
+// Reassembler puts the chunks of messages back together
+type Reassembler struct {
+	size    int
+	pending int
+
+	// partial are the incomplete messages, oldest first
+	partial []*partial
+	stats   Stats
+}
+
+type partial struct {
+	id     uint16
+	chunks [][]byte
+	have   int
+}
+
+// NewReassembler returns a reassembler for chunks of size bytes that keeps DefaultPending incomplete messages
+func NewReassembler(size int) (*Reassembler, error) {
+	if err := checkSize(size); err != nil {
+		return nil, err
+	}
+	return &Reassembler{size: size, pending: DefaultPending}, nil
+}
+
+// WithPending sets how many incomplete messages are kept, the oldest is given up for a new one beyond that
+func (r *Reassembler) WithPending(n int) {
+	if n < 1 {
+		n = 1
+	}
+	r.pending = n
+}
+
+// Stats returns what was dropped so far
+func (r *Reassembler) Stats() Stats {
+	return r.stats
+}
+
+// valid returns true if chunk is a chunk with a correct checksum and header
+func (r *Reassembler) valid(chunk []byte) bool {
+	if len(chunk) != r.size || chunk[0] != chunkMagic {
+		return false
+	}
+	if crc32.ChecksumIEEE(chunk[:r.size-crcSize]) != binary.BigEndian.Uint32(chunk[r.size-crcSize:]) {
+		return false
+	}
+	index := binary.BigEndian.Uint16(chunk[3:])
+	count := binary.BigEndian.Uint16(chunk[5:])
+	return index < count && int(chunk[7]) <= r.size-headerSize-crcSize
+}
+
+// Add takes one chunk and returns the message it completes, nil if there is none.
+// Broken chunks are dropped and counted in Stats, chunks that were already added are ignored.
+func (r *Reassembler) Add(chunk []byte) []byte {
+	if !r.valid(chunk) {
+		r.stats.Corrupt++
+		return nil
+	}
+	id := binary.BigEndian.Uint16(chunk[1:])
+	index := binary.BigEndian.Uint16(chunk[3:])
+	count := int(binary.BigEndian.Uint16(chunk[5:]))
+	payload := chunk[headerSize : headerSize+int(chunk[7])]
+
+	var p *partial
+	for _, cand := range r.partial {
+		if cand.id == id && len(cand.chunks) == count {
+			p = cand
+			break
+		}
+	}
+	if p == nil {
+		if count == 1 {
+			return append([]byte{}, payload...)
+		}
+		if len(r.partial) >= r.pending {
+			r.partial = r.partial[1:]
+			r.stats.Incomplete++
+		}
+		p = &partial{id: id, chunks: make([][]byte, count)}
+		r.partial = append(r.partial, p)
+	}
+	if p.chunks[index] != nil {
+		return nil
+	}
+	p.chunks[index] = append([]byte{}, payload...)
+	p.have++
+	if p.have < count {
+		return nil
+	}
+
+	for i, cand := range r.partial {
+		if cand == p {
+			r.partial = append(r.partial[:i], r.partial[i+1:]...)
+			break
+		}
+	}
+	var msg []byte
+	for _, c := range p.chunks {
+		msg = append(msg, c...)
+	}
+	return msg
+}
+
+// Reader reads the messages of a stream of chunks, see the package documentation
+type Reader struct {
+	rd  *bufio.Reader
+	re  *Reassembler
+	buf []byte
+}
+
+// NewReader returns a reader of the chunks of size bytes in r
+func NewReader(r io.Reader, size int) (*Reader, error) {
+	re, err := NewReassembler(size)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{rd: bufio.NewReaderSize(r, 4*MaxChunkSize), re: re}, nil
+}
+
+// Reassembler returns the reassembler of the reader, i.e. for its stats or to change the pending messages
+func (r *Reader) Reassembler() *Reassembler {
+	return r.re
+}
+
+// ReadMessage returns the next complete message. At the end of the stream it returns io.EOF,
+// incomplete messages and a partial chunk at the end are dropped then.
+func (r *Reader) ReadMessage() ([]byte, error) {
+	size := r.re.size
+	for {
+		for len(r.buf) < size {
+			b, err := r.rd.ReadByte()
+			if err != nil {
+				if err == io.EOF {
+					r.re.stats.Skipped += len(r.buf)
+					r.re.stats.Incomplete += len(r.re.partial)
+					r.buf, r.re.partial = nil, nil
+				}
+				return nil, err
+			}
+			if len(r.buf) == 0 && b != chunkMagic {
+				r.re.stats.Skipped++
+				continue
+			}
+			r.buf = append(r.buf, b)
+		}
+
+		if !r.re.valid(r.buf) {
+			// not a chunk after all, look for the next magic after the one that was wrong
+			r.re.stats.Skipped++
+			next := 1
+			for next < len(r.buf) && r.buf[next] != chunkMagic {
+				next++
+			}
+			r.re.stats.Skipped += next - 1
+			r.buf = append(r.buf[:0], r.buf[next:]...)
+			continue
+		}
+		msg := r.re.Add(r.buf)
+		r.buf = r.buf[:0]
+		if msg != nil {
+			return msg, nil
+		}
+	}
+}
+
+// ReadTransfer reads the next message as a transfer
+func (r *Reader) ReadTransfer() (*gabbygrove.Transfer, error) {
+	msg, err := r.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	var tr gabbygrove.Transfer
+	if err := tr.UnmarshalCBOR(msg); err != nil {
+		return nil, errors.Wrap(err, "chunked: invalid transfer")
+	}
+	return &tr, nil
+}
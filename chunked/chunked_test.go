@@ -0,0 +1,141 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package chunked
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mindeco.de/ssb-gabbygrove/gabbygrovetest"
+)
+
+func TestChunked(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, err := NewWriter(ioutil.Discard, MinChunkSize-1)
+	a.Error(err)
+	_, err = NewReader(nil, MaxChunkSize+1)
+	a.Error(err)
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, 32)
+	r.NoError(err)
+	msgs := [][]byte{
+		{},
+		[]byte("short"),
+		bytes.Repeat([]byte("long message "), 50),
+	}
+	for _, m := range msgs {
+		r.NoError(w.WriteMessage(m))
+	}
+	feed := gabbygrovetest.Fixture(t, "alice", 3)
+	for _, tr := range feed.Messages() {
+		r.NoError(w.WriteTransfer(tr))
+	}
+	a.Zero(buf.Len() % 32)
+
+	rd, err := NewReader(&buf, 32)
+	r.NoError(err)
+	for _, want := range msgs {
+		got, err := rd.ReadMessage()
+		r.NoError(err)
+		a.Equal(want, got)
+	}
+	for _, want := range feed.Messages() {
+		got, err := rd.ReadTransfer()
+		r.NoError(err)
+		a.Equal(want.Key(), got.Key())
+	}
+	_, err = rd.ReadMessage()
+	a.Equal(io.EOF, err)
+	a.Equal(Stats{}, rd.Reassembler().Stats())
+}
+
+func TestChunkedLossy(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	const size = 24
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, size)
+	r.NoError(err)
+	first := bytes.Repeat([]byte{1}, 30)
+	second := bytes.Repeat([]byte{2}, 30)
+	third := bytes.Repeat([]byte{3}, 30)
+	for _, m := range [][]byte{first, second, third} {
+		r.NoError(w.WriteMessage(m))
+	}
+	chunks := buf.Bytes()
+	r.Len(chunks, 9*size)
+
+	// noise in front, a flipped bit in the second message and a lost chunk of the third
+	var lossy []byte
+	lossy = append(lossy, 0x00, chunkMagic, 0x42)
+	lossy = append(lossy, chunks[:3*size]...)
+	broken := append([]byte{}, chunks[3*size:4*size]...)
+	broken[10] ^= 0x04
+	lossy = append(lossy, broken...)
+	lossy = append(lossy, chunks[4*size:7*size]...)
+	lossy = append(lossy, chunks[8*size:]...)
+
+	rd, err := NewReader(bytes.NewReader(lossy), size)
+	r.NoError(err)
+	got, err := rd.ReadMessage()
+	r.NoError(err)
+	a.Equal(first, got)
+	_, err = rd.ReadMessage()
+	a.Equal(io.EOF, err)
+	stats := rd.Reassembler().Stats()
+	a.Equal(3+size, stats.Skipped)
+	a.Equal(2, stats.Incomplete)
+}
+
+func TestReassemblerOrder(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	const size = MinChunkSize
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, size)
+	r.NoError(err)
+	msgs := [][]byte{[]byte("interleaved"), []byte("out of order")}
+	for _, m := range msgs {
+		r.NoError(w.WriteMessage(m))
+	}
+	var chunks [][]byte
+	for data := buf.Bytes(); len(data) > 0; data = data[size:] {
+		chunks = append(chunks, data[:size])
+	}
+
+	re, err := NewReassembler(size)
+	r.NoError(err)
+	rand.New(rand.NewSource(1)).Shuffle(len(chunks), func(i, j int) { chunks[i], chunks[j] = chunks[j], chunks[i] })
+	var got [][]byte
+	for _, c := range chunks {
+		if msg := re.Add(c); msg != nil {
+			got = append(got, msg)
+		}
+		// duplicates are ignored
+		a.Nil(re.Add(c))
+	}
+	a.ElementsMatch(msgs, got)
+
+	a.Nil(re.Add(make([]byte, size)))
+	a.Equal(1, re.Stats().Corrupt)
+
+	// the oldest incomplete message is given up
+	re.WithPending(1)
+	a.Nil(re.Add(chunks[0]))
+	buf.Reset()
+	r.NoError(w.WriteMessage([]byte("the next one")))
+	a.Nil(re.Add(buf.Bytes()[:size]))
+	a.Equal(1, re.Stats().Incomplete)
+}
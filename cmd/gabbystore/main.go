@@ -0,0 +1,153 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+// gabbystore checks and maintains the files of a store.
+//
+// Usage:
+//
+//	gabbystore [-bolt index.db] fsck <dir>
+//	gabbystore [-bolt index.db] compact <dir>
+//	gabbystore stats <dir>
+//
+// fsck verifies every stored message and prints the problems it finds, one per line.
+// compact rewrites feeds without the content that was deleted.
+// stats prints one line per feed with its counts and sizes and a total line at the end.
+// They exit with 1 if something is wrong and 2 on invalid usage.
+// The store shouldn't be used by another process while they run.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	"go.mindeco.de/ssb-gabbygrove/store"
+	"go.mindeco.de/ssb-gabbygrove/store/boltindex"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("gabbystore", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	boltPath := flags.String("bolt", "", "path of the bolt index of the store, if it has one")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: gabbystore [-bolt index.db] fsck|compact|stats <dir>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+	cmd, dir := flags.Arg(0), flags.Arg(1)
+	if cmd != "fsck" && cmd != "compact" && cmd != "stats" {
+		flags.Usage()
+		return 2
+	}
+
+	if _, err := os.Stat(dir); err != nil {
+		fmt.Fprintln(stderr, "gabbystore:", err)
+		return 1
+	}
+	s, err := store.Open(dir)
+	if err != nil {
+		fmt.Fprintln(stderr, "gabbystore:", err)
+		return 1
+	}
+	defer s.Close()
+
+	if *boltPath != "" {
+		db, err := bolt.Open(*boltPath, 0600, nil)
+		if err != nil {
+			fmt.Fprintln(stderr, "gabbystore: failed to open index:", err)
+			return 1
+		}
+		defer db.Close()
+		idx, err := boltindex.New(db)
+		if err != nil {
+			fmt.Fprintln(stderr, "gabbystore:", err)
+			return 1
+		}
+		s.WithIndex(idx)
+	}
+
+	switch cmd {
+	case "fsck":
+		problems, err := s.Fsck()
+		if err != nil {
+			fmt.Fprintln(stderr, "gabbystore:", err)
+			return 1
+		}
+		for _, p := range problems {
+			fmt.Fprintln(stdout, p.Error())
+		}
+		if len(problems) > 0 {
+			fmt.Fprintf(stderr, "gabbystore: %d problems\n", len(problems))
+			return 1
+		}
+	case "compact":
+		if err := s.Compact(); err != nil {
+			fmt.Fprintln(stderr, "gabbystore:", err)
+			return 1
+		}
+	case "stats":
+		st, err := s.Stats()
+		if err != nil {
+			fmt.Fprintln(stderr, "gabbystore:", err)
+			return 1
+		}
+		printStats(stdout, st)
+	}
+	return 0
+}
+
+var contentTypeNames = map[gabbygrove.ContentType]string{
+	gabbygrove.ContentTypeArbitrary: "arbitrary",
+	gabbygrove.ContentTypeJSON:      "json",
+	gabbygrove.ContentTypeCBOR:      "cbor",
+}
+
+// printStats prints key=value pairs so the lines can be grepped and parsed
+func printStats(w io.Writer, st *store.Stats) {
+	for _, fs := range st.Feeds {
+		fmt.Fprintf(w, "%s messages=%d log=%d disk=%d content=%d deleted=%d", fs.Author.String(), fs.Messages, fs.LogBytes, fs.DiskBytes, fs.ContentBytes, fs.DeletedContent)
+		if !fs.First.IsZero() {
+			fmt.Fprintf(w, " first=%s last=%s", fs.First.UTC().Format(time.RFC3339), fs.Last.UTC().Format(time.RFC3339))
+		}
+		encodings := make(map[string]uint64, len(fs.ContentTypes))
+		for ct, n := range fs.ContentTypes {
+			name, ok := contentTypeNames[ct]
+			if !ok {
+				name = fmt.Sprint(uint(ct))
+			}
+			encodings[name] = n
+		}
+		fmt.Fprintf(w, " encodings=%s types=%s\n", histogram(encodings), histogram(fs.Types))
+	}
+	fmt.Fprintf(w, "total feeds=%d messages=%d disk=%d shared=%d\n", len(st.Feeds), st.Messages, st.DiskBytes, st.SharedBytes)
+}
+
+// histogram formats counts as name:n pairs, sorted by name
+func histogram(counts map[string]uint64) string {
+	if len(counts) == 0 {
+		return "-"
+	}
+	pairs := make([]string, 0, len(counts))
+	for name, n := range counts {
+		pairs = append(pairs, fmt.Sprintf("%s:%d", name, n))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
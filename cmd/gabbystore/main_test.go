@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	"go.mindeco.de/ssb-gabbygrove/store"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestRun(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbystore")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	logs := filepath.Join(dir, "logs")
+
+	pub, priv, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	r.NoError(err)
+	author, err := refs.NewFeedRefFromBytes(pub, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+	e := gabbygrove.NewEncoder(priv)
+	state := gabbygrove.NewFeedState(author)
+
+	s, err := store.Open(logs)
+	r.NoError(err)
+	for i := 0; i < 3; i++ {
+		seq, prev := state.Next()
+		tr, _, err := e.Encode(seq, prev, map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+		r.NoError(state.Append(tr))
+		r.NoError(s.Append(tr))
+	}
+	r.NoError(s.DeleteContent(author, 2))
+	r.NoError(s.Close())
+
+	exec := func(args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		code := run(args, &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+
+	code, out, _ := exec("fsck", logs)
+	a.Equal(0, code)
+	a.Empty(out)
+
+	idx := filepath.Join(dir, "index.db")
+	code, _, errOut := exec("-bolt", idx, "compact", logs)
+	a.Equal(0, code, errOut)
+	code, out, _ = exec("-bolt", idx, "fsck", logs)
+	a.Equal(0, code, out)
+
+	code, out, _ = exec("stats", logs)
+	a.Equal(0, code)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	r.Len(lines, 2, out)
+	a.True(strings.HasPrefix(lines[0], author.String()+" messages=3 "), lines[0])
+	a.Contains(lines[0], " deleted=1 ")
+	a.Contains(lines[0], " types=test:2")
+	a.True(strings.HasPrefix(lines[1], "total feeds=1 messages=3 "), lines[1])
+
+	// tampered content
+	logPath := filepath.Join(logs, hex.EncodeToString(pub)+".log")
+	logData, err := ioutil.ReadFile(logPath)
+	r.NoError(err)
+	i := bytes.LastIndex(logData, []byte(`"i":2`))
+	r.True(i > 0)
+	logData[i+4] = '3'
+	r.NoError(ioutil.WriteFile(logPath, logData, 0600))
+
+	code, out, errOut = exec("fsck", logs)
+	a.Equal(1, code)
+	a.Contains(out, ":3: ")
+	a.Contains(errOut, "1 problems")
+
+	code, _, _ = exec("repair", logs)
+	a.Equal(2, code)
+	code, _, _ = exec("fsck")
+	a.Equal(2, code)
+	code, _, _ = exec("fsck", filepath.Join(dir, "nope"))
+	a.Equal(1, code)
+}
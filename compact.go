@@ -0,0 +1,193 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+)
+
+// The compact form drops everything that can be re-derived from the event itself:
+// the CBOR framing, tags and type bytes of the references and the length of the content.
+// It starts with a flags byte, followed by the fixed size fields and varints:
+//
+//	flags | author(32) | [previous(32)] | seq(uvarint) | timestamp(varint) | content hash(32) | content size(uvarint) | content type(uvarint) | signature(64) | [content]
+const (
+	compactVersion1 byte = 0x10
+
+	compactHasPrevious byte = 1 << 0
+	compactHasContent  byte = 1 << 1
+
+	compactFlagMask byte = compactHasPrevious | compactHasContent
+)
+
+// MarshalCompact encodes the transfer for size constrained channels like QR codes or NFC tags.
+// Without content a message takes less then 200 bytes.
+// Only transfers with canonically encoded events (like the ones produced by Encoder) can be compacted.
+func (tr *Transfer) MarshalCompact(withContent bool) ([]byte, error) {
+	evt, err := tr.getEvent()
+	if err != nil {
+		return nil, errors.Wrap(err, "gabbygrove/compact: invalid event")
+	}
+
+	if len(evt.unknown) > 0 || len(tr.unknown) > 0 {
+		return nil, errors.Wrap(ErrUnknownFields, "gabbygrove/compact")
+	}
+	if len(evt.Extensions) > 0 {
+		return nil, errors.Errorf("gabbygrove/compact: events with extensions can't be compacted")
+	}
+
+	// we need to be able to reproduce the signed bytes exactly
+	reEncoded, err := evt.MarshalCBOR()
+	if err != nil {
+		return nil, errors.Wrap(err, "gabbygrove/compact: failed to re-encode event")
+	}
+	if !bytes.Equal(reEncoded, tr.Event) {
+		return nil, errors.Errorf("gabbygrove/compact: event is not canonically encoded")
+	}
+	if len(tr.Signature) != ed25519.SignatureSize {
+		return nil, errors.Errorf("gabbygrove/compact: wrong signature size")
+	}
+
+	var buf bytes.Buffer
+	flags := compactVersion1
+	if evt.Previous != nil {
+		flags |= compactHasPrevious
+	}
+	if withContent {
+		if len(tr.Content) != int(evt.Content.Size) {
+			return nil, errors.Errorf("gabbygrove/compact: content missing or of wrong size")
+		}
+		flags |= compactHasContent
+	}
+	buf.WriteByte(flags)
+
+	for _, br := range []*BinaryRef{&evt.Author, evt.Previous} {
+		if br == nil {
+			continue
+		}
+		b, err := br.MarshalBinary()
+		if err != nil {
+			return nil, errors.Wrap(err, "gabbygrove/compact: invalid reference")
+		}
+		buf.Write(b[1:])
+	}
+
+	var vbuf [binary.MaxVarintLen64]byte
+	buf.Write(vbuf[:binary.PutUvarint(vbuf[:], evt.Sequence)])
+	buf.Write(vbuf[:binary.PutVarint(vbuf[:], evt.Timestamp)])
+
+	hb, err := evt.Content.Hash.MarshalBinary()
+	if err != nil {
+		return nil, errors.Wrap(err, "gabbygrove/compact: invalid content hash")
+	}
+	buf.Write(hb[1:])
+	buf.Write(vbuf[:binary.PutUvarint(vbuf[:], uint64(evt.Content.Size))])
+	buf.Write(vbuf[:binary.PutUvarint(vbuf[:], uint64(evt.Content.Type))])
+
+	buf.Write(tr.Signature)
+	if withContent {
+		buf.Write(tr.Content)
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalCompact restores a transfer from its compact form.
+// The event bytes are re-created and need to be verified like any other received transfer.
+func (tr *Transfer) UnmarshalCompact(data []byte) error {
+	rd := bytes.NewReader(data)
+
+	flags, err := rd.ReadByte()
+	if err != nil {
+		return errors.Wrap(err, "gabbygrove/compact: missing flags")
+	}
+	if flags&^compactFlagMask != compactVersion1 {
+		return errors.Errorf("gabbygrove/compact: unsupported version or flags: %x", flags)
+	}
+
+	var evt Event
+	var hash [32]byte
+
+	if _, err := io.ReadFull(rd, hash[:]); err != nil {
+		return errors.Wrap(err, "gabbygrove/compact: author")
+	}
+	author, err := refs.NewFeedRefFromBytes(hash[:], refs.RefAlgoFeedGabby)
+	if err != nil {
+		return err
+	}
+	evt.Author, _ = fromRef(author)
+
+	if flags&compactHasPrevious != 0 {
+		if _, err := io.ReadFull(rd, hash[:]); err != nil {
+			return errors.Wrap(err, "gabbygrove/compact: previous")
+		}
+		mr, err := refs.NewMessageRefFromBytes(hash[:], refs.RefAlgoMessageGabby)
+		if err != nil {
+			return err
+		}
+		prev, _ := fromRef(mr)
+		evt.Previous = &prev
+	}
+
+	if evt.Sequence, err = binary.ReadUvarint(rd); err != nil {
+		return errors.Wrap(err, "gabbygrove/compact: sequence")
+	}
+	if evt.Timestamp, err = binary.ReadVarint(rd); err != nil {
+		return errors.Wrap(err, "gabbygrove/compact: timestamp")
+	}
+
+	if _, err := io.ReadFull(rd, hash[:]); err != nil {
+		return errors.Wrap(err, "gabbygrove/compact: content hash")
+	}
+	cr, err := NewContentRefFromBytes(hash[:])
+	if err != nil {
+		return err
+	}
+	evt.Content.Hash, _ = fromRef(cr)
+
+	size, err := binary.ReadUvarint(rd)
+	if err != nil || size > 0xffff {
+		return errors.Errorf("gabbygrove/compact: invalid content size")
+	}
+	evt.Content.Size = uint16(size)
+	ctype, err := binary.ReadUvarint(rd)
+	if err != nil || ctype > uint64(ContentTypeCBOR) {
+		return errors.Errorf("gabbygrove/compact: invalid content type")
+	}
+	evt.Content.Type = ContentType(ctype)
+
+	sig := make([]byte, ed25519.SignatureSize)
+	if _, err := io.ReadFull(rd, sig); err != nil {
+		return errors.Wrap(err, "gabbygrove/compact: signature")
+	}
+
+	var content []byte
+	if flags&compactHasContent != 0 {
+		if rd.Len() != int(evt.Content.Size) {
+			return errors.Errorf("gabbygrove/compact: content size mismatch (%d != %d)", rd.Len(), evt.Content.Size)
+		}
+		content = make([]byte, rd.Len())
+		rd.Read(content)
+	} else if rd.Len() != 0 {
+		return errors.Errorf("gabbygrove/compact: %d trailing bytes", rd.Len())
+	}
+
+	evtBytes, err := evt.MarshalCBOR()
+	if err != nil {
+		return err
+	}
+
+	var newTr Transfer
+	newTr.Event = evtBytes
+	newTr.Signature = sig
+	newTr.Content = content
+	*tr = newTr
+	return nil
+}
@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompact(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+
+	startTime = time.Date(1969, 12, 31, 23, 59, 55, 0, time.UTC).Unix()
+	now = fakeNow
+
+	e := NewEncoder(privKey)
+	e.WithNowTimestamps(true)
+
+	var prev BinaryRef
+	for i := 1; i <= 2; i++ {
+		tr, key, err := e.Encode(uint64(i), prev, map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+		prev, err = fromRef(key)
+		r.NoError(err)
+
+		full, err := tr.MarshalCBOR()
+		r.NoError(err)
+
+		for _, withContent := range []bool{true, false} {
+			compact, err := tr.MarshalCompact(withContent)
+			r.NoError(err)
+			t.Logf("msg %d: %d bytes compacted to %d (content: %v)", i, len(full), len(compact), withContent)
+			a.True(len(compact) < 1024)
+
+			var got Transfer
+			r.NoError(got.UnmarshalCompact(compact))
+			a.True(got.Verify(nil))
+			a.Equal(key, got.Key())
+			a.Equal(tr.Event, got.Event)
+			if withContent {
+				a.Equal(tr.Content, got.Content)
+			} else {
+				a.Nil(got.Content)
+			}
+
+			// truncated input
+			a.Error(got.UnmarshalCompact(compact[:len(compact)-1]))
+		}
+	}
+}
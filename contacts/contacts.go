@@ -0,0 +1,207 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+// Package contacts keeps the social graph that contact messages of gabbygrove feeds describe.
+//
+// A contact message is JSON content like {"type":"contact","contact":"ssb:feed/...","following":true}.
+// Besides following it can set blocking, and spectating is an alias for following.
+// Fields that are left out don't change the relation, the latest message of an author wins.
+//
+// The Graph is an indexer.Indexer, it's kept in memory and built from a store by an indexer.Driver.
+package contacts
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	"go.mindeco.de/ssb-gabbygrove/indexer"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// Type is the message type of contact messages
+const Type = "contact"
+
+// Message is the content of a contact message
+type Message struct {
+	Type    string `json:"type"`
+	Contact string `json:"contact"`
+
+	Following  *bool `json:"following,omitempty"`
+	Blocking   *bool `json:"blocking,omitempty"`
+	Spectating *bool `json:"spectating,omitempty"`
+}
+
+// relation is what an author thinks of one contact
+type relation struct {
+	contact   refs.FeedRef
+	following bool
+	blocking  bool
+}
+
+// Graph tracks who follows and blocks whom. It's safe for concurrent use.
+type Graph struct {
+	mu    sync.RWMutex
+	edges map[string]map[string]*relation
+
+	progress indexer.Progress
+}
+
+var _ indexer.Indexer = (*Graph)(nil)
+
+// New returns an empty graph
+func New() *Graph {
+	return &Graph{edges: make(map[string]map[string]*relation)}
+}
+
+// ProcessMessage applies tr if it's a contact message.
+// Other messages, messages without content and malformed contact messages only advance the progress of the feed,
+// a broken message doesn't stop the index.
+func (g *Graph) ProcessMessage(tr *gabbygrove.Transfer) error {
+	author := tr.Author()
+	defer g.progress.Set(author, uint64(tr.Seq()))
+
+	if t, err := tr.MessageType(); err != nil || t != Type {
+		return nil
+	}
+	var msg Message
+	if err := json.Unmarshal(tr.Content, &msg); err != nil {
+		return nil
+	}
+	contact, err := refs.ParseFeedRef(msg.Contact)
+	if err != nil {
+		return nil
+	}
+	g.apply(author, contact, msg)
+	return nil
+}
+
+// Processed returns the sequence of the last message of author that was processed
+func (g *Graph) Processed(author refs.FeedRef) (uint64, error) {
+	return g.progress.Processed(author)
+}
+
+func (g *Graph) apply(author, contact refs.FeedRef, msg Message) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	edges, has := g.edges[author.String()]
+	if !has {
+		edges = make(map[string]*relation)
+		g.edges[author.String()] = edges
+	}
+	rel, has := edges[contact.String()]
+	if !has {
+		rel = &relation{contact: contact}
+		edges[contact.String()] = rel
+	}
+	if msg.Spectating != nil {
+		rel.following = *msg.Spectating
+	}
+	if msg.Following != nil {
+		rel.following = *msg.Following
+	}
+	if msg.Blocking != nil {
+		rel.blocking = *msg.Blocking
+	}
+	if !rel.following && !rel.blocking {
+		delete(edges, contact.String())
+	}
+}
+
+// Follows returns true if a follows b
+func (g *Graph) Follows(a, b refs.FeedRef) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	rel, has := g.edges[a.String()][b.String()]
+	return has && rel.following
+}
+
+// Blocks returns true if a blocks b
+func (g *Graph) Blocks(a, b refs.FeedRef) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	rel, has := g.edges[a.String()][b.String()]
+	return has && rel.blocking
+}
+
+// Following returns the feeds a follows, sorted by reference
+func (g *Graph) Following(a refs.FeedRef) []refs.FeedRef {
+	return g.list(a, func(rel *relation) bool { return rel.following })
+}
+
+// Blocking returns the feeds a blocks, sorted by reference
+func (g *Graph) Blocking(a refs.FeedRef) []refs.FeedRef {
+	return g.list(a, func(rel *relation) bool { return rel.blocking })
+}
+
+func (g *Graph) list(a refs.FeedRef, match func(*relation) bool) []refs.FeedRef {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	var feeds []refs.FeedRef
+	for _, rel := range g.edges[a.String()] {
+		if match(rel) {
+			feeds = append(feeds, rel.contact)
+		}
+	}
+	sort.Slice(feeds, func(i, j int) bool { return feeds[i].String() < feeds[j].String() })
+	return feeds
+}
+
+// Hop is a feed and how many follows away it is
+type Hop struct {
+	Feed     refs.FeedRef
+	Distance int
+}
+
+// Hops returns the feeds that are up to max follows away from from, including from itself at distance zero.
+// Feeds that from blocks are left out, even if others follow them; blocks of other feeds only affect themselves.
+// The result is sorted by distance and then by reference.
+func (g *Graph) Hops(from refs.FeedRef, max int) []Hop {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	blocked := make(map[string]bool)
+	for k, rel := range g.edges[from.String()] {
+		if rel.blocking {
+			blocked[k] = true
+		}
+	}
+
+	seen := map[string]bool{from.String(): true}
+	hops := []Hop{{Feed: from}}
+	frontier := []refs.FeedRef{from}
+	for dist := 1; dist <= max && len(frontier) > 0; dist++ {
+		var next []refs.FeedRef
+		for _, f := range frontier {
+			for k, rel := range g.edges[f.String()] {
+				if !rel.following || seen[k] || blocked[k] {
+					continue
+				}
+				seen[k] = true
+				next = append(next, rel.contact)
+				hops = append(hops, Hop{Feed: rel.contact, Distance: dist})
+			}
+		}
+		frontier = next
+	}
+	sort.Slice(hops, func(i, j int) bool {
+		if hops[i].Distance != hops[j].Distance {
+			return hops[i].Distance < hops[j].Distance
+		}
+		return hops[i].Feed.String() < hops[j].Feed.String()
+	})
+	return hops
+}
+
+// Distance returns how many follows away to is from from, if it's at most max away and not blocked by from
+func (g *Graph) Distance(from, to refs.FeedRef, max int) (int, bool) {
+	for _, h := range g.Hops(from, max) {
+		if h.Feed.Equal(to) {
+			return h.Distance, true
+		}
+	}
+	return 0, false
+}
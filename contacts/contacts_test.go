@@ -0,0 +1,116 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package contacts
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+)
+
+// testFeed publishes messages for one author
+type testFeed struct {
+	t     *testing.T
+	ref   refs.FeedRef
+	enc   *gabbygrove.Encoder
+	state *gabbygrove.FeedState
+}
+
+func newTestFeed(t *testing.T, seed string) *testFeed {
+	r := require.New(t)
+	pub, priv, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte(seed), 32/len(seed))))
+	r.NoError(err)
+	ref, err := refs.NewFeedRefFromBytes(pub, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+	return &testFeed{t: t, ref: ref, enc: gabbygrove.NewEncoder(priv), state: gabbygrove.NewFeedState(ref)}
+}
+
+func (tf *testFeed) publish(g *Graph, val interface{}) {
+	r := require.New(tf.t)
+	seq, prev := tf.state.Next()
+	tr, _, err := tf.enc.Encode(seq, prev, val)
+	r.NoError(err)
+	r.NoError(tf.state.Append(tr))
+	r.NoError(g.ProcessMessage(tr))
+}
+
+func yes() *bool { b := true; return &b }
+func no() *bool  { b := false; return &b }
+
+func TestGraph(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	g := New()
+	alice := newTestFeed(t, "alic")
+	bob := newTestFeed(t, "bob1")
+	carol := newTestFeed(t, "caro")
+	dave := newTestFeed(t, "dave")
+
+	// like the fixtures, with spectating and the contact as a FeedRef
+	alice.publish(g, map[string]interface{}{"type": "contact", "contact": bob.ref, "spectating": true})
+	alice.publish(g, Message{Type: Type, Contact: carol.ref.URI(), Following: yes()})
+	bob.publish(g, Message{Type: Type, Contact: dave.ref.URI(), Following: yes()})
+	carol.publish(g, Message{Type: Type, Contact: alice.ref.URI(), Following: yes()})
+
+	// not contact messages or broken ones
+	bob.publish(g, map[string]interface{}{"type": "post", "contact": carol.ref.URI(), "following": true})
+	bob.publish(g, map[string]interface{}{"type": "contact", "contact": "nope", "following": true})
+	bob.publish(g, map[string]interface{}{"type": "contact", "contact": carol.ref.URI(), "following": "yes"})
+	bob.publish(g, []byte("arbitrary"))
+
+	a.True(g.Follows(alice.ref, bob.ref))
+	a.True(g.Follows(alice.ref, carol.ref))
+	a.False(g.Follows(bob.ref, alice.ref))
+	a.False(g.Follows(bob.ref, carol.ref))
+	a.Len(g.Following(bob.ref), 1)
+
+	seq, err := g.Processed(bob.ref)
+	r.NoError(err)
+	a.EqualValues(5, seq, "every message is processed")
+
+	hops := g.Hops(alice.ref, 2)
+	r.Len(hops, 4)
+	a.True(hops[0].Feed.Equal(alice.ref))
+	a.Equal(0, hops[0].Distance)
+	a.Equal(1, hops[1].Distance)
+	a.Equal(1, hops[2].Distance)
+	a.True(hops[3].Feed.Equal(dave.ref))
+	a.Equal(2, hops[3].Distance)
+
+	a.Len(g.Hops(alice.ref, 1), 3)
+	a.Len(g.Hops(alice.ref, 0), 1)
+
+	d, ok := g.Distance(carol.ref, dave.ref, 3)
+	a.True(ok)
+	a.Equal(3, d)
+	_, ok = g.Distance(carol.ref, dave.ref, 2)
+	a.False(ok)
+
+	// blocking removes them from the hops of alice but not of others
+	alice.publish(g, Message{Type: Type, Contact: dave.ref.URI(), Blocking: yes()})
+	a.True(g.Blocks(alice.ref, dave.ref))
+	a.Len(g.Blocking(alice.ref), 1)
+	_, ok = g.Distance(alice.ref, dave.ref, 5)
+	a.False(ok)
+	d, ok = g.Distance(bob.ref, dave.ref, 5)
+	a.True(ok)
+	a.Equal(1, d)
+
+	// fields that are left out stay the same
+	alice.publish(g, Message{Type: Type, Contact: bob.ref.URI(), Blocking: no()})
+	a.True(g.Follows(alice.ref, bob.ref))
+	alice.publish(g, Message{Type: Type, Contact: bob.ref.URI(), Following: no()})
+	a.False(g.Follows(alice.ref, bob.ref))
+	want := []refs.FeedRef{carol.ref}
+	a.Equal(want, g.Following(alice.ref))
+
+	a.Len(g.Following(dave.ref), 0)
+}
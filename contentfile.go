@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// ErrNoContent is returned by WriteContentTo if the transfer has no content to write
+var ErrNoContent = errors.New("gabbygrove: content not available")
+
+// EncodeFromFile signs a message for the content of the file at path without reading it into memory.
+// The returned transfer only keeps the path, WriteContentTo streams the file from there.
+// Set Content on it to use the transfer as usual.
+func (e *Encoder) EncodeFromFile(sequence uint64, prev BinaryRef, ctype ContentType, path string) (*Transfer, refs.MessageRef, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, refs.MessageRef{}, errors.Wrap(err, "gabbygrove: failed to open content file")
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, refs.MessageRef{}, errors.Wrap(err, "gabbygrove: failed to stat content file")
+	}
+	if n := fi.Size(); n > int64(DefaultLimits.MaxContentSize) {
+		return nil, refs.MessageRef{}, errors.Errorf("gabbygrove: content size too large (got %d bytes)", n)
+	}
+
+	ch := NewContentHasher()
+	if _, err := io.Copy(ch, f); err != nil {
+		return nil, refs.MessageRef{}, errors.Wrap(err, "gabbygrove: failed to hash content file")
+	}
+	if err := ch.Close(); err != nil {
+		return nil, refs.MessageRef{}, err
+	}
+	ref, size, err := ch.Sum()
+	if err != nil {
+		return nil, refs.MessageRef{}, err
+	}
+
+	tr, key, err := e.EncodeWithContentHash(sequence, prev, ctype, ref, size)
+	if err != nil {
+		return nil, refs.MessageRef{}, err
+	}
+	if tr.HasContent() {
+		return tr, key, nil
+	}
+	// don't change a transfer the content lookup might have returned
+	withPath := *tr
+	withPath.contentPath = path
+	return &withPath, key, nil
+}
+
+// WriteContentTo writes the content of the transfer to w.
+// For transfers made by EncodeFromFile, the file is streamed and checked against the event while doing so.
+// If it changed in the meantime, the mismatch is only noticed after all of it was written and reported as an error.
+func (tr *Transfer) WriteContentTo(w io.Writer) (int64, error) {
+	if tr.HasContent() {
+		n, err := w.Write(tr.Content)
+		return int64(n), err
+	}
+	if tr.contentPath == "" {
+		return 0, ErrNoContent
+	}
+
+	evt, err := tr.getEvent()
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := os.Open(tr.contentPath)
+	if err != nil {
+		return 0, errors.Wrap(err, "gabbygrove: failed to open content file")
+	}
+	defer f.Close()
+
+	ch := NewContentHasher()
+	n, err := io.Copy(w, io.TeeReader(f, ch))
+	if err != nil {
+		return n, errors.Wrap(err, "gabbygrove: failed to copy content file")
+	}
+	if err := ch.Close(); err != nil {
+		return n, err
+	}
+	ref, size, err := ch.Sum()
+	if err != nil {
+		return n, err
+	}
+	if size != int(evt.Content.Size) || ref != evt.Content.Hash {
+		return n, errors.Errorf("gabbygrove: content file %s changed since it was encoded", tr.contentPath)
+	}
+	return n, nil
+}
@@ -0,0 +1,74 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeFromFile(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbygrove-contentfile")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	content := bytes.Repeat([]byte("large file "), 5000)
+	path := filepath.Join(dir, "content")
+	r.NoError(ioutil.WriteFile(path, content, 0600))
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	e := NewEncoder(privKey)
+
+	tr, key, err := e.EncodeFromFile(1, BinaryRef{}, ContentTypeArbitrary, path)
+	r.NoError(err)
+	a.Equal(tr.Key(), key)
+	a.False(tr.HasContent())
+	a.True(tr.ContentMatches(content))
+
+	direct, _, err := NewEncoder(privKey).Encode(1, BinaryRef{}, content)
+	r.NoError(err)
+	a.Equal(direct.Event, tr.Event)
+
+	var buf bytes.Buffer
+	n, err := tr.WriteContentTo(&buf)
+	r.NoError(err)
+	a.EqualValues(len(content), n)
+	a.Equal(content, buf.Bytes())
+
+	// the file changed after encoding
+	r.NoError(ioutil.WriteFile(path, bytes.ToUpper(content), 0600))
+	buf.Reset()
+	_, err = tr.WriteContentTo(&buf)
+	a.Error(err)
+
+	// in memory content is written directly
+	buf.Reset()
+	n, err = direct.WriteContentTo(&buf)
+	r.NoError(err)
+	a.EqualValues(len(content), n)
+	a.Equal(content, buf.Bytes())
+
+	direct.Content = nil
+	_, err = direct.WriteContentTo(&buf)
+	a.Equal(ErrNoContent, errors.Cause(err))
+
+	r.NoError(ioutil.WriteFile(path, make([]byte, math.MaxUint16+1), 0600))
+	_, _, err = e.EncodeFromFile(1, BinaryRef{}, ContentTypeArbitrary, path)
+	a.Error(err)
+
+	_, _, err = e.EncodeFromFile(1, BinaryRef{}, ContentTypeArbitrary, filepath.Join(dir, "missing"))
+	a.Error(err)
+}
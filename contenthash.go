@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"hash"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// ContentHasher computes the content reference of everything written to it.
+// This way content that is streamed from disk or the network doesn't need to be read twice, once to hash and once to store it.
+type ContentHasher struct {
+	h    hash.Hash
+	size int
+
+	closed bool
+	ref    BinaryRef
+}
+
+func NewContentHasher() *ContentHasher {
+	return &ContentHasher{h: newSHA256()}
+}
+
+// Write fails if the content gets larger than the limit or the hasher was closed
+func (ch *ContentHasher) Write(p []byte) (int, error) {
+	if ch.closed {
+		return 0, errors.Errorf("gabbygrove/contenthasher: write after close")
+	}
+	if ch.size+len(p) > DefaultLimits.MaxContentSize {
+		return 0, errors.Errorf("gabbygrove: content size too large (got %d bytes)", ch.size+len(p))
+	}
+	ch.size += len(p)
+	return ch.h.Write(p)
+}
+
+// Close finishes the hash, see Sum for the result
+func (ch *ContentHasher) Close() error {
+	if ch.closed {
+		return nil
+	}
+	cr := ContentRef{algo: RefAlgoContentGabby}
+	copy(cr.hash[:], ch.h.Sum(nil))
+	ref, err := fromRef(cr)
+	if err != nil {
+		return err
+	}
+	ch.ref = ref
+	ch.closed = true
+	return nil
+}
+
+// Sum returns the content reference and the size of the written data, once the hasher is closed
+func (ch *ContentHasher) Sum() (BinaryRef, int, error) {
+	if !ch.closed {
+		return BinaryRef{}, 0, errors.Errorf("gabbygrove/contenthasher: not closed")
+	}
+	return ch.ref, ch.size, nil
+}
+
+// EncodeWithContentHash signs a message for content that was hashed beforehand, for instance with a ContentHasher.
+// The returned transfer has no content, it can be attached by setting Content on it.
+func (e *Encoder) EncodeWithContentHash(sequence uint64, prev BinaryRef, ctype ContentType, contentHash BinaryRef, size int) (tr *Transfer, key refs.MessageRef, err error) {
+	defer func() {
+		var n int
+		if tr != nil {
+			n = len(tr.Event)
+		}
+		countMetric(MetricEncode, err != nil, n)
+	}()
+
+	if size < 0 || size > DefaultLimits.MaxContentSize {
+		return nil, refs.MessageRef{}, errors.Errorf("gabbygrove: content size too large (got %d bytes)", size)
+	}
+	if size == 0 && ctype != ContentTypeArbitrary {
+		return nil, refs.MessageRef{}, errors.Wrapf(ErrEmptyContent, "encode: type %d", ctype)
+	}
+	ref, err := contentHash.GetRef(RefTypeContent)
+	if err != nil {
+		return nil, refs.MessageRef{}, errors.Wrap(err, "invalid content hash")
+	}
+
+	return e.encodeHashed(sequence, prev, e.timestamp(), ctype, size, ref.(ContentRef), nil)
+}
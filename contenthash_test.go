@@ -0,0 +1,79 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+func TestContentHasher(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	content := bytes.Repeat([]byte("streamed "), 1000)
+
+	ch := NewContentHasher()
+	_, _, err := ch.Sum()
+	a.Error(err, "sum before close")
+
+	n, err := io.Copy(ch, bytes.NewReader(content))
+	r.NoError(err)
+	a.EqualValues(len(content), n)
+	r.NoError(ch.Close())
+	r.NoError(ch.Close())
+	_, err = ch.Write([]byte("more"))
+	a.Error(err, "write after close")
+
+	ref, size, err := ch.Sum()
+	r.NoError(err)
+	a.Equal(len(content), size)
+	want, err := HashContent(content)
+	r.NoError(err)
+	a.Equal(want, ref)
+
+	pubKey, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	author, err := refs.NewFeedRefFromBytes(pubKey, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+	state := NewFeedState(author)
+	e := NewEncoder(privKey)
+
+	seq, prev := state.Next()
+	tr, key, err := e.EncodeWithContentHash(seq, prev, ContentTypeArbitrary, ref, size)
+	r.NoError(err)
+	a.Nil(tr.Content)
+	a.Equal(tr.Key(), key)
+	a.True(tr.ContentMatches(content))
+	r.NoError(state.Append(tr))
+
+	// the same as encoding the content directly
+	direct, _, err := NewEncoder(privKey).Encode(seq, prev, content)
+	r.NoError(err)
+	a.Equal(direct.Event, tr.Event)
+
+	tr.Content = content
+	r.NoError(tr.Validate(DefaultLimits))
+
+	_, _, err = e.EncodeWithContentHash(2, BinaryRef{}, ContentTypeArbitrary, ref, math.MaxUint16+1)
+	a.Error(err)
+	_, _, err = e.EncodeWithContentHash(2, BinaryRef{}, ContentTypeArbitrary, BinaryRef{}, 5)
+	a.Error(err)
+}
+
+func TestContentHasherTooLarge(t *testing.T) {
+	a := assert.New(t)
+
+	ch := NewContentHasher()
+	_, err := ch.Write(make([]byte, math.MaxUint16))
+	a.NoError(err)
+	_, err = ch.Write([]byte{1})
+	a.Error(err)
+}
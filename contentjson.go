@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotJSON is returned by ContentJSON for content of another type
+var ErrNotJSON = errors.New("gabbygrove: content is not JSON")
+
+// contentCache keeps what loadContent and ContentJSON produced, like lazyEvt does for the event
+type contentCache struct {
+	// file is the content read from contentPath
+	file []byte
+
+	// decoded is the result of ContentJSON for data and a pointer of type typ
+	data    []byte
+	typ     reflect.Type
+	decoded reflect.Value
+}
+
+// loadContent returns the content of the transfer, see ContentBytes
+func (tr *Transfer) loadContent() ([]byte, error) {
+	if tr.HasContent() {
+		return tr.Content, nil
+	}
+	if tr.contentPath == "" {
+		return nil, ErrNoContent
+	}
+	if tr.lazyContent != nil && tr.lazyContent.file != nil {
+		return tr.lazyContent.file, nil
+	}
+
+	var buf bytes.Buffer
+	if _, err := tr.WriteContentTo(&buf); err != nil {
+		return nil, err
+	}
+	if tr.lazyContent == nil {
+		tr.lazyContent = new(contentCache)
+	}
+	tr.lazyContent.file = buf.Bytes()
+	return tr.lazyContent.file, nil
+}
+
+// ContentJSON decodes JSON content into v, like json.Unmarshal does.
+// Content isn't decoded before it's asked for and the result is kept, so calling it again with the same type of v
+// only assigns the earlier result. Maps, slices and pointers in it are shared between those calls, copy them before changing them.
+// It fails with ErrNotJSON for other types of content and ErrNoContent if the content isn't attached.
+func (tr *Transfer) ContentJSON(v interface{}) error {
+	evt, err := tr.getEvent()
+	if err != nil {
+		return err
+	}
+	if evt.Content.Type != ContentTypeJSON {
+		return ErrNotJSON
+	}
+	data, err := tr.loadContent()
+	if err != nil {
+		return err
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.Errorf("gabbygrove: ContentJSON needs a non-nil pointer, not %T", v)
+	}
+	c := tr.lazyContent
+	if c != nil && c.typ == rv.Type() && sameBytes(c.data, data) {
+		rv.Elem().Set(c.decoded)
+		return nil
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.Wrap(err, "gabbygrove: invalid json content")
+	}
+	if c == nil {
+		c = new(contentCache)
+		tr.lazyContent = c
+	}
+	c.data, c.typ = data, rv.Type()
+	c.decoded = reflect.New(rv.Type().Elem()).Elem()
+	c.decoded.Set(rv.Elem())
+	return nil
+}
+
+// sameBytes is true if a and b are the same slice, so a cache made for a still holds after Content was replaced
+func sameBytes(a, b []byte) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentJSON(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, trs := makeTestFeed(t, "json", 2)
+
+	type post struct {
+		Type string
+		I    int
+	}
+	var p post
+	r.NoError(trs[1].ContentJSON(&p))
+	a.Equal(post{Type: "test", I: 1}, p)
+
+	// the second call for the same type isn't decoded again, it gets the kept result
+	trs[1].lazyContent.decoded.Field(1).SetInt(42)
+	var again post
+	r.NoError(trs[1].ContentJSON(&again))
+	a.Equal(42, again.I)
+
+	var generic map[string]interface{}
+	r.NoError(trs[1].ContentJSON(&generic))
+	a.Equal("test", generic["type"])
+
+	// replacing the content drops the cached result
+	changed := *trs[1]
+	changed.Content = []byte(`{"type":"test","i":7}`)
+	r.NoError(changed.ContentJSON(&again))
+	a.Equal(7, again.I)
+
+	a.Error(trs[1].ContentJSON(p), "not a pointer")
+	a.Error(trs[1].ContentJSON((*post)(nil)))
+
+	noContent := *trs[0]
+	noContent.Content = nil
+	noContent.lazyContent = nil
+	a.Equal(ErrNoContent, noContent.ContentJSON(&p))
+	a.Nil(noContent.ContentBytes())
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("json"), 8)))
+	bin, _, err := NewEncoder(privKey).Encode(1, BinaryRef{}, []byte("raw"))
+	r.NoError(err)
+	a.Equal(ErrNotJSON, bin.ContentJSON(&p))
+	a.Equal("raw", string(bin.ContentBytes()))
+
+	broken := *trs[1]
+	broken.Content = []byte(`{"type":`)
+	a.Error(broken.ContentJSON(&p))
+}
+
+func TestContentBytesFromFile(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbygrove-contentjson")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "content.json")
+	r.NoError(ioutil.WriteFile(path, []byte(`{"type":"file","i":3}`), 0600))
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("file"), 8)))
+	tr, _, err := NewEncoder(privKey).EncodeFromFile(1, BinaryRef{}, ContentTypeJSON, path)
+	r.NoError(err)
+	a.False(tr.HasContent())
+
+	var v struct{ I int }
+	r.NoError(tr.ContentJSON(&v))
+	a.Equal(3, v.I)
+
+	// the file is only read once
+	r.NoError(os.Remove(path))
+	a.Equal(`{"type":"file","i":3}`, string(tr.ContentBytes()))
+	r.NoError(tr.ContentJSON(&v))
+}
+
+func BenchmarkContentJSON(b *testing.B) {
+	_, trs := makeTestFeed(b, "json", 1)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var v map[string]interface{}
+		if err := trs[0].ContentJSON(&v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"github.com/pkg/errors"
+)
+
+// DecodeInto decodes the single transfer in buf into dst without allocating, for memory constrained devices.
+// With a nil scratch the fields of dst point into buf, otherwise they are copied into scratch,
+// so buf can be reused. It fails if scratch is too small to hold them.
+//
+// Only the framing of the transfer is decoded, the event is decoded (and allocated) on first use like after UnmarshalCBOR.
+func DecodeInto(dst *Transfer, buf []byte, scratch []byte) error {
+	if len(buf) > maxTransferSize {
+		return errors.Errorf("gabbygrove/transfer: transfer too large")
+	}
+	var newTr Transfer
+	n, err := newTr.parseCBOR(buf, decodeOptions{})
+	if err != nil {
+		return errors.Wrap(err, "gabbygrove/transfer")
+	}
+	if rest := len(buf) - n; rest != 0 {
+		return errors.Errorf("gabbygrove/transfer: %d trailing bytes after transfer", rest)
+	}
+
+	if scratch != nil {
+		need := len(newTr.Event) + len(newTr.Signature) + len(newTr.Content)
+		if len(scratch) < need {
+			return errors.Errorf("gabbygrove/transfer: scratch too small (need %d bytes)", need)
+		}
+		for _, f := range [...]*[]byte{&newTr.Event, &newTr.Signature, &newTr.Content} {
+			if *f == nil {
+				continue
+			}
+			n := copy(scratch, *f)
+			*f = scratch[:n:n]
+			scratch = scratch[n:]
+		}
+	}
+	if err := newTr.checkSizes(DefaultLimits); err != nil {
+		return err
+	}
+	*dst = newTr
+	return nil
+}
@@ -0,0 +1,57 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeInto(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, trs := makeTestFeed(t, "dead", 2)
+	buf, err := trs[1].MarshalCBOR()
+	r.NoError(err)
+
+	var tr Transfer
+	r.NoError(DecodeInto(&tr, buf, nil))
+	a.Equal(trs[1].Key(), tr.Key())
+	a.Equal(trs[1].Content, tr.Content)
+	a.True(tr.Verify(nil))
+
+	scratch := make([]byte, len(buf))
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := DecodeInto(&tr, buf, scratch); err != nil {
+			t.Fatal(err)
+		}
+	})
+	a.EqualValues(0, allocs)
+
+	// doesn't alias buf with scratch
+	for i := range buf {
+		buf[i] = 0
+	}
+	a.Equal(trs[1].Key(), tr.Key())
+	a.True(tr.Verify(nil))
+
+	buf, err = trs[1].MarshalCBOR()
+	r.NoError(err)
+	a.Error(DecodeInto(&tr, buf, make([]byte, 10)), "scratch too small")
+	a.Error(DecodeInto(&tr, buf[:len(buf)-1], nil))
+	a.Error(DecodeInto(&tr, append(buf, 0), nil))
+
+	// without content
+	noContent := *trs[0]
+	noContent.Content = nil
+	buf, err = noContent.MarshalCBOR()
+	r.NoError(err)
+	r.NoError(DecodeInto(&tr, buf, nil))
+	a.Nil(tr.Content)
+	a.Equal(trs[0].Key(), tr.Key())
+}
@@ -0,0 +1,110 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// ErrUnknownFields is returned for transfers and events with more array elements then this version knows about
+var ErrUnknownFields = errors.New("gabbygrove: unknown fields")
+
+const (
+	eventFieldCount    = 5
+	transferFieldCount = 3
+)
+
+// Decoder decodes transfers with options the plain UnmarshalCBOR doesn't offer
+type Decoder struct {
+	rejectUnknown bool
+	filter        AuthorFilter
+	maxAlloc      int
+	jsonPolicy    *JSONPolicy
+}
+
+// NewDecoder returns a decoder that behaves like UnmarshalCBOR until configured otherwise
+func NewDecoder() *Decoder {
+	return &Decoder{}
+}
+
+// WithUnknownFields(false) makes the decoder fail with ErrUnknownFields on transfers and events
+// from a future revision of the format, which append elements to their arrays.
+// By default they are accepted like UnmarshalCBOR does, see Transfer.UnknownFields.
+func (d *Decoder) WithUnknownFields(yes bool) {
+	d.rejectUnknown = !yes
+}
+
+// Decode decodes a single transfer and fails if data holds more then that
+func (d *Decoder) Decode(data []byte) (tr *Transfer, err error) {
+	defer func() { countMetric(MetricDecode, err != nil, len(data)) }()
+
+	tr = new(Transfer)
+	n, err := tr.decodeFrom(data, d.options())
+	if err != nil {
+		return nil, err
+	}
+	if rest := len(data) - n; rest != 0 {
+		return nil, errors.Errorf("gabbygrove/transfer: %d trailing bytes after transfer", rest)
+	}
+	if err := d.filterTransfer(tr); err != nil {
+		return nil, err
+	}
+	return tr, nil
+}
+
+// DecodeFrom reads exactly one transfer from r, see Transfer.DecodeFrom
+func (d *Decoder) DecodeFrom(r io.Reader) (*Transfer, error) {
+	tr := new(Transfer)
+	if _, err := tr.decodeStream(r, d.options()); err != nil {
+		return nil, err
+	}
+	if err := d.filterTransfer(tr); err != nil {
+		return nil, err
+	}
+	return tr, nil
+}
+
+func (d *Decoder) options() decodeOptions {
+	return decodeOptions{rejectUnknown: d.rejectUnknown, budget: d.budget()}
+}
+
+func (d *Decoder) filterTransfer(tr *Transfer) error {
+	if err := checkJSONContent(d.jsonPolicy, tr); err != nil {
+		return err
+	}
+	if d.filter == nil {
+		return nil
+	}
+	evt, err := tr.getEvent()
+	if err != nil {
+		return err
+	}
+	return checkAuthor(d.filter, evt)
+}
+
+// DecodeEvent decodes a single event
+func (d *Decoder) DecodeEvent(data []byte) (*Event, error) {
+	evt := new(Event)
+	if err := evt.decode(data, d.options()); err != nil {
+		return nil, err
+	}
+	if err := checkAuthor(d.filter, evt); err != nil {
+		return nil, err
+	}
+	return evt, nil
+}
+
+// UnknownFields returns the raw array elements this version of the format doesn't know about.
+// They are written back as-is when the transfer is marshaled again, so the message can be passed along intact.
+func (tr *Transfer) UnknownFields() [][]byte {
+	return tr.unknown
+}
+
+// UnknownFields returns the raw array elements this version of the format doesn't know about
+func (evt *Event) UnknownFields() [][]byte {
+	return evt.unknown
+}
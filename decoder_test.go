@@ -0,0 +1,211 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestDecoderUnknownFields(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	pubKey, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("beef"), 8)))
+	author, err := refFromPubKey(pubKey)
+	r.NoError(err)
+
+	content := []byte(`{"type":"test"}`)
+	cr := ContentRef{algo: RefAlgoContentGabby}
+	cr.hash = sha256.Sum256(content)
+	chash, err := fromRef(cr)
+	r.NoError(err)
+
+	// an event from a future revision with an additional field
+	evt := Event{
+		Author:   author,
+		Sequence: 1,
+		Content:  Content{Hash: chash, Size: uint16(len(content)), Type: ContentTypeJSON},
+		unknown:  [][]byte{appendCBORText(nil, "future")},
+	}
+	evtBytes, err := evt.MarshalCBOR()
+	r.NoError(err)
+
+	tr := Transfer{
+		Event:     evtBytes,
+		Signature: ed25519.Sign(privKey, evtBytes),
+		Content:   content,
+		unknown:   [][]byte{appendCBORHead(nil, cborMajorUint, 23)},
+	}
+	trBytes, err := tr.MarshalCBOR()
+	r.NoError(err)
+
+	// kept by default
+	var plain Transfer
+	r.NoError(plain.UnmarshalCBOR(trBytes))
+	a.Len(plain.UnknownFields(), 1)
+	a.True(plain.Verify(nil))
+	a.NoError(plain.DecodeFrom(bytes.NewReader(trBytes)))
+	var plainEvt Event
+	r.NoError(plainEvt.UnmarshalCBOR(evtBytes))
+	a.Len(plainEvt.UnknownFields(), 1)
+
+	// strict on request
+	strict := NewDecoder()
+	strict.WithUnknownFields(false)
+	_, err = strict.Decode(trBytes)
+	a.True(errors.Is(err, ErrUnknownFields), "%+v", err)
+	_, err = strict.DecodeFrom(bytes.NewReader(trBytes))
+	a.True(errors.Is(err, ErrUnknownFields), "%+v", err)
+
+	dec := NewDecoder()
+	got, err := dec.Decode(trBytes)
+	r.NoError(err)
+	a.True(got.Verify(nil))
+	a.EqualValues(1, got.Seq())
+	r.Len(got.UnknownFields(), 1)
+
+	gotEvt, err := got.UnmarshaledEvent()
+	r.NoError(err)
+	r.Len(gotEvt.UnknownFields(), 1)
+	p := cborParser{data: gotEvt.UnknownFields()[0]}
+	future, err := p.text()
+	r.NoError(err)
+	a.Equal("future", future)
+
+	// passed along without destroying it
+	reEncoded, err := got.MarshalCBOR()
+	r.NoError(err)
+	a.Equal(trBytes, reEncoded)
+	reEvt, err := gotEvt.MarshalCBOR()
+	r.NoError(err)
+	a.Equal(evtBytes, reEvt)
+
+	streamed, err := dec.DecodeFrom(bytes.NewReader(trBytes))
+	r.NoError(err)
+	a.Equal(got.Key(), streamed.Key())
+
+	_, err = got.MarshalCompact(false)
+	a.True(errors.Is(err, ErrUnknownFields))
+
+	// known messages are unchanged
+	_, trs := makeTestFeed(t, "dead", 1)
+	b, err := trs[0].MarshalCBOR()
+	r.NoError(err)
+	known, err := strict.Decode(b)
+	r.NoError(err)
+	a.Len(known.UnknownFields(), 0)
+	b2, err := known.MarshalCBOR()
+	r.NoError(err)
+	a.Equal(b, b2)
+}
+
+func TestDecoderCaps(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, trs := makeTestFeed(t, "dead", 1)
+	base := *trs[0]
+
+	dec := NewDecoder()
+	dec.WithUnknownFields(true)
+
+	withUnknown := func(fields ...[]byte) []byte {
+		tr := base
+		tr.unknown = fields
+		b, err := tr.MarshalCBOR()
+		r.NoError(err)
+		return b
+	}
+	// both the buffer and the stream decoder need to agree
+	decode := func(b []byte) error {
+		_, err := dec.Decode(b)
+		_, streamErr := dec.DecodeFrom(bytes.NewReader(b))
+		a.Equal(err == nil, streamErr == nil, "buffer: %v stream: %v", err, streamErr)
+		return err
+	}
+
+	tag := func(n uint64, inner []byte) []byte {
+		return append(appendCBORHead(nil, cborMajorTag, n), inner...)
+	}
+	zero := []byte{0x00}
+
+	a.NoError(decode(withUnknown(tag(5, zero))))
+	a.Error(decode(withUnknown(tag(5, tag(6, zero)))), "nested tags")
+
+	var many [][]byte
+	for i := 0; i < cborMaxUnknownFields; i++ {
+		many = append(many, zero)
+	}
+	a.NoError(decode(withUnknown(many...)))
+	a.Error(decode(withUnknown(append(many, zero)...)), "too many unknown fields")
+
+	deep := zero
+	for i := 0; i <= cborMaxDepth; i++ {
+		deep = append(appendCBORHead(nil, cborMajorArray, 1), deep...)
+	}
+	a.Error(decode(withUnknown(deep)), "nested too deep")
+
+	wide := appendCBORHead(nil, cborMajorArray, cborMaxItems)
+	wide = append(wide, bytes.Repeat(zero, cborMaxItems)...)
+	a.Error(decode(withUnknown(wide)), "too many items")
+
+	// a map that claims more elements than there are bytes
+	huge := appendCBORHead(nil, cborMajorMap, 1<<62)
+	a.Error(decode(withUnknown(huge)))
+}
+
+func TestDecoderMaxAllocation(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	tr, _, err := NewEncoder(privKey).Encode(1, BinaryRef{}, bytes.Repeat([]byte("A"), 4096))
+	r.NoError(err)
+	data, err := tr.MarshalCBOR()
+	r.NoError(err)
+
+	dec := NewDecoder()
+	dec.WithMaxAllocation(1024)
+
+	_, err = dec.Decode(data)
+	a.Equal(ErrAllocationBudget, errors.Cause(err))
+	_, err = dec.DecodeFrom(bytes.NewReader(data))
+	a.Equal(ErrAllocationBudget, errors.Cause(err))
+
+	// the budget is per call
+	dec.WithMaxAllocation(2 * len(data))
+	for i := 0; i < 3; i++ {
+		_, err = dec.Decode(data)
+		r.NoError(err)
+		_, err = dec.DecodeFrom(bytes.NewReader(data))
+		r.NoError(err)
+	}
+
+	// unknown fields count against it too
+	withUnknown := *tr
+	withUnknown.unknown = [][]byte{appendCBORBytes(nil, make([]byte, 2*len(data)))}
+	data, err = withUnknown.MarshalCBOR()
+	r.NoError(err)
+	dec.WithUnknownFields(true)
+	dec.WithMaxAllocation(len(data) / 2)
+	_, err = dec.Decode(data)
+	a.Equal(ErrAllocationBudget, errors.Cause(err))
+
+	dec.WithMaxAllocation(0)
+	_, err = dec.Decode(data)
+	a.NoError(err)
+
+	evtDec := NewDecoder()
+	evtDec.WithMaxAllocation(binrefSize)
+	_, err = evtDec.DecodeEvent(tr.Event)
+	a.Equal(ErrAllocationBudget, errors.Cause(err))
+}
@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ContentLookupFunc returns the already published message carrying content with the passed hash or nil if there is none.
+type ContentLookupFunc func(ContentRef) (*Transfer, error)
+
+// WithContentLookup makes Encode idempotent: if lookup knows a message with the same content,
+// that message is returned instead of signing a new one.
+// This prevents double publishing when an application retries after a crash,
+// but also means the same content can't be published twice on purpose.
+func (e *Encoder) WithContentLookup(lookup ContentLookupFunc) {
+	e.contentLookup = lookup
+}
+
+// MemContentIndex is a simple in-memory index from content hash to message, usable as a ContentLookupFunc
+type MemContentIndex struct {
+	mu sync.Mutex
+	m  map[ContentRef]*Transfer
+}
+
+func NewMemContentIndex() *MemContentIndex {
+	return &MemContentIndex{m: make(map[ContentRef]*Transfer)}
+}
+
+// Add indexes tr under the hash of its content
+func (mci *MemContentIndex) Add(tr *Transfer) error {
+	evt, err := tr.getEvent()
+	if err != nil {
+		return errors.Wrap(err, "content index: invalid event")
+	}
+	cr, err := evt.Content.Hash.GetRef(RefTypeContent)
+	if err != nil {
+		return errors.Wrap(err, "content index: invalid content hash")
+	}
+	mci.mu.Lock()
+	defer mci.mu.Unlock()
+	mci.m[cr.(ContentRef)] = tr
+	return nil
+}
+
+// Lookup implements ContentLookupFunc
+func (mci *MemContentIndex) Lookup(cr ContentRef) (*Transfer, error) {
+	mci.mu.Lock()
+	defer mci.mu.Unlock()
+	return mci.m[cr], nil
+}
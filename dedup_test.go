@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+func TestOutboxDedup(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	pubKey, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	author, err := refs.NewFeedRefFromBytes(pubKey, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+
+	idx := NewMemContentIndex()
+	e := NewEncoder(privKey)
+	e.WithContentLookup(idx.Lookup)
+
+	ob := NewOutbox(e, NewFeedState(author), NewMemOutboxStore())
+
+	post := map[string]interface{}{"type": "post", "text": "hello"}
+	tr1, key1, err := ob.Publish(post)
+	r.NoError(err)
+	r.NoError(idx.Add(tr1))
+
+	// retrying after a crash doesn't create a second message
+	tr2, key2, err := ob.Publish(post)
+	r.NoError(err)
+	a.Equal(key1, key2)
+	a.EqualValues(1, tr2.Seq())
+
+	state := ob.State()
+	a.EqualValues(1, state.Sequence)
+	n, err := ob.Pending()
+	r.NoError(err)
+	a.Equal(1, n)
+
+	// different content still gets published
+	tr3, _, err := ob.Publish(map[string]interface{}{"type": "post", "text": "world"})
+	r.NoError(err)
+	a.EqualValues(2, tr3.Seq())
+}
@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotDeterministic is returned by CheckDeterministic if the same inputs were encoded differently
+var ErrNotDeterministic = errors.New("gabbygrove: encoding is not deterministic")
+
+// WithDeterministic makes the output of the encoder only depend on its key, HMAC secret and extensions
+// and the sequence, previous message and content passed to Encode:
+//
+//   - timestamps are zero, regardless of WithNowTimestamps and WithClock
+//   - the content lookup is skipped, so an earlier message with the same content isn't returned instead
+//
+// The encoding itself has no other inputs: CBOR heads are always the shortest form, extensions are sorted by key
+// and ed25519 signatures don't use a random nonce. JSON content is encoded with encoding/json, which sorts map keys,
+// but values with their own MarshalJSON need to be deterministic themselves, see CheckDeterministic.
+func (e *Encoder) WithDeterministic(yes bool) {
+	e.deterministic = yes
+}
+
+// CheckDeterministic encodes val twice and fails with ErrNotDeterministic if the results differ.
+// Like Preview, it has no side effects: the content lookup is skipped and nothing is committed to a SequenceStore.
+func (e *Encoder) CheckDeterministic(sequence uint64, prev BinaryRef, val interface{}) error {
+	var first []byte
+	for i := 0; i < 2; i++ {
+		ctype, contentBytes, cr, err := encodeContent(val)
+		if err != nil {
+			return err
+		}
+		evtBytes, err := e.eventBytes(sequence, prev, e.timestamp(), ctype, len(contentBytes), cr)
+		if err != nil {
+			return err
+		}
+		sig, err := e.sign(evtBytes)
+		if err != nil {
+			return err
+		}
+		tr := Transfer{Event: evtBytes, Signature: sig, Content: contentBytes}
+		b := tr.appendCBOR(nil)
+		if first == nil {
+			first = b
+		} else if !bytes.Equal(first, b) {
+			return ErrNotDeterministic
+		}
+	}
+	return nil
+}
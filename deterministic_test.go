@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// counter marshals differently every time
+type counter struct{ n *int }
+
+func (c counter) MarshalJSON() ([]byte, error) {
+	*c.n++
+	return []byte(strconv.Itoa(*c.n)), nil
+}
+
+func TestDeterministic(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("beef"), 8)))
+
+	var ticks int64
+	clock := func() time.Time {
+		ticks++
+		return time.Unix(1600000000+ticks, 0)
+	}
+
+	content := map[string]interface{}{"type": "test", "b": 2, "a": 1, "c": []int{1, 2, 3}}
+
+	e := NewEncoder(privKey)
+	e.WithClock(clock)
+	a.Equal(ErrNotDeterministic, e.CheckDeterministic(1, BinaryRef{}, content), "clock")
+
+	var lookups int
+	e.WithContentLookup(func(ContentRef) (*Transfer, error) {
+		lookups++
+		return nil, nil
+	})
+	e.WithDeterministic(true)
+	r.NoError(e.CheckDeterministic(1, BinaryRef{}, content))
+
+	tr1, _, err := e.Encode(1, BinaryRef{}, content)
+	r.NoError(err)
+	tr2, _, err := NewEncoder(privKey).Encode(1, BinaryRef{}, content)
+	r.NoError(err)
+	b1, err := tr1.MarshalCBOR()
+	r.NoError(err)
+	b2, err := tr2.MarshalCBOR()
+	r.NoError(err)
+	a.Equal(b1, b2)
+	a.EqualValues(0, tr1.Claimed().Unix())
+	a.Equal(0, lookups)
+
+	var n int
+	a.Equal(ErrNotDeterministic, e.CheckDeterministic(1, BinaryRef{}, counter{&n}))
+
+	e.WithDeterministic(false)
+	tr3, _, err := e.Encode(1, BinaryRef{}, content)
+	r.NoError(err)
+	a.NotEqual(tr1.Event, tr3.Event)
+	a.Equal(1, lookups)
+}
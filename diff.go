@@ -0,0 +1,153 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// Equal is true if tr and o encode the same message with the same content: the same event, signature
+// and extra elements, and content that is either equal or missing from both.
+// The received time and other local state is ignored. Use Key to compare messages regardless of their content.
+func (tr *Transfer) Equal(o *Transfer) bool {
+	if tr == nil || o == nil {
+		return tr == o
+	}
+	if !bytes.Equal(tr.Event, o.Event) || !bytes.Equal(tr.Signature, o.Signature) {
+		return false
+	}
+	if tr.HasContent() != o.HasContent() || !bytes.Equal(tr.Content, o.Content) {
+		return false
+	}
+	if len(tr.unknown) != len(o.unknown) {
+		return false
+	}
+	for i := range tr.unknown {
+		if !bytes.Equal(tr.unknown[i], o.unknown[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// FeedDiff is the result of DiffFeeds
+type FeedDiff struct {
+	// Diverged is the first sequence at which the feeds differ, 0 if they are equal
+	Diverged uint64
+
+	// Forked is true if both feeds have a message with the same sequence but a different key,
+	// so the author signed two messages for it. Otherwise one side only misses messages or content.
+	Forked bool
+
+	// Differences are the sequences at which the messages aren't Equal, in order
+	Differences []FeedDifference
+}
+
+// FeedDifference is a sequence at which two feeds differ
+type FeedDifference struct {
+	Sequence uint64
+
+	// A and B are the messages of each feed, nil if it doesn't have one with the sequence
+	A, B *Transfer
+}
+
+// DiffFeeds compares two copies of the same feed, i.e. from two stores or exports, that are ordered by sequence.
+// They can start at any sequence and have gaps, a message one of them doesn't have is a difference too.
+// All the differences are kept, so it's best used on parts of a feed when comparing long forks.
+// It fails with ErrWrongAuthor if the messages aren't all of the same author and ErrWrongSequence if they aren't ordered.
+func DiffFeeds(a, b TransferStream) (*FeedDiff, error) {
+	sa := diffSide{s: a, name: "a"}
+	sb := diffSide{s: b, name: "b"}
+
+	var (
+		diff   FeedDiff
+		author *refs.FeedRef
+	)
+	for {
+		for _, side := range []*diffSide{&sa, &sb} {
+			if err := side.fill(&author); err != nil {
+				return nil, err
+			}
+		}
+		if sa.next == nil && sb.next == nil {
+			return &diff, nil
+		}
+
+		var d FeedDifference
+		switch {
+		case sb.next == nil || (sa.next != nil && sa.seq < sb.seq):
+			d.Sequence, d.A = sa.seq, sa.take()
+		case sa.next == nil || sb.seq < sa.seq:
+			d.Sequence, d.B = sb.seq, sb.take()
+		default:
+			d.Sequence, d.A, d.B = sa.seq, sa.take(), sb.take()
+			if d.A.Equal(d.B) {
+				continue
+			}
+			if !d.A.Key().Equal(d.B.Key()) {
+				diff.Forked = true
+			}
+		}
+		if diff.Diverged == 0 {
+			diff.Diverged = d.Sequence
+		}
+		diff.Differences = append(diff.Differences, d)
+	}
+}
+
+// diffSide is one of the streams of DiffFeeds with the transfer that is up next
+type diffSide struct {
+	s    TransferStream
+	name string
+	done bool
+
+	next *Transfer
+	seq  uint64
+}
+
+// fill reads the next transfer if there is none and checks it against author and the one before
+func (ds *diffSide) fill(author **refs.FeedRef) error {
+	if ds.done || ds.next != nil {
+		return nil
+	}
+	tr, err := ds.s.Next()
+	if err == io.EOF {
+		ds.done = true
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "gabbygrove/diff: feed %s", ds.name)
+	}
+	evt, err := tr.getEvent()
+	if err != nil {
+		return errors.Wrapf(err, "gabbygrove/diff: feed %s: invalid event", ds.name)
+	}
+	aref, err := evt.Author.GetRef(RefTypeFeed)
+	if err != nil {
+		return errors.Wrapf(err, "gabbygrove/diff: feed %s: invalid author", ds.name)
+	}
+	fr := aref.(refs.FeedRef)
+	if *author == nil {
+		*author = &fr
+	} else if !fr.Equal(**author) {
+		return errors.Wrapf(ErrWrongAuthor, "diff: feed %s has %s", ds.name, fr.ShortSigil())
+	}
+	if evt.Sequence <= ds.seq {
+		return errors.Wrapf(ErrWrongSequence, "diff: feed %s not ordered at %d", ds.name, evt.Sequence)
+	}
+	ds.next, ds.seq = tr, evt.Sequence
+	return nil
+}
+
+// take hands out the next transfer
+func (ds *diffSide) take() *Transfer {
+	tr := ds.next
+	ds.next = nil
+	return tr
+}
@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferEqual(t *testing.T) {
+	a := assert.New(t)
+
+	_, trs := makeTestFeed(t, "dead", 2)
+	_, again := makeTestFeed(t, "dead", 2)
+
+	a.True(trs[0].Equal(again[0]))
+	a.False(trs[0].Equal(trs[1]))
+	a.False(trs[0].Equal(nil))
+	a.True((*Transfer)(nil).Equal(nil))
+
+	withoutContent := *trs[0]
+	withoutContent.Content = nil
+	a.False(trs[0].Equal(&withoutContent))
+	a.Equal(trs[0].Key(), withoutContent.Key())
+
+	received := *trs[0]
+	received.SetReceived(fakeNow())
+	a.True(trs[0].Equal(&received), "local state is ignored")
+}
+
+func TestDiffFeeds(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, trs := makeTestFeed(t, "dead", 5)
+	_, same := makeTestFeed(t, "dead", 5)
+
+	diff, err := DiffFeeds(SliceStream(trs), SliceStream(same))
+	r.NoError(err)
+	a.Zero(diff.Diverged)
+	a.False(diff.Forked)
+	a.Empty(diff.Differences)
+
+	// b misses the content of 2 and the last message
+	withoutContent := *same[1]
+	withoutContent.Content = nil
+	partial := []*Transfer{same[0], &withoutContent, same[2], same[3]}
+	diff, err = DiffFeeds(SliceStream(trs), SliceStream(partial))
+	r.NoError(err)
+	a.EqualValues(2, diff.Diverged)
+	a.False(diff.Forked)
+	r.Len(diff.Differences, 2)
+	a.EqualValues(2, diff.Differences[0].Sequence)
+	a.Equal(&withoutContent, diff.Differences[0].B)
+	a.EqualValues(5, diff.Differences[1].Sequence)
+	a.Equal(trs[4], diff.Differences[1].A)
+	a.Nil(diff.Differences[1].B)
+
+	// b starts later
+	diff, err = DiffFeeds(SliceStream(trs), SliceStream(same[3:]))
+	r.NoError(err)
+	a.EqualValues(1, diff.Diverged)
+	a.Len(diff.Differences, 3)
+
+	// the author forked the feed after 3
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	e := NewEncoder(privKey)
+	prev, err := fromRef(trs[2].Key())
+	r.NoError(err)
+	fork, _, err := e.Encode(4, prev, "other")
+	r.NoError(err)
+	diff, err = DiffFeeds(SliceStream(trs), SliceStream(append(same[:3:3], fork)))
+	r.NoError(err)
+	a.EqualValues(4, diff.Diverged)
+	a.True(diff.Forked)
+	r.Len(diff.Differences, 2)
+	a.Equal(fork, diff.Differences[0].B)
+
+	// not the same author
+	_, other := makeTestFeed(t, "beef", 2)
+	_, err = DiffFeeds(SliceStream(trs), SliceStream(other))
+	a.Equal(ErrWrongAuthor, errors.Cause(err))
+
+	// not ordered
+	_, err = DiffFeeds(SliceStream([]*Transfer{trs[1], trs[0]}), SliceStream(nil))
+	a.Equal(ErrWrongSequence, errors.Cause(err))
+
+	// errors of the streams are passed on
+	broken := TransferStreamFunc(func() (*Transfer, error) { return nil, io.ErrUnexpectedEOF })
+	_, err = DiffFeeds(SliceStream(trs), broken)
+	a.Equal(io.ErrUnexpectedEOF, errors.Cause(err))
+}
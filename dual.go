@@ -0,0 +1,128 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+)
+
+// DualEncoder publishes the same content on a legacy and a gabbygrove feed in lockstep.
+// Both messages get the same claimed timestamp and the sequence numbers of both feeds are recorded,
+// so that followers of either feed can be pointed to the counterpart during a migration.
+type DualEncoder struct {
+	mu sync.Mutex
+
+	legacy *LegacyEncoder
+	gabby  *Encoder
+
+	legacySeq  uint64
+	legacyPrev *refs.MessageRef
+
+	gabbySeq  uint64
+	gabbyPrev BinaryRef
+
+	// gabby sequence to legacy sequence and back
+	toLegacy map[uint64]uint64
+	toGabby  map[uint64]uint64
+}
+
+// DualMessage holds both halves of a dual publish
+type DualMessage struct {
+	Legacy    *LegacyMessage
+	LegacyKey refs.MessageRef
+
+	Gabby    *Transfer
+	GabbyKey refs.MessageRef
+}
+
+func NewDualEncoder(legacyKey, gabbyKey ed25519.PrivateKey) *DualEncoder {
+	de := &DualEncoder{}
+	de.legacy = NewLegacyEncoder(legacyKey)
+	de.gabby = NewEncoder(gabbyKey)
+	de.toLegacy = make(map[uint64]uint64)
+	de.toGabby = make(map[uint64]uint64)
+	return de
+}
+
+// Gabby returns the underlying gabbygrove encoder, i.e. to configure HMAC signing
+func (de *DualEncoder) Gabby() *Encoder {
+	return de.gabby
+}
+
+// ResumeLegacy continues an existing legacy feed after seq with the message prev
+func (de *DualEncoder) ResumeLegacy(seq uint64, prev refs.MessageRef) {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	de.legacySeq = seq
+	de.legacyPrev = &prev
+}
+
+// ResumeGabby continues an existing gabbygrove feed after seq with the message prev
+func (de *DualEncoder) ResumeGabby(seq uint64, prev BinaryRef) {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	de.gabbySeq = seq
+	de.gabbyPrev = prev
+}
+
+// Publish encodes val as the next message on both feeds.
+// Neither feed advances if one of them fails to encode.
+func (de *DualEncoder) Publish(val interface{}) (*DualMessage, error) {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+
+	claimed := now()
+
+	legacySeq := de.legacySeq + 1
+	lmsg, lkey, err := de.legacy.encode(legacySeq, de.legacyPrev, val, claimed.UnixNano()/1000000)
+	if err != nil {
+		return nil, errors.Wrap(err, "dual publish: legacy feed")
+	}
+
+	gabbySeq := de.gabbySeq + 1
+	tr, gkey, err := de.gabby.encode(gabbySeq, de.gabbyPrev, val, claimed.Unix())
+	if err != nil {
+		return nil, errors.Wrap(err, "dual publish: gabbygrove feed")
+	}
+
+	gabbyPrev, err := fromRef(gkey)
+	if err != nil {
+		return nil, errors.Wrap(err, "dual publish: invalid message reference")
+	}
+
+	de.legacySeq = legacySeq
+	de.legacyPrev = &lkey
+	de.gabbySeq = gabbySeq
+	de.gabbyPrev = gabbyPrev
+	de.toLegacy[gabbySeq] = legacySeq
+	de.toGabby[legacySeq] = gabbySeq
+
+	return &DualMessage{
+		Legacy:    lmsg,
+		LegacyKey: lkey,
+		Gabby:     tr,
+		GabbyKey:  gkey,
+	}, nil
+}
+
+// LegacySequence returns the legacy sequence that was published together with the gabbygrove one
+func (de *DualEncoder) LegacySequence(gabbySeq uint64) (uint64, bool) {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	seq, has := de.toLegacy[gabbySeq]
+	return seq, has
+}
+
+// GabbySequence returns the gabbygrove sequence that was published together with the legacy one
+func (de *DualEncoder) GabbySequence(legacySeq uint64) (uint64, bool) {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	seq, has := de.toGabby[legacySeq]
+	return seq, has
+}
@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDualEncoder(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, legacyKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("beef"), 8)))
+	_, gabbyKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+
+	startTime = time.Date(1969, 12, 31, 23, 59, 55, 0, time.UTC).Unix()
+	now = fakeNow
+
+	de := NewDualEncoder(legacyKey, gabbyKey)
+
+	// pretend the legacy feed already has 3 messages
+	lmsg, lkey, err := NewLegacyEncoder(legacyKey).Encode(1, nil, map[string]interface{}{"type": "test"})
+	r.NoError(err)
+	r.True(lmsg.Verify())
+	de.ResumeLegacy(3, lkey)
+
+	for i := 0; i < 3; i++ {
+		msg, err := de.Publish(map[string]interface{}{
+			"type": "test",
+			"i":    i,
+		})
+		r.NoError(err, "publish %d", i)
+
+		a.True(msg.Legacy.Verify(), "legacy %d did not verify", i)
+		a.True(msg.Gabby.Verify(nil), "gabby %d did not verify", i)
+		a.Equal(msg.GabbyKey, msg.Gabby.Key())
+
+		var lv legacyValue
+		r.NoError(json.Unmarshal(msg.Legacy.Raw, &lv))
+		a.EqualValues(4+i, lv.Sequence)
+		a.EqualValues(1+i, msg.Gabby.Seq())
+		a.Equal(msg.Gabby.Claimed().Unix()*1000, lv.Timestamp, "shared clock")
+
+		lseq, has := de.LegacySequence(uint64(1 + i))
+		a.True(has)
+		a.EqualValues(4+i, lseq)
+
+		gseq, has := de.GabbySequence(lseq)
+		a.True(has)
+		a.EqualValues(1+i, gseq)
+	}
+
+	_, err = de.Publish([]byte("no bytes on legacy"))
+	r.Error(err)
+	_, has := de.LegacySequence(4)
+	a.False(has, "failed publish should not advance")
+}
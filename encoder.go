@@ -6,15 +6,12 @@ package gabbygrove
 
 import (
 	"bytes"
-	"crypto/sha256"
 	"encoding/json"
 	"io"
-	"math"
-	"reflect"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
-	"github.com/ugorji/go/codec"
 	refs "go.mindeco.de/ssb-refs"
 	ssb "go.mindeco.de/ssb-refs"
 	"golang.org/x/crypto/ed25519"
@@ -26,38 +23,73 @@ import (
 // 888 is WIP and currently unused
 const CypherLinkCBORTag = 1050
 
-// GetCBORHandle returns a codec.CborHandle with an extension
-// yet to be registerd for SSB References as CBOR tag XXX
-func GetCBORHandle() (h *codec.CborHandle) {
-	h = new(codec.CborHandle)
-	h.IndefiniteLength = false // no streaming
-	h.Canonical = true         // sort map keys
-	h.SignedInteger = true
-
-	h.StructToArray = true
-
-	var cExt BinRefExt
-	h.SetInterfaceExt(reflect.TypeOf(&BinaryRef{}), CypherLinkCBORTag, cExt)
-	return h
+func NewEncoder(author ed25519.PrivateKey) *Encoder {
+	return NewSignerEncoder(privateKeySigner(author))
 }
 
-func NewEncoder(author ed25519.PrivateKey) *Encoder {
+// NewSignerEncoder returns an encoder that leaves the signing to s, see KeyPair
+func NewSignerEncoder(s Signer) *Encoder {
 	pe := &Encoder{}
-	pe.privKey = author
+	pe.signer = s
 	return pe
 }
 
+// Encoder signs new messages of one feed.
+// Once it's configured, Encode can be called concurrently, except with WithFeedState or WithSequenceStore,
+// whose callers need to make sure only one message is signed at a time, see Outbox.
 type Encoder struct {
-	privKey ed25519.PrivateKey
+	signer Signer
+	audit  *signatureAudit
 
 	hmacSecret   *[32]byte
 	setTimestamp bool
+	clock        func() time.Time
+
+	deterministic bool
+
+	contentLookup ContentLookupFunc
+	seqStore      SequenceStore
+	feedState     *FeedState
+
+	// mu guards the state Encode keeps between calls, lastSeq, lastTimestamp and keyChecked
+	mu sync.Mutex
+
+	timestampOrder TimestampOrder
+	lastSeq        uint64
+	lastTimestamp  int64
+
+	extensions Extensions
+	jsonPolicy *JSONPolicy
+	hybrid     *hybridSigner
+
+	signingHook SigningAuditHook
+
+	legacyLookup LegacyFeedLookupFunc
+	keyChecked   bool
 }
 
 func (e *Encoder) WithNowTimestamps(yes bool) {
 	e.setTimestamp = yes
 }
 
+// WithClock makes the encoder set timestamps from now instead of the system clock, i.e. a fake clock in tests
+func (e *Encoder) WithClock(now func() time.Time) {
+	e.setTimestamp = true
+	e.clock = now
+}
+
+// timestamp returns the claimed time for a new event in seconds, zero unless timestamps are enabled
+func (e *Encoder) timestamp() int64 {
+	switch {
+	case e.deterministic, !e.setTimestamp:
+		return 0
+	case e.clock != nil:
+		return e.clock().Unix()
+	default:
+		return now().Unix()
+	}
+}
+
 func (e *Encoder) WithHMAC(in []byte) error {
 	var k [32]byte
 	n := copy(k[:], in)
@@ -71,80 +103,235 @@ func (e *Encoder) WithHMAC(in []byte) error {
 var now = time.Now
 
 func (e *Encoder) Encode(sequence uint64, prev BinaryRef, val interface{}) (*Transfer, refs.MessageRef, error) {
-	contentHash := sha256.New()
+	return e.encode(sequence, prev, val, e.timestamp())
+}
+
+// encode does the actual work of Encode with an explicit timestamp (in seconds)
+func (e *Encoder) encode(sequence uint64, prev BinaryRef, val interface{}, timestamp int64) (tr *Transfer, key refs.MessageRef, err error) {
+	defer func() {
+		var n int
+		if tr != nil {
+			n = len(tr.Event) + len(tr.Content)
+		}
+		countMetric(MetricEncode, err != nil, n)
+	}()
+
+	ctype, contentBytes, cr, err := encodeContent(val)
+	if err != nil {
+		return nil, refs.MessageRef{}, err
+	}
+	if e.jsonPolicy != nil && ctype == ContentTypeJSON {
+		if err := e.jsonPolicy.Check(contentBytes); err != nil {
+			return nil, refs.MessageRef{}, err
+		}
+	}
+	return e.encodeHashed(sequence, prev, timestamp, ctype, len(contentBytes), cr, contentBytes)
+}
+
+// encodeHashed signs the event for already hashed content, which might not be at hand (nil)
+func (e *Encoder) encodeHashed(sequence uint64, prev BinaryRef, timestamp int64, ctype ContentType, size int, cr ContentRef, contentBytes []byte) (*Transfer, refs.MessageRef, error) {
+	if err := e.checkKeyReuse(); err != nil {
+		return nil, refs.MessageRef{}, err
+	}
+
+	if e.contentLookup != nil && !e.deterministic {
+		existing, err := e.contentLookup(cr)
+		if err != nil {
+			return nil, refs.MessageRef{}, errors.Wrap(err, "content lookup failed")
+		}
+		if existing != nil {
+			return existing, existing.Key(), nil
+		}
+	}
+
+	if err := e.checkTip(sequence, prev); err != nil {
+		return nil, refs.MessageRef{}, err
+	}
+	timestamp, err := e.orderTimestamp(sequence, timestamp)
+	if err != nil {
+		return nil, refs.MessageRef{}, err
+	}
+	evtBytes, err := e.eventBytes(sequence, prev, timestamp, ctype, size, cr)
+	if err != nil {
+		return nil, refs.MessageRef{}, err
+	}
+
+	var newTr Transfer
+	newTr.Event = evtBytes
+	newTr.Signature, err = e.sign(evtBytes)
+	if err != nil {
+		return nil, refs.MessageRef{}, err
+	}
+	newTr.Content = contentBytes
+	if e.hybrid != nil {
+		if err := e.signHybrid(&newTr); err != nil {
+			return nil, refs.MessageRef{}, err
+		}
+	}
+	key := newTr.Key()
+
+	var end feedEnd
+	switch {
+	case e.feedState != nil:
+		if end, err = e.feedState.check(&newTr); err != nil {
+			return nil, refs.MessageRef{}, errors.Wrap(err, "feed state")
+		}
+	case e.seqStore != nil:
+		if end, err = endOf(&newTr, sequence, false); err != nil {
+			return nil, refs.MessageRef{}, err
+		}
+	}
+	if err := e.auditSigning(sequence, prev, cr, key); err != nil {
+		return nil, refs.MessageRef{}, err
+	}
+	if e.seqStore != nil {
+		committed := FeedState{Sequence: sequence, Tip: &key, Terminated: end.terminated, Revoked: end.revoked}
+		if err := commitState(e.seqStore, committed); err != nil {
+			return nil, refs.MessageRef{}, errors.Wrap(err, "failed to commit sequence")
+		}
+	}
+	if e.feedState != nil {
+		e.feedState.advance(key, end)
+	}
+	e.signedTimestamp(sequence, timestamp)
+	return &newTr, key, nil
+}
+
+// encodeContent serializes val and hashes the result
+func encodeContent(val interface{}) (ContentType, []byte, ContentRef, error) {
+	contentHash := newSHA256()
 	contentBuf := &bytes.Buffer{}
 	w := io.MultiWriter(contentHash, contentBuf)
 
-	// fill the fields of the new event
-	var evt Event
-
+	var ctype ContentType
 	switch tv := val.(type) {
 	case []byte:
-		evt.Content.Type = ContentTypeArbitrary
+		ctype = ContentTypeArbitrary
 		io.Copy(w, bytes.NewReader(tv))
 	default:
-		evt.Content.Type = ContentTypeJSON
+		ctype = ContentTypeJSON
 		err := json.NewEncoder(w).Encode(val)
 		if err != nil {
-			return nil, refs.MessageRef{}, errors.Wrap(err, "json content encoding failed")
+			return 0, nil, ContentRef{}, errors.Wrap(err, "json content encoding failed")
 		}
 	}
 
-	if sequence > 1 {
-		evt.Previous = &prev
+	n := contentBuf.Len()
+	if n > DefaultLimits.MaxContentSize {
+		return 0, nil, ContentRef{}, errors.Errorf("gabbygrove: content size too large (got %d bytes)", n)
 	}
-	evt.Sequence = sequence
-	if e.setTimestamp {
-		evt.Timestamp = now().Unix()
+	contentBytes := contentBuf.Bytes()
+	if contentBytes == nil {
+		// nothing was written, it's empty content and not missing
+		contentBytes = []byte{}
 	}
 
-	var err error
-	evt.Author, err = refFromPubKey(e.privKey.Public().(ed25519.PublicKey))
-	if err != nil {
-		return nil, refs.MessageRef{}, errors.Wrap(err, "invalid author ref")
+	cr := ContentRef{
+		algo: RefAlgoContentGabby,
 	}
+	copy(cr.hash[:], contentHash.Sum(nil))
+	return ctype, contentBytes, cr, nil
+}
 
+// HashContent returns the reference an event uses to point to data.
+// It fails if data is larger than the content limit.
+func HashContent(data []byte) (BinaryRef, error) {
+	if n := len(data); n > DefaultLimits.MaxContentSize {
+		return BinaryRef{}, errors.Errorf("gabbygrove: content size too large (got %d bytes)", n)
+	}
 	cr := ContentRef{
+		hash: sum256(data),
 		algo: RefAlgoContentGabby,
 	}
-	copy(cr.hash[:], contentHash.Sum(nil))
+	return fromRef(cr)
+}
 
-	evt.Content.Hash, err = fromRef(cr)
+// ContentMatches returns true if data has the size and hash the event of tr announces.
+// It doesn't look at tr.Content, so content that is stored separately can be checked before it's attached.
+func (tr *Transfer) ContentMatches(data []byte) bool {
+	evt, err := tr.getEvent()
+	if err != nil {
+		return false
+	}
+	if len(data) != int(evt.Content.Size) {
+		return false
+	}
+	want, err := evt.Content.Hash.GetRef(RefTypeContent)
+	if err != nil {
+		return false
+	}
+	got, err := HashContent(data)
 	if err != nil {
-		return nil, refs.MessageRef{}, errors.Wrap(err, "failed to construct content reference")
+		return false
 	}
+	return got.r.(ContentRef) == want.(ContentRef)
+}
 
-	n := contentBuf.Len()
-	if n > math.MaxUint16 {
-		return nil, refs.MessageRef{}, errors.Errorf("gabbygrove: content size too large (got %d bytes)", n)
+// eventBytes fills the fields of the new event and encodes it
+func (e *Encoder) eventBytes(sequence uint64, prev BinaryRef, timestamp int64, ctype ContentType, size int, cr ContentRef) ([]byte, error) {
+	if sequence > DefaultLimits.MaxSequence {
+		return nil, errors.Errorf("gabbygrove: sequence %d out of range", sequence)
+	}
+
+	var evt Event
+	if sequence > 1 {
+		evt.Previous = &prev
+	}
+	evt.Sequence = sequence
+	evt.Timestamp = timestamp
+	evt.Extensions = e.eventExtensions()
+
+	var err error
+	evt.Author, err = refFromPubKey(e.signer.Public())
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid author ref")
+	}
+
+	evt.Content.Type = ctype
+	evt.Content.Size = uint16(size)
+	evt.Content.Hash, err = fromRef(cr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to construct content reference")
 	}
-	evt.Content.Size = uint16(n)
-	contentBytes := contentBuf.Bytes()
 
 	evtBytes, err := evt.MarshalCBOR()
 	if err != nil {
-		return nil, refs.MessageRef{}, errors.Wrap(err, "failed to encode event")
+		return nil, errors.Wrap(err, "failed to encode event")
 	}
+	return evtBytes, nil
+}
 
-	toSign := evtBytes
-	if e.hmacSecret != nil {
-		mac := auth.Sum(evtBytes, e.hmacSecret)
-		toSign = mac[:]
+func (e *Encoder) sign(evtBytes []byte) ([]byte, error) {
+	if _, err := signerSuite(e.signer); err != nil {
+		return nil, err
 	}
+	toSign := e.toSign(evtBytes)
+	sig, err := e.signer.Sign(toSign)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to sign event")
+	}
+	if e.audit != nil {
+		if err := e.audit.check(e.signer, toSign, sig); err != nil {
+			debugLog("event", "sign", "err", err)
+			return nil, err
+		}
+	}
+	return sig, nil
+}
 
-	var tr Transfer
-	tr.Event = evtBytes
-	tr.Signature = ed25519.Sign(e.privKey, toSign)
-	tr.Content = contentBytes
-	return &tr, tr.Key(), nil
+// toSign returns the bytes that are signed for an event, its HMAC if a key is set
+func (e *Encoder) toSign(evtBytes []byte) []byte {
+	if e.hmacSecret == nil {
+		return evtBytes
+	}
+	mac := auth.Sum(evtBytes, e.hmacSecret)
+	return mac[:]
 }
 
 func (tr Transfer) Key() refs.MessageRef {
-	signedEvtHash := sha256.New()
-	io.Copy(signedEvtHash, bytes.NewReader(tr.Event))
-	io.Copy(signedEvtHash, bytes.NewReader(tr.Signature))
+	signedEvtHash := sum256(tr.Event, tr.Signature)
 
-	mr, err := refs.NewMessageRefFromBytes(signedEvtHash.Sum(nil), ssb.RefAlgoMessageGabby)
+	mr, err := refs.NewMessageRefFromBytes(signedEvtHash[:], ssb.RefAlgoMessageGabby)
 	if err != nil {
 		panic(err)
 	}
@@ -15,7 +15,6 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	"github.com/ugorji/go/codec"
 	refs "go.mindeco.de/ssb-refs"
 	ssb "go.mindeco.de/ssb-refs"
 	"golang.org/x/crypto/ed25519"
@@ -129,7 +128,7 @@ func TestEncoder(t *testing.T) {
 	}
 }
 
-func TestEvtDecode(t *testing.T) {
+func TestEvtUnmarshalCBOR(t *testing.T) {
 	r := require.New(t)
 	a := assert.New(t)
 
@@ -140,8 +139,7 @@ func TestEvtDecode(t *testing.T) {
 	r.NotNil(data)
 
 	var evt Event
-	evtDec := codec.NewDecoder(bytes.NewReader(data), GetCBORHandle())
-	err = evtDec.Decode(&evt)
+	err = evt.UnmarshalCBOR(data)
 	r.NoError(err, "decode failed")
 	a.NotNil(evt.Author)
 	a.NotNil(evt.Previous)
@@ -150,6 +148,56 @@ func TestEvtDecode(t *testing.T) {
 	a.Equal(uint64(3), evt.Sequence)
 	a.EqualValues(-3, evt.Timestamp)
 }
+
+func TestDecodedEvent(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, trs := makeTestFeed(t, "dead", 2)
+	tr := trs[1]
+	r.NoError(tr.Validate(DefaultLimits))
+
+	evt, err := tr.DecodedEvent()
+	r.NoError(err)
+	a.Equal(uint64(2), evt.Sequence)
+	a.Equal(*tr.Previous(), evt.Previous.r)
+
+	// changing the copy doesn't change the transfer
+	evt.Sequence = 23
+	evt.Previous = nil
+	a.EqualValues(2, tr.Seq())
+	a.NotNil(tr.Previous())
+
+	again, err := tr.DecodedEvent()
+	r.NoError(err)
+	a.Equal(uint64(2), again.Sequence)
+
+	// the plain ref types
+	prev, ok := again.PreviousMessageRef()
+	a.True(ok)
+	a.Equal(*tr.Previous(), prev)
+	a.Equal(tr.Author(), again.AuthorFeedRef())
+	hash, err := HashContent(tr.Content)
+	r.NoError(err)
+	cref := again.ContentRef()
+	a.Equal(RefAlgoContentGabby, cref.Algo())
+	var got [32]byte
+	r.NoError(cref.CopyHashTo(got[:]))
+	a.Equal(hash.r.(ContentRef).hash, got)
+
+	first, err := trs[0].DecodedEvent()
+	r.NoError(err)
+	_, ok = first.PreviousMessageRef()
+	a.False(ok)
+	var empty Event
+	a.Equal(refs.FeedRef{}, empty.AuthorFeedRef())
+	a.Equal(refs.MessageRef{}, empty.ContentRef())
+
+	broken := Transfer{Event: []byte{0xff}}
+	_, err = broken.DecodedEvent()
+	a.Error(err)
+}
+
 func TestEncodeLargestMsg(t *testing.T) {
 	r := require.New(t)
 	dead := bytes.Repeat([]byte("dead"), 8)
@@ -222,6 +270,52 @@ func TestDecodeContentTooLarge(t *testing.T) {
 
 }
 
+func TestContentMatches(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, trs := makeTestFeed(t, "dead", 1)
+	tr := trs[0]
+
+	ref, err := HashContent(tr.Content)
+	r.NoError(err)
+	evt, err := tr.UnmarshaledEvent()
+	r.NoError(err)
+	a.Equal(evt.Content.Hash, ref)
+
+	// content stored somewhere else
+	stored := append([]byte{}, tr.Content...)
+	tr.Content = nil
+	a.True(tr.ContentMatches(stored))
+	a.False(tr.ContentMatches(nil))
+	a.False(tr.ContentMatches(stored[1:]))
+	stored[0] ^= 1
+	a.False(tr.ContentMatches(stored))
+
+	_, err = HashContent(make([]byte, math.MaxUint16+1))
+	a.Error(err)
+}
+
+func TestEncoderWithClock(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	e := NewEncoder(privKey)
+
+	fake := time.Unix(1600000000, 0)
+	e.WithClock(func() time.Time { return fake })
+
+	tr, _, err := e.Encode(1, BinaryRef{}, []byte("tick"))
+	r.NoError(err)
+	a.Equal(fake, tr.Claimed())
+
+	e.WithNowTimestamps(false)
+	tr, _, err = e.Encode(1, BinaryRef{}, []byte("tock"))
+	r.NoError(err)
+	a.EqualValues(0, tr.Claimed().Unix())
+}
+
 func benchmarkEncoder(i int, b *testing.B) {
 	r := require.New(b)
 
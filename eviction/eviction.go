@@ -0,0 +1,239 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+// Package eviction decides what a replica with little storage drops first, the oldest messages.
+//
+// Content is off-chain in gabbygrove, so it can be dropped from any message without breaking the feed.
+// Only the content of the tip and of checkpointed messages is kept. The events are what verification needs:
+// they only link to their previous one (there are no lipmaa links to skip along), so a feed verifies from its start
+// or from a trusted checkpoint to its tip and all events in between are retained.
+// Before the newest checkpoint of a feed whole transfers can be dropped if the Planner is allowed to.
+package eviction
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// Message is what the planner needs to know about one stored message
+type Message struct {
+	Sequence uint64
+
+	// Time orders the messages, i.e. when it was received
+	Time time.Time
+
+	// EventSize is the size of the event and its signature, ContentSize the one of the content that is stored
+	EventSize, ContentSize int64
+}
+
+// MessageOf returns the message for a stored transfer, its time is the received one or the claimed one without it
+func MessageOf(tr *gabbygrove.Transfer) Message {
+	m := Message{
+		Sequence:    uint64(tr.Seq()),
+		EventSize:   int64(len(tr.Event) + len(tr.Signature)),
+		ContentSize: int64(len(tr.Content)),
+	}
+	if rcv, has := tr.ReceivedAt(); has {
+		m.Time = rcv
+	} else {
+		m.Time = tr.Claimed()
+	}
+	return m
+}
+
+// Feed is a stored feed
+type Feed struct {
+	Author refs.FeedRef
+
+	// Tip is the sequence of the latest message
+	Tip uint64
+
+	// Checkpoints are the sequences of the messages that trusted checkpoints vouch for, see gabbygrove.Checkpoint
+	Checkpoints []uint64
+
+	// Messages are the messages that are stored, they don't need to be complete or in order
+	Messages []Message
+}
+
+// Kind is what an Action drops
+type Kind int
+
+const (
+	// DropContent drops the content of a message and keeps its event
+	DropContent Kind = iota + 1
+
+	// DropTransfer drops the whole message
+	DropTransfer
+)
+
+func (k Kind) String() string {
+	switch k {
+	case DropContent:
+		return "content"
+	case DropTransfer:
+		return "transfer"
+	}
+	return "unknown"
+}
+
+// Action is one thing to drop
+type Action struct {
+	Kind     Kind
+	Author   refs.FeedRef
+	Sequence uint64
+
+	// Frees is how many bytes it frees
+	Frees int64
+}
+
+// Result is what Plan decided
+type Result struct {
+	Actions []Action
+
+	// Size is how large the feeds are after the actions
+	Size int64
+
+	// Fits is false if the feeds don't fit the budget even after the actions
+	Fits bool
+}
+
+// Planner decides what to drop to fit a budget
+type Planner struct {
+	budget    int64
+	transfers bool
+}
+
+// NewPlanner returns a planner that fits feeds into budget bytes by dropping content
+func NewPlanner(budget int64) *Planner {
+	return &Planner{budget: budget}
+}
+
+// WithTransfers allows dropping whole messages that come before the newest checkpoint of their feed,
+// once dropping content isn't enough. The replica then trusts the checkpoint for the start of the feed.
+func (p *Planner) WithTransfers(allow bool) {
+	p.transfers = allow
+}
+
+type candidate struct {
+	feed *Feed
+	msg  Message
+}
+
+// Plan returns what to drop so feeds fit the budget, the oldest messages first.
+// Content goes first, whole messages only after all the content that can go went.
+func (p *Planner) Plan(feeds []Feed) Result {
+	var (
+		size     int64
+		contents []candidate
+		events   []candidate
+	)
+	for i := range feeds {
+		f := &feeds[i]
+		var newest uint64
+		keep := make(map[uint64]bool, len(f.Checkpoints)+1)
+		keep[f.Tip] = true
+		for _, cp := range f.Checkpoints {
+			keep[cp] = true
+			if cp > newest && cp <= f.Tip {
+				newest = cp
+			}
+		}
+		for _, m := range f.Messages {
+			size += m.EventSize + m.ContentSize
+			if keep[m.Sequence] {
+				continue
+			}
+			if m.ContentSize > 0 {
+				contents = append(contents, candidate{feed: f, msg: m})
+			}
+			if m.Sequence < newest {
+				events = append(events, candidate{feed: f, msg: m})
+			}
+		}
+	}
+
+	res := Result{Size: size}
+	if size <= p.budget {
+		res.Fits = true
+		return res
+	}
+
+	sortOldest(contents)
+	dropped := make(map[*Feed]map[uint64]bool)
+	for _, c := range contents {
+		if res.Size <= p.budget {
+			break
+		}
+		res.Actions = append(res.Actions, Action{Kind: DropContent, Author: c.feed.Author, Sequence: c.msg.Sequence, Frees: c.msg.ContentSize})
+		res.Size -= c.msg.ContentSize
+		if dropped[c.feed] == nil {
+			dropped[c.feed] = make(map[uint64]bool)
+		}
+		dropped[c.feed][c.msg.Sequence] = true
+	}
+
+	if p.transfers {
+		sortOldest(events)
+		for _, c := range events {
+			if res.Size <= p.budget {
+				break
+			}
+			frees := c.msg.EventSize
+			if !dropped[c.feed][c.msg.Sequence] {
+				frees += c.msg.ContentSize
+			}
+			res.Actions = append(res.Actions, Action{Kind: DropTransfer, Author: c.feed.Author, Sequence: c.msg.Sequence, Frees: frees})
+			res.Size -= frees
+		}
+	}
+	res.Fits = res.Size <= p.budget
+	return res
+}
+
+func sortOldest(cs []candidate) {
+	sort.SliceStable(cs, func(i, j int) bool {
+		if !cs[i].msg.Time.Equal(cs[j].msg.Time) {
+			return cs[i].msg.Time.Before(cs[j].msg.Time)
+		}
+		return cs[i].msg.Sequence < cs[j].msg.Sequence
+	})
+}
+
+// ContentDeleter drops the content of messages, i.e. a store.Store
+type ContentDeleter interface {
+	DeleteContent(author refs.FeedRef, seq uint64) error
+}
+
+// TransferDeleter drops whole messages
+type TransferDeleter interface {
+	DeleteTransfer(author refs.FeedRef, seq uint64) error
+}
+
+// Apply carries out the actions, d needs to be a TransferDeleter as well if there are DropTransfer actions.
+// It stops at the first error.
+func Apply(d ContentDeleter, actions []Action) error {
+	for _, a := range actions {
+		var err error
+		switch a.Kind {
+		case DropContent:
+			err = d.DeleteContent(a.Author, a.Sequence)
+		case DropTransfer:
+			td, ok := d.(TransferDeleter)
+			if !ok {
+				return errors.Errorf("eviction: can't drop transfers")
+			}
+			err = td.DeleteTransfer(a.Author, a.Sequence)
+		default:
+			return errors.Errorf("eviction: unknown action %d", a.Kind)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "eviction: failed to drop %s of %d", a.Kind, a.Sequence)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package eviction
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mindeco.de/ssb-gabbygrove/gabbygrovetest"
+	"go.mindeco.de/ssb-gabbygrove/store"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+func testFeed(author refs.FeedRef, start time.Time, n int, checkpoints ...uint64) Feed {
+	f := Feed{Author: author, Tip: uint64(n), Checkpoints: checkpoints}
+	for i := 1; i <= n; i++ {
+		f.Messages = append(f.Messages, Message{
+			Sequence:    uint64(i),
+			Time:        start.Add(time.Duration(i) * time.Hour),
+			EventSize:   100,
+			ContentSize: 1000,
+		})
+	}
+	return f
+}
+
+func TestPlan(t *testing.T) {
+	a := assert.New(t)
+
+	alice := gabbygrovetest.NewFeedBuilder("alice").Author()
+	bob := gabbygrovetest.NewFeedBuilder("bob").Author()
+	start := time.Unix(1600000000, 0)
+	feeds := []Feed{
+		testFeed(alice, start, 5, 2),
+		testFeed(bob, start.Add(30*time.Minute), 3),
+	}
+
+	res := NewPlanner(10000).Plan(feeds)
+	a.True(res.Fits)
+	a.Empty(res.Actions)
+	a.EqualValues(8800, res.Size)
+
+	// the oldest content goes first, alternating between the feeds
+	res = NewPlanner(6000).Plan(feeds)
+	a.True(res.Fits)
+	a.EqualValues(5800, res.Size)
+	if a.Len(res.Actions, 3) {
+		a.Equal(Action{Kind: DropContent, Author: alice, Sequence: 1, Frees: 1000}, res.Actions[0])
+		a.Equal(Action{Kind: DropContent, Author: bob, Sequence: 1, Frees: 1000}, res.Actions[1])
+		a.Equal(Action{Kind: DropContent, Author: bob, Sequence: 2, Frees: 1000}, res.Actions[2], "2 of alice is checkpointed")
+	}
+
+	// tips and checkpoints keep their content
+	res = NewPlanner(0).Plan(feeds)
+	a.False(res.Fits)
+	a.Len(res.Actions, 5)
+	a.EqualValues(800+3000, res.Size)
+
+	// with transfers, only what's before the checkpoint can go
+	p := NewPlanner(0)
+	p.WithTransfers(true)
+	res = p.Plan(feeds)
+	a.False(res.Fits)
+	if a.Len(res.Actions, 6) {
+		a.Equal(Action{Kind: DropTransfer, Author: alice, Sequence: 1, Frees: 100}, res.Actions[5])
+	}
+	a.EqualValues(700+3000, res.Size)
+}
+
+func TestApply(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "eviction")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	s, err := store.Open(dir)
+	r.NoError(err)
+	defer s.Close()
+
+	b := gabbygrovetest.Fixture(t, "alice", 4)
+	f := Feed{Author: b.Author(), Tip: 4}
+	var size int64
+	for _, tr := range b.Messages() {
+		r.NoError(s.Append(tr))
+		m := MessageOf(tr)
+		size += m.EventSize + m.ContentSize
+		f.Messages = append(f.Messages, m)
+	}
+	a.True(f.Messages[0].Time.Before(f.Messages[1].Time))
+
+	p := NewPlanner(size - 1)
+	res := p.Plan([]Feed{f})
+	a.True(res.Fits)
+	r.Len(res.Actions, 1)
+	r.NoError(Apply(s, res.Actions))
+
+	tr, err := s.Get(b.Author(), 1)
+	r.NoError(err)
+	a.Nil(tr.Content)
+	tr, err = s.Get(b.Author(), 2)
+	r.NoError(err)
+	a.NotNil(tr.Content)
+
+	a.Error(Apply(s, []Action{{Kind: DropTransfer, Author: b.Author(), Sequence: 1}}), "the store can't drop transfers")
+}
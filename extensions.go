@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Extensions is small application metadata (like a region or an app id) that is signed as part of the event.
+// It's encoded as a CBOR map in a sixth element of the event array.
+// Events without extensions leave it out and are encoded exactly as before.
+type Extensions map[string][]byte
+
+// maxExtensionsSize is the largest encoded extensions map in bytes
+const maxExtensionsSize = 256
+
+// WithExtensions attaches ext to all events encoded from now on, nil removes them again.
+func (e *Encoder) WithExtensions(ext Extensions) error {
+	if len(ext) == 0 {
+		e.extensions = nil
+		return nil
+	}
+	if _, err := ext.marshal(); err != nil {
+		return err
+	}
+	e.extensions = ext
+	return nil
+}
+
+// Extensions returns the extensions of the signed event, nil if it has none
+func (tr *Transfer) Extensions() (Extensions, error) {
+	evt, err := tr.getEvent()
+	if err != nil {
+		return nil, err
+	}
+	return evt.Extensions, nil
+}
+
+func (ext Extensions) marshal() ([]byte, error) {
+	b := ext.appendCBOR(nil)
+	if n := len(b); n > maxExtensionsSize {
+		return nil, errors.Errorf("gabbygrove/extensions: too large (%d bytes)", n)
+	}
+	return b, nil
+}
+
+func decodeExtensions(raw []byte) (Extensions, error) {
+	if n := len(raw); n > maxExtensionsSize {
+		return nil, errors.Errorf("gabbygrove/extensions: too large (%d bytes)", n)
+	}
+	ext, err := parseExtensions(raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "gabbygrove/extensions: failed to decode")
+	}
+	if len(ext) == 0 {
+		return nil, errors.Errorf("gabbygrove/extensions: empty map needs to be left out")
+	}
+	return ext, nil
+}
@@ -0,0 +1,70 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtensions(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	e := NewEncoder(privKey)
+
+	plain, _, err := e.Encode(1, BinaryRef{}, "hello")
+	r.NoError(err)
+
+	r.NoError(e.WithExtensions(Extensions{"region": []byte("eu"), "app": []byte("chess")}))
+	tr, key, err := e.Encode(1, BinaryRef{}, "hello")
+	r.NoError(err)
+	a.NotEqual(plain.Key(), key)
+	a.True(len(tr.Event) > len(plain.Event))
+	a.True(tr.Verify(nil))
+
+	// survives the wire
+	b, err := tr.MarshalCBOR()
+	r.NoError(err)
+	var got Transfer
+	r.NoError(got.UnmarshalCBOR(b))
+	ext, err := got.Extensions()
+	r.NoError(err)
+	a.Equal("eu", string(ext["region"]))
+	a.Equal("chess", string(ext["app"]))
+	r.NoError(got.Validate(DefaultLimits))
+
+	// canonical re-encoding
+	evt, err := got.UnmarshaledEvent()
+	r.NoError(err)
+	evtBytes, err := evt.MarshalCBOR()
+	r.NoError(err)
+	a.Equal(tr.Event, evtBytes)
+
+	// signed: changing them breaks the signature
+	evt.Extensions["region"] = []byte("us")
+	got.Event, err = evt.MarshalCBOR()
+	r.NoError(err)
+	got.lazyEvt = nil
+	a.False(got.Verify(nil))
+
+	ext, err = plain.Extensions()
+	r.NoError(err)
+	a.Nil(ext)
+
+	_, err = tr.MarshalCompact(false)
+	a.Error(err)
+
+	a.Error(e.WithExtensions(Extensions{"big": bytes.Repeat([]byte("x"), maxExtensionsSize)}))
+
+	r.NoError(e.WithExtensions(nil))
+	again, _, err := e.Encode(1, BinaryRef{}, "hello")
+	r.NoError(err)
+	a.Equal(plain.Key(), again.Key())
+}
@@ -0,0 +1,334 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+// Package fec wraps batches of transfers in a Reed-Solomon erasure code, for one-way links like radio or satellite
+// broadcasts where nothing can be asked for again.
+//
+// A batch is split into data shards and parity shards are computed from them. Each shard is sent on its own,
+// i.e. as one packet, and any data shards out of all of them are enough to recover the batch.
+// Shards carry a CRC32, a broken shard counts as lost. The layout of a shard is
+//
+//	magic (1) | version (1) | batch (4) | data (1) | parity (1) | index (1) | length (4) | shard | crc32 (4)
+//
+// with big-endian numbers, where length is the size of the batch and the CRC32 (IEEE) covers everything before it.
+package fec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+)
+
+const (
+	shardMagic   = 0xfe
+	shardVersion = 1
+
+	shardHeaderSize = 13
+	shardCRCSize    = 4
+
+	// MaxShards is the most data and parity shards a batch can have together
+	MaxShards = 255
+
+	// MaxBatchSize is the largest batch that is encoded
+	MaxBatchSize = 16 << 20
+
+	// DefaultPending is how many incomplete batches a Decoder keeps
+	DefaultPending = 16
+)
+
+var (
+	// ErrCorrupt is returned by Decoder.Add for shards that aren't valid
+	ErrCorrupt = errors.New("fec: corrupt shard")
+
+	// ErrBatchSize is returned by Encoder.Encode for batches larger than MaxBatchSize
+	ErrBatchSize = errors.New("fec: batch too large")
+)
+
+// Encoder splits batches into shards
+type Encoder struct {
+	mu           sync.Mutex
+	data, parity int
+	batch        uint32
+}
+
+// NewEncoder returns an encoder of data shards and parity shards per batch.
+// Up to parity shards of a batch can be lost, the overhead is parity/data.
+func NewEncoder(data, parity int) (*Encoder, error) {
+	if data < 1 || parity < 0 || data+parity > MaxShards {
+		return nil, errors.Errorf("fec: invalid shard counts %d+%d", data, parity)
+	}
+	return &Encoder{data: data, parity: parity}, nil
+}
+
+// Encode returns the shards of batch, the data shards first
+func (e *Encoder) Encode(batch []byte) ([][]byte, error) {
+	if len(batch) > MaxBatchSize {
+		return nil, errors.Wrapf(ErrBatchSize, "%d bytes", len(batch))
+	}
+	e.mu.Lock()
+	id := e.batch
+	e.batch++
+	e.mu.Unlock()
+
+	size := (len(batch) + e.data - 1) / e.data
+	if size == 0 {
+		size = 1
+	}
+	payloads := make([][]byte, e.data+e.parity)
+	for i := 0; i < e.data; i++ {
+		p := make([]byte, size)
+		if off := i * size; off < len(batch) {
+			copy(p, batch[off:])
+		}
+		payloads[i] = p
+	}
+	for i := 0; i < e.parity; i++ {
+		p := make([]byte, size)
+		for j, c := range cauchyRow(i, e.data) {
+			mulAdd(p, payloads[j], c)
+		}
+		payloads[e.data+i] = p
+	}
+
+	shards := make([][]byte, len(payloads))
+	for i, p := range payloads {
+		s := make([]byte, shardHeaderSize, shardHeaderSize+size+shardCRCSize)
+		s[0] = shardMagic
+		s[1] = shardVersion
+		binary.BigEndian.PutUint32(s[2:], id)
+		s[6] = byte(e.data)
+		s[7] = byte(e.parity)
+		s[8] = byte(i)
+		binary.BigEndian.PutUint32(s[9:], uint32(len(batch)))
+		s = append(s, p...)
+		s = append(s, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(s[len(s)-shardCRCSize:], crc32.ChecksumIEEE(s[:len(s)-shardCRCSize]))
+		shards[i] = s
+	}
+	return shards, nil
+}
+
+// EncodeTransfers encodes trs as one batch, one after the other as a CBOR sequence
+func (e *Encoder) EncodeTransfers(trs []*gabbygrove.Transfer) ([][]byte, error) {
+	var batch []byte
+	for _, tr := range trs {
+		data, err := tr.MarshalCBOR()
+		if err != nil {
+			return nil, errors.Wrap(err, "fec: failed to encode transfer")
+		}
+		batch = append(batch, data...)
+	}
+	return e.Encode(batch)
+}
+
+// DecodeTransfers returns the transfers of a batch made by EncodeTransfers
+func DecodeTransfers(batch []byte) ([]*gabbygrove.Transfer, error) {
+	rd := bytes.NewReader(batch)
+	var trs []*gabbygrove.Transfer
+	for rd.Len() > 0 {
+		var tr gabbygrove.Transfer
+		if err := tr.DecodeFrom(rd); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return trs, errors.Wrapf(err, "fec: invalid transfer %d", len(trs))
+		}
+		trs = append(trs, &tr)
+	}
+	return trs, nil
+}
+
+// shard is a decoded shard
+type shard struct {
+	batch        uint32
+	data, parity int
+	index        int
+	length       int
+	payload      []byte
+}
+
+func parseShard(s []byte) (*shard, error) {
+	if len(s) < shardHeaderSize+1+shardCRCSize || s[0] != shardMagic {
+		return nil, ErrCorrupt
+	}
+	if crc32.ChecksumIEEE(s[:len(s)-shardCRCSize]) != binary.BigEndian.Uint32(s[len(s)-shardCRCSize:]) {
+		return nil, errors.Wrap(ErrCorrupt, "checksum")
+	}
+	if s[1] != shardVersion {
+		return nil, errors.Wrapf(ErrCorrupt, "version %d", s[1])
+	}
+	sh := &shard{
+		batch:   binary.BigEndian.Uint32(s[2:]),
+		data:    int(s[6]),
+		parity:  int(s[7]),
+		index:   int(s[8]),
+		length:  int(binary.BigEndian.Uint32(s[9:])),
+		payload: s[shardHeaderSize : len(s)-shardCRCSize],
+	}
+	switch {
+	case sh.data < 1 || sh.data+sh.parity > MaxShards || sh.index >= sh.data+sh.parity:
+		return nil, errors.Wrap(ErrCorrupt, "shard counts")
+	case sh.length > MaxBatchSize || sh.length > sh.data*len(sh.payload):
+		return nil, errors.Wrap(ErrCorrupt, "length")
+	}
+	return sh, nil
+}
+
+// Decoder collects the shards of batches and recovers them
+type Decoder struct {
+	pending int
+
+	// batches are the incomplete ones, oldest first
+	batches []*batch
+
+	// done are the ids of recovered batches, so their late shards are ignored
+	done []uint32
+}
+
+type batch struct {
+	id           uint32
+	data, parity int
+	length, size int
+	shards       map[int][]byte
+}
+
+// NewDecoder returns a decoder that keeps DefaultPending incomplete batches
+func NewDecoder() *Decoder {
+	return &Decoder{pending: DefaultPending}
+}
+
+// WithPending sets how many incomplete batches are kept, the oldest is given up for a new one beyond that
+func (d *Decoder) WithPending(n int) {
+	if n < 1 {
+		n = 1
+	}
+	d.pending = n
+}
+
+// Add takes one shard and returns the batch once enough of its shards arrived, nil before and after that.
+// Broken shards return ErrCorrupt and are otherwise ignored.
+func (d *Decoder) Add(s []byte) ([]byte, error) {
+	sh, err := parseShard(s)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range d.done {
+		if id == sh.batch {
+			return nil, nil
+		}
+	}
+
+	var b *batch
+	for _, cand := range d.batches {
+		if cand.id == sh.batch {
+			b = cand
+			break
+		}
+	}
+	if b == nil {
+		if len(d.batches) >= d.pending {
+			d.batches = d.batches[1:]
+		}
+		b = &batch{
+			id:     sh.batch,
+			data:   sh.data,
+			parity: sh.parity,
+			length: sh.length,
+			size:   len(sh.payload),
+			shards: make(map[int][]byte),
+		}
+		d.batches = append(d.batches, b)
+	}
+	if b.data != sh.data || b.parity != sh.parity || b.length != sh.length || b.size != len(sh.payload) {
+		return nil, errors.Wrap(ErrCorrupt, "doesn't match its batch")
+	}
+	if _, has := b.shards[sh.index]; has {
+		return nil, nil
+	}
+	b.shards[sh.index] = append([]byte{}, sh.payload...)
+	if len(b.shards) < b.data {
+		return nil, nil
+	}
+
+	data, err := b.recover()
+	if err != nil {
+		return nil, err
+	}
+	for i, cand := range d.batches {
+		if cand == b {
+			d.batches = append(d.batches[:i], d.batches[i+1:]...)
+			break
+		}
+	}
+	d.done = append(d.done, b.id)
+	if len(d.done) > d.pending {
+		d.done = d.done[1:]
+	}
+	return data, nil
+}
+
+// Missing returns how many more shards the incomplete batches need, by batch id
+func (d *Decoder) Missing() map[uint32]int {
+	missing := make(map[uint32]int, len(d.batches))
+	for _, b := range d.batches {
+		missing[b.id] = b.data - len(b.shards)
+	}
+	return missing
+}
+
+// recover solves for the data shards with the first data shards that arrived
+func (b *batch) recover() ([]byte, error) {
+	rows := make([][]byte, 0, b.data)
+	have := make([][]byte, 0, b.data)
+	for i := 0; i < b.data+b.parity && len(rows) < b.data; i++ {
+		p, has := b.shards[i]
+		if !has {
+			continue
+		}
+		var row []byte
+		if i < b.data {
+			row = make([]byte, b.data)
+			row[i] = 1
+		} else {
+			row = cauchyRow(i-b.data, b.data)
+		}
+		rows = append(rows, row)
+		have = append(have, p)
+	}
+
+	data := make([][]byte, b.data)
+	complete := true
+	for i := 0; i < b.data; i++ {
+		if data[i] = b.shards[i]; data[i] == nil {
+			complete = false
+		}
+	}
+	if !complete {
+		inv, err := invert(rows)
+		if err != nil {
+			return nil, err
+		}
+		for i := range data {
+			if data[i] != nil {
+				continue
+			}
+			p := make([]byte, b.size)
+			for j, c := range inv[i] {
+				mulAdd(p, have[j], c)
+			}
+			data[i] = p
+		}
+	}
+
+	out := make([]byte, 0, b.data*b.size)
+	for _, p := range data {
+		out = append(out, p...)
+	}
+	return out[:b.length], nil
+}
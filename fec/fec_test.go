@@ -0,0 +1,160 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package fec
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mindeco.de/ssb-gabbygrove/gabbygrovetest"
+)
+
+func TestGF256(t *testing.T) {
+	a := assert.New(t)
+	for x := 1; x < 256; x++ {
+		a.EqualValues(1, gfMul(byte(x), gfInv(byte(x))), "%d", x)
+	}
+	a.EqualValues(0, gfMul(0, 7))
+	a.Equal(byte(0x0e), gfMul(0x07, 0x02))
+}
+
+func TestFECRecover(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, err := NewEncoder(0, 1)
+	a.Error(err)
+	_, err = NewEncoder(200, 56)
+	a.Error(err)
+
+	rnd := rand.New(rand.NewSource(42))
+	batch := make([]byte, 1000)
+	rnd.Read(batch)
+
+	e, err := NewEncoder(10, 4)
+	r.NoError(err)
+	shards, err := e.Encode(batch)
+	r.NoError(err)
+	r.Len(shards, 14)
+
+	// every way of losing up to four shards can be recovered, try some
+	for try := 0; try < 50; try++ {
+		lost := rnd.Intn(5)
+		perm := rnd.Perm(len(shards))
+		d := NewDecoder()
+		var got []byte
+		for _, i := range perm[lost:] {
+			out, err := d.Add(shards[i])
+			r.NoError(err)
+			if out != nil {
+				r.Nil(got, "only once")
+				got = out
+			}
+		}
+		a.Equal(batch, got, "lost %v", perm[:lost])
+	}
+
+	// five lost is one too many
+	d := NewDecoder()
+	for _, s := range shards[5:] {
+		out, err := d.Add(s)
+		r.NoError(err)
+		a.Nil(out)
+	}
+	a.Equal(map[uint32]int{0: 1}, d.Missing())
+
+	// without parity the data shards need to arrive
+	e, err = NewEncoder(3, 0)
+	r.NoError(err)
+	shards, err = e.Encode([]byte("abcdefg"))
+	r.NoError(err)
+	d = NewDecoder()
+	var got []byte
+	for _, s := range shards {
+		out, err := d.Add(s)
+		r.NoError(err)
+		if out != nil {
+			got = out
+		}
+	}
+	a.Equal([]byte("abcdefg"), got)
+}
+
+func TestFECCorrupt(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	e, err := NewEncoder(2, 1)
+	r.NoError(err)
+	shards, err := e.Encode([]byte("some batch of data"))
+	r.NoError(err)
+
+	d := NewDecoder()
+	broken := append([]byte{}, shards[0]...)
+	broken[20] ^= 0x01
+	_, err = d.Add(broken)
+	a.Equal(ErrCorrupt, errors.Cause(err))
+	_, err = d.Add(shards[0][:10])
+	a.Equal(ErrCorrupt, errors.Cause(err))
+
+	out, err := d.Add(shards[2])
+	r.NoError(err)
+	a.Nil(out)
+	out, err = d.Add(shards[1])
+	r.NoError(err)
+	a.Equal([]byte("some batch of data"), out)
+
+	// late shards of a recovered batch are ignored
+	out, err = d.Add(shards[0])
+	r.NoError(err)
+	a.Nil(out)
+	a.Empty(d.Missing())
+
+	// the oldest incomplete batch is given up
+	d.WithPending(1)
+	first, err := e.Encode([]byte("first"))
+	r.NoError(err)
+	second, err := e.Encode([]byte("second"))
+	r.NoError(err)
+	_, err = d.Add(first[0])
+	r.NoError(err)
+	_, err = d.Add(second[0])
+	r.NoError(err)
+	a.Equal(map[uint32]int{2: 1}, d.Missing())
+}
+
+func TestFECTransfers(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	feed := gabbygrovetest.Fixture(t, "alice", 5)
+	e, err := NewEncoder(4, 2)
+	r.NoError(err)
+	shards, err := e.EncodeTransfers(feed.Messages())
+	r.NoError(err)
+
+	d := NewDecoder()
+	var batch []byte
+	for _, s := range shards[2:] {
+		out, err := d.Add(s)
+		r.NoError(err)
+		if out != nil {
+			batch = out
+		}
+	}
+	trs, err := DecodeTransfers(batch)
+	r.NoError(err)
+	if a.Len(trs, 5) {
+		for i, tr := range trs {
+			a.Equal(feed.Messages()[i].Key(), tr.Key())
+		}
+	}
+
+	_, err = DecodeTransfers(batch[:len(batch)-3])
+	a.Error(err)
+}
@@ -0,0 +1,320 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+var (
+	ErrWrongAuthor      = errors.New("gabbygrove: wrong author")
+	ErrWrongSequence    = errors.New("gabbygrove: wrong sequence")
+	ErrBrokenChain      = errors.New("gabbygrove: previous does not match the feed tip")
+	ErrInvalidSignature = errors.New("gabbygrove: invalid signature")
+)
+
+// FeedState tracks the tip of a single feed and validates new messages against it
+type FeedState struct {
+	Author   refs.FeedRef
+	Sequence uint64
+
+	// Tip is the key of the latest message, nil for an empty feed
+	Tip *refs.MessageRef
+
+	// Revoked is set once the feed published a Revocation, later messages are rejected with ErrKeyRevoked
+	Revoked *RevocationPoint
+
+	// Terminated is set once the feed published a Tombstone, later messages are rejected with ErrFeedTerminated
+	Terminated bool
+
+	hmacKey *[32]byte
+
+	// acceptRevoked lets messages after a revocation pass, for RevocationFlag
+	acceptRevoked bool
+
+	// key is the parsed key of Author, see VerifyKey
+	key *VerifyKey
+}
+
+// NewFeedState returns the state of an empty feed
+func NewFeedState(author refs.FeedRef) *FeedState {
+	return &FeedState{Author: author}
+}
+
+func (fs *FeedState) WithHMAC(in []byte) error {
+	var k [32]byte
+	n := copy(k[:], in)
+	if n != 32 {
+		return errors.Errorf("hmac key to short: %d", n)
+	}
+	fs.hmacKey = &k
+	return nil
+}
+
+// Next returns the sequence and previous reference to encode the next message with
+func (fs FeedState) Next() (uint64, BinaryRef) {
+	var prev BinaryRef
+	if fs.Tip != nil {
+		prev, _ = fromRef(*fs.Tip)
+	}
+	return fs.Sequence + 1, prev
+}
+
+// Check validates tr as the next message of the feed without advancing the state
+func (fs *FeedState) Check(tr *Transfer) error {
+	_, err := fs.check(tr)
+	return err
+}
+
+// feedEnd is how a message ends its feed, see check
+type feedEnd struct {
+	revoked    *RevocationPoint
+	terminated bool
+}
+
+// check is Check that also returns if tr revokes or terminates the feed
+func (fs *FeedState) check(tr *Transfer) (feedEnd, error) {
+	var none feedEnd
+	evt, err := tr.getEvent()
+	if err != nil {
+		return none, errors.Wrap(err, "gabbygrove/feedstate: invalid event")
+	}
+
+	aref, err := evt.Author.GetRef(RefTypeFeed)
+	if err != nil {
+		return none, errors.Wrap(err, "gabbygrove/feedstate: invalid author")
+	}
+	if !aref.(refs.FeedRef).Equal(fs.Author) {
+		return none, errors.Wrapf(ErrWrongAuthor, "feedstate: got %s", aref.(refs.FeedRef).ShortSigil())
+	}
+	if fs.Terminated {
+		return none, errors.Wrapf(ErrFeedTerminated, "feedstate: terminated at %d", fs.Sequence)
+	}
+	if fs.Revoked != nil && !fs.acceptRevoked {
+		return none, errors.Wrapf(ErrKeyRevoked, "feedstate: revoked at %d", fs.Revoked.Sequence)
+	}
+
+	if want := fs.Sequence + 1; evt.Sequence != want {
+		return none, errors.Wrapf(ErrWrongSequence, "feedstate: expected %d got %d", want, evt.Sequence)
+	}
+
+	switch {
+	case fs.Tip == nil && evt.Previous != nil:
+		return none, errors.Wrap(ErrBrokenChain, "feedstate: first message has a previous")
+	case fs.Tip != nil:
+		if evt.Previous == nil {
+			return none, errors.Wrapf(ErrBrokenChain, "feedstate: message %d has no previous", evt.Sequence)
+		}
+		pref, err := evt.Previous.GetRef(RefTypeMessage)
+		if err != nil {
+			return none, errors.Wrap(err, "gabbygrove/feedstate: invalid previous")
+		}
+		if !pref.(refs.MessageRef).Equal(*fs.Tip) {
+			return none, errors.Wrapf(ErrBrokenChain, "feedstate: message %d", evt.Sequence)
+		}
+	}
+
+	vk, err := fs.verifyKey()
+	if err != nil {
+		return none, errors.Wrap(err, "gabbygrove/feedstate")
+	}
+	if tr.verifyKey(vk, fs.hmacKey, false) != nil {
+		return none, errors.Wrapf(ErrInvalidSignature, "feedstate: message %d", evt.Sequence)
+	}
+
+	return endOf(tr, evt.Sequence, fs.Revoked != nil)
+}
+
+// endOf returns how tr, the message seq of its feed, ends the feed.
+// A feed that is already revoked can't be revoked again.
+func endOf(tr *Transfer, seq uint64, revoked bool) (feedEnd, error) {
+	var none feedEnd
+	switch {
+	case hasJSONType(tr, TombstoneType):
+		if _, err := ParseTombstone(tr); err != nil {
+			return none, errors.Wrapf(err, "gabbygrove/feedstate: message %d", seq)
+		}
+		return feedEnd{terminated: true}, nil
+	case !revoked && hasJSONType(tr, RevocationType):
+		rev, err := ParseRevocation(tr)
+		if err != nil {
+			return none, errors.Wrapf(err, "gabbygrove/feedstate: message %d", seq)
+		}
+		return feedEnd{revoked: &RevocationPoint{Sequence: seq, Since: rev.Since}}, nil
+	}
+	return none, nil
+}
+
+// Append validates tr as the next message and advances the state on success.
+// A Revocation sets Revoked and a Tombstone Terminated, both are only recognized with their content attached.
+func (fs *FeedState) Append(tr *Transfer) error {
+	end, err := fs.check(tr)
+	if err != nil {
+		if debugEnabled() {
+			debugLog("event", "append", "author", fs.Author.URI(), "seq", fs.Sequence+1, "msg", tr.Key().URI(), "err", err)
+		}
+		return err
+	}
+	fs.advance(tr.Key(), end)
+	return nil
+}
+
+// advance moves the tip to the checked message key
+func (fs *FeedState) advance(key refs.MessageRef, end feedEnd) {
+	fs.Sequence++
+	fs.Tip = &key
+	if end.revoked != nil {
+		fs.Revoked = end.revoked
+	}
+	fs.Terminated = end.terminated
+}
+
+const (
+	feedStateVersion1 byte = 1
+
+	// feedStateVersion2 adds a flags byte after the tip, followed by the fields the flags announce.
+	// It's only used if a flag is set, so readers of version 1 can still load the other snapshots.
+	feedStateVersion2 byte = 2
+
+	// feedStateRevoked is followed by the sequence and since of Revoked as uvarints
+	feedStateRevoked byte = 1 << 0
+
+	// feedStateTerminated has no fields, the tip is the tombstone
+	feedStateTerminated byte = 1 << 1
+
+	feedStateFlagMask = feedStateRevoked | feedStateTerminated
+)
+
+// MarshalBinary snapshots the position of the feed, so verification can resume from it after a restart.
+// The HMAC key is not part of the snapshot and needs to be set again after UnmarshalBinary.
+func (fs FeedState) MarshalBinary() ([]byte, error) {
+	if fs.Author.Algo() != refs.RefAlgoFeedGabby {
+		return nil, errors.Errorf("gabbygrove/feedstate: not a gabbygrove feed: %s", fs.Author.Algo())
+	}
+	if (fs.Tip == nil) != (fs.Sequence == 0) {
+		return nil, errors.Errorf("gabbygrove/feedstate: tip and sequence disagree")
+	}
+	if rp := fs.Revoked; rp != nil && (rp.Since == 0 || rp.Since > rp.Sequence || rp.Sequence > fs.Sequence) {
+		return nil, errors.Errorf("gabbygrove/feedstate: invalid revocation point")
+	}
+
+	var flags byte
+	if fs.Revoked != nil {
+		flags |= feedStateRevoked
+	}
+	if fs.Terminated {
+		if fs.Sequence == 0 {
+			return nil, errors.Errorf("gabbygrove/feedstate: empty feed is terminated")
+		}
+		flags |= feedStateTerminated
+	}
+
+	var buf bytes.Buffer
+	if flags == 0 {
+		buf.WriteByte(feedStateVersion1)
+	} else {
+		buf.WriteByte(feedStateVersion2)
+	}
+	buf.Write(fs.Author.PubKey())
+
+	var vbuf [binary.MaxVarintLen64]byte
+	buf.Write(vbuf[:binary.PutUvarint(vbuf[:], fs.Sequence)])
+
+	if fs.Tip != nil {
+		tip := make([]byte, 32)
+		if err := fs.Tip.CopyHashTo(tip); err != nil {
+			return nil, errors.Wrap(err, "gabbygrove/feedstate: invalid tip")
+		}
+		buf.Write(tip)
+	}
+
+	if flags != 0 {
+		buf.WriteByte(flags)
+	}
+	if fs.Revoked != nil {
+		buf.Write(vbuf[:binary.PutUvarint(vbuf[:], fs.Revoked.Sequence)])
+		buf.Write(vbuf[:binary.PutUvarint(vbuf[:], fs.Revoked.Since)])
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary restores a snapshot made by MarshalBinary
+func (fs *FeedState) UnmarshalBinary(data []byte) error {
+	rd := bytes.NewReader(data)
+
+	v, err := rd.ReadByte()
+	if err != nil || (v != feedStateVersion1 && v != feedStateVersion2) {
+		return errors.Errorf("gabbygrove/feedstate: unsupported snapshot version")
+	}
+
+	var hash [32]byte
+	if _, err := io.ReadFull(rd, hash[:]); err != nil {
+		return errors.Wrap(err, "gabbygrove/feedstate: author")
+	}
+	author, err := refs.NewFeedRefFromBytes(hash[:], refs.RefAlgoFeedGabby)
+	if err != nil {
+		return err
+	}
+
+	seq, err := binary.ReadUvarint(rd)
+	if err != nil {
+		return errors.Wrap(err, "gabbygrove/feedstate: sequence")
+	}
+
+	var tip *refs.MessageRef
+	if seq > 0 {
+		if _, err := io.ReadFull(rd, hash[:]); err != nil {
+			return errors.Wrap(err, "gabbygrove/feedstate: tip")
+		}
+		mr, err := refs.NewMessageRefFromBytes(hash[:], refs.RefAlgoMessageGabby)
+		if err != nil {
+			return err
+		}
+		tip = &mr
+	}
+
+	var (
+		revoked    *RevocationPoint
+		terminated bool
+	)
+	if v == feedStateVersion2 {
+		flags, err := rd.ReadByte()
+		if err != nil || flags == 0 || flags&^feedStateFlagMask != 0 {
+			return errors.Errorf("gabbygrove/feedstate: unsupported flags")
+		}
+		if flags&feedStateRevoked != 0 {
+			var rp RevocationPoint
+			if rp.Sequence, err = binary.ReadUvarint(rd); err != nil {
+				return errors.Wrap(err, "gabbygrove/feedstate: revocation")
+			}
+			if rp.Since, err = binary.ReadUvarint(rd); err != nil {
+				return errors.Wrap(err, "gabbygrove/feedstate: revocation")
+			}
+			if rp.Since == 0 || rp.Since > rp.Sequence || rp.Sequence > seq {
+				return errors.Errorf("gabbygrove/feedstate: invalid revocation point")
+			}
+			revoked = &rp
+		}
+		if flags&feedStateTerminated != 0 && seq == 0 {
+			return errors.Errorf("gabbygrove/feedstate: empty feed is terminated")
+		}
+		terminated = flags&feedStateTerminated != 0
+	}
+	if rd.Len() != 0 {
+		return errors.Errorf("gabbygrove/feedstate: %d trailing bytes", rd.Len())
+	}
+
+	fs.Author = author
+	fs.Sequence = seq
+	fs.Tip = tip
+	fs.Revoked = revoked
+	fs.Terminated = terminated
+	return nil
+}
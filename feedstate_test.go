@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// makeTestFeed encodes n json messages for the key generated from seed
+func makeTestFeed(t testing.TB, seed string, n int) (refs.FeedRef, []*Transfer) {
+	r := require.New(t)
+
+	pubKey, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte(seed), 32/len(seed))))
+	author, err := refs.NewFeedRefFromBytes(pubKey, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+
+	e := NewEncoder(privKey)
+	state := NewFeedState(author)
+
+	var trs []*Transfer
+	for i := 0; i < n; i++ {
+		seq, prev := state.Next()
+		tr, _, err := e.Encode(seq, prev, map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+		r.NoError(state.Append(tr))
+		trs = append(trs, tr)
+	}
+	return author, trs
+}
+
+func TestFeedState(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	author, trs := makeTestFeed(t, "dead", 3)
+
+	state := NewFeedState(author)
+	a.Equal(ErrWrongSequence, errors.Cause(state.Append(trs[1])))
+	r.NoError(state.Append(trs[0]))
+	a.Equal(ErrWrongSequence, errors.Cause(state.Append(trs[0])))
+	r.NoError(state.Append(trs[1]))
+	a.EqualValues(2, state.Sequence)
+	a.Equal(trs[1].Key(), *state.Tip)
+
+	_, otherTrs := makeTestFeed(t, "beef", 3)
+	a.Equal(ErrWrongAuthor, errors.Cause(state.Append(otherTrs[2])))
+
+	broken := *state
+	wrongTip := trs[0].Key()
+	broken.Tip = &wrongTip
+	a.Equal(ErrBrokenChain, errors.Cause(broken.Append(trs[2])))
+
+	// tampered signature
+	tampered := *trs[2]
+	tampered.Signature = append([]byte{}, trs[2].Signature...)
+	tampered.Signature[0] ^= 0xff
+	a.Equal(ErrInvalidSignature, errors.Cause(state.Append(&tampered)))
+
+	r.NoError(state.Append(trs[2]))
+}
+
+func TestFeedStateSnapshot(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	author, trs := makeTestFeed(t, "dead", 3)
+
+	empty := NewFeedState(author)
+	data, err := empty.MarshalBinary()
+	r.NoError(err)
+	var restored FeedState
+	r.NoError(restored.UnmarshalBinary(data))
+	a.True(restored.Author.Equal(author))
+	a.Nil(restored.Tip)
+
+	state := NewFeedState(author)
+	r.NoError(state.Append(trs[0]))
+	r.NoError(state.Append(trs[1]))
+	data, err = state.MarshalBinary()
+	r.NoError(err)
+
+	r.NoError(restored.UnmarshalBinary(data))
+	a.EqualValues(2, restored.Sequence)
+	a.Equal(trs[1].Key(), *restored.Tip)
+	r.NoError(restored.Append(trs[2]), "resumes where it left off")
+
+	a.Error(restored.UnmarshalBinary(data[:len(data)-1]))
+	a.Error(restored.UnmarshalBinary(append(data, 0)))
+	a.Error(restored.UnmarshalBinary(append([]byte{2}, data[1:]...)))
+}
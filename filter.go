@@ -0,0 +1,108 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// ErrBlockedAuthor is returned for messages of authors an AuthorFilter rejected
+var ErrBlockedAuthor = errors.New("gabbygrove: blocked author")
+
+// AuthorFilter decides whose messages are accepted.
+// The Validator and Decoder consult it before any signature is checked.
+type AuthorFilter interface {
+	Allowed(author refs.FeedRef) bool
+}
+
+// AuthorFilterFunc adapts a plain function to an AuthorFilter
+type AuthorFilterFunc func(author refs.FeedRef) bool
+
+// Allowed calls fn(author)
+func (fn AuthorFilterFunc) Allowed(author refs.FeedRef) bool {
+	return fn(author)
+}
+
+// AuthorList is an AuthorFilter that either only allows or only denies the authors on it.
+// Authors can be added and removed while it is in use.
+type AuthorList struct {
+	allow bool
+
+	mu    sync.RWMutex
+	feeds map[string]struct{}
+}
+
+var _ AuthorFilter = (*AuthorList)(nil)
+
+// NewAllowList only accepts the passed authors
+func NewAllowList(feeds ...refs.FeedRef) *AuthorList {
+	return newAuthorList(true, feeds)
+}
+
+// NewDenyList accepts all but the passed authors
+func NewDenyList(feeds ...refs.FeedRef) *AuthorList {
+	return newAuthorList(false, feeds)
+}
+
+func newAuthorList(allow bool, feeds []refs.FeedRef) *AuthorList {
+	al := &AuthorList{
+		allow: allow,
+		feeds: make(map[string]struct{}, len(feeds)),
+	}
+	for _, f := range feeds {
+		al.Add(f)
+	}
+	return al
+}
+
+// Add puts author on the list
+func (al *AuthorList) Add(author refs.FeedRef) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	al.feeds[author.String()] = struct{}{}
+}
+
+// Remove takes author off the list
+func (al *AuthorList) Remove(author refs.FeedRef) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	delete(al.feeds, author.String())
+}
+
+// Allowed returns true for authors on an allow list and those not on a deny list
+func (al *AuthorList) Allowed(author refs.FeedRef) bool {
+	al.mu.RLock()
+	defer al.mu.RUnlock()
+	_, has := al.feeds[author.String()]
+	return has == al.allow
+}
+
+// WithAuthorFilter makes the validator reject messages of authors f doesn't allow
+func (v *Validator) WithAuthorFilter(f AuthorFilter) {
+	v.filter = f
+}
+
+// WithAuthorFilter makes the decoder reject transfers and events of authors f doesn't allow
+func (d *Decoder) WithAuthorFilter(f AuthorFilter) {
+	d.filter = f
+}
+
+func checkAuthor(f AuthorFilter, evt *Event) error {
+	if f == nil {
+		return nil
+	}
+	aref, err := evt.Author.GetRef(RefTypeFeed)
+	if err != nil {
+		return errors.Wrap(err, "gabbygrove: invalid author")
+	}
+	author := aref.(refs.FeedRef)
+	if !f.Allowed(author) {
+		return errors.Wrapf(ErrBlockedAuthor, "%s", author.ShortSigil())
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+func TestAuthorFilter(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	alice, aliceTrs := makeTestFeed(t, "dead", 2)
+	bob, bobTrs := makeTestFeed(t, "beef", 1)
+
+	deny := NewDenyList(bob)
+	a.True(deny.Allowed(alice))
+	a.False(deny.Allowed(bob))
+
+	v := NewValidator(2)
+	v.WithAuthorFilter(deny)
+	_, err := v.Append(aliceTrs[0])
+	r.NoError(err)
+	_, err = v.Append(bobTrs[0])
+	a.Equal(ErrBlockedAuthor, errors.Cause(err))
+	a.Equal(1, v.Authors(), "blocked authors are not tracked")
+
+	deny.Remove(bob)
+	_, err = v.Append(bobTrs[0])
+	r.NoError(err)
+
+	// decoder
+	allow := NewAllowList(alice)
+	dec := NewDecoder()
+	dec.WithAuthorFilter(allow)
+
+	b, err := aliceTrs[1].MarshalCBOR()
+	r.NoError(err)
+	_, err = dec.Decode(b)
+	r.NoError(err)
+	_, err = dec.DecodeEvent(aliceTrs[1].Event)
+	r.NoError(err)
+
+	b, err = bobTrs[0].MarshalCBOR()
+	r.NoError(err)
+	_, err = dec.Decode(b)
+	a.Equal(ErrBlockedAuthor, errors.Cause(err))
+	_, err = dec.DecodeFrom(bytes.NewReader(b))
+	a.Equal(ErrBlockedAuthor, errors.Cause(err))
+	_, err = dec.DecodeEvent(bobTrs[0].Event)
+	a.Equal(ErrBlockedAuthor, errors.Cause(err))
+
+	var calls int
+	dec.WithAuthorFilter(AuthorFilterFunc(func(refs.FeedRef) bool {
+		calls++
+		return true
+	}))
+	_, err = dec.Decode(b)
+	r.NoError(err)
+	a.Equal(1, calls)
+}
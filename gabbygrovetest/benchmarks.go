@@ -0,0 +1,225 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrovetest
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+)
+
+// Corpus is a set of valid messages to benchmark with, in an order a Validator accepts
+type Corpus struct {
+	Name     string
+	Messages []*gabbygrove.Transfer
+}
+
+// Bytes returns the size of the encoded messages
+func (c *Corpus) Bytes() (int64, error) {
+	var n int64
+	for _, tr := range c.Messages {
+		data, err := tr.MarshalCBOR()
+		if err != nil {
+			return 0, err
+		}
+		n += int64(len(data))
+	}
+	return n, nil
+}
+
+// SmallJSON is one feed of n short JSON posts, the common case of social messages
+func SmallJSON(n int) (*Corpus, error) {
+	b := NewFeedBuilder("bench small-json")
+	for i := 0; i < n; i++ {
+		post := map[string]interface{}{"type": "post", "text": fmt.Sprintf("hello number %d, how is it going?", i)}
+		if _, err := b.Publish(post); err != nil {
+			return nil, errors.Wrapf(err, "gabbygrovetest: post %d", i)
+		}
+	}
+	return &Corpus{Name: "small-json", Messages: b.Messages()}, nil
+}
+
+// MaxBinary is one feed of n messages with arbitrary content of the largest size, the worst case for hashing
+func MaxBinary(n int) (*Corpus, error) {
+	b := NewFeedBuilder("bench max-binary")
+	content := bytes.Repeat([]byte{0x5a}, math.MaxUint16)
+	for i := 0; i < n; i++ {
+		content[0], content[1] = byte(i>>8), byte(i)
+		if _, err := b.Publish(append([]byte{}, content...)); err != nil {
+			return nil, errors.Wrapf(err, "gabbygrovetest: blob %d", i)
+		}
+	}
+	return &Corpus{Name: "max-binary", Messages: b.Messages()}, nil
+}
+
+// MixedFeeds is feeds feeds of n messages each that cycle through the content types,
+// interleaved like they arrive from a peer that replicates many feeds
+func MixedFeeds(feeds, n int) (*Corpus, error) {
+	builders := make([]*FeedBuilder, feeds)
+	for i := range builders {
+		builders[i] = NewFeedBuilder(fmt.Sprintf("bench mixed %d", i))
+		if _, err := builders[i].Build(n, gabbygrove.ContentTypeJSON, gabbygrove.ContentTypeArbitrary, gabbygrove.ContentTypeCBOR); err != nil {
+			return nil, err
+		}
+	}
+	c := &Corpus{Name: "mixed-feeds"}
+	for seq := 0; seq < n; seq++ {
+		for _, b := range builders {
+			c.Messages = append(c.Messages, b.Messages()[seq])
+		}
+	}
+	return c, nil
+}
+
+// Corpora returns the corpora Benchmarks runs on
+func Corpora() ([]*Corpus, error) {
+	var cs []*Corpus
+	for _, build := range []func() (*Corpus, error){
+		func() (*Corpus, error) { return SmallJSON(256) },
+		func() (*Corpus, error) { return MaxBinary(16) },
+		func() (*Corpus, error) { return MixedFeeds(16, 32) },
+	} {
+		c, err := build()
+		if err != nil {
+			return nil, err
+		}
+		cs = append(cs, c)
+	}
+	return cs, nil
+}
+
+// Benchmark is one operation on one corpus, its Name is operation/corpus
+type Benchmark struct {
+	Name string
+	F    func(b *testing.B)
+}
+
+// Benchmarks returns benchmarks of the codec and the crypto over all Corpora, so changes to either can be compared
+// with the same messages across versions. One op is one message, bytes are its encoded size.
+// Run them with RunBenchmarks or b.Run.
+func Benchmarks() []Benchmark {
+	ops := []struct {
+		name string
+		fn   func(b *testing.B, c *Corpus, encoded [][]byte)
+	}{
+		{"marshal", benchMarshal},
+		{"unmarshal", benchUnmarshal},
+		{"verify-all", benchVerifyAll},
+		{"key", benchKey},
+		{"validator", benchValidator},
+	}
+	corpora := []string{"small-json", "max-binary", "mixed-feeds"}
+
+	var bms []Benchmark
+	for _, op := range ops {
+		for i, name := range corpora {
+			op, i := op, i
+			bms = append(bms, Benchmark{
+				Name: op.name + "/" + name,
+				F: func(b *testing.B) {
+					c, encoded := benchCorpus(b, i)
+					op.fn(b, c, encoded)
+				},
+			})
+		}
+	}
+	return bms
+}
+
+// RunBenchmarks runs all Benchmarks as sub-benchmarks of b
+func RunBenchmarks(b *testing.B) {
+	for _, bm := range Benchmarks() {
+		b.Run(bm.Name, bm.F)
+	}
+}
+
+// benchCorpora are built once, b.Run calls a benchmark many times
+var benchCorpora struct {
+	once sync.Once
+	cs   []*Corpus
+	err  error
+}
+
+// benchCorpus returns corpus i of Corpora and its encoded messages, then sets up b
+func benchCorpus(b *testing.B, i int) (*Corpus, [][]byte) {
+	b.Helper()
+	benchCorpora.once.Do(func() {
+		benchCorpora.cs, benchCorpora.err = Corpora()
+	})
+	if benchCorpora.err != nil {
+		b.Fatal(benchCorpora.err)
+	}
+	c := benchCorpora.cs[i]
+	var err error
+	encoded := make([][]byte, len(c.Messages))
+	var total int64
+	for j, tr := range c.Messages {
+		if encoded[j], err = tr.MarshalCBOR(); err != nil {
+			b.Fatal(err)
+		}
+		total += int64(len(encoded[j]))
+	}
+	b.SetBytes(total / int64(len(encoded)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	return c, encoded
+}
+
+func benchMarshal(b *testing.B, c *Corpus, _ [][]byte) {
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Messages[i%len(c.Messages)].MarshalCBOR(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchUnmarshal(b *testing.B, _ *Corpus, encoded [][]byte) {
+	for i := 0; i < b.N; i++ {
+		var tr gabbygrove.Transfer
+		if err := tr.UnmarshalCBOR(encoded[i%len(encoded)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchVerifyAll(b *testing.B, _ *Corpus, encoded [][]byte) {
+	for i := 0; i < b.N; i++ {
+		// decoded fresh, so nothing cached of an earlier round is measured
+		var tr gabbygrove.Transfer
+		if err := tr.UnmarshalCBOR(encoded[i%len(encoded)]); err != nil {
+			b.Fatal(err)
+		}
+		if err := tr.VerifyAll(nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchKey(b *testing.B, c *Corpus, _ [][]byte) {
+	for i := 0; i < b.N; i++ {
+		c.Messages[i%len(c.Messages)].Key()
+	}
+}
+
+func benchValidator(b *testing.B, c *Corpus, _ [][]byte) {
+	var v *gabbygrove.Validator
+	for i := 0; i < b.N; i++ {
+		j := i % len(c.Messages)
+		if j == 0 {
+			b.StopTimer()
+			v = gabbygrove.NewValidator(0)
+			v.WithContentHashing(true)
+			b.StartTimer()
+		}
+		if _, err := v.Append(c.Messages[j]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrovetest
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+)
+
+func TestCorpora(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	cs, err := Corpora()
+	r.NoError(err)
+	r.Len(cs, 3)
+	for _, c := range cs {
+		v := gabbygrove.NewValidator(0)
+		v.WithContentHashing(true)
+		for _, tr := range c.Messages {
+			appended, err := v.Append(tr)
+			r.NoError(err, c.Name)
+			r.Len(appended, 1, c.Name)
+			a.True(appended[0].HasContent(), c.Name)
+		}
+		n, err := c.Bytes()
+		r.NoError(err)
+		a.True(n > 0)
+	}
+	a.Len(cs[1].Messages[0].Content, math.MaxUint16)
+	a.Equal(16, feedCount(cs[2]))
+
+	var names []string
+	for _, bm := range Benchmarks() {
+		names = append(names, bm.Name)
+	}
+	a.Contains(names, "verify-all/max-binary")
+	a.Len(names, 15)
+}
+
+// feedCount counts the feeds of a corpus
+func feedCount(c *Corpus) int {
+	authors := make(map[string]bool)
+	for _, tr := range c.Messages {
+		authors[tr.Author().String()] = true
+	}
+	return len(authors)
+}
+
+func BenchmarkSuite(b *testing.B) {
+	RunBenchmarks(b)
+}
@@ -0,0 +1,180 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrovetest
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+)
+
+// DefaultStart is the time of the first message of a FeedBuilder
+var DefaultStart = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Clock is a fake clock that advances by a fixed step every time it's read
+type Clock struct {
+	mu   sync.Mutex
+	next time.Time
+	step time.Duration
+}
+
+// NewClock returns a clock that starts at start
+func NewClock(start time.Time, step time.Duration) *Clock {
+	return &Clock{next: start, step: step}
+}
+
+// Now returns the current time of the clock and advances it
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := c.next
+	c.next = c.next.Add(c.step)
+	return t
+}
+
+// KeyFromSeed derives a key pair from seed, the same seed always gives the same key
+func KeyFromSeed(seed string) ed25519.PrivateKey {
+	h := sha256.Sum256([]byte(seed))
+	return ed25519.NewKeyFromSeed(h[:])
+}
+
+// FeedBuilder publishes messages on a feed with a deterministic key and a fake clock.
+// Every message is validated with a FeedState before it's returned.
+type FeedBuilder struct {
+	key ed25519.PrivateKey
+
+	enc   *gabbygrove.Encoder
+	clock *Clock
+	state *gabbygrove.FeedState
+
+	messages []*gabbygrove.Transfer
+}
+
+// NewFeedBuilder returns a builder for the feed of the key derived from seed.
+// Its clock starts at DefaultStart and advances by a minute per message.
+func NewFeedBuilder(seed string) *FeedBuilder {
+	key := KeyFromSeed(seed)
+	author, err := refs.NewFeedRefFromBytes(key.Public().(ed25519.PublicKey), refs.RefAlgoFeedGabby)
+	if err != nil {
+		panic(err) // the key always has the right length
+	}
+	b := &FeedBuilder{
+		key:   key,
+		enc:   gabbygrove.NewEncoder(key),
+		state: gabbygrove.NewFeedState(author),
+	}
+	b.WithClock(NewClock(DefaultStart, time.Minute))
+	return b
+}
+
+// WithClock replaces the clock of the builder
+func (b *FeedBuilder) WithClock(c *Clock) *FeedBuilder {
+	b.clock = c
+	b.enc.WithClock(c.Now)
+	return b
+}
+
+// WithHMAC signs the messages with an HMAC key, it needs to be set before the first message
+func (b *FeedBuilder) WithHMAC(key []byte) (*FeedBuilder, error) {
+	if len(b.messages) > 0 {
+		return nil, errors.Errorf("gabbygrovetest: hmac key set after the first message")
+	}
+	if err := b.enc.WithHMAC(key); err != nil {
+		return nil, err
+	}
+	if err := b.state.WithHMAC(key); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *FeedBuilder) Key() ed25519.PrivateKey { return b.key }
+
+func (b *FeedBuilder) Author() refs.FeedRef { return b.state.Author }
+
+// State returns a copy of the state after the last message
+func (b *FeedBuilder) State() gabbygrove.FeedState { return *b.state }
+
+// Messages returns all messages published so far
+func (b *FeedBuilder) Messages() []*gabbygrove.Transfer { return b.messages }
+
+// Publish encodes val as the next message, like Encoder.Encode does
+func (b *FeedBuilder) Publish(val interface{}) (*gabbygrove.Transfer, error) {
+	seq, prev := b.state.Next()
+	tr, _, err := b.enc.Encode(seq, prev, val)
+	if err != nil {
+		return nil, err
+	}
+	return b.appendMessage(tr)
+}
+
+// PublishType publishes generated content of type ctype
+func (b *FeedBuilder) PublishType(ctype gabbygrove.ContentType) (*gabbygrove.Transfer, error) {
+	i := len(b.messages) + 1
+	switch ctype {
+	case gabbygrove.ContentTypeJSON:
+		return b.Publish(map[string]interface{}{"type": "test", "i": i})
+	case gabbygrove.ContentTypeArbitrary:
+		return b.Publish([]byte(fmt.Sprintf("message %d", i)))
+	case gabbygrove.ContentTypeCBOR:
+		// a CBOR text string
+		text := fmt.Sprintf("message %d", i)
+		content := append([]byte{0x60 | byte(len(text))}, text...)
+		ref, err := gabbygrove.HashContent(content)
+		if err != nil {
+			return nil, err
+		}
+		seq, prev := b.state.Next()
+		tr, _, err := b.enc.EncodeWithContentHash(seq, prev, ctype, ref, len(content))
+		if err != nil {
+			return nil, err
+		}
+		tr.Content = content
+		return b.appendMessage(tr)
+	default:
+		return nil, errors.Errorf("gabbygrovetest: unsupported content type %d", ctype)
+	}
+}
+
+// Build publishes n messages, cycling through types (JSON if none are passed)
+func (b *FeedBuilder) Build(n int, types ...gabbygrove.ContentType) ([]*gabbygrove.Transfer, error) {
+	if len(types) == 0 {
+		types = []gabbygrove.ContentType{gabbygrove.ContentTypeJSON}
+	}
+	trs := make([]*gabbygrove.Transfer, n)
+	for i := range trs {
+		tr, err := b.PublishType(types[i%len(types)])
+		if err != nil {
+			return nil, errors.Wrapf(err, "gabbygrovetest: message %d", i+1)
+		}
+		trs[i] = tr
+	}
+	return trs, nil
+}
+
+func (b *FeedBuilder) appendMessage(tr *gabbygrove.Transfer) (*gabbygrove.Transfer, error) {
+	if err := b.state.Append(tr); err != nil {
+		return nil, errors.Wrap(err, "gabbygrovetest: built an invalid message")
+	}
+	b.messages = append(b.messages, tr)
+	return tr, nil
+}
+
+// Fixture builds a feed of n messages for seed and fails tb if that doesn't work
+func Fixture(tb testing.TB, seed string, n int, types ...gabbygrove.ContentType) *FeedBuilder {
+	tb.Helper()
+	b := NewFeedBuilder(seed)
+	if _, err := b.Build(n, types...); err != nil {
+		tb.Fatal(err)
+	}
+	return b
+}
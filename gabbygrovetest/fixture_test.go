@@ -0,0 +1,69 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrovetest
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+)
+
+func TestFixture(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	types := []gabbygrove.ContentType{gabbygrove.ContentTypeJSON, gabbygrove.ContentTypeArbitrary, gabbygrove.ContentTypeCBOR}
+	b := Fixture(t, "alice", 6, types...)
+	trs := b.Messages()
+	r.Len(trs, 6)
+	a.EqualValues(6, b.State().Sequence)
+
+	for i, tr := range trs {
+		evt, err := tr.UnmarshaledEvent()
+		r.NoError(err)
+		a.Equal(types[i%3], evt.Content.Type)
+		a.Equal(DefaultStart.Add(time.Duration(i)*time.Minute), tr.Claimed().UTC())
+		r.NoError(tr.VerifyAll(nil))
+	}
+
+	// the same seed builds the same feed
+	again := Fixture(t, "alice", 6, types...)
+	for i, tr := range again.Messages() {
+		a.Equal(trs[i].Key(), tr.Key())
+	}
+	a.NotEqual(b.Author(), NewFeedBuilder("bob").Author())
+
+	// continue publishing
+	tr, err := b.Publish(map[string]interface{}{"type": "post", "text": "hello"})
+	r.NoError(err)
+	a.EqualValues(7, tr.Seq())
+}
+
+func TestFeedBuilderHMAC(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	hmacKey := bytes.Repeat([]byte{1}, 32)
+	b, err := NewFeedBuilder("hmac").WithHMAC(hmacKey)
+	r.NoError(err)
+	b.WithClock(NewClock(time.Unix(0, 0), time.Hour))
+	trs, err := b.Build(3)
+	r.NoError(err)
+
+	var key [32]byte
+	copy(key[:], hmacKey)
+	for i, tr := range trs {
+		a.True(tr.Verify(&key))
+		a.False(tr.Verify(nil))
+		a.EqualValues(i, tr.Claimed().Unix()/3600)
+	}
+
+	_, err = b.WithHMAC(hmacKey)
+	a.Error(err, "too late")
+}
@@ -0,0 +1,167 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+// Package gabbygrovetest generates random but valid gabbygrove data for tests.
+//
+// The generators take a *rand.Rand so failures can be reproduced from a seed.
+// Feed implements quick.Generator and can be used with testing/quick directly:
+//
+//	quick.Check(func(f gabbygrovetest.Feed) bool { ... }, nil)
+package gabbygrovetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
+
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+)
+
+// RandomKey returns a key pair derived from rnd
+func RandomKey(rnd *rand.Rand) ed25519.PrivateKey {
+	_, priv, err := ed25519.GenerateKey(rnd)
+	if err != nil {
+		panic(err) // reading from a math/rand source doesn't fail
+	}
+	return priv
+}
+
+// RandomContent returns the payload and type of a message: a JSON object, raw bytes (possibly empty) or (rarely) the largest content possible
+func RandomContent(rnd *rand.Rand) ([]byte, gabbygrove.ContentType) {
+	switch n := rnd.Intn(20); {
+	case n == 0:
+		return []byte{}, gabbygrove.ContentTypeArbitrary
+	case n == 1:
+		b := make([]byte, gabbygrove.DefaultLimits.MaxContentSize)
+		rnd.Read(b)
+		return b, gabbygrove.ContentTypeArbitrary
+	case n < 8:
+		b := make([]byte, 1+rnd.Intn(512))
+		rnd.Read(b)
+		return b, gabbygrove.ContentTypeArbitrary
+	default:
+		types := []string{"post", "contact", "vote", "about"}
+		val := map[string]interface{}{
+			"type": types[rnd.Intn(len(types))],
+			"text": randomText(rnd, rnd.Intn(256)),
+			"n":    rnd.Int63n(1 << 53),
+		}
+		b, err := json.Marshal(val)
+		if err != nil {
+			panic(err)
+		}
+		return b, gabbygrove.ContentTypeJSON
+	}
+}
+
+const alphabet = "abcdefghijklmnopqrstuvwxyz ABCDEFGHIJKLMNOPQRSTUVWXYZ 0123456789 äöü 🌲"
+
+func randomText(rnd *rand.Rand, n int) string {
+	chars := []rune(alphabet)
+	out := make([]rune, n)
+	for i := range out {
+		out[i] = chars[rnd.Intn(len(chars))]
+	}
+	return string(out)
+}
+
+// RandomExtensions returns nil most of the time and otherwise a small valid extensions map
+func RandomExtensions(rnd *rand.Rand) gabbygrove.Extensions {
+	if rnd.Intn(4) != 0 {
+		return nil
+	}
+	ext := make(gabbygrove.Extensions)
+	for i := 0; i < 1+rnd.Intn(3); i++ {
+		v := make([]byte, rnd.Intn(16))
+		rnd.Read(v)
+		ext[fmt.Sprintf("ext%d", rnd.Intn(100))] = v
+	}
+	return ext
+}
+
+// Message signs the event for content as the next message after prev (nil for the first one).
+// The timestamp is random, it's not checked by this package.
+func Message(rnd *rand.Rand, key ed25519.PrivateKey, seq uint64, prev *refs.MessageRef, content []byte, ctype gabbygrove.ContentType, ext gabbygrove.Extensions) (*gabbygrove.Transfer, error) {
+	author, err := refs.NewFeedRefFromBytes(key.Public().(ed25519.PublicKey), refs.RefAlgoFeedGabby)
+	if err != nil {
+		return nil, err
+	}
+
+	var evt gabbygrove.Event
+	if prev != nil {
+		br, err := gabbygrove.NewBinaryRef(*prev)
+		if err != nil {
+			return nil, err
+		}
+		evt.Previous = &br
+	}
+	if evt.Author, err = gabbygrove.NewBinaryRef(author); err != nil {
+		return nil, err
+	}
+	evt.Sequence = seq
+	evt.Timestamp = 1500000000 + rnd.Int63n(1<<31)
+	if evt.Content.Hash, err = gabbygrove.HashContent(content); err != nil {
+		return nil, err
+	}
+	evt.Content.Size = uint16(len(content))
+	evt.Content.Type = ctype
+	evt.Extensions = ext
+
+	evtBytes, err := evt.MarshalCBOR()
+	if err != nil {
+		return nil, err
+	}
+	return &gabbygrove.Transfer{
+		Event:     evtBytes,
+		Signature: ed25519.Sign(key, evtBytes),
+		Content:   content,
+	}, nil
+}
+
+// RandomTransfer returns the first message of a new random feed
+func RandomTransfer(rnd *rand.Rand) *gabbygrove.Transfer {
+	f := RandomFeed(rnd, 1)
+	return f.Messages[0]
+}
+
+// Feed is a valid chain of messages by one author
+type Feed struct {
+	Key      ed25519.PrivateKey
+	Author   refs.FeedRef
+	Messages []*gabbygrove.Transfer
+}
+
+// RandomFeed returns a new feed of n messages with random contents
+func RandomFeed(rnd *rand.Rand, n int) Feed {
+	key := RandomKey(rnd)
+	author, err := refs.NewFeedRefFromBytes(key.Public().(ed25519.PublicKey), refs.RefAlgoFeedGabby)
+	if err != nil {
+		panic(err)
+	}
+	f := Feed{Key: key, Author: author}
+
+	var prev *refs.MessageRef
+	for i := 0; i < n; i++ {
+		content, ctype := RandomContent(rnd)
+		tr, err := Message(rnd, key, uint64(i+1), prev, content, ctype, RandomExtensions(rnd))
+		if err != nil {
+			panic(err)
+		}
+		key := tr.Key()
+		prev = &key
+		f.Messages = append(f.Messages, tr)
+	}
+	return f
+}
+
+// Generate implements quick.Generator, the feed has between one and size messages
+func (Feed) Generate(rnd *rand.Rand, size int) reflect.Value {
+	if size < 1 {
+		size = 1
+	}
+	return reflect.ValueOf(RandomFeed(rnd, 1+rnd.Intn(size)))
+}
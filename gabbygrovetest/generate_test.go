@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrovetest
+
+import (
+	"math/rand"
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+)
+
+func TestRandomFeedIsValid(t *testing.T) {
+	r := require.New(t)
+
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 5; i++ {
+		f := RandomFeed(rnd, 25)
+		state := gabbygrove.NewFeedState(f.Author)
+		for _, tr := range f.Messages {
+			r.NoError(state.Append(tr))
+			r.NoError(tr.Validate(gabbygrove.DefaultLimits))
+			r.NoError(tr.VerifyAll(nil))
+		}
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	a := assert.New(t)
+
+	f1 := RandomFeed(rand.New(rand.NewSource(42)), 3)
+	f2 := RandomFeed(rand.New(rand.NewSource(42)), 3)
+	a.Equal(f1.Author, f2.Author)
+	for i := range f1.Messages {
+		a.Equal(f1.Messages[i].Key(), f2.Messages[i].Key())
+	}
+}
+
+func TestQuickRoundTrip(t *testing.T) {
+	roundTrip := func(f Feed) bool {
+		for _, tr := range f.Messages {
+			data, err := tr.MarshalCBOR()
+			if err != nil {
+				return false
+			}
+			var decoded gabbygrove.Transfer
+			if err := decoded.UnmarshalCBOR(data); err != nil {
+				return false
+			}
+			if decoded.Key() != tr.Key() || !decoded.Verify(nil) {
+				return false
+			}
+		}
+		return true
+	}
+	require.NoError(t, quick.Check(roundTrip, &quick.Config{MaxCount: 20}))
+
+	tr := RandomTransfer(rand.New(rand.NewSource(7)))
+	assert.EqualValues(t, 1, tr.Seq())
+}
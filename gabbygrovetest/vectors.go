@@ -0,0 +1,158 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+//go:build go1.16
+// +build go1.16
+
+package gabbygrovetest
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"sort"
+
+	"github.com/pkg/errors"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+)
+
+// Vector is a known encoding and what it's supposed to decode to.
+//
+// Vector files are JSON arrays of objects with these fields:
+//
+//	name      a short description, i.e. "first message"
+//	transfer  hex of an encoded transfer
+//	event     hex of an encoded event (instead of transfer)
+//	error     true if decoding (or verifying the signature of a transfer) needs to fail
+//	expected  the decoded fields, see VectorFields
+//
+// References are written as ssb URIs.
+type Vector struct {
+	Name     string        `json:"name"`
+	Transfer HexBytes      `json:"transfer,omitempty"`
+	Event    HexBytes      `json:"event,omitempty"`
+	Error    bool          `json:"error,omitempty"`
+	Expected *VectorFields `json:"expected,omitempty"`
+}
+
+// VectorFields are the expected fields of a vector
+type VectorFields struct {
+	// Key is the message key, only for transfers
+	Key string `json:"key,omitempty"`
+
+	// Previous is empty for the first message
+	Previous  string `json:"previous,omitempty"`
+	Author    string `json:"author"`
+	Sequence  uint64 `json:"sequence"`
+	Timestamp int64  `json:"timestamp"`
+
+	ContentHash string                 `json:"contentHash"`
+	ContentSize uint16                 `json:"contentSize"`
+	ContentType gabbygrove.ContentType `json:"contentType"`
+}
+
+// HexBytes is a byte slice written as a hex string in JSON
+type HexBytes []byte
+
+func (hb HexBytes) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(hb)), nil
+}
+
+func (hb *HexBytes) UnmarshalText(text []byte) error {
+	b, err := hex.DecodeString(string(text))
+	if err != nil {
+		return err
+	}
+	*hb = b
+	return nil
+}
+
+// LoadVectors reads the vectors of all *.json files at the root of fsys, in the order of their file names
+func LoadVectors(fsys fs.FS) ([]Vector, error) {
+	names, err := fs.Glob(fsys, "*.json")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	var all []Vector
+	for _, name := range names {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		var vs []Vector
+		if err := json.Unmarshal(data, &vs); err != nil {
+			return nil, errors.Wrapf(err, "gabbygrovetest: invalid vector file %s", name)
+		}
+		for i, v := range vs {
+			if (v.Transfer == nil) == (v.Event == nil) {
+				return nil, errors.Errorf("gabbygrovetest: %s: vector %d needs either a transfer or an event", name, i)
+			}
+			if !v.Error && v.Expected == nil {
+				return nil, errors.Errorf("gabbygrovetest: %s: vector %d has no expected fields", name, i)
+			}
+		}
+		all = append(all, vs...)
+	}
+	return all, nil
+}
+
+// Check decodes the vector and compares it with the expected fields
+func (v Vector) Check() error {
+	got, err := v.decode()
+	if v.Error {
+		if err == nil {
+			return errors.Errorf("vector %q: decoded without error", v.Name)
+		}
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "vector %q", v.Name)
+	}
+	if *got != *v.Expected {
+		return errors.Errorf("vector %q: decoded %+v, expected %+v", v.Name, *got, *v.Expected)
+	}
+	return nil
+}
+
+func (v Vector) decode() (*VectorFields, error) {
+	var (
+		evt *gabbygrove.Event
+		key string
+	)
+	if v.Transfer != nil {
+		var tr gabbygrove.Transfer
+		if err := tr.UnmarshalCBOR(v.Transfer); err != nil {
+			return nil, err
+		}
+		if !tr.Verify(nil) {
+			return nil, gabbygrove.ErrInvalidSignature
+		}
+		var err error
+		if evt, err = tr.UnmarshaledEvent(); err != nil {
+			return nil, err
+		}
+		key = tr.Key().URI()
+	} else {
+		evt = new(gabbygrove.Event)
+		if err := evt.UnmarshalCBOR(v.Event); err != nil {
+			return nil, err
+		}
+	}
+
+	f := VectorFields{
+		Key:         key,
+		Author:      evt.Author.URI(),
+		Sequence:    evt.Sequence,
+		Timestamp:   evt.Timestamp,
+		ContentHash: evt.Content.Hash.URI(),
+		ContentSize: evt.Content.Size,
+		ContentType: evt.Content.Type,
+	}
+	if evt.Previous != nil {
+		f.Previous = evt.Previous.URI()
+	}
+	return &f, nil
+}
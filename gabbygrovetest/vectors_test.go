@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+//go:build go1.16
+// +build go1.16
+
+package gabbygrovetest
+
+import (
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadVectors(t *testing.T) {
+	r := require.New(t)
+
+	vectors, err := LoadVectors(os.DirFS("../testdata/vectors"))
+	r.NoError(err)
+	r.NotEmpty(vectors)
+
+	var failing int
+	for _, v := range vectors {
+		r.NoError(v.Check())
+		if v.Error {
+			failing++
+		}
+	}
+	r.NotZero(failing)
+
+	// a vector that doesn't hold
+	v := vectors[0]
+	r.False(v.Error)
+	expected := *v.Expected
+	expected.Sequence++
+	v.Expected = &expected
+	r.Error(v.Check())
+}
+
+func TestLoadVectorsInvalid(t *testing.T) {
+	a := assert.New(t)
+
+	for name, content := range map[string]string{
+		"not json":       `{`,
+		"no data":        `[{"name":"x","expected":{}}]`,
+		"both":           `[{"name":"x","event":"00","transfer":"00","error":true}]`,
+		"no expectation": `[{"name":"x","event":"00"}]`,
+		"bad hex":        `[{"name":"x","event":"zz","error":true}]`,
+	} {
+		_, err := LoadVectors(fstest.MapFS{"v.json": {Data: []byte(content)}})
+		a.Error(err, name)
+	}
+
+	vs, err := LoadVectors(fstest.MapFS{"other.txt": {Data: []byte("ignored")}})
+	a.NoError(err)
+	a.Empty(vs)
+}
@@ -0,0 +1,211 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package gabbyrpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// GabbygroveClient is the client API for Gabbygrove service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type GabbygroveClient interface {
+	// Publish encodes, signs and stores the next message of the feed of the server
+	Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*PublishResponse, error)
+	// GetFeed streams the stored messages of a feed in order
+	GetFeed(ctx context.Context, in *GetFeedRequest, opts ...grpc.CallOption) (Gabbygrove_GetFeedClient, error)
+	// VerifyTransfer checks the signature and content of a single transfer, without storing it or looking at its feed
+	VerifyTransfer(ctx context.Context, in *VerifyTransferRequest, opts ...grpc.CallOption) (*VerifyTransferResponse, error)
+}
+
+type gabbygroveClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewGabbygroveClient(cc grpc.ClientConnInterface) GabbygroveClient {
+	return &gabbygroveClient{cc}
+}
+
+func (c *gabbygroveClient) Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*PublishResponse, error) {
+	out := new(PublishResponse)
+	err := c.cc.Invoke(ctx, "/gabbygrove.v1.Gabbygrove/Publish", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gabbygroveClient) GetFeed(ctx context.Context, in *GetFeedRequest, opts ...grpc.CallOption) (Gabbygrove_GetFeedClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Gabbygrove_ServiceDesc.Streams[0], "/gabbygrove.v1.Gabbygrove/GetFeed", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &gabbygroveGetFeedClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Gabbygrove_GetFeedClient interface {
+	Recv() (*Message, error)
+	grpc.ClientStream
+}
+
+type gabbygroveGetFeedClient struct {
+	grpc.ClientStream
+}
+
+func (x *gabbygroveGetFeedClient) Recv() (*Message, error) {
+	m := new(Message)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *gabbygroveClient) VerifyTransfer(ctx context.Context, in *VerifyTransferRequest, opts ...grpc.CallOption) (*VerifyTransferResponse, error) {
+	out := new(VerifyTransferResponse)
+	err := c.cc.Invoke(ctx, "/gabbygrove.v1.Gabbygrove/VerifyTransfer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GabbygroveServer is the server API for Gabbygrove service.
+// All implementations must embed UnimplementedGabbygroveServer
+// for forward compatibility
+type GabbygroveServer interface {
+	// Publish encodes, signs and stores the next message of the feed of the server
+	Publish(context.Context, *PublishRequest) (*PublishResponse, error)
+	// GetFeed streams the stored messages of a feed in order
+	GetFeed(*GetFeedRequest, Gabbygrove_GetFeedServer) error
+	// VerifyTransfer checks the signature and content of a single transfer, without storing it or looking at its feed
+	VerifyTransfer(context.Context, *VerifyTransferRequest) (*VerifyTransferResponse, error)
+	mustEmbedUnimplementedGabbygroveServer()
+}
+
+// UnimplementedGabbygroveServer must be embedded to have forward compatible implementations.
+type UnimplementedGabbygroveServer struct {
+}
+
+func (UnimplementedGabbygroveServer) Publish(context.Context, *PublishRequest) (*PublishResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Publish not implemented")
+}
+func (UnimplementedGabbygroveServer) GetFeed(*GetFeedRequest, Gabbygrove_GetFeedServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetFeed not implemented")
+}
+func (UnimplementedGabbygroveServer) VerifyTransfer(context.Context, *VerifyTransferRequest) (*VerifyTransferResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method VerifyTransfer not implemented")
+}
+func (UnimplementedGabbygroveServer) mustEmbedUnimplementedGabbygroveServer() {}
+
+// UnsafeGabbygroveServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to GabbygroveServer will
+// result in compilation errors.
+type UnsafeGabbygroveServer interface {
+	mustEmbedUnimplementedGabbygroveServer()
+}
+
+func RegisterGabbygroveServer(s grpc.ServiceRegistrar, srv GabbygroveServer) {
+	s.RegisterService(&Gabbygrove_ServiceDesc, srv)
+}
+
+func _Gabbygrove_Publish_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PublishRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GabbygroveServer).Publish(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gabbygrove.v1.Gabbygrove/Publish",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GabbygroveServer).Publish(ctx, req.(*PublishRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Gabbygrove_GetFeed_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetFeedRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(GabbygroveServer).GetFeed(m, &gabbygroveGetFeedServer{stream})
+}
+
+type Gabbygrove_GetFeedServer interface {
+	Send(*Message) error
+	grpc.ServerStream
+}
+
+type gabbygroveGetFeedServer struct {
+	grpc.ServerStream
+}
+
+func (x *gabbygroveGetFeedServer) Send(m *Message) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Gabbygrove_VerifyTransfer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyTransferRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GabbygroveServer).VerifyTransfer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gabbygrove.v1.Gabbygrove/VerifyTransfer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GabbygroveServer).VerifyTransfer(ctx, req.(*VerifyTransferRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Gabbygrove_ServiceDesc is the grpc.ServiceDesc for Gabbygrove service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Gabbygrove_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gabbygrove.v1.Gabbygrove",
+	HandlerType: (*GabbygroveServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Publish",
+			Handler:    _Gabbygrove_Publish_Handler,
+		},
+		{
+			MethodName: "VerifyTransfer",
+			Handler:    _Gabbygrove_VerifyTransfer_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "GetFeed",
+			Handler:       _Gabbygrove_GetFeed_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "gabbyrpc/gabbygrove.proto",
+}
@@ -0,0 +1,173 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+// Package gabbyrpc is a gRPC service around this implementation, so services in other languages can use it over the network.
+//
+// The service is defined in gabbygrove.proto. The Go code is generated from it with protoc-gen-go and protoc-gen-go-grpc:
+//
+//	protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative gabbyrpc/gabbygrove.proto
+package gabbyrpc
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/pkg/errors"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	refs "go.mindeco.de/ssb-refs"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Store is what the server keeps messages in, store.Store implements it
+type Store interface {
+	Append(tr *gabbygrove.Transfer) error
+	Tip(author refs.FeedRef) (gabbygrove.FeedState, error)
+	Iterate(author refs.FeedRef, from, to uint64, fn func(*gabbygrove.Transfer) error) error
+}
+
+// Server implements GabbygroveServer.
+// It publishes to the feed of one author and serves all feeds of its store.
+type Server struct {
+	UnimplementedGabbygroveServer
+
+	// mu serializes Publish, so the sequence of the feed is taken and appended at once
+	mu     sync.Mutex
+	enc    *gabbygrove.Encoder
+	author refs.FeedRef
+
+	store Store
+}
+
+var _ GabbygroveServer = (*Server)(nil)
+
+// NewServer publishes with enc, which signs for author, and keeps the messages in s
+func NewServer(enc *gabbygrove.Encoder, author refs.FeedRef, s Store) *Server {
+	return &Server{enc: enc, author: author, store: s}
+}
+
+// Publish encodes the content as the next message of the feed and stores it
+func (s *Server) Publish(ctx context.Context, req *PublishRequest) (*PublishResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tip, err := s.store.Tip(s.author)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get feed tip: %s", err)
+	}
+	seq, prev := tip.Next()
+
+	var tr *gabbygrove.Transfer
+	switch req.ContentType {
+	case ContentType_CONTENT_TYPE_ARBITRARY:
+		tr, _, err = s.enc.Encode(seq, prev, req.Content)
+	case ContentType_CONTENT_TYPE_JSON:
+		if !json.Valid(req.Content) {
+			return nil, status.Error(codes.InvalidArgument, "content is not valid JSON")
+		}
+		tr, _, err = s.enc.Encode(seq, prev, json.RawMessage(req.Content))
+	case ContentType_CONTENT_TYPE_CBOR:
+		tr, err = s.encodeCBOR(seq, prev, req.Content)
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported content type %d", req.ContentType)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to encode: %s", err)
+	}
+
+	if err := s.store.Append(tr); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to store: %s", err)
+	}
+	msg, err := newMessage(tr)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &PublishResponse{Message: msg}, nil
+}
+
+// encodeCBOR signs already encoded CBOR content, which the encoder can't produce from a value
+func (s *Server) encodeCBOR(seq uint64, prev gabbygrove.BinaryRef, content []byte) (*gabbygrove.Transfer, error) {
+	ref, err := gabbygrove.HashContent(content)
+	if err != nil {
+		return nil, err
+	}
+	tr, _, err := s.enc.EncodeWithContentHash(seq, prev, gabbygrove.ContentTypeCBOR, ref, len(content))
+	if err != nil {
+		return nil, err
+	}
+	tr.Content = content
+	return tr, nil
+}
+
+// GetFeed sends the stored messages of the requested feed
+func (s *Server) GetFeed(req *GetFeedRequest, stream Gabbygrove_GetFeedServer) error {
+	author, err := refs.ParseFeedRef(req.Feed)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid feed: %s", err)
+	}
+	if req.To != 0 && req.To < req.From {
+		return status.Error(codes.InvalidArgument, "to is before from")
+	}
+
+	ctx := stream.Context()
+	err = s.store.Iterate(author, req.From, req.To, func(tr *gabbygrove.Transfer) error {
+		if err := ctx.Err(); err != nil {
+			return status.FromContextError(err).Err()
+		}
+		msg, err := newMessage(tr)
+		if err != nil {
+			return err
+		}
+		return stream.Send(msg)
+	})
+	if _, isStatus := status.FromError(err); err != nil && !isStatus {
+		return status.Errorf(codes.Internal, "failed to read feed: %s", err)
+	}
+	return err
+}
+
+// VerifyTransfer checks the signature and the content of the transfer.
+// Invalid transfers are a valid request, only a broken HMAC key is an error.
+func (s *Server) VerifyTransfer(ctx context.Context, req *VerifyTransferRequest) (*VerifyTransferResponse, error) {
+	var hmacKey *[32]byte
+	if len(req.HmacKey) > 0 {
+		if len(req.HmacKey) != 32 {
+			return nil, status.Errorf(codes.InvalidArgument, "hmac key needs to be 32 bytes (got %d)", len(req.HmacKey))
+		}
+		hmacKey = new([32]byte)
+		copy(hmacKey[:], req.HmacKey)
+	}
+
+	var tr gabbygrove.Transfer
+	if err := tr.UnmarshalCBOR(req.Transfer); err != nil {
+		return &VerifyTransferResponse{Stage: gabbygrove.VerifyStructure.String(), Error: err.Error()}, nil
+	}
+	if err := tr.VerifyAll(hmacKey); err != nil {
+		res := &VerifyTransferResponse{Error: err.Error()}
+		var ve *gabbygrove.VerifyError
+		if errors.As(err, &ve) {
+			res.Stage = ve.Stage.String()
+		}
+		return res, nil
+	}
+	msg, err := newMessage(&tr)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &VerifyTransferResponse{Valid: true, Message: msg}, nil
+}
+
+func newMessage(tr *gabbygrove.Transfer) (*Message, error) {
+	data, err := tr.MarshalCBOR()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode transfer")
+	}
+	return &Message{
+		Key:      tr.Key().URI(),
+		Author:   tr.Author().URI(),
+		Sequence: uint64(tr.Seq()),
+		Transfer: data,
+	}, nil
+}
@@ -0,0 +1,129 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbyrpc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	"go.mindeco.de/ssb-gabbygrove/store"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestServer(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbyrpc")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	st, err := store.Open(dir)
+	r.NoError(err)
+	defer st.Close()
+
+	pub, priv, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte("beef"), 8)))
+	r.NoError(err)
+	author, err := refs.NewFeedRefFromBytes(pub, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	RegisterGabbygroveServer(srv, NewServer(gabbygrove.NewEncoder(priv), author, st))
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	r.NoError(err)
+	defer conn.Close()
+	c := NewGabbygroveClient(conn)
+	ctx := context.Background()
+
+	for i, req := range []*PublishRequest{
+		{Content: []byte(`{"type":"test"}`), ContentType: ContentType_CONTENT_TYPE_JSON},
+		{Content: []byte("raw bytes"), ContentType: ContentType_CONTENT_TYPE_ARBITRARY},
+		{Content: []byte{0x63, 'f', 'o', 'o'}, ContentType: ContentType_CONTENT_TYPE_CBOR},
+	} {
+		res, err := c.Publish(ctx, req)
+		r.NoError(err)
+		a.EqualValues(i+1, res.Message.Sequence)
+		a.Equal(author.URI(), res.Message.Author)
+	}
+
+	_, err = c.Publish(ctx, &PublishRequest{Content: []byte("{"), ContentType: ContentType_CONTENT_TYPE_JSON})
+	a.Equal(codes.InvalidArgument, status.Code(err))
+
+	feed, err := c.GetFeed(ctx, &GetFeedRequest{Feed: author.URI(), From: 2})
+	r.NoError(err)
+	var msgs []*Message
+	for {
+		msg, err := feed.Recv()
+		if err == io.EOF {
+			break
+		}
+		r.NoError(err)
+		msgs = append(msgs, msg)
+	}
+	r.Len(msgs, 2)
+	a.EqualValues(3, msgs[1].Sequence)
+
+	var tr gabbygrove.Transfer
+	r.NoError(tr.UnmarshalCBOR(msgs[1].Transfer))
+	evt, err := tr.DecodedEvent()
+	r.NoError(err)
+	a.Equal(gabbygrove.ContentTypeCBOR, evt.Content.Type)
+	a.Equal(tr.Key().URI(), msgs[1].Key)
+
+	_, err = recvErr(c.GetFeed(ctx, &GetFeedRequest{Feed: "nope"}))
+	a.Equal(codes.InvalidArgument, status.Code(err))
+
+	// verification results
+	res, err := c.VerifyTransfer(ctx, &VerifyTransferRequest{Transfer: msgs[1].Transfer})
+	r.NoError(err)
+	a.True(res.Valid, res.Error)
+	a.Equal(msgs[1].Key, res.Message.Key)
+
+	tr.Content = []byte{0x63, 'b', 'a', 'r'}
+	tampered, err := tr.MarshalCBOR()
+	r.NoError(err)
+	res, err = c.VerifyTransfer(ctx, &VerifyTransferRequest{Transfer: tampered})
+	r.NoError(err)
+	a.False(res.Valid)
+	a.Equal(gabbygrove.VerifyContentHash.String(), res.Stage)
+
+	res, err = c.VerifyTransfer(ctx, &VerifyTransferRequest{Transfer: msgs[1].Transfer, HmacKey: bytes.Repeat([]byte("h"), 32)})
+	r.NoError(err)
+	a.False(res.Valid)
+	a.Equal(gabbygrove.VerifySignature.String(), res.Stage)
+
+	res, err = c.VerifyTransfer(ctx, &VerifyTransferRequest{Transfer: []byte("garbage")})
+	r.NoError(err)
+	a.False(res.Valid)
+	a.Equal(gabbygrove.VerifyStructure.String(), res.Stage)
+
+	_, err = c.VerifyTransfer(ctx, &VerifyTransferRequest{Transfer: msgs[1].Transfer, HmacKey: []byte("short")})
+	a.Equal(codes.InvalidArgument, status.Code(err))
+}
+
+func recvErr(s Gabbygrove_GetFeedClient, err error) (*Message, error) {
+	if err != nil {
+		return nil, err
+	}
+	return s.Recv()
+}
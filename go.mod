@@ -5,13 +5,18 @@
 module go.mindeco.de/ssb-gabbygrove
 
 require (
+	github.com/klauspost/compress v1.13.0
 	github.com/kr/pretty v0.1.0 // indirect
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.6.1
 	github.com/ugorji/go/codec v1.1.7
+	go.etcd.io/bbolt v1.3.6
 	go.mindeco.de v1.12.0
 	go.mindeco.de/ssb-refs v0.5.1
 	golang.org/x/crypto v0.0.0-20200604202706-70a84ac30bf9
+	golang.org/x/text v0.3.3
+	google.golang.org/grpc v1.38.0
+	google.golang.org/protobuf v1.26.0
 	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
 )
 
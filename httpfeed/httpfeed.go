@@ -0,0 +1,174 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+// Package httpfeed serves the feeds of a store over HTTP, for simple web gateways and bridges.
+//
+// GET /feed/{ref}?from=&to= streams the messages of the feed from sequence from up to and including to.
+// Both are optional, ref is the URI or sigil of the feed.
+// By default the transfers are sent one after the other as a CBOR sequence (application/cbor-seq, RFC 8742).
+// With format=json or an Accept header of application/x-ndjson, every message is one line of JSON instead, see Line.
+//
+// IngestHandler is the other direction, it takes transfers over POST and validates them before they are stored.
+package httpfeed
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// Store is what the handler reads feeds from, store.Store implements it
+type Store interface {
+	// Iterate passes the messages of author from sequence from up to and including to, zero meaning up to the tip
+	Iterate(author refs.FeedRef, from, to uint64, fn func(*gabbygrove.Transfer) error) error
+}
+
+const (
+	contentTypeCBOR = "application/cbor-seq"
+	contentTypeJSON = "application/x-ndjson"
+)
+
+// Line is one message in the JSON lines format
+type Line struct {
+	Key string `json:"key"`
+
+	// Value is the message as gabbygrove.Transfer.ValueContent returns it, its signature can't be verified in this form
+	Value json.RawMessage `json:"value"`
+
+	// Transfer is the encoded transfer, which can be verified
+	Transfer []byte `json:"transfer"`
+}
+
+// Handler serves feeds of a store
+type Handler struct {
+	store Store
+	limit uint64
+}
+
+var _ http.Handler = (*Handler)(nil)
+
+// NewHandler returns a handler serving the feeds of s
+func NewHandler(s Store) *Handler {
+	return &Handler{store: s}
+}
+
+// WithLimit caps the number of messages sent for one request, zero means no limit.
+// Clients can page through longer feeds with from.
+func (h *Handler) WithLimit(n uint64) {
+	h.limit = n
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	const prefix = "/feed/"
+	if !strings.HasPrefix(req.URL.Path, prefix) {
+		http.NotFound(w, req)
+		return
+	}
+	author, err := refs.ParseFeedRef(strings.TrimPrefix(req.URL.Path, prefix))
+	if err != nil {
+		http.Error(w, "invalid feed reference", http.StatusBadRequest)
+		return
+	}
+
+	q := req.URL.Query()
+	from, err := parseSeq(q.Get("from"))
+	if err != nil {
+		http.Error(w, "invalid from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseSeq(q.Get("to"))
+	if err != nil {
+		http.Error(w, "invalid to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if from < 1 {
+		from = 1
+	}
+	if to != 0 && to < from {
+		http.Error(w, "to is before from", http.StatusBadRequest)
+		return
+	}
+	if h.limit > 0 && (to == 0 || to-from+1 > h.limit) {
+		to = from + h.limit - 1
+	}
+
+	asJSON := q.Get("format") == "json" || strings.Contains(req.Header.Get("Accept"), contentTypeJSON)
+	switch f := q.Get("format"); {
+	case f != "" && f != "json" && f != "cbor":
+		http.Error(w, "unsupported format", http.StatusBadRequest)
+		return
+	case f == "cbor":
+		asJSON = false
+	}
+	if asJSON {
+		w.Header().Set("Content-Type", contentTypeJSON)
+	} else {
+		w.Header().Set("Content-Type", contentTypeCBOR)
+	}
+	if req.Method == http.MethodHead {
+		return
+	}
+
+	bw := bufio.NewWriter(w)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(bw)
+	var sent bool
+	err = h.store.Iterate(author, from, to, func(tr *gabbygrove.Transfer) error {
+		data, err := tr.MarshalCBOR()
+		if err != nil {
+			return err
+		}
+		if asJSON {
+			err = enc.Encode(Line{Key: tr.Key().URI(), Value: tr.ValueContentJSON(), Transfer: data})
+		} else {
+			_, err = bw.Write(data)
+		}
+		if err != nil {
+			return errWrite{err}
+		}
+		sent = true
+		if err := bw.Flush(); err != nil {
+			return errWrite{err}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	switch {
+	case err == nil:
+		bw.Flush()
+	case errors.As(err, new(errWrite)):
+		// the client went away
+	case !sent:
+		http.Error(w, "failed to read feed", http.StatusInternalServerError)
+	default:
+		// the status is already sent, cutting the connection is the only way to tell the client the stream is incomplete
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// errWrite marks errors of writing the response, as opposed to reading the store
+type errWrite struct{ err error }
+
+func (e errWrite) Error() string { return e.err.Error() }
+
+func parseSeq(s string) (uint64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
@@ -0,0 +1,161 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package httpfeed
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	"go.mindeco.de/ssb-gabbygrove/store"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+)
+
+func makeFeed(t *testing.T, s *store.Store, n int) refs.FeedRef {
+	r := require.New(t)
+
+	pub, priv, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte("beef"), 8)))
+	r.NoError(err)
+	author, err := refs.NewFeedRefFromBytes(pub, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+
+	e := gabbygrove.NewEncoder(priv)
+	state := gabbygrove.NewFeedState(author)
+	for i := 0; i < n; i++ {
+		seq, prev := state.Next()
+		tr, _, err := e.Encode(seq, prev, map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+		r.NoError(state.Append(tr))
+		r.NoError(s.Append(tr))
+	}
+	return author
+}
+
+func TestHandler(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "httpfeed")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	s, err := store.Open(dir)
+	r.NoError(err)
+	defer s.Close()
+	author := makeFeed(t, s, 5)
+
+	h := NewHandler(s)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	get := func(query string, header ...string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/feed/"+url.PathEscape(author.URI())+query, nil)
+		r.NoError(err)
+		if len(header) == 2 {
+			req.Header.Set(header[0], header[1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		r.NoError(err)
+		return resp
+	}
+
+	// a CBOR sequence of verifiable transfers
+	resp := get("?from=2&to=4")
+	r.Equal(http.StatusOK, resp.StatusCode)
+	a.Equal(contentTypeCBOR, resp.Header.Get("Content-Type"))
+	var seqs []int64
+	for {
+		var tr gabbygrove.Transfer
+		err := tr.DecodeFrom(resp.Body)
+		if err == io.EOF {
+			break
+		}
+		r.NoError(err)
+		a.True(tr.Verify(nil))
+		seqs = append(seqs, tr.Seq())
+	}
+	resp.Body.Close()
+	a.Equal([]int64{2, 3, 4}, seqs)
+
+	// JSON lines, by query or header
+	for _, resp := range []*http.Response{get("?format=json&from=4"), get("?from=4", "Accept", contentTypeJSON)} {
+		r.Equal(http.StatusOK, resp.StatusCode)
+		a.Equal(contentTypeJSON, resp.Header.Get("Content-Type"))
+		var lines []Line
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			var l Line
+			r.NoError(json.Unmarshal(sc.Bytes(), &l))
+			lines = append(lines, l)
+		}
+		resp.Body.Close()
+		r.Len(lines, 2)
+
+		var tr gabbygrove.Transfer
+		r.NoError(tr.UnmarshalCBOR(lines[1].Transfer))
+		a.Equal(tr.Key().URI(), lines[1].Key)
+		var val struct{ Sequence int64 }
+		r.NoError(json.Unmarshal(lines[1].Value, &val))
+		a.EqualValues(5, val.Sequence)
+	}
+
+	// the limit caps open ranges
+	h.WithLimit(2)
+	resp = get("?format=json")
+	body, err := ioutil.ReadAll(resp.Body)
+	r.NoError(err)
+	resp.Body.Close()
+	a.Equal(2, bytes.Count(body, []byte("\n")))
+
+	for _, query := range []string{"?from=x", "?to=-1", "?from=3&to=2", "?format=xml"} {
+		resp := get(query)
+		resp.Body.Close()
+		a.Equal(http.StatusBadRequest, resp.StatusCode, query)
+	}
+
+	resp, err = http.Get(srv.URL + "/feed/nope")
+	r.NoError(err)
+	resp.Body.Close()
+	a.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = http.Get(srv.URL + "/other")
+	r.NoError(err)
+	resp.Body.Close()
+	a.Equal(http.StatusNotFound, resp.StatusCode)
+
+	resp, err = http.Post(srv.URL+"/feed/"+url.PathEscape(author.URI()), "text/plain", nil)
+	r.NoError(err)
+	resp.Body.Close()
+	a.Equal(http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+type failingStore struct{}
+
+func (failingStore) Iterate(refs.FeedRef, uint64, uint64, func(*gabbygrove.Transfer) error) error {
+	return errors.New("broken disk")
+}
+
+func TestHandlerStoreError(t *testing.T) {
+	a := assert.New(t)
+
+	author, err := refs.NewFeedRefFromBytes(bytes.Repeat([]byte{1}, 32), refs.RefAlgoFeedGabby)
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/feed/"+url.PathEscape(author.URI()), nil)
+	NewHandler(failingStore{}).ServeHTTP(rec, req)
+	a.Equal(http.StatusInternalServerError, rec.Code)
+}
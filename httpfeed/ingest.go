@@ -0,0 +1,198 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package httpfeed
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+)
+
+// DefaultMaxBody is the largest request body IngestHandler reads by default
+const DefaultMaxBody = 4 << 20
+
+// IngestHandler takes transfers in a POST request and validates them with a gabbygrove.Validator.
+// The body is a CBOR sequence of transfers, like the one Handler sends. They are processed in order
+// and the first invalid one stops the request, the ones before it are kept.
+// Messages that arrive ahead of their feed are buffered by the validator and only reported once they are appended.
+//
+// The response is an IngestResult as JSON and the status code reflects its error, if any.
+type IngestHandler struct {
+	v       *gabbygrove.Validator
+	sink    func(*gabbygrove.Transfer) error
+	maxBody int64
+}
+
+var _ http.Handler = (*IngestHandler)(nil)
+
+// NewIngestHandler validates posted transfers with v and passes appended ones to sink, i.e. store.Store.Append
+func NewIngestHandler(v *gabbygrove.Validator, sink func(*gabbygrove.Transfer) error) *IngestHandler {
+	return &IngestHandler{v: v, sink: sink, maxBody: DefaultMaxBody}
+}
+
+// WithMaxBody sets the largest request body in bytes
+func (ih *IngestHandler) WithMaxBody(n int64) {
+	ih.maxBody = n
+}
+
+// IngestResult is the response of the IngestHandler
+type IngestResult struct {
+	Appended []AppendedMessage `json:"appended"`
+	Error    *IngestError      `json:"error,omitempty"`
+}
+
+// AppendedMessage is a message that was validated and passed on
+type AppendedMessage struct {
+	Key      string `json:"key"`
+	Author   string `json:"author"`
+	Sequence int64  `json:"sequence"`
+}
+
+// IngestError describes why a transfer was rejected
+type IngestError struct {
+	// Code is one of the Code constants
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// Index is the position of the rejected transfer in the request, starting at zero
+	Index int `json:"index"`
+
+	// RetryAfter is set in seconds for CodeQuota
+	RetryAfter float64 `json:"retry_after,omitempty"`
+}
+
+// The codes of an IngestError
+const (
+	CodeMalformed        = "malformed"
+	CodeTooLarge         = "too_large"
+	CodeInvalid          = "invalid"
+	CodeContent          = "invalid_content"
+	CodeContentHash      = "content_hash"
+	CodeInvalidSignature = "invalid_signature"
+	CodeWrongSequence    = "wrong_sequence"
+	CodeBrokenChain      = "broken_chain"
+	CodeBlockedAuthor    = "blocked_author"
+	CodeQuota            = "quota_exceeded"
+	CodeBufferFull       = "buffer_full"
+	CodeInternal         = "internal"
+)
+
+func (ih *IngestHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body := http.MaxBytesReader(w, req.Body, ih.maxBody)
+	res := IngestResult{Appended: []AppendedMessage{}}
+	status := http.StatusOK
+	for i := 0; ; i++ {
+		var tr gabbygrove.Transfer
+		err := tr.DecodeFrom(body)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			status, res.Error = http.StatusBadRequest, &IngestError{Code: CodeMalformed, Message: err.Error(), Index: i}
+			if tooLarge(err) {
+				status, res.Error.Code = http.StatusRequestEntityTooLarge, CodeTooLarge
+			}
+			break
+		}
+
+		appended, err := ih.ingest(&tr)
+		for _, a := range appended {
+			res.Appended = append(res.Appended, AppendedMessage{
+				Key:      a.Key().URI(),
+				Author:   a.Author().URI(),
+				Sequence: a.Seq(),
+			})
+		}
+		if err != nil {
+			status, res.Error = classify(err)
+			res.Error.Index = i
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if res.Error != nil && res.Error.Code == CodeQuota {
+		w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(res.Error.RetryAfter)), 10))
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
+
+// ingest checks what the validator doesn't (limits and the content hash) and passes the appended messages to the sink
+func (ih *IngestHandler) ingest(tr *gabbygrove.Transfer) ([]*gabbygrove.Transfer, error) {
+	if err := tr.Validate(gabbygrove.DefaultLimits); err != nil {
+		return nil, err
+	}
+	if tr.HasContent() && !tr.ContentMatches(tr.Content) {
+		return nil, gabbygrove.ErrContentHash
+	}
+
+	appended, err := ih.v.Append(tr)
+	if err != nil {
+		return nil, err
+	}
+	for i, a := range appended {
+		if err := ih.sink(a); err != nil {
+			return appended[:i], errSink{err}
+		}
+	}
+	return appended, nil
+}
+
+// errSink marks errors of the sink, which are the server's fault
+type errSink struct{ err error }
+
+func (e errSink) Error() string { return e.err.Error() }
+
+// classify maps err to a status code and an IngestError
+func classify(err error) (int, *IngestError) {
+	ie := &IngestError{Message: err.Error()}
+	var quota *gabbygrove.QuotaError
+	switch {
+	case errors.As(err, new(errSink)):
+		ie.Code, ie.Message = CodeInternal, "failed to store message"
+		return http.StatusInternalServerError, ie
+	case errors.As(err, &quota):
+		ie.Code, ie.RetryAfter = CodeQuota, quota.RetryAfter.Seconds()
+		return http.StatusTooManyRequests, ie
+	case errors.Is(err, gabbygrove.ErrBufferFull):
+		ie.Code = CodeBufferFull
+		return http.StatusTooManyRequests, ie
+	case errors.Is(err, gabbygrove.ErrBlockedAuthor):
+		ie.Code = CodeBlockedAuthor
+		return http.StatusForbidden, ie
+	case errors.Is(err, gabbygrove.ErrWrongSequence):
+		ie.Code = CodeWrongSequence
+		return http.StatusConflict, ie
+	case errors.Is(err, gabbygrove.ErrBrokenChain):
+		ie.Code = CodeBrokenChain
+		return http.StatusConflict, ie
+	case errors.Is(err, gabbygrove.ErrInvalidSignature):
+		ie.Code = CodeInvalidSignature
+	case errors.Is(err, gabbygrove.ErrContentHash):
+		ie.Code = CodeContentHash
+	case errors.Is(err, gabbygrove.ErrContentSize), errors.Is(err, gabbygrove.ErrEmptyContent):
+		ie.Code = CodeContent
+	default:
+		ie.Code = CodeInvalid
+	}
+	return http.StatusBadRequest, ie
+}
+
+// tooLarge returns true if err comes from the http.MaxBytesReader, which has no error value to compare to
+func tooLarge(err error) bool {
+	return errors.Cause(err).Error() == "http: request body too large"
+}
@@ -0,0 +1,151 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package httpfeed
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+)
+
+func encodeFeed(t *testing.T, seed string, n int) (refs.FeedRef, []*gabbygrove.Transfer) {
+	r := require.New(t)
+
+	pub, priv, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte(seed), 32/len(seed))))
+	r.NoError(err)
+	author, err := refs.NewFeedRefFromBytes(pub, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+
+	e := gabbygrove.NewEncoder(priv)
+	state := gabbygrove.NewFeedState(author)
+	var trs []*gabbygrove.Transfer
+	for i := 0; i < n; i++ {
+		seq, prev := state.Next()
+		tr, _, err := e.Encode(seq, prev, map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+		r.NoError(state.Append(tr))
+		trs = append(trs, tr)
+	}
+	return author, trs
+}
+
+func body(t *testing.T, trs ...*gabbygrove.Transfer) *bytes.Buffer {
+	var buf bytes.Buffer
+	for _, tr := range trs {
+		b, err := tr.MarshalCBOR()
+		require.NoError(t, err)
+		buf.Write(b)
+	}
+	return &buf
+}
+
+func TestIngestHandler(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	var stored []*gabbygrove.Transfer
+	var sinkErr error
+	v := gabbygrove.NewValidator(2)
+	ih := NewIngestHandler(v, func(tr *gabbygrove.Transfer) error {
+		if sinkErr != nil {
+			return sinkErr
+		}
+		stored = append(stored, tr)
+		return nil
+	})
+
+	post := func(b *bytes.Buffer) (int, IngestResult) {
+		rec := httptest.NewRecorder()
+		ih.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ingest", b))
+		var res IngestResult
+		r.NoError(json.Unmarshal(rec.Body.Bytes(), &res), rec.Body.String())
+		return rec.Code, res
+	}
+
+	author, trs := encodeFeed(t, "dead", 6)
+
+	code, res := post(body(t, trs[0], trs[1]))
+	a.Equal(http.StatusOK, code)
+	a.Nil(res.Error)
+	r.Len(res.Appended, 2)
+	a.Equal(trs[1].Key().URI(), res.Appended[1].Key)
+	a.Equal(author.URI(), res.Appended[1].Author)
+	a.EqualValues(2, res.Appended[1].Sequence)
+	a.Len(stored, 2)
+
+	// out of order is buffered and reported once it's appended
+	code, res = post(body(t, trs[3]))
+	a.Equal(http.StatusOK, code)
+	a.Len(res.Appended, 0)
+	code, res = post(body(t, trs[2]))
+	a.Equal(http.StatusOK, code)
+	a.Len(res.Appended, 2)
+
+	// a valid message before an invalid one is kept
+	tampered := *trs[5]
+	tampered.Content = bytes.Replace(trs[5].Content, []byte("5"), []byte("6"), 1)
+	code, res = post(body(t, trs[4], &tampered))
+	a.Equal(http.StatusBadRequest, code)
+	a.Len(res.Appended, 1)
+	r.NotNil(res.Error)
+	a.Equal(CodeContentHash, res.Error.Code)
+	a.Equal(1, res.Error.Index)
+
+	tampered.Content = append(tampered.Content, ' ')
+	code, res = post(body(t, &tampered))
+	a.Equal(http.StatusBadRequest, code)
+	a.Equal(CodeContent, res.Error.Code)
+
+	badSig := *trs[5]
+	badSig.Signature = append([]byte{}, trs[5].Signature...)
+	badSig.Signature[0] ^= 1
+	code, res = post(body(t, &badSig))
+	a.Equal(http.StatusBadRequest, code)
+	a.Equal(CodeInvalidSignature, res.Error.Code)
+
+	code, res = post(bytes.NewBufferString("not cbor"))
+	a.Equal(http.StatusBadRequest, code)
+	a.Equal(CodeMalformed, res.Error.Code)
+
+	ih.WithMaxBody(10)
+	code, res = post(body(t, trs[5]))
+	a.Equal(http.StatusRequestEntityTooLarge, code)
+	a.Equal(CodeTooLarge, res.Error.Code)
+	ih.WithMaxBody(DefaultMaxBody)
+
+	sinkErr = errors.New("disk full")
+	code, res = post(body(t, trs[5]))
+	a.Equal(http.StatusInternalServerError, code)
+	a.Equal(CodeInternal, res.Error.Code)
+	a.NotContains(res.Error.Message, "disk full")
+	sinkErr = nil
+
+	// quotas and blocked authors
+	other, otherTrs := encodeFeed(t, "beef", 2)
+	v.WithLimiter(gabbygrove.NewQuotaLimiter(gabbygrove.Quota{MessagesPerMinute: 1}))
+	code, res = post(body(t, otherTrs[0], otherTrs[1]))
+	a.Equal(http.StatusTooManyRequests, code)
+	a.Equal(CodeQuota, res.Error.Code)
+	a.True(res.Error.RetryAfter > 0 && res.Error.RetryAfter <= time.Minute.Seconds())
+
+	v.WithAuthorFilter(gabbygrove.NewDenyList(other))
+	code, res = post(body(t, otherTrs[1]))
+	a.Equal(http.StatusForbidden, code)
+	a.Equal(CodeBlockedAuthor, res.Error.Code)
+
+	rec := httptest.NewRecorder()
+	ih.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ingest", nil))
+	a.Equal(http.StatusMethodNotAllowed, rec.Code)
+}
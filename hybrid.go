@@ -0,0 +1,153 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/nacl/auth"
+)
+
+// The hybrid mode is an experiment for researching post-quantum feeds, it is not part of gabbygrove-v1
+// and no other implementation understands it. A hybrid event is signed with ed25519 like any other
+// and additionally with a second algorithm, whose signature is appended to the transfer as a fourth element.
+// Other implementations keep it as an unknown element, a strict Decoder rejects such transfers.
+// The message key only covers the ed25519 signature.
+//
+// To keep the second signature from being stripped, every hybrid event carries the extension
+// HybridExtension, which names the second algorithm and commits to the hash of its public key.
+// The format of both may change with the version in the extension key.
+
+// SuiteHybridExperimental names the hybrid mode, it's not accepted by ParseSignatureSuite
+const SuiteHybridExperimental SignatureSuite = "ed25519+experimental"
+
+// HybridExtension is the key of the extension that marks hybrid events
+const HybridExtension = "x-hybrid-v0"
+
+// ErrNotHybrid is returned by VerifyHybrid for messages that are not signed in the hybrid mode
+var ErrNotHybrid = errors.New("gabbygrove: not a hybrid message")
+
+// HybridSigner makes the second signature of the hybrid mode, i.e. with Dilithium from another package.
+type HybridSigner interface {
+	// Algorithm names the second algorithm, like dilithium3. It's signed as part of every event.
+	Algorithm() string
+
+	// Public returns the public key of the second algorithm
+	Public() []byte
+
+	// Sign returns the signature of msg, the same bytes that are signed with ed25519
+	Sign(msg []byte) ([]byte, error)
+}
+
+// HybridVerifier checks second signatures of the hybrid mode
+type HybridVerifier interface {
+	// Algorithm needs to be the same as the one of the HybridSigner
+	Algorithm() string
+
+	// Verify returns true if sig is a valid signature of msg by pub
+	Verify(pub, msg, sig []byte) bool
+}
+
+// WithExperimentalHybrid makes the encoder sign every event a second time with hs, nil turns it off again.
+// The resulting feed is NOT interoperable, see SuiteHybridExperimental.
+// Previews leave out the size of the second signature and CheckDeterministic only covers the ed25519 part.
+func (e *Encoder) WithExperimentalHybrid(hs HybridSigner) error {
+	if hs == nil {
+		e.hybrid = nil
+		return nil
+	}
+	algo := hs.Algorithm()
+	if algo == "" || bytes.IndexByte([]byte(algo), 0) >= 0 {
+		return errors.Errorf("gabbygrove/hybrid: invalid algorithm name %q", algo)
+	}
+	commit := hybridCommitment(algo, hs.Public())
+	if _, err := withHybridExtension(e.extensions, commit).marshal(); err != nil {
+		return err
+	}
+	e.hybrid = &hybridSigner{signer: hs, commitment: commit}
+	return nil
+}
+
+// hybridSigner is set by WithExperimentalHybrid
+type hybridSigner struct {
+	signer     HybridSigner
+	commitment []byte
+}
+
+// hybridCommitment is the value of HybridExtension: the algorithm, a zero byte and the hash of the public key
+func hybridCommitment(algo string, pub []byte) []byte {
+	sum := sum256(pub)
+	return append(append([]byte(algo), 0), sum[:]...)
+}
+
+// withHybridExtension returns ext with the hybrid extension added, without changing ext
+func withHybridExtension(ext Extensions, commitment []byte) Extensions {
+	withHybrid := make(Extensions, len(ext)+1)
+	for k, v := range ext {
+		withHybrid[k] = v
+	}
+	withHybrid[HybridExtension] = commitment
+	return withHybrid
+}
+
+// eventExtensions returns the extensions of a new event
+func (e *Encoder) eventExtensions() Extensions {
+	if e.hybrid == nil {
+		return e.extensions
+	}
+	return withHybridExtension(e.extensions, e.hybrid.commitment)
+}
+
+// signHybrid appends the second signature to tr
+func (e *Encoder) signHybrid(tr *Transfer) error {
+	sig, err := e.hybrid.signer.Sign(e.toSign(tr.Event))
+	if err != nil {
+		return errors.Wrap(err, "gabbygrove/hybrid: failed to sign event")
+	}
+	tr.unknown = [][]byte{appendCBORBytes(nil, sig)}
+	if n := tr.encodedLen(); n > maxTransferSize {
+		return errors.Errorf("gabbygrove/hybrid: transfer too large (%d bytes), use smaller content", n)
+	}
+	return nil
+}
+
+// VerifyHybrid is VerifyAll for a message of the hybrid mode that also checks its second signature
+// against pub with hv. It fails with ErrNotHybrid for messages without the hybrid extension
+// and like VerifyAll with a *VerifyError otherwise.
+func (tr *Transfer) VerifyHybrid(hmacKey *[32]byte, hv HybridVerifier, pub []byte) error {
+	if err := tr.VerifyAll(hmacKey); err != nil {
+		return err
+	}
+	evt, err := tr.getEvent()
+	if err != nil {
+		return err
+	}
+	commitment, has := evt.Extensions[HybridExtension]
+	if !has {
+		return ErrNotHybrid
+	}
+	if !bytes.Equal(commitment, hybridCommitment(hv.Algorithm(), pub)) {
+		return &VerifyError{Stage: VerifyStructure, Err: errors.New("gabbygrove/hybrid: algorithm or public key doesn't match the event")}
+	}
+	if len(tr.unknown) != 1 {
+		return &VerifyError{Stage: VerifyStructure, Err: errors.Errorf("gabbygrove/hybrid: %d extra transfer elements instead of the second signature", len(tr.unknown))}
+	}
+	p := cborParser{data: tr.unknown[0]}
+	sig, err := p.bytes()
+	if err != nil || sig == nil {
+		return &VerifyError{Stage: VerifyStructure, Err: errors.New("gabbygrove/hybrid: second signature is not a byte string")}
+	}
+
+	toVerify := tr.Event
+	if hmacKey != nil {
+		mac := auth.Sum(tr.Event, hmacKey)
+		toVerify = mac[:]
+	}
+	if !hv.Verify(pub, toVerify, sig) {
+		return &VerifyError{Stage: VerifySignature, Err: errors.Wrap(ErrInvalidSignature, "second signature")}
+	}
+	return nil
+}
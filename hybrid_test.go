@@ -0,0 +1,140 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+)
+
+// edHybrid stands in for a post-quantum algorithm with a second ed25519 key
+type edHybrid struct {
+	algo string
+	priv ed25519.PrivateKey
+}
+
+func (h edHybrid) Algorithm() string { return h.algo }
+func (h edHybrid) Public() []byte    { return h.priv.Public().(ed25519.PublicKey) }
+
+func (h edHybrid) Sign(msg []byte) ([]byte, error) {
+	return ed25519.Sign(h.priv, msg), nil
+}
+
+func (h edHybrid) Verify(pub, msg, sig []byte) bool {
+	return ed25519.Verify(pub, msg, sig)
+}
+
+func TestExperimentalHybrid(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("hybr"), 8)))
+	_, secondKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("pqpq"), 8)))
+	second := edHybrid{algo: "test-ed25519", priv: secondKey}
+
+	e := NewEncoder(privKey)
+	r.NoError(e.WithExtensions(Extensions{"app": []byte("x")}))
+	a.Error(e.WithExperimentalHybrid(edHybrid{algo: "bad\x00name", priv: secondKey}))
+	r.NoError(e.WithExperimentalHybrid(second))
+
+	tr, key, err := e.Encode(1, BinaryRef{}, []byte("hybrid"))
+	r.NoError(err)
+	a.Equal(key, tr.Key())
+	a.NoError(tr.VerifyAll(nil), "the ed25519 part verifies like any message")
+	a.NoError(tr.VerifyHybrid(nil, second, second.Public()))
+	suite, err := tr.Suite()
+	r.NoError(err)
+	a.Equal(SuiteHybridExperimental, suite)
+	ext, err := tr.Extensions()
+	r.NoError(err)
+	a.Equal("x", string(ext["app"]))
+
+	// plain decoding keeps the second signature, a strict decoder rejects it
+	data, err := tr.MarshalCBOR()
+	r.NoError(err)
+	var plain Transfer
+	r.NoError(plain.UnmarshalCBOR(data))
+	a.NoError(plain.VerifyHybrid(nil, second, second.Public()))
+	strict := NewDecoder()
+	strict.WithUnknownFields(false)
+	_, err = strict.Decode(data)
+	a.Equal(ErrUnknownFields, errors.Cause(err))
+	d := NewDecoder()
+	got, err := d.Decode(data)
+	r.NoError(err)
+	a.NoError(got.VerifyHybrid(nil, second, second.Public()))
+
+	// stripping the second signature or using another key fails
+	stripped := *got
+	stripped.unknown = nil
+	var ve *VerifyError
+	r.True(errors.As(stripped.VerifyHybrid(nil, second, second.Public()), &ve))
+	a.Equal(VerifyStructure, ve.Stage)
+	other := edHybrid{algo: "test-ed25519", priv: privKey}
+	a.Error(got.VerifyHybrid(nil, other, other.Public()))
+
+	forged := *got
+	forged.unknown = [][]byte{appendCBORBytes(nil, bytes.Repeat([]byte{1}, ed25519.SignatureSize))}
+	r.True(errors.As(forged.VerifyHybrid(nil, second, second.Public()), &ve))
+	a.Equal(VerifySignature, ve.Stage)
+
+	r.NoError(e.WithExperimentalHybrid(nil))
+	plainTr, _, err := e.Encode(1, BinaryRef{}, []byte("plain"))
+	r.NoError(err)
+	a.Equal(ErrNotHybrid, plainTr.VerifyHybrid(nil, second, second.Public()))
+	suite, err = plainTr.Suite()
+	r.NoError(err)
+	a.Equal(SuiteEd25519, suite)
+}
+
+func TestHybridOutbox(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	pubKey, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("hybr"), 8)))
+	_, secondKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("pqpq"), 8)))
+	second := edHybrid{algo: "test-ed25519", priv: secondKey}
+	author, err := refs.NewFeedRefFromBytes(pubKey, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+
+	newEncoder := func() *Encoder {
+		e := NewEncoder(privKey)
+		r.NoError(e.WithExperimentalHybrid(second))
+		return e
+	}
+
+	store := NewMemOutboxStore()
+	ob := NewOutbox(newEncoder(), NewFeedState(author), store)
+	for i := 0; i < 3; i++ {
+		_, _, err := ob.Publish([]byte("hybrid"))
+		r.NoError(err)
+	}
+
+	// nothing was committed, so reopening replays all pending transfers into the state
+	ob2, err := OpenOutbox(newEncoder(), author, NewMemSequenceStore(), store)
+	r.NoError(err)
+	a.EqualValues(3, ob2.State().Sequence)
+	a.Equal(*ob.State().Tip, *ob2.State().Tip)
+
+	remote := NewFeedState(author)
+	r.NoError(ob2.Replay(func(tr *Transfer) error {
+		if err := tr.VerifyHybrid(nil, second, second.Public()); err != nil {
+			return err
+		}
+		return remote.Append(tr)
+	}))
+	a.EqualValues(3, remote.Sequence)
+
+	tr, _, err := ob2.Publish([]byte("hybrid"))
+	r.NoError(err)
+	a.EqualValues(4, tr.Seq())
+	a.NoError(tr.VerifyHybrid(nil, second, second.Public()))
+}
@@ -0,0 +1,217 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+// Package indexer builds secondary indexes, like contacts or about, from the messages of feeds.
+//
+// An Indexer processes the messages of every feed in order and remembers how far it got.
+// The Driver feeds it from a store and keeps it up to date with newly appended messages,
+// resuming where the indexer left off after a restart.
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/pkg/errors"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// Indexer builds state from the messages of feeds
+type Indexer interface {
+	// ProcessMessage updates the state with tr, the messages of a feed are passed in order and without gaps.
+	ProcessMessage(tr *gabbygrove.Transfer) error
+
+	// Processed returns the sequence of the last message of author the indexer processed, zero if none.
+	// Indexers that persist their state need to persist it together with this, so they resume at the right message.
+	Processed(author refs.FeedRef) (uint64, error)
+}
+
+// Flusher is implemented by indexers that want to persist their state after a batch of messages instead of on every one
+type Flusher interface {
+	Flush() error
+}
+
+// Source is where the driver reads feeds from, store.Store implements it
+type Source interface {
+	// Iterate passes the messages of author from sequence from up to and including to, zero meaning up to the tip
+	Iterate(author refs.FeedRef, from, to uint64, fn func(*gabbygrove.Transfer) error) error
+}
+
+// Driver feeds messages to indexers
+type Driver struct {
+	mu       sync.Mutex
+	src      Source
+	indexers []Indexer
+}
+
+// NewDriver returns a driver passing the messages of src on to the indexers
+func NewDriver(src Source, indexers ...Indexer) *Driver {
+	return &Driver{src: src, indexers: indexers}
+}
+
+// Sync catches all indexers up with the messages of author in the source
+func (d *Driver) Sync(author refs.FeedRef) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.sync(author); err != nil {
+		return err
+	}
+	return d.flush()
+}
+
+// sync reads the feed once from the indexer that is furthest behind, d.mu needs to be held
+func (d *Driver) sync(author refs.FeedRef) error {
+	processed := make([]uint64, len(d.indexers))
+	var from uint64
+	for i, idx := range d.indexers {
+		seq, err := idx.Processed(author)
+		if err != nil {
+			return errors.Wrapf(err, "indexer: failed to get the state of indexer %d", i)
+		}
+		processed[i] = seq
+		if i == 0 || seq < from {
+			from = seq
+		}
+	}
+	if len(d.indexers) == 0 {
+		return nil
+	}
+	return d.src.Iterate(author, from+1, 0, func(tr *gabbygrove.Transfer) error {
+		seq := uint64(tr.Seq())
+		for i, idx := range d.indexers {
+			if seq <= processed[i] {
+				continue
+			}
+			if err := idx.ProcessMessage(tr); err != nil {
+				return errors.Wrapf(err, "indexer: indexer %d failed to process %d", i, seq)
+			}
+			processed[i] = seq
+		}
+		return nil
+	})
+}
+
+// Process passes a new message to the indexers that haven't seen it.
+// If an indexer is missing messages before it, they are read from the source first.
+func (d *Driver) Process(tr *gabbygrove.Transfer) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.process(tr); err != nil {
+		return err
+	}
+	return d.flush()
+}
+
+func (d *Driver) process(tr *gabbygrove.Transfer) error {
+	author, seq := tr.Author(), uint64(tr.Seq())
+	for i, idx := range d.indexers {
+		have, err := idx.Processed(author)
+		if err != nil {
+			return errors.Wrapf(err, "indexer: failed to get the state of indexer %d", i)
+		}
+		if have+1 < seq {
+			// the source has everything up to tr, which includes it if it came from there
+			return d.sync(author)
+		}
+	}
+	for i, idx := range d.indexers {
+		have, err := idx.Processed(author)
+		if err != nil {
+			return errors.Wrapf(err, "indexer: failed to get the state of indexer %d", i)
+		}
+		if seq <= have {
+			continue
+		}
+		if err := idx.ProcessMessage(tr); err != nil {
+			return errors.Wrapf(err, "indexer: indexer %d failed to process %d", i, seq)
+		}
+	}
+	return nil
+}
+
+// Run processes the messages of updates until it's closed or ctx is done, i.e. from Store.Subscribe.
+// It returns nil when updates is closed and ctx.Err() if it's done.
+func (d *Driver) Run(ctx context.Context, updates <-chan *gabbygrove.Transfer) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case tr, ok := <-updates:
+			if !ok {
+				return nil
+			}
+			if err := d.Process(tr); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// flush persists the state of the indexers that are Flushers, d.mu needs to be held
+func (d *Driver) flush() error {
+	for i, idx := range d.indexers {
+		f, ok := idx.(Flusher)
+		if !ok {
+			continue
+		}
+		if err := f.Flush(); err != nil {
+			return errors.Wrapf(err, "indexer: failed to flush indexer %d", i)
+		}
+	}
+	return nil
+}
+
+// Progress keeps the processed sequence per author for an Indexer to embed.
+// It's safe for concurrent use and it can be persisted as JSON.
+type Progress struct {
+	mu   sync.Mutex
+	seqs map[string]uint64
+}
+
+// Processed returns the sequence stored for author, zero if there is none
+func (p *Progress) Processed(author refs.FeedRef) (uint64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.seqs[author.String()], nil
+}
+
+// Set records seq as the last processed message of author
+func (p *Progress) Set(author refs.FeedRef, seq uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.seqs == nil {
+		p.seqs = make(map[string]uint64)
+	}
+	p.seqs[author.String()] = seq
+}
+
+// MarshalJSON encodes the sequences as an object keyed by feed reference
+func (p *Progress) MarshalJSON() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	seqs := p.seqs
+	if seqs == nil {
+		seqs = map[string]uint64{}
+	}
+	return json.Marshal(seqs)
+}
+
+// UnmarshalJSON replaces the sequences with the encoded ones
+func (p *Progress) UnmarshalJSON(data []byte) error {
+	var seqs map[string]uint64
+	if err := json.Unmarshal(data, &seqs); err != nil {
+		return errors.Wrap(err, "indexer: invalid progress")
+	}
+	for k := range seqs {
+		if _, err := refs.ParseFeedRef(k); err != nil {
+			return errors.Wrapf(err, "indexer: invalid feed in progress")
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seqs = seqs
+	return nil
+}
@@ -0,0 +1,191 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	"go.mindeco.de/ssb-gabbygrove/store"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+)
+
+func makeFeed(t *testing.T, seed string, n int) (refs.FeedRef, []*gabbygrove.Transfer) {
+	r := require.New(t)
+
+	pub, priv, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte(seed), 32/len(seed))))
+	r.NoError(err)
+	author, err := refs.NewFeedRefFromBytes(pub, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+
+	e := gabbygrove.NewEncoder(priv)
+	state := gabbygrove.NewFeedState(author)
+	var trs []*gabbygrove.Transfer
+	for i := 0; i < n; i++ {
+		seq, prev := state.Next()
+		tr, _, err := e.Encode(seq, prev, map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+		r.NoError(state.Append(tr))
+		trs = append(trs, tr)
+	}
+	return author, trs
+}
+
+// countIndexer counts the messages per author and checks they come in order
+type countIndexer struct {
+	Progress
+	counts  map[string]int
+	flushes int
+	failAt  uint64
+}
+
+func newCountIndexer() *countIndexer {
+	return &countIndexer{counts: make(map[string]int)}
+}
+
+func (ci *countIndexer) ProcessMessage(tr *gabbygrove.Transfer) error {
+	author := tr.Author()
+	have, _ := ci.Processed(author)
+	if uint64(tr.Seq()) != have+1 {
+		return errors.Errorf("out of order: %d after %d", tr.Seq(), have)
+	}
+	if uint64(tr.Seq()) == ci.failAt {
+		return errors.New("test failure")
+	}
+	ci.counts[author.String()]++
+	ci.Set(author, uint64(tr.Seq()))
+	return nil
+}
+
+func (ci *countIndexer) Flush() error {
+	ci.flushes++
+	return nil
+}
+
+func TestDriver(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbyindexer")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s, err := store.Open(dir)
+	r.NoError(err)
+	defer s.Close()
+
+	alice, aliceTrs := makeFeed(t, "dead", 6)
+	for _, tr := range aliceTrs[:4] {
+		r.NoError(s.Append(tr))
+	}
+
+	first := newCountIndexer()
+	d := NewDriver(s, first)
+	r.NoError(d.Sync(alice))
+	a.Equal(4, first.counts[alice.String()])
+	a.Equal(1, first.flushes)
+
+	// resuming picks up where they left off, a new indexer starts from the beginning
+	r.NoError(s.Append(aliceTrs[4]))
+	second := newCountIndexer()
+	d = NewDriver(s, first, second)
+	r.NoError(d.Sync(alice))
+	a.Equal(5, first.counts[alice.String()])
+	a.Equal(5, second.counts[alice.String()])
+
+	// already seen messages are skipped
+	r.NoError(d.Process(aliceTrs[2]))
+	a.Equal(5, first.counts[alice.String()])
+
+	// live updates, i.e. from Store.Subscribe
+	bob, bobTrs := makeFeed(t, "beef", 3)
+	updates := make(chan *gabbygrove.Transfer, 10)
+	for _, tr := range append([]*gabbygrove.Transfer{aliceTrs[5]}, bobTrs...) {
+		r.NoError(s.Append(tr))
+		updates <- tr
+	}
+	close(updates)
+	r.NoError(d.Run(context.Background(), updates))
+	a.Equal(6, first.counts[alice.String()])
+	a.Equal(3, first.counts[bob.String()])
+	a.Equal(3, second.counts[bob.String()])
+
+	// a message of a feed the indexer is behind on, the gap is read from the store
+	third := newCountIndexer()
+	r.NoError(NewDriver(s, third).Process(bobTrs[2]))
+	a.Equal(3, third.counts[bob.String()])
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	a.Equal(context.Canceled, d.Run(ctx, make(chan *gabbygrove.Transfer)))
+}
+
+func TestDriverError(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbyindexer")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s, err := store.Open(dir)
+	r.NoError(err)
+	defer s.Close()
+
+	alice, aliceTrs := makeFeed(t, "dead", 4)
+	for _, tr := range aliceTrs {
+		r.NoError(s.Append(tr))
+	}
+
+	ci := newCountIndexer()
+	ci.failAt = 3
+	d := NewDriver(s, ci)
+	a.Error(d.Sync(alice))
+	seq, err := ci.Processed(alice)
+	r.NoError(err)
+	a.EqualValues(2, seq)
+
+	ci.failAt = 0
+	r.NoError(d.Sync(alice))
+	a.Equal(4, ci.counts[alice.String()])
+}
+
+func TestProgressJSON(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	alice, _ := makeFeed(t, "dead", 0)
+	bob, _ := makeFeed(t, "beef", 0)
+
+	var p Progress
+	b, err := json.Marshal(&p)
+	r.NoError(err)
+	a.Equal("{}", string(b))
+
+	p.Set(alice, 23)
+	p.Set(bob, 5)
+	b, err = json.Marshal(&p)
+	r.NoError(err)
+
+	var p2 Progress
+	r.NoError(json.Unmarshal(b, &p2))
+	seq, err := p2.Processed(alice)
+	r.NoError(err)
+	a.EqualValues(23, seq)
+	seq, err = p2.Processed(bob)
+	r.NoError(err)
+	a.EqualValues(5, seq)
+
+	a.Error(json.Unmarshal([]byte(`{"not a feed":1}`), &p2))
+}
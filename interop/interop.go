@@ -0,0 +1,230 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+// Package interop compares the encoding of this package with another implementation of gabbygrove, like the JavaScript one.
+//
+// Diverging canonicalization is the main risk for interoperability: if two implementations encode the same event differently,
+// their signatures and message keys don't match. Compare generates random events, has both sides encode and decode them
+// and reports every difference as a test failure.
+//
+// The other side is a Reference. Subprocess runs it as a separate program, see its documentation for the protocol.
+package interop
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+)
+
+// EventFields are the fields of an event, without the types and framing of either implementation.
+// All hashes and keys are 32 bytes long.
+type EventFields struct {
+	// Previous is nil for the first message
+	Previous []byte `json:"previous"`
+
+	Author    []byte `json:"author"`
+	Sequence  uint64 `json:"sequence"`
+	Timestamp int64  `json:"timestamp"`
+
+	ContentHash []byte `json:"contentHash"`
+	ContentSize uint16 `json:"contentSize"`
+	ContentType uint   `json:"contentType"`
+}
+
+// Reference is the implementation to compare against
+type Reference interface {
+	EncodeEvent(EventFields) ([]byte, error)
+	DecodeEvent([]byte) (EventFields, error)
+}
+
+// the type prefixes of binary references
+const (
+	refFeed    = 0x01
+	refMessage = 0x02
+	refContent = 0x03
+)
+
+// Encode encodes f with this package
+func Encode(f EventFields) ([]byte, error) {
+	var evt gabbygrove.Event
+	if f.Previous != nil {
+		var prev gabbygrove.BinaryRef
+		if err := prev.UnmarshalBinary(append([]byte{refMessage}, f.Previous...)); err != nil {
+			return nil, errors.Wrap(err, "interop: invalid previous")
+		}
+		evt.Previous = &prev
+	}
+	if err := evt.Author.UnmarshalBinary(append([]byte{refFeed}, f.Author...)); err != nil {
+		return nil, errors.Wrap(err, "interop: invalid author")
+	}
+	evt.Sequence = f.Sequence
+	evt.Timestamp = f.Timestamp
+	if err := evt.Content.Hash.UnmarshalBinary(append([]byte{refContent}, f.ContentHash...)); err != nil {
+		return nil, errors.Wrap(err, "interop: invalid content hash")
+	}
+	evt.Content.Size = f.ContentSize
+	evt.Content.Type = gabbygrove.ContentType(f.ContentType)
+	return evt.MarshalCBOR()
+}
+
+// Decode decodes data with this package
+func Decode(data []byte) (EventFields, error) {
+	var evt gabbygrove.Event
+	if err := evt.UnmarshalCBOR(data); err != nil {
+		return EventFields{}, err
+	}
+
+	var (
+		f   EventFields
+		err error
+	)
+	if evt.Previous != nil {
+		if f.Previous, err = hashOf(*evt.Previous); err != nil {
+			return EventFields{}, err
+		}
+	}
+	if f.Author, err = hashOf(evt.Author); err != nil {
+		return EventFields{}, err
+	}
+	f.Sequence = evt.Sequence
+	f.Timestamp = evt.Timestamp
+	if f.ContentHash, err = hashOf(evt.Content.Hash); err != nil {
+		return EventFields{}, err
+	}
+	f.ContentSize = evt.Content.Size
+	f.ContentType = uint(evt.Content.Type)
+	return f, nil
+}
+
+func hashOf(ref gabbygrove.BinaryRef) ([]byte, error) {
+	b, err := ref.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != 33 {
+		return nil, errors.Errorf("interop: invalid reference")
+	}
+	return b[1:], nil
+}
+
+// maxSafeInteger is the largest integer a JavaScript number holds without losing precision
+const maxSafeInteger = 1<<53 - 1
+
+// Options configure Compare
+type Options struct {
+	// Seed makes the generated events reproducible
+	Seed int64
+
+	// Iterations is the number of events to compare, defaults to 1000
+	Iterations int
+
+	// MaxSequence caps the generated sequences and timestamps, defaults to the largest integer JavaScript can represent exactly
+	MaxSequence uint64
+}
+
+// RandomFields generates the fields of a valid event
+func RandomFields(rnd *rand.Rand, maxSequence uint64) EventFields {
+	hash := func() []byte {
+		b := make([]byte, 32)
+		rnd.Read(b)
+		return b
+	}
+	// favor the edges of the integer encodings
+	edges := []uint64{1, 23, 24, 255, 256, 65535, 65536, 1<<32 - 1, 1 << 32}
+	var f EventFields
+	if rnd.Intn(2) == 0 {
+		f.Sequence = edges[rnd.Intn(len(edges))]
+	} else {
+		f.Sequence = uint64(rnd.Int63())
+	}
+	f.Sequence = f.Sequence%maxSequence + 1
+	if f.Sequence > 1 {
+		f.Previous = hash()
+	}
+	f.Author = hash()
+	f.Timestamp = rnd.Int63n(int64(maxSequence)) - int64(maxSequence)/2
+	f.ContentHash = hash()
+	f.ContentSize = uint16(rnd.Intn(1 << 16))
+	f.ContentType = uint(rnd.Intn(3))
+	if f.ContentSize == 0 {
+		f.ContentType = uint(gabbygrove.ContentTypeArbitrary)
+	}
+	return f
+}
+
+// Compare encodes and decodes random events with this package and ref and reports every difference on tb
+func Compare(tb testing.TB, ref Reference, opts Options) {
+	tb.Helper()
+	if opts.Iterations == 0 {
+		opts.Iterations = 1000
+	}
+	if opts.MaxSequence == 0 {
+		opts.MaxSequence = maxSafeInteger
+	}
+	rnd := rand.New(rand.NewSource(opts.Seed))
+
+	for i := 0; i < opts.Iterations; i++ {
+		f := RandomFields(rnd, opts.MaxSequence)
+		if err := compareOnce(ref, f); err != nil {
+			tb.Errorf("interop: seed %d, iteration %d: %s\nfields: %+v", opts.Seed, i, err, f)
+		}
+	}
+}
+
+func compareOnce(ref Reference, f EventFields) error {
+	ours, err := Encode(f)
+	if err != nil {
+		return errors.Wrap(err, "encoding failed here")
+	}
+	theirs, err := ref.EncodeEvent(f)
+	if err != nil {
+		return errors.Wrap(err, "encoding failed on the reference")
+	}
+	if !bytes.Equal(ours, theirs) {
+		return fmt.Errorf("encodings differ\nhere:      %x\nreference: %x", ours, theirs)
+	}
+
+	decoded, err := ref.DecodeEvent(ours)
+	if err != nil {
+		return errors.Wrap(err, "decoding failed on the reference")
+	}
+	if !reflect.DeepEqual(f, decoded) {
+		return fmt.Errorf("reference decoded different fields: %+v", decoded)
+	}
+	return nil
+}
+
+// Vector is the expected encoding of a known event
+type Vector struct {
+	Fields EventFields
+	CBOR   []byte
+}
+
+// CheckVectors compares the encoding and decoding of this package against fixed vectors, i.e. produced by another implementation
+func CheckVectors(tb testing.TB, vectors []Vector) {
+	tb.Helper()
+	for i, v := range vectors {
+		ours, err := Encode(v.Fields)
+		if err != nil {
+			tb.Errorf("interop: vector %d: %s", i, err)
+			continue
+		}
+		if !bytes.Equal(ours, v.CBOR) {
+			tb.Errorf("interop: vector %d: encodings differ\nhere:   %x\nvector: %x", i, ours, v.CBOR)
+		}
+		decoded, err := Decode(v.CBOR)
+		if err != nil {
+			tb.Errorf("interop: vector %d: %s", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(v.Fields, decoded) {
+			tb.Errorf("interop: vector %d: decoded different fields: %+v", i, decoded)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package interop
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ugorji/go/codec"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+)
+
+// codecReference is a second implementation, using the reflection based codec package instead of the hand written encoder
+type codecReference struct{}
+
+func (codecReference) EncodeEvent(f EventFields) ([]byte, error) {
+	return encodeWithCodec(f, f.Timestamp)
+}
+
+func encodeWithCodec(f EventFields, timestamp interface{}) ([]byte, error) {
+	data, err := Encode(f) // only to get the typed event
+	if err != nil {
+		return nil, err
+	}
+	var evt gabbygrove.Event
+	if err := codec.NewDecoderBytes(data, gabbygrove.NewCBORHandle()).Decode(&evt); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = codec.NewEncoder(&buf, gabbygrove.NewCBORHandle()).Encode([]interface{}{evt.Previous, &evt.Author, evt.Sequence, timestamp, &evt.Content})
+	return buf.Bytes(), err
+}
+
+func (codecReference) DecodeEvent(data []byte) (EventFields, error) {
+	var evt gabbygrove.Event
+	if err := codec.NewDecoderBytes(data, gabbygrove.NewCBORHandle()).Decode(&evt); err != nil {
+		return EventFields{}, err
+	}
+	reEncoded, err := evt.MarshalCBOR()
+	if err != nil {
+		return EventFields{}, err
+	}
+	return Decode(reEncoded)
+}
+
+func TestCompareCodec(t *testing.T) {
+	Compare(t, codecReference{}, Options{Seed: 1, Iterations: 500})
+	Compare(t, codecReference{}, Options{Seed: 2, Iterations: 500, MaxSequence: 1<<63 - 1})
+}
+
+// recorder catches the failures Compare reports
+type recorder struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+// brokenReference drops the sign of timestamps
+type brokenReference struct{ codecReference }
+
+func (brokenReference) EncodeEvent(f EventFields) ([]byte, error) {
+	ts := f.Timestamp
+	if ts < 0 {
+		ts = -ts
+	}
+	return encodeWithCodec(f, ts)
+}
+
+func TestCompareReportsDivergence(t *testing.T) {
+	a := assert.New(t)
+
+	rec := &recorder{TB: t}
+	Compare(rec, brokenReference{}, Options{Seed: 3, Iterations: 50, MaxSequence: 1000})
+	a.NotEmpty(rec.failures)
+	for _, f := range rec.failures {
+		a.Contains(f, "encodings differ")
+	}
+}
+
+func TestVectors(t *testing.T) {
+	r := require.New(t)
+
+	data, err := hex.DecodeString("85d9041a5821024226e0304155aeea683a98882ca5683579e1cdd5505597fb76498bf4c4973b98d9041a582101aed3dab65ce9e0d6c50d46fceffb552296ed21b6e0b537a6a0184575ce8f5cbd032283d9041a58210327d0b22f26328f03ffce2a7c66b2ee27e337ca5d28cdc89ead668f1dd7f0218b186901")
+	r.NoError(err)
+	fields, err := Decode(data)
+	r.NoError(err)
+	r.EqualValues(3, fields.Sequence)
+
+	rec := &recorder{TB: t}
+	CheckVectors(rec, []Vector{{Fields: fields, CBOR: data}})
+	r.Empty(rec.failures)
+
+	fields.Timestamp++
+	CheckVectors(rec, []Vector{{Fields: fields, CBOR: data}})
+	r.Len(rec.failures, 2)
+}
+
+// TestHelperReference is not a real test, it's the reference program for TestSubprocess
+func TestHelperReference(t *testing.T) {
+	if os.Getenv("GABBYGROVE_INTEROP_HELPER") != "1" {
+		return
+	}
+	if err := Serve(os.Stdin, os.Stdout, codecReference{}); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func TestSubprocess(t *testing.T) {
+	r := require.New(t)
+
+	os.Setenv("GABBYGROVE_INTEROP_HELPER", "1")
+	defer os.Unsetenv("GABBYGROVE_INTEROP_HELPER")
+
+	sp, err := NewSubprocess(os.Args[0], "-test.run=TestHelperReference")
+	r.NoError(err)
+	Compare(t, sp, Options{Seed: 4, Iterations: 100})
+
+	_, err = sp.DecodeEvent([]byte{0xff})
+	r.Error(err)
+	r.NoError(sp.Close())
+}
+
+// TestReference runs against the program in GABBYGROVE_REFERENCE, i.e. "node reference.js"
+func TestReference(t *testing.T) {
+	cmd := strings.Fields(os.Getenv("GABBYGROVE_REFERENCE"))
+	if len(cmd) == 0 {
+		t.Skip("GABBYGROVE_REFERENCE not set")
+	}
+	sp, err := NewSubprocess(cmd[0], cmd[1:]...)
+	require.NoError(t, err)
+	defer sp.Close()
+	Compare(t, sp, Options{Seed: 5})
+}
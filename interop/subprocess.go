@@ -0,0 +1,158 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package interop
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os/exec"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Subprocess is a Reference implemented by another program, talking to it over its stdin and stdout.
+// Each request is one line of JSON and answered by one line of JSON:
+//
+//	{"op":"encode","fields":{...}} -> {"cbor":"<hex>"}
+//	{"op":"decode","cbor":"<hex>"} -> {"fields":{...}}
+//
+// Failures are answered with {"error":"<message>"}.
+// The fields use the JSON names of EventFields, byte values are base64 encoded like encoding/json does.
+// Serve implements the other end of the protocol.
+type Subprocess struct {
+	mu sync.Mutex
+
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	out   *json.Decoder
+	enc   *json.Encoder
+}
+
+type request struct {
+	Op     string       `json:"op"`
+	Fields *EventFields `json:"fields,omitempty"`
+	CBOR   string       `json:"cbor,omitempty"`
+}
+
+type response struct {
+	Fields *EventFields `json:"fields,omitempty"`
+	CBOR   string       `json:"cbor,omitempty"`
+	Error  string       `json:"error,omitempty"`
+}
+
+// NewSubprocess starts the program and keeps it running until Close
+func NewSubprocess(name string, args ...string) (*Subprocess, error) {
+	cmd := exec.Command(name, args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "interop: stdin")
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "interop: stdout")
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Wrap(err, "interop: failed to start reference")
+	}
+	return &Subprocess{
+		cmd:   cmd,
+		stdin: stdin,
+		out:   json.NewDecoder(bufio.NewReader(stdout)),
+		enc:   json.NewEncoder(stdin),
+	}, nil
+}
+
+func (s *Subprocess) roundtrip(req request) (*response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.enc.Encode(req); err != nil {
+		return nil, errors.Wrap(err, "interop: failed to send request")
+	}
+	var resp response
+	if err := s.out.Decode(&resp); err != nil {
+		return nil, errors.Wrap(err, "interop: failed to read response")
+	}
+	if resp.Error != "" {
+		return nil, errors.Errorf("interop: reference: %s", resp.Error)
+	}
+	return &resp, nil
+}
+
+func (s *Subprocess) EncodeEvent(f EventFields) ([]byte, error) {
+	resp, err := s.roundtrip(request{Op: "encode", Fields: &f})
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(resp.CBOR)
+}
+
+func (s *Subprocess) DecodeEvent(data []byte) (EventFields, error) {
+	resp, err := s.roundtrip(request{Op: "decode", CBOR: hex.EncodeToString(data)})
+	if err != nil {
+		return EventFields{}, err
+	}
+	if resp.Fields == nil {
+		return EventFields{}, errors.Errorf("interop: reference returned no fields")
+	}
+	return *resp.Fields, nil
+}
+
+// Close ends the input of the program and waits for it to exit
+func (s *Subprocess) Close() error {
+	s.stdin.Close()
+	return s.cmd.Wait()
+}
+
+// Serve answers the requests of a Subprocess read from r with ref, until r ends.
+// It can wrap another Go implementation as a program.
+func Serve(r io.Reader, w io.Writer, ref Reference) error {
+	dec := json.NewDecoder(r)
+	enc := json.NewEncoder(w)
+	for {
+		var req request
+		if err := dec.Decode(&req); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return errors.Wrap(err, "interop: invalid request")
+		}
+
+		var resp response
+		switch req.Op {
+		case "encode":
+			if req.Fields == nil {
+				resp.Error = "no fields"
+				break
+			}
+			b, err := ref.EncodeEvent(*req.Fields)
+			if err != nil {
+				resp.Error = err.Error()
+				break
+			}
+			resp.CBOR = hex.EncodeToString(b)
+		case "decode":
+			b, err := hex.DecodeString(req.CBOR)
+			if err != nil {
+				resp.Error = err.Error()
+				break
+			}
+			f, err := ref.DecodeEvent(b)
+			if err != nil {
+				resp.Error = err.Error()
+				break
+			}
+			resp.Fields = &f
+		default:
+			resp.Error = "unknown op: " + req.Op
+		}
+		if err := enc.Encode(resp); err != nil {
+			return errors.Wrap(err, "interop: failed to write response")
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+//go:build !tinygo
+// +build !tinygo
+
+package gabbygrove
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/ugorji/go/codec"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// DefaultMaxInviteSize keeps an invite small enough for a (binary) QR code
+const DefaultMaxInviteSize = 2048
+
+// Invite bundles a feed reference with the first messages of that feed.
+// Rooms or QR codes can carry it to onboard a new peer without an initial replication round.
+type Invite struct {
+	Feed      BinaryRef
+	Transfers []Transfer
+}
+
+// NewInvite creates an invite for feed with its initial transfers
+func NewInvite(feed refs.FeedRef, trs ...*Transfer) (*Invite, error) {
+	var inv Invite
+	var err error
+	inv.Feed, err = fromRef(feed)
+	if err != nil {
+		return nil, errors.Wrap(err, "gabbygrove/invite: invalid feed ref")
+	}
+	for _, tr := range trs {
+		inv.Transfers = append(inv.Transfers, *tr)
+	}
+	return &inv, nil
+}
+
+// Encode encodes the invite as CBOR and fails if the result is larger then maxSize.
+// A maxSize of 0 falls back to DefaultMaxInviteSize.
+func (inv Invite) Encode(maxSize int) ([]byte, error) {
+	if maxSize == 0 {
+		maxSize = DefaultMaxInviteSize
+	}
+	var buf bytes.Buffer
+	enc := codec.NewEncoder(&buf, NewCBORHandle())
+	if err := enc.Encode(inv); err != nil {
+		return nil, errors.Wrap(err, "gabbygrove/invite: failed to encode")
+	}
+	if n := buf.Len(); n > maxSize {
+		return nil, errors.Errorf("gabbygrove/invite: payload too large (%d > %d bytes)", n, maxSize)
+	}
+	return buf.Bytes(), nil
+}
+
+// ParseInvite decodes and validates an invite of at most maxSize bytes.
+// The transfers need to be the start of the feed (beginning with sequence 1), properly chained and signed,
+// with content that matches their events.
+func ParseInvite(data []byte, maxSize int, hmacKey *[32]byte) (*Invite, error) {
+	if maxSize == 0 {
+		maxSize = DefaultMaxInviteSize
+	}
+	if n := len(data); n > maxSize {
+		return nil, errors.Errorf("gabbygrove/invite: payload too large (%d > %d bytes)", n, maxSize)
+	}
+
+	var inv Invite
+	dec := codec.NewDecoder(io.LimitReader(bytes.NewReader(data), int64(maxSize)), NewCBORHandle())
+	if err := dec.Decode(&inv); err != nil {
+		return nil, errors.Wrap(err, "gabbygrove/invite: failed to decode")
+	}
+
+	feed, err := inv.Feed.GetRef(RefTypeFeed)
+	if err != nil {
+		return nil, errors.Wrap(err, "gabbygrove/invite: invalid feed ref")
+	}
+
+	var prev *refs.MessageRef
+	for i := range inv.Transfers {
+		tr := &inv.Transfers[i]
+		if err := tr.VerifyAll(hmacKey); err != nil {
+			return nil, errors.Wrapf(err, "gabbygrove/invite: transfer %d does not verify", i)
+		}
+		if !tr.Author().Equal(feed.(refs.FeedRef)) {
+			return nil, errors.Errorf("gabbygrove/invite: transfer %d is from a different author", i)
+		}
+		if tr.Seq() != int64(i+1) {
+			return nil, errors.Errorf("gabbygrove/invite: transfer %d has wrong sequence %d", i, tr.Seq())
+		}
+		if got := tr.Previous(); prev != nil && (got == nil || !got.Equal(*prev)) {
+			return nil, errors.Errorf("gabbygrove/invite: transfer %d breaks the chain", i)
+		}
+		key := tr.Key()
+		prev = &key
+	}
+
+	return &inv, nil
+}
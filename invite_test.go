@@ -0,0 +1,72 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+//go:build !tinygo
+// +build !tinygo
+
+package gabbygrove
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+func TestInvite(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	pubKey, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	feed, err := refs.NewFeedRefFromBytes(pubKey, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+
+	e := NewEncoder(privKey)
+	var trs []*Transfer
+	var prev BinaryRef
+	for i := 1; i <= 2; i++ {
+		tr, key, err := e.Encode(uint64(i), prev, map[string]interface{}{"type": "about", "name": "alice"})
+		r.NoError(err)
+		prev, err = fromRef(key)
+		r.NoError(err)
+		trs = append(trs, tr)
+	}
+
+	inv, err := NewInvite(feed, trs...)
+	r.NoError(err)
+
+	data, err := inv.Encode(0)
+	r.NoError(err)
+	t.Log("invite size:", len(data))
+
+	got, err := ParseInvite(data, 0, nil)
+	r.NoError(err)
+	a.Equal(feed.URI(), got.Feed.URI())
+	r.Len(got.Transfers, 2)
+	a.Equal(trs[1].Key(), got.Transfers[1].Key())
+
+	// size bounds
+	_, err = inv.Encode(len(data) - 1)
+	a.Error(err)
+	_, err = ParseInvite(data, len(data)-1, nil)
+	a.Error(err)
+
+	// content that doesn't match its event
+	tampered := *inv
+	tampered.Transfers = append([]Transfer{}, inv.Transfers...)
+	tampered.Transfers[0].Content = []byte(`{"type":"about","name":"mallory"}`)
+	data, err = tampered.Encode(0)
+	r.NoError(err)
+	_, err = ParseInvite(data, 0, nil)
+	a.Error(err)
+
+	// not starting at one
+	inv.Transfers = inv.Transfers[1:]
+	data, err = inv.Encode(0)
+	r.NoError(err)
+	_, err = ParseInvite(data, 0, nil)
+	a.Error(err)
+}
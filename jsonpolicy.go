@@ -0,0 +1,231 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"encoding/json"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	// ErrInvalidUTF8 is returned for JSON content with text that isn't valid UTF-8, see JSONPolicy
+	ErrInvalidUTF8 = errors.New("gabbygrove: JSON content is not valid UTF-8")
+
+	// ErrNotNFC is returned for JSON content with text that isn't in normalization form C, see JSONPolicy
+	ErrNotNFC = errors.New("gabbygrove: JSON content is not NFC normalized")
+
+	// ErrDuplicateKey is returned for JSON content with an object that has the same key twice, see JSONPolicy
+	ErrDuplicateKey = errors.New("gabbygrove: JSON content has a duplicate key")
+)
+
+// JSONPolicy restricts JSON content beyond being well-formed.
+// The content is hashed as it is, so text that implementations decode or normalize differently
+// leads to different content behind the same reference.
+type JSONPolicy struct {
+	// UTF8 rejects strings with invalid UTF-8, including escaped surrogates that don't form a pair.
+	// encoding/json replaces those with U+FFFD when decoding, other parsers keep or reject them.
+	UTF8 bool
+
+	// NFC rejects strings that aren't in Unicode normalization form C, keys included.
+	// It catches text that got encoded by a client that doesn't normalize, next to one that does.
+	NFC bool
+
+	// AllowDuplicateKeys accepts objects that have the same key more than once.
+	// Parsers differ in which of the values they keep, so by default they are rejected.
+	// Keys are compared after unescaping, "a" and "\u0061" are the same key.
+	AllowDuplicateKeys bool
+}
+
+// defaultJSONPolicy is what the Validator checks if WithJSONPolicy isn't used
+var defaultJSONPolicy = &JSONPolicy{}
+
+// Check returns an error if data isn't JSON that satisfies the policy
+func (p JSONPolicy) Check(data []byte) error {
+	if !json.Valid(data) {
+		return errors.New("gabbygrove: invalid json content")
+	}
+	if !p.UTF8 && !p.NFC && p.AllowDuplicateKeys {
+		return nil
+	}
+	return walkJSONStrings(data, func(lit []byte, keys map[string]struct{}) error {
+		if p.UTF8 && !validJSONString(lit) {
+			return errors.Wrapf(ErrInvalidUTF8, "string at %q", shorten(lit))
+		}
+		checkKey := keys != nil && !p.AllowDuplicateKeys
+		if !p.NFC && !checkKey {
+			return nil
+		}
+		s, err := unquoteJSON(lit)
+		if err != nil {
+			return err
+		}
+		if p.NFC && !norm.NFC.IsNormalString(s) {
+			return errors.Wrapf(ErrNotNFC, "string at %q", shorten(lit))
+		}
+		if checkKey {
+			if _, dup := keys[s]; dup {
+				return errors.Wrapf(ErrDuplicateKey, "key %q", shorten(lit))
+			}
+			keys[s] = struct{}{}
+		}
+		return nil
+	})
+}
+
+// WithJSONPolicy makes the encoder refuse JSON content that p rejects.
+// Content is only checked if the encoder serializes it, not if it's hashed elsewhere like for EncodeFromFile.
+func (e *Encoder) WithJSONPolicy(p *JSONPolicy) {
+	e.jsonPolicy = p
+}
+
+// WithJSONPolicy makes the decoder reject transfers with JSON content that p rejects.
+// Transfers without content pass, ContentMatches still needs to be checked once it's attached.
+func (d *Decoder) WithJSONPolicy(p *JSONPolicy) {
+	d.jsonPolicy = p
+}
+
+// WithJSONPolicy replaces the checks of JSON content, by default malformed content and duplicate keys are rejected.
+// Like for a ContentPolicy, messages with content p rejects are still appended, with their content dropped.
+// Passing nil turns the checks off.
+func (v *Validator) WithJSONPolicy(p *JSONPolicy) {
+	v.jsonPolicy = p
+}
+
+// checkJSONContent applies p to the content of tr, if it's JSON and attached
+func checkJSONContent(p *JSONPolicy, tr *Transfer) error {
+	if p == nil || len(tr.Content) == 0 {
+		return nil
+	}
+	evt, err := tr.getEvent()
+	if err != nil {
+		return err
+	}
+	if evt.Content.Type != ContentTypeJSON {
+		return nil
+	}
+	return p.Check(tr.Content)
+}
+
+// jsonFrame is an object or array walkJSONStrings is in
+type jsonFrame struct {
+	object    bool
+	expectKey bool
+	keys      map[string]struct{}
+}
+
+// walkJSONStrings calls fn with every string literal in data, quotes included. data needs to be valid JSON.
+// For the keys of objects, fn also gets the keys fn saw before in the same object, which it's free to add to.
+func walkJSONStrings(data []byte, fn func(lit []byte, keys map[string]struct{}) error) error {
+	var stack []jsonFrame
+	for i := 0; i < len(data); i++ {
+		var top *jsonFrame
+		if len(stack) > 0 {
+			top = &stack[len(stack)-1]
+		}
+		switch data[i] {
+		case '{':
+			stack = append(stack, jsonFrame{object: true, expectKey: true})
+		case '[':
+			stack = append(stack, jsonFrame{})
+		case '}', ']':
+			stack = stack[:len(stack)-1]
+		case ',':
+			top.expectKey = top.object
+		case ':':
+			top.expectKey = false
+		case '"':
+			start := i
+			for i++; data[i] != '"'; i++ {
+				if data[i] == '\\' {
+					i++
+				}
+			}
+			var keys map[string]struct{}
+			if top != nil && top.expectKey {
+				if top.keys == nil {
+					top.keys = make(map[string]struct{})
+				}
+				keys = top.keys
+			}
+			if err := fn(data[start:i+1], keys); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validJSONString checks the bytes of a string literal and that its escaped surrogates are paired
+func validJSONString(lit []byte) bool {
+	if !utf8.Valid(lit) {
+		return false
+	}
+	for i := 1; i < len(lit)-1; i++ {
+		if lit[i] != '\\' {
+			continue
+		}
+		i++
+		if lit[i] != 'u' {
+			continue
+		}
+		r := hex4(lit[i+1 : i+5])
+		i += 4
+		switch {
+		case r >= 0xdc00 && r < 0xe000:
+			return false
+		case r >= 0xd800 && r < 0xdc00:
+			if i+6 >= len(lit)-1 || lit[i+1] != '\\' || lit[i+2] != 'u' {
+				return false
+			}
+			if low := hex4(lit[i+3 : i+7]); low < 0xdc00 || low >= 0xe000 {
+				return false
+			}
+			i += 6
+		}
+	}
+	return true
+}
+
+// hex4 decodes the four hex digits of an \u escape, which json.Valid already checked
+func hex4(b []byte) rune {
+	var r rune
+	for _, c := range b {
+		r <<= 4
+		switch {
+		case c >= '0' && c <= '9':
+			r |= rune(c - '0')
+		case c >= 'a' && c <= 'f':
+			r |= rune(c - 'a' + 10)
+		default:
+			r |= rune(c - 'A' + 10)
+		}
+	}
+	return r
+}
+
+// unquoteJSON returns the text of a string literal
+func unquoteJSON(lit []byte) (string, error) {
+	for _, c := range lit {
+		if c == '\\' {
+			var s string
+			if err := json.Unmarshal(lit, &s); err != nil {
+				return "", errors.Wrap(err, "gabbygrove: invalid json content")
+			}
+			return s, nil
+		}
+	}
+	return string(lit[1 : len(lit)-1]), nil
+}
+
+// shorten cuts long literals for error messages
+func shorten(lit []byte) []byte {
+	if len(lit) > 32 {
+		return lit[:32]
+	}
+	return lit
+}
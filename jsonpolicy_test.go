@@ -0,0 +1,126 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONPolicyCheck(t *testing.T) {
+	a := assert.New(t)
+
+	strict := JSONPolicy{UTF8: true, NFC: true}
+	for _, ok := range []string{
+		`{"type":"post","text":"hello"}`,
+		"{\"text\":\"caf\u00e9\"}",
+		"{\"emoji\":\"\U0001F600\",\"pair\":\"\\ud83d\\ude00\",\"escaped\":\"\\\\udc00\"}",
+		`[1,"two",{"three":null}]`,
+	} {
+		a.NoError(strict.Check([]byte(ok)), ok)
+	}
+
+	for _, tc := range []struct {
+		data string
+		err  error
+	}{
+		{"{\"text\":\"\xff\"}", ErrInvalidUTF8},
+		{`{"text":"\udc00"}`, ErrInvalidUTF8},
+		{`{"text":"\ud83d"}`, ErrInvalidUTF8},
+		{`{"text":"\ud83dA"}`, ErrInvalidUTF8},
+		{"{\"text\":\"cafe\u0301\"}", ErrNotNFC},
+		{"{\"cafe\u0301\":1}", ErrNotNFC},
+	} {
+		a.Equal(tc.err, errors.Cause(strict.Check([]byte(tc.data))), tc.data)
+	}
+
+	for _, dup := range []string{
+		`{"type":"post","type":"vote"}`,
+		`{"a":1,"\u0061":2}`,
+		`{"outer":{"x":[{"k":1,"k":1}]}}`,
+	} {
+		a.Equal(ErrDuplicateKey, errors.Cause(JSONPolicy{}.Check([]byte(dup))), dup)
+		a.NoError(JSONPolicy{AllowDuplicateKeys: true}.Check([]byte(dup)), dup)
+	}
+	// the same key in different objects, and as a value
+	a.NoError(JSONPolicy{}.Check([]byte(`{"a":{"a":"a"},"b":[{"a":1},{"a":2}],"c":"a"}`)))
+
+	a.Error(JSONPolicy{}.Check([]byte(`{"text":`)))
+	a.NoError(JSONPolicy{}.Check([]byte("{\"text\":\"\xff\"}")), "nothing but well-formed by default")
+	a.NoError(JSONPolicy{NFC: true}.Check([]byte(`{"text":"\ud83d"}`)))
+}
+
+func TestJSONPolicyEncodeDecode(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("utf8"), 8)))
+	decomposed := map[string]interface{}{"type": "post", "text": "cafe\u0301"}
+
+	loose := NewEncoder(privKey)
+	tr, _, err := loose.Encode(1, BinaryRef{}, decomposed)
+	r.NoError(err)
+	data, err := tr.MarshalCBOR()
+	r.NoError(err)
+
+	e := NewEncoder(privKey)
+	e.WithJSONPolicy(&JSONPolicy{UTF8: true, NFC: true})
+	_, _, err = e.Encode(1, BinaryRef{}, decomposed)
+	a.Equal(ErrNotNFC, errors.Cause(err))
+	_, _, err = e.Encode(1, BinaryRef{}, json.RawMessage("{\"text\":\"\xff\"}"))
+	a.Equal(ErrInvalidUTF8, errors.Cause(err))
+	_, _, err = e.Encode(1, BinaryRef{}, []byte("cafe\u0301"))
+	a.NoError(err, "arbitrary content isn't checked")
+
+	d := NewDecoder()
+	_, err = d.Decode(data)
+	a.NoError(err)
+	d.WithJSONPolicy(&JSONPolicy{NFC: true})
+	_, err = d.Decode(data)
+	a.Equal(ErrNotNFC, errors.Cause(err))
+	_, err = d.DecodeFrom(bytes.NewReader(data))
+	a.Equal(ErrNotNFC, errors.Cause(err))
+
+	noContent := *tr
+	noContent.Content = nil
+	data, err = noContent.MarshalCBOR()
+	r.NoError(err)
+	_, err = d.Decode(data)
+	a.NoError(err)
+}
+
+func TestValidatorJSONPolicy(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dupk"), 8)))
+	e := NewEncoder(privKey)
+	tr, _, err := e.Encode(1, BinaryRef{}, json.RawMessage(`{"type":"post","type":"vote"}`))
+	r.NoError(err)
+
+	// strict by default, the message is appended without its content
+	appended, err := NewValidator(0).Append(tr)
+	r.NoError(err)
+	r.Len(appended, 1)
+	a.False(appended[0].HasContent())
+	a.True(tr.HasContent(), "leaves the passed transfer alone")
+
+	v := NewValidator(0)
+	v.WithJSONPolicy(&JSONPolicy{AllowDuplicateKeys: true})
+	appended, err = v.Append(tr)
+	r.NoError(err)
+	a.True(appended[0].HasContent())
+
+	v = NewValidator(0)
+	v.WithJSONPolicy(nil)
+	appended, err = v.Append(tr)
+	r.NoError(err)
+	a.True(appended[0].HasContent())
+}
@@ -0,0 +1,239 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/ed25519"
+)
+
+var (
+	// ErrKeyFileEncrypted is returned by LoadKeyPair for files that need a passphrase, see LoadKeyPairEncrypted
+	ErrKeyFileEncrypted = errors.New("gabbygrove/keyfile: key is encrypted")
+
+	// ErrKeyFilePlaintext is returned by LoadKeyPairEncrypted for files that aren't encrypted
+	ErrKeyFilePlaintext = errors.New("gabbygrove/keyfile: key is not encrypted")
+
+	// ErrPassphrase is returned if the key can't be decrypted, either because of a wrong passphrase or a modified file
+	ErrPassphrase = errors.New("gabbygrove/keyfile: wrong passphrase")
+)
+
+// keyFile is the JSON stored on disk. Only the seed is saved, the rest of the private key is derived from it.
+type keyFile struct {
+	Curve string `json:"curve"`
+	ID    string `json:"id"`
+
+	Seed      []byte        `json:"seed,omitempty"`
+	Encrypted *encryptedKey `json:"encrypted,omitempty"`
+}
+
+// encryptedKey is a seed sealed with XChaCha20-Poly1305 under a key derived from the passphrase with argon2id.
+// The id of the key file is authenticated as additional data.
+type encryptedKey struct {
+	KDF     string `json:"kdf"`
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"` // in KiB
+	Threads uint8  `json:"threads"`
+
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+const kdfArgon2id = "argon2id"
+
+// keyFileKDF are the argon2id parameters new files are encrypted with, the recommended ones from the argon2 package
+var keyFileKDF = encryptedKey{KDF: kdfArgon2id, Time: 1, Memory: 64 * 1024, Threads: 4}
+
+// files asking for more than this are rejected before any work is done
+const (
+	maxKDFTime   = 16
+	maxKDFMemory = 1024 * 1024
+)
+
+// SaveKeyPair writes the secret of kp unencrypted to a new file at path, which only the owner can read.
+// It doesn't overwrite existing files.
+func SaveKeyPair(path string, kp *KeyPair) error {
+	kf, err := newKeyFile(kp)
+	if err != nil {
+		return err
+	}
+	err = kp.withSeed(func(seed []byte) error {
+		kf.Seed = append([]byte(nil), seed...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	defer Zeroize(kf.Seed)
+	return writeKeyFile(path, kf)
+}
+
+// SaveKeyPairEncrypted is like SaveKeyPair but seals the secret with a key derived from passphrase
+func SaveKeyPairEncrypted(path string, kp *KeyPair, passphrase []byte) error {
+	if len(passphrase) == 0 {
+		return errors.New("gabbygrove/keyfile: empty passphrase")
+	}
+	kf, err := newKeyFile(kp)
+	if err != nil {
+		return err
+	}
+
+	enc := keyFileKDF
+	enc.Salt = make([]byte, 16)
+	enc.Nonce = make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := rand.Read(enc.Salt); err != nil {
+		return errors.Wrap(err, "gabbygrove/keyfile: failed to create salt")
+	}
+	if _, err := rand.Read(enc.Nonce); err != nil {
+		return errors.Wrap(err, "gabbygrove/keyfile: failed to create nonce")
+	}
+
+	aead, err := enc.aead(passphrase)
+	if err != nil {
+		return err
+	}
+	err = kp.withSeed(func(seed []byte) error {
+		enc.Ciphertext = aead.Seal(nil, enc.Nonce, seed, []byte(kf.ID))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	kf.Encrypted = &enc
+	return writeKeyFile(path, kf)
+}
+
+// LoadKeyPair reads a key file written by SaveKeyPair, see NewKeyPair for lock
+func LoadKeyPair(path string, lock bool) (*KeyPair, error) {
+	kf, err := readKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if kf.Encrypted != nil {
+		return nil, ErrKeyFileEncrypted
+	}
+	defer Zeroize(kf.Seed)
+	return kf.keyPair(kf.Seed, lock)
+}
+
+// LoadKeyPairEncrypted reads a key file written by SaveKeyPairEncrypted, see NewKeyPair for lock
+func LoadKeyPairEncrypted(path string, passphrase []byte, lock bool) (*KeyPair, error) {
+	kf, err := readKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	enc := kf.Encrypted
+	if enc == nil {
+		Zeroize(kf.Seed)
+		return nil, ErrKeyFilePlaintext
+	}
+	if enc.KDF != kdfArgon2id {
+		return nil, errors.Errorf("gabbygrove/keyfile: unsupported kdf: %q", enc.KDF)
+	}
+	if enc.Time == 0 || enc.Time > maxKDFTime || enc.Memory == 0 || enc.Memory > maxKDFMemory || enc.Threads == 0 {
+		return nil, errors.Errorf("gabbygrove/keyfile: unreasonable kdf parameters")
+	}
+	if len(enc.Nonce) != chacha20poly1305.NonceSizeX {
+		return nil, errors.Errorf("gabbygrove/keyfile: invalid nonce size: %d", len(enc.Nonce))
+	}
+
+	aead, err := enc.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := aead.Open(nil, enc.Nonce, enc.Ciphertext, []byte(kf.ID))
+	if err != nil {
+		return nil, ErrPassphrase
+	}
+	defer Zeroize(seed)
+	return kf.keyPair(seed, lock)
+}
+
+func (enc encryptedKey) aead(passphrase []byte) (cipher.AEAD, error) {
+	key := argon2.IDKey(passphrase, enc.Salt, enc.Time, enc.Memory, enc.Threads, chacha20poly1305.KeySize)
+	defer Zeroize(key)
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "gabbygrove/keyfile: failed to create cipher")
+	}
+	return aead, nil
+}
+
+func newKeyFile(kp *KeyPair) (*keyFile, error) {
+	feed, err := kp.Feed()
+	if err != nil {
+		return nil, errors.Wrap(err, "gabbygrove/keyfile: invalid public key")
+	}
+	return &keyFile{Curve: "ed25519", ID: feed.URI()}, nil
+}
+
+// keyPair derives the private key from seed and checks that it belongs to the id of the file
+func (kf keyFile) keyPair(seed []byte, lock bool) (*KeyPair, error) {
+	if n := len(seed); n != ed25519.SeedSize {
+		return nil, errors.Errorf("gabbygrove/keyfile: invalid seed size: %d", n)
+	}
+	priv := ed25519.NewKeyFromSeed(seed)
+	defer Zeroize(priv)
+	kp, err := NewKeyPair(priv, lock)
+	if err != nil {
+		return nil, err
+	}
+	feed, err := kp.Feed()
+	if err != nil || feed.URI() != kf.ID {
+		kp.Close()
+		return nil, errors.Errorf("gabbygrove/keyfile: key doesn't match id %s", kf.ID)
+	}
+	return kp, nil
+}
+
+func writeKeyFile(path string, kf *keyFile) error {
+	data, err := json.MarshalIndent(kf, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "gabbygrove/keyfile: failed to encode")
+	}
+	defer Zeroize(data)
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return errors.Wrap(err, "gabbygrove/keyfile: failed to create file")
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(path)
+		return errors.Wrap(err, "gabbygrove/keyfile: failed to write")
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(path)
+		return errors.Wrap(err, "gabbygrove/keyfile: failed to write")
+	}
+	return f.Close()
+}
+
+func readKeyFile(path string) (*keyFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "gabbygrove/keyfile: failed to read file")
+	}
+	defer Zeroize(data)
+
+	var kf keyFile
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return nil, errors.Wrap(err, "gabbygrove/keyfile: broken file")
+	}
+	if kf.Curve != "ed25519" {
+		return nil, errors.Errorf("gabbygrove/keyfile: unsupported curve: %q", kf.Curve)
+	}
+	return &kf, nil
+}
@@ -0,0 +1,110 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyFile(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	kp, err := GenerateKeyPair(bytes.NewReader(bytes.Repeat([]byte("beef"), 8)), false)
+	r.NoError(err)
+	defer kp.Close()
+	sig, err := kp.Sign([]byte("test"))
+	r.NoError(err)
+
+	plain := filepath.Join(dir, "plain")
+	r.NoError(SaveKeyPair(plain, kp))
+	a.Error(SaveKeyPair(plain, kp), "overwrote existing file")
+	fi, err := os.Stat(plain)
+	r.NoError(err)
+	a.Equal(os.FileMode(0600), fi.Mode().Perm())
+
+	loaded, err := LoadKeyPair(plain, false)
+	r.NoError(err)
+	a.Equal(kp.Public(), loaded.Public())
+	loadedSig, err := loaded.Sign([]byte("test"))
+	r.NoError(err)
+	a.Equal(sig, loadedSig)
+	loaded.Close()
+
+	_, err = LoadKeyPairEncrypted(plain, []byte("pass"), false)
+	a.Equal(ErrKeyFilePlaintext, err)
+
+	enc := filepath.Join(dir, "encrypted")
+	a.Error(SaveKeyPairEncrypted(enc, kp, nil))
+	r.NoError(SaveKeyPairEncrypted(enc, kp, []byte("correct horse")))
+
+	data, err := ioutil.ReadFile(enc)
+	r.NoError(err)
+	plainData, err := ioutil.ReadFile(plain)
+	r.NoError(err)
+	var kf keyFile
+	r.NoError(json.Unmarshal(plainData, &kf))
+	a.False(bytes.Contains(data, kf.Seed), "seed in the clear")
+	a.NotContains(string(data), `"seed"`)
+
+	_, err = LoadKeyPair(enc, false)
+	a.Equal(ErrKeyFileEncrypted, err)
+	_, err = LoadKeyPairEncrypted(enc, []byte("wrong"), false)
+	a.Equal(ErrPassphrase, err)
+
+	loaded, err = LoadKeyPairEncrypted(enc, []byte("correct horse"), false)
+	r.NoError(err)
+	a.Equal(kp.Public(), loaded.Public())
+	loadedSig, err = loaded.Sign([]byte("test"))
+	r.NoError(err)
+	a.Equal(sig, loadedSig)
+	loaded.Close()
+
+	// the id is authenticated
+	other, err := GenerateKeyPair(bytes.NewReader(bytes.Repeat([]byte("dead"), 8)), false)
+	r.NoError(err)
+	defer other.Close()
+	otherFeed, err := other.Feed()
+	r.NoError(err)
+	r.NoError(json.Unmarshal(data, &kf))
+	kf.ID = otherFeed.URI()
+	swapped := filepath.Join(dir, "swapped")
+	r.NoError(writeKeyFile(swapped, &kf))
+	_, err = LoadKeyPairEncrypted(swapped, []byte("correct horse"), false)
+	a.Equal(ErrPassphrase, err)
+
+	// and so is the plaintext one, by deriving it
+	r.NoError(json.Unmarshal(plainData, &kf))
+	kf.ID = otherFeed.URI()
+	swapped = filepath.Join(dir, "swapped-plain")
+	r.NoError(writeKeyFile(swapped, &kf))
+	_, err = LoadKeyPair(swapped, false)
+	a.Error(err)
+
+	// don't burn cpu on hostile parameters
+	r.NoError(json.Unmarshal(data, &kf))
+	kf.Encrypted.Memory = 1 << 30
+	costly := filepath.Join(dir, "costly")
+	r.NoError(writeKeyFile(costly, &kf))
+	_, err = LoadKeyPairEncrypted(costly, []byte("correct horse"), false)
+	a.Error(err)
+
+	closed, err := GenerateKeyPair(nil, false)
+	r.NoError(err)
+	closed.Close()
+	a.Equal(ErrKeyPairClosed, SaveKeyPair(filepath.Join(dir, "closed"), closed))
+}
@@ -0,0 +1,134 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+)
+
+// ErrKeyPairClosed is returned when a KeyPair is used after Close
+var ErrKeyPairClosed = errors.New("gabbygrove/keypair: closed")
+
+// ErrLockingUnsupported is returned by NewKeyPair if locked memory was asked for but isn't available on this platform
+var ErrLockingUnsupported = errors.New("gabbygrove/keypair: memory locking not supported")
+
+// KeyPair holds a private key in one place and wipes it on Close.
+// With locking, the secret lives in memory that is excluded from swap (mlock) and outside of the garbage collected heap,
+// so that the runtime can't leave copies of it behind.
+//
+// A KeyPair is a Signer and safe for concurrent use.
+type KeyPair struct {
+	mu sync.Mutex
+
+	pub    ed25519.PublicKey
+	secret []byte
+	locked bool
+}
+
+var _ Signer = (*KeyPair)(nil)
+
+// NewKeyPair copies priv into a new KeyPair.
+// The caller should wipe priv afterwards, see Zeroize.
+func NewKeyPair(priv ed25519.PrivateKey, lock bool) (*KeyPair, error) {
+	if n := len(priv); n != ed25519.PrivateKeySize {
+		return nil, errors.Errorf("gabbygrove/keypair: invalid private key size: %d", n)
+	}
+
+	var kp KeyPair
+	if lock {
+		var err error
+		kp.secret, err = lockedAlloc(ed25519.PrivateKeySize)
+		if err != nil {
+			return nil, err
+		}
+		kp.locked = true
+	} else {
+		kp.secret = make([]byte, ed25519.PrivateKeySize)
+	}
+	copy(kp.secret, priv)
+
+	kp.pub = make(ed25519.PublicKey, ed25519.PublicKeySize)
+	copy(kp.pub, priv[32:])
+	return &kp, nil
+}
+
+// GenerateKeyPair creates a new key from the randomness of r (crypto/rand if nil).
+// The seed doesn't leave this function except in the KeyPair.
+func GenerateKeyPair(r io.Reader, lock bool) (*KeyPair, error) {
+	_, priv, err := ed25519.GenerateKey(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "gabbygrove/keypair: failed to generate key")
+	}
+	defer Zeroize(priv)
+	return NewKeyPair(priv, lock)
+}
+
+// Public returns the public key, which stays available after Close
+func (kp *KeyPair) Public() ed25519.PublicKey {
+	return kp.pub
+}
+
+// Feed returns the reference of the feed this key signs for
+func (kp *KeyPair) Feed() (refs.FeedRef, error) {
+	return refs.NewFeedRefFromBytes(kp.pub, refs.RefAlgoFeedGabby)
+}
+
+// Locked returns true if the secret is held in locked memory
+func (kp *KeyPair) Locked() bool {
+	return kp.locked
+}
+
+// Sign signs msg, it fails with ErrKeyPairClosed after Close
+func (kp *KeyPair) Sign(msg []byte) ([]byte, error) {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	if kp.secret == nil {
+		return nil, ErrKeyPairClosed
+	}
+	// ed25519 keeps per-key state keyed by the heap address of the key, which can't point into locked memory.
+	// Sign from a copy that only lives for the duration of the call instead.
+	key := make(ed25519.PrivateKey, ed25519.PrivateKeySize)
+	copy(key, kp.secret)
+	defer Zeroize(key)
+	return ed25519.Sign(key, msg), nil
+}
+
+// withSeed calls fn with the seed of the private key, which it must not keep
+func (kp *KeyPair) withSeed(fn func(seed []byte) error) error {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	if kp.secret == nil {
+		return ErrKeyPairClosed
+	}
+	return fn(kp.secret[:ed25519.SeedSize])
+}
+
+// Close wipes the secret and releases locked memory. Calling it more than once is fine.
+func (kp *KeyPair) Close() error {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	if kp.secret == nil {
+		return nil
+	}
+	Zeroize(kp.secret)
+	var err error
+	if kp.locked {
+		err = lockedFree(kp.secret)
+	}
+	kp.secret = nil
+	return err
+}
+
+// Zeroize overwrites b with zeros
+func Zeroize(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,36 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+//go:build (linux || darwin || freebsd || netbsd || openbsd) && !tinygo
+// +build linux darwin freebsd netbsd openbsd
+// +build !tinygo
+
+package gabbygrove
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// lockedAlloc maps n bytes of anonymous memory and locks it into RAM
+func lockedAlloc(n int) ([]byte, error) {
+	b, err := syscall.Mmap(-1, 0, n, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, errors.Wrap(err, "gabbygrove/keypair: failed to map memory")
+	}
+	if err := syscall.Mlock(b); err != nil {
+		syscall.Munmap(b)
+		return nil, errors.Wrap(err, "gabbygrove/keypair: failed to lock memory")
+	}
+	return b, nil
+}
+
+// lockedFree releases memory from lockedAlloc, it needs to be wiped before
+func lockedFree(b []byte) error {
+	if err := syscall.Munlock(b); err != nil {
+		return errors.Wrap(err, "gabbygrove/keypair: failed to unlock memory")
+	}
+	return errors.Wrap(syscall.Munmap(b), "gabbygrove/keypair: failed to unmap memory")
+}
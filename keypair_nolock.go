@@ -0,0 +1,16 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+//go:build !(linux || darwin || freebsd || netbsd || openbsd) || tinygo
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd tinygo
+
+package gabbygrove
+
+func lockedAlloc(n int) ([]byte, error) {
+	return nil, ErrLockingUnsupported
+}
+
+func lockedFree(b []byte) error {
+	return ErrLockingUnsupported
+}
@@ -0,0 +1,77 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestKeyPair(t *testing.T) {
+	for _, lock := range []bool{false, true} {
+		r := require.New(t)
+		a := assert.New(t)
+
+		kp, err := GenerateKeyPair(bytes.NewReader(bytes.Repeat([]byte("beef"), 8)), lock)
+		if lock && err != nil {
+			t.Log("skipping locked memory:", err)
+			continue
+		}
+		r.NoError(err)
+		a.Equal(lock, kp.Locked())
+
+		pub, priv := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("beef"), 8)))
+		a.Equal(pub, kp.Public())
+		feed, err := kp.Feed()
+		r.NoError(err)
+		a.Equal(pub, ed25519.PublicKey(feed.PubKey()))
+
+		// the same messages as with the plain private key
+		tr, _, err := NewSignerEncoder(kp).Encode(1, BinaryRef{}, []byte("hello"))
+		r.NoError(err)
+		want, _, err := NewEncoder(priv).Encode(1, BinaryRef{}, []byte("hello"))
+		r.NoError(err)
+		a.Equal(want.Signature, tr.Signature)
+		a.True(tr.Verify(nil))
+
+		secret := kp.secret
+		r.NoError(kp.Close())
+		a.Nil(kp.secret)
+		if !lock {
+			// locked memory is unmapped after Close and can't be looked at anymore
+			a.Equal(make([]byte, len(secret)), secret, "not wiped")
+		}
+		r.NoError(kp.Close(), "second close")
+		a.Equal(pub, kp.Public())
+
+		_, err = kp.Sign([]byte("after"))
+		a.Equal(ErrKeyPairClosed, err)
+		_, _, err = NewSignerEncoder(kp).Encode(2, BinaryRef{}, []byte("after"))
+		a.Equal(ErrKeyPairClosed, errors.Cause(err))
+	}
+}
+
+func TestKeyPairCopies(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, priv := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	kp, err := NewKeyPair(priv, false)
+	r.NoError(err)
+	defer kp.Close()
+
+	Zeroize(priv)
+	a.Equal(make([]byte, len(priv)), []byte(priv))
+	_, err = kp.Sign([]byte("still works"))
+	a.NoError(err)
+
+	_, err = NewKeyPair(priv[:32], false)
+	a.Error(err)
+}
@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// ErrKeyReuse is returned when the key of an encoder already backs a legacy feed
+var ErrKeyReuse = errors.New("gabbygrove: key is already used for a legacy feed")
+
+// LegacyFeedLookupFunc reports whether a legacy (ed25519) feed exists, i.e. in the local database of an application
+type LegacyFeedLookupFunc func(refs.FeedRef) (bool, error)
+
+// WithKeyReuseCheck makes the encoder refuse to sign if lookup knows a legacy feed with the same key.
+// One key pair backing two feed formats ties both feeds together for everyone and can't be undone, see DualEncoder for a migration with two keys.
+// The lookup runs before the first message is signed and a negative answer is remembered, nil removes the check.
+func (e *Encoder) WithKeyReuseCheck(lookup LegacyFeedLookupFunc) {
+	e.legacyLookup = lookup
+	e.mu.Lock()
+	e.keyChecked = false
+	e.mu.Unlock()
+}
+
+func (e *Encoder) checkKeyReuse() error {
+	if e.legacyLookup == nil {
+		return nil
+	}
+	e.mu.Lock()
+	checked := e.keyChecked
+	e.mu.Unlock()
+	if checked {
+		return nil
+	}
+	legacy, err := refs.NewFeedRefFromBytes(e.signer.Public(), refs.RefAlgoFeedSSB1)
+	if err != nil {
+		return errors.Wrap(err, "invalid author key")
+	}
+	used, err := e.legacyLookup(legacy)
+	if err != nil {
+		return errors.Wrap(err, "legacy feed lookup failed")
+	}
+	if used {
+		return errors.Wrapf(ErrKeyReuse, "encoder: %s", legacy.ShortSigil())
+	}
+	e.mu.Lock()
+	e.keyChecked = true
+	e.mu.Unlock()
+	return nil
+}
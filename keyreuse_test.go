@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+func TestKeyReuseCheck(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	pubKey, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	legacy, err := refs.NewFeedRefFromBytes(pubKey, refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+
+	known := map[string]bool{legacy.String(): true}
+	var lookups int
+	lookup := func(fr refs.FeedRef) (bool, error) {
+		lookups++
+		a.Equal(refs.RefAlgoFeedSSB1, fr.Algo())
+		return known[fr.String()], nil
+	}
+
+	e := NewEncoder(privKey)
+	e.WithKeyReuseCheck(lookup)
+	_, _, err = e.Encode(1, BinaryRef{}, []byte("hello"))
+	a.Equal(ErrKeyReuse, errors.Cause(err))
+	_, _, err = e.EncodeWithContentHash(1, BinaryRef{}, ContentTypeArbitrary, BinaryRef{}, 0)
+	a.Error(err)
+
+	// a fresh key
+	_, otherKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("beef"), 8)))
+	e = NewEncoder(otherKey)
+	e.WithKeyReuseCheck(lookup)
+	lookups = 0
+	for i := 0; i < 3; i++ {
+		_, _, err = e.Encode(uint64(i+1), BinaryRef{}, []byte("hello"))
+		r.NoError(err)
+	}
+	a.Equal(1, lookups, "negative answer is remembered")
+
+	e = NewEncoder(privKey)
+	e.WithKeyReuseCheck(func(refs.FeedRef) (bool, error) { return false, errors.New("db down") })
+	_, _, err = e.Encode(1, BinaryRef{}, []byte("hello"))
+	a.Error(err)
+
+	e.WithKeyReuseCheck(nil)
+	_, _, err = e.Encode(1, BinaryRef{}, []byte("hello"))
+	a.NoError(err)
+}
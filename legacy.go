@@ -0,0 +1,165 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+)
+
+// LegacyMessage is a signed message of the classic (ed25519/sha256) JSON feed format
+type LegacyMessage struct {
+	Key refs.MessageRef
+
+	// Raw holds the signed JSON exactly as it needs to be replicated
+	Raw json.RawMessage
+}
+
+// legacyValue mirrors the field order of the legacy format up to (and excluding) the signature
+type legacyValue struct {
+	Previous  *refs.MessageRef `json:"previous"`
+	Author    refs.FeedRef     `json:"author"`
+	Sequence  uint64           `json:"sequence"`
+	Timestamp int64            `json:"timestamp"`
+	Hash      string           `json:"hash"`
+	Content   json.RawMessage  `json:"content"`
+}
+
+// NewLegacyEncoder returns an encoder for classic ed25519 JSON feeds.
+// It only exists to ease the migration to gabbygrove (see DualEncoder) and only produces plain, unencrypted JSON content.
+func NewLegacyEncoder(author ed25519.PrivateKey) *LegacyEncoder {
+	le := &LegacyEncoder{}
+	le.privKey = author
+	return le
+}
+
+type LegacyEncoder struct {
+	privKey ed25519.PrivateKey
+
+	setTimestamp bool
+}
+
+func (le *LegacyEncoder) WithNowTimestamps(yes bool) {
+	le.setTimestamp = yes
+}
+
+func (le *LegacyEncoder) Encode(sequence uint64, prev *refs.MessageRef, val interface{}) (*LegacyMessage, refs.MessageRef, error) {
+	var ts int64
+	if le.setTimestamp {
+		ts = now().UnixNano() / 1000000
+	}
+	return le.encode(sequence, prev, val, ts)
+}
+
+// encode signs val as the next legacy message with an explicit timestamp (in milliseconds)
+func (le *LegacyEncoder) encode(sequence uint64, prev *refs.MessageRef, val interface{}, timestamp int64) (*LegacyMessage, refs.MessageRef, error) {
+	if _, isBytes := val.([]byte); isBytes {
+		return nil, refs.MessageRef{}, errors.Errorf("gabbygrove/legacy: can't publish arbitrary bytes on a legacy feed")
+	}
+
+	author, err := refs.NewFeedRefFromBytes(le.privKey.Public().(ed25519.PublicKey), refs.RefAlgoFeedSSB1)
+	if err != nil {
+		return nil, refs.MessageRef{}, errors.Wrap(err, "gabbygrove/legacy: invalid author ref")
+	}
+
+	content, err := json.Marshal(val)
+	if err != nil {
+		return nil, refs.MessageRef{}, errors.Wrap(err, "gabbygrove/legacy: json content encoding failed")
+	}
+
+	var lv legacyValue
+	if sequence > 1 {
+		if prev == nil {
+			return nil, refs.MessageRef{}, errors.Errorf("gabbygrove/legacy: sequence %d needs a previous message", sequence)
+		}
+		lv.Previous = prev
+	}
+	lv.Author = author
+	lv.Sequence = sequence
+	lv.Timestamp = timestamp
+	lv.Hash = "sha256"
+	lv.Content = content
+
+	unsigned, err := legacyPrettyPrint(lv)
+	if err != nil {
+		return nil, refs.MessageRef{}, err
+	}
+
+	sig := ed25519.Sign(le.privKey, unsigned)
+
+	// splice the signature in as the last field of the object
+	var signed bytes.Buffer
+	signed.Write(unsigned[:len(unsigned)-2])
+	signed.WriteString(",\n  \"signature\": \"")
+	signed.WriteString(base64.StdEncoding.EncodeToString(sig))
+	signed.WriteString(".sig.ed25519\"\n}")
+
+	var msg LegacyMessage
+	msg.Raw = signed.Bytes()
+	msg.Key, err = legacyHash(msg.Raw)
+	if err != nil {
+		return nil, refs.MessageRef{}, err
+	}
+	return &msg, msg.Key, nil
+}
+
+// Verify checks the signature of a message produced by a LegacyEncoder
+func (lm LegacyMessage) Verify() bool {
+	const sigField = ",\n  \"signature\": \""
+	idx := bytes.LastIndex(lm.Raw, []byte(sigField))
+	if idx < 0 {
+		return false
+	}
+
+	sigStr := string(lm.Raw[idx+len(sigField):])
+	if !strings.HasSuffix(sigStr, ".sig.ed25519\"\n}") {
+		return false
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(sigStr, ".sig.ed25519\"\n}"))
+	if err != nil {
+		return false
+	}
+
+	var lv legacyValue
+	if err := json.Unmarshal(lm.Raw, &lv); err != nil {
+		return false
+	}
+
+	unsigned := append(append([]byte{}, lm.Raw[:idx]...), '\n', '}')
+	return ed25519.Verify(lv.Author.PubKey(), unsigned, sig)
+}
+
+// legacyPrettyPrint formats the value the way JSON.stringify(v, null, 2) would
+func legacyPrettyPrint(lv legacyValue) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(lv); err != nil {
+		return nil, errors.Wrap(err, "gabbygrove/legacy: failed to encode message")
+	}
+	// strip the newline added by the encoder
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// legacyHash computes the message key over the "v8 binary" representation of the signed JSON,
+// which keeps only the lower byte of each UTF-16 code unit.
+func legacyHash(raw []byte) (refs.MessageRef, error) {
+	h := sha256.New()
+	var b [1]byte
+	for _, u := range utf16.Encode([]rune(string(raw))) {
+		b[0] = byte(u)
+		h.Write(b[:])
+	}
+	return refs.NewMessageRefFromBytes(h.Sum(nil), refs.RefAlgoMessageSSB1)
+}
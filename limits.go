@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ed25519"
+)
+
+// Content that is left out of a transfer (because it was deleted or not replicated) is nil and encoded as CBOR null.
+// Empty but non-nil content is a payload of zero bytes, encoded as an empty byte string.
+// Only ContentTypeArbitrary can be empty, its hash is the sha256 of no bytes.
+var (
+	ErrEmptyContent = errors.New("gabbygrove: only arbitrary content can be empty")
+	ErrContentSize  = errors.New("gabbygrove: content does not match the announced size")
+)
+
+// Limits are the size bounds of the format.
+// DefaultLimits holds the values this package uses internally, peers can pass stricter ones to Transfer.Validate.
+type Limits struct {
+	// MaxContentSize is the largest content payload in bytes
+	MaxContentSize int
+
+	// MaxEventSize is the largest encoded event in bytes
+	MaxEventSize int
+
+	// MaxTransferSize is the largest encoded transfer (event, signature and content) in bytes
+	MaxTransferSize int
+
+	// MaxSequence is the highest sequence a feed can reach.
+	// It's bound by the int64 of the refs.Message interface.
+	MaxSequence uint64
+}
+
+// DefaultLimits are the authoritative limits of gabbygrove-v1
+var DefaultLimits = Limits{
+	MaxContentSize:  math.MaxUint16,
+	MaxEventSize:    maxEventSize,
+	MaxTransferSize: maxTransferSize,
+	MaxSequence:     math.MaxInt64,
+}
+
+// checkSizes only looks at the lengths of the encoded fields
+func (tr *Transfer) checkSizes(l Limits) error {
+	if len(tr.Content) > l.MaxContentSize {
+		return errors.Errorf("gabbygrove/transfer: content too large")
+	}
+	if len(tr.Signature) != ed25519.SignatureSize {
+		return errors.Errorf("gabbygrove/transfer: wrong signature size")
+	}
+	if len(tr.Event) > l.MaxEventSize {
+		return errors.Errorf("gabbygrove/transfer: event too large")
+	}
+	if n := 1 + cborBytesLen(len(tr.Event)) + cborBytesLen(len(tr.Signature)) + cborBytesLen(len(tr.Content)); n > l.MaxTransferSize {
+		return errors.Errorf("gabbygrove/transfer: transfer too large")
+	}
+	return nil
+}
+
+// Validate checks tr against the passed limits.
+// Next to the encoded sizes, this decodes the event to check the sequence and the size of the content it announces.
+func (tr *Transfer) Validate(l Limits) error {
+	if err := tr.checkSizes(l); err != nil {
+		return err
+	}
+	evt, err := tr.getEvent()
+	if err != nil {
+		return errors.Wrap(err, "gabbygrove/transfer: invalid event")
+	}
+	if evt.Sequence == 0 || evt.Sequence > l.MaxSequence {
+		return errors.Errorf("gabbygrove/transfer: sequence %d out of range", evt.Sequence)
+	}
+	if int(evt.Content.Size) > l.MaxContentSize {
+		return errors.Errorf("gabbygrove/transfer: announced content too large")
+	}
+	if evt.Content.Size == 0 && evt.Content.Type != ContentTypeArbitrary {
+		return errors.Wrapf(ErrEmptyContent, "transfer: type %d", evt.Content.Type)
+	}
+	if n := len(tr.Content); tr.Content != nil && n != int(evt.Content.Size) {
+		return errors.Wrapf(ErrContentSize, "transfer: %d != %d", n, evt.Content.Size)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimits(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	e := NewEncoder(privKey)
+
+	tr, _, err := e.Encode(1, BinaryRef{}, bytes.Repeat([]byte("X"), DefaultLimits.MaxContentSize))
+	r.NoError(err)
+	r.NoError(tr.Validate(DefaultLimits))
+
+	full, err := tr.MarshalCBOR()
+	r.NoError(err)
+	a.True(len(full) <= DefaultLimits.MaxTransferSize)
+	a.True(len(tr.Event) <= DefaultLimits.MaxEventSize)
+
+	strict := DefaultLimits
+	strict.MaxContentSize = 1024
+	a.Error(tr.Validate(strict))
+
+	small, _, err := e.Encode(1, BinaryRef{}, "hi")
+	r.NoError(err)
+	r.NoError(small.Validate(strict))
+
+	// content that doesn't match the event
+	small.Content = append(small.Content, 'x')
+	a.Error(small.Validate(DefaultLimits))
+
+	_, _, err = e.Encode(math.MaxInt64+1, BinaryRef{}, "too far")
+	a.Error(err)
+}
+
+func TestTrailingBytes(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, trs := makeTestFeed(t, "dead", 2)
+
+	var stream []byte
+	for _, tr := range trs {
+		b, err := tr.MarshalCBOR()
+		r.NoError(err)
+		stream = append(stream, b...)
+	}
+
+	var tr Transfer
+	a.Error(tr.UnmarshalCBOR(stream), "two transfers are not one")
+
+	first, rest, err := DecodeFirst(stream)
+	r.NoError(err)
+	a.Equal(trs[0].Key(), first.Key())
+
+	second, rest, err := DecodeFirst(rest)
+	r.NoError(err)
+	a.Equal(trs[1].Key(), second.Key())
+	a.Len(rest, 0)
+
+	var evt Event
+	a.Error(evt.UnmarshalCBOR(append(trs[0].Event, 0x00)))
+	r.NoError(evt.UnmarshalCBOR(trs[0].Event))
+}
+
+func TestEmptyContent(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	e := NewEncoder(privKey)
+
+	for _, empty := range [][]byte{nil, {}} {
+		tr, _, err := e.Encode(1, BinaryRef{}, empty)
+		r.NoError(err)
+		r.NotNil(tr.Content)
+		a.True(tr.HasContent())
+		a.True(tr.ContentMatches(nil))
+		r.NoError(tr.Validate(DefaultLimits))
+
+		evt, err := tr.UnmarshaledEvent()
+		r.NoError(err)
+		a.EqualValues(0, evt.Content.Size)
+		a.Equal(ContentTypeArbitrary, evt.Content.Type)
+
+		// empty content survives the round trip and is not the same as missing content
+		data, err := tr.MarshalCBOR()
+		r.NoError(err)
+		a.Equal(byte(0x40), data[len(data)-1])
+		var decoded Transfer
+		r.NoError(decoded.UnmarshalCBOR(data))
+		a.True(decoded.HasContent())
+		a.Len(decoded.Content, 0)
+
+		decoded.Content = nil
+		a.False(decoded.HasContent())
+		data, err = decoded.MarshalCBOR()
+		r.NoError(err)
+		a.Equal(byte(cborNull), data[len(data)-1])
+		r.NoError(decoded.UnmarshalCBOR(data))
+		a.False(decoded.HasContent())
+		r.NoError(decoded.Validate(DefaultLimits))
+	}
+
+	tr, _, err := e.Encode(1, BinaryRef{}, []byte("foo"))
+	r.NoError(err)
+	tr.Content = []byte{}
+	err = tr.Validate(DefaultLimits)
+	a.True(errors.Is(err, ErrContentSize), "%v", err)
+
+	ref, err := HashContent(nil)
+	r.NoError(err)
+	_, _, err = e.EncodeWithContentHash(1, BinaryRef{}, ContentTypeJSON, ref, 0)
+	a.True(errors.Is(err, ErrEmptyContent), "%v", err)
+
+	// what another implementation might produce
+	var evt Event
+	r.NoError(evt.UnmarshalCBOR(tr.Event))
+	evt.Content.Size = 0
+	evt.Content.Type = ContentTypeJSON
+	evtBytes, err := evt.MarshalCBOR()
+	r.NoError(err)
+	sig, err := e.sign(evtBytes)
+	r.NoError(err)
+	jsonTr := Transfer{Event: evtBytes, Signature: sig}
+	err = jsonTr.Validate(DefaultLimits)
+	a.True(errors.Is(err, ErrEmptyContent), "%v", err)
+}
@@ -0,0 +1,38 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"sync/atomic"
+
+	"go.mindeco.de/log"
+	"go.mindeco.de/log/level"
+)
+
+type loggerHolder struct{ log.Logger }
+
+var pkgLogger atomic.Value
+
+// SetLogger installs l to receive debug events about decode failures, failed verifications and broken chains.
+// The events carry the sequence and message or author reference where available.
+// By default nothing is logged.
+func SetLogger(l log.Logger) {
+	pkgLogger.Store(loggerHolder{l})
+}
+
+// debugEnabled is true if a logger is installed.
+// Callers check it before computing arguments like message keys, which would hash the event for nothing otherwise.
+func debugEnabled() bool {
+	h, ok := pkgLogger.Load().(loggerHolder)
+	return ok && h.Logger != nil
+}
+
+func debugLog(keyvals ...interface{}) {
+	h, ok := pkgLogger.Load().(loggerHolder)
+	if !ok || h.Logger == nil {
+		return
+	}
+	level.Debug(h.Logger).Log(keyvals...)
+}
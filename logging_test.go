@@ -0,0 +1,56 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mindeco.de/log"
+)
+
+func TestLogger(t *testing.T) {
+	a := assert.New(t)
+
+	var buf bytes.Buffer
+	SetLogger(log.NewLogfmtLogger(&buf))
+	defer SetLogger(nil)
+
+	author, trs := makeTestFeed(t, "dead", 2)
+
+	tampered := *trs[0]
+	tampered.Signature = append([]byte{}, trs[0].Signature...)
+	tampered.Signature[0] ^= 0xff
+	a.False(tampered.Verify(nil))
+	a.Contains(buf.String(), "event=verify")
+	a.Contains(buf.String(), "seq=1")
+	a.Contains(buf.String(), tampered.Key().URI())
+	buf.Reset()
+
+	a.Error(NewFeedState(author).Append(trs[1]))
+	a.Contains(buf.String(), "event=append")
+	a.Contains(buf.String(), author.URI())
+	a.Contains(buf.String(), "level=debug")
+	buf.Reset()
+
+	var tr Transfer
+	a.Error(tr.UnmarshalCBOR([]byte{0x83, 0x01}))
+	a.Contains(buf.String(), "event=decode")
+}
+
+func TestLoggerDisabled(t *testing.T) {
+	a := assert.New(t)
+
+	SetLogger(nil)
+	_, trs := makeTestFeed(t, "dead", 1)
+	trs[0].Claimed()
+
+	// without a logger, the key of the message isn't computed for the debug event
+	allocs := testing.AllocsPerRun(10, func() {
+		trs[0].Received()
+	})
+	a.Zero(allocs)
+}
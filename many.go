@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// MarshalMany appends the encodings of trs to buf, each prefixed by its length as an uvarint, and returns the extended buffer.
+// Passing the result of an earlier call as buf[:0] reuses its memory, so a batch can be written with one call to Write.
+// Transfers that exceed the DefaultLimits are rejected, since UnmarshalMany couldn't read them back.
+func MarshalMany(trs []*Transfer, buf []byte) ([]byte, error) {
+	start := len(buf)
+	var vbuf [binary.MaxVarintLen64]byte
+	for i, tr := range trs {
+		if tr == nil {
+			return buf[:start], errors.Errorf("gabbygrove: transfer %d is nil", i)
+		}
+		if err := tr.checkSizes(DefaultLimits); err != nil {
+			return buf[:start], errors.Wrapf(err, "gabbygrove: transfer %d", i)
+		}
+		buf = append(buf, vbuf[:binary.PutUvarint(vbuf[:], uint64(tr.encodedLen()))]...)
+		buf = tr.appendCBOR(buf)
+	}
+	return buf, nil
+}
+
+// UnmarshalMany decodes the transfers of a buffer written by MarshalMany.
+// Like UnmarshalCBOR, the transfers don't point into data and unknown fields are kept.
+func UnmarshalMany(data []byte) ([]*Transfer, error) {
+	var trs []*Transfer
+	for len(data) > 0 {
+		n, k := binary.Uvarint(data)
+		if k <= 0 {
+			return nil, errors.Errorf("gabbygrove: invalid length of transfer %d", len(trs))
+		}
+		data = data[k:]
+		if n > maxTransferSize || n > uint64(len(data)) {
+			return nil, errors.Errorf("gabbygrove: transfer %d has %d bytes of %d available", len(trs), n, len(data))
+		}
+
+		var tr Transfer
+		if err := tr.UnmarshalCBOR(data[:n]); err != nil {
+			return nil, errors.Wrapf(err, "gabbygrove: transfer %d", len(trs))
+		}
+		trs = append(trs, &tr)
+		data = data[n:]
+	}
+	return trs, nil
+}
+
+// encodedLen is the number of bytes appendCBOR appends.
+// The array head is one byte since there are never more than 23 elements, see cborMaxUnknownFields.
+func (tr *Transfer) encodedLen() int {
+	n := 1 + cborBytesLen(len(tr.Event)) + cborBytesLen(len(tr.Signature)) + cborBytesLen(len(tr.Content))
+	for _, u := range tr.unknown {
+		n += len(u)
+	}
+	return n
+}
@@ -0,0 +1,89 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalMany(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, trs := makeTestFeed(t, "many", 5)
+
+	buf, err := MarshalMany(trs, nil)
+	r.NoError(err)
+	for _, tr := range trs {
+		a.Equal(len(tr.appendCBOR(nil)), tr.encodedLen())
+	}
+
+	got, err := UnmarshalMany(buf)
+	r.NoError(err)
+	r.Len(got, len(trs))
+	for i := range trs {
+		a.Equal(trs[i].Key(), got[i].Key())
+		a.Equal(trs[i].Content, got[i].Content)
+	}
+
+	// the decoded transfers don't point into the buffer
+	again, err := MarshalMany(trs[:2], buf[:0])
+	r.NoError(err)
+	a.Equal(&buf[0], &again[0], "reused the buffer")
+	a.Equal(trs[4].Key(), got[4].Key())
+
+	out, err := MarshalMany(trs[:1], []byte("prefix"))
+	r.NoError(err)
+	a.Equal("prefix", string(out[:6]))
+
+	none, err := UnmarshalMany(nil)
+	r.NoError(err)
+	a.Len(none, 0)
+
+	_, err = MarshalMany([]*Transfer{trs[0], nil}, []byte("prefix"))
+	a.Error(err)
+
+	_, err = UnmarshalMany(buf[:len(buf)-1])
+	a.Error(err, "truncated")
+	_, err = UnmarshalMany(append([]byte{0xff}, buf...))
+	a.Error(err, "length beyond the buffer")
+	_, err = UnmarshalMany([]byte{0x80})
+	a.Error(err, "unterminated uvarint")
+}
+
+func BenchmarkMarshalMany(b *testing.B) {
+	_, trs := makeTestFeed(b, "many", 64)
+	buf, err := MarshalMany(trs, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if buf, err = MarshalMany(trs, buf[:0]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalMany(b *testing.B) {
+	_, trs := makeTestFeed(b, "many", 64)
+	buf, err := MarshalMany(trs, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := UnmarshalMany(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"crypto/sha256"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// MerkleTree is an auxiliary hash tree over the message keys of a feed.
+// It uses the construction of RFC 6962 (certificate transparency), which works for any number of messages.
+// With the root of a feed of length M, an InclusionProof shows that message N is part of it using only log2(M) hashes.
+type MerkleTree struct {
+	leaves [][32]byte
+}
+
+func NewMerkleTree() *MerkleTree {
+	return &MerkleTree{}
+}
+
+// Append adds the key of the next message of the feed
+func (mt *MerkleTree) Append(key refs.MessageRef) error {
+	var h [32]byte
+	if err := key.CopyHashTo(h[:]); err != nil {
+		return errors.Wrap(err, "merkle: invalid message key")
+	}
+	mt.leaves = append(mt.leaves, merkleLeaf(key))
+	return nil
+}
+
+// Len returns the number of messages in the tree
+func (mt *MerkleTree) Len() uint64 {
+	return uint64(len(mt.leaves))
+}
+
+// Root returns the root hash over all messages
+func (mt *MerkleTree) Root() [32]byte {
+	return merkleRoot(mt.leaves)
+}
+
+// RootAt returns the root hash over the first size messages
+func (mt *MerkleTree) RootAt(size uint64) ([32]byte, error) {
+	if size > mt.Len() {
+		return [32]byte{}, errors.Errorf("merkle: tree only has %d messages", mt.Len())
+	}
+	return merkleRoot(mt.leaves[:size]), nil
+}
+
+// InclusionProof shows that the message with Sequence is part of a feed of length TreeSize
+type InclusionProof struct {
+	Sequence uint64
+	TreeSize uint64
+	Path     [][32]byte
+}
+
+// InclusionProof creates a proof for the message seq (starting at 1) in the feed prefix of length size
+func (mt *MerkleTree) InclusionProof(seq, size uint64) (*InclusionProof, error) {
+	if size > mt.Len() {
+		return nil, errors.Errorf("merkle: tree only has %d messages", mt.Len())
+	}
+	if seq < 1 || seq > size {
+		return nil, errors.Errorf("merkle: sequence %d not in a tree of size %d", seq, size)
+	}
+	return &InclusionProof{
+		Sequence: seq,
+		TreeSize: size,
+		Path:     merklePath(seq-1, mt.leaves[:size]),
+	}, nil
+}
+
+// Verify checks that key is the message at p.Sequence of the tree with the passed root
+func (p InclusionProof) Verify(key refs.MessageRef, root [32]byte) bool {
+	if p.Sequence < 1 || p.Sequence > p.TreeSize {
+		return false
+	}
+
+	// RFC 9162, section 2.1.3.2
+	fn, sn := p.Sequence-1, p.TreeSize-1
+	r := merkleLeaf(key)
+	for _, h := range p.Path {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			r = merkleNode(h, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = merkleNode(r, h)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && r == root
+}
+
+func merkleLeaf(key refs.MessageRef) [32]byte {
+	var h [32]byte
+	key.CopyHashTo(h[:])
+	return sha256.Sum256(append([]byte{0x00}, h[:]...))
+}
+
+func merkleNode(left, right [32]byte) [32]byte {
+	buf := make([]byte, 1, 65)
+	buf[0] = 0x01
+	buf = append(buf, left[:]...)
+	buf = append(buf, right[:]...)
+	return sha256.Sum256(buf)
+}
+
+// merkleSplit returns the largest power of two smaller then n
+func merkleSplit(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+func merkleRoot(leaves [][32]byte) [32]byte {
+	switch len(leaves) {
+	case 0:
+		return sha256.Sum256(nil)
+	case 1:
+		return leaves[0]
+	}
+	k := merkleSplit(len(leaves))
+	return merkleNode(merkleRoot(leaves[:k]), merkleRoot(leaves[k:]))
+}
+
+func merklePath(m uint64, leaves [][32]byte) [][32]byte {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	k := merkleSplit(len(leaves))
+	if m < uint64(k) {
+		return append(merklePath(m, leaves[:k]), merkleRoot(leaves[k:]))
+	}
+	return append(merklePath(m-uint64(k), leaves[k:]), merkleRoot(leaves[:k]))
+}
@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerkleInclusion(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, trs := makeTestFeed(t, "dead", 13)
+
+	mt := NewMerkleTree()
+	for _, tr := range trs {
+		r.NoError(mt.Append(tr.Key()))
+	}
+
+	for size := uint64(1); size <= mt.Len(); size++ {
+		root, err := mt.RootAt(size)
+		r.NoError(err)
+
+		for seq := uint64(1); seq <= size; seq++ {
+			p, err := mt.InclusionProof(seq, size)
+			r.NoError(err)
+			a.True(p.Verify(trs[seq-1].Key(), root), "%d in %d", seq, size)
+
+			// the wrong message
+			other := trs[seq%uint64(len(trs))].Key()
+			a.False(p.Verify(other, root), "%d in %d with wrong key", seq, size)
+
+			// claiming a different position
+			if size > 1 {
+				moved := *p
+				moved.Sequence = seq%size + 1
+				a.False(moved.Verify(trs[seq-1].Key(), root), "%d moved in %d", seq, size)
+			}
+		}
+	}
+
+	_, err := mt.InclusionProof(14, 13)
+	a.Error(err)
+	_, err = mt.InclusionProof(0, 13)
+	a.Error(err)
+}
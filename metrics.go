@@ -0,0 +1,43 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"sync/atomic"
+)
+
+// Metric names an instrumented operation of this package
+type Metric string
+
+const (
+	MetricEncode Metric = "encode"
+	MetricDecode Metric = "decode"
+	MetricVerify Metric = "verify"
+)
+
+// MetricsSink receives a call for every encode, decode and verification.
+// It's easy to back by prometheus counters (i.e. a CounterVec labeled with the metric and outcome).
+// Implementations need to be safe for concurrent use.
+type MetricsSink interface {
+	// Count records one operation of type m, if it failed and how many bytes it processed
+	Count(m Metric, failed bool, bytes int)
+}
+
+type sinkHolder struct{ MetricsSink }
+
+var metricsSink atomic.Value
+
+// SetMetricsSink installs s as the receiver of all metrics of this package. Passing nil disables them again.
+func SetMetricsSink(s MetricsSink) {
+	metricsSink.Store(sinkHolder{s})
+}
+
+func countMetric(m Metric, failed bool, bytes int) {
+	h, ok := metricsSink.Load().(sinkHolder)
+	if !ok || h.MetricsSink == nil {
+		return
+	}
+	h.Count(m, failed, bytes)
+}
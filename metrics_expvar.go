@@ -0,0 +1,35 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+//go:build !tinygo
+// +build !tinygo
+
+package gabbygrove
+
+import (
+	"expvar"
+)
+
+// ExpvarSink publishes the metrics as an expvar map, with operations, failures and bytes per metric
+type ExpvarSink struct {
+	m *expvar.Map
+}
+
+// NewExpvarSink publishes a new map under name, which needs to be unique for the process (see expvar.Publish)
+func NewExpvarSink(name string) *ExpvarSink {
+	return &ExpvarSink{m: expvar.NewMap(name)}
+}
+
+func (es *ExpvarSink) Count(m Metric, failed bool, bytes int) {
+	es.m.Add(string(m), 1)
+	if failed {
+		es.m.Add(string(m)+"_failed", 1)
+	}
+	es.m.Add(string(m)+"_bytes", int64(bytes))
+}
+
+// Map gives access to the published counters
+func (es *ExpvarSink) Map() *expvar.Map {
+	return es.m
+}
@@ -0,0 +1,49 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+//go:build !tinygo
+// +build !tinygo
+
+package gabbygrove
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsSink(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	sink := NewExpvarSink("gabbygrove-test")
+	SetMetricsSink(sink)
+	defer SetMetricsSink(nil)
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	e := NewEncoder(privKey)
+	tr, _, err := e.Encode(1, BinaryRef{}, "hello")
+	r.NoError(err)
+	_, _, err = e.Encode(1, BinaryRef{}, bytes.Repeat([]byte("A"), 70000))
+	r.Error(err)
+
+	data, err := tr.MarshalCBOR()
+	r.NoError(err)
+	var got Transfer
+	r.NoError(got.UnmarshalCBOR(data))
+	r.Error(got.UnmarshalCBOR(data[:10]))
+
+	a.True(got.Verify(nil))
+
+	m := sink.Map()
+	a.Equal("2", m.Get("encode").String())
+	a.Equal("1", m.Get("encode_failed").String())
+	a.Equal("2", m.Get("decode").String())
+	a.Equal("1", m.Get("decode_failed").String())
+	a.Equal("1", m.Get("verify").String())
+	a.Nil(m.Get("verify_failed"))
+	a.NotEqual("0", m.Get("encode_bytes").String())
+}
@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ErrTimestampBackwards is returned by Encode with TimestampsReject for a timestamp before the one of the previous message
+var ErrTimestampBackwards = errors.New("gabbygrove: timestamp is earlier than the previous one")
+
+// TimestampOrder says what an encoder does with a timestamp that is earlier than the one of the previous message,
+// i.e. after the system clock was set back. The claimed times of a feed are not ordered by the format,
+// but some indexes assume they don't decrease.
+type TimestampOrder int
+
+const (
+	// TimestampsAny keeps timestamps as they are, it's the default
+	TimestampsAny TimestampOrder = iota
+
+	// TimestampsClamp uses the previous timestamp instead
+	TimestampsClamp
+
+	// TimestampsReject fails with ErrTimestampBackwards
+	TimestampsReject
+)
+
+// WithMonotonicTimestamps makes the encoder apply order to the timestamps of new messages.
+// The previous timestamp is the one of the last message the encoder signed, or of tip until then,
+// which should be the latest message of the feed when the encoder is created, i.e. after a restart.
+// A message that doesn't follow that one isn't checked, since its previous timestamp isn't known.
+// Neither are messages without timestamps, see WithNowTimestamps.
+func (e *Encoder) WithMonotonicTimestamps(order TimestampOrder, tip *Transfer) error {
+	e.timestampOrder = order
+	if tip == nil {
+		return nil
+	}
+	evt, err := tip.getEvent()
+	if err != nil {
+		return errors.Wrap(err, "gabbygrove: invalid tip")
+	}
+	e.mu.Lock()
+	e.lastSeq, e.lastTimestamp = evt.Sequence, int64(evt.Timestamp)
+	e.mu.Unlock()
+	return nil
+}
+
+// orderTimestamp applies the TimestampOrder to the timestamp of message sequence
+func (e *Encoder) orderTimestamp(sequence uint64, timestamp int64) (int64, error) {
+	if e.timestampOrder == TimestampsAny || e.deterministic || !e.setTimestamp {
+		return timestamp, nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch {
+	case e.lastSeq == 0, sequence != e.lastSeq+1, timestamp >= e.lastTimestamp:
+		return timestamp, nil
+	}
+	if e.timestampOrder == TimestampsClamp {
+		return e.lastTimestamp, nil
+	}
+	return 0, errors.Wrapf(ErrTimestampBackwards, "message %d: %d before %d", sequence, timestamp, e.lastTimestamp)
+}
+
+// signedTimestamp remembers the timestamp of the message the encoder just signed, if the order is applied at all
+func (e *Encoder) signedTimestamp(sequence uint64, timestamp int64) {
+	if e.timestampOrder == TimestampsAny {
+		return
+	}
+	e.mu.Lock()
+	e.lastSeq, e.lastTimestamp = sequence, timestamp
+	e.mu.Unlock()
+}
@@ -0,0 +1,111 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+func TestMonotonicTimestamps(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+
+	clock := time.Unix(1000, 0)
+	newEncoder := func() *Encoder {
+		e := NewEncoder(privKey)
+		e.WithClock(func() time.Time { return clock })
+		return e
+	}
+
+	// encode appends the next message of trs
+	encode := func(e *Encoder, trs []*Transfer) ([]*Transfer, error) {
+		seq, prev := uint64(1), BinaryRef{}
+		if n := len(trs); n > 0 {
+			var err error
+			prev, err = fromRef(trs[n-1].Key())
+			r.NoError(err)
+			seq = uint64(n + 1)
+		}
+		tr, _, err := e.Encode(seq, prev, map[string]interface{}{"type": "test", "i": seq})
+		if err != nil {
+			return trs, err
+		}
+		return append(trs, tr), nil
+	}
+
+	e := newEncoder()
+	r.NoError(e.WithMonotonicTimestamps(TimestampsClamp, nil))
+	trs, err := encode(e, nil)
+	r.NoError(err)
+
+	clock = time.Unix(900, 0)
+	trs, err = encode(e, trs)
+	r.NoError(err)
+	a.EqualValues(1000, trs[1].Claimed().Unix(), "not clamped")
+
+	p, err := e.Preview(3, BinaryRef{}, "preview")
+	r.NoError(err)
+	a.EqualValues(1000, p.Timestamp)
+
+	clock = time.Unix(1100, 0)
+	trs, err = encode(e, trs)
+	r.NoError(err)
+	a.EqualValues(1100, trs[2].Claimed().Unix())
+
+	// a new encoder continues from the tip
+	clock = time.Unix(1050, 0)
+	e = newEncoder()
+	r.NoError(e.WithMonotonicTimestamps(TimestampsReject, trs[2]))
+	_, err = encode(e, trs)
+	a.Equal(ErrTimestampBackwards, errors.Cause(err))
+
+	clock = time.Unix(1100, 0)
+	trs, err = encode(e, trs)
+	r.NoError(err, "equal timestamps are fine")
+
+	// without an order, the clock is taken as it is
+	r.NoError(e.WithMonotonicTimestamps(TimestampsAny, nil))
+	clock = time.Unix(10, 0)
+	trs, err = encode(e, trs)
+	r.NoError(err)
+	a.EqualValues(10, trs[4].Claimed().Unix())
+}
+
+func TestEncoderConcurrent(t *testing.T) {
+	r := require.New(t)
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	e := NewEncoder(privKey)
+	e.WithNowTimestamps(true)
+	r.NoError(e.WithMonotonicTimestamps(TimestampsClamp, nil))
+	e.WithKeyReuseCheck(func(refs.FeedRef) (bool, error) { return false, nil })
+
+	// go test -race complains if the state between the calls isn't guarded
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(seq uint64) {
+			defer wg.Done()
+			_, _, err := e.Encode(seq, BinaryRef{}, map[string]interface{}{"type": "test"})
+			errs <- err
+		}(uint64(i + 1))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		r.NoError(err)
+	}
+}
@@ -0,0 +1,164 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"math/big"
+	"strings"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// multihash code and digest length of sha2-256, see https://github.com/multiformats/multicodec
+const (
+	multihashSHA256    = 0x12
+	multihashSHA256Len = 32
+)
+
+// Multibase selects the string encoding of a multibase string by its prefix character
+type Multibase byte
+
+const (
+	MultibaseBase16    Multibase = 'f'
+	MultibaseBase32    Multibase = 'b'
+	MultibaseBase58BTC Multibase = 'z'
+	MultibaseBase64URL Multibase = 'u'
+)
+
+var base32Lower = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// Multihash returns the sha2-256 multihash of a content or message reference
+func Multihash(r refs.Ref) ([]byte, error) {
+	digest := make([]byte, multihashSHA256Len)
+	switch tr := r.(type) {
+	case ContentRef:
+		copy(digest, tr.hash[:])
+	case refs.MessageRef:
+		if err := tr.CopyHashTo(digest); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.Errorf("multihash: unsupported reference type: %T", r)
+	}
+	return append([]byte{multihashSHA256, multihashSHA256Len}, digest...), nil
+}
+
+// FormatMultibase returns the multihash of r as a multibase string, i.e. for tools indexing gabbygrove data next to IPFS
+func FormatMultibase(r refs.Ref, base Multibase) (string, error) {
+	mh, err := Multihash(r)
+	if err != nil {
+		return "", err
+	}
+	var enc string
+	switch base {
+	case MultibaseBase16:
+		enc = hex.EncodeToString(mh)
+	case MultibaseBase32:
+		enc = base32Lower.EncodeToString(mh)
+	case MultibaseBase58BTC:
+		enc = base58Encode(mh)
+	case MultibaseBase64URL:
+		enc = base64.RawURLEncoding.EncodeToString(mh)
+	default:
+		return "", errors.Errorf("multibase: unsupported encoding %q", byte(base))
+	}
+	return string(base) + enc, nil
+}
+
+// ParseMultibase decodes a multibase string holding a sha2-256 multihash into a gabbygrove reference of type t.
+// Since the multihash doesn't say what was hashed, the caller needs to know if it's a message or content reference.
+func ParseMultibase(s string, t RefType) (BinaryRef, error) {
+	if len(s) < 2 {
+		return BinaryRef{}, errors.Errorf("multibase: input too short")
+	}
+
+	var mh []byte
+	var err error
+	switch Multibase(s[0]) {
+	case MultibaseBase16:
+		mh, err = hex.DecodeString(strings.ToLower(s[1:]))
+	case MultibaseBase32:
+		mh, err = base32Lower.DecodeString(s[1:])
+	case MultibaseBase58BTC:
+		mh, err = base58Decode(s[1:])
+	case MultibaseBase64URL:
+		mh, err = base64.RawURLEncoding.DecodeString(s[1:])
+	default:
+		return BinaryRef{}, errors.Errorf("multibase: unsupported encoding %q", s[0])
+	}
+	if err != nil {
+		return BinaryRef{}, errors.Wrap(err, "multibase: invalid encoding")
+	}
+
+	if len(mh) != 2+multihashSHA256Len || mh[0] != multihashSHA256 || mh[1] != multihashSHA256Len {
+		return BinaryRef{}, errors.Errorf("multihash: not a sha2-256 digest")
+	}
+
+	switch t {
+	case RefTypeContent:
+		cr, err := NewContentRefFromBytes(mh[2:])
+		if err != nil {
+			return BinaryRef{}, err
+		}
+		return fromRef(cr)
+	case RefTypeMessage:
+		mr, err := refs.NewMessageRefFromBytes(mh[2:], refs.RefAlgoMessageGabby)
+		if err != nil {
+			return BinaryRef{}, err
+		}
+		return fromRef(mr)
+	default:
+		return BinaryRef{}, errors.Errorf("multihash: unsupported reference type %d", t)
+	}
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+func base58Encode(in []byte) string {
+	var zeros int
+	for zeros < len(in) && in[zeros] == 0 {
+		zeros++
+	}
+
+	n := new(big.Int).SetBytes(in)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for i := 0; i < zeros; i++ {
+		out = append(out, base58Alphabet[0])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+func base58Decode(in string) ([]byte, error) {
+	var zeros int
+	for zeros < len(in) && in[zeros] == base58Alphabet[0] {
+		zeros++
+	}
+
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for _, c := range []byte(in[zeros:]) {
+		idx := strings.IndexByte(base58Alphabet, c)
+		if idx < 0 {
+			return nil, errors.Errorf("base58: invalid character %q", c)
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(idx)))
+	}
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
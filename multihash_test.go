@@ -0,0 +1,58 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+func TestMultibase(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	// sha256("hello") as a known multihash
+	cr, err := NewContentRefFromBytes([]byte{
+		0x2c, 0xf2, 0x4d, 0xba, 0x5f, 0xb0, 0xa3, 0x0e, 0x26, 0xe8, 0x3b, 0x2a, 0xc5, 0xb9, 0xe2, 0x9e,
+		0x1b, 0x16, 0x1e, 0x5c, 0x1f, 0xa7, 0x42, 0x5e, 0x73, 0x04, 0x33, 0x62, 0x93, 0x8b, 0x98, 0x24,
+	})
+	r.NoError(err)
+
+	known := map[Multibase]string{
+		MultibaseBase16:    "f12202cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		MultibaseBase58BTC: "zQmRN6wdp1S2A5EtjW9A3M1vKSBuQQGcgvuhoMUoEz4iiT5",
+	}
+	for base, want := range known {
+		got, err := FormatMultibase(cr, base)
+		r.NoError(err)
+		a.Equal(want, got)
+	}
+
+	mr, err := refs.NewMessageRefFromBytes(bytes.Repeat([]byte("b4ut"), 8), refs.RefAlgoMessageGabby)
+	r.NoError(err)
+
+	for _, base := range []Multibase{MultibaseBase16, MultibaseBase32, MultibaseBase58BTC, MultibaseBase64URL} {
+		s, err := FormatMultibase(cr, base)
+		r.NoError(err)
+		br, err := ParseMultibase(s, RefTypeContent)
+		r.NoError(err, "base %c", base)
+		a.Equal(cr.URI(), br.URI())
+
+		s, err = FormatMultibase(mr, base)
+		r.NoError(err)
+		br, err = ParseMultibase(s, RefTypeMessage)
+		r.NoError(err, "base %c", base)
+		a.Equal(mr.URI(), br.URI())
+	}
+
+	_, err = ParseMultibase("x1234", RefTypeContent)
+	a.Error(err)
+	_, err = ParseMultibase("f1114"+known[MultibaseBase16][5:], RefTypeContent)
+	a.Error(err, "sha1 multihash")
+}
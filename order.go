@@ -0,0 +1,182 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"container/heap"
+	"io"
+	"sort"
+
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// TransferOrder returns true if a comes before b
+type TransferOrder func(a, b *Transfer) bool
+
+// Transfers that fail to decode have no author, sequence or claimed time and sort first in all of these.
+var (
+	// ByAuthorSequence orders by the public key of the author and then by sequence, so every feed is in one piece
+	ByAuthorSequence TransferOrder = func(a, b *Transfer) bool {
+		ka, kb := orderKeyOf(a), orderKeyOf(b)
+		return ka.feedLess(kb)
+	}
+
+	// ByClaimed orders by the timestamp in the event, which is what the author claims.
+	// Messages with the same timestamp are ordered by author and sequence.
+	ByClaimed TransferOrder = func(a, b *Transfer) bool {
+		ka, kb := orderKeyOf(a), orderKeyOf(b)
+		if ka.claimed != kb.claimed {
+			return ka.claimed < kb.claimed
+		}
+		return ka.feedLess(kb)
+	}
+
+	// ByReceived orders by the local received time and falls back to the claimed time for messages without one, like Received.
+	// Messages that arrived at the same time are ordered by author and sequence.
+	ByReceived TransferOrder = func(a, b *Transfer) bool {
+		ta, tb := receivedOrClaimed(a), receivedOrClaimed(b)
+		if ta != tb {
+			return ta < tb
+		}
+		return orderKeyOf(a).feedLess(orderKeyOf(b))
+	}
+)
+
+// orderKey are the fields the orders look at
+type orderKey struct {
+	author  []byte
+	seq     uint64
+	claimed int64 // in seconds
+}
+
+func orderKeyOf(tr *Transfer) orderKey {
+	evt, err := tr.getEvent()
+	if err != nil {
+		return orderKey{}
+	}
+	var k orderKey
+	if fr, ok := evt.Author.r.(refs.FeedRef); ok {
+		k.author = fr.PubKey()
+	}
+	k.seq = evt.Sequence
+	k.claimed = evt.Timestamp
+	return k
+}
+
+func (k orderKey) feedLess(o orderKey) bool {
+	if c := bytes.Compare(k.author, o.author); c != 0 {
+		return c < 0
+	}
+	return k.seq < o.seq
+}
+
+// receivedOrClaimed returns the received time in nanoseconds, without the logging of Received
+func receivedOrClaimed(tr *Transfer) int64 {
+	if rcv, has := tr.ReceivedAt(); has {
+		return rcv.UnixNano()
+	}
+	return orderKeyOf(tr).claimed * 1e9
+}
+
+// SortTransfers sorts trs by less. The sort is stable, so equal transfers keep their order.
+func SortTransfers(trs []*Transfer, less TransferOrder) {
+	sort.SliceStable(trs, func(i, j int) bool { return less(trs[i], trs[j]) })
+}
+
+// TransferStream yields transfers one after the other and io.EOF after the last one
+type TransferStream interface {
+	Next() (*Transfer, error)
+}
+
+// TransferStreamFunc adapts a plain function to a TransferStream
+type TransferStreamFunc func() (*Transfer, error)
+
+// Next calls fn()
+func (fn TransferStreamFunc) Next() (*Transfer, error) {
+	return fn()
+}
+
+// SliceStream returns a stream of trs
+func SliceStream(trs []*Transfer) TransferStream {
+	return TransferStreamFunc(func() (*Transfer, error) {
+		if len(trs) == 0 {
+			return nil, io.EOF
+		}
+		tr := trs[0]
+		trs = trs[1:]
+		return tr, nil
+	})
+}
+
+// MergeStreams combines streams that are each ordered by less into one stream in that order, i.e. many feeds into one timeline.
+// It only holds the next transfer of every stream. The first error of a stream (other than io.EOF) ends the merged one.
+func MergeStreams(less TransferOrder, streams ...TransferStream) TransferStream {
+	return &mergedStream{h: transferHeap{less: less}, pending: streams}
+}
+
+type mergedStream struct {
+	h transferHeap
+
+	// pending streams haven't been read from yet, they are started on the first call to Next
+	pending []TransferStream
+	err     error
+}
+
+func (m *mergedStream) Next() (*Transfer, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	for _, s := range m.pending {
+		if err := m.pull(s); err != nil {
+			return nil, err
+		}
+	}
+	m.pending = nil
+
+	if m.h.Len() == 0 {
+		m.err = io.EOF
+		return nil, io.EOF
+	}
+	head := heap.Pop(&m.h).(heapItem)
+	if err := m.pull(head.from); err != nil {
+		return nil, err
+	}
+	return head.tr, nil
+}
+
+// pull puts the next transfer of s on the heap
+func (m *mergedStream) pull(s TransferStream) error {
+	tr, err := s.Next()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		m.err = err
+		return err
+	}
+	heap.Push(&m.h, heapItem{tr: tr, from: s})
+	return nil
+}
+
+type heapItem struct {
+	tr   *Transfer
+	from TransferStream
+}
+
+type transferHeap struct {
+	items []heapItem
+	less  TransferOrder
+}
+
+func (h transferHeap) Len() int            { return len(h.items) }
+func (h transferHeap) Less(i, j int) bool  { return h.less(h.items[i].tr, h.items[j].tr) }
+func (h transferHeap) Swap(i, j int)       { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *transferHeap) Push(x interface{}) { h.items = append(h.items, x.(heapItem)) }
+func (h *transferHeap) Pop() interface{} {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
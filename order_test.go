@@ -0,0 +1,126 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// makeTimedFeed creates n messages claimed at the passed unix timestamps
+func makeTimedFeed(t *testing.T, seed string, stamps ...int64) []*Transfer {
+	r := require.New(t)
+
+	pubKey, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte(seed), 32/len(seed))))
+	author, err := refs.NewFeedRefFromBytes(pubKey, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+
+	e := NewEncoder(privKey)
+	state := NewFeedState(author)
+	var trs []*Transfer
+	for _, ts := range stamps {
+		ts := ts
+		e.WithClock(func() time.Time { return time.Unix(ts, 0) })
+		seq, prev := state.Next()
+		tr, _, err := e.Encode(seq, prev, map[string]interface{}{"type": "test"})
+		r.NoError(err)
+		r.NoError(state.Append(tr))
+		trs = append(trs, tr)
+	}
+	return trs
+}
+
+func claimedOf(trs []*Transfer) []int64 {
+	var ts []int64
+	for _, tr := range trs {
+		ts = append(ts, tr.Claimed().Unix())
+	}
+	return ts
+}
+
+func drain(t *testing.T, s TransferStream) []*Transfer {
+	var trs []*Transfer
+	for {
+		tr, err := s.Next()
+		if err == io.EOF {
+			return trs
+		}
+		require.NoError(t, err)
+		trs = append(trs, tr)
+	}
+}
+
+func TestSortTransfers(t *testing.T) {
+	a := assert.New(t)
+
+	alice := makeTimedFeed(t, "dead", 10, 20, 30)
+	bob := makeTimedFeed(t, "beef", 15, 20, 40)
+
+	all := append(append([]*Transfer{}, alice...), bob...)
+	rand.New(rand.NewSource(1)).Shuffle(len(all), func(i, j int) { all[i], all[j] = all[j], all[i] })
+
+	SortTransfers(all, ByClaimed)
+	a.Equal([]int64{10, 15, 20, 20, 30, 40}, claimedOf(all))
+
+	SortTransfers(all, ByAuthorSequence)
+	first, second := alice, bob
+	if bytes.Compare(bob[0].Author().PubKey(), alice[0].Author().PubKey()) < 0 {
+		first, second = bob, alice
+	}
+	a.Equal(append(append([]*Transfer{}, first...), second...), all)
+
+	// received times win over claimed ones
+	bob[2].SetReceived(time.Unix(5, 0))
+	alice[0].SetReceived(time.Unix(50, 0))
+	SortTransfers(all, ByReceived)
+	a.Equal(bob[2], all[0])
+	a.Equal(alice[0], all[len(all)-1])
+	a.Equal([]int64{15, 20, 20, 30}, claimedOf(all[1:5]))
+
+	broken := &Transfer{Event: []byte{0xff}}
+	withBroken := []*Transfer{alice[1], broken}
+	SortTransfers(withBroken, ByClaimed)
+	a.Equal(broken, withBroken[0])
+}
+
+func TestMergeStreams(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	alice := makeTimedFeed(t, "dead", 10, 20, 30)
+	bob := makeTimedFeed(t, "beef", 15, 20, 40)
+	carl := makeTimedFeed(t, "acab", 1)
+
+	merged := drain(t, MergeStreams(ByClaimed, SliceStream(alice), SliceStream(bob), SliceStream(nil), SliceStream(carl)))
+	a.Equal([]int64{1, 10, 15, 20, 20, 30, 40}, claimedOf(merged))
+
+	a.Len(drain(t, MergeStreams(ByClaimed)), 0)
+
+	// errors end the stream
+	failing := errors.New("broken stream")
+	calls := 0
+	s := MergeStreams(ByClaimed, SliceStream(alice), TransferStreamFunc(func() (*Transfer, error) {
+		calls++
+		if calls > 1 {
+			return nil, failing
+		}
+		return bob[0], nil
+	}))
+	tr, err := s.Next()
+	r.NoError(err)
+	a.Equal(alice[0], tr)
+	_, err = s.Next()
+	a.Equal(failing, err, "pulling the next of bob")
+	_, err = s.Next()
+	a.Equal(failing, err)
+}
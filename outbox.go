@@ -0,0 +1,303 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// OutboxStore persists encoded transfers until they are synced
+type OutboxStore interface {
+	// Put stores the encoded transfer of sequence seq
+	Put(seq uint64, data []byte) error
+
+	// Get returns the encoded transfer of sequence seq
+	Get(seq uint64) ([]byte, error)
+
+	// Remove drops a transfer once it was delivered
+	Remove(seq uint64) error
+
+	// Pending lists the sequences that are still stored, in any order
+	Pending() ([]uint64, error)
+}
+
+// Outbox publishes messages while offline and hands them out for syncing later.
+// New messages are chained onto a local FeedState and kept in the OutboxStore until Replay delivered them.
+type Outbox struct {
+	mu sync.Mutex
+
+	enc   *Encoder
+	state *FeedState
+	store OutboxStore
+
+	seqStore SequenceStore
+}
+
+// NewOutbox continues the feed described by state using enc to sign new messages
+func NewOutbox(enc *Encoder, state *FeedState, store OutboxStore) *Outbox {
+	return &Outbox{
+		enc:   enc,
+		state: state,
+		store: store,
+	}
+}
+
+// OpenOutbox continues author's feed from the tip committed to seqStore.
+// Every published message is committed to seqStore after it was persisted in store.
+// Pending transfers newer then the committed tip (from a crash between the two) are applied and committed,
+// so the feed continues after them instead of forking.
+// If seqStore is a FeedStateStore, a feed that ended with a tombstone or revocation stays so.
+func OpenOutbox(enc *Encoder, author refs.FeedRef, seqStore SequenceStore, store OutboxStore) (*Outbox, error) {
+	state, err := LoadFeedState(author, seqStore)
+	if err != nil {
+		return nil, err
+	}
+
+	seqs, err := store.Pending()
+	if err != nil {
+		return nil, errors.Wrap(err, "outbox: failed to list pending")
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	for _, seq := range seqs {
+		if seq <= state.Sequence {
+			continue
+		}
+		data, err := store.Get(seq)
+		if err != nil {
+			return nil, errors.Wrapf(err, "outbox: failed to load %d", seq)
+		}
+		// keeps unknown fields, i.e. the second signature of hybrid transfers
+		var tr Transfer
+		if err := tr.UnmarshalCBOR(data); err != nil {
+			return nil, errors.Wrapf(err, "outbox: failed to unmarshal %d", seq)
+		}
+		if err := state.Append(&tr); err != nil {
+			return nil, errors.Wrapf(err, "outbox: pending %d does not fit the committed tip", seq)
+		}
+		if err := commitState(seqStore, *state); err != nil {
+			return nil, errors.Wrap(err, "outbox: failed to commit recovered tip")
+		}
+	}
+
+	ob := NewOutbox(enc, state, store)
+	ob.seqStore = seqStore
+	return ob, nil
+}
+
+// State returns a copy of the current local state of the feed
+func (o *Outbox) State() FeedState {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return *o.state
+}
+
+// Publish encodes val as the next message of the feed and persists it before advancing the state.
+func (o *Outbox) Publish(val interface{}) (*Transfer, refs.MessageRef, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	switch {
+	case o.state.Terminated:
+		return nil, refs.MessageRef{}, errors.Wrap(ErrFeedTerminated, "outbox")
+	case o.state.Revoked != nil && !o.state.acceptRevoked:
+		return nil, refs.MessageRef{}, errors.Wrap(ErrKeyRevoked, "outbox")
+	}
+	seq, prev := o.state.Next()
+	tr, key, err := o.enc.Encode(seq, prev, val)
+	if err != nil {
+		return nil, refs.MessageRef{}, errors.Wrap(err, "outbox: failed to encode")
+	}
+
+	// the encoder found this content in its lookup and returned the earlier message
+	if uint64(tr.Seq()) != seq {
+		return tr, key, nil
+	}
+
+	end, err := o.state.check(tr)
+	if err != nil {
+		return nil, refs.MessageRef{}, errors.Wrap(err, "outbox: encoded message does not fit the feed")
+	}
+
+	data, err := tr.MarshalCBOR()
+	if err != nil {
+		return nil, refs.MessageRef{}, errors.Wrap(err, "outbox: failed to marshal")
+	}
+	if err := o.store.Put(seq, data); err != nil {
+		return nil, refs.MessageRef{}, errors.Wrap(err, "outbox: failed to persist")
+	}
+	if o.seqStore != nil {
+		committed := FeedState{Sequence: seq, Tip: &key, Terminated: end.terminated, Revoked: end.revoked}
+		if err := commitState(o.seqStore, committed); err != nil {
+			return nil, refs.MessageRef{}, errors.Wrap(err, "outbox: failed to commit sequence")
+		}
+	}
+
+	o.state.advance(key, end)
+	return tr, key, nil
+}
+
+// Pending returns the number of transfers that were not delivered yet
+func (o *Outbox) Pending() (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	seqs, err := o.store.Pending()
+	return len(seqs), err
+}
+
+// Replay passes all pending transfers in sequence order to send.
+// Each transfer is removed from the store once send returns nil.
+// Replay stops at the first error, so the remaining transfers are retried in order on the next call.
+func (o *Outbox) Replay(send func(*Transfer) error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	seqs, err := o.store.Pending()
+	if err != nil {
+		return errors.Wrap(err, "outbox: failed to list pending")
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	for _, seq := range seqs {
+		data, err := o.store.Get(seq)
+		if err != nil {
+			return errors.Wrapf(err, "outbox: failed to load %d", seq)
+		}
+		var tr Transfer
+		if err := tr.UnmarshalCBOR(data); err != nil {
+			return errors.Wrapf(err, "outbox: failed to unmarshal %d", seq)
+		}
+		if err := send(&tr); err != nil {
+			return err
+		}
+		if err := o.store.Remove(seq); err != nil {
+			return errors.Wrapf(err, "outbox: failed to remove %d", seq)
+		}
+	}
+	return nil
+}
+
+// NewMemOutboxStore keeps the pending transfers in memory, mostly useful for testing
+func NewMemOutboxStore() OutboxStore {
+	return &memOutbox{m: make(map[uint64][]byte)}
+}
+
+type memOutbox struct {
+	mu sync.Mutex
+	m  map[uint64][]byte
+}
+
+func (mo *memOutbox) Put(seq uint64, data []byte) error {
+	mo.mu.Lock()
+	defer mo.mu.Unlock()
+	mo.m[seq] = append([]byte{}, data...)
+	return nil
+}
+
+func (mo *memOutbox) Get(seq uint64) ([]byte, error) {
+	mo.mu.Lock()
+	defer mo.mu.Unlock()
+	data, has := mo.m[seq]
+	if !has {
+		return nil, errors.Errorf("outbox: no such sequence: %d", seq)
+	}
+	return data, nil
+}
+
+func (mo *memOutbox) Remove(seq uint64) error {
+	mo.mu.Lock()
+	defer mo.mu.Unlock()
+	delete(mo.m, seq)
+	return nil
+}
+
+func (mo *memOutbox) Pending() ([]uint64, error) {
+	mo.mu.Lock()
+	defer mo.mu.Unlock()
+	seqs := make([]uint64, 0, len(mo.m))
+	for seq := range mo.m {
+		seqs = append(seqs, seq)
+	}
+	return seqs, nil
+}
+
+// NewDirOutboxStore keeps each pending transfer as a file in dir
+func NewDirOutboxStore(dir string) (OutboxStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "outbox: failed to create directory")
+	}
+	return dirOutbox(dir), nil
+}
+
+type dirOutbox string
+
+const dirOutboxSuffix = ".transfer"
+
+func (do dirOutbox) name(seq uint64) string {
+	return filepath.Join(string(do), fmt.Sprintf("%020d%s", seq, dirOutboxSuffix))
+}
+
+func (do dirOutbox) Put(seq uint64, data []byte) error {
+	tmp, err := ioutil.TempFile(string(do), "pending-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), do.name(seq))
+}
+
+func (do dirOutbox) Get(seq uint64) ([]byte, error) {
+	return ioutil.ReadFile(do.name(seq))
+}
+
+func (do dirOutbox) Remove(seq uint64) error {
+	err := os.Remove(do.name(seq))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (do dirOutbox) Pending() ([]uint64, error) {
+	infos, err := ioutil.ReadDir(string(do))
+	if err != nil {
+		return nil, err
+	}
+	var seqs []uint64
+	for _, fi := range infos {
+		name := fi.Name()
+		if !strings.HasSuffix(name, dirOutboxSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, dirOutboxSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	return seqs, nil
+}
@@ -0,0 +1,70 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+func TestOutbox(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	pubKey, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	author, err := refs.NewFeedRefFromBytes(pubKey, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+
+	store, err := NewDirOutboxStore(dir)
+	r.NoError(err)
+
+	ob := NewOutbox(NewEncoder(privKey), NewFeedState(author), store)
+	for i := 0; i < 5; i++ {
+		tr, _, err := ob.Publish(map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+		a.EqualValues(i+1, tr.Seq())
+	}
+	n, err := ob.Pending()
+	r.NoError(err)
+	a.Equal(5, n)
+
+	// the remote goes away after the second message
+	remote := NewFeedState(author)
+	errOffline := errors.New("offline")
+	err = ob.Replay(func(tr *Transfer) error {
+		if remote.Sequence == 2 {
+			return errOffline
+		}
+		return remote.Append(tr)
+	})
+	a.Equal(errOffline, err)
+
+	n, err = ob.Pending()
+	r.NoError(err)
+	a.Equal(3, n)
+
+	// a new outbox over the same directory picks up where we left off
+	store2, err := NewDirOutboxStore(dir)
+	r.NoError(err)
+	ob2 := NewOutbox(NewEncoder(privKey), NewFeedState(author), store2)
+	r.NoError(ob2.Replay(remote.Append))
+	a.EqualValues(5, remote.Sequence)
+	a.Equal(*ob.State().Tip, *remote.Tip)
+
+	n, err = ob2.Pending()
+	r.NoError(err)
+	a.Equal(0, n)
+}
@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+// ContentPolicy restricts which content a node keeps, i.e. only JSON messages of certain types.
+// Events are still needed to continue the chain of a feed, so the Validator doesn't reject
+// messages outside of the policy but drops their content, like a remote that deleted it.
+type ContentPolicy struct {
+	// Types are the allowed content types, all are allowed if it's empty
+	Types []ContentType
+
+	// JSONTypes are the allowed values of the "type" field of JSON content, all are allowed if it's empty.
+	// Setting it implies JSON content.
+	JSONTypes []string
+
+	// None drops all content, for nodes that only keep the events. The other fields are ignored then.
+	None bool
+}
+
+// Allowed checks the content of tr against the policy.
+// The content type is taken from the event; the JSON type is only checked if the content is present.
+func (p ContentPolicy) Allowed(tr *Transfer) bool {
+	if p.None {
+		return false
+	}
+	evt, err := tr.getEvent()
+	if err != nil {
+		return false
+	}
+
+	if len(p.Types) > 0 {
+		var found bool
+		for _, t := range p.Types {
+			if t == evt.Content.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(p.JSONTypes) == 0 {
+		return true
+	}
+	if evt.Content.Type != ContentTypeJSON {
+		return false
+	}
+	if len(tr.Content) == 0 {
+		return true
+	}
+	typ, err := jsonTypeField(tr.Content)
+	if err != nil {
+		return false
+	}
+	for _, t := range p.JSONTypes {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// WithContentPolicy makes the validator drop the content of messages p doesn't allow
+func (v *Validator) WithContentPolicy(p *ContentPolicy) {
+	v.policy = p
+}
+
+// applyPolicy returns tr or a copy of it without content.
+// Revocations and tombstones keep their content, a feed can't be ended without it.
+func (v *Validator) applyPolicy(tr *Transfer) *Transfer {
+	if len(tr.Content) == 0 || hasJSONType(tr, RevocationType) || hasJSONType(tr, TombstoneType) {
+		return tr
+	}
+	if err := checkJSONContent(v.jsonPolicy, tr); err != nil {
+		if debugEnabled() {
+			debugLog("event", "validate", "msg", tr.Key().URI(), "err", err)
+		}
+	} else if v.policy == nil || v.policy.Allowed(tr) {
+		return tr
+	}
+	stripped := *tr
+	stripped.Content = nil
+	return &stripped
+}
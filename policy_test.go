@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+func TestContentPolicy(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	pubKey, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	author, err := refs.NewFeedRefFromBytes(pubKey, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+	e := NewEncoder(privKey)
+	state := NewFeedState(author)
+
+	var trs []*Transfer
+	for _, val := range []interface{}{
+		map[string]interface{}{"type": "gathering"},
+		map[string]interface{}{"type": "post", "text": "hi"},
+		[]byte("binary"),
+		map[string]interface{}{"type": "gathering", "title": "party"},
+	} {
+		seq, prev := state.Next()
+		tr, _, err := e.Encode(seq, prev, val)
+		r.NoError(err)
+		r.NoError(state.Append(tr))
+		trs = append(trs, tr)
+	}
+
+	p := ContentPolicy{JSONTypes: []string{"gathering"}}
+	a.True(p.Allowed(trs[0]))
+	a.False(p.Allowed(trs[1]))
+	a.False(p.Allowed(trs[2]))
+
+	onlyJSON := ContentPolicy{Types: []ContentType{ContentTypeJSON}}
+	a.True(onlyJSON.Allowed(trs[1]))
+	a.False(onlyJSON.Allowed(trs[2]))
+
+	none := ContentPolicy{None: true, Types: []ContentType{ContentTypeJSON}}
+	a.False(none.Allowed(trs[0]))
+
+	v := NewValidator(0)
+	v.WithContentPolicy(&p)
+
+	var kept int
+	for _, tr := range trs {
+		appended, err := v.Append(tr)
+		r.NoError(err, "the chain continues")
+		r.Len(appended, 1)
+		if len(appended[0].Content) > 0 {
+			kept++
+		}
+		a.Equal(tr.Key(), appended[0].Key())
+	}
+	a.Equal(2, kept)
+	a.EqualValues(4, v.Tip(author).Sequence)
+	a.NotNil(trs[1].Content, "passed transfers are not modified")
+}
@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+)
+
+// Preview describes the message Encode would produce
+type Preview struct {
+	Sequence  uint64
+	Timestamp int64
+
+	EventSize    int
+	ContentSize  int
+	TransferSize int // the encoded size of the whole transfer object
+
+	enc  *Encoder
+	prev BinaryRef
+	val  interface{}
+}
+
+// Preview computes the sizes of the message Encode would produce, without signing it or creating the transfer.
+// The key covers the signature, so it's only known after Encode. Nothing is committed to a SequenceStore,
+// but like Encode, it fails for messages that don't continue the tip, see WithSequenceStore.
+// The content lookup for deduplication is skipped, as is the second signature of the hybrid mode.
+func (e *Encoder) Preview(sequence uint64, prev BinaryRef, val interface{}) (*Preview, error) {
+	if err := e.checkTip(sequence, prev); err != nil {
+		return nil, err
+	}
+	ts, err := e.orderTimestamp(sequence, e.timestamp())
+	if err != nil {
+		return nil, err
+	}
+
+	ctype, contentBytes, cr, err := encodeContent(val)
+	if err != nil {
+		return nil, err
+	}
+	if e.jsonPolicy != nil && ctype == ContentTypeJSON {
+		if err := e.jsonPolicy.Check(contentBytes); err != nil {
+			return nil, err
+		}
+	}
+
+	evtBytes, err := e.eventBytes(sequence, prev, ts, ctype, len(contentBytes), cr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Preview{
+		Sequence:  sequence,
+		Timestamp: ts,
+
+		EventSize:   len(evtBytes),
+		ContentSize: len(contentBytes),
+
+		enc:  e,
+		prev: prev,
+		val:  val,
+	}
+	// the signature always has the same length, so a placeholder stands in for it
+	p.TransferSize = 1 + cborBytesLen(len(evtBytes)) + cborBytesLen(ed25519.SignatureSize) + cborBytesLen(len(contentBytes))
+	return p, nil
+}
+
+// Encode creates the previewed message, using the same timestamp.
+// The sizes only match the preview if val wasn't changed in the meantime.
+func (p *Preview) Encode() (*Transfer, refs.MessageRef, error) {
+	return p.enc.encode(p.Sequence, p.prev, p.val, p.Timestamp)
+}
+
+// cborBytesLen returns the encoded size of a byte string of length n
+func cborBytesLen(n int) int {
+	switch {
+	case n < 24:
+		return 1 + n
+	case n <= 0xff:
+		return 2 + n
+	case n <= 0xffff:
+		return 3 + n
+	default:
+		return 5 + n
+	}
+}
@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreview(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+
+	startTime = time.Date(1969, 12, 31, 23, 59, 55, 0, time.UTC).Unix()
+	now = fakeNow
+
+	e := NewEncoder(privKey)
+	e.WithNowTimestamps(true)
+
+	for _, content := range []interface{}{
+		map[string]interface{}{"type": "test"},
+		bytes.Repeat([]byte("X"), 300),
+	} {
+		ss := NewMemSequenceStore()
+		e.WithSequenceStore(ss)
+
+		p, err := e.Preview(1, BinaryRef{}, content)
+		r.NoError(err)
+
+		seq, _, err := ss.Load()
+		r.NoError(err)
+		a.Zero(seq, "preview committed")
+
+		tr, key, err := p.Encode()
+		r.NoError(err)
+		a.Equal(p.Sequence, uint64(tr.Seq()))
+		a.NotZero(key)
+		a.Equal(p.Timestamp, tr.Claimed().Unix())
+
+		full, err := tr.MarshalCBOR()
+		r.NoError(err)
+		a.Equal(len(full), p.TransferSize)
+		a.Equal(len(tr.Event), p.EventSize)
+		a.Equal(len(tr.Content), p.ContentSize)
+	}
+}
+
+// countingSigner counts its signatures
+type countingSigner struct {
+	privateKeySigner
+	calls int
+}
+
+func (cs *countingSigner) Sign(msg []byte) ([]byte, error) {
+	cs.calls++
+	return cs.privateKeySigner.Sign(msg)
+}
+
+func TestPreviewWithoutSigning(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	cs := &countingSigner{privateKeySigner: privateKeySigner(privKey)}
+	e := NewSignerEncoder(cs)
+
+	_, err := e.Preview(1, BinaryRef{}, "hello")
+	r.NoError(err)
+	a.Zero(cs.calls, "preview signed")
+
+	// it fails like Encode for messages that don't continue the tip
+	e.WithSequenceStore(NewMemSequenceStore())
+	_, err = e.Preview(2, BinaryRef{}, "too early")
+	a.Equal(ErrWrongSequence, errors.Cause(err))
+	_, _, err = e.Encode(2, BinaryRef{}, "too early")
+	a.Equal(ErrWrongSequence, errors.Cause(err))
+	a.Zero(cs.calls)
+}
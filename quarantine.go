@@ -0,0 +1,155 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"sync"
+
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// Quarantine hashes content in the background, so ingest isn't held up by hashing large content.
+// The messages it holds were accepted on their event and signature, their content isn't trusted until it was hashed.
+// See Validator.WithQuarantine.
+type Quarantine struct {
+	queue chan *Transfer
+
+	verified func(*Transfer)
+	rejected func(*Transfer, error)
+
+	mu     sync.Mutex
+	idle   *sync.Cond
+	held   map[string]int
+	closed bool
+
+	// adding are the Add calls that are sending to the queue, Close waits for them before closing it
+	adding  sync.WaitGroup
+	workers sync.WaitGroup
+}
+
+// NewQuarantine starts workers goroutines that hash content, up to queue messages wait for them before Add blocks
+func NewQuarantine(workers, queue int) *Quarantine {
+	if workers < 1 {
+		workers = 1
+	}
+	if queue < 0 {
+		queue = 0
+	}
+	q := &Quarantine{
+		queue: make(chan *Transfer, queue),
+		held:  make(map[string]int),
+	}
+	q.idle = sync.NewCond(&q.mu)
+	q.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+// WithVerified calls fn with every message whose content matched, once it left the quarantine.
+// It needs to be set before the first message is added, fn is called from the workers.
+func (q *Quarantine) WithVerified(fn func(*Transfer)) {
+	q.verified = fn
+}
+
+// WithRejected calls fn with every message whose content didn't match, the error is ErrContentHash.
+// Its content should be dropped, i.e. with store.Store.DeleteContent, the message stays quarantined until fn returns.
+// The event is still valid.
+// It needs to be set before the first message is added, fn is called from the workers.
+func (q *Quarantine) WithRejected(fn func(*Transfer, error)) {
+	q.rejected = fn
+}
+
+// Add quarantines tr until its content is hashed. It blocks while the queue is full.
+// After Close the content is hashed right away instead.
+func (q *Quarantine) Add(tr *Transfer) {
+	key := tr.Key().String()
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		q.check(tr)
+		return
+	}
+	q.held[key]++
+	q.adding.Add(1)
+	q.mu.Unlock()
+	q.queue <- tr
+	q.adding.Done()
+}
+
+// Quarantined returns true while the content of the message with key isn't hashed yet
+func (q *Quarantine) Quarantined(key refs.MessageRef) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.held[key.String()] > 0
+}
+
+// Len returns how many messages are quarantined
+func (q *Quarantine) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.held)
+}
+
+// Wait blocks until no message is quarantined anymore
+func (q *Quarantine) Wait() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.held) > 0 {
+		q.idle.Wait()
+	}
+}
+
+// Close hashes the content that is still queued and stops the workers
+func (q *Quarantine) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	q.mu.Unlock()
+	q.adding.Wait()
+	close(q.queue)
+	q.workers.Wait()
+}
+
+func (q *Quarantine) work() {
+	defer q.workers.Done()
+	for tr := range q.queue {
+		q.check(tr)
+	}
+}
+
+// check hashes the content of tr, releases it and reports the outcome
+func (q *Quarantine) check(tr *Transfer) {
+	if !tr.ContentMatches(tr.Content) {
+		if debugEnabled() {
+			debugLog("event", "quarantine", "msg", tr.Key().URI(), "err", ErrContentHash)
+		}
+		if q.rejected != nil {
+			q.rejected(tr, ErrContentHash)
+		}
+		q.release(tr)
+		return
+	}
+	q.release(tr)
+	if q.verified != nil {
+		q.verified(tr)
+	}
+}
+
+func (q *Quarantine) release(tr *Transfer) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	key := tr.Key().String()
+	if q.held[key]--; q.held[key] <= 0 {
+		delete(q.held, key)
+	}
+	if len(q.held) == 0 {
+		q.idle.Broadcast()
+	}
+}
@@ -0,0 +1,111 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tampered returns a copy of tr with content that has the right size but not the right hash
+func tampered(tr *Transfer) *Transfer {
+	cpy := *tr
+	cpy.Content = append([]byte{}, tr.Content...)
+	// a letter of the type, so the JSON stays valid and only the hash tells
+	cpy.Content[len(cpy.Content)-4] ^= 1
+	return &cpy
+}
+
+func TestValidatorContentHashing(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, trs := makeTestFeed(t, "hash", 3)
+	trs[1] = tampered(trs[1])
+
+	v := NewValidator(0)
+	for i, tr := range trs {
+		appended, err := v.Append(tr)
+		r.NoError(err)
+		r.Len(appended, 1)
+		a.NotNil(appended[0].Content, "not hashed by default: %d", i)
+	}
+
+	v = NewValidator(0)
+	v.WithContentHashing(true)
+	for i, tr := range trs {
+		appended, err := v.Append(tr)
+		r.NoError(err, "the event is valid")
+		r.Len(appended, 1)
+		a.Equal(i != 1, appended[0].HasContent(), "content of %d", i)
+	}
+	a.NotNil(trs[1].Content, "doesn't change the passed message")
+}
+
+func TestValidatorQuarantine(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, trs := makeTestFeed(t, "quar", 6)
+	trs[2] = tampered(trs[2])
+
+	q := NewQuarantine(2, 1)
+	var (
+		mu       sync.Mutex
+		verified []int64
+		rejected []int64
+		block    = make(chan struct{})
+	)
+	q.WithVerified(func(tr *Transfer) {
+		<-block
+		mu.Lock()
+		verified = append(verified, tr.Seq())
+		mu.Unlock()
+	})
+	q.WithRejected(func(tr *Transfer, err error) {
+		a.Equal(ErrContentHash, err)
+		a.True(q.Quarantined(tr.Key()), "held until rejected")
+		mu.Lock()
+		rejected = append(rejected, tr.Seq())
+		mu.Unlock()
+	})
+
+	v := NewValidator(0)
+	v.WithQuarantine(q)
+	// both workers get stuck in the verified handler, the third message waits in the queue
+	for _, tr := range trs[:3] {
+		appended, err := v.Append(tr)
+		r.NoError(err)
+		r.Len(appended, 1)
+		a.True(appended[0].HasContent(), "returned right away with its content")
+	}
+	a.EqualValues(3, v.Tip(trs[0].Author()).Sequence)
+	a.True(q.Len() > 0)
+	a.True(q.Quarantined(trs[2].Key()))
+
+	close(block)
+	for _, tr := range trs[3:5] {
+		_, err := v.Append(tr)
+		r.NoError(err)
+	}
+	q.Wait()
+	a.Equal(0, q.Len())
+	for _, tr := range trs[:5] {
+		a.False(q.Quarantined(tr.Key()))
+	}
+
+	q.Close()
+	_, err := v.Append(trs[5])
+	r.NoError(err)
+	a.False(q.Quarantined(trs[5].Key()), "hashed right away after Close")
+
+	mu.Lock()
+	defer mu.Unlock()
+	a.ElementsMatch([]int64{1, 2, 4, 5, 6}, verified)
+	a.Equal([]int64{3}, rejected)
+}
@@ -0,0 +1,105 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// Limiter decides if an author may add another message to its feed.
+// It is consulted by the Validator before the signature of a new message is checked.
+type Limiter interface {
+	// Allow returns nil if the message of author with size bytes (event and content) may be appended.
+	// Rejections should be a *QuotaError.
+	Allow(author refs.FeedRef, size int) error
+}
+
+// QuotaError is returned when an author exceeded one of its quotas
+type QuotaError struct {
+	Author refs.FeedRef
+
+	// Quota names the exceeded quota, i.e. "messages/minute"
+	Quota string
+
+	// RetryAfter is the time until the quota resets
+	RetryAfter time.Duration
+}
+
+func (qe *QuotaError) Error() string {
+	return fmt.Sprintf("gabbygrove: quota %s exceeded by %s (retry after %s)", qe.Quota, qe.Author.ShortSigil(), qe.RetryAfter)
+}
+
+// WithLimiter makes the validator enforce l on every new message
+func (v *Validator) WithLimiter(l Limiter) {
+	v.limiter = l
+}
+
+// Quota configures a QuotaLimiter, zero values mean unlimited
+type Quota struct {
+	MessagesPerMinute int
+	BytesPerDay       int
+}
+
+// QuotaLimiter enforces the same Quota for every author, counted in fixed windows
+type QuotaLimiter struct {
+	quota Quota
+
+	mu      sync.Mutex
+	authors map[string]*quotaUsage
+}
+
+type quotaUsage struct {
+	minute   time.Time
+	messages int
+
+	day   time.Time
+	bytes int
+}
+
+var _ Limiter = (*QuotaLimiter)(nil)
+
+// NewQuotaLimiter returns a limiter that enforces q per author
+func NewQuotaLimiter(q Quota) *QuotaLimiter {
+	return &QuotaLimiter{
+		quota:   q,
+		authors: make(map[string]*quotaUsage),
+	}
+}
+
+// Allow counts the message against the quotas of author
+func (ql *QuotaLimiter) Allow(author refs.FeedRef, size int) error {
+	ql.mu.Lock()
+	defer ql.mu.Unlock()
+
+	ts := now()
+	minute := ts.Truncate(time.Minute)
+	day := ts.Truncate(24 * time.Hour)
+
+	u, has := ql.authors[author.String()]
+	if !has {
+		u = &quotaUsage{}
+		ql.authors[author.String()] = u
+	}
+	if !u.minute.Equal(minute) {
+		u.minute, u.messages = minute, 0
+	}
+	if !u.day.Equal(day) {
+		u.day, u.bytes = day, 0
+	}
+
+	if max := ql.quota.MessagesPerMinute; max > 0 && u.messages+1 > max {
+		return &QuotaError{Author: author, Quota: "messages/minute", RetryAfter: minute.Add(time.Minute).Sub(ts)}
+	}
+	if max := ql.quota.BytesPerDay; max > 0 && u.bytes+size > max {
+		return &QuotaError{Author: author, Quota: "bytes/day", RetryAfter: day.Add(24 * time.Hour).Sub(ts)}
+	}
+	u.messages++
+	u.bytes += size
+	return nil
+}
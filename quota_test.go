@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatorQuota(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	ts := time.Date(2021, 3, 1, 12, 0, 30, 0, time.UTC)
+	now = func() time.Time { return ts }
+	defer func() { now = time.Now }()
+
+	author, trs := makeTestFeed(t, "dead", 5)
+	other, otherTrs := makeTestFeed(t, "beef", 1)
+
+	v := NewValidator(0)
+	v.WithLimiter(NewQuotaLimiter(Quota{MessagesPerMinute: 2}))
+
+	_, err := v.Append(trs[0])
+	r.NoError(err)
+	_, err = v.Append(trs[1])
+	r.NoError(err)
+
+	_, err = v.Append(trs[2])
+	var qe *QuotaError
+	r.True(errors.As(err, &qe), "%+v", err)
+	a.Equal("messages/minute", qe.Quota)
+	a.True(qe.Author.Equal(author))
+	a.Equal(30*time.Second, qe.RetryAfter)
+
+	// other authors and known messages are not affected
+	_, err = v.Append(otherTrs[0])
+	r.NoError(err)
+	a.EqualValues(1, v.Tip(other).Sequence)
+	_, err = v.Append(trs[1])
+	r.NoError(err)
+
+	ts = ts.Add(time.Minute)
+	_, err = v.Append(trs[2])
+	r.NoError(err)
+
+	// bytes per day
+	size := len(trs[3].Event) + len(trs[3].Content)
+	ql := NewQuotaLimiter(Quota{BytesPerDay: size + size/2})
+	r.NoError(ql.Allow(author, size))
+	err = ql.Allow(author, size)
+	r.True(errors.As(err, &qe))
+	a.Equal("bytes/day", qe.Quota)
+	ts = ts.Add(24 * time.Hour)
+	r.NoError(ql.Allow(author, size))
+}
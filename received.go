@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The received time is when a message arrived locally. It isn't part of the signed bytes
+// and only kept next to the transfer where it needs to be stored, see MarshalReceived.
+
+// SetReceived sets when tr arrived, which Received returns from now on
+func (tr *Transfer) SetReceived(t time.Time) {
+	tr.received = t
+}
+
+// ReceivedAt returns when tr arrived and false if that wasn't set
+func (tr *Transfer) ReceivedAt() (time.Time, bool) {
+	return tr.received, !tr.received.IsZero()
+}
+
+// receivedWrapperFields is the length of the array MarshalReceived wraps a transfer in.
+// Transfers have at least three fields, so the two formats can't be confused.
+const receivedWrapperFields = 2
+
+// MarshalReceived encodes tr together with its received time (in milliseconds) as the CBOR array [transfer, received].
+// Without a received time, it's the plain transfer, as MarshalCBOR encodes it.
+func (tr *Transfer) MarshalReceived() ([]byte, error) {
+	rcv, has := tr.ReceivedAt()
+	if !has {
+		return tr.MarshalCBOR()
+	}
+	b := appendCBORHead(nil, cborMajorArray, receivedWrapperFields)
+	b = tr.appendCBOR(b)
+	b = appendCBORInt(b, rcv.UnixNano()/int64(time.Millisecond))
+	return b, nil
+}
+
+// UnmarshalReceived decodes what MarshalReceived produced, which includes plain transfers
+func (tr *Transfer) UnmarshalReceived(data []byte) (err error) {
+	if len(data) == 0 || data[0] != cborMajorArray<<5|receivedWrapperFields {
+		return tr.UnmarshalCBOR(data)
+	}
+	defer func() { countMetric(MetricDecode, err != nil, len(data)) }()
+
+	p := cborParser{data: data, off: 1}
+	var newTr Transfer
+	n, err := newTr.decodeFrom(data[p.off:], decodeOptions{})
+	if err != nil {
+		return err
+	}
+	p.off += n
+	ms, err := p.int()
+	if err != nil {
+		return errors.Wrap(err, "gabbygrove/transfer: invalid received time")
+	}
+	if rest := len(data) - p.off; rest != 0 {
+		return errors.Errorf("gabbygrove/transfer: %d trailing bytes after received transfer", rest)
+	}
+	newTr.received = time.Unix(0, ms*int64(time.Millisecond))
+	*tr = newTr
+	return nil
+}
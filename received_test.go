@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReceived(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, trs := makeTestFeed(t, "dead", 1)
+	tr := trs[0]
+
+	_, has := tr.ReceivedAt()
+	a.False(has)
+	a.Equal(tr.Claimed(), tr.Received())
+
+	// without a received time it's the plain transfer
+	plain, err := tr.MarshalReceived()
+	r.NoError(err)
+	want, err := tr.MarshalCBOR()
+	r.NoError(err)
+	a.Equal(want, plain)
+
+	rcv := time.Unix(1600000000, 42e6)
+	tr.SetReceived(rcv)
+	a.Equal(rcv, tr.Received())
+	key := tr.Key()
+
+	// not part of the signed or transmitted bytes
+	b, err := tr.MarshalCBOR()
+	r.NoError(err)
+	a.Equal(want, b)
+
+	wrapped, err := tr.MarshalReceived()
+	r.NoError(err)
+	a.NotEqual(want, wrapped)
+
+	var got Transfer
+	r.NoError(got.UnmarshalReceived(wrapped))
+	a.Equal(key, got.Key())
+	gotRcv, has := got.ReceivedAt()
+	a.True(has)
+	a.True(rcv.Equal(gotRcv))
+	a.True(got.Verify(nil))
+
+	var gotPlain Transfer
+	r.NoError(gotPlain.UnmarshalReceived(plain))
+	a.Equal(key, gotPlain.Key())
+	_, has = gotPlain.ReceivedAt()
+	a.False(has)
+
+	// the wrapper is not a transfer
+	a.Error(got.UnmarshalCBOR(wrapped))
+	a.Error(got.UnmarshalReceived(wrapped[:len(wrapped)-1]))
+	a.Error(got.UnmarshalReceived(append(wrapped, 0)))
+}
@@ -0,0 +1,223 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// The String methods of the references go through fmt and net/url, which shows up when exporting large feeds.
+// The helpers here write the same bytes without the intermediate allocations
+// and have a fast path for parsing the canonical forms, anything else is passed on to the parsers of ssb-refs.
+
+// refHashLen is the length of the hashes and public keys of the references formatted here
+const refHashLen = 32
+
+// refBase64Len is the length of a base64 encoded hash, with padding
+const refBase64Len = 44
+
+// refStringLen is about the length of a formatted reference, to size buffers with
+const refStringLen = len("ssb:message/gabbygrove-v1/") + refBase64Len
+
+// AppendRef appends the String form of ref to dst.
+// Feed, message and content references are formatted directly, others fall back to calling String.
+func AppendRef(dst []byte, ref refs.Ref) []byte {
+	switch r := ref.(type) {
+	case refs.FeedRef:
+		return appendFeedRef(dst, r)
+	case refs.MessageRef:
+		return appendMessageRef(dst, r)
+	case ContentRef:
+		// the URI names the format, not the algorithm of the reference
+		return appendURI(dst, "content", r.hash[:], refs.RefAlgoFeedGabby)
+	default:
+		return append(dst, ref.String()...)
+	}
+}
+
+func appendFeedRef(dst []byte, f refs.FeedRef) []byte {
+	if f.Algo() == refs.RefAlgoFeedSSB1 {
+		return appendSigil(dst, '@', f.PubKey(), f.Algo())
+	}
+	return appendURI(dst, "feed", f.PubKey(), f.Algo())
+}
+
+func appendMessageRef(dst []byte, m refs.MessageRef) []byte {
+	var hash [refHashLen]byte
+	if err := m.CopyHashTo(hash[:]); err != nil {
+		return append(dst, m.String()...)
+	}
+	if m.Algo() == refs.RefAlgoMessageSSB1 || m.Algo() == refs.RefAlgoCloakedGroup {
+		return appendSigil(dst, '%', hash[:], m.Algo())
+	}
+	return appendURI(dst, "message", hash[:], m.Algo())
+}
+
+// AppendRefHex appends the lowercase hex encoding of the public key or hash of ref to dst, like the store names its files.
+func AppendRefHex(dst []byte, ref refs.Ref) ([]byte, error) {
+	var raw [refHashLen]byte
+	switch r := ref.(type) {
+	case refs.FeedRef:
+		copy(raw[:], r.PubKey())
+	case refs.MessageRef:
+		if err := r.CopyHashTo(raw[:]); err != nil {
+			return dst, err
+		}
+	case ContentRef:
+		raw = r.hash
+	default:
+		return dst, errors.Errorf("gabbygrove: can't hex encode %T", ref)
+	}
+	n := len(dst)
+	dst = append(dst, make([]byte, hex.EncodedLen(refHashLen))...)
+	hex.Encode(dst[n:], raw[:])
+	return dst, nil
+}
+
+// FormatFeedRefs returns the String forms of feeds, which all share one allocation
+func FormatFeedRefs(feeds []refs.FeedRef) []string {
+	buf := make([]byte, 0, len(feeds)*refStringLen)
+	ends := make([]int, len(feeds))
+	for i, f := range feeds {
+		buf = appendFeedRef(buf, f)
+		ends[i] = len(buf)
+	}
+	return splitAt(string(buf), ends)
+}
+
+// FormatMessageRefs returns the String forms of msgs, which all share one allocation
+func FormatMessageRefs(msgs []refs.MessageRef) []string {
+	buf := make([]byte, 0, len(msgs)*refStringLen)
+	ends := make([]int, len(msgs))
+	for i, m := range msgs {
+		buf = appendMessageRef(buf, m)
+		ends[i] = len(buf)
+	}
+	return splitAt(string(buf), ends)
+}
+
+// ParseFeedRefs parses strs like refs.ParseFeedRef does
+func ParseFeedRefs(strs []string) ([]refs.FeedRef, error) {
+	feeds := make([]refs.FeedRef, len(strs))
+	for i, s := range strs {
+		f, err := parseFeedRef(s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "gabbygrove: feed reference %d", i)
+		}
+		feeds[i] = f
+	}
+	return feeds, nil
+}
+
+// ParseMessageRefs parses strs like refs.ParseMessageRef does
+func ParseMessageRefs(strs []string) ([]refs.MessageRef, error) {
+	msgs := make([]refs.MessageRef, len(strs))
+	for i, s := range strs {
+		m, err := parseMessageRef(s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "gabbygrove: message reference %d", i)
+		}
+		msgs[i] = m
+	}
+	return msgs, nil
+}
+
+func parseFeedRef(s string) (refs.FeedRef, error) {
+	var raw [refHashLen]byte
+	if algo, ok := decodeCanonical(s, '@', "feed", raw[:]); ok {
+		switch algo {
+		case refs.RefAlgoFeedSSB1, refs.RefAlgoFeedGabby, refs.RefAlgoFeedBendyButt:
+			return refs.NewFeedRefFromBytes(raw[:], algo)
+		}
+	}
+	return refs.ParseFeedRef(s)
+}
+
+func parseMessageRef(s string) (refs.MessageRef, error) {
+	var raw [refHashLen]byte
+	if algo, ok := decodeCanonical(s, '%', "message", raw[:]); ok {
+		isURI := s[0] != '%'
+		switch {
+		case algo == refs.RefAlgoMessageSSB1 || algo == refs.RefAlgoMessageGabby,
+			algo == refs.RefAlgoCloakedGroup && !isURI,
+			algo == refs.RefAlgoMessageBendyButt && isURI:
+			return refs.NewMessageRefFromBytes(raw[:], algo)
+		}
+	}
+	return refs.ParseMessageRef(s)
+}
+
+// decodeCanonical decodes s into raw if it's exactly a sigil or an ssb URI of a 32 byte reference and returns its algorithm.
+func decodeCanonical(s string, sigil byte, kind string, raw []byte) (refs.RefAlgo, bool) {
+	var (
+		enc       *base64.Encoding
+		encoded   string
+		algo      string
+		uriPrefix = "ssb:" + kind + "/"
+	)
+	switch {
+	case len(s) > 1+refBase64Len+1 && s[0] == sigil && s[1+refBase64Len] == '.':
+		enc = base64.StdEncoding
+		encoded, algo = s[1:1+refBase64Len], s[2+refBase64Len:]
+		if strings.IndexByte(algo, '.') >= 0 {
+			return "", false
+		}
+	case strings.HasPrefix(s, uriPrefix) && len(s) > len(uriPrefix)+refBase64Len+1:
+		enc = base64.URLEncoding
+		algo, encoded = s[len(uriPrefix):len(s)-refBase64Len-1], s[len(s)-refBase64Len:]
+		if s[len(s)-refBase64Len-1] != '/' || strings.IndexByte(algo, '/') >= 0 {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	var src [refBase64Len]byte
+	var dec [refHashLen + 1]byte // Decode needs room for the padding
+	copy(src[:], encoded)
+	if n, err := enc.Decode(dec[:], src[:]); err != nil || n != refHashLen {
+		return "", false
+	}
+	copy(raw, dec[:refHashLen])
+	return refs.RefAlgo(algo), true
+}
+
+func appendSigil(dst []byte, sigil byte, raw []byte, algo refs.RefAlgo) []byte {
+	dst = append(dst, sigil)
+	dst = appendBase64(dst, base64.StdEncoding, raw)
+	dst = append(dst, '.')
+	return append(dst, algo...)
+}
+
+func appendURI(dst []byte, kind string, raw []byte, algo refs.RefAlgo) []byte {
+	dst = append(dst, "ssb:"...)
+	dst = append(dst, kind...)
+	dst = append(dst, '/')
+	dst = append(dst, algo...)
+	dst = append(dst, '/')
+	return appendBase64(dst, base64.URLEncoding, raw)
+}
+
+func appendBase64(dst []byte, enc *base64.Encoding, raw []byte) []byte {
+	n := len(dst)
+	dst = append(dst, make([]byte, enc.EncodedLen(len(raw)))...)
+	enc.Encode(dst[n:], raw)
+	return dst
+}
+
+// splitAt cuts s at the offsets in ends
+func splitAt(s string, ends []int) []string {
+	out := make([]string, len(ends))
+	start := 0
+	for i, end := range ends {
+		out[i] = s[start:end]
+		start = end
+	}
+	return out
+}
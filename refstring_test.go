@@ -0,0 +1,175 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+func testRefs(t testing.TB) ([]refs.FeedRef, []refs.MessageRef, ContentRef) {
+	r := require.New(t)
+	raw := bytes.Repeat([]byte{0xfb, 0xef, 0xff}, 11)[:32]
+
+	var feeds []refs.FeedRef
+	for _, algo := range []refs.RefAlgo{refs.RefAlgoFeedGabby, refs.RefAlgoFeedSSB1, refs.RefAlgoFeedBendyButt} {
+		f, err := refs.NewFeedRefFromBytes(raw, algo)
+		r.NoError(err)
+		feeds = append(feeds, f)
+	}
+	var msgs []refs.MessageRef
+	for _, algo := range []refs.RefAlgo{refs.RefAlgoMessageGabby, refs.RefAlgoMessageSSB1, refs.RefAlgoCloakedGroup, refs.RefAlgoMessageBendyButt} {
+		m, err := refs.NewMessageRefFromBytes(raw, algo)
+		r.NoError(err)
+		msgs = append(msgs, m)
+	}
+	content, err := NewContentRefFromBytes(raw)
+	r.NoError(err)
+	return feeds, msgs, content
+}
+
+func TestAppendRef(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	feeds, msgs, content := testRefs(t)
+	all := []refs.Ref{content}
+	for _, f := range feeds {
+		all = append(all, f)
+	}
+	for _, m := range msgs {
+		all = append(all, m)
+	}
+	for _, ref := range all {
+		a.Equal(ref.String(), string(AppendRef(nil, ref)))
+		a.Equal("x"+ref.String(), string(AppendRef([]byte("x"), ref)))
+
+		h, err := AppendRefHex(nil, ref)
+		r.NoError(err)
+		a.Equal(hex.EncodeToString(bytes.Repeat([]byte{0xfb, 0xef, 0xff}, 11)[:32]), string(h))
+	}
+
+	strs := FormatFeedRefs(feeds)
+	for i, f := range feeds {
+		a.Equal(f.String(), strs[i])
+	}
+	strs = FormatMessageRefs(msgs)
+	for i, m := range msgs {
+		a.Equal(m.String(), strs[i])
+	}
+	a.Len(FormatMessageRefs(nil), 0)
+
+	buf := make([]byte, 0, 128)
+	a.Zero(testing.AllocsPerRun(10, func() { appendFeedRef(buf[:0], feeds[0]) }))
+	a.Zero(testing.AllocsPerRun(10, func() { appendMessageRef(buf[:0], msgs[0]) }))
+}
+
+func TestParseRefs(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	feeds, msgs, _ := testRefs(t)
+
+	// the sigil forms of all of them, and the URIs
+	var feedStrs, msgStrs []string
+	for _, f := range feeds {
+		feedStrs = append(feedStrs, f.Sigil(), f.URI())
+	}
+	for _, m := range msgs {
+		msgStrs = append(msgStrs, m.Sigil(), m.URI())
+	}
+
+	for _, s := range feedStrs {
+		want, wantErr := refs.ParseFeedRef(s)
+		got, err := ParseFeedRefs([]string{s})
+		if wantErr != nil {
+			a.Error(err, s)
+			continue
+		}
+		r.NoError(err, s)
+		a.True(want.Equal(got[0]), s)
+	}
+	for _, s := range msgStrs {
+		want, wantErr := refs.ParseMessageRef(s)
+		got, err := ParseMessageRefs([]string{s})
+		if wantErr != nil {
+			a.Error(err, s)
+			continue
+		}
+		r.NoError(err, s)
+		a.True(want.Equal(got[0]), s)
+	}
+
+	got, err := ParseMessageRefs(FormatMessageRefs(msgs[:2]))
+	r.NoError(err)
+	a.True(msgs[1].Equal(got[1]))
+
+	for _, bad := range []string{
+		"",
+		"%" + msgs[0].Sigil()[1:45] + ".unknown",
+		msgs[0].Sigil()[:40] + ".sha256",
+		"ssb:message/unknown/" + msgs[0].URI()[len(msgs[0].URI())-44:],
+		"@" + msgs[0].Sigil()[1:],
+	} {
+		_, err := ParseMessageRefs([]string{msgStrs[0], bad})
+		a.Error(err, "%q", bad)
+	}
+	_, err = ParseFeedRefs([]string{feeds[0].String(), msgs[0].String()})
+	a.Error(err)
+}
+
+func BenchmarkFormatMessageRefs(b *testing.B) {
+	_, trs := makeTestFeed(b, "refs", 64)
+	msgs := make([]refs.MessageRef, len(trs))
+	for i, tr := range trs {
+		msgs[i] = tr.Key()
+	}
+	b.Run("String", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, m := range msgs {
+				_ = m.String()
+			}
+		}
+	})
+	b.Run("FormatMessageRefs", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			FormatMessageRefs(msgs)
+		}
+	})
+}
+
+func BenchmarkParseMessageRefs(b *testing.B) {
+	_, trs := makeTestFeed(b, "refs", 64)
+	msgs := make([]refs.MessageRef, len(trs))
+	for i, tr := range trs {
+		msgs[i] = tr.Key()
+	}
+	strs := FormatMessageRefs(msgs)
+	b.Run("ParseMessageRef", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, s := range strs {
+				if _, err := refs.ParseMessageRef(s); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("ParseMessageRefs", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := ParseMessageRefs(strs); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
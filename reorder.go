@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// ErrBufferFull is returned when a FeedBuffer already holds its limit of early messages
+var ErrBufferFull = errors.New("gabbygrove: out-of-order buffer full")
+
+// SeqRange is a range of sequences of one feed, both ends are included
+type SeqRange struct {
+	Author refs.FeedRef
+	From   uint64
+	To     uint64
+}
+
+// FeedBuffer validates the messages of a feed that arrive out of order.
+// Messages ahead of the feed are held back, up to limit of them, until the ones in between arrived.
+type FeedBuffer struct {
+	state   *FeedState
+	limit   int
+	pending map[uint64]*Transfer
+}
+
+// NewFeedBuffer continues the feed described by state and buffers at most limit messages
+func NewFeedBuffer(state *FeedState, limit int) *FeedBuffer {
+	return &FeedBuffer{
+		state:   state,
+		limit:   limit,
+		pending: make(map[uint64]*Transfer),
+	}
+}
+
+// State returns the feed state the buffer appends to
+func (fb *FeedBuffer) State() *FeedState {
+	return fb.state
+}
+
+// Add takes in tr and returns the messages that were appended to the feed because of it, in order.
+// Messages the feed already has are ignored.
+// Messages ahead of the feed need a valid signature to be buffered, another one with the same sequence is ignored.
+// If tr is the next message and invalid, its error is returned. Buffered messages that turn out invalid are dropped.
+func (fb *FeedBuffer) Add(tr *Transfer) ([]*Transfer, error) {
+	evt, err := tr.getEvent()
+	if err != nil {
+		return nil, errors.Wrap(err, "gabbygrove/buffer: invalid event")
+	}
+
+	next := fb.state.Sequence + 1
+	switch s := evt.Sequence; {
+	case s < next:
+		return nil, nil
+	case s > next:
+		aref, err := evt.Author.GetRef(RefTypeFeed)
+		if err != nil {
+			return nil, errors.Wrap(err, "gabbygrove/buffer: invalid author")
+		}
+		if !aref.(refs.FeedRef).Equal(fb.state.Author) {
+			return nil, errors.Wrapf(ErrWrongAuthor, "buffer: got %s", aref.(refs.FeedRef).ShortSigil())
+		}
+		if _, has := fb.pending[s]; has {
+			// keep the first one, it's signed and the chain decides once the gap is filled
+			return nil, nil
+		}
+		if len(fb.pending) >= fb.limit {
+			return nil, errors.Wrapf(ErrBufferFull, "buffer: can't hold %d", s)
+		}
+		vk, err := fb.state.verifyKey()
+		if err != nil {
+			return nil, errors.Wrap(err, "gabbygrove/buffer")
+		}
+		if tr.verifyKey(vk, fb.state.hmacKey, false) != nil {
+			return nil, errors.Wrapf(ErrInvalidSignature, "buffer: message %d", s)
+		}
+		fb.pending[s] = tr
+		return nil, nil
+	}
+
+	if err := fb.state.Append(tr); err != nil {
+		return nil, err
+	}
+	appended := []*Transfer{tr}
+	for {
+		next := fb.state.Sequence + 1
+		buffered, has := fb.pending[next]
+		if !has {
+			return appended, nil
+		}
+		delete(fb.pending, next)
+		if err := fb.state.Append(buffered); err != nil {
+			// drop it, a valid one might still arrive
+			return appended, nil
+		}
+		appended = append(appended, buffered)
+	}
+}
+
+// Len returns the number of buffered messages
+func (fb *FeedBuffer) Len() int {
+	return len(fb.pending)
+}
+
+// Missing returns the gaps between the feed and the buffered messages, so they can be requested.
+// Nothing is missing if no messages are buffered.
+func (fb *FeedBuffer) Missing() []SeqRange {
+	seqs := make([]uint64, 0, len(fb.pending))
+	for seq := range fb.pending {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	var missing []SeqRange
+	have := fb.state.Sequence
+	for _, seq := range seqs {
+		if seq > have+1 {
+			missing = append(missing, SeqRange{
+				Author: fb.state.Author,
+				From:   have + 1,
+				To:     seq - 1,
+			})
+		}
+		have = seq
+	}
+	return missing
+}
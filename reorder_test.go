@@ -0,0 +1,84 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeedBuffer(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	author, trs := makeTestFeed(t, "dead", 8)
+	fb := NewFeedBuffer(NewFeedState(author), 3)
+
+	a.Len(fb.Missing(), 0)
+
+	appended, err := fb.Add(trs[2])
+	r.NoError(err)
+	a.Len(appended, 0)
+	appended, err = fb.Add(trs[5])
+	r.NoError(err)
+	a.Len(appended, 0)
+
+	a.Equal([]SeqRange{
+		{Author: author, From: 1, To: 2},
+		{Author: author, From: 4, To: 5},
+	}, fb.Missing())
+
+	_, err = fb.Add(trs[6])
+	r.NoError(err)
+	_, err = fb.Add(trs[7])
+	a.Equal(ErrBufferFull, errors.Cause(err))
+	_, err = fb.Add(trs[6])
+	r.NoError(err, "adding a buffered one again is fine")
+
+	// forged messages are neither buffered nor evict a genuine one
+	forged := *trs[6]
+	forged.Signature = append([]byte{}, trs[6].Signature...)
+	forged.Signature[0] ^= 0xff
+	_, err = fb.Add(&forged)
+	r.NoError(err)
+	a.Equal(trs[6], fb.pending[7])
+	forged = *trs[7]
+	forged.Signature = append([]byte{}, trs[7].Signature...)
+	forged.Signature[0] ^= 0xff
+	other := NewFeedBuffer(NewFeedState(author), 3)
+	_, err = other.Add(&forged)
+	a.Equal(ErrInvalidSignature, errors.Cause(err))
+	a.Equal(0, other.Len())
+
+	appended, err = fb.Add(trs[0])
+	r.NoError(err)
+	a.Len(appended, 1)
+	appended, err = fb.Add(trs[1])
+	r.NoError(err)
+	r.Len(appended, 2)
+	a.EqualValues(3, appended[1].Seq())
+
+	a.Equal([]SeqRange{{Author: author, From: 4, To: 5}}, fb.Missing())
+
+	appended, err = fb.Add(trs[4])
+	r.NoError(err)
+	a.Len(appended, 0)
+	a.Len(fb.Missing(), 1)
+
+	appended, err = fb.Add(trs[3])
+	r.NoError(err)
+	a.Len(appended, 4)
+	a.EqualValues(7, fb.State().Sequence)
+	a.Equal(0, fb.Len())
+	a.Len(fb.Missing(), 0)
+
+	// already known
+	appended, err = fb.Add(trs[3])
+	r.NoError(err)
+	a.Len(appended, 0)
+}
@@ -0,0 +1,120 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package replicate
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// Range is a span of sequences of a feed, from From up to and including To
+type Range struct {
+	From, To uint64
+}
+
+// MissingContent is a message that is stored without its content, Hash is what the content needs to match
+type MissingContent struct {
+	Sequence uint64
+	Hash     gabbygrove.ContentRef
+}
+
+// Backfill is what is missing locally of the feed of Author
+type Backfill struct {
+	Author refs.FeedRef
+
+	// Messages are the messages that aren't stored at all, i.e. because the feed is behind a peer
+	Messages []Range
+
+	// Content are the messages that are stored without their content
+	Content []MissingContent
+}
+
+// BackfillStore is the part of a store.Store that DetectBackfill needs
+type BackfillStore interface {
+	Feeds() ([]refs.FeedRef, error)
+	Tip(author refs.FeedRef) (gabbygrove.FeedState, error)
+	Iterate(author refs.FeedRef, from, to uint64, fn func(*gabbygrove.Transfer) error) error
+}
+
+// DetectBackfill returns what the feeds that p replicates are missing in s, ordered by public key.
+// known is how far the feeds are elsewhere, i.e. the vectors of peers merged, and the messages after the local tip
+// up to it are missing. Content is missing if a message is stored without it but p keeps it.
+// Content that was deleted on purpose looks the same as content that never arrived, p needs to leave it out
+// (i.e. with KeepLast) so it isn't asked for again. Feeds without anything missing are left out.
+func DetectBackfill(s BackfillStore, known Vector, p Profile) ([]Backfill, error) {
+	stored, err := s.Feeds()
+	if err != nil {
+		return nil, err
+	}
+	authors := make(map[string]refs.FeedRef, len(stored)+len(known))
+	for _, author := range stored {
+		authors[string(author.PubKey())] = author
+	}
+	for _, author := range known.Authors() {
+		authors[string(author.PubKey())] = author
+	}
+	keys := make([]string, 0, len(authors))
+	for k := range authors {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var backfills []Backfill
+	for _, k := range keys {
+		author := authors[k]
+		if !p.Replicates(author) {
+			continue
+		}
+		b, err := detectFeed(s, author, known, p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "replicate: failed to check %s", author.ShortSigil())
+		}
+		if len(b.Messages) > 0 || len(b.Content) > 0 {
+			backfills = append(backfills, b)
+		}
+	}
+	return backfills, nil
+}
+
+func detectFeed(s BackfillStore, author refs.FeedRef, known Vector, p Profile) (Backfill, error) {
+	b := Backfill{Author: author}
+	tip, err := s.Tip(author)
+	if err != nil {
+		return b, err
+	}
+	if n, has := known.Get(author); has && n.Replicate && n.Sequence > tip.Sequence {
+		b.Messages = append(b.Messages, Range{From: tip.Sequence + 1, To: n.Sequence})
+	}
+	if tip.Sequence == 0 || (p.Content != nil && p.Content.None) {
+		return b, nil
+	}
+
+	from := uint64(1)
+	if p.KeepLast > 0 && tip.Sequence > p.KeepLast {
+		from = tip.Sequence - p.KeepLast + 1
+	}
+	err = s.Iterate(author, from, tip.Sequence, func(tr *gabbygrove.Transfer) error {
+		if tr.HasContent() {
+			return nil
+		}
+		evt, err := tr.DecodedEvent()
+		if err != nil {
+			return err
+		}
+		if evt.Content.Size == 0 || (p.Content != nil && !p.Content.Allowed(tr)) {
+			return nil
+		}
+		cr, err := evt.Content.Hash.GetRef(gabbygrove.RefTypeContent)
+		if err != nil {
+			return errors.Wrapf(err, "replicate: message %d has no content hash", tr.Seq())
+		}
+		b.Content = append(b.Content, MissingContent{Sequence: uint64(tr.Seq()), Hash: cr.(gabbygrove.ContentRef)})
+		return nil
+	})
+	return b, err
+}
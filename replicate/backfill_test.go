@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package replicate
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	"go.mindeco.de/ssb-gabbygrove/gabbygrovetest"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+func TestDetectBackfill(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	alice := gabbygrovetest.Fixture(t, "alice", 5)
+	bob := gabbygrovetest.Fixture(t, "bob", 4)
+	carol := gabbygrovetest.Fixture(t, "carol", 3)
+	dave := gabbygrovetest.Fixture(t, "dave", 1)
+	s := openStore(t, alice.Messages(), bob.Messages()[:2])
+	r.NoError(s.DeleteContent(alice.Author(), 2))
+	r.NoError(s.DeleteContent(alice.Author(), 4))
+
+	known := vectorOf(t, s, alice.Author())
+	r.NoError(known.Set(bob.Author(), Note{Replicate: true, Receive: true, Sequence: 4}))
+	r.NoError(known.Set(carol.Author(), Note{Replicate: true, Receive: true, Sequence: 3}))
+	r.NoError(known.Set(dave.Author(), Note{}))
+
+	hash := func(seq int) gabbygrove.ContentRef {
+		evt, err := alice.Messages()[seq-1].DecodedEvent()
+		r.NoError(err)
+		cr, err := evt.Content.Hash.GetRef(gabbygrove.RefTypeContent)
+		r.NoError(err)
+		return cr.(gabbygrove.ContentRef)
+	}
+	byAuthor := func(bs []Backfill) map[string]Backfill {
+		m := make(map[string]Backfill)
+		for i, b := range bs {
+			if i > 0 {
+				a.True(bytes.Compare(bs[i-1].Author.PubKey(), b.Author.PubKey()) < 0, "ordered by public key")
+			}
+			m[b.Author.String()] = b
+		}
+		return m
+	}
+
+	bs, err := DetectBackfill(s, known, Full)
+	r.NoError(err)
+	r.Len(bs, 3)
+	m := byAuthor(bs)
+	a.Equal(Backfill{
+		Author:  alice.Author(),
+		Content: []MissingContent{{Sequence: 2, Hash: hash(2)}, {Sequence: 4, Hash: hash(4)}},
+	}, m[alice.Author().String()])
+	a.Equal(Backfill{Author: bob.Author(), Messages: []Range{{From: 3, To: 4}}}, m[bob.Author().String()])
+	a.Equal(Backfill{Author: carol.Author(), Messages: []Range{{From: 1, To: 3}}}, m[carol.Author().String()])
+
+	bs, err = DetectBackfill(s, known, LastN(2))
+	r.NoError(err)
+	m = byAuthor(bs)
+	a.Equal([]MissingContent{{Sequence: 4, Hash: hash(4)}}, m[alice.Author().String()].Content)
+
+	bs, err = DetectBackfill(s, known, EventsOnly)
+	r.NoError(err)
+	r.Len(bs, 2)
+	for _, b := range bs {
+		a.Empty(b.Content)
+	}
+
+	onlyAlice := Profile{Feeds: func(author refs.FeedRef) bool { return author.Equal(alice.Author()) }}
+	bs, err = DetectBackfill(s, known, onlyAlice)
+	r.NoError(err)
+	r.Len(bs, 1)
+	a.True(bs[0].Author.Equal(alice.Author()))
+
+	bs, err = DetectBackfill(s, vectorOf(t, s, alice.Author(), bob.Author()), EventsOnly)
+	r.NoError(err)
+	a.Empty(bs, "nothing missing")
+}
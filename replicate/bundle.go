@@ -0,0 +1,163 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package replicate
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Feeds can be exchanged offline with files, i.e. on a USB stick, instead of a Session.
+// One node writes a want list of how far it has the feeds it wants, another answers it with a bundle
+// of the messages it has after that, and the first imports the bundle.
+// Both files use the frames of the session: a hello with their own magic, a vector,
+// the transfers of a bundle and done at the end, so a file that was cut off is noticed.
+const (
+	wantsMagic  = "gabbygrove/wants"
+	bundleMagic = "gabbygrove/bundle"
+	fileVersion = 1
+)
+
+// WriteWantList writes the notes of the feeds to ask for. Only the feeds that are received are answered, see Note.
+func WriteWantList(w io.Writer, wants Vector) error {
+	bw := bufio.NewWriter(w)
+	if err := writeFileHeader(bw, wantsMagic, wants); err != nil {
+		return err
+	}
+	if err := writeFrame(bw, frameDone, nil); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// ReadWantList reads what WriteWantList wrote
+func ReadWantList(r io.Reader) (Vector, error) {
+	rd := bufio.NewReader(r)
+	wants, err := readFileHeader(rd, wantsMagic)
+	if err != nil {
+		return nil, err
+	}
+	f := readFrame(rd)
+	if f.err != nil {
+		return nil, f.err
+	}
+	if f.typ != frameDone {
+		return nil, errors.Wrap(ErrProtocol, "want list: expected done")
+	}
+	return wants, nil
+}
+
+// WriteBundle answers wants with the messages of feeds after what the want list notes, up to how far local notes them,
+// including what content they have. It starts with the notes of local for the wanted feeds and returns how many transfers it wrote.
+func WriteBundle(w io.Writer, feeds Feeds, local, wants Vector) (int, error) {
+	have := make(Vector)
+	for k, n := range wants {
+		if ln, has := local[k]; has && n.Replicate {
+			have[k] = ln
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := writeFileHeader(bw, bundleMagic, have); err != nil {
+		return 0, err
+	}
+	var written int
+	queue := newSendQueue(ToSend(local, wants), local)
+	for !queue.empty() {
+		tr, err := queue.next(feeds)
+		if err != nil {
+			return written, err
+		}
+		data, err := tr.MarshalCBOR()
+		if err != nil {
+			return written, errors.Wrap(err, "replicate: failed to encode transfer")
+		}
+		if err := writeFrame(bw, frameTransfer, data); err != nil {
+			return written, err
+		}
+		written++
+	}
+	if err := writeFrame(bw, frameDone, nil); err != nil {
+		return written, err
+	}
+	return written, bw.Flush()
+}
+
+// ImportBundle appends the transfers of a bundle to feeds, if local notes that their feeds are received.
+// Messages local already has are skipped and local is advanced with the appended ones.
+// It returns the notes the bundle started with, of how far its writer has the feeds, and how many transfers were appended.
+// If the bundle is broken, what was appended up to there stays.
+func ImportBundle(r io.Reader, feeds Feeds, local Vector) (Vector, int, error) {
+	rd := bufio.NewReader(r)
+	have, err := readFileHeader(rd, bundleMagic)
+	if err != nil {
+		return nil, 0, err
+	}
+	var appended int
+	for {
+		f := readFrame(rd)
+		if f.err != nil {
+			return have, appended, f.err
+		}
+		switch f.typ {
+		case frameDone:
+			return have, appended, nil
+		case frameTransfer:
+		default:
+			return have, appended, errors.Wrapf(ErrProtocol, "bundle: unexpected frame %d", f.typ)
+		}
+
+		tr, author, seq, err := decodeTransfer(f.payload)
+		if err != nil {
+			return have, appended, errors.Wrap(err, "bundle")
+		}
+		note, has := local.Get(author)
+		if !has || !note.Replicate || !note.Receive || seq <= note.Sequence {
+			continue
+		}
+		if err := feeds.Append(tr); err != nil {
+			return have, appended, errors.Wrapf(err, "replicate: failed to append %d of %s", seq, author.ShortSigil())
+		}
+		note.Sequence = seq
+		if err := local.Set(author, note); err != nil {
+			return have, appended, err
+		}
+		appended++
+	}
+}
+
+func writeFileHeader(w io.Writer, magic string, v Vector) error {
+	if err := writeFrame(w, frameHello, append([]byte(magic), fileVersion)); err != nil {
+		return err
+	}
+	data, err := v.MarshalCBOR()
+	if err != nil {
+		return err
+	}
+	return writeFrame(w, frameVector, data)
+}
+
+func readFileHeader(rd *bufio.Reader, magic string) (Vector, error) {
+	f := readFrame(rd)
+	if f.err != nil {
+		return nil, f.err
+	}
+	if f.typ != frameHello || string(f.payload) != magic+string([]byte{fileVersion}) {
+		return nil, errors.Wrapf(ErrProtocol, "not a file of %s", magic)
+	}
+	if f = readFrame(rd); f.err != nil {
+		return nil, f.err
+	}
+	if f.typ != frameVector {
+		return nil, errors.Wrap(ErrProtocol, "expected vector")
+	}
+	var v Vector
+	if err := v.UnmarshalCBOR(f.payload); err != nil {
+		return nil, errors.Wrap(ErrProtocol, err.Error())
+	}
+	return v, nil
+}
@@ -0,0 +1,109 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package replicate
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mindeco.de/ssb-gabbygrove/gabbygrovetest"
+)
+
+func TestBundle(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	alice := gabbygrovetest.Fixture(t, "alice", 30)
+	bob := gabbygrovetest.Fixture(t, "bob", 10)
+	carol := gabbygrovetest.Fixture(t, "carol", 5)
+
+	offline := openStore(t, alice.Messages()[:10])
+	online := openStore(t, alice.Messages(), bob.Messages(), carol.Messages())
+
+	// the offline node asks for alice and bob
+	var wantFile bytes.Buffer
+	wants := vectorOf(t, offline, alice.Author(), bob.Author())
+	r.NoError(WriteWantList(&wantFile, wants))
+
+	gotWants, err := ReadWantList(bytes.NewReader(wantFile.Bytes()))
+	r.NoError(err)
+	a.Equal(wants, gotWants)
+
+	var bundle bytes.Buffer
+	local := vectorOf(t, online, alice.Author(), bob.Author(), carol.Author())
+	n, err := WriteBundle(&bundle, online, local, gotWants)
+	r.NoError(err)
+	a.Equal(30, n)
+
+	have, appended, err := ImportBundle(bytes.NewReader(bundle.Bytes()), offline, wants)
+	r.NoError(err)
+	a.Equal(30, appended)
+	a.Len(have, 2, "only what was asked for")
+	note, _ := have.Get(alice.Author())
+	a.EqualValues(30, note.Sequence)
+	note, _ = wants.Get(bob.Author())
+	a.EqualValues(10, note.Sequence, "advanced")
+	for _, b := range []*gabbygrovetest.FeedBuilder{alice, bob} {
+		tip, err := offline.Tip(b.Author())
+		r.NoError(err)
+		a.Equal(b.State().Sequence, tip.Sequence)
+	}
+	tip, err := offline.Tip(carol.Author())
+	r.NoError(err)
+	a.EqualValues(0, tip.Sequence)
+
+	// importing again changes nothing
+	_, appended, err = ImportBundle(bytes.NewReader(bundle.Bytes()), offline, wants)
+	r.NoError(err)
+	a.Equal(0, appended)
+
+	// a cut off bundle keeps what came before the cut
+	partial := openStore(t)
+	partialWants := vectorOf(t, partial, alice.Author(), bob.Author())
+	var full bytes.Buffer
+	_, err = WriteBundle(&full, online, local, partialWants)
+	r.NoError(err)
+	_, appended, err = ImportBundle(bytes.NewReader(full.Bytes()[:full.Len()/2]), partial, partialWants)
+	a.Error(err)
+	a.True(appended > 0)
+	var stored int
+	for _, b := range []*gabbygrovetest.FeedBuilder{alice, bob} {
+		tip, err := partial.Tip(b.Author())
+		r.NoError(err)
+		stored += int(tip.Sequence)
+	}
+	a.Equal(appended, stored)
+
+	_, err = ReadWantList(bytes.NewReader(bundle.Bytes()))
+	a.Equal(ErrProtocol, errors.Cause(err), "not a want list")
+	_, _, err = ImportBundle(bytes.NewReader(wantFile.Bytes()), offline, wants)
+	a.Equal(ErrProtocol, errors.Cause(err), "not a bundle")
+	_, err = ReadWantList(bytes.NewReader(wantFile.Bytes()[:wantFile.Len()-1]))
+	a.Error(err, "cut off")
+
+	// a transfer whose event can't be decoded is rejected
+	truncated := *alice.Messages()[0]
+	truncated.Event = truncated.Event[:len(truncated.Event)/2]
+	garbage, err := truncated.MarshalCBOR()
+	r.NoError(err)
+	var broken bytes.Buffer
+	r.NoError(writeFileHeader(&broken, bundleMagic, make(Vector)))
+	r.NoError(writeFrame(&broken, frameTransfer, garbage))
+	r.NoError(writeFrame(&broken, frameDone, nil))
+	_, appended, err = ImportBundle(&broken, openStore(t), partialWants)
+	a.Equal(ErrProtocol, errors.Cause(err), "garbage event")
+	a.Equal(0, appended)
+
+	// nothing is bundled that isn't wanted
+	bundle.Reset()
+	none := make(Vector)
+	r.NoError(none.Set(carol.Author(), Note{Replicate: true, Sequence: 0}))
+	n, err = WriteBundle(&bundle, online, local, none)
+	r.NoError(err)
+	a.Equal(0, n)
+}
@@ -0,0 +1,126 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package replicate
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	"go.mindeco.de/ssb-gabbygrove/contacts"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// Profile says what of which feeds a node keeps. The events of the feeds it replicates are always kept,
+// without them the feeds can't be verified, the profiles differ in the content and in the feeds.
+//
+// A profile is applied to the parts that enforce it: the content policy to a gabbygrove.Validator with ApplyValidator,
+// the feeds to a Scheduler with ApplyScheduler and the content to keep to a store with Prune.
+type Profile struct {
+	Name string
+
+	// Content is the content that is kept when messages arrive, nil keeps all
+	Content *gabbygrove.ContentPolicy
+
+	// KeepLast keeps the content of the last KeepLast messages of a feed only, zero keeps all
+	KeepLast uint64
+
+	// Feeds are the feeds to replicate, nil replicates all
+	Feeds func(author refs.FeedRef) bool
+}
+
+// The profiles that aren't specific to a node
+var (
+	// Full keeps everything
+	Full = Profile{Name: "full"}
+
+	// EventsOnly keeps no content at all
+	EventsOnly = Profile{Name: "events-only", Content: &gabbygrove.ContentPolicy{None: true}}
+)
+
+// LastN keeps only the content of the newest n messages of every feed
+func LastN(n uint64) Profile {
+	return Profile{Name: "last-" + strconv.FormatUint(n, 10), KeepLast: n}
+}
+
+// FollowsOnly replicates self and the feeds that are at most hops follows away from it in g, see contacts.Graph.Hops.
+// The graph is asked every time, so the profile follows it as it changes.
+func FollowsOnly(g *contacts.Graph, self refs.FeedRef, hops int) Profile {
+	return Profile{
+		Name: "follows-only",
+		Feeds: func(author refs.FeedRef) bool {
+			if author.Equal(self) {
+				return true
+			}
+			_, ok := g.Distance(self, author, hops)
+			return ok
+		},
+	}
+}
+
+// ParseProfile returns the profile with name: full, events-only or last-N.
+// follows-only needs a graph, see FollowsOnly.
+func ParseProfile(name string) (Profile, error) {
+	switch name {
+	case Full.Name:
+		return Full, nil
+	case EventsOnly.Name:
+		return EventsOnly, nil
+	}
+	if rest := strings.TrimPrefix(name, "last-"); rest != name {
+		n, err := strconv.ParseUint(rest, 10, 64)
+		if err == nil && n > 0 {
+			return LastN(n), nil
+		}
+	}
+	return Profile{}, errors.Errorf("replicate: unknown profile %q", name)
+}
+
+// Replicates returns true if the profile replicates the feed of author
+func (p Profile) Replicates(author refs.FeedRef) bool {
+	return p.Feeds == nil || p.Feeds(author)
+}
+
+// ApplyValidator sets the content policy of the profile on v
+func (p Profile) ApplyValidator(v *gabbygrove.Validator) {
+	v.WithContentPolicy(p.Content)
+}
+
+// ApplyScheduler makes s plan only the feeds the profile replicates
+func (p Profile) ApplyScheduler(s *Scheduler) {
+	s.WithFilter(p.Feeds)
+}
+
+// ContentStore is the part of a store.Store that Prune needs
+type ContentStore interface {
+	Tip(author refs.FeedRef) (gabbygrove.FeedState, error)
+	DeleteContent(author refs.FeedRef, seq uint64) error
+}
+
+// Prune deletes the content of the feed of author that the profile doesn't keep, starting after the sequence from.
+// It returns up to where it deleted, to pass as from the next time. Without KeepLast nothing is deleted.
+func (p Profile) Prune(s ContentStore, author refs.FeedRef, from uint64) (uint64, error) {
+	if p.KeepLast == 0 {
+		return from, nil
+	}
+	tip, err := s.Tip(author)
+	if err != nil {
+		return from, err
+	}
+	if tip.Sequence <= p.KeepLast {
+		return from, nil
+	}
+	until := tip.Sequence - p.KeepLast
+	for seq := from + 1; seq <= until; seq++ {
+		if err := s.DeleteContent(author, seq); err != nil {
+			return seq - 1, err
+		}
+	}
+	if until > from {
+		return until, nil
+	}
+	return from, nil
+}
@@ -0,0 +1,103 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package replicate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	"go.mindeco.de/ssb-gabbygrove/contacts"
+	"go.mindeco.de/ssb-gabbygrove/gabbygrovetest"
+)
+
+func TestParseProfile(t *testing.T) {
+	a := assert.New(t)
+	for _, name := range []string{"full", "events-only", "last-10"} {
+		p, err := ParseProfile(name)
+		if a.NoError(err, name) {
+			a.Equal(name, p.Name)
+		}
+	}
+	p, _ := ParseProfile("last-10")
+	a.EqualValues(10, p.KeepLast)
+	for _, name := range []string{"", "last-", "last-0", "last-x", "follows-only"} {
+		_, err := ParseProfile(name)
+		a.Error(err, name)
+	}
+}
+
+func TestProfileEventsOnly(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	feed := gabbygrovetest.Fixture(t, "alice", 3)
+	v := gabbygrove.NewValidator(0)
+	EventsOnly.ApplyValidator(v)
+	for _, tr := range feed.Messages() {
+		appended, err := v.Append(tr)
+		r.NoError(err)
+		r.Len(appended, 1)
+		a.Nil(appended[0].Content)
+	}
+}
+
+func TestProfileLastN(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	feed := gabbygrovetest.Fixture(t, "alice", 10)
+	s := openStore(t, feed.Messages())
+
+	p := LastN(3)
+	done, err := p.Prune(s, feed.Author(), 0)
+	r.NoError(err)
+	a.EqualValues(7, done)
+	for seq := uint64(1); seq <= 10; seq++ {
+		tr, err := s.Get(feed.Author(), seq)
+		r.NoError(err)
+		a.Equal(seq > 7, tr.Content != nil, "%d", seq)
+	}
+	done, err = p.Prune(s, feed.Author(), done)
+	r.NoError(err)
+	a.EqualValues(7, done)
+
+	done, err = Full.Prune(s, feed.Author(), 0)
+	r.NoError(err)
+	a.EqualValues(0, done)
+}
+
+func TestProfileFollowsOnly(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	alice := gabbygrovetest.NewFeedBuilder("alice")
+	bob := gabbygrovetest.NewFeedBuilder("bob")
+	carol := gabbygrovetest.NewFeedBuilder("carol")
+	g := contacts.New()
+	tr, err := alice.Publish(map[string]interface{}{"type": "contact", "contact": bob.Author().URI(), "following": true})
+	r.NoError(err)
+	r.NoError(g.ProcessMessage(tr))
+
+	p := FollowsOnly(g, alice.Author(), 1)
+	a.True(p.Replicates(alice.Author()))
+	a.True(p.Replicates(bob.Author()))
+	a.False(p.Replicates(carol.Author()))
+	a.True(Full.Replicates(carol.Author()))
+
+	s := NewScheduler()
+	p.ApplyScheduler(s)
+	plan := s.Plan("peer", []Have{{bob.Author(), 5}, {carol.Author(), 5}})
+	if a.Len(plan, 1) {
+		a.True(plan[0].Author.Equal(bob.Author()))
+	}
+
+	// the profile follows the graph
+	tr, err = alice.Publish(map[string]interface{}{"type": "contact", "contact": carol.Author().URI(), "following": true})
+	r.NoError(err)
+	r.NoError(g.ProcessMessage(tr))
+	a.Len(s.Plan("peer", []Have{{carol.Author(), 5}}), 1)
+}
@@ -0,0 +1,334 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+// Package replicate decides which messages to fetch from which peer.
+//
+// A Scheduler knows how far the local feeds are. Given how far a peer says its feeds are,
+// it plans requests for the ranges the peer is ahead, most important feeds first and within budgets,
+// and tracks them until they are done, so a feed isn't fetched from two peers at once
+// and its messages arrive in order.
+// How the messages are fetched is up to the caller, the states can be exchanged as a Vector.
+// A Session does all of it over a connection, and WriteWantList, WriteBundle and ImportBundle do it with files.
+// DetectBackfill finds the messages and the content that are missing locally.
+package replicate
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"time"
+
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// DefaultMessageSize is what the byte budget assumes for a message of a feed that nothing was received of yet
+const DefaultMessageSize = 1024
+
+// Have is how far a feed is known, locally or by a peer
+type Have struct {
+	Author   refs.FeedRef
+	Sequence uint64
+}
+
+// Request asks a peer for the messages of Author from From to To, both included
+type Request struct {
+	Peer     string
+	Author   refs.FeedRef
+	From, To uint64
+
+	// Started is when the request was planned
+	Started time.Time
+}
+
+// Messages returns how many messages the request is for
+func (r Request) Messages() uint64 {
+	return r.To - r.From + 1
+}
+
+// Scheduler plans and tracks the requests to the peers, it's safe for concurrent use
+type Scheduler struct {
+	mu sync.Mutex
+
+	// local are the sequences of the local feeds
+	local map[string]uint64
+
+	// inFlight are the requests that aren't done per peer and author
+	inFlight map[string]map[string]*inFlight
+
+	// sizes is the average message size per feed, as far as messages were received
+	sizes map[string]*average
+
+	priority func(refs.FeedRef) int
+	filter   func(refs.FeedRef) bool
+
+	maxRange    uint64
+	maxMessages uint64
+	maxBytes    int64
+}
+
+type inFlight struct {
+	Request
+
+	// next is the sequence that is expected next
+	next uint64
+}
+
+type average struct {
+	total int64
+	n     int64
+}
+
+// NewScheduler returns a scheduler with no local feeds and no limits
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		local:    make(map[string]uint64),
+		inFlight: make(map[string]map[string]*inFlight),
+		sizes:    make(map[string]*average),
+	}
+}
+
+// WithPriority sets which feeds are fetched first, the ones with the lower value.
+// Feeds of the same priority are ordered by how far behind they are, the furthest first.
+// Without it all feeds have the same priority.
+func (s *Scheduler) WithPriority(fn func(author refs.FeedRef) int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.priority = fn
+}
+
+// WithFilter makes Plan leave out the feeds fn returns false for, nil plans all of them
+func (s *Scheduler) WithFilter(fn func(author refs.FeedRef) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filter = fn
+}
+
+// WithRangeLimit limits a single request to n messages, the rest of the range is planned once it's done. Zero means no limit.
+func (s *Scheduler) WithRangeLimit(n uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxRange = n
+}
+
+// WithBudget limits the requests that are in flight to one peer to messages in total, zero means no limit.
+// If maxBytes is positive it limits them to that many bytes as well,
+// estimated from the average size of the messages received of each feed so far, or DefaultMessageSize.
+// The first request to a peer is always at least one message, also if it's estimated to be larger.
+func (s *Scheduler) WithBudget(messages uint64, maxBytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxMessages = messages
+	s.maxBytes = maxBytes
+}
+
+// SetLocal sets how far the local feed of author is, i.e. after loading it from a store
+func (s *Scheduler) SetLocal(author refs.FeedRef, seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.local[author.String()] = seq
+}
+
+// Local returns how far the local feed of author is
+func (s *Scheduler) Local(author refs.FeedRef) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.local[author.String()]
+}
+
+// Plan returns the requests to send to peer, given how far it says its feeds are.
+// They are tracked as in flight until Received, Done or Cancel finishes them.
+// Feeds that are in flight to any peer are left out, and the requests to peer stay within its budget.
+func (s *Scheduler) Plan(peer string, remote []Have) []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type candidate struct {
+		Have
+		key      string
+		from     uint64
+		priority int
+	}
+	var candidates []candidate
+	for _, h := range remote {
+		key := h.Author.String()
+		from := s.local[key] + 1
+		if h.Sequence < from || s.busy(key) || (s.filter != nil && !s.filter(h.Author)) {
+			continue
+		}
+		c := candidate{Have: h, key: key, from: from}
+		if s.priority != nil {
+			c.priority = s.priority(h.Author)
+		}
+		candidates = append(candidates, c)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		ci, cj := candidates[i], candidates[j]
+		if ci.priority != cj.priority {
+			return ci.priority < cj.priority
+		}
+		if li, lj := ci.Sequence-ci.from, cj.Sequence-cj.from; li != lj {
+			return li > lj
+		}
+		return bytes.Compare(ci.Author.PubKey(), cj.Author.PubKey()) < 0
+	})
+
+	reqs := s.inFlight[peer]
+	if reqs == nil {
+		reqs = make(map[string]*inFlight)
+		s.inFlight[peer] = reqs
+	}
+	var (
+		messages uint64
+		size     int64
+	)
+	for _, r := range reqs {
+		messages += r.To - r.next + 1
+		size += int64(r.To-r.next+1) * s.size(r.Author.String())
+	}
+
+	var planned []Request
+	now := time.Now()
+	for _, c := range candidates {
+		n := c.Sequence - c.from + 1
+		if s.maxRange > 0 && n > s.maxRange {
+			n = s.maxRange
+		}
+		if s.maxMessages > 0 {
+			if messages >= s.maxMessages {
+				break
+			}
+			if left := s.maxMessages - messages; n > left {
+				n = left
+			}
+		}
+		if s.maxBytes > 0 {
+			msgSize := s.size(c.key)
+			fit := (s.maxBytes - size) / msgSize
+			if fit < 1 {
+				if len(reqs) > 0 {
+					continue
+				}
+				fit = 1
+			}
+			if uint64(fit) < n {
+				n = uint64(fit)
+			}
+			size += int64(n) * msgSize
+		}
+		messages += n
+
+		r := Request{Peer: peer, Author: c.Author, From: c.from, To: c.from + n - 1, Started: now}
+		reqs[c.key] = &inFlight{Request: r, next: r.From}
+		planned = append(planned, r)
+	}
+	return planned
+}
+
+// busy returns true if the feed is in flight to any peer, s.mu needs to be held
+func (s *Scheduler) busy(key string) bool {
+	for _, reqs := range s.inFlight {
+		if _, has := reqs[key]; has {
+			return true
+		}
+	}
+	return false
+}
+
+// size returns the estimated size of a message of the feed, s.mu needs to be held
+func (s *Scheduler) size(key string) int64 {
+	avg, has := s.sizes[key]
+	if !has || avg.n == 0 {
+		return DefaultMessageSize
+	}
+	if size := avg.total / avg.n; size > 0 {
+		return size
+	}
+	return 1
+}
+
+// Received records that peer sent the message of author at seq, which was size bytes and is stored now.
+// It advances the local feed and finishes the request to peer once its last message arrived.
+// Messages that weren't requested still advance the local feed if they are the next one.
+func (s *Scheduler) Received(peer string, author refs.FeedRef, seq uint64, size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := author.String()
+	if seq == s.local[key]+1 {
+		s.local[key] = seq
+	}
+	avg := s.sizes[key]
+	if avg == nil {
+		avg = &average{}
+		s.sizes[key] = avg
+	}
+	avg.total += int64(size)
+	avg.n++
+
+	r, has := s.inFlight[peer][key]
+	if !has || seq < r.next {
+		return
+	}
+	r.next = seq + 1
+	if r.next > r.To {
+		delete(s.inFlight[peer], key)
+	}
+}
+
+// Done finishes the request for author to peer, i.e. because the peer sent all it had or failed it.
+// What of it wasn't received can be planned again.
+func (s *Scheduler) Done(peer string, author refs.FeedRef) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.inFlight[peer], author.String())
+}
+
+// Cancel finishes all requests to peer, i.e. because it disconnected
+func (s *Scheduler) Cancel(peer string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.inFlight, peer)
+}
+
+// Expire finishes and returns the requests that were started before t, so they can be planned with another peer
+func (s *Scheduler) Expire(t time.Time) []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired []Request
+	for _, reqs := range s.inFlight {
+		for key, r := range reqs {
+			if r.Started.Before(t) {
+				expired = append(expired, r.Request)
+				delete(reqs, key)
+			}
+		}
+	}
+	sortRequests(expired)
+	return expired
+}
+
+// InFlight returns what is still expected of the requests to peer, ordered by author
+func (s *Scheduler) InFlight(peer string) []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var reqs []Request
+	for _, r := range s.inFlight[peer] {
+		req := r.Request
+		req.From = r.next
+		reqs = append(reqs, req)
+	}
+	sortRequests(reqs)
+	return reqs
+}
+
+func sortRequests(reqs []Request) {
+	sort.Slice(reqs, func(i, j int) bool {
+		if reqs[i].Peer != reqs[j].Peer {
+			return reqs[i].Peer < reqs[j].Peer
+		}
+		return bytes.Compare(reqs[i].Author.PubKey(), reqs[j].Author.PubKey()) < 0
+	})
+}
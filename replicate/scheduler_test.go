@@ -0,0 +1,127 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package replicate
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+func testFeed(t *testing.T, b byte) refs.FeedRef {
+	fr, err := refs.NewFeedRefFromBytes(bytes.Repeat([]byte{b}, 32), refs.RefAlgoFeedGabby)
+	require.NoError(t, err)
+	return fr
+}
+
+func TestSchedulerPlan(t *testing.T) {
+	a := assert.New(t)
+
+	alice, bob, carol := testFeed(t, 1), testFeed(t, 2), testFeed(t, 3)
+
+	s := NewScheduler()
+	s.SetLocal(alice, 10)
+	s.SetLocal(bob, 3)
+
+	remote := []Have{{alice, 12}, {bob, 3}, {carol, 20}}
+	plan := s.Plan("peer1", remote)
+	if a.Len(plan, 2) {
+		// carol is further behind
+		a.True(plan[0].Author.Equal(carol))
+		a.EqualValues(1, plan[0].From)
+		a.EqualValues(20, plan[0].To)
+		a.True(plan[1].Author.Equal(alice))
+		a.EqualValues(11, plan[1].From)
+		a.EqualValues(2, plan[1].Messages())
+	}
+
+	// nothing is fetched twice
+	a.Empty(s.Plan("peer2", remote))
+
+	s.Received("peer1", alice, 11, 100)
+	a.EqualValues(11, s.Local(alice))
+	inFlight := s.InFlight("peer1")
+	if a.Len(inFlight, 2) {
+		a.True(inFlight[0].Author.Equal(alice))
+		a.EqualValues(12, inFlight[0].From)
+	}
+	s.Received("peer1", alice, 12, 100)
+	a.Len(s.InFlight("peer1"), 1)
+
+	// a failed request can go to another peer
+	s.Received("peer1", carol, 1, 100)
+	s.Done("peer1", carol)
+	plan = s.Plan("peer2", []Have{{carol, 25}})
+	if a.Len(plan, 1) {
+		a.EqualValues(2, plan[0].From)
+		a.EqualValues(25, plan[0].To)
+	}
+
+	s.Cancel("peer2")
+	a.Empty(s.InFlight("peer2"))
+	plan = s.Plan("peer3", []Have{{carol, 25}})
+	a.Len(plan, 1)
+	expired := s.Expire(time.Now().Add(time.Minute))
+	a.Equal(plan, expired)
+	a.Empty(s.InFlight("peer3"))
+}
+
+func TestSchedulerBudgets(t *testing.T) {
+	a := assert.New(t)
+
+	alice, bob, carol := testFeed(t, 1), testFeed(t, 2), testFeed(t, 3)
+	remote := []Have{{alice, 100}, {bob, 50}, {carol, 10}}
+
+	s := NewScheduler()
+	s.WithRangeLimit(30)
+	s.WithBudget(40, 0)
+	s.WithPriority(func(author refs.FeedRef) int {
+		if author.Equal(carol) {
+			return 0
+		}
+		return 1
+	})
+
+	plan := s.Plan("peer", remote)
+	if a.Len(plan, 2) {
+		a.True(plan[0].Author.Equal(carol))
+		a.EqualValues(10, plan[0].Messages())
+		a.True(plan[1].Author.Equal(alice))
+		a.EqualValues(30, plan[1].Messages())
+	}
+	a.Empty(s.Plan("peer", remote), "budget is used up")
+
+	for seq := uint64(1); seq <= 10; seq++ {
+		s.Received("peer", carol, seq, 2000)
+	}
+	plan = s.Plan("peer", remote)
+	if a.Len(plan, 1) {
+		a.True(plan[0].Author.Equal(bob))
+		a.EqualValues(10, plan[0].Messages())
+	}
+
+	// bytes are estimated from what was received
+	s = NewScheduler()
+	s.WithBudget(0, 10*1024)
+	s.Received("peer", carol, 1, 4096)
+	plan = s.Plan("peer", []Have{{carol, 10}})
+	if a.Len(plan, 1) {
+		a.EqualValues(2, plan[0].Messages())
+	}
+	plan = s.Plan("peer", []Have{{alice, 10}})
+	if a.Len(plan, 1) {
+		a.EqualValues(2, plan[0].Messages(), "default size")
+	}
+	a.Empty(s.Plan("peer", []Have{{bob, 10}}))
+
+	// but one message always goes
+	s = NewScheduler()
+	s.WithBudget(0, 10)
+	a.Len(s.Plan("peer", []Have{{alice, 10}}), 1)
+}
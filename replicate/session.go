@@ -0,0 +1,505 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package replicate
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// ErrProtocol is returned by Session.Run if the peer doesn't follow the protocol
+var ErrProtocol = errors.New("replicate: protocol violation")
+
+// Feeds is what a Session syncs, i.e. a store.Store
+type Feeds interface {
+	Get(author refs.FeedRef, seq uint64) (*gabbygrove.Transfer, error)
+	Append(tr *gabbygrove.Transfer) error
+}
+
+// DefaultWindow is how many transfers a Session sends before it waits for them to be acknowledged
+const DefaultWindow = 64
+
+// SessionState is where a Session is in the protocol
+type SessionState int
+
+const (
+	// StateInit is the state before Run
+	StateInit SessionState = iota
+
+	// StateExchange is while the hellos and vectors are exchanged
+	StateExchange
+
+	// StateStreaming is while transfers and acknowledgements are exchanged
+	StateStreaming
+
+	// StateDone is after both sides sent all they had and it was acknowledged
+	StateDone
+
+	// StateFailed is after Run returned an error
+	StateFailed
+)
+
+func (st SessionState) String() string {
+	switch st {
+	case StateInit:
+		return "init"
+	case StateExchange:
+		return "exchange"
+	case StateStreaming:
+		return "streaming"
+	case StateDone:
+		return "done"
+	case StateFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// The frames of the protocol, each is its type, the uvarint length of the payload and the payload
+const (
+	// frameHello starts a session, its payload is sessionMagic and the version
+	frameHello byte = iota + 1
+
+	// frameVector is the Vector of the sender, it's the second frame
+	frameVector
+
+	// frameTransfer is one encoded transfer
+	frameTransfer
+
+	// frameAck holds the changes to the vector of the sender after it appended transfers
+	frameAck
+
+	// frameDone says the sender has nothing more to send and everything it sent was acknowledged
+	frameDone
+)
+
+const (
+	sessionMagic   = "gabbygrove/sync"
+	sessionVersion = 1
+
+	// maxFrameSize fits a vector of MaxVectorFeeds
+	maxFrameSize = 4 << 20
+)
+
+// Session syncs feeds with a peer over a connection, the same on both ends.
+//
+// Both sides say hello and send their Vector of the feeds they have and want. Then each sends the messages
+// the other wants and doesn't have, as far as Feeds has them, and acknowledges the messages it appended
+// with the changes to its vector. The unacknowledged transfers in flight are limited by the window.
+// Once a side has nothing more to send and everything was acknowledged it says it's done,
+// and the session ends when both are.
+//
+// An interrupted session can be resumed with a new one from the vectors of the stored feeds,
+// nothing that was acknowledged is sent again.
+type Session struct {
+	conn   io.ReadWriter
+	w      *bufio.Writer
+	feeds  Feeds
+	window int
+
+	mu     sync.Mutex
+	state  SessionState
+	local  Vector
+	remote Vector
+}
+
+// NewSession returns a session over conn that sends and appends to feeds.
+// local are the notes of the feeds that are synced, how far feeds has them and if they should be received.
+func NewSession(conn io.ReadWriter, feeds Feeds, local Vector) *Session {
+	own := make(Vector, len(local))
+	own.Update(local)
+	return &Session{
+		conn:   conn,
+		w:      bufio.NewWriter(conn),
+		feeds:  feeds,
+		window: DefaultWindow,
+		local:  own,
+		remote: make(Vector),
+	}
+}
+
+// WithWindow sets how many transfers are sent before waiting for their acknowledgement, it needs to be set before Run
+func (s *Session) WithWindow(n int) {
+	if n < 1 {
+		n = 1
+	}
+	s.window = n
+}
+
+// State returns where the session is
+func (s *Session) State() SessionState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// Local returns the local notes, which include what was appended during the session
+func (s *Session) Local() Vector {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := make(Vector, len(s.local))
+	v.Update(s.local)
+	return v
+}
+
+// Remote returns the notes of the peer, which include what it acknowledged
+func (s *Session) Remote() Vector {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := make(Vector, len(s.remote))
+	v.Update(s.remote)
+	return v
+}
+
+func (s *Session) setState(st SessionState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = st
+}
+
+// Run syncs until both sides are done, the peer fails or ctx is canceled.
+// The connection isn't closed and is read from until it is, so it needs to be closed after Run,
+// which also stops a session that is blocked writing to the peer.
+func (s *Session) Run(ctx context.Context) error {
+	if s.State() != StateInit {
+		return errors.Errorf("replicate: session already ran")
+	}
+	err := s.run(ctx)
+	if err != nil {
+		s.setState(StateFailed)
+		return err
+	}
+	s.setState(StateDone)
+	return nil
+}
+
+type frame struct {
+	typ     byte
+	payload []byte
+	err     error
+}
+
+func (s *Session) run(ctx context.Context) error {
+	s.setState(StateExchange)
+
+	// reading starts first, both sides write before they read
+	frames := &frameQueue{signal: make(chan struct{}, 1)}
+	go frames.read(s.conn)
+	next := func() (frame, error) {
+		return frames.pop(ctx)
+	}
+
+	hello := append([]byte(sessionMagic), sessionVersion)
+	if err := s.writeFrame(frameHello, hello); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	vec, err := s.local.MarshalCBOR()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := s.writeFrame(frameVector, vec); err != nil {
+		return err
+	}
+	if err := s.w.Flush(); err != nil {
+		return errors.Wrap(err, "replicate: failed to send")
+	}
+
+	f, err := next()
+	if err != nil {
+		return err
+	}
+	if f.typ != frameHello || string(f.payload) != string(hello) {
+		return errors.Wrap(ErrProtocol, "expected hello")
+	}
+	if f, err = next(); err != nil {
+		return err
+	}
+	if f.typ != frameVector {
+		return errors.Wrap(ErrProtocol, "expected vector")
+	}
+	var remote Vector
+	if err := remote.UnmarshalCBOR(f.payload); err != nil {
+		return errors.Wrap(ErrProtocol, err.Error())
+	}
+	s.mu.Lock()
+	s.remote = remote
+	queue := newSendQueue(ToSend(s.local, remote), s.local)
+	s.mu.Unlock()
+	s.setState(StateStreaming)
+
+	var sentDone, gotDone bool
+	for {
+		for !queue.empty() && s.unacked(queue) < s.window {
+			tr, err := queue.next(s.feeds)
+			if err != nil {
+				return err
+			}
+			data, err := tr.MarshalCBOR()
+			if err != nil {
+				return errors.Wrap(err, "replicate: failed to encode transfer")
+			}
+			if err := s.writeFrame(frameTransfer, data); err != nil {
+				return err
+			}
+		}
+		if !sentDone && queue.empty() && s.unacked(queue) == 0 {
+			if err := s.writeFrame(frameDone, nil); err != nil {
+				return err
+			}
+			sentDone = true
+		}
+		if err := s.w.Flush(); err != nil {
+			return errors.Wrap(err, "replicate: failed to send")
+		}
+		if sentDone && gotDone {
+			return nil
+		}
+
+		f, err := next()
+		if err != nil {
+			return err
+		}
+		switch f.typ {
+		case frameTransfer:
+			if gotDone {
+				return errors.Wrap(ErrProtocol, "transfer after done")
+			}
+			changes, err := s.receive(f.payload)
+			if err != nil {
+				return err
+			}
+			if len(changes) > 0 {
+				ack, err := changes.MarshalCBOR()
+				if err != nil {
+					return err
+				}
+				if err := s.writeFrame(frameAck, ack); err != nil {
+					return err
+				}
+			}
+		case frameAck:
+			var changes Vector
+			if err := changes.UnmarshalCBOR(f.payload); err != nil {
+				return errors.Wrap(ErrProtocol, err.Error())
+			}
+			s.mu.Lock()
+			s.remote.Update(changes)
+			s.mu.Unlock()
+		case frameDone:
+			if gotDone {
+				return errors.Wrap(ErrProtocol, "done twice")
+			}
+			gotDone = true
+		default:
+			return errors.Wrapf(ErrProtocol, "unexpected frame %d", f.typ)
+		}
+	}
+}
+
+// decodeTransfer decodes a transfer sent by the peer together with its author and sequence.
+// The event isn't trusted yet, anything that can't be decoded is a protocol violation.
+func decodeTransfer(data []byte) (*gabbygrove.Transfer, refs.FeedRef, uint64, error) {
+	var tr gabbygrove.Transfer
+	if err := tr.UnmarshalCBOR(data); err != nil {
+		return nil, refs.FeedRef{}, 0, errors.Wrap(ErrProtocol, err.Error())
+	}
+	evt, err := tr.UnmarshaledEvent()
+	if err != nil {
+		return nil, refs.FeedRef{}, 0, errors.Wrapf(ErrProtocol, "invalid event: %s", err)
+	}
+	aref, err := evt.Author.GetRef(gabbygrove.RefTypeFeed)
+	if err != nil {
+		return nil, refs.FeedRef{}, 0, errors.Wrapf(ErrProtocol, "invalid author: %s", err)
+	}
+	return &tr, aref.(refs.FeedRef), evt.Sequence, nil
+}
+
+// receive appends the transfer and returns the changes to the local vector
+func (s *Session) receive(data []byte) (Vector, error) {
+	tr, author, seq, err := decodeTransfer(data)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	note, has := s.local.Get(author)
+	s.mu.Unlock()
+	if !has || !note.Replicate || !note.Receive {
+		return nil, errors.Wrapf(ErrProtocol, "feed %s wasn't asked for", author.ShortSigil())
+	}
+	if seq <= note.Sequence {
+		// already appended, i.e. sent again after an interrupted session
+		return nil, nil
+	}
+	if err := s.feeds.Append(tr); err != nil {
+		return nil, errors.Wrapf(err, "replicate: failed to append %d of %s", seq, author.ShortSigil())
+	}
+	note.Sequence = seq
+
+	changes := make(Vector)
+	if err := changes.Set(author, note); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.local.Update(changes)
+	s.mu.Unlock()
+	return changes, nil
+}
+
+// unacked returns how many sent transfers the peer didn't acknowledge yet
+func (s *Session) unacked(q *sendQueue) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n uint64
+	for key, sent := range q.sent {
+		if acked := s.remote[key].Sequence; sent > acked {
+			n += sent - acked
+		}
+	}
+	return int(n)
+}
+
+func (s *Session) writeFrame(typ byte, payload []byte) error {
+	return writeFrame(s.w, typ, payload)
+}
+
+func writeFrame(w io.Writer, typ byte, payload []byte) error {
+	var head [1 + binary.MaxVarintLen64]byte
+	head[0] = typ
+	n := binary.PutUvarint(head[1:], uint64(len(payload)))
+	if _, err := w.Write(head[:1+n]); err != nil {
+		return errors.Wrap(err, "replicate: failed to send")
+	}
+	if _, err := w.Write(payload); err != nil {
+		return errors.Wrap(err, "replicate: failed to send")
+	}
+	return nil
+}
+
+// maxQueuedFrames bounds the frames that are received but not handled yet.
+// The windows of both sides keep them far below, a peer that sends more doesn't wait for acknowledgements.
+const maxQueuedFrames = 1 << 12
+
+// frameQueue holds the received frames, so reading never waits for the handling of frames.
+// Otherwise two sessions that both send could block each other.
+type frameQueue struct {
+	mu     sync.Mutex
+	frames []frame
+	signal chan struct{}
+}
+
+// read queues the frames of conn until it fails
+func (q *frameQueue) read(conn io.Reader) {
+	rd := bufio.NewReader(conn)
+	for {
+		f := readFrame(rd)
+		q.mu.Lock()
+		if len(q.frames) >= maxQueuedFrames {
+			f = frame{err: errors.Wrap(ErrProtocol, "too many frames in flight")}
+		}
+		q.frames = append(q.frames, f)
+		q.mu.Unlock()
+		select {
+		case q.signal <- struct{}{}:
+		default:
+		}
+		if f.err != nil {
+			return
+		}
+	}
+}
+
+// pop returns the next frame or its error
+func (q *frameQueue) pop(ctx context.Context) (frame, error) {
+	for {
+		q.mu.Lock()
+		if len(q.frames) > 0 {
+			f := q.frames[0]
+			if f.err == nil {
+				q.frames = q.frames[1:]
+			}
+			q.mu.Unlock()
+			return f, f.err
+		}
+		q.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return frame{}, ctx.Err()
+		case <-q.signal:
+		}
+	}
+}
+
+func readFrame(rd *bufio.Reader) frame {
+	typ, err := rd.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return frame{err: errors.Wrap(err, "replicate: failed to receive")}
+	}
+	n, err := binary.ReadUvarint(rd)
+	if err != nil {
+		return frame{err: errors.Wrap(err, "replicate: failed to receive")}
+	}
+	if n > maxFrameSize {
+		return frame{err: errors.Wrapf(ErrProtocol, "frame of %d bytes", n)}
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(rd, payload); err != nil {
+		return frame{err: errors.Wrap(err, "replicate: failed to receive")}
+	}
+	return frame{typ: typ, payload: payload}
+}
+
+// sendQueue walks through the messages to send, feed by feed
+type sendQueue struct {
+	feeds []sendRange
+
+	// sent is the last sequence sent per feed
+	sent map[string]uint64
+}
+
+type sendRange struct {
+	author    refs.FeedRef
+	next, end uint64
+}
+
+func newSendQueue(haves []Have, local Vector) *sendQueue {
+	q := &sendQueue{sent: make(map[string]uint64)}
+	for _, h := range haves {
+		n, _ := local.Get(h.Author)
+		q.feeds = append(q.feeds, sendRange{author: h.Author, next: h.Sequence + 1, end: n.Sequence})
+	}
+	return q
+}
+
+func (q *sendQueue) empty() bool {
+	return len(q.feeds) == 0
+}
+
+// next returns the next transfer to send
+func (q *sendQueue) next(feeds Feeds) (*gabbygrove.Transfer, error) {
+	r := &q.feeds[0]
+	tr, err := feeds.Get(r.author, r.next)
+	if err != nil {
+		return nil, errors.Wrapf(err, "replicate: failed to get %d of %s", r.next, r.author.ShortSigil())
+	}
+	q.sent[string(r.author.PubKey())] = r.next
+	r.next++
+	if r.next > r.end {
+		q.feeds = q.feeds[1:]
+	}
+	return tr, nil
+}
@@ -0,0 +1,227 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package replicate
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	"go.mindeco.de/ssb-gabbygrove/gabbygrovetest"
+	"go.mindeco.de/ssb-gabbygrove/store"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+func openStore(t *testing.T, feeds ...[]*gabbygrove.Transfer) *store.Store {
+	dir, err := ioutil.TempDir("", "replicate")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	s, err := store.Open(dir)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	for _, trs := range feeds {
+		for _, tr := range trs {
+			require.NoError(t, s.Append(tr))
+		}
+	}
+	return s
+}
+
+// vectorOf notes how far s has authors and that they should be received
+func vectorOf(t *testing.T, s *store.Store, authors ...refs.FeedRef) Vector {
+	v := make(Vector)
+	for _, author := range authors {
+		tip, err := s.Tip(author)
+		require.NoError(t, err)
+		require.NoError(t, v.Set(author, Note{Replicate: true, Receive: true, Sequence: tip.Sequence}))
+	}
+	return v
+}
+
+// runPair runs two sessions against each other and returns their errors
+func runPair(a, b *Session, ca, cb net.Conn) (error, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- b.Run(ctx)
+		cb.Close()
+	}()
+	errA := a.Run(ctx)
+	ca.Close()
+	return errA, <-errs
+}
+
+func TestSession(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	alice := gabbygrovetest.Fixture(t, "alice", 150)
+	bob := gabbygrovetest.Fixture(t, "bob", 20)
+	carol := gabbygrovetest.Fixture(t, "carol", 5)
+
+	// one side has all of alice and a bit of bob, the other the rest
+	one := openStore(t, alice.Messages(), bob.Messages()[:3])
+	other := openStore(t, alice.Messages()[:40], bob.Messages(), carol.Messages())
+	authors := []refs.FeedRef{alice.Author(), bob.Author()}
+
+	c1, c2 := net.Pipe()
+	s1 := NewSession(c1, one, vectorOf(t, one, authors...))
+	s1.WithWindow(8)
+	s2 := NewSession(c2, other, vectorOf(t, other, append(authors, carol.Author())...))
+	a.Equal(StateInit, s1.State())
+
+	err1, err2 := runPair(s1, s2, c1, c2)
+	r.NoError(err1)
+	r.NoError(err2)
+	a.Equal(StateDone, s1.State())
+	a.Equal(StateDone, s2.State())
+	a.Error(s1.Run(context.Background()), "only once")
+
+	for _, s := range []*store.Store{one, other} {
+		for _, b := range []*gabbygrovetest.FeedBuilder{alice, bob} {
+			tip, err := s.Tip(b.Author())
+			r.NoError(err)
+			a.Equal(b.State().Sequence, tip.Sequence)
+		}
+	}
+	// the first side didn't ask for carol
+	tip, err := one.Tip(carol.Author())
+	r.NoError(err)
+	a.EqualValues(0, tip.Sequence)
+
+	n, _ := s2.Local().Get(alice.Author())
+	a.EqualValues(150, n.Sequence)
+	n, _ = s1.Remote().Get(alice.Author())
+	a.EqualValues(150, n.Sequence, "acknowledged")
+
+	// nothing to do the second time
+	c1, c2 = net.Pipe()
+	s1 = NewSession(c1, one, vectorOf(t, one, authors...))
+	s2 = NewSession(c2, other, vectorOf(t, other, authors...))
+	err1, err2 = runPair(s1, s2, c1, c2)
+	r.NoError(err1)
+	r.NoError(err2)
+}
+
+// cutConn fails writes after limit bytes
+type cutConn struct {
+	net.Conn
+	limit int
+}
+
+func (c *cutConn) Write(p []byte) (int, error) {
+	if len(p) > c.limit {
+		n, _ := c.Conn.Write(p[:c.limit])
+		c.limit = 0
+		c.Conn.Close()
+		return n, errors.New("cut")
+	}
+	c.limit -= len(p)
+	return c.Conn.Write(p)
+}
+
+func TestSessionResume(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	alice := gabbygrovetest.Fixture(t, "alice", 100)
+	one := openStore(t, alice.Messages())
+	other := openStore(t)
+
+	c1, c2 := net.Pipe()
+	s1 := NewSession(&cutConn{Conn: c1, limit: 20 * 1024}, one, vectorOf(t, one, alice.Author()))
+	s1.WithWindow(4)
+	s2 := NewSession(c2, other, vectorOf(t, other, alice.Author()))
+	err1, err2 := runPair(s1, s2, c1, c2)
+	a.Error(err1)
+	a.Error(err2)
+	a.Equal(StateFailed, s1.State())
+
+	tip, err := other.Tip(alice.Author())
+	r.NoError(err)
+	a.True(tip.Sequence > 0 && tip.Sequence < 100, "got %d", tip.Sequence)
+
+	// a new session picks up where the stores are
+	c1, c2 = net.Pipe()
+	s1 = NewSession(c1, one, vectorOf(t, one, alice.Author()))
+	s2 = NewSession(c2, other, vectorOf(t, other, alice.Author()))
+	err1, err2 = runPair(s1, s2, c1, c2)
+	r.NoError(err1)
+	r.NoError(err2)
+	tip, err = other.Tip(alice.Author())
+	r.NoError(err)
+	a.EqualValues(100, tip.Sequence)
+}
+
+// scripted is a peer that sends what's in its buffer and ignores what it gets
+type scripted struct {
+	io.Reader
+	io.Writer
+}
+
+func writeFrameTo(buf *bytes.Buffer, typ byte, payload []byte) {
+	var head [binary.MaxVarintLen64]byte
+	buf.WriteByte(typ)
+	buf.Write(head[:binary.PutUvarint(head[:], uint64(len(payload)))])
+	buf.Write(payload)
+}
+
+func TestSessionProtocol(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	alice := gabbygrovetest.Fixture(t, "alice", 1)
+	hello := append([]byte(sessionMagic), sessionVersion)
+	empty, err := make(Vector).MarshalCBOR()
+	r.NoError(err)
+	msg, err := alice.Messages()[0].MarshalCBOR()
+	r.NoError(err)
+	truncated := *alice.Messages()[0]
+	truncated.Event = truncated.Event[:len(truncated.Event)/2]
+	garbage, err := truncated.MarshalCBOR()
+	r.NoError(err)
+
+	for name, tc := range map[string]struct {
+		frames   [][]byte
+		ok       bool
+		protocol bool
+	}{
+		"done":         {frames: [][]byte{{frameHello}, hello, {frameVector}, empty, {frameDone}, nil}, ok: true},
+		"nothing":      {},
+		"no hello":     {frames: [][]byte{{frameVector}, empty}, protocol: true},
+		"old version":  {frames: [][]byte{{frameHello}, []byte(sessionMagic + "\x00")}, protocol: true},
+		"wrong vector": {frames: [][]byte{{frameHello}, hello, {frameVector}, {0x80}}, protocol: true},
+		"not asked":    {frames: [][]byte{{frameHello}, hello, {frameVector}, empty, {frameTransfer}, msg}, protocol: true},
+		"garbage":      {frames: [][]byte{{frameHello}, hello, {frameVector}, empty, {frameTransfer}, garbage}, protocol: true},
+		"unknown":      {frames: [][]byte{{frameHello}, hello, {frameVector}, empty, {0x42}, nil}, protocol: true},
+	} {
+		var peer bytes.Buffer
+		for i := 0; i < len(tc.frames); i += 2 {
+			writeFrameTo(&peer, tc.frames[i][0], tc.frames[i+1])
+		}
+		s := NewSession(scripted{&peer, ioutil.Discard}, openStore(t), make(Vector))
+		err := s.Run(context.Background())
+		if tc.ok {
+			a.NoError(err, name)
+			a.Equal(StateDone, s.State())
+			continue
+		}
+		if a.Error(err, name) && tc.protocol {
+			a.Equal(ErrProtocol, errors.Cause(err), name)
+		}
+		a.Equal(StateFailed, s.State())
+	}
+}
@@ -0,0 +1,274 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package replicate
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// MaxVectorFeeds is the most feeds UnmarshalCBOR accepts in one vector
+const MaxVectorFeeds = 1 << 16
+
+// Note is what a peer says about one feed, like the notes of epidemic broadcast trees (EBT)
+type Note struct {
+	// Replicate is false if the peer doesn't replicate the feed (anymore), the other fields are unset then
+	Replicate bool
+
+	// Receive is false if the peer doesn't want new messages of the feed from us, only to know how far we are
+	Receive bool
+
+	// Sequence is how far the peer has the feed
+	Sequence uint64
+}
+
+// noteSeqLimit is the largest sequence a note can hold, the lower bit of the encoding is the receive flag
+const noteSeqLimit = math.MaxInt64 >> 1
+
+// Int returns the note encoded like in EBT: -1 if it doesn't replicate,
+// otherwise the sequence shifted left by one with the lowest bit set if it doesn't receive
+func (n Note) Int() int64 {
+	if !n.Replicate {
+		return -1
+	}
+	v := int64(n.Sequence) << 1
+	if !n.Receive {
+		v |= 1
+	}
+	return v
+}
+
+// NoteFromInt decodes what Int returns, negative values don't replicate
+func NoteFromInt(v int64) Note {
+	if v < 0 {
+		return Note{}
+	}
+	return Note{Replicate: true, Receive: v&1 == 0, Sequence: uint64(v >> 1)}
+}
+
+// Vector holds the notes of many feeds, keyed by the public key of their author.
+// It's encoded as a CBOR map from the 32 bytes of the public key to the note as a number, see Note.Int.
+type Vector map[string]Note
+
+// Set sets the note of author, which needs to be a gabbygrove feed
+func (v Vector) Set(author refs.FeedRef, n Note) error {
+	if author.Algo() != refs.RefAlgoFeedGabby {
+		return errors.Errorf("replicate: not a gabbygrove feed: %s", author.Algo())
+	}
+	if n.Sequence > noteSeqLimit {
+		return errors.Errorf("replicate: sequence too large: %d", n.Sequence)
+	}
+	if !n.Replicate {
+		n = Note{}
+	}
+	v[string(author.PubKey())] = n
+	return nil
+}
+
+// Get returns the note of author, if the vector has one
+func (v Vector) Get(author refs.FeedRef) (Note, bool) {
+	n, has := v[string(author.PubKey())]
+	return n, has
+}
+
+// Authors returns the feeds of the vector, ordered by public key
+func (v Vector) Authors() []refs.FeedRef {
+	keys := v.sortedKeys()
+	authors := make([]refs.FeedRef, 0, len(keys))
+	for _, k := range keys {
+		authors = append(authors, authorOf(k))
+	}
+	return authors
+}
+
+func (v Vector) sortedKeys() []string {
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func authorOf(key string) refs.FeedRef {
+	fr, err := refs.NewFeedRefFromBytes([]byte(key), refs.RefAlgoFeedGabby)
+	if err != nil {
+		// keys are checked by Set and UnmarshalCBOR
+		panic(err)
+	}
+	return fr
+}
+
+// Haves returns how far the feeds that are replicated are, for Scheduler.Plan
+func (v Vector) Haves() []Have {
+	var haves []Have
+	for _, k := range v.sortedKeys() {
+		if n := v[k]; n.Replicate {
+			haves = append(haves, Have{Author: authorOf(k), Sequence: n.Sequence})
+		}
+	}
+	return haves
+}
+
+// Changes returns the notes of to that are new or differ from the ones in from, which is what needs to be sent
+// to a peer that has from already. Feeds that are only in from are noted as not replicated anymore.
+func Changes(from, to Vector) Vector {
+	changes := make(Vector)
+	for k, n := range to {
+		if old, has := from[k]; !has || old != n {
+			changes[k] = n
+		}
+	}
+	for k := range from {
+		if _, has := to[k]; !has {
+			changes[k] = Note{}
+		}
+	}
+	return changes
+}
+
+// Update applies the changes a peer sent, see Changes
+func (v Vector) Update(changes Vector) {
+	for k, n := range changes {
+		v[k] = n
+	}
+}
+
+// ToSend returns the feeds that local is ahead of remote on and that remote wants to receive,
+// each with the sequence remote has. The messages after it are the ones to send.
+func ToSend(local, remote Vector) []Have {
+	var haves []Have
+	for _, k := range remote.sortedKeys() {
+		rn, ln := remote[k], local[k]
+		if !rn.Replicate || !rn.Receive || !ln.Replicate || ln.Sequence <= rn.Sequence {
+			continue
+		}
+		haves = append(haves, Have{Author: authorOf(k), Sequence: rn.Sequence})
+	}
+	return haves
+}
+
+const (
+	cborMajorUint   = 0
+	cborMajorNegInt = 1
+	cborMajorBytes  = 2
+	cborMajorMap    = 5
+)
+
+// MarshalCBOR encodes the vector canonically, with the keys in order
+func (v Vector) MarshalCBOR() ([]byte, error) {
+	buf := appendHead(nil, cborMajorMap, uint64(len(v)))
+	for _, k := range v.sortedKeys() {
+		if len(k) != 32 {
+			return nil, errors.Errorf("replicate: invalid key length %d", len(k))
+		}
+		buf = appendHead(buf, cborMajorBytes, 32)
+		buf = append(buf, k...)
+		if i := v[k].Int(); i < 0 {
+			buf = appendHead(buf, cborMajorNegInt, uint64(-1-i))
+		} else {
+			buf = appendHead(buf, cborMajorUint, uint64(i))
+		}
+	}
+	return buf, nil
+}
+
+// UnmarshalCBOR replaces the notes of v with the decoded ones.
+// Keys need to be 32-byte public keys and can't repeat, other negative numbers than -1 are rejected as well.
+func (v *Vector) UnmarshalCBOR(data []byte) error {
+	rd := bytes.NewReader(data)
+	major, n, err := readHead(rd)
+	if err != nil {
+		return err
+	}
+	if major != cborMajorMap {
+		return errors.Errorf("replicate: vector is not a map")
+	}
+	if n > MaxVectorFeeds {
+		return errors.Errorf("replicate: vector of %d feeds is too large", n)
+	}
+
+	out := make(Vector, n)
+	for i := uint64(0); i < n; i++ {
+		major, l, err := readHead(rd)
+		if err != nil {
+			return err
+		}
+		if major != cborMajorBytes || l != 32 {
+			return errors.Errorf("replicate: key %d is not a public key", i)
+		}
+		key := make([]byte, 32)
+		if _, err := io.ReadFull(rd, key); err != nil {
+			return errors.Errorf("replicate: key %d is cut off", i)
+		}
+		if _, has := out[string(key)]; has {
+			return errors.Errorf("replicate: key %d repeats", i)
+		}
+
+		major, val, err := readHead(rd)
+		if err != nil {
+			return err
+		}
+		var note Note
+		switch {
+		case major == cborMajorUint && val <= math.MaxInt64:
+			note = NoteFromInt(int64(val))
+		case major == cborMajorNegInt && val == 0:
+		default:
+			return errors.Errorf("replicate: invalid note %d", i)
+		}
+		out[string(key)] = note
+	}
+	if rd.Len() != 0 {
+		return errors.Errorf("replicate: %d trailing bytes", rd.Len())
+	}
+	*v = out
+	return nil
+}
+
+func appendHead(dst []byte, major byte, v uint64) []byte {
+	m := major << 5
+	switch {
+	case v < 24:
+		return append(dst, m|byte(v))
+	case v <= math.MaxUint8:
+		return append(dst, m|24, byte(v))
+	case v <= math.MaxUint16:
+		return append(dst, m|25, byte(v>>8), byte(v))
+	case v <= math.MaxUint32:
+		return append(dst, m|26, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+	return append(dst, m|27, byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// readHead reads the major type and argument of the next item, only definite lengths are supported
+func readHead(rd *bytes.Reader) (byte, uint64, error) {
+	b, err := rd.ReadByte()
+	if err != nil {
+		return 0, 0, errors.Errorf("replicate: vector is cut off")
+	}
+	major, info := b>>5, b&0x1f
+	if info < 24 {
+		return major, uint64(info), nil
+	}
+	if info > 27 {
+		return 0, 0, errors.Errorf("replicate: unsupported cbor item 0x%02x", b)
+	}
+	size := 1 << (info - 24)
+	var v uint64
+	for i := 0; i < size; i++ {
+		c, err := rd.ReadByte()
+		if err != nil {
+			return 0, 0, errors.Errorf("replicate: vector is cut off")
+		}
+		v = v<<8 | uint64(c)
+	}
+	return major, v, nil
+}
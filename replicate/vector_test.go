@@ -0,0 +1,122 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package replicate
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+func TestNoteInt(t *testing.T) {
+	a := assert.New(t)
+	for _, tc := range []struct {
+		n Note
+		v int64
+	}{
+		{Note{}, -1},
+		{Note{Replicate: true, Receive: true}, 0},
+		{Note{Replicate: true, Receive: true, Sequence: 5}, 10},
+		{Note{Replicate: true, Sequence: 5}, 11},
+	} {
+		a.Equal(tc.v, tc.n.Int(), "%+v", tc.n)
+		a.Equal(tc.n, NoteFromInt(tc.v))
+	}
+	a.Equal(Note{}, NoteFromInt(-7))
+}
+
+func TestVectorCBOR(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	alice, bob, carol := testFeed(t, 1), testFeed(t, 2), testFeed(t, 3)
+	v := make(Vector)
+	r.NoError(v.Set(carol, Note{}))
+	r.NoError(v.Set(alice, Note{Replicate: true, Receive: true, Sequence: 300}))
+	r.NoError(v.Set(bob, Note{Replicate: true, Sequence: 2}))
+	a.Error(v.Set(alice, Note{Replicate: true, Sequence: 1 << 63}))
+	ssb, err := refs.NewFeedRefFromBytes(bytes.Repeat([]byte{4}, 32), refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+	a.Error(v.Set(ssb, Note{Replicate: true}))
+
+	data, err := v.MarshalCBOR()
+	r.NoError(err)
+	want := []byte{0xa3, 0x58, 0x20}
+	want = append(want, bytes.Repeat([]byte{1}, 32)...)
+	want = append(want, 0x19, 0x02, 0x58, 0x58, 0x20)
+	want = append(want, bytes.Repeat([]byte{2}, 32)...)
+	want = append(want, 0x05, 0x58, 0x20)
+	want = append(want, bytes.Repeat([]byte{3}, 32)...)
+	want = append(want, 0x20)
+	a.Equal(want, data)
+
+	var got Vector
+	r.NoError(got.UnmarshalCBOR(data))
+	a.Equal(v, got)
+	authors := got.Authors()
+	if a.Len(authors, 3) {
+		a.True(authors[0].Equal(alice))
+	}
+
+	for name, bad := range map[string][]byte{
+		"empty":     nil,
+		"not a map": {0x80},
+		"short key": {0xa1, 0x41, 0x01, 0x00},
+		"cut off":   data[:40],
+		"trailing":  append(append([]byte{}, data...), 0x00),
+		"negative":  append(append([]byte{0xa1, 0x58, 0x20}, bytes.Repeat([]byte{1}, 32)...), 0x21),
+		"repeat": append(append(append(append([]byte{0xa2, 0x58, 0x20}, bytes.Repeat([]byte{1}, 32)...),
+			0x00, 0x58, 0x20), bytes.Repeat([]byte{1}, 32)...), 0x00),
+		"too large": {0xba, 0xff, 0xff, 0xff, 0xff},
+	} {
+		a.Error(got.UnmarshalCBOR(bad), name)
+	}
+}
+
+func TestVectorDiff(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	alice, bob, carol := testFeed(t, 1), testFeed(t, 2), testFeed(t, 3)
+	local := make(Vector)
+	r.NoError(local.Set(alice, Note{Replicate: true, Receive: true, Sequence: 10}))
+	r.NoError(local.Set(bob, Note{Replicate: true, Receive: true, Sequence: 5}))
+	r.NoError(local.Set(carol, Note{Replicate: true, Receive: true, Sequence: 7}))
+
+	remote := make(Vector)
+	r.NoError(remote.Set(alice, Note{Replicate: true, Receive: true, Sequence: 4}))
+	r.NoError(remote.Set(bob, Note{Replicate: true, Receive: true, Sequence: 9}))
+	r.NoError(remote.Set(carol, Note{Replicate: true, Sequence: 2}))
+
+	send := ToSend(local, remote)
+	if a.Len(send, 1) {
+		a.True(send[0].Author.Equal(alice))
+		a.EqualValues(4, send[0].Sequence)
+	}
+	fetch := remote.Haves()
+	a.Len(fetch, 3)
+
+	before := make(Vector)
+	before.Update(remote)
+	a.Empty(Changes(before, remote))
+
+	r.NoError(remote.Set(bob, Note{Replicate: true, Receive: true, Sequence: 10}))
+	delete(remote, string(carol.PubKey()))
+	changes := Changes(before, remote)
+	a.Len(changes, 2)
+	n, has := changes.Get(bob)
+	a.True(has)
+	a.EqualValues(10, n.Sequence)
+	n, has = changes.Get(carol)
+	a.True(has)
+	a.False(n.Replicate)
+
+	before.Update(changes)
+	n, _ = before.Get(bob)
+	a.EqualValues(10, n.Sequence)
+}
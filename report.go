@@ -0,0 +1,123 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"time"
+)
+
+// MessageStatus is the outcome of validating a single message
+type MessageStatus string
+
+const (
+	StatusValid   MessageStatus = "valid"
+	StatusInvalid MessageStatus = "invalid"
+	// StatusSkipped marks messages after the first invalid one, which can't be validated without a valid previous
+	StatusSkipped MessageStatus = "skipped"
+)
+
+// VerificationReport summarizes the validation of (a part of) a feed.
+// It is meant to be serialized (i.e. as JSON) so audits of replicated data can be logged and compared.
+type VerificationReport struct {
+	Author string `json:"author"`
+
+	// StartSequence is the sequence the feed state was at before the validation
+	StartSequence uint64 `json:"startSequence"`
+
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+
+	Valid   int `json:"valid"`
+	Invalid int `json:"invalid"`
+	Skipped int `json:"skipped"`
+
+	EventBytes   int `json:"eventBytes"`
+	ContentBytes int `json:"contentBytes"`
+
+	FirstError         string `json:"firstError,omitempty"`
+	FirstErrorSequence uint64 `json:"firstErrorSequence,omitempty"`
+
+	Messages []MessageReport `json:"messages"`
+}
+
+// MessageReport is the entry of a single message in a VerificationReport
+type MessageReport struct {
+	Sequence uint64        `json:"sequence"`
+	Key      string        `json:"key,omitempty"`
+	Status   MessageStatus `json:"status"`
+	Error    string        `json:"error,omitempty"`
+
+	EventBytes   int `json:"eventBytes"`
+	ContentBytes int `json:"contentBytes"`
+
+	Duration time.Duration `json:"duration"`
+}
+
+// ValidateFeed appends trs to state in order and stops at the first invalid message.
+// If report is not nil, it is filled with the outcome for every passed transfer.
+func ValidateFeed(state *FeedState, trs []*Transfer, report *VerificationReport) error {
+	var (
+		start    = time.Now()
+		firstErr error
+	)
+	if report != nil {
+		report.Author = state.Author.URI()
+		report.StartSequence = state.Sequence
+		report.Started = start
+		report.Messages = make([]MessageReport, 0, len(trs))
+	}
+
+	for i, tr := range trs {
+		if firstErr != nil {
+			if report == nil {
+				break
+			}
+			report.Skipped++
+			report.Messages = append(report.Messages, MessageReport{
+				Sequence:     report.StartSequence + uint64(i) + 1,
+				Status:       StatusSkipped,
+				EventBytes:   len(tr.Event),
+				ContentBytes: len(tr.Content),
+			})
+			continue
+		}
+
+		msgStart := time.Now()
+		expected := state.Sequence + 1
+		err := state.Append(tr)
+		if err != nil {
+			firstErr = err
+		}
+		if report == nil {
+			continue
+		}
+
+		mr := MessageReport{
+			Sequence:     expected,
+			EventBytes:   len(tr.Event),
+			ContentBytes: len(tr.Content),
+			Duration:     time.Since(msgStart),
+		}
+		report.EventBytes += mr.EventBytes
+		report.ContentBytes += mr.ContentBytes
+		if err != nil {
+			mr.Status = StatusInvalid
+			mr.Error = err.Error()
+			report.Invalid++
+			report.FirstError = err.Error()
+			report.FirstErrorSequence = expected
+		} else {
+			mr.Status = StatusValid
+			mr.Key = state.Tip.URI()
+			report.Valid++
+		}
+		report.Messages = append(report.Messages, mr)
+	}
+
+	if report != nil {
+		report.Duration = time.Since(start)
+	}
+	return firstErr
+}
@@ -0,0 +1,58 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateFeedReport(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	author, trs := makeTestFeed(t, "dead", 5)
+
+	var report VerificationReport
+	r.NoError(ValidateFeed(NewFeedState(author), trs, &report))
+	a.Equal(5, report.Valid)
+	a.Empty(report.FirstError)
+	a.Len(report.Messages, 5)
+
+	// break the signature of the third message
+	tampered := *trs[2]
+	tampered.Signature = append([]byte{}, trs[2].Signature...)
+	tampered.Signature[0] ^= 0xff
+	trs[2] = &tampered
+
+	report = VerificationReport{}
+	err := ValidateFeed(NewFeedState(author), trs, &report)
+	r.Error(err)
+	a.Equal(2, report.Valid)
+	a.Equal(1, report.Invalid)
+	a.Equal(2, report.Skipped)
+	a.EqualValues(3, report.FirstErrorSequence)
+	a.Equal(err.Error(), report.FirstError)
+
+	var statuses []MessageStatus
+	var seqs []uint64
+	for _, m := range report.Messages {
+		statuses = append(statuses, m.Status)
+		seqs = append(seqs, m.Sequence)
+	}
+	a.Equal([]MessageStatus{StatusValid, StatusValid, StatusInvalid, StatusSkipped, StatusSkipped}, statuses)
+	a.Equal([]uint64{1, 2, 3, 4, 5}, seqs)
+
+	_, err = json.Marshal(report)
+	r.NoError(err)
+
+	// no report
+	state := NewFeedState(author)
+	r.Error(ValidateFeed(state, trs, nil))
+	a.EqualValues(2, state.Sequence)
+}
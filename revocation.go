@@ -0,0 +1,154 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// RevocationType is the content type of a published Revocation
+const RevocationType = "gabbygrove/revoke"
+
+// maxRevocationReasonLength bounds the reason of a revocation in bytes
+const maxRevocationReasonLength = 512
+
+// ErrKeyRevoked is returned for messages after the revocation of a feed, see RevocationPolicy
+var ErrKeyRevoked = errors.New("gabbygrove: key of the feed is revoked")
+
+// Revocation is the conventional last message of a feed whose key was compromised or retired.
+// It disowns the messages from Since up to itself, which can be forged ones that were published with the stolen key.
+// A feed can't be continued after it, see RevocationPolicy for what happens to messages that still follow.
+// Anyone with the key can publish one, which is no worse than what they can do with it already.
+type Revocation struct {
+	Type string `json:"type"`
+
+	// Since is the first sequence that isn't trusted anymore, the sequence of the revocation to only end the feed
+	Since uint64 `json:"since"`
+
+	Reason string `json:"reason,omitempty"`
+}
+
+// NewRevocation returns a revocation of the messages from since on
+func NewRevocation(since uint64, reason string) *Revocation {
+	var rev Revocation
+	rev.Type = RevocationType
+	rev.Since = since
+	rev.Reason = reason
+	return &rev
+}
+
+// Validate checks that the revocation follows the conventional layout
+func (rev Revocation) Validate() error {
+	if rev.Type != RevocationType {
+		return errors.Errorf("revocation: wrong type: %q", rev.Type)
+	}
+	if rev.Since == 0 {
+		return errors.Errorf("revocation: since is missing")
+	}
+	if n := len(rev.Reason); n > maxRevocationReasonLength {
+		return errors.Errorf("revocation: reason too long (%d bytes)", n)
+	}
+	return nil
+}
+
+// EncodeRevocation encodes rev as the message sequence of the feed of e
+func (e *Encoder) EncodeRevocation(sequence uint64, prev BinaryRef, rev Revocation) (*Transfer, refs.MessageRef, error) {
+	if err := rev.Validate(); err != nil {
+		return nil, refs.MessageRef{}, err
+	}
+	if rev.Since > sequence {
+		return nil, refs.MessageRef{}, errors.Errorf("revocation: since %d is after the revocation %d", rev.Since, sequence)
+	}
+	return e.Encode(sequence, prev, rev)
+}
+
+// ParseRevocation validates tr as a revocation and returns it.
+// The transfer needs to carry the matching JSON content, Since can't be after the message itself.
+// Verifying the signature is left to FeedState.Append, which also makes sure a feed is only revoked once.
+func ParseRevocation(tr *Transfer) (*Revocation, error) {
+	evt, err := tr.getEvent()
+	if err != nil {
+		return nil, errors.Wrap(err, "revocation: invalid event")
+	}
+	if evt.Content.Type != ContentTypeJSON {
+		return nil, errors.Errorf("revocation: content is not JSON")
+	}
+
+	if len(tr.Content) == 0 {
+		return nil, errors.Errorf("revocation: content missing")
+	}
+	if !tr.ContentMatches(tr.Content) {
+		return nil, errors.Errorf("revocation: content does not match the event")
+	}
+
+	var rev Revocation
+	if err := json.Unmarshal(tr.Content, &rev); err != nil {
+		return nil, errors.Wrap(err, "revocation: invalid JSON")
+	}
+	if err := rev.Validate(); err != nil {
+		return nil, err
+	}
+	if rev.Since > evt.Sequence {
+		return nil, errors.Errorf("revocation: since %d is after the revocation %d", rev.Since, evt.Sequence)
+	}
+	return &rev, nil
+}
+
+// hasJSONType is true if tr has attached JSON content of type typ.
+// The cheap substring test keeps other messages from being decoded.
+func hasJSONType(tr *Transfer, typ string) bool {
+	if len(tr.Content) == 0 || !bytes.Contains(tr.Content, []byte(typ)) {
+		return false
+	}
+	evt, err := tr.getEvent()
+	if err != nil || evt.Content.Type != ContentTypeJSON {
+		return false
+	}
+	got, err := jsonTypeField(tr.Content)
+	return err == nil && got == typ
+}
+
+// RevocationPoint is where a feed published its Revocation
+type RevocationPoint struct {
+	// Sequence is the sequence of the revocation message
+	Sequence uint64 `json:"sequence"`
+
+	// Since is the first disowned sequence, see Revocation
+	Since uint64 `json:"since"`
+}
+
+// Disowned is true for the messages the revocation doesn't vouch for:
+// those from Since up to the revocation and all that follow it.
+func (rp RevocationPoint) Disowned(seq uint64) bool {
+	return seq >= rp.Since && seq != rp.Sequence
+}
+
+// RevocationPolicy decides what a Validator does with messages that follow the revocation of their feed.
+// Messages between Since and the revocation were appended before it was known, see RevocationPoint.Disowned.
+type RevocationPolicy int
+
+const (
+	// RevocationReject rejects them with ErrKeyRevoked, it's the default
+	RevocationReject RevocationPolicy = iota
+
+	// RevocationFlag appends them if they are valid otherwise and marks them, see AfterRevocation
+	RevocationFlag
+)
+
+// WithRevocationPolicy sets what happens to messages after a revocation.
+// A feed keeps the policy of when it was first seen, changing it later only affects new feeds.
+func (v *Validator) WithRevocationPolicy(p RevocationPolicy) {
+	v.revocation = p
+}
+
+// AfterRevocation is true for messages a Validator appended with RevocationFlag after the revocation of their feed.
+// Like the received time, the mark isn't part of the encoding.
+func (tr *Transfer) AfterRevocation() bool {
+	return tr.afterRevocation
+}
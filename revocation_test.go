@@ -0,0 +1,183 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// makeRevokedFeed is makeTestFeed with a revocation of the messages from since on appended,
+// followed by another message that was signed with the revoked key
+func makeRevokedFeed(t *testing.T, n int, since uint64) ([]*Transfer, *Transfer) {
+	r := require.New(t)
+
+	author, trs := makeTestFeed(t, "dead", n)
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	e := NewEncoder(privKey)
+
+	state := NewFeedState(author)
+	for _, tr := range trs {
+		r.NoError(state.Append(tr))
+	}
+	seq, prev := state.Next()
+	rev, _, err := e.EncodeRevocation(seq, prev, *NewRevocation(since, "laptop stolen"))
+	r.NoError(err)
+	r.NoError(state.Append(rev))
+	trs = append(trs, rev)
+
+	seq, prev = state.Next()
+	after, _, err := e.Encode(seq, prev, map[string]interface{}{"type": "test", "i": seq})
+	r.NoError(err)
+	return trs, after
+}
+
+func TestRevocation(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	e := NewEncoder(privKey)
+	_, _, err := e.EncodeRevocation(1, BinaryRef{}, *NewRevocation(0, ""))
+	a.Error(err, "since is missing")
+	_, _, err = e.EncodeRevocation(1, BinaryRef{}, *NewRevocation(2, ""))
+	a.Error(err, "since after the revocation")
+	_, _, err = e.EncodeRevocation(1, BinaryRef{}, Revocation{Type: "gabbygrove/revoked", Since: 1})
+	a.Error(err, "wrong type")
+
+	trs, after := makeRevokedFeed(t, 3, 3)
+	rev, err := ParseRevocation(trs[3])
+	r.NoError(err)
+	a.EqualValues(3, rev.Since)
+	a.Equal("laptop stolen", rev.Reason)
+	_, err = ParseRevocation(trs[2])
+	a.Error(err)
+
+	author, _ := makeTestFeed(t, "dead", 0)
+	state := NewFeedState(author)
+	for _, tr := range trs[:3] {
+		r.NoError(state.Append(tr))
+		a.Nil(state.Revoked)
+	}
+
+	// the content of a revocation needs to match its event
+	swapped := *trs[3]
+	swapped.Content = []byte(`{"type":"gabbygrove/revoke","since":1}`)
+	a.Error(state.Append(&swapped))
+
+	r.NoError(state.Append(trs[3]))
+	r.NotNil(state.Revoked)
+	a.Equal(RevocationPoint{Sequence: 4, Since: 3}, *state.Revoked)
+	a.False(state.Revoked.Disowned(2))
+	a.True(state.Revoked.Disowned(3))
+	a.False(state.Revoked.Disowned(4), "the revocation itself")
+	a.True(state.Revoked.Disowned(5))
+
+	a.Equal(ErrKeyRevoked, errors.Cause(state.Append(after)))
+	a.EqualValues(4, state.Sequence)
+
+	// the revocation survives a snapshot
+	data, err := state.MarshalBinary()
+	r.NoError(err)
+	a.Equal(feedStateVersion2, data[0])
+	var restored FeedState
+	r.NoError(restored.UnmarshalBinary(data))
+	a.Equal(state.Revoked, restored.Revoked)
+	a.Equal(ErrKeyRevoked, errors.Cause(restored.Append(after)))
+
+	a.Error(restored.UnmarshalBinary(data[:len(data)-1]))
+	bad := *state
+	bad.Revoked = &RevocationPoint{Sequence: 5, Since: 3}
+	_, err = bad.MarshalBinary()
+	a.Error(err, "revocation after the tip")
+}
+
+func TestValidatorRevocation(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	trs, after := makeRevokedFeed(t, 2, 3)
+
+	v := NewValidator(4)
+	// dropping all content keeps the one of revocations
+	v.WithContentPolicy(&ContentPolicy{None: true})
+	for _, tr := range trs {
+		appended, err := v.Append(tr)
+		r.NoError(err)
+		r.Len(appended, 1)
+		a.False(appended[0].AfterRevocation())
+	}
+	author := trs[0].Author()
+	tip := v.Tip(author)
+	r.NotNil(tip.Revoked)
+	a.EqualValues(3, tip.Revoked.Sequence)
+	_, err := v.Append(after)
+	a.Equal(ErrKeyRevoked, errors.Cause(err))
+
+	v = NewValidator(4)
+	v.WithRevocationPolicy(RevocationFlag)
+	for _, tr := range trs {
+		_, err := v.Append(tr)
+		r.NoError(err)
+	}
+	appended, err := v.Append(after)
+	r.NoError(err)
+	r.Len(appended, 1)
+	a.True(appended[0].AfterRevocation())
+	a.False(after.AfterRevocation(), "marked a copy")
+	a.EqualValues(4, v.Tip(author).Sequence)
+}
+
+func TestOutboxRevokedReopen(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	pubKey, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	author, err := refs.NewFeedRefFromBytes(pubKey, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+
+	for name, ss := range map[string]SequenceStore{
+		"mem":  NewMemSequenceStore(),
+		"file": NewFileSequenceStore(filepath.Join(dir, "tip.json")),
+	} {
+		ob, err := OpenOutbox(NewEncoder(privKey), author, ss, NewMemOutboxStore())
+		r.NoError(err, name)
+		_, _, err = ob.Publish("one")
+		r.NoError(err, name)
+		_, revKey, err := ob.Publish(NewRevocation(1, "laptop stolen"))
+		r.NoError(err, name)
+		_, _, err = ob.Publish("two")
+		a.Equal(ErrKeyRevoked, errors.Cause(err), name)
+
+		// the revocation point is committed with the tip
+		ob, err = OpenOutbox(NewEncoder(privKey), author, ss, NewMemOutboxStore())
+		r.NoError(err, name)
+		state := ob.State()
+		r.NotNil(state.Revoked, name)
+		a.Equal(RevocationPoint{Sequence: 2, Since: 1}, *state.Revoked, name)
+		a.False(state.Terminated, name)
+		_, _, err = ob.Publish("two")
+		a.Equal(ErrKeyRevoked, errors.Cause(err), name)
+
+		e := NewEncoder(privKey)
+		e.WithSequenceStore(ss)
+		prev, err := fromRef(revKey)
+		r.NoError(err)
+		_, _, err = e.Encode(3, prev, "two")
+		a.Equal(ErrKeyRevoked, errors.Cause(err), name)
+	}
+}
@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+)
+
+// SameAsType is the content type of a published SameAsProof
+const SameAsType = "gabbygrove/same-as"
+
+// SameAsProof states that a legacy feed and a gabbygrove feed belong to the same identity.
+// Each key signs a statement containing the ref of the other feed, so neither side can claim the other on its own.
+// It can be published as JSON content on both feeds.
+type SameAsProof struct {
+	Type string `json:"type"`
+
+	Legacy refs.FeedRef `json:"legacy"`
+	Gabby  refs.FeedRef `json:"gabby"`
+
+	// LegacySignature is made by the legacy key over the statement containing the gabbygrove ref
+	LegacySignature string `json:"legacySignature"`
+	// GabbySignature is made by the gabbygrove key over the statement containing the legacy ref
+	GabbySignature string `json:"gabbySignature"`
+}
+
+// NewSameAsProof creates a proof signed by both keys
+func NewSameAsProof(legacyKey, gabbyKey ed25519.PrivateKey) (*SameAsProof, error) {
+	legacy, err := refs.NewFeedRefFromBytes(legacyKey.Public().(ed25519.PublicKey), refs.RefAlgoFeedSSB1)
+	if err != nil {
+		return nil, errors.Wrap(err, "same-as: invalid legacy key")
+	}
+	gabby, err := refs.NewFeedRefFromBytes(gabbyKey.Public().(ed25519.PublicKey), refs.RefAlgoFeedGabby)
+	if err != nil {
+		return nil, errors.Wrap(err, "same-as: invalid gabbygrove key")
+	}
+
+	var p SameAsProof
+	p.Type = SameAsType
+	p.Legacy = legacy
+	p.Gabby = gabby
+	p.LegacySignature = SignSameAs(legacyKey, legacy, gabby)
+	p.GabbySignature = SignSameAs(gabbyKey, gabby, legacy)
+	return &p, nil
+}
+
+// SignSameAs signs the statement that signer is the same as other.
+// This allows creating both halves of a proof on different machines.
+func SignSameAs(key ed25519.PrivateKey, signer, other refs.FeedRef) string {
+	sig := ed25519.Sign(key, sameAsStatement(signer, other))
+	return base64.StdEncoding.EncodeToString(sig) + ".sig.ed25519"
+}
+
+// Verify checks that both signatures are valid and the feeds have the expected formats
+func (p SameAsProof) Verify() error {
+	if p.Type != SameAsType {
+		return errors.Errorf("same-as: wrong type: %q", p.Type)
+	}
+	if p.Legacy.Algo() != refs.RefAlgoFeedSSB1 {
+		return errors.Errorf("same-as: not a legacy feed: %s", p.Legacy.Algo())
+	}
+	if p.Gabby.Algo() != refs.RefAlgoFeedGabby {
+		return errors.Errorf("same-as: not a gabbygrove feed: %s", p.Gabby.Algo())
+	}
+	if err := verifySameAs(p.LegacySignature, p.Legacy, p.Gabby); err != nil {
+		return errors.Wrap(err, "same-as: legacy signature")
+	}
+	if err := verifySameAs(p.GabbySignature, p.Gabby, p.Legacy); err != nil {
+		return errors.Wrap(err, "same-as: gabbygrove signature")
+	}
+	return nil
+}
+
+func verifySameAs(sigStr string, signer, other refs.FeedRef) error {
+	const suffix = ".sig.ed25519"
+	if len(sigStr) <= len(suffix) || sigStr[len(sigStr)-len(suffix):] != suffix {
+		return errors.Errorf("invalid signature suffix")
+	}
+	sig, err := base64.StdEncoding.DecodeString(sigStr[:len(sigStr)-len(suffix)])
+	if err != nil {
+		return errors.Wrap(err, "invalid signature encoding")
+	}
+	if !ed25519.Verify(signer.PubKey(), sameAsStatement(signer, other), sig) {
+		return errors.Errorf("signature does not verify")
+	}
+	return nil
+}
+
+func sameAsStatement(signer, other refs.FeedRef) []byte {
+	return []byte(fmt.Sprintf("%s\n%s\n%s", SameAsType, signer.String(), other.String()))
+}
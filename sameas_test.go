@@ -0,0 +1,46 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSameAsProof(t *testing.T) {
+	r := require.New(t)
+
+	_, legacyKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("beef"), 8)))
+	_, gabbyKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+
+	p, err := NewSameAsProof(legacyKey, gabbyKey)
+	r.NoError(err)
+	r.NoError(p.Verify())
+
+	// survives being published as content
+	e := NewEncoder(gabbyKey)
+	tr, _, err := e.Encode(1, BinaryRef{}, p)
+	r.NoError(err)
+
+	var got SameAsProof
+	r.NoError(json.Unmarshal(tr.Content, &got))
+	r.NoError(got.Verify())
+
+	// swapped signatures don't verify
+	swapped := got
+	swapped.LegacySignature, swapped.GabbySignature = got.GabbySignature, got.LegacySignature
+	r.Error(swapped.Verify())
+
+	// can't claim someone elses legacy feed
+	_, otherKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("f00d"), 8)))
+	other, err := NewSameAsProof(otherKey, gabbyKey)
+	r.NoError(err)
+	forged := got
+	forged.Legacy = other.Legacy
+	r.Error(forged.Verify())
+}
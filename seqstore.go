@@ -0,0 +1,295 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// SequenceStore persists the tip of a feed that is published to.
+// Committing a message before it is handed out means a crash can't lead to signing two different messages with the same sequence.
+type SequenceStore interface {
+	// Load returns the latest committed sequence and message key, 0 and nil for a new feed.
+	Load() (uint64, *refs.MessageRef, error)
+
+	// Commit atomically replaces the stored tip with seq and key.
+	Commit(seq uint64, key refs.MessageRef) error
+}
+
+// FeedStateStore is a SequenceStore that also keeps how the feed ended, so LoadFeedState and
+// the encoder know about it after a restart. The SequenceStores of this package implement it.
+type FeedStateStore interface {
+	SequenceStore
+
+	// LoadState returns the committed state, with an empty author
+	LoadState() (*FeedState, error)
+
+	// CommitState is Commit for the tip of fs that also keeps whether the feed is terminated or revoked.
+	// Once the end is committed it stays, later commits only move the tip.
+	CommitState(fs FeedState) error
+}
+
+// commitState commits the tip of fs to ss, with the end of the feed if ss keeps it
+func commitState(ss SequenceStore, fs FeedState) error {
+	if fss, ok := ss.(FeedStateStore); ok {
+		return fss.CommitState(fs)
+	}
+	return ss.Commit(fs.Sequence, *fs.Tip)
+}
+
+// loadState returns the state committed to ss, see LoadFeedState
+func loadState(ss SequenceStore) (*FeedState, error) {
+	var (
+		fs  = &FeedState{}
+		err error
+	)
+	if fss, ok := ss.(FeedStateStore); ok {
+		fs, err = fss.LoadState()
+	} else {
+		fs.Sequence, fs.Tip, err = ss.Load()
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "sequence store: failed to load")
+	}
+	if (fs.Sequence == 0) != (fs.Tip == nil) {
+		return nil, errors.Errorf("sequence store: inconsistent tip for sequence %d", fs.Sequence)
+	}
+	return fs, nil
+}
+
+// WithSequenceStore makes the encoder commit every newly signed message to ss before returning it.
+// If the commit fails, the message is discarded and Encode returns an error.
+// Messages that don't continue the stored tip are refused before they are signed, see checkTip.
+func (e *Encoder) WithSequenceStore(ss SequenceStore) {
+	e.seqStore = ss
+}
+
+// WithFeedState makes the encoder refuse messages that don't continue fs and advance fs with the ones it signs.
+// Like FeedState.Append, it rejects messages after a tombstone or revocation of the feed.
+// fs needs to be the state of the feed of the encoder and isn't safe to use elsewhere at the same time.
+func (e *Encoder) WithFeedState(fs *FeedState) {
+	e.feedState = fs
+}
+
+// checkTip fails with ErrWrongSequence or ErrBrokenChain if sequence and prev don't continue the tip
+// of the SequenceStore or FeedState of the encoder. Signing a second message with the same sequence forks the feed,
+// which replicating peers can't recover from.
+func (e *Encoder) checkTip(sequence uint64, prev BinaryRef) error {
+	if e.seqStore != nil {
+		committed, err := loadState(e.seqStore)
+		if err != nil {
+			return err
+		}
+		switch {
+		case committed.Terminated:
+			return errors.Wrapf(ErrFeedTerminated, "sequence store: terminated at %d", committed.Sequence)
+		case committed.Revoked != nil:
+			return errors.Wrapf(ErrKeyRevoked, "sequence store: revoked at %d", committed.Revoked.Sequence)
+		}
+		if err := continuesTip(committed.Sequence, committed.Tip, sequence, prev); err != nil {
+			return errors.Wrap(err, "sequence store")
+		}
+	}
+	if fs := e.feedState; fs != nil {
+		switch {
+		case fs.Terminated:
+			return errors.Wrapf(ErrFeedTerminated, "feed state: terminated at %d", fs.Sequence)
+		case fs.Revoked != nil && !fs.acceptRevoked:
+			return errors.Wrapf(ErrKeyRevoked, "feed state: revoked at %d", fs.Revoked.Sequence)
+		}
+		if err := continuesTip(e.feedState.Sequence, e.feedState.Tip, sequence, prev); err != nil {
+			return errors.Wrap(err, "feed state")
+		}
+	}
+	return nil
+}
+
+// continuesTip checks that sequence and prev follow seq and tip. The first message has no previous.
+func continuesTip(seq uint64, tip *refs.MessageRef, sequence uint64, prev BinaryRef) error {
+	if want := seq + 1; sequence != want {
+		return errors.Wrapf(ErrWrongSequence, "encoder: expected %d got %d", want, sequence)
+	}
+	if tip == nil {
+		return nil
+	}
+	pref, err := prev.GetRef(RefTypeMessage)
+	if err != nil {
+		return errors.Wrapf(ErrBrokenChain, "encoder: message %d has no valid previous", sequence)
+	}
+	if !pref.(refs.MessageRef).Equal(*tip) {
+		return errors.Wrapf(ErrBrokenChain, "encoder: message %d", sequence)
+	}
+	return nil
+}
+
+// LoadFeedState creates the state of author's feed from the tip in ss.
+// If ss is a FeedStateStore, Terminated and Revoked are restored as well.
+func LoadFeedState(author refs.FeedRef, ss SequenceStore) (*FeedState, error) {
+	committed, err := loadState(ss)
+	if err != nil {
+		return nil, err
+	}
+	fs := NewFeedState(author)
+	fs.Sequence = committed.Sequence
+	fs.Tip = committed.Tip
+	fs.Terminated = committed.Terminated
+	fs.Revoked = committed.Revoked
+	return fs, nil
+}
+
+// NewMemSequenceStore returns a SequenceStore that only lives as long as the process, mostly useful for testing
+func NewMemSequenceStore() SequenceStore {
+	return &memSeqStore{}
+}
+
+type memSeqStore struct {
+	mu         sync.Mutex
+	seq        uint64
+	tip        *refs.MessageRef
+	terminated bool
+	revoked    *RevocationPoint
+}
+
+func (ms *memSeqStore) Load() (uint64, *refs.MessageRef, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.seq, ms.tip, nil
+}
+
+func (ms *memSeqStore) Commit(seq uint64, key refs.MessageRef) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.seq = seq
+	ms.tip = &key
+	return nil
+}
+
+func (ms *memSeqStore) LoadState() (*FeedState, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return &FeedState{Sequence: ms.seq, Tip: ms.tip, Terminated: ms.terminated, Revoked: ms.revoked}, nil
+}
+
+func (ms *memSeqStore) CommitState(fs FeedState) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.seq = fs.Sequence
+	ms.tip = fs.Tip
+	ms.terminated = ms.terminated || fs.Terminated
+	if ms.revoked == nil {
+		ms.revoked = fs.Revoked
+	}
+	return nil
+}
+
+// NewFileSequenceStore keeps the tip in a small JSON file.
+// Commits write a temporary file, sync it and rename it over the old one.
+func NewFileSequenceStore(path string) SequenceStore {
+	return fileSeqStore(path)
+}
+
+type fileSeqStore string
+
+type fileSeqState struct {
+	Sequence   uint64           `json:"sequence"`
+	Tip        *refs.MessageRef `json:"tip"`
+	Terminated bool             `json:"terminated,omitempty"`
+	Revoked    *RevocationPoint `json:"revoked,omitempty"`
+}
+
+func (fss fileSeqStore) Load() (uint64, *refs.MessageRef, error) {
+	state, err := fss.read()
+	if err != nil {
+		return 0, nil, err
+	}
+	return state.Sequence, state.Tip, nil
+}
+
+func (fss fileSeqStore) LoadState() (*FeedState, error) {
+	state, err := fss.read()
+	if err != nil {
+		return nil, err
+	}
+	return &FeedState{Sequence: state.Sequence, Tip: state.Tip, Terminated: state.Terminated, Revoked: state.Revoked}, nil
+}
+
+func (fss fileSeqStore) Commit(seq uint64, key refs.MessageRef) error {
+	return fss.CommitState(FeedState{Sequence: seq, Tip: &key})
+}
+
+func (fss fileSeqStore) CommitState(fs FeedState) error {
+	// the end of the feed is kept from the last commit
+	state, err := fss.read()
+	if err != nil {
+		return err
+	}
+	state.Sequence, state.Tip = fs.Sequence, fs.Tip
+	state.Terminated = state.Terminated || fs.Terminated
+	if state.Revoked == nil {
+		state.Revoked = fs.Revoked
+	}
+	return fss.write(state)
+}
+
+// read returns the stored state, which is empty if there is no file yet
+func (fss fileSeqStore) read() (fileSeqState, error) {
+	var state fileSeqState
+	data, err := ioutil.ReadFile(string(fss))
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, errors.Wrap(err, "sequence store: broken state file")
+	}
+	return state, nil
+}
+
+// write replaces the state file with state
+func (fss fileSeqStore) write(state fileSeqState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(string(fss))
+	tmp, err := ioutil.TempFile(dir, filepath.Base(string(fss))+".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), string(fss)); err != nil {
+		return err
+	}
+
+	// make sure the rename itself is durable
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,151 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+type failingSeqStore struct{ SequenceStore }
+
+func (failingSeqStore) Commit(uint64, refs.MessageRef) error { return errors.New("disk full") }
+
+func TestEncoderSequenceStore(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+
+	ss := NewFileSequenceStore(filepath.Join(dir, "tip.json"))
+	seq, tip, err := ss.Load()
+	r.NoError(err)
+	a.Zero(seq)
+	a.Nil(tip)
+
+	e := NewEncoder(privKey)
+	e.WithSequenceStore(ss)
+	_, key, err := e.Encode(1, BinaryRef{}, "hello")
+	r.NoError(err)
+
+	seq, tip, err = ss.Load()
+	r.NoError(err)
+	a.EqualValues(1, seq)
+	r.NotNil(tip)
+	a.Equal(key, *tip)
+
+	e.WithSequenceStore(failingSeqStore{ss})
+	prev, err := fromRef(key)
+	r.NoError(err)
+	tr, _, err := e.Encode(2, prev, "world")
+	a.Error(err)
+	a.Nil(tr, "uncommitted message escaped")
+}
+
+func TestEncoderTipGuard(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	pubKey, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	author, err := refs.NewFeedRefFromBytes(pubKey, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+
+	var signed int
+	e := NewEncoder(privKey)
+	e.WithSigningAuditHook(func(uint64, *refs.MessageRef, ContentRef, refs.MessageRef) error {
+		signed++
+		return nil
+	})
+	e.WithSequenceStore(NewMemSequenceStore())
+
+	_, _, err = e.Encode(2, BinaryRef{}, "too early")
+	a.Equal(ErrWrongSequence, errors.Cause(err))
+	_, key1, err := e.Encode(1, BinaryRef{}, "one")
+	r.NoError(err)
+	_, _, err = e.Encode(1, BinaryRef{}, "fork")
+	a.Equal(ErrWrongSequence, errors.Cause(err), "signed the same sequence twice")
+
+	_, _, err = e.Encode(2, BinaryRef{}, "no previous")
+	a.Equal(ErrBrokenChain, errors.Cause(err))
+	wrongPrev, err := HashContent([]byte("nope"))
+	r.NoError(err)
+	_, _, err = e.Encode(2, wrongPrev, "previous is content")
+	a.Equal(ErrBrokenChain, errors.Cause(err))
+	prev, err := fromRef(key1)
+	r.NoError(err)
+	_, key2, err := e.Encode(2, prev, "two")
+	r.NoError(err)
+	a.Equal(2, signed, "signed refused messages")
+
+	// a feed state is checked and advanced as well
+	fs := NewFeedState(author)
+	e.WithSequenceStore(nil)
+	e.WithFeedState(fs)
+	_, _, err = e.Encode(3, prev, "state is behind")
+	a.Equal(ErrWrongSequence, errors.Cause(err))
+
+	fs.Sequence, fs.Tip = 2, &key2
+	seq, prev := fs.Next()
+	_, _, err = e.EncodeTombstone(seq, prev, *NewTombstone(""))
+	r.NoError(err)
+	a.EqualValues(3, fs.Sequence)
+	a.True(fs.Terminated)
+
+	seq, prev = fs.Next()
+	_, _, err = e.Encode(seq, prev, "after the end")
+	a.Equal(ErrFeedTerminated, errors.Cause(err))
+	a.EqualValues(3, fs.Sequence)
+	a.Equal(3, signed, "signed after the tombstone")
+}
+
+func TestOutboxRecovery(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	pubKey, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	author, err := refs.NewFeedRefFromBytes(pubKey, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+
+	ss := NewMemSequenceStore()
+	store := NewMemOutboxStore()
+
+	ob, err := OpenOutbox(NewEncoder(privKey), author, ss, store)
+	r.NoError(err)
+	_, _, err = ob.Publish("one")
+	r.NoError(err)
+
+	// simulate a crash after persisting the transfer but before the commit
+	ob.seqStore = failingSeqStore{ss}
+	_, _, err = ob.Publish("two")
+	r.Error(err)
+
+	data, err := store.Get(2)
+	r.NoError(err)
+	var tr2 Transfer
+	r.NoError(tr2.UnmarshalCBOR(data))
+
+	ob, err = OpenOutbox(NewEncoder(privKey), author, ss, store)
+	r.NoError(err)
+	state := ob.State()
+	a.EqualValues(2, state.Sequence)
+	a.Equal(tr2.Key(), *state.Tip)
+
+	tr3, _, err := ob.Publish("three")
+	r.NoError(err)
+	a.EqualValues(3, tr3.Seq())
+	a.Equal(tr2.Key(), *tr3.Previous())
+}
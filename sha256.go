@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"crypto/sha256"
+	"hash"
+	"sync/atomic"
+)
+
+type sha256Holder struct{ newHash func() hash.Hash }
+
+var sha256Impl atomic.Value
+
+// sum256BufSize bounds the data sum256 joins on the stack to hash it in one go, an event with its signature fits
+const sum256BufSize = 1024
+
+// SetSHA256 replaces the SHA-256 implementation content hashes and message keys are computed with,
+// i.e. with sha256simd.New of github.com/minio/sha256-simd.
+// By default the one-shot crypto/sha256 functions are used, which pick the SHA extensions or AVX2 at runtime
+// where the CPU has them. Compare with the hashing benchmarks of this package before switching,
+// GODEBUG=cpu.sha=off shows what the selection is worth on amd64.
+// Passing nil restores crypto/sha256.
+// The implementation needs to compute standard SHA-256, anything else breaks every reference.
+func SetSHA256(newHash func() hash.Hash) {
+	sha256Impl.Store(sha256Holder{newHash})
+}
+
+// customSHA256 returns the implementation passed to SetSHA256, nil if there is none
+func customSHA256() func() hash.Hash {
+	h, _ := sha256Impl.Load().(sha256Holder)
+	return h.newHash
+}
+
+func newSHA256() hash.Hash {
+	if newHash := customSHA256(); newHash != nil {
+		return newHash()
+	}
+	return sha256.New()
+}
+
+// sum256 hashes data as if it was one slice.
+// Without another implementation, data that fits sum256BufSize is hashed with sha256.Sum256, which doesn't allocate.
+func sum256(data ...[]byte) [sha256.Size]byte {
+	if customSHA256() == nil {
+		if len(data) == 1 {
+			return sha256.Sum256(data[0])
+		}
+		var n int
+		for _, d := range data {
+			n += len(d)
+		}
+		if n <= sum256BufSize {
+			var buf [sum256BufSize]byte
+			joined := buf[:0]
+			for _, d := range data {
+				joined = append(joined, d...)
+			}
+			return sha256.Sum256(joined)
+		}
+	}
+	hh := newSHA256()
+	for _, d := range data {
+		hh.Write(d)
+	}
+	var sum [sha256.Size]byte
+	hh.Sum(sum[:0])
+	return sum
+}
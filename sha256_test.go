@@ -0,0 +1,96 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"hash"
+	"math"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetSHA256(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, trs := makeTestFeed(t, "sha2", 2)
+	wantKey := trs[1].Key()
+	wantRef, err := HashContent(trs[1].Content)
+	r.NoError(err)
+
+	var calls int64
+	SetSHA256(func() hash.Hash {
+		atomic.AddInt64(&calls, 1)
+		return sha256.New()
+	})
+	defer SetSHA256(nil)
+
+	a.Equal(wantKey, trs[1].Key())
+	gotRef, err := HashContent(trs[1].Content)
+	r.NoError(err)
+	a.Equal(wantRef, gotRef)
+	a.True(trs[1].ContentMatches(trs[1].Content))
+	a.EqualValues(3, atomic.LoadInt64(&calls))
+
+	SetSHA256(nil)
+	a.Equal(wantKey, trs[1].Key())
+	a.EqualValues(3, atomic.LoadInt64(&calls), "restored crypto/sha256")
+}
+
+func TestSum256(t *testing.T) {
+	a := assert.New(t)
+
+	for _, n := range []int{0, 1, sum256BufSize - 1, sum256BufSize, sum256BufSize + 1, 3 * sum256BufSize} {
+		data := bytes.Repeat([]byte{0x42}, n)
+		want := sha256.Sum256(data)
+		a.Equal(want, sum256(data), "%d bytes", n)
+		a.Equal(want, sum256(data[:n/3], data[n/3:n/2], nil, data[n/2:]), "%d bytes in parts", n)
+	}
+}
+
+// The hashing benchmarks measure the SHA-256 implementation that is set, run them once with and once without SetSHA256 to compare.
+// The Streaming variants always go through a hash.Hash, like a custom implementation does.
+
+func benchmarkHashContent(size int, b *testing.B) {
+	data := bytes.Repeat([]byte{0xaa}, size)
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := HashContent(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkHashContent1k(b *testing.B)  { benchmarkHashContent(1024, b) }
+func BenchmarkHashContent64k(b *testing.B) { benchmarkHashContent(math.MaxUint16, b) }
+
+func BenchmarkTransferKey(b *testing.B) {
+	_, trs := makeTestFeed(b, "sha2", 1)
+	b.SetBytes(int64(len(trs[0].Event) + len(trs[0].Signature)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trs[0].Key()
+	}
+}
+
+func BenchmarkTransferKeyStreaming(b *testing.B) {
+	SetSHA256(sha256.New)
+	defer SetSHA256(nil)
+	BenchmarkTransferKey(b)
+}
+
+func BenchmarkHashContent64kStreaming(b *testing.B) {
+	SetSHA256(sha256.New)
+	defer SetSHA256(nil)
+	benchmarkHashContent(math.MaxUint16, b)
+}
@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ed25519"
+)
+
+// ErrSignatureAudit is returned by an auditing encoder if a fresh signature didn't check out.
+// Nothing is returned or committed to the sequence store in that case.
+var ErrSignatureAudit = errors.New("gabbygrove: signature audit failed")
+
+// SignatureVerifier checks sig of msg by pub, like ed25519.Verify
+type SignatureVerifier func(pub ed25519.PublicKey, msg, sig []byte) bool
+
+// signatureAudit is set by WithSignatureAudit
+type signatureAudit struct {
+	second SignatureVerifier
+}
+
+// WithSignatureAudit makes the encoder check every signature before it's handed out, since a published one can't be taken back.
+// ed25519 signatures are deterministic, so each event is signed twice and both need to be equal and valid.
+// If second is not nil, it's used as an additional, independent implementation to verify with.
+// This catches faulty hardware or a broken build of the crypto code and roughly triples the cost of signing.
+func (e *Encoder) WithSignatureAudit(yes bool, second SignatureVerifier) {
+	if !yes {
+		e.audit = nil
+		return
+	}
+	e.audit = &signatureAudit{second: second}
+}
+
+func (sa *signatureAudit) check(s Signer, msg, sig []byte) error {
+	again, err := s.Sign(msg)
+	if err != nil {
+		return errors.Wrap(err, "failed to sign for audit")
+	}
+	if !bytes.Equal(sig, again) {
+		return errors.Wrap(ErrSignatureAudit, "signing is not deterministic")
+	}
+
+	pub := s.Public()
+	if !ed25519.Verify(pub, msg, sig) {
+		return errors.Wrap(ErrSignatureAudit, "signature doesn't verify")
+	}
+	if sa.second != nil && !sa.second(pub, msg, sig) {
+		return errors.Wrap(ErrSignatureAudit, "signature doesn't verify with second implementation")
+	}
+	return nil
+}
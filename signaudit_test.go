@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+)
+
+// flakySigner flips a bit in the signature of every nth call
+type flakySigner struct {
+	privateKeySigner
+	calls, nth int
+}
+
+func (fs *flakySigner) Sign(msg []byte) ([]byte, error) {
+	sig, err := fs.privateKeySigner.Sign(msg)
+	fs.calls++
+	if fs.calls%fs.nth == 0 {
+		sig[0] ^= 1
+	}
+	return sig, err
+}
+
+func TestSignatureAudit(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("beef"), 8)))
+
+	var seconds int
+	second := func(pub ed25519.PublicKey, msg, sig []byte) bool {
+		seconds++
+		return ed25519.Verify(pub, msg, sig)
+	}
+
+	e := NewEncoder(privKey)
+	e.WithSignatureAudit(true, second)
+	r.NoError(e.WithHMAC(bytes.Repeat([]byte("h"), 32)))
+	tr, _, err := e.Encode(1, BinaryRef{}, []byte("fine"))
+	r.NoError(err)
+	a.Equal(1, seconds)
+	a.True(tr.Verify(e.hmacSecret))
+
+	// consistently wrong signatures are caught by verifying
+	e = NewSignerEncoder(&flakySigner{privateKeySigner: privateKeySigner(privKey), nth: 1})
+	e.WithSignatureAudit(true, nil)
+	_, _, err = e.Encode(1, BinaryRef{}, []byte("broken"))
+	a.Equal(ErrSignatureAudit, errors.Cause(err))
+
+	// and sporadic ones by signing twice
+	flaky := &flakySigner{privateKeySigner: privateKeySigner(privKey), nth: 2}
+	e = NewSignerEncoder(flaky)
+	e.WithSignatureAudit(true, nil)
+	_, _, err = e.Encode(1, BinaryRef{}, []byte("flaky"))
+	a.Equal(ErrSignatureAudit, errors.Cause(err))
+	a.Equal(2, flaky.calls)
+
+	e.WithSignatureAudit(false, nil)
+	tr, _, err = e.Encode(1, BinaryRef{}, []byte("unaudited"))
+	r.NoError(err, "flaky signer is fine on odd calls")
+	a.True(tr.Verify(nil))
+
+	// a disagreeing second implementation
+	e = NewEncoder(privKey)
+	e.WithSignatureAudit(true, func(ed25519.PublicKey, []byte, []byte) bool { return false })
+	_, _, err = e.Encode(1, BinaryRef{}, []byte("disagree"))
+	a.Equal(ErrSignatureAudit, errors.Cause(err))
+}
@@ -0,0 +1,27 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import "golang.org/x/crypto/ed25519"
+
+// Signer creates the signatures for an Encoder, so that it doesn't need to hold the private key itself
+type Signer interface {
+	// Public returns the key the signatures can be verified with, the author of the feed
+	Public() ed25519.PublicKey
+
+	// Sign returns the ed25519 signature of msg
+	Sign(msg []byte) ([]byte, error)
+}
+
+// privateKeySigner signs with a plain private key, as passed to NewEncoder
+type privateKeySigner ed25519.PrivateKey
+
+func (pks privateKeySigner) Public() ed25519.PublicKey {
+	return ed25519.PrivateKey(pks).Public().(ed25519.PublicKey)
+}
+
+func (pks privateKeySigner) Sign(msg []byte) ([]byte, error) {
+	return ed25519.Sign(ed25519.PrivateKey(pks), msg), nil
+}
@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// SigningAuditHook is called for every message an encoder signs, with the previous message (nil for the first one),
+// the hash of the content and the key of the new message. It runs before the message is committed to a SequenceStore
+// and handed out. If it fails, the message is discarded, so nothing is signed that isn't recorded.
+// CheckDeterministic signs too, but those signatures never leave the encoder and aren't reported.
+type SigningAuditHook func(seq uint64, prev *refs.MessageRef, content ContentRef, key refs.MessageRef) error
+
+// WithSigningAuditHook sets the hook that records what the key of the encoder signs, nil removes it.
+// A record of that is useful after a suspected key compromise, to tell messages of the owner from forged ones.
+func (e *Encoder) WithSigningAuditHook(hook SigningAuditHook) {
+	e.signingHook = hook
+}
+
+// auditSigning calls the hook for a new message
+func (e *Encoder) auditSigning(seq uint64, prev BinaryRef, content ContentRef, key refs.MessageRef) error {
+	if e.signingHook == nil {
+		return nil
+	}
+	var prevRef *refs.MessageRef
+	if seq > 1 {
+		r, err := prev.GetRef(RefTypeMessage)
+		if err != nil {
+			return errors.Wrap(err, "signing audit: invalid previous")
+		}
+		mr := r.(refs.MessageRef)
+		prevRef = &mr
+	}
+	if err := e.signingHook(seq, prevRef, content, key); err != nil {
+		return errors.Wrap(err, "signing audit: hook failed")
+	}
+	return nil
+}
+
+// AuditRecord is one line of the log written by AuditLogHook
+type AuditRecord struct {
+	Sequence uint64           `json:"sequence"`
+	Previous *refs.MessageRef `json:"previous"`
+	Content  ContentRef       `json:"content"`
+	Key      refs.MessageRef  `json:"key"`
+
+	// Signed is the local time of signing, which can differ from the claimed time of the message
+	Signed time.Time `json:"signed"`
+}
+
+// AuditLogHook returns a hook that appends a line of JSON for every signed message to w,
+// which should be a file opened with os.O_APPEND. Files are synced after every line.
+// The hook can be shared by encoders of different feeds.
+func AuditLogHook(w io.Writer) SigningAuditHook {
+	var mu sync.Mutex
+	return func(seq uint64, prev *refs.MessageRef, content ContentRef, key refs.MessageRef) error {
+		line, err := json.Marshal(AuditRecord{
+			Sequence: seq,
+			Previous: prev,
+			Content:  content,
+			Key:      key,
+			Signed:   now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			return err
+		}
+		if f, ok := w.(*os.File); ok {
+			return f.Sync()
+		}
+		return nil
+	}
+}
+
+// ReadAuditLog reads the records of a log written by AuditLogHook.
+// A partial last line, like after a crash while writing it, is an error.
+func ReadAuditLog(r io.Reader) ([]AuditRecord, error) {
+	var recs []AuditRecord
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		var rec AuditRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return recs, errors.Wrapf(err, "signing audit: broken record %d", len(recs)+1)
+		}
+		recs = append(recs, rec)
+	}
+	return recs, sc.Err()
+}
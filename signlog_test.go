@@ -0,0 +1,120 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+func TestSigningAuditHook(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+
+	var buf bytes.Buffer
+	e := NewEncoder(privKey)
+	e.WithSigningAuditHook(AuditLogHook(&buf))
+
+	tr1, key1, err := e.Encode(1, BinaryRef{}, "hello")
+	r.NoError(err)
+	prev, err := fromRef(key1)
+	r.NoError(err)
+	tr2, key2, err := e.Encode(2, prev, "world")
+	r.NoError(err)
+
+	recs, err := ReadAuditLog(&buf)
+	r.NoError(err)
+	r.Len(recs, 2)
+
+	a.EqualValues(1, recs[0].Sequence)
+	a.Nil(recs[0].Previous)
+	a.True(recs[0].Key.Equal(key1))
+	evt, err := tr1.getEvent()
+	r.NoError(err)
+	cr, err := evt.Content.Hash.GetRef(RefTypeContent)
+	r.NoError(err)
+	a.Equal(cr, recs[0].Content)
+
+	a.EqualValues(2, recs[1].Sequence)
+	r.NotNil(recs[1].Previous)
+	a.True(recs[1].Previous.Equal(key1))
+	a.True(recs[1].Key.Equal(key2))
+	evt, err = tr2.getEvent()
+	r.NoError(err)
+	cr, err = evt.Content.Hash.GetRef(RefTypeContent)
+	r.NoError(err)
+	a.Equal(cr, recs[1].Content)
+	a.False(recs[1].Signed.IsZero())
+
+	// a failing hook keeps the message from being handed out and committed
+	ss := NewMemSequenceStore()
+	r.NoError(ss.Commit(2, key2))
+	e.WithSequenceStore(ss)
+	e.WithSigningAuditHook(func(uint64, *refs.MessageRef, ContentRef, refs.MessageRef) error {
+		return errors.New("disk full")
+	})
+	prev, err = fromRef(key2)
+	r.NoError(err)
+	tr, _, err := e.Encode(3, prev, "lost")
+	a.Error(err)
+	a.Nil(tr, "unrecorded message escaped")
+	seq, _, err := ss.Load()
+	r.NoError(err)
+	a.EqualValues(2, seq)
+
+	e.WithSigningAuditHook(nil)
+	_, _, err = e.Encode(3, prev, "unrecorded")
+	r.NoError(err)
+}
+
+func TestAuditLogFile(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "signed.log")
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("beef"), 8)))
+
+	// the log survives reopening the file, like after a restart
+	var prev BinaryRef
+	for i := 1; i <= 4; i++ {
+		f, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		r.NoError(err)
+		e := NewEncoder(privKey)
+		e.WithSigningAuditHook(AuditLogHook(f))
+		_, key, err := e.Encode(uint64(i), prev, i)
+		r.NoError(err)
+		r.NoError(f.Close())
+		prev, err = fromRef(key)
+		r.NoError(err)
+	}
+
+	data, err := ioutil.ReadFile(fname)
+	r.NoError(err)
+	recs, err := ReadAuditLog(bytes.NewReader(data))
+	r.NoError(err)
+	r.Len(recs, 4)
+	for i, rec := range recs {
+		a.EqualValues(i+1, rec.Sequence)
+	}
+
+	// a partial last line
+	recs, err = ReadAuditLog(bytes.NewReader(data[:len(data)-20]))
+	a.Error(err)
+	a.Len(recs, 3)
+}
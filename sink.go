@@ -0,0 +1,141 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrOutsideWindow is returned for messages too far ahead of the feed to be buffered
+var ErrOutsideWindow = errors.New("gabbygrove: sequence outside of the buffer window")
+
+// VerifySink sits between the network and the database.
+// Transfers are taken in as a CBOR stream through Write or one by one with Push,
+// messages that arrive early are held back (up to window sequences ahead of the feed)
+// and Messages emits every verified message in feed order.
+//
+// Emitting blocks until the message is received, which in turn blocks Write and Push.
+// This way a slow consumer slows down the connection instead of filling up memory.
+type VerifySink struct {
+	mu     sync.Mutex
+	buf    *FeedBuffer
+	window uint64
+
+	out    chan *Transfer
+	closed bool
+
+	pw         *io.PipeWriter
+	decodeDone chan error
+}
+
+// NewVerifySink continues the feed described by state.
+// It buffers at most window messages ahead of the next expected sequence.
+func NewVerifySink(state *FeedState, window int) *VerifySink {
+	if window < 0 {
+		window = 0
+	}
+	pr, pw := io.Pipe()
+	vs := &VerifySink{
+		buf:    NewFeedBuffer(state, window),
+		window: uint64(window),
+
+		out: make(chan *Transfer),
+
+		pw:         pw,
+		decodeDone: make(chan error, 1),
+	}
+	go vs.decodeLoop(pr)
+	return vs
+}
+
+// Messages returns the verified messages in order. It is closed by Close.
+func (vs *VerifySink) Messages() <-chan *Transfer {
+	return vs.out
+}
+
+// Write takes in a stream of encoded transfers, which doesn't need to be split on message boundaries.
+// The stream can't be resumed after an invalid message, all following writes return that error.
+func (vs *VerifySink) Write(p []byte) (int, error) {
+	return vs.pw.Write(p)
+}
+
+func (vs *VerifySink) decodeLoop(pr *io.PipeReader) {
+	for {
+		var tr Transfer
+		err := tr.DecodeFrom(pr)
+		if err == io.EOF {
+			vs.decodeDone <- nil
+			return
+		}
+		if err == nil {
+			err = vs.Push(&tr)
+		}
+		if err != nil {
+			pr.CloseWithError(err)
+			vs.decodeDone <- err
+			return
+		}
+	}
+}
+
+// Push takes in a single transfer.
+// Messages the feed already has are ignored, messages ahead of it are buffered
+// and the next message is verified and emitted, together with the buffered ones following it.
+// An invalid next message is dropped and its error returned, the sink keeps waiting for a valid one.
+func (vs *VerifySink) Push(tr *Transfer) error {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	evt, err := tr.getEvent()
+	if err != nil {
+		return errors.Wrap(err, "gabbygrove/sink: invalid event")
+	}
+	if next := vs.buf.State().Sequence + 1; evt.Sequence > next && evt.Sequence-next > vs.window {
+		return errors.Wrapf(ErrOutsideWindow, "sink: got %d while expecting %d", evt.Sequence, next)
+	}
+
+	appended, err := vs.buf.Add(tr)
+	if err != nil {
+		return err
+	}
+	for _, tr := range appended {
+		vs.out <- tr
+	}
+	return nil
+}
+
+// Buffered returns the number of messages held back because earlier ones are still missing
+func (vs *VerifySink) Buffered() int {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	return vs.buf.Len()
+}
+
+// Missing returns the sequences that keep the buffered messages from being emitted
+func (vs *VerifySink) Missing() []SeqRange {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	return vs.buf.Missing()
+}
+
+// Close ends the Write stream and closes Messages.
+// It returns the error that broke the stream, if any.
+func (vs *VerifySink) Close() error {
+	vs.pw.Close()
+	err := <-vs.decodeDone
+	// unblock a second Close
+	vs.decodeDone <- err
+
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	if !vs.closed {
+		close(vs.out)
+		vs.closed = true
+	}
+	return err
+}
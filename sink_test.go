@@ -0,0 +1,108 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifySinkPush(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	author, trs := makeTestFeed(t, "dead", 5)
+	vs := NewVerifySink(NewFeedState(author), 2)
+
+	var got []*Transfer
+	done := make(chan struct{})
+	go func() {
+		for tr := range vs.Messages() {
+			got = append(got, tr)
+		}
+		close(done)
+	}()
+
+	r.NoError(vs.Push(trs[2]))
+	r.NoError(vs.Push(trs[1]))
+	a.Equal(2, vs.Buffered())
+	a.Equal([]SeqRange{{Author: author, From: 1, To: 1}}, vs.Missing())
+	a.Equal(ErrOutsideWindow, errors.Cause(vs.Push(trs[4])))
+
+	_, otherTrs := makeTestFeed(t, "beef", 3)
+	a.Equal(ErrWrongAuthor, errors.Cause(vs.Push(otherTrs[2])))
+
+	r.NoError(vs.Push(trs[0]))
+	a.Equal(0, vs.Buffered())
+	r.NoError(vs.Push(trs[0]), "duplicates are ignored")
+
+	broken := *trs[3]
+	broken.Signature = append([]byte{}, trs[3].Signature...)
+	broken.Signature[0] ^= 1
+	a.Equal(ErrInvalidSignature, errors.Cause(vs.Push(&broken)))
+
+	r.NoError(vs.Push(trs[3]))
+	r.NoError(vs.Push(trs[4]))
+
+	r.NoError(vs.Close())
+	<-done
+	r.Len(got, 5)
+	for i, tr := range got {
+		a.Equal(trs[i].Key(), tr.Key())
+	}
+}
+
+func TestVerifySinkWrite(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	author, trs := makeTestFeed(t, "dead", 4)
+	vs := NewVerifySink(NewFeedState(author), 4)
+
+	var stream []byte
+	for _, i := range []int{1, 0, 3, 2} {
+		b, err := trs[i].MarshalCBOR()
+		r.NoError(err)
+		stream = append(stream, b...)
+	}
+
+	written := make(chan error, 1)
+	go func() {
+		// split in odd chunks, like reads from a connection
+		for len(stream) > 0 {
+			n := 100
+			if n > len(stream) {
+				n = len(stream)
+			}
+			if _, err := vs.Write(stream[:n]); err != nil {
+				written <- err
+				return
+			}
+			stream = stream[n:]
+		}
+		written <- vs.Close()
+	}()
+
+	var seq int64
+	for tr := range vs.Messages() {
+		seq++
+		a.Equal(seq, tr.Seq())
+	}
+	a.EqualValues(4, seq)
+	r.NoError(<-written)
+
+	// a broken stream can't continue
+	vs = NewVerifySink(NewFeedState(author), 4)
+	go func() {
+		for range vs.Messages() {
+		}
+	}()
+	_, err := vs.Write([]byte{0xff, 0xff, 0xff})
+	a.Error(err)
+	a.Error(vs.Close())
+}
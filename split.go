@@ -0,0 +1,84 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// The content of a message is off-chain, only its hash is signed.
+// EventOnly and ContentMessage split a transfer into two parts that can be shipped independently,
+// for instance to replicate all the events of a feed first and fetch the content later.
+// Combine puts them back together.
+
+// EventOnly returns the encoded transfer without its content.
+// It's a regular transfer and can be decoded as such.
+func (tr *Transfer) EventOnly() ([]byte, error) {
+	eventOnly := *tr
+	eventOnly.Content = nil
+	return eventOnly.MarshalCBOR()
+}
+
+// ContentMessage returns the content together with the key of its message, as a CBOR array of both.
+func (tr *Transfer) ContentMessage() ([]byte, error) {
+	if !tr.HasContent() {
+		return nil, ErrNoContent
+	}
+	key, err := fromRef(tr.Key())
+	if err != nil {
+		return nil, err
+	}
+	b := appendCBORHead(nil, cborMajorArray, 2)
+	if b, err = appendCBORRef(b, &key); err != nil {
+		return nil, err
+	}
+	return appendCBORBytes(b, tr.Content), nil
+}
+
+// Combine decodes the parts made by EventOnly and ContentMessage into a transfer.
+// It checks that the content belongs to the event and that the signature is valid, hmacKey is nil for feeds without one.
+func Combine(eventOnly, contentMsg []byte, hmacKey *[32]byte) (*Transfer, error) {
+	var tr Transfer
+	if err := tr.UnmarshalCBOR(eventOnly); err != nil {
+		return nil, errors.Wrap(err, "gabbygrove/combine: invalid event")
+	}
+	if tr.HasContent() {
+		return nil, errors.Errorf("gabbygrove/combine: event already has content")
+	}
+
+	p := cborParser{data: contentMsg}
+	if n, err := p.expect(cborMajorArray); err != nil || n != 2 {
+		return nil, errors.Errorf("gabbygrove/combine: invalid content message")
+	}
+	key, err := p.ref()
+	if err != nil || key == nil {
+		return nil, errors.Errorf("gabbygrove/combine: invalid message key: %v", err)
+	}
+	content, err := p.bytes()
+	if err != nil || content == nil {
+		return nil, errors.Errorf("gabbygrove/combine: invalid content: %v", err)
+	}
+	if p.off != len(contentMsg) {
+		return nil, errors.Errorf("gabbygrove/combine: %d trailing bytes after content", len(contentMsg)-p.off)
+	}
+
+	mref, err := key.GetRef(RefTypeMessage)
+	if err != nil {
+		return nil, errors.Wrap(err, "gabbygrove/combine: invalid message key")
+	}
+	if !mref.(refs.MessageRef).Equal(tr.Key()) {
+		return nil, errors.Errorf("gabbygrove/combine: content is for another message")
+	}
+	if !tr.ContentMatches(content) {
+		return nil, errors.Errorf("gabbygrove/combine: content does not match the event")
+	}
+	if !tr.Verify(hmacKey) {
+		return nil, errors.Wrap(ErrInvalidSignature, "combine")
+	}
+
+	tr.Content = append([]byte{}, content...)
+	return &tr, nil
+}
@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitCombine(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, trs := makeTestFeed(t, "dead", 2)
+
+	evt1, err := trs[0].EventOnly()
+	r.NoError(err)
+	content1, err := trs[0].ContentMessage()
+	r.NoError(err)
+	a.True(trs[0].HasContent(), "splitting doesn't change the transfer")
+
+	// the event part is a transfer without content
+	var eventOnly Transfer
+	r.NoError(eventOnly.UnmarshalCBOR(evt1))
+	a.False(eventOnly.HasContent())
+	a.Equal(trs[0].Key(), eventOnly.Key())
+
+	combined, err := Combine(evt1, content1, nil)
+	r.NoError(err)
+	a.Equal(trs[0].Event, combined.Event)
+	a.Equal(trs[0].Signature, combined.Signature)
+	a.Equal(trs[0].Content, combined.Content)
+
+	// content of another message
+	content2, err := trs[1].ContentMessage()
+	r.NoError(err)
+	_, err = Combine(evt1, content2, nil)
+	a.Error(err)
+
+	// a full transfer is not an event part
+	full, err := trs[0].MarshalCBOR()
+	r.NoError(err)
+	_, err = Combine(full, content1, nil)
+	a.Error(err)
+
+	// tampered content
+	tampered := append([]byte{}, content1...)
+	tampered[len(tampered)-1] ^= 1
+	_, err = Combine(evt1, tampered, nil)
+	a.Error(err)
+	_, err = Combine(evt1, append(content1, 0), nil)
+	a.Error(err)
+
+	// the signature is checked too
+	var key [32]byte
+	_, err = Combine(evt1, content1, &key)
+	a.Equal(ErrInvalidSignature, errors.Cause(err))
+
+	eventOnly.Content = nil
+	_, err = eventOnly.ContentMessage()
+	a.Equal(ErrNoContent, err)
+}
@@ -0,0 +1,134 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+// Package sshagent signs gabbygrove events with an ed25519 key held by an SSH agent (or a hardware token behind one),
+// so the private key never enters the process.
+//
+// Agents sign ssh-ed25519 keys with plain ed25519 over the data, which makes the signatures the same as local ones.
+//
+//	s, err := sshagent.Dial(nil)
+//	…
+//	defer s.Close()
+//	enc := gabbygrove.NewSignerEncoder(s)
+package sshagent
+
+import (
+	"bytes"
+	"net"
+	"os"
+
+	"github.com/pkg/errors"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ErrNoKey is returned if the agent doesn't hold a matching ed25519 key
+var ErrNoKey = errors.New("sshagent: no matching ed25519 key in agent")
+
+// ErrAmbiguousKey is returned if no key was asked for and the agent holds more than one ed25519 key
+var ErrAmbiguousKey = errors.New("sshagent: more than one ed25519 key in agent")
+
+// Signer signs with a key of an agent. It's safe for concurrent use.
+type Signer struct {
+	agent agent.Agent
+	key   ssh.PublicKey
+	pub   ed25519.PublicKey
+
+	conn net.Conn
+}
+
+var _ gabbygrove.Signer = (*Signer)(nil)
+
+// New returns a Signer for pub, which needs to be held by a.
+// If pub is nil, the agent has to hold exactly one ed25519 key, which is used.
+func New(a agent.Agent, pub ed25519.PublicKey) (*Signer, error) {
+	keys, err := a.List()
+	if err != nil {
+		return nil, errors.Wrap(err, "sshagent: failed to list keys")
+	}
+
+	var s *Signer
+	for _, k := range keys {
+		if k.Type() != ssh.KeyAlgoED25519 {
+			continue
+		}
+		parsed, err := ssh.ParsePublicKey(k.Marshal())
+		if err != nil {
+			return nil, errors.Wrap(err, "sshagent: failed to parse agent key")
+		}
+		cpk, ok := parsed.(ssh.CryptoPublicKey)
+		if !ok {
+			continue
+		}
+		edPub, ok := cpk.CryptoPublicKey().(ed25519.PublicKey)
+		if !ok {
+			continue
+		}
+		if pub != nil {
+			if bytes.Equal(edPub, pub) {
+				return &Signer{agent: a, key: parsed, pub: edPub}, nil
+			}
+			continue
+		}
+		if s != nil {
+			return nil, ErrAmbiguousKey
+		}
+		s = &Signer{agent: a, key: parsed, pub: edPub}
+	}
+	if s == nil {
+		return nil, ErrNoKey
+	}
+	return s, nil
+}
+
+// Dial connects to the agent at $SSH_AUTH_SOCK and returns a Signer for pub, see New.
+// Close the Signer to close the connection.
+func Dial(pub ed25519.PublicKey) (*Signer, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, errors.New("sshagent: SSH_AUTH_SOCK not set")
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, errors.Wrap(err, "sshagent: failed to connect to agent")
+	}
+	s, err := New(agent.NewClient(conn), pub)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	s.conn = conn
+	return s, nil
+}
+
+// Public returns the public key of the agent key
+func (s *Signer) Public() ed25519.PublicKey {
+	return s.pub
+}
+
+// Sign asks the agent for a signature of msg.
+// The signature is checked before it's returned, since it comes from outside of the process.
+func (s *Signer) Sign(msg []byte) ([]byte, error) {
+	sig, err := s.agent.Sign(s.key, msg)
+	if err != nil {
+		return nil, errors.Wrap(err, "sshagent: agent failed to sign")
+	}
+	if sig.Format != ssh.KeyAlgoED25519 {
+		return nil, errors.Errorf("sshagent: unexpected signature format: %s", sig.Format)
+	}
+	if len(sig.Blob) != ed25519.SignatureSize || !ed25519.Verify(s.pub, msg, sig.Blob) {
+		return nil, errors.New("sshagent: agent returned an invalid signature")
+	}
+	return sig.Blob, nil
+}
+
+// Close closes the connection to the agent if the Signer was created with Dial
+func (s *Signer) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
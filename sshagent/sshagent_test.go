@@ -0,0 +1,111 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package sshagent
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func newKey(t *testing.T, seed string) ed25519.PrivateKey {
+	_, priv, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte(seed), 8)))
+	require.NoError(t, err)
+	return priv
+}
+
+func TestSigner(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	kr := agent.NewKeyring()
+	_, err := New(kr, nil)
+	a.Equal(ErrNoKey, err)
+
+	priv := newKey(t, "beef")
+	r.NoError(kr.Add(agent.AddedKey{PrivateKey: priv}))
+
+	s, err := New(kr, nil)
+	r.NoError(err)
+	a.Equal(priv.Public(), s.Public())
+
+	// same messages as with the local key
+	tr, _, err := gabbygrove.NewSignerEncoder(s).Encode(1, gabbygrove.BinaryRef{}, []byte("hello"))
+	r.NoError(err)
+	want, _, err := gabbygrove.NewEncoder(priv).Encode(1, gabbygrove.BinaryRef{}, []byte("hello"))
+	r.NoError(err)
+	a.Equal(want.Signature, tr.Signature)
+	a.True(tr.Verify(nil))
+
+	other := newKey(t, "dead")
+	r.NoError(kr.Add(agent.AddedKey{PrivateKey: other}))
+	_, err = New(kr, nil)
+	a.Equal(ErrAmbiguousKey, err)
+	s, err = New(kr, other.Public().(ed25519.PublicKey))
+	r.NoError(err)
+	a.Equal(other.Public(), s.Public())
+
+	_, err = New(kr, newKey(t, "acab").Public().(ed25519.PublicKey))
+	a.Equal(ErrNoKey, err)
+
+	// keys removed from the agent can't sign anymore
+	r.NoError(kr.RemoveAll())
+	_, err = s.Sign([]byte("gone"))
+	a.Error(err)
+}
+
+func TestDial(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "sshagent")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "agent.sock")
+
+	l, err := net.Listen("unix", sock)
+	r.NoError(err)
+	defer l.Close()
+
+	kr := agent.NewKeyring()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	r.NoError(err)
+	r.NoError(kr.Add(agent.AddedKey{PrivateKey: priv}))
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(kr, c)
+		}
+	}()
+
+	old := os.Getenv("SSH_AUTH_SOCK")
+	defer os.Setenv("SSH_AUTH_SOCK", old)
+	os.Setenv("SSH_AUTH_SOCK", sock)
+
+	s, err := Dial(nil)
+	r.NoError(err)
+	defer s.Close()
+
+	sig, err := s.Sign([]byte("over the wire"))
+	r.NoError(err)
+	a.True(ed25519.Verify(priv.Public().(ed25519.PublicKey), []byte("over the wire"), sig))
+
+	os.Setenv("SSH_AUTH_SOCK", "")
+	_, err = Dial(nil)
+	a.Error(err)
+}
@@ -0,0 +1,157 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+// Package boltindex implements the store.Index on top of a bbolt database.
+//
+// The sequences bucket holds a bucket per author (keyed by the public key),
+// mapping the big-endian sequence to the offset of the message in the log.
+// The keys bucket maps the hash of a message to the public key of its author and its sequence.
+package boltindex
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+	"go.mindeco.de/ssb-gabbygrove/store"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+var (
+	sequencesBucket = []byte("sequences")
+	keysBucket      = []byte("keys")
+)
+
+// Index is a store.Index backed by bbolt
+type Index struct {
+	db *bolt.DB
+}
+
+var (
+	_ store.Index         = (*Index)(nil)
+	_ store.OffsetUpdater = (*Index)(nil)
+)
+
+// New creates the buckets in db if needed. The database is not closed by the index.
+func New(db *bolt.DB) (*Index, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{sequencesBucket, keysBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "boltindex: failed to create buckets")
+	}
+	return &Index{db: db}, nil
+}
+
+// Put records that key is the message of author at seq
+func (idx *Index) Put(author refs.FeedRef, seq uint64, key refs.MessageRef, offset int64) error {
+	hash := make([]byte, 32)
+	if err := key.CopyHashTo(hash); err != nil {
+		return errors.Wrap(err, "boltindex: invalid key")
+	}
+
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		seqs, err := tx.Bucket(sequencesBucket).CreateBucketIfNotExists(author.PubKey())
+		if err != nil {
+			return err
+		}
+		var want uint64 = 1
+		if last, _ := seqs.Cursor().Last(); last != nil {
+			want = binary.BigEndian.Uint64(last) + 1
+		}
+		if seq != want {
+			return errors.Errorf("boltindex: expected sequence %d got %d", want, seq)
+		}
+
+		if err := seqs.Put(uint64Bytes(seq), uint64Bytes(uint64(offset))); err != nil {
+			return err
+		}
+		return tx.Bucket(keysBucket).Put(hash, append(append([]byte{}, author.PubKey()...), uint64Bytes(seq)...))
+	})
+}
+
+// Offset returns where the message of author at seq starts in its log
+func (idx *Index) Offset(author refs.FeedRef, seq uint64) (int64, error) {
+	var offset int64
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		var v []byte
+		if seqs := tx.Bucket(sequencesBucket).Bucket(author.PubKey()); seqs != nil {
+			v = seqs.Get(uint64Bytes(seq))
+		}
+		if v == nil {
+			return errors.Wrapf(store.ErrNotFound, "sequence %d", seq)
+		}
+		offset = int64(binary.BigEndian.Uint64(v))
+		return nil
+	})
+	return offset, err
+}
+
+// UpdateOffset changes the offset of the already indexed message of author at seq, see store.Store.Compact
+func (idx *Index) UpdateOffset(author refs.FeedRef, seq uint64, offset int64) error {
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		seqs := tx.Bucket(sequencesBucket).Bucket(author.PubKey())
+		if seqs == nil || seqs.Get(uint64Bytes(seq)) == nil {
+			return errors.Wrapf(store.ErrNotFound, "sequence %d", seq)
+		}
+		return seqs.Put(uint64Bytes(seq), uint64Bytes(uint64(offset)))
+	})
+}
+
+// Lookup returns the author and sequence of the message with key
+func (idx *Index) Lookup(key refs.MessageRef) (refs.FeedRef, uint64, error) {
+	hash := make([]byte, 32)
+	if err := key.CopyHashTo(hash); err != nil {
+		return refs.FeedRef{}, 0, errors.Wrap(err, "boltindex: invalid key")
+	}
+
+	var (
+		author refs.FeedRef
+		seq    uint64
+	)
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(keysBucket).Get(hash)
+		if v == nil {
+			return errors.Wrapf(store.ErrNotFound, "key %s", key.ShortSigil())
+		}
+		if len(v) != 32+8 {
+			return errors.Errorf("boltindex: corrupted entry for %s", key.ShortSigil())
+		}
+		var err error
+		author, err = refs.NewFeedRefFromBytes(v[:32], refs.RefAlgoFeedGabby)
+		if err != nil {
+			return err
+		}
+		seq = binary.BigEndian.Uint64(v[32:])
+		return nil
+	})
+	return author, seq, err
+}
+
+// Sequence returns the highest sequence of author in the index
+func (idx *Index) Sequence(author refs.FeedRef) (uint64, error) {
+	var seq uint64
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		seqs := tx.Bucket(sequencesBucket).Bucket(author.PubKey())
+		if seqs == nil {
+			return nil
+		}
+		if last, _ := seqs.Cursor().Last(); last != nil {
+			seq = binary.BigEndian.Uint64(last)
+		}
+		return nil
+	})
+	return seq, err
+}
+
+func uint64Bytes(v uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	return b[:]
+}
@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package boltindex
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	"go.mindeco.de/ssb-gabbygrove/store"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestBoltIndex(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "boltindex")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	pub, priv, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	r.NoError(err)
+	author, err := refs.NewFeedRefFromBytes(pub, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+	e := gabbygrove.NewEncoder(priv)
+	state := gabbygrove.NewFeedState(author)
+
+	var trs []*gabbygrove.Transfer
+	for i := 0; i < 4; i++ {
+		seq, prev := state.Next()
+		tr, _, err := e.Encode(seq, prev, map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+		r.NoError(state.Append(tr))
+		trs = append(trs, tr)
+	}
+
+	// store the first two without the index
+	s, err := store.Open(filepath.Join(dir, "logs"))
+	r.NoError(err)
+	r.NoError(s.Append(trs[0]))
+	r.NoError(s.Append(trs[1]))
+	r.NoError(s.Close())
+
+	db, err := bolt.Open(filepath.Join(dir, "index.db"), 0600, nil)
+	r.NoError(err)
+	defer db.Close()
+	idx, err := New(db)
+	r.NoError(err)
+
+	s, err = store.Open(filepath.Join(dir, "logs"))
+	r.NoError(err)
+	defer s.Close()
+	s.WithIndex(idx)
+
+	// opening the feed catches the index up
+	tip, err := s.Tip(author)
+	r.NoError(err)
+	a.EqualValues(2, tip.Sequence)
+	seq, err := idx.Sequence(author)
+	r.NoError(err)
+	a.EqualValues(2, seq)
+
+	r.NoError(s.Append(trs[2]))
+	r.NoError(s.Append(trs[3]))
+
+	gotAuthor, gotSeq, err := idx.Lookup(trs[2].Key())
+	r.NoError(err)
+	a.True(author.Equal(gotAuthor))
+	a.EqualValues(3, gotSeq)
+
+	got, err := s.GetByKey(trs[3].Key())
+	r.NoError(err)
+	a.Equal(trs[3].Key(), got.Key())
+
+	off1, err := idx.Offset(author, 1)
+	r.NoError(err)
+	a.EqualValues(0, off1)
+	off2, err := idx.Offset(author, 2)
+	r.NoError(err)
+	b, err := trs[0].MarshalCBOR()
+	r.NoError(err)
+	a.EqualValues(len(b), off2)
+
+	_, err = idx.Offset(author, 5)
+	a.Equal(store.ErrNotFound, errors.Cause(err))
+	_, _, err = idx.Lookup(refs.MessageRef{})
+	a.Error(err)
+
+	a.Error(idx.Put(author, 7, trs[0].Key(), 0), "gaps are rejected")
+
+	// compacting the store moves messages after deleted content
+	r.NoError(s.DeleteContent(author, 1))
+	r.NoError(s.Compact())
+	off2, err = idx.Offset(author, 2)
+	r.NoError(err)
+	a.True(off2 < int64(len(b)), "offset not updated: %d", off2)
+	problems, err := s.Fsck()
+	r.NoError(err)
+	a.Len(problems, 0, "%v", problems)
+	a.Error(idx.UpdateOffset(author, 5, 0))
+}
@@ -0,0 +1,216 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"encoding/binary"
+	"os"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// OffsetUpdater is implemented by indexes that can change the offset of an indexed message, which Compact needs
+type OffsetUpdater interface {
+	UpdateOffset(author refs.FeedRef, seq uint64, offset int64) error
+}
+
+// compactInfix marks the files of a compaction in progress, a file with only the infix commits it
+const compactInfix = ".compact"
+
+var compactSuffixes = []string{logSuffix, indexSuffix, deletedSuffix, segmentsSuffix, segmentIdxSuffix}
+
+// Compact rewrites the feeds with deleted content without it, see DeleteContent.
+// The new files are written next to the old ones and moved into place once they are complete,
+// so a crash leaves either the old or the new feed. Messages keep their sequence and key but not their offset,
+// so an index needs to be an OffsetUpdater.
+func (s *Store) Compact() error {
+	feeds, err := s.Feeds()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	segmentSize := s.segmentSize
+	s.mu.Unlock()
+
+	for _, author := range feeds {
+		f, err := s.feed(author, false)
+		if err != nil {
+			return err
+		}
+		if err := f.compact(author, segmentSize); err != nil {
+			return errors.Wrapf(err, "store: failed to compact %s", author.ShortSigil())
+		}
+	}
+	return nil
+}
+
+func (f *feed) compact(author refs.FeedRef, segmentSize int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.deleted) == 0 {
+		return nil
+	}
+	if err := f.sync(); err != nil {
+		return err
+	}
+	var updater OffsetUpdater
+	if f.index != nil {
+		var ok bool
+		if updater, ok = f.index.(OffsetUpdater); !ok {
+			return errors.Errorf("index can't update offsets")
+		}
+	}
+
+	if err := f.writeCompacted(); err != nil {
+		removeCompaction(f.path)
+		return err
+	}
+	marker, err := os.Create(f.path + compactInfix)
+	if err != nil {
+		removeCompaction(f.path)
+		return err
+	}
+	if err := marker.Sync(); err != nil {
+		marker.Close()
+		removeCompaction(f.path)
+		return err
+	}
+	marker.Close()
+
+	// from here on the new files win, also if opening them fails now and it's retried later
+	if err := f.close(); err != nil {
+		return err
+	}
+	nf, err := openFeed(f.path, author, f.content, segmentSize, f.sealer)
+	if err != nil {
+		return err
+	}
+	f.log, f.idxFile, f.delFile = nf.log, nf.idxFile, nf.delFile
+	f.segs, f.base = nf.segs, nf.base
+	f.deleted, f.ends = nf.deleted, nf.ends
+
+	if updater != nil {
+		for seq := uint64(1); seq <= uint64(len(f.ends)); seq++ {
+			if err := updater.UpdateOffset(author, seq, f.start(seq)); err != nil {
+				return errors.Wrapf(err, "failed to update the offset of %d", seq)
+			}
+		}
+	}
+	return nil
+}
+
+// writeCompacted writes the messages without deleted content to the compaction files
+func (f *feed) writeCompacted() error {
+	tmp := f.path + compactInfix
+	log, err := os.OpenFile(tmp+logSuffix, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+
+	var sg *segments
+	if f.segs != nil {
+		data, err := os.OpenFile(tmp+segmentsSuffix, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		idx, err := os.OpenFile(tmp+segmentIdxSuffix, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			data.Close()
+			return err
+		}
+		sg = &segments{data: data, idx: idx, size: f.segs.size, cached: -1}
+		defer sg.close()
+		if err := sg.load(); err != nil {
+			return err
+		}
+	}
+
+	var (
+		ends    = make([]int64, 0, len(f.ends))
+		offset  int64
+		pending []byte
+		logged  int64
+	)
+	sealed := f.segs.messages()
+	for seq := uint64(1); seq <= uint64(len(f.ends)); seq++ {
+		tr, err := f.readLogged(seq)
+		if err != nil {
+			return err
+		}
+		if _, has := f.deleted[seq]; has {
+			tr.Content = nil
+		}
+		data, err := tr.MarshalReceived()
+		if err != nil {
+			return errors.Wrapf(err, "failed to marshal %d", seq)
+		}
+		if data, err = f.sealer.seal(seq, data); err != nil {
+			return err
+		}
+		offset += int64(len(data))
+		ends = append(ends, offset)
+
+		if seq <= sealed {
+			pending = append(pending, data...)
+			if seq%sg.size == 0 {
+				if err := sg.add(pending); err != nil {
+					return err
+				}
+				pending = nil
+			}
+			continue
+		}
+		if _, err := log.WriteAt(data, logged); err != nil {
+			return err
+		}
+		logged += int64(len(data))
+	}
+	if err := log.Sync(); err != nil {
+		return err
+	}
+
+	idxData := make([]byte, len(ends)*indexEntrySize)
+	for i, end := range ends {
+		binary.BigEndian.PutUint64(idxData[i*indexEntrySize:], uint64(end))
+	}
+	if err := writeFileSynced(tmp+indexSuffix, idxData); err != nil {
+		return err
+	}
+	if err := writeFileSynced(tmp+deletedSuffix, nil); err != nil {
+		return err
+	}
+	return nil
+}
+
+// recoverCompaction moves the files of a committed compaction of the feed at base into place and removes an unfinished one
+func recoverCompaction(base string) error {
+	marker := base + compactInfix
+	_, err := os.Stat(marker)
+	if os.IsNotExist(err) {
+		return removeCompaction(base)
+	}
+	if err != nil {
+		return err
+	}
+	for _, suffix := range compactSuffixes {
+		err := os.Rename(marker+suffix, base+suffix)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "failed to move compacted file")
+		}
+	}
+	return os.Remove(marker)
+}
+
+func removeCompaction(base string) error {
+	for _, suffix := range compactSuffixes {
+		if err := os.Remove(base + compactInfix + suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
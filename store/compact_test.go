@@ -0,0 +1,187 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+)
+
+// makeBigFeed makes a feed with content that compaction saves space on
+func makeBigFeed(t *testing.T, seed string, n int) (refs.FeedRef, []*gabbygrove.Transfer) {
+	r := require.New(t)
+
+	pub, priv, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte(seed), 32/len(seed))))
+	r.NoError(err)
+	author, err := refs.NewFeedRefFromBytes(pub, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+
+	e := gabbygrove.NewEncoder(priv)
+	state := gabbygrove.NewFeedState(author)
+	var trs []*gabbygrove.Transfer
+	for i := 0; i < n; i++ {
+		seq, prev := state.Next()
+		tr, _, err := e.Encode(seq, prev, bytes.Repeat([]byte{byte(i)}, 2048))
+		r.NoError(err)
+		r.NoError(state.Append(tr))
+		trs = append(trs, tr)
+	}
+	return author, trs
+}
+
+func TestStoreCompact(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbystore")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	r.NoError(err)
+	s.WithIndex(NewMemIndex())
+	s.WithCompression(3)
+
+	alice, trs := makeBigFeed(t, "dead", 9)
+	bob, bobTrs := makeFeed(t, "beef", 2)
+	base := filepath.Join(dir, hex.EncodeToString(alice.PubKey()))
+	for _, tr := range trs[:8] {
+		r.NoError(s.Append(tr))
+	}
+	for _, tr := range bobTrs {
+		r.NoError(s.Append(tr))
+	}
+
+	// one in a segment and one in the tail of the log
+	r.NoError(s.DeleteContent(alice, 2))
+	r.NoError(s.DeleteContent(alice, 8))
+
+	size := func(suffix string) int64 {
+		fi, err := os.Stat(base + suffix)
+		r.NoError(err)
+		return fi.Size()
+	}
+	logSize, segSize := size(logSuffix), size(segmentsSuffix)
+	bobLog, err := ioutil.ReadFile(filepath.Join(dir, hex.EncodeToString(bob.PubKey())+logSuffix))
+	r.NoError(err)
+
+	r.NoError(s.Compact())
+	a.True(size(logSuffix) < logSize, "log didn't shrink")
+	a.True(size(segmentsSuffix) < segSize, "segments didn't shrink")
+	a.EqualValues(0, size(deletedSuffix))
+	for _, suffix := range compactSuffixes {
+		_, err := os.Stat(base + compactInfix + suffix)
+		a.True(os.IsNotExist(err), suffix)
+	}
+	bobAfter, err := ioutil.ReadFile(filepath.Join(dir, hex.EncodeToString(bob.PubKey())+logSuffix))
+	r.NoError(err)
+	a.Equal(bobLog, bobAfter, "feeds without deleted content are left alone")
+
+	check := func(s *Store, n int) {
+		for i, want := range trs[:n] {
+			seq := uint64(i + 1)
+			got, err := s.Get(alice, seq)
+			r.NoError(err)
+			a.Equal(want.Key(), got.Key())
+			if seq == 2 || seq == 8 {
+				a.False(got.HasContent(), "seq %d", seq)
+			} else {
+				a.Equal(want.Content, got.Content, "seq %d", seq)
+			}
+			byKey, err := s.GetByKey(want.Key())
+			r.NoError(err)
+			a.Equal(want.Key(), byKey.Key())
+		}
+		problems, err := s.Fsck()
+		r.NoError(err)
+		a.Len(problems, 0, "%v", problems)
+	}
+	check(s, 8)
+
+	// still compressed and appendable
+	r.NoError(s.Append(trs[8]))
+	check(s, 9)
+	r.NoError(s.Close())
+
+	s, err = Open(dir)
+	r.NoError(err)
+	defer s.Close()
+	s.WithIndex(NewMemIndex())
+	check(s, 9)
+	r.NoError(s.Compact(), "nothing to do")
+}
+
+func TestStoreCompactRecovery(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbystore")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	r.NoError(err)
+	alice, trs := makeBigFeed(t, "dead", 3)
+	base := filepath.Join(dir, hex.EncodeToString(alice.PubKey()))
+	for _, tr := range trs {
+		r.NoError(s.Append(tr))
+	}
+	r.NoError(s.DeleteContent(alice, 1))
+
+	f, err := s.feed(alice, false)
+	r.NoError(err)
+	f.mu.Lock()
+	r.NoError(f.writeCompacted())
+	f.mu.Unlock()
+	logSize := func() int64 {
+		fi, err := os.Stat(base + logSuffix)
+		r.NoError(err)
+		return fi.Size()
+	}
+	before := logSize()
+	r.NoError(s.Close())
+
+	// without the marker the compaction didn't finish and is dropped
+	s, err = Open(dir)
+	r.NoError(err)
+	_, err = s.Tip(alice)
+	r.NoError(err)
+	a.Equal(before, logSize())
+	_, err = os.Stat(base + compactInfix + logSuffix)
+	a.True(os.IsNotExist(err))
+
+	// with it, it's finished
+	f, err = s.feed(alice, false)
+	r.NoError(err)
+	f.mu.Lock()
+	r.NoError(f.writeCompacted())
+	f.mu.Unlock()
+	r.NoError(ioutil.WriteFile(base+compactInfix, nil, 0600))
+	r.NoError(s.Close())
+
+	s, err = Open(dir)
+	r.NoError(err)
+	defer s.Close()
+	tip, err := s.Tip(alice)
+	r.NoError(err)
+	a.EqualValues(3, tip.Sequence)
+	a.True(logSize() < before)
+	got, err := s.Get(alice, 1)
+	r.NoError(err)
+	a.False(got.HasContent())
+	got, err = s.Get(alice, 3)
+	r.NoError(err)
+	a.Equal(trs[2].Content, got.Content)
+}
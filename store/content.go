@@ -0,0 +1,297 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+const (
+	contentDir       = "content"
+	contentRefSuffix = ".refs"
+	deletedSuffix    = ".del"
+)
+
+// WithSharedContent makes the store keep content of at least minSize bytes once for all feeds, zero turns it off.
+// Identical content that different authors posted (i.e. reposts) is then stored in one file named after its hash,
+// with a count of the messages referencing it, and the logs only mark that their content is shared.
+// Messages that were stored before are unaffected, reading shared content works either way.
+func (s *Store) WithSharedContent(minSize int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shareMin = minSize
+}
+
+// DeleteContent drops the content of the message of author at seq, it's returned without it from then on.
+// The event stays to keep the chain of the feed intact. Shared content is removed once no message references it.
+// The content of a tombstone or revocation is kept, the store needs it to restore how the feed ended.
+func (s *Store) DeleteContent(author refs.FeedRef, seq uint64) error {
+	f, err := s.feed(author, false)
+	if err != nil {
+		return err
+	}
+	return f.deleteContent(seq)
+}
+
+// ContentReferences returns how many stored messages share the content with hash ref, zero if it's not shared
+func (s *Store) ContentReferences(ref gabbygrove.BinaryRef) (uint64, error) {
+	name, err := s.content.contentName(ref)
+	if err != nil {
+		return 0, err
+	}
+	return s.content.refs(name)
+}
+
+// contentName returns the name of the shared file for content with hash ref
+func (sc *sharedContent) contentName(ref gabbygrove.BinaryRef) (string, error) {
+	b, err := ref.MarshalBinary()
+	if err != nil || len(b) < 2 {
+		return "", errors.Errorf("store: invalid content hash")
+	}
+	return sc.name(b[1:]), nil
+}
+
+// name turns the sha256 hash of content into its file name.
+// In an encrypted store it's a keyed hash, since the plain one would tell which known content the store holds.
+func (sc *sharedContent) name(hash []byte) string {
+	if sc.nameKey == nil {
+		return hex.EncodeToString(hash)
+	}
+	mac := hmac.New(sha256.New, sc.nameKey)
+	mac.Write(hash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sharedContent keeps content for many feeds, next to a reference count per content file.
+// Counts are raised before and lowered after the logs are changed, so a crash can leak content but never lose it.
+type sharedContent struct {
+	mu  sync.Mutex
+	dir string
+
+	// secret encrypts the content files if it's set, nameKey hides their names
+	secret  []byte
+	nameKey []byte
+}
+
+func (sc *sharedContent) path(name string) string {
+	return filepath.Join(sc.dir, name)
+}
+
+// add stores data under name unless it's already there and counts the new reference
+func (sc *sharedContent) add(name string, data []byte) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	n, err := sc.readRefs(name)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		if err := os.MkdirAll(sc.dir, 0700); err != nil {
+			return errors.Wrap(err, "store: failed to create content directory")
+		}
+		sealed, err := newSealer(sc.secret, contentDir+"/"+name).seal(0, data)
+		if err != nil {
+			return err
+		}
+		if err := writeFileSynced(sc.path(name), sealed); err != nil {
+			return errors.Wrap(err, "store: failed to write content")
+		}
+	}
+	return sc.writeRefs(name, n+1)
+}
+
+// release drops one reference and removes the content with the last one
+func (sc *sharedContent) release(name string) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	n, err := sc.readRefs(name)
+	if err != nil {
+		return err
+	}
+	if n > 1 {
+		return sc.writeRefs(name, n-1)
+	}
+	if err := os.Remove(sc.path(name)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "store: failed to remove content")
+	}
+	if err := os.Remove(sc.path(name) + contentRefSuffix); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "store: failed to remove content references")
+	}
+	return nil
+}
+
+func (sc *sharedContent) get(name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(sc.path(name))
+	if err != nil {
+		return nil, errors.Wrap(err, "store: failed to read shared content")
+	}
+	return newSealer(sc.secret, contentDir+"/"+name).open(0, data)
+}
+
+func (sc *sharedContent) refs(name string) (uint64, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.readRefs(name)
+}
+
+func (sc *sharedContent) readRefs(name string) (uint64, error) {
+	b, err := ioutil.ReadFile(sc.path(name) + contentRefSuffix)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "store: failed to read content references")
+	}
+	if len(b) != 8 {
+		return 0, errors.Errorf("store: corrupted content references of %s", name)
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func (sc *sharedContent) writeRefs(name string, n uint64) error {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], n)
+	if err := writeFileSynced(sc.path(name)+contentRefSuffix, b[:]); err != nil {
+		return errors.Wrap(err, "store: failed to write content references")
+	}
+	return nil
+}
+
+// writeFileSynced replaces the file at path with data through a synced temporary file
+func writeFileSynced(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// shareable returns the name to share the content of tr under, if it should be shared
+func (sc *sharedContent) shareable(tr *gabbygrove.Transfer, minSize int) (string, bool) {
+	if minSize <= 0 || len(tr.Content) == 0 || len(tr.Content) < minSize {
+		return "", false
+	}
+	evt, err := tr.DecodedEvent()
+	if err != nil {
+		return "", false
+	}
+	name, err := sc.contentName(evt.Content.Hash)
+	if err != nil {
+		return "", false
+	}
+	return name, true
+}
+
+// sharedName returns the name of the shared content of a logged transfer, which marks it with empty content
+func (sc *sharedContent) sharedName(tr *gabbygrove.Transfer) (string, bool) {
+	if tr.Content == nil || len(tr.Content) > 0 {
+		return "", false
+	}
+	evt, err := tr.DecodedEvent()
+	if err != nil || evt.Content.Size == 0 {
+		return "", false
+	}
+	name, err := sc.contentName(evt.Content.Hash)
+	if err != nil {
+		return "", false
+	}
+	return name, true
+}
+
+// loadDeleted reads the sequences whose content was deleted
+func (f *feed) loadDeleted() error {
+	data, err := ioutil.ReadAll(f.delFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to read deleted contents")
+	}
+	if rest := len(data) % indexEntrySize; rest != 0 {
+		data = data[:len(data)-rest]
+		if err := f.delFile.Truncate(int64(len(data))); err != nil {
+			return errors.Wrap(err, "failed to cut partial deleted entry")
+		}
+	}
+	f.deleted = make(map[uint64]struct{})
+	for i := 0; i < len(data); i += indexEntrySize {
+		f.deleted[binary.BigEndian.Uint64(data[i:])] = struct{}{}
+	}
+	return nil
+}
+
+func (f *feed) deleteContent(seq uint64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, has := f.deleted[seq]; has {
+		return nil
+	}
+	if (f.state.Terminated && seq == f.state.Sequence) || (f.state.Revoked != nil && seq == f.state.Revoked.Sequence) {
+		// the tombstone or revocation is needed to know how the feed ended
+		return nil
+	}
+	logged, err := f.readLogged(seq)
+	if err != nil {
+		return err
+	}
+
+	var entry [indexEntrySize]byte
+	binary.BigEndian.PutUint64(entry[:], seq)
+	if _, err := f.delFile.WriteAt(entry[:], int64(len(f.deleted))*indexEntrySize); err != nil {
+		return errors.Wrap(err, "store: failed to write deleted content")
+	}
+	if err := f.delFile.Sync(); err != nil {
+		return errors.Wrap(err, "store: failed to sync deleted contents")
+	}
+	f.deleted[seq] = struct{}{}
+
+	if name, shared := f.content.sharedName(logged); shared {
+		return f.content.release(name)
+	}
+	return nil
+}
+
+// resolve returns the logged transfer as it was appended, or without content if that was deleted
+func (f *feed) resolve(seq uint64, logged *gabbygrove.Transfer) (*gabbygrove.Transfer, error) {
+	if _, has := f.deleted[seq]; has {
+		logged.Content = nil
+		return logged, nil
+	}
+	name, shared := f.content.sharedName(logged)
+	if !shared {
+		return logged, nil
+	}
+	data, err := f.content.get(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "store: content of %d", seq)
+	}
+	logged.Content = data
+	return logged, nil
+}
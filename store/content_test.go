@@ -0,0 +1,121 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+)
+
+// makeRepost makes a feed of one message with content
+func makeRepost(t *testing.T, seed string, content interface{}) (refs.FeedRef, *gabbygrove.Transfer) {
+	r := require.New(t)
+	pub, priv, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte(seed), 32/len(seed))))
+	r.NoError(err)
+	author, err := refs.NewFeedRefFromBytes(pub, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+	tr, _, err := gabbygrove.NewEncoder(priv).Encode(1, gabbygrove.BinaryRef{}, content)
+	r.NoError(err)
+	return author, tr
+}
+
+func TestStoreSharedContent(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbystore")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	r.NoError(err)
+	s.WithSharedContent(16)
+
+	content := bytes.Repeat([]byte("repost "), 100)
+	alice, aliceTr := makeRepost(t, "dead", content)
+	bob, bobTr := makeRepost(t, "beef", content)
+	carol, carolTr := makeRepost(t, "cafe", []byte("too small"))
+	r.NoError(s.Append(aliceTr))
+	r.NoError(s.Append(bobTr))
+	r.NoError(s.Append(carolTr))
+
+	evt, err := aliceTr.DecodedEvent()
+	r.NoError(err)
+	hash := evt.Content.Hash
+	n, err := s.ContentReferences(hash)
+	r.NoError(err)
+	a.EqualValues(2, n)
+
+	files, err := ioutil.ReadDir(filepath.Join(dir, contentDir))
+	r.NoError(err)
+	a.Len(files, 2, "the content and its references")
+	fi, err := os.Stat(filepath.Join(dir, hex.EncodeToString(alice.PubKey())+logSuffix))
+	r.NoError(err)
+	a.True(fi.Size() < int64(len(content)), "log keeps the content: %d", fi.Size())
+
+	check := func(s *Store, author refs.FeedRef, want *gabbygrove.Transfer, withContent bool) {
+		got, err := s.Get(author, 1)
+		r.NoError(err)
+		a.Equal(want.Key(), got.Key())
+		if withContent {
+			a.Equal(want.Content, got.Content)
+			a.True(got.ContentMatches(got.Content))
+		} else {
+			a.False(got.HasContent())
+		}
+	}
+	check(s, alice, aliceTr, true)
+	check(s, bob, bobTr, true)
+	check(s, carol, carolTr, true)
+
+	// deleting keeps it for the other feed
+	r.NoError(s.DeleteContent(alice, 1))
+	r.NoError(s.DeleteContent(alice, 1), "deleting twice")
+	check(s, alice, aliceTr, false)
+	check(s, bob, bobTr, true)
+	n, err = s.ContentReferences(hash)
+	r.NoError(err)
+	a.EqualValues(1, n)
+
+	// reopened without sharing, reading works the same
+	r.NoError(s.Close())
+	s, err = Open(dir)
+	r.NoError(err)
+	check(s, alice, aliceTr, false)
+	check(s, bob, bobTr, true)
+	tip, err := s.Tip(alice)
+	r.NoError(err)
+	a.EqualValues(1, tip.Sequence)
+
+	r.NoError(s.DeleteContent(bob, 1))
+	check(s, bob, bobTr, false)
+	n, err = s.ContentReferences(hash)
+	r.NoError(err)
+	a.EqualValues(0, n)
+	files, err = ioutil.ReadDir(filepath.Join(dir, contentDir))
+	r.NoError(err)
+	a.Len(files, 0)
+
+	// content in the log can be deleted too
+	r.NoError(s.DeleteContent(carol, 1))
+	check(s, carol, carolTr, false)
+	a.Error(s.DeleteContent(carol, 2))
+	r.NoError(s.Close())
+
+	s, err = Open(dir)
+	r.NoError(err)
+	defer s.Close()
+	check(s, carol, carolTr, false)
+}
@@ -0,0 +1,139 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+)
+
+// ErrInvalidCursor is returned for cursors that don't fit the feed they point into,
+// i.e. because the store was replaced since the cursor was handed out
+var ErrInvalidCursor = errors.New("store: invalid cursor")
+
+// cursorVersion is the first byte of a token, so the format can change later
+const cursorVersion = 1
+
+// Cursor is the position after a message of a feed, so iteration can resume there.
+// It's passed to clients as an opaque token, see String and ParseCursor.
+type Cursor struct {
+	Author refs.FeedRef
+
+	// Sequence is the last message that was passed, zero at the start of the feed
+	Sequence uint64
+
+	// Offset is where the next message starts in the log.
+	// It's checked on resumption, so a cursor can't silently point into a different log.
+	Offset int64
+}
+
+// NewCursor returns a cursor at the start of author's feed
+func NewCursor(author refs.FeedRef) Cursor {
+	return Cursor{Author: author}
+}
+
+// String encodes the cursor as a URL safe token
+func (c Cursor) String() string {
+	b := make([]byte, 0, 1+ed25519.PublicKeySize+2*binary.MaxVarintLen64)
+	b = append(b, cursorVersion)
+	b = append(b, c.Author.PubKey()...)
+	var buf [binary.MaxVarintLen64]byte
+	b = append(b, buf[:binary.PutUvarint(buf[:], c.Sequence)]...)
+	b = append(b, buf[:binary.PutUvarint(buf[:], uint64(c.Offset))]...)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// MarshalText returns the token of the cursor
+func (c Cursor) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText parses a token, see ParseCursor
+func (c *Cursor) UnmarshalText(text []byte) error {
+	parsed, err := ParseCursor(string(text))
+	if err != nil {
+		return err
+	}
+	*c = parsed
+	return nil
+}
+
+// ParseCursor decodes a token from Cursor.String
+func ParseCursor(token string) (Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return Cursor{}, errors.Wrap(ErrInvalidCursor, "not base64")
+	}
+	if len(b) < 1+ed25519.PublicKeySize || b[0] != cursorVersion {
+		return Cursor{}, errors.Wrap(ErrInvalidCursor, "unsupported token")
+	}
+	var c Cursor
+	c.Author, err = refs.NewFeedRefFromBytes(b[1:1+ed25519.PublicKeySize], refs.RefAlgoFeedGabby)
+	if err != nil {
+		return Cursor{}, errors.Wrap(ErrInvalidCursor, err.Error())
+	}
+	rest := b[1+ed25519.PublicKeySize:]
+
+	seq, n := binary.Uvarint(rest)
+	if n <= 0 {
+		return Cursor{}, errors.Wrap(ErrInvalidCursor, "broken sequence")
+	}
+	rest = rest[n:]
+	off, n := binary.Uvarint(rest)
+	if n <= 0 || off > 1<<62 {
+		return Cursor{}, errors.Wrap(ErrInvalidCursor, "broken offset")
+	}
+	if len(rest) != n {
+		return Cursor{}, errors.Wrap(ErrInvalidCursor, "trailing bytes")
+	}
+	c.Sequence = seq
+	c.Offset = int64(off)
+	return c, nil
+}
+
+// Page passes up to limit messages after c to fn, in order, and returns the cursor after the last one fn accepted.
+// A limit of zero means up to the tip. At the tip, the returned cursor is c itself and can be used to poll for new messages.
+// If fn returns an error, Page stops and returns it together with the cursor before that message.
+func (s *Store) Page(c Cursor, limit int, fn func(*gabbygrove.Transfer) error) (Cursor, error) {
+	f, err := s.feed(c.Author, false)
+	if errors.Cause(err) == ErrNotFound && c.Sequence == 0 && c.Offset == 0 {
+		// nothing to page through yet
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+
+	f.mu.Lock()
+	tip := uint64(len(f.ends))
+	valid := c.Sequence <= tip && c.Offset == f.start(c.Sequence+1)
+	f.mu.Unlock()
+	if !valid {
+		return c, errors.Wrapf(ErrInvalidCursor, "sequence %d at offset %d", c.Sequence, c.Offset)
+	}
+
+	to := tip
+	if limit > 0 && c.Sequence+uint64(limit) < to {
+		to = c.Sequence + uint64(limit)
+	}
+	for seq := c.Sequence + 1; seq <= to; seq++ {
+		tr, err := f.get(seq)
+		if err != nil {
+			return c, err
+		}
+		if err := fn(tr); err != nil {
+			return c, err
+		}
+		f.mu.Lock()
+		c.Sequence, c.Offset = seq, f.ends[seq-1]
+		f.mu.Unlock()
+	}
+	return c, nil
+}
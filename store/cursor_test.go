@@ -0,0 +1,106 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+)
+
+func TestCursor(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbystore")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	r.NoError(err)
+
+	alice, trs := makeFeed(t, "dead", 5)
+	for _, tr := range trs[:4] {
+		r.NoError(s.Append(tr))
+	}
+
+	var seqs []int64
+	collect := func(tr *gabbygrove.Transfer) error {
+		seqs = append(seqs, tr.Seq())
+		return nil
+	}
+
+	c, err := s.Page(NewCursor(alice), 3, collect)
+	r.NoError(err)
+	a.Equal([]int64{1, 2, 3}, seqs)
+	a.EqualValues(3, c.Sequence)
+
+	// the token survives a restart of the store
+	token := c.String()
+	r.NoError(s.Close())
+	s, err = Open(dir)
+	r.NoError(err)
+	defer s.Close()
+
+	resumed, err := ParseCursor(token)
+	r.NoError(err)
+	a.Equal(c, resumed)
+
+	seqs = nil
+	c, err = s.Page(resumed, 3, collect)
+	r.NoError(err)
+	a.Equal([]int64{4}, seqs)
+
+	// at the tip until there is more
+	seqs = nil
+	same, err := s.Page(c, 0, collect)
+	r.NoError(err)
+	a.Equal(c, same)
+	a.Len(seqs, 0)
+	r.NoError(s.Append(trs[4]))
+	c, err = s.Page(c, 0, collect)
+	r.NoError(err)
+	a.Equal([]int64{5}, seqs)
+
+	// errors of fn keep the cursor before the message
+	failing := errors.New("nope")
+	before := NewCursor(alice)
+	got, err := s.Page(before, 0, func(tr *gabbygrove.Transfer) error {
+		if tr.Seq() == 2 {
+			return failing
+		}
+		return nil
+	})
+	a.Equal(failing, err)
+	a.EqualValues(1, got.Sequence)
+
+	// cursors that don't fit the log
+	wrong := c
+	wrong.Offset--
+	_, err = s.Page(wrong, 0, collect)
+	a.Equal(ErrInvalidCursor, errors.Cause(err))
+	wrong = c
+	wrong.Sequence++
+	_, err = s.Page(wrong, 0, collect)
+	a.Equal(ErrInvalidCursor, errors.Cause(err))
+
+	// as text, i.e. in JSON responses
+	b, err := json.Marshal(struct{ Next Cursor }{c})
+	r.NoError(err)
+	var decoded struct{ Next Cursor }
+	r.NoError(json.Unmarshal(b, &decoded))
+	a.Equal(c, decoded.Next)
+
+	for _, broken := range []string{"", "!!", token[:10], token + "AA"} {
+		_, err := ParseCursor(broken)
+		a.Equal(ErrInvalidCursor, errors.Cause(err), "token %q", broken)
+	}
+}
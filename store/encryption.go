@@ -0,0 +1,160 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+var (
+	// ErrEncrypted is returned if an encrypted store is used without its secret, or a plaintext store with one
+	ErrEncrypted = errors.New("store: encryption does not match the store")
+
+	// ErrWrongSecret is returned by WithEncryption if the store was encrypted with another secret
+	ErrWrongSecret = errors.New("store: wrong encryption secret")
+)
+
+// encryptionFile marks an encrypted store and holds a value to check the secret against
+const encryptionFile = "encryption"
+
+const encryptionVersion1 byte = 1
+
+// WithEncryption encrypts the transfers and content the store writes with XChaCha20-Poly1305,
+// under a key per file that is derived from secret. It needs to be set before the first feed is accessed
+// and an encrypted store can only be used with the same secret from then on.
+//
+// Every message in a log is sealed on its own, bound to its sequence, and shared content as a whole.
+// What isn't hidden are the authors of the feeds (the names of the files), how many messages they have and how large they are.
+// Shared content files are named after a keyed hash of the content, so they don't tell which known content is stored.
+// Encrypted data doesn't compress, so it can't be combined with WithCompression.
+func (s *Store) WithEncryption(secret []byte) error {
+	if len(secret) < 32 {
+		return errors.Errorf("store: encryption secret too short: %d", len(secret))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.feeds) > 0 {
+		return errors.Errorf("store: encryption needs to be set before feeds are opened")
+	}
+
+	secret = append([]byte{}, secret...)
+	check := deriveKey(secret, "check")
+	path := filepath.Join(s.dir, encryptionFile)
+	stored, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		feeds, err := s.Feeds()
+		if err != nil {
+			return err
+		}
+		if len(feeds) > 0 {
+			return errors.Wrap(ErrEncrypted, "store has plaintext feeds")
+		}
+		if err := writeFileSynced(path, append([]byte{encryptionVersion1}, check...)); err != nil {
+			return errors.Wrap(err, "store: failed to write encryption check")
+		}
+	case err != nil:
+		return errors.Wrap(err, "store: failed to read encryption check")
+	case len(stored) != 1+len(check) || stored[0] != encryptionVersion1:
+		return errors.Errorf("store: invalid encryption check")
+	case subtle.ConstantTimeCompare(stored[1:], check) != 1:
+		return ErrWrongSecret
+	}
+	s.secret = secret
+	s.content.secret = secret
+	s.content.nameKey = deriveKey(secret, "content names")
+	return nil
+}
+
+// checkEncryption fails if the store is encrypted but no secret is set, s.mu needs to be held
+func (s *Store) checkEncryption() error {
+	if s.secret != nil {
+		if s.segmentSize > 0 {
+			return errors.Errorf("store: compression can't be combined with encryption")
+		}
+		return nil
+	}
+	_, err := os.Stat(filepath.Join(s.dir, encryptionFile))
+	if err == nil {
+		return ErrEncrypted
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// deriveKey returns the key for purpose, i.e. a file name
+func deriveKey(secret []byte, purpose string) []byte {
+	key := make([]byte, chacha20poly1305.KeySize)
+	r := hkdf.New(sha256.New, secret, nil, []byte("gabbygrove/store "+purpose))
+	if _, err := io.ReadFull(r, key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// sealer encrypts the records of one file, a nil sealer leaves them as they are
+type sealer struct {
+	aead cipher.AEAD
+}
+
+// newSealer returns the sealer for the file name, nil without a secret
+func newSealer(secret []byte, name string) *sealer {
+	if secret == nil {
+		return nil
+	}
+	aead, err := chacha20poly1305.NewX(deriveKey(secret, name))
+	if err != nil {
+		panic(err)
+	}
+	return &sealer{aead: aead}
+}
+
+// seal encrypts the record at position pos (i.e. the sequence of a message) as nonce and ciphertext
+func (sl *sealer) seal(pos uint64, plain []byte) ([]byte, error) {
+	if sl == nil {
+		return plain, nil
+	}
+	out := make([]byte, chacha20poly1305.NonceSizeX, chacha20poly1305.NonceSizeX+len(plain)+sl.aead.Overhead())
+	if _, err := rand.Read(out); err != nil {
+		return nil, errors.Wrap(err, "store: failed to make nonce")
+	}
+	return sl.aead.Seal(out, out, plain, posData(pos)), nil
+}
+
+// open decrypts a record seal returned for pos
+func (sl *sealer) open(pos uint64, sealed []byte) ([]byte, error) {
+	if sl == nil {
+		return sealed, nil
+	}
+	if len(sealed) < chacha20poly1305.NonceSizeX+sl.aead.Overhead() {
+		return nil, errors.Errorf("store: sealed record too short")
+	}
+	nonce, box := sealed[:chacha20poly1305.NonceSizeX], sealed[chacha20poly1305.NonceSizeX:]
+	plain, err := sl.aead.Open(nil, nonce, box, posData(pos))
+	if err != nil {
+		return nil, errors.Wrap(err, "store: failed to decrypt record")
+	}
+	return plain, nil
+}
+
+func posData(pos uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], pos)
+	return b[:]
+}
@@ -0,0 +1,136 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+func TestStoreEncryption(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbystore")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	secret := bytes.Repeat([]byte{0x42}, 32)
+	s, err := Open(dir)
+	r.NoError(err)
+	a.Error(s.WithEncryption(secret[:16]), "too short")
+	r.NoError(s.WithEncryption(secret))
+	s.WithSharedContent(64)
+
+	alice, aliceTrs := makeFeed(t, "dead", 4)
+	for _, tr := range aliceTrs {
+		r.NoError(s.Append(tr))
+	}
+	content := bytes.Repeat([]byte("repost "), 20)
+	bob, bobTr := makeRepost(t, "beef", content)
+	carol, carolTr := makeRepost(t, "cafe", content)
+	r.NoError(s.Append(bobTr))
+	r.NoError(s.Append(carolTr))
+	entries, err := s.ClaimedBetween([]refs.FeedRef{alice}, time.Time{}, time.Time{})
+	r.NoError(err)
+	a.Len(entries, 4)
+	r.NoError(s.Close())
+
+	// nothing of the messages is in the files
+	base := filepath.Join(dir, hex.EncodeToString(alice.PubKey()))
+	logData, err := ioutil.ReadFile(base + logSuffix)
+	r.NoError(err)
+	a.False(bytes.Contains(logData, aliceTrs[0].Event), "event in the log")
+	_, err = os.Stat(base + timesSuffix)
+	a.True(os.IsNotExist(err), "time index on disk")
+	blobs, err := ioutil.ReadDir(filepath.Join(dir, contentDir))
+	r.NoError(err)
+	r.NotEmpty(blobs)
+	sum := sha256.Sum256(content)
+	for _, fi := range blobs {
+		a.False(strings.HasPrefix(fi.Name(), hex.EncodeToString(sum[:])), "hash of the content in the name")
+		data, err := ioutil.ReadFile(filepath.Join(dir, contentDir, fi.Name()))
+		r.NoError(err)
+		a.False(bytes.Contains(data, []byte("repost")), "content in %s", fi.Name())
+	}
+
+	// a torn write is still cut off
+	appendFile(t, base+logSuffix, []byte{0x01, 0x02, 0x03})
+
+	s, err = Open(dir)
+	r.NoError(err)
+	r.NoError(s.WithEncryption(secret))
+	tip, err := s.Tip(alice)
+	r.NoError(err)
+	a.EqualValues(4, tip.Sequence)
+	for i, want := range aliceTrs {
+		got, err := s.Get(alice, uint64(i+1))
+		r.NoError(err)
+		a.Equal(want.Key(), got.Key())
+		a.Equal(want.Content, got.Content)
+	}
+	got, err := s.Get(bob, 1)
+	r.NoError(err)
+	a.Equal(bobTr.Content, got.Content)
+
+	problems, err := s.Fsck()
+	r.NoError(err)
+	a.Empty(problems)
+	r.NoError(s.DeleteContent(alice, 2))
+	r.NoError(s.Compact())
+	got, err = s.Get(alice, 3)
+	r.NoError(err)
+	a.Equal(aliceTrs[2].Content, got.Content)
+	got, err = s.Get(carol, 1)
+	r.NoError(err)
+	a.Equal(content, got.Content)
+	r.NoError(s.Close())
+
+	// without the secret or with another one the store can't be used
+	s, err = Open(dir)
+	r.NoError(err)
+	_, err = s.Tip(alice)
+	a.Equal(ErrEncrypted, errors.Cause(err))
+	a.Equal(ErrWrongSecret, errors.Cause(s.WithEncryption(bytes.Repeat([]byte{0x23}, 32))))
+	s.WithCompression(2)
+	r.NoError(s.WithEncryption(secret))
+	_, err = s.Tip(alice)
+	a.Error(err, "compression with encryption")
+	r.NoError(s.Close())
+}
+
+func TestStoreEncryptionPlaintext(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbystore")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	r.NoError(err)
+	_, trs := makeFeed(t, "dead", 1)
+	r.NoError(s.Append(trs[0]))
+	a.Error(s.WithEncryption(bytes.Repeat([]byte{0x42}, 32)), "feeds are open")
+	r.NoError(s.Close())
+
+	s, err = Open(dir)
+	r.NoError(err)
+	defer s.Close()
+	err = s.WithEncryption(bytes.Repeat([]byte{0x42}, 32))
+	a.Equal(ErrEncrypted, errors.Cause(err))
+}
@@ -0,0 +1,210 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// Feeds returns the authors of the feeds in the store, sorted by public key
+func (s *Store) Feeds() ([]refs.FeedRef, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "store: failed to list feeds")
+	}
+	var feeds []refs.FeedRef
+	for _, fi := range infos {
+		name := fi.Name()
+		if fi.IsDir() || !strings.HasSuffix(name, indexSuffix) {
+			continue
+		}
+		pub, err := hex.DecodeString(strings.TrimSuffix(name, indexSuffix))
+		if err != nil || len(pub) != 32 {
+			continue
+		}
+		author, err := refs.NewFeedRefFromBytes(pub, refs.RefAlgoFeedGabby)
+		if err != nil {
+			continue
+		}
+		feeds = append(feeds, author)
+	}
+	return feeds, nil
+}
+
+// Problem is an inconsistency Fsck found
+type Problem struct {
+	// Author and Sequence locate the problem, both are empty for shared content and Sequence is zero for a whole feed
+	Author   refs.FeedRef
+	Sequence uint64
+
+	Err error
+}
+
+func (p Problem) Error() string {
+	switch {
+	case p.Author == (refs.FeedRef{}):
+		return p.Err.Error()
+	case p.Sequence == 0:
+		return fmt.Sprintf("%s: %s", p.Author.ShortSigil(), p.Err)
+	default:
+		return fmt.Sprintf("%s:%d: %s", p.Author.ShortSigil(), p.Sequence, p.Err)
+	}
+}
+
+// Fsck verifies every stored message against the chain of its feed and the hash of its content,
+// checks the index (if there is one) and the reference counts of shared content.
+// It returns the problems it found and only fails if it couldn't check the store.
+// The problems of a feed end with the first message that doesn't continue its chain.
+func (s *Store) Fsck() ([]Problem, error) {
+	feeds, err := s.Feeds()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	idx := s.idx
+	s.mu.Unlock()
+
+	var problems []Problem
+	sharedRefs := make(map[string]uint64)
+	for _, author := range feeds {
+		f, err := s.feed(author, false)
+		if err != nil {
+			problems = append(problems, Problem{Author: author, Err: err})
+			continue
+		}
+		problems = append(problems, f.fsck(author, idx, sharedRefs)...)
+	}
+
+	contentProblems, err := s.content.fsck(sharedRefs)
+	if err != nil {
+		return nil, err
+	}
+	return append(problems, contentProblems...), nil
+}
+
+// fsck checks the messages of the feed and counts what they reference of the shared content
+func (f *feed) fsck(author refs.FeedRef, idx Index, sharedRefs map[string]uint64) []Problem {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var problems []Problem
+	report := func(seq uint64, err error) {
+		problems = append(problems, Problem{Author: author, Sequence: seq, Err: err})
+	}
+
+	state := gabbygrove.NewFeedState(author)
+	n := uint64(len(f.ends))
+	for seq := uint64(1); seq <= n; seq++ {
+		logged, err := f.readLogged(seq)
+		if err != nil {
+			report(seq, err)
+			return problems
+		}
+		_, deleted := f.deleted[seq]
+		if name, shared := f.content.sharedName(logged); shared && !deleted {
+			sharedRefs[name]++
+		}
+		tr, err := f.resolve(seq, logged)
+		if err != nil {
+			report(seq, err)
+			tr = logged
+			tr.Content = nil
+		}
+		if err := state.Append(tr); err != nil {
+			report(seq, errors.Wrap(err, "broken chain"))
+			return problems
+		}
+		if tr.Content != nil && !tr.ContentMatches(tr.Content) {
+			report(seq, gabbygrove.ErrContentHash)
+		}
+
+		if idx == nil {
+			continue
+		}
+		key := tr.Key()
+		if got, gotSeq, err := idx.Lookup(key); err != nil {
+			report(seq, errors.Wrap(err, "index"))
+		} else if !got.Equal(author) || gotSeq != seq {
+			report(seq, errors.Errorf("index has the key at %s:%d", got.ShortSigil(), gotSeq))
+		}
+		if offset, err := idx.Offset(author, seq); err != nil {
+			report(seq, errors.Wrap(err, "index"))
+		} else if offset != f.start(seq) {
+			report(seq, errors.Errorf("index has offset %d instead of %d", offset, f.start(seq)))
+		}
+	}
+	if idx != nil {
+		if have, err := idx.Sequence(author); err != nil {
+			report(0, errors.Wrap(err, "index"))
+		} else if have != n {
+			report(0, errors.Errorf("index has %d messages instead of %d", have, n))
+		}
+	}
+	return problems
+}
+
+// fsck compares the shared content with the references the feeds counted
+func (sc *sharedContent) fsck(counted map[string]uint64) ([]Problem, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	infos, err := ioutil.ReadDir(sc.dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "store: failed to list shared content")
+	}
+	var problems []Problem
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, Problem{Err: errors.Errorf("content "+format, args...)})
+	}
+
+	stored := make(map[string]bool)
+	for _, fi := range infos {
+		name := fi.Name()
+		if b, err := hex.DecodeString(name); err != nil || len(b) != sha256.Size {
+			continue
+		}
+		stored[name] = true
+		data, err := sc.get(name)
+		if err != nil {
+			return nil, err
+		}
+		if sum := sha256.Sum256(data); sc.name(sum[:]) != name {
+			report("%s: does not match its hash", name)
+		}
+		n, err := sc.readRefs(name)
+		if err != nil {
+			report("%s: %s", name, err)
+			continue
+		}
+		switch want := counted[name]; {
+		case want == 0:
+			report("%s: not referenced by any message (counted %d)", name, n)
+		case n < want:
+			report("%s: counted %d references for %d messages", name, n, want)
+		}
+	}
+
+	var missing []string
+	for name := range counted {
+		if !stored[name] {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		report("%s: missing", name)
+	}
+	return problems, nil
+}
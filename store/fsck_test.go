@@ -0,0 +1,136 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+func TestStoreFsck(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbystore")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	r.NoError(err)
+	s.WithIndex(NewMemIndex())
+	s.WithCompression(2)
+	s.WithSharedContent(64)
+
+	alice, aliceTrs := makeFeed(t, "dead", 5)
+	bob, bobTrs := makeFeed(t, "beef", 1)
+	carol, repost := makeRepost(t, "cafe", bytes.Repeat([]byte("repost "), 20))
+	for _, tr := range append(aliceTrs, bobTrs[0], repost) {
+		r.NoError(s.Append(tr))
+	}
+	r.NoError(s.DeleteContent(alice, 1))
+
+	feeds, err := s.Feeds()
+	r.NoError(err)
+	a.Len(feeds, 3)
+	for _, f := range []refs.FeedRef{alice, bob, carol} {
+		var found bool
+		for _, got := range feeds {
+			found = found || got.Equal(f)
+		}
+		a.True(found, f.ShortSigil())
+	}
+
+	problems, err := s.Fsck()
+	r.NoError(err)
+	a.Len(problems, 0, "%v", problems)
+	r.NoError(s.Close())
+
+	// content in the tail of a log that doesn't match its hash
+	logPath := filepath.Join(dir, hex.EncodeToString(alice.PubKey())+logSuffix)
+	logData, err := ioutil.ReadFile(logPath)
+	r.NoError(err)
+	i := bytes.Index(logData, []byte(`"type":"test"`))
+	r.True(i > 0)
+	logData[i+9] = 'b'
+	r.NoError(ioutil.WriteFile(logPath, logData, 0600))
+
+	// shared content with too few references and some nobody references
+	evt, err := repost.DecodedEvent()
+	r.NoError(err)
+	name, err := s.content.contentName(evt.Content.Hash)
+	r.NoError(err)
+	r.NoError(ioutil.WriteFile(filepath.Join(dir, contentDir, name+contentRefSuffix), make([]byte, 8), 0600))
+	orphan := []byte("nobody posted this")
+	sum := sha256.Sum256(orphan)
+	r.NoError(ioutil.WriteFile(filepath.Join(dir, contentDir, hex.EncodeToString(sum[:])), orphan, 0600))
+	var one [8]byte
+	binary.BigEndian.PutUint64(one[:], 1)
+	r.NoError(ioutil.WriteFile(filepath.Join(dir, contentDir, hex.EncodeToString(sum[:])+contentRefSuffix), one[:], 0600))
+
+	s, err = Open(dir)
+	r.NoError(err)
+	defer s.Close()
+	s.WithIndex(NewMemIndex())
+
+	problems, err = s.Fsck()
+	r.NoError(err)
+	r.Len(problems, 3, "%v", problems)
+	a.True(problems[0].Author.Equal(alice))
+	a.EqualValues(5, problems[0].Sequence)
+	a.Contains(problems[0].Error(), "content does not match")
+	var shared []string
+	for _, p := range problems[1:] {
+		a.Equal(uint64(0), p.Sequence)
+		shared = append(shared, p.Error())
+	}
+	a.True(strings.Contains(strings.Join(shared, "\n"), "counted 0 references for 1 messages"), "%v", shared)
+	a.True(strings.Contains(strings.Join(shared, "\n"), "not referenced"), "%v", shared)
+}
+
+func TestStoreFsckBrokenChain(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbystore")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	r.NoError(err)
+	alice, trs := makeFeed(t, "dead", 3)
+	for _, tr := range trs {
+		r.NoError(s.Append(tr))
+	}
+	r.NoError(s.Close())
+
+	// a flipped bit in the signature of the second message
+	logPath := filepath.Join(dir, hex.EncodeToString(alice.PubKey())+logSuffix)
+	logData, err := ioutil.ReadFile(logPath)
+	r.NoError(err)
+	i := bytes.Index(logData, trs[1].Signature)
+	r.True(i > 0)
+	logData[i] ^= 1
+	r.NoError(ioutil.WriteFile(logPath, logData, 0600))
+
+	s, err = Open(dir)
+	r.NoError(err)
+	defer s.Close()
+	problems, err := s.Fsck()
+	r.NoError(err)
+	r.Len(problems, 1, "%v", problems)
+	a.EqualValues(2, problems[0].Sequence)
+	a.Contains(problems[0].Error(), "broken chain")
+}
@@ -0,0 +1,139 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// Index is kept up to date by the store next to the logs, so messages can be looked up by key.
+// See the boltindex package for a persistent implementation.
+type Index interface {
+	// Put records that key is the message of author at seq, which starts at offset in its log
+	Put(author refs.FeedRef, seq uint64, key refs.MessageRef, offset int64) error
+
+	// Offset returns where the message of author at seq starts in its log
+	Offset(author refs.FeedRef, seq uint64) (int64, error)
+
+	// Lookup returns the author and sequence of the message with key
+	Lookup(key refs.MessageRef) (refs.FeedRef, uint64, error)
+
+	// Sequence returns the highest sequence of author the index has, zero if it has none
+	Sequence(author refs.FeedRef) (uint64, error)
+}
+
+// WithIndex makes the store maintain idx on every Append.
+// It needs to be set before the first feed is accessed.
+// Feeds the index is behind on (i.e. after a failed update) are caught up when they are opened.
+func (s *Store) WithIndex(idx Index) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.idx = idx
+}
+
+// GetByKey returns the message with key, using the index
+func (s *Store) GetByKey(key refs.MessageRef) (*gabbygrove.Transfer, error) {
+	s.mu.Lock()
+	idx := s.idx
+	s.mu.Unlock()
+	if idx == nil {
+		return nil, errors.Errorf("store: no index to look up keys")
+	}
+	author, seq, err := idx.Lookup(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(author, seq)
+}
+
+// catchUp puts the messages the index doesn't have yet
+func (f *feed) catchUp(idx Index, author refs.FeedRef) error {
+	have, err := idx.Sequence(author)
+	if err != nil {
+		return errors.Wrap(err, "failed to get indexed sequence")
+	}
+	for seq := have + 1; seq <= uint64(len(f.ends)); seq++ {
+		tr, err := f.readLogged(seq)
+		if err != nil {
+			return err
+		}
+		if err := idx.Put(author, seq, tr.Key(), f.start(seq)); err != nil {
+			return errors.Wrapf(err, "failed to index %d", seq)
+		}
+	}
+	return nil
+}
+
+// NewMemIndex keeps the index in memory, mostly useful for testing
+func NewMemIndex() Index {
+	return &memIndex{
+		offsets: make(map[string][]int64),
+		keys:    make(map[string]memIndexEntry),
+	}
+}
+
+type memIndex struct {
+	mu      sync.Mutex
+	offsets map[string][]int64
+	keys    map[string]memIndexEntry
+}
+
+type memIndexEntry struct {
+	author refs.FeedRef
+	seq    uint64
+}
+
+func (mi *memIndex) Put(author refs.FeedRef, seq uint64, key refs.MessageRef, offset int64) error {
+	mi.mu.Lock()
+	defer mi.mu.Unlock()
+	offsets := mi.offsets[author.String()]
+	if want := uint64(len(offsets)) + 1; seq != want {
+		return errors.Errorf("store/memindex: expected sequence %d got %d", want, seq)
+	}
+	mi.offsets[author.String()] = append(offsets, offset)
+	mi.keys[key.String()] = memIndexEntry{author: author, seq: seq}
+	return nil
+}
+
+func (mi *memIndex) Offset(author refs.FeedRef, seq uint64) (int64, error) {
+	mi.mu.Lock()
+	defer mi.mu.Unlock()
+	offsets := mi.offsets[author.String()]
+	if seq < 1 || seq > uint64(len(offsets)) {
+		return 0, errors.Wrapf(ErrNotFound, "sequence %d", seq)
+	}
+	return offsets[seq-1], nil
+}
+
+func (mi *memIndex) UpdateOffset(author refs.FeedRef, seq uint64, offset int64) error {
+	mi.mu.Lock()
+	defer mi.mu.Unlock()
+	offsets := mi.offsets[author.String()]
+	if seq < 1 || seq > uint64(len(offsets)) {
+		return errors.Wrapf(ErrNotFound, "sequence %d", seq)
+	}
+	offsets[seq-1] = offset
+	return nil
+}
+
+func (mi *memIndex) Lookup(key refs.MessageRef) (refs.FeedRef, uint64, error) {
+	mi.mu.Lock()
+	defer mi.mu.Unlock()
+	e, has := mi.keys[key.String()]
+	if !has {
+		return refs.FeedRef{}, 0, errors.Wrapf(ErrNotFound, "key %s", key.ShortSigil())
+	}
+	return e.author, e.seq, nil
+}
+
+func (mi *memIndex) Sequence(author refs.FeedRef) (uint64, error) {
+	mi.mu.Lock()
+	defer mi.mu.Unlock()
+	return uint64(len(mi.offsets[author.String()])), nil
+}
@@ -0,0 +1,324 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+)
+
+const (
+	segmentsSuffix    = ".zst"
+	segmentIdxSuffix  = ".seg"
+	segmentHeaderSize = 8
+)
+
+// WithCompression makes the store compress the logs of feeds in segments of segmentSize messages with zstd, zero turns it off.
+// It needs to be set before the first feed is accessed.
+//
+// Once the uncompressed tail of a log holds segmentSize messages, they are compressed into one frame of the segments file
+// and the log starts over. The segment index keeps the end of every frame, so a message is found by decompressing one segment.
+// A feed that was compressed once keeps being compressed with the segment size it started with, even if it's turned off again.
+func (s *Store) WithCompression(segmentSize int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if segmentSize < 0 {
+		segmentSize = 0
+	}
+	s.segmentSize = segmentSize
+}
+
+var (
+	zstdOnce sync.Once
+	zstdEnc  *zstd.Encoder
+	zstdDec  *zstd.Decoder
+	zstdErr  error
+)
+
+// zstdCoders returns the encoder and decoder shared by all stores, they are safe for concurrent EncodeAll and DecodeAll
+func zstdCoders() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		zstdEnc, zstdErr = zstd.NewWriter(nil)
+		if zstdErr != nil {
+			return
+		}
+		zstdDec, zstdErr = zstd.NewReader(nil)
+	})
+	return zstdEnc, zstdDec, zstdErr
+}
+
+// segments are the compressed part of the log of a feed
+type segments struct {
+	data, idx *os.File
+
+	// size is the number of messages per segment, ends the end offset of every frame in data
+	size uint64
+	ends []int64
+
+	// the last decompressed segment, sequential reads mostly hit the same one
+	cached     int
+	cachedData []byte
+}
+
+// openSegments opens the segments of the feed at base if there are any or segmentSize turns them on
+func openSegments(base string, segmentSize int) (*segments, error) {
+	flags := os.O_RDWR
+	if segmentSize > 0 {
+		flags |= os.O_CREATE
+	}
+	idx, err := os.OpenFile(base+segmentIdxSuffix, flags, 0600)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.OpenFile(base+segmentsSuffix, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		idx.Close()
+		return nil, err
+	}
+	sg := &segments{data: data, idx: idx, size: uint64(segmentSize), cached: -1}
+	if err := sg.load(); err != nil {
+		sg.close()
+		return nil, err
+	}
+	if sg.size == 0 {
+		sg.close()
+		return nil, nil
+	}
+	return sg, nil
+}
+
+// load reads the segment index and cuts off frames that were written after it
+func (sg *segments) load() error {
+	idxData, err := ioutil.ReadAll(sg.idx)
+	if err != nil {
+		return errors.Wrap(err, "failed to read segment index")
+	}
+	if len(idxData) < segmentHeaderSize {
+		if sg.size == 0 {
+			return nil
+		}
+		var hdr [segmentHeaderSize]byte
+		binary.BigEndian.PutUint64(hdr[:], sg.size)
+		if _, err := sg.idx.WriteAt(hdr[:], 0); err != nil {
+			return errors.Wrap(err, "failed to write segment index")
+		}
+		return sg.idx.Sync()
+	}
+	sg.size = binary.BigEndian.Uint64(idxData)
+	if sg.size == 0 {
+		return errors.Errorf("corrupted segment index: segment size zero")
+	}
+
+	entries := idxData[segmentHeaderSize:]
+	if rest := len(entries) % indexEntrySize; rest != 0 {
+		entries = entries[:len(entries)-rest]
+		if err := sg.idx.Truncate(int64(segmentHeaderSize + len(entries))); err != nil {
+			return errors.Wrap(err, "failed to cut partial segment index entry")
+		}
+	}
+	sg.ends = make([]int64, len(entries)/indexEntrySize)
+	var last int64
+	for i := range sg.ends {
+		end := int64(binary.BigEndian.Uint64(entries[i*indexEntrySize:]))
+		if end <= last {
+			return errors.Errorf("corrupted segment index at segment %d", i)
+		}
+		sg.ends[i] = end
+		last = end
+	}
+
+	fi, err := sg.data.Stat()
+	if err != nil {
+		return err
+	}
+	switch {
+	case fi.Size() < last:
+		return errors.Errorf("segments are shorter than their index (%d < %d)", fi.Size(), last)
+	case fi.Size() > last:
+		if err := sg.data.Truncate(last); err != nil {
+			return errors.Wrap(err, "failed to cut unindexed segment")
+		}
+	}
+	return nil
+}
+
+// messages returns how many messages are compressed
+func (sg *segments) messages() uint64 {
+	if sg == nil {
+		return 0
+	}
+	return uint64(len(sg.ends)) * sg.size
+}
+
+// add compresses the messages in raw as the next segment
+func (sg *segments) add(raw []byte) error {
+	enc, _, err := zstdCoders()
+	if err != nil {
+		return errors.Wrap(err, "store: failed to set up compression")
+	}
+	frame := enc.EncodeAll(raw, nil)
+
+	var start int64
+	if n := len(sg.ends); n > 0 {
+		start = sg.ends[n-1]
+	}
+	if _, err := sg.data.WriteAt(frame, start); err != nil {
+		return errors.Wrap(err, "store: failed to write segment")
+	}
+	if err := sg.data.Sync(); err != nil {
+		return errors.Wrap(err, "store: failed to sync segments")
+	}
+
+	end := start + int64(len(frame))
+	var entry [indexEntrySize]byte
+	binary.BigEndian.PutUint64(entry[:], uint64(end))
+	if _, err := sg.idx.WriteAt(entry[:], segmentHeaderSize+int64(len(sg.ends))*indexEntrySize); err != nil {
+		return errors.Wrap(err, "store: failed to write segment index")
+	}
+	if err := sg.idx.Sync(); err != nil {
+		return errors.Wrap(err, "store: failed to sync segment index")
+	}
+	sg.ends = append(sg.ends, end)
+	return nil
+}
+
+// segment returns the decompressed messages of segment i
+func (sg *segments) segment(i int) ([]byte, error) {
+	if i == sg.cached {
+		return sg.cachedData, nil
+	}
+	var start int64
+	if i > 0 {
+		start = sg.ends[i-1]
+	}
+	frame := make([]byte, sg.ends[i]-start)
+	if _, err := sg.data.ReadAt(frame, start); err != nil {
+		return nil, errors.Wrapf(err, "store: failed to read segment %d", i)
+	}
+	_, dec, err := zstdCoders()
+	if err != nil {
+		return nil, errors.Wrap(err, "store: failed to set up compression")
+	}
+	raw, err := dec.DecodeAll(frame, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "store: failed to decompress segment %d", i)
+	}
+	sg.cached, sg.cachedData = i, raw
+	return raw, nil
+}
+
+func (sg *segments) close() error {
+	err := sg.data.Close()
+	if err2 := sg.idx.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// loadSegments opens the segments of the feed and finds where its log starts, f.ends needs to be loaded
+func (f *feed) loadSegments(base string, segmentSize int) error {
+	sg, err := openSegments(base, segmentSize)
+	if err != nil {
+		return err
+	}
+	f.segs = sg
+	sealed := f.segs.messages()
+	if sealed == 0 {
+		return nil
+	}
+	if sealed > uint64(len(f.ends)) {
+		return errors.Errorf("segments hold more messages than the index (%d > %d)", sealed, len(f.ends))
+	}
+	f.base = f.ends[sealed-1]
+
+	// the log is only started over after the segment is written, finish that if it didn't happen
+	prevSealed := sealed - f.segs.size
+	prevBase := f.start(prevSealed + 1)
+	fi, err := f.log.Stat()
+	if err != nil {
+		return err
+	}
+	firstLen := f.ends[prevSealed] - prevBase
+	if fi.Size() < firstLen {
+		return nil
+	}
+	first := make([]byte, firstLen)
+	if _, err := f.log.ReadAt(first, 0); err != nil {
+		return errors.Wrap(err, "failed to read log")
+	}
+	var tr gabbygrove.Transfer
+	if err := tr.UnmarshalReceived(first); err != nil || uint64(tr.Seq()) != prevSealed+1 {
+		return nil
+	}
+	return f.restartLog(f.base - prevBase)
+}
+
+// seal compresses the tail of the log once it holds a whole segment, f.mu needs to be held
+func (f *feed) seal() error {
+	sealed := f.segs.messages()
+	if uint64(len(f.ends))-sealed < f.segs.size {
+		return nil
+	}
+	// segments are only cut from the start of the log that made it to disk
+	if err := f.sync(); err != nil {
+		return err
+	}
+	newBase := f.ends[sealed+f.segs.size-1]
+	raw := make([]byte, newBase-f.base)
+	if _, err := f.log.ReadAt(raw, 0); err != nil {
+		return errors.Wrap(err, "store: failed to read log")
+	}
+	if err := f.segs.add(raw); err != nil {
+		return err
+	}
+	f.base = newBase
+	return f.restartLog(int64(len(raw)))
+}
+
+// restartLog drops the first n bytes of the log
+func (f *feed) restartLog(n int64) error {
+	fi, err := f.log.Stat()
+	if err != nil {
+		return err
+	}
+	rest := make([]byte, fi.Size()-n)
+	if _, err := f.log.ReadAt(rest, n); err != nil {
+		return errors.Wrap(err, "store: failed to read log")
+	}
+	if _, err := f.log.WriteAt(rest, 0); err != nil {
+		return errors.Wrap(err, "store: failed to write log")
+	}
+	if err := f.log.Truncate(int64(len(rest))); err != nil {
+		return errors.Wrap(err, "store: failed to cut log")
+	}
+	if err := f.log.Sync(); err != nil {
+		return errors.Wrap(err, "store: failed to sync log")
+	}
+	return nil
+}
+
+// readSegment returns the raw message at seq from its segment
+func (f *feed) readSegment(seq uint64) ([]byte, error) {
+	i := int((seq - 1) / f.segs.size)
+	raw, err := f.segs.segment(i)
+	if err != nil {
+		return nil, err
+	}
+	segStart := f.start(uint64(i)*f.segs.size + 1)
+	from, to := f.start(seq)-segStart, f.ends[seq-1]-segStart
+	if from < 0 || to > int64(len(raw)) {
+		return nil, errors.Errorf("store: segment %d does not match the index", i)
+	}
+	return raw[from:to:to], nil
+}
@@ -0,0 +1,151 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+)
+
+func TestStoreCompression(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbystore")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	r.NoError(err)
+	s.WithCompression(3)
+	s.WithIndex(NewMemIndex())
+
+	alice, trs := makeFeed(t, "dead", 11)
+	base := filepath.Join(dir, hex.EncodeToString(alice.PubKey()))
+	for _, tr := range trs[:8] {
+		r.NoError(s.Append(tr))
+	}
+
+	checkAll := func(s *Store, n int) {
+		var got []*gabbygrove.Transfer
+		r.NoError(s.Iterate(alice, 1, 0, func(tr *gabbygrove.Transfer) error {
+			got = append(got, tr)
+			return nil
+		}))
+		r.Len(got, n)
+		for i, tr := range got {
+			a.Equal(trs[i].Key(), tr.Key(), "seq %d", i+1)
+			a.Equal(trs[i].Content, tr.Content, "seq %d", i+1)
+		}
+		// out of order too, across segments
+		for _, seq := range []uint64{5, 1, uint64(n), 4, 2} {
+			tr, err := s.Get(alice, seq)
+			r.NoError(err)
+			a.Equal(trs[seq-1].Key(), tr.Key())
+		}
+	}
+	checkAll(s, 8)
+
+	// two segments are sealed, the log only has the rest
+	sg, err := ioutil.ReadFile(base + segmentIdxSuffix)
+	r.NoError(err)
+	a.Len(sg, segmentHeaderSize+2*indexEntrySize)
+	logData, err := ioutil.ReadFile(base + logSuffix)
+	r.NoError(err)
+	s7, err := trs[6].MarshalReceived()
+	r.NoError(err)
+	s8, err := trs[7].MarshalReceived()
+	r.NoError(err)
+	a.Equal(append(s7, s8...), logData)
+
+	got, err := s.GetByKey(trs[1].Key())
+	r.NoError(err)
+	a.EqualValues(2, got.Seq())
+	r.NoError(s.Close())
+
+	// turning it off keeps the feed compressed
+	s, err = Open(dir)
+	r.NoError(err)
+	checkAll(s, 8)
+	tip, err := s.Tip(alice)
+	r.NoError(err)
+	a.EqualValues(8, tip.Sequence)
+	r.NoError(s.Append(trs[8]))
+	r.NoError(s.Append(trs[9]))
+	checkAll(s, 10)
+	sg, err = ioutil.ReadFile(base + segmentIdxSuffix)
+	r.NoError(err)
+	a.Len(sg, segmentHeaderSize+3*indexEntrySize)
+	r.NoError(s.Close())
+
+	// a frame that didn't make it into the segment index is cut off
+	zst, err := os.OpenFile(base+segmentsSuffix, os.O_WRONLY|os.O_APPEND, 0600)
+	r.NoError(err)
+	_, err = zst.Write([]byte("torn frame"))
+	r.NoError(err)
+	r.NoError(zst.Close())
+
+	s, err = Open(dir)
+	r.NoError(err)
+	defer s.Close()
+	checkAll(s, 10)
+	r.NoError(s.Append(trs[10]))
+	checkAll(s, 11)
+}
+
+func TestStoreCompressionRestart(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbystore")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	r.NoError(err)
+	s.WithCompression(3)
+
+	alice, trs := makeFeed(t, "dead", 4)
+	base := filepath.Join(dir, hex.EncodeToString(alice.PubKey()))
+	for _, tr := range trs[:3] {
+		r.NoError(s.Append(tr))
+	}
+	fullLog, err := ioutil.ReadFile(base + logSuffix)
+	r.NoError(err)
+	fullIdx, err := ioutil.ReadFile(base + indexSuffix)
+	r.NoError(err)
+
+	// sealed before the fourth is written
+	r.NoError(s.Append(trs[3]))
+	r.NoError(s.Close())
+
+	// like a crash after the segment was written but before the log started over
+	r.NoError(ioutil.WriteFile(base+logSuffix, fullLog, 0600))
+	r.NoError(ioutil.WriteFile(base+indexSuffix, fullIdx, 0600))
+
+	s, err = Open(dir)
+	r.NoError(err)
+	defer s.Close()
+	tip, err := s.Tip(alice)
+	r.NoError(err)
+	a.EqualValues(3, tip.Sequence)
+	logData, err := ioutil.ReadFile(base + logSuffix)
+	r.NoError(err)
+	a.Len(logData, 0)
+
+	r.NoError(s.Append(trs[3]))
+	for i, want := range trs {
+		got, err := s.Get(alice, uint64(i+1))
+		r.NoError(err)
+		a.Equal(want.Key(), got.Key())
+	}
+}
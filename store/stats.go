@@ -0,0 +1,133 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// FeedStats describes one stored feed
+type FeedStats struct {
+	Author   refs.FeedRef
+	Messages uint64
+
+	// DiskBytes is the size of the files of the feed, LogBytes the one of the uncompressed log
+	DiskBytes, LogBytes int64
+
+	// ContentBytes is the size of the content of the messages, including the shared content they reference.
+	// DeletedContent counts the messages that are stored without their content, i.e. because it was deleted.
+	ContentBytes   int64
+	DeletedContent uint64
+
+	// ContentTypes counts the messages by the content type of their event,
+	// Types by the type field of their content as far as it has one (see gabbygrove.Transfer.MessageType)
+	ContentTypes map[gabbygrove.ContentType]uint64
+	Types        map[string]uint64
+
+	// First and Last are the earliest and latest claimed time of the messages
+	First, Last time.Time
+}
+
+// Stats describes the whole store
+type Stats struct {
+	// Feeds are sorted by public key, like Store.Feeds
+	Feeds []FeedStats
+
+	Messages uint64
+
+	// DiskBytes is the size of the files of all feeds and of the shared content
+	DiskBytes int64
+
+	// SharedBytes is the size of the content directory, which is part of DiskBytes
+	SharedBytes int64
+}
+
+// Stats reads all stored messages and sums them up, i.e. for monitoring how much the store grows.
+// Messages that can't be read are left out, Fsck reports them.
+func (s *Store) Stats() (*Stats, error) {
+	feeds, err := s.Feeds()
+	if err != nil {
+		return nil, err
+	}
+
+	var st Stats
+	for _, author := range feeds {
+		f, err := s.feed(author, false)
+		if err != nil {
+			return nil, err
+		}
+		fs := f.stats(author)
+		st.Feeds = append(st.Feeds, fs)
+		st.Messages += fs.Messages
+		st.DiskBytes += fs.DiskBytes
+	}
+
+	st.SharedBytes = dirSize(s.content.dir)
+	st.DiskBytes += st.SharedBytes
+	return &st, nil
+}
+
+func (f *feed) stats(author refs.FeedRef) FeedStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fs := FeedStats{
+		Author:       author,
+		Messages:     uint64(len(f.ends)),
+		ContentTypes: make(map[gabbygrove.ContentType]uint64),
+		Types:        make(map[string]uint64),
+	}
+	if n := len(f.ends); n > 0 {
+		fs.LogBytes = f.ends[n-1]
+	}
+	for _, suffix := range []string{logSuffix, indexSuffix, deletedSuffix, segmentsSuffix, segmentIdxSuffix, timesSuffix} {
+		if fi, err := os.Stat(f.path + suffix); err == nil {
+			fs.DiskBytes += fi.Size()
+		}
+	}
+	for seq := uint64(1); seq <= fs.Messages; seq++ {
+		tr, err := f.read(seq)
+		if err != nil {
+			continue
+		}
+		evt, err := tr.DecodedEvent()
+		if err != nil {
+			continue
+		}
+		if !tr.HasContent() {
+			fs.DeletedContent++
+		}
+		fs.ContentBytes += int64(len(tr.Content))
+		fs.ContentTypes[evt.Content.Type]++
+		if typ, err := tr.MessageType(); err == nil {
+			fs.Types[typ]++
+		}
+		claimed := time.Unix(evt.Timestamp, 0)
+		if fs.First.IsZero() || claimed.Before(fs.First) {
+			fs.First = claimed
+		}
+		if claimed.After(fs.Last) {
+			fs.Last = claimed
+		}
+	}
+	return fs
+}
+
+// dirSize returns the size of the files in dir, zero if it doesn't exist
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(_ string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size
+}
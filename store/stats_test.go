@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreStats(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbystore")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	r.NoError(err)
+	s.WithSharedContent(64)
+
+	st, err := s.Stats()
+	r.NoError(err)
+	a.Len(st.Feeds, 0)
+	a.EqualValues(0, st.Messages)
+
+	alice, aliceTrs := makeFeed(t, "dead", 4)
+	carol, repost := makeRepost(t, "cafe", bytes.Repeat([]byte("repost "), 20))
+	for _, tr := range append(aliceTrs, repost) {
+		r.NoError(s.Append(tr))
+	}
+	r.NoError(s.DeleteContent(alice, 1))
+
+	st, err = s.Stats()
+	r.NoError(err)
+	r.Len(st.Feeds, 2)
+	a.EqualValues(5, st.Messages)
+	a.True(st.SharedBytes > 140, "shared content: %d", st.SharedBytes)
+
+	var aliceStats, carolStats FeedStats
+	for _, fs := range st.Feeds {
+		switch {
+		case fs.Author.Equal(alice):
+			aliceStats = fs
+		case fs.Author.Equal(carol):
+			carolStats = fs
+		}
+	}
+
+	a.EqualValues(4, aliceStats.Messages)
+	a.EqualValues(1, aliceStats.DeletedContent)
+	a.EqualValues(3, aliceStats.Types["test"])
+	var want int64
+	for _, tr := range aliceTrs[1:] {
+		want += int64(len(tr.Content))
+	}
+	a.Equal(want, aliceStats.ContentBytes)
+	a.True(aliceStats.LogBytes > 0)
+	a.True(aliceStats.DiskBytes >= aliceStats.LogBytes)
+	a.True(aliceStats.First.Equal(aliceTrs[1].Claimed()) || aliceStats.First.Before(aliceTrs[1].Claimed()))
+	a.False(aliceStats.Last.Before(aliceStats.First))
+
+	a.EqualValues(1, carolStats.Messages)
+	a.EqualValues(len(repost.Content), carolStats.ContentBytes, "counts the shared content")
+	a.True(st.DiskBytes >= aliceStats.DiskBytes+carolStats.DiskBytes+st.SharedBytes)
+
+	var total uint64
+	for _, n := range aliceStats.ContentTypes {
+		total += n
+	}
+	a.EqualValues(4, total, "the event keeps the content type of deleted content")
+
+	r.NoError(s.Close())
+}
@@ -0,0 +1,544 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+// Package store is a reference implementation of a durable store for gabbygrove feeds.
+//
+// Each feed is kept in files named after the public key of its author:
+// an append-only log of the encoded transfers and an index with the end offset of every transfer,
+// one big-endian uint64 per sequence. The log is written and synced before the index,
+// so after a crash the log can be cut back to the last indexed message.
+// With a relaxed SyncPolicy the index can get ahead of the log instead, messages it points to that
+// weren't completely written are cut off as well.
+// Transfers with a received time are logged with it, see Transfer.MarshalReceived.
+// A third file lists the sequences whose content was deleted, in the same format.
+// Messages can also be queried by their claimed time, see Store.ClaimedBetween.
+// With compression, the start of the log is moved into zstd compressed segments, see Store.WithCompression.
+//
+// Content can also be kept once for all feeds in the content directory, see Store.WithSharedContent.
+// The logs and the content can be encrypted at rest, see Store.WithEncryption.
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// ErrNotFound is returned for sequences the store doesn't have
+var ErrNotFound = errors.New("store: message not found")
+
+const (
+	logSuffix   = ".log"
+	indexSuffix = ".idx"
+
+	indexEntrySize = 8
+)
+
+// Store keeps many feeds in one directory
+type Store struct {
+	dir string
+
+	mu    sync.Mutex
+	feeds map[string]*feed
+	idx   Index
+
+	content  *sharedContent
+	shareMin int
+
+	segmentSize int
+
+	// secret encrypts the files of the store if it's set, see WithEncryption
+	secret []byte
+
+	syncPolicy SyncPolicy
+	stopSync   chan struct{}
+	syncDone   chan struct{}
+
+	subs gabbygrove.Broadcaster
+}
+
+// Open opens (and creates) the store in dir
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "store: failed to create directory")
+	}
+	return &Store{
+		dir:     dir,
+		feeds:   make(map[string]*feed),
+		content: &sharedContent{dir: filepath.Join(dir, contentDir)},
+	}, nil
+}
+
+// Close syncs and closes the files of all opened feeds
+func (s *Store) Close() error {
+	s.stopPeriodicSync()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var firstErr error
+	for name, f := range s.feeds {
+		if err := f.sync(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := f.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(s.feeds, name)
+	}
+	return firstErr
+}
+
+// Append validates tr as the next message of its author's feed and stores it
+func (s *Store) Append(tr *gabbygrove.Transfer) error {
+	author, err := authorOf(tr)
+	if err != nil {
+		return err
+	}
+	f, err := s.feed(author, true)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	shareMin, policy := s.shareMin, s.syncPolicy
+	s.mu.Unlock()
+	return f.append(tr, shareMin, policy == SyncEach)
+}
+
+// authorOf decodes the author of tr, which isn't validated yet
+func authorOf(tr *gabbygrove.Transfer) (refs.FeedRef, error) {
+	evt, err := tr.UnmarshaledEvent()
+	if err != nil {
+		return refs.FeedRef{}, errors.Wrap(err, "store: invalid event")
+	}
+	aref, err := evt.Author.GetRef(gabbygrove.RefTypeFeed)
+	if err != nil {
+		return refs.FeedRef{}, errors.Wrap(err, "store: invalid author")
+	}
+	return aref.(refs.FeedRef), nil
+}
+
+// Subscribe returns a channel of the messages appended to the feed of author, or to all feeds if it's nil.
+// See gabbygrove.Broadcaster for the semantics of buffer and when the channel is closed.
+func (s *Store) Subscribe(ctx context.Context, author *refs.FeedRef, buffer int) <-chan *gabbygrove.Transfer {
+	return s.subs.Subscribe(ctx, author, buffer)
+}
+
+// Get returns the message of author at seq
+func (s *Store) Get(author refs.FeedRef, seq uint64) (*gabbygrove.Transfer, error) {
+	f, err := s.feed(author, false)
+	if err != nil {
+		return nil, err
+	}
+	return f.get(seq)
+}
+
+// Iterate passes the messages of author from sequence from up to and including to, in order.
+// A to of zero means up to the tip. It stops at the first error fn returns.
+// Unknown feeds are empty.
+func (s *Store) Iterate(author refs.FeedRef, from, to uint64, fn func(*gabbygrove.Transfer) error) error {
+	f, err := s.feed(author, false)
+	if errors.Cause(err) == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if from < 1 {
+		from = 1
+	}
+	if tip := f.tip().Sequence; to == 0 || to > tip {
+		to = tip
+	}
+	for seq := from; seq <= to; seq++ {
+		tr, err := f.get(seq)
+		if err != nil {
+			return err
+		}
+		if err := fn(tr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tip returns the state of author's feed, which is empty for unknown feeds
+func (s *Store) Tip(author refs.FeedRef) (gabbygrove.FeedState, error) {
+	f, err := s.feed(author, false)
+	if errors.Cause(err) == ErrNotFound {
+		return *gabbygrove.NewFeedState(author), nil
+	}
+	if err != nil {
+		return gabbygrove.FeedState{}, err
+	}
+	return f.tip(), nil
+}
+
+// feed returns the opened feed of author. Unless create is set, it fails with ErrNotFound
+// instead of creating the files of a feed that isn't stored yet.
+func (s *Store) feed(author refs.FeedRef, create bool) (*feed, error) {
+	if author.Algo() != refs.RefAlgoFeedGabby {
+		return nil, errors.Errorf("store: not a gabbygrove feed: %s", author.Algo())
+	}
+	name := hex.EncodeToString(author.PubKey())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, has := s.feeds[name]; has {
+		return f, nil
+	}
+	base := filepath.Join(s.dir, name)
+	if !create {
+		if _, err := os.Stat(base + logSuffix); os.IsNotExist(err) {
+			return nil, errors.Wrapf(ErrNotFound, "feed %s", author.ShortSigil())
+		}
+	}
+	if err := s.checkEncryption(); err != nil {
+		return nil, err
+	}
+	f, err := openFeed(base, author, s.content, s.segmentSize, newSealer(s.secret, name+logSuffix))
+	if err != nil {
+		return nil, errors.Wrapf(err, "store: failed to open feed %s", author.ShortSigil())
+	}
+	if s.idx != nil {
+		if err := f.catchUp(s.idx, author); err != nil {
+			f.close()
+			return nil, errors.Wrapf(err, "store: failed to index feed %s", author.ShortSigil())
+		}
+		f.index = s.idx
+	}
+	f.subs = &s.subs
+	s.feeds[name] = f
+	return f, nil
+}
+
+type feed struct {
+	mu sync.Mutex
+
+	// path is where the files of the feed are, without their suffix
+	path string
+
+	log, idxFile, delFile *os.File
+
+	// sealer encrypts the records of the log, it's nil for plaintext stores
+	sealer *sealer
+
+	// segs holds the compressed start of the log if there is one, the log file starts at offset base then
+	segs *segments
+	base int64
+
+	// content is shared between the feeds of the store, deleted are the sequences whose content was deleted
+	content *sharedContent
+	deleted map[uint64]struct{}
+
+	// index is the optional external index of the store, subs are the subscribers of the store
+	index Index
+	subs  *gabbygrove.Broadcaster
+
+	// pending are the appended messages that aren't synced yet, without SyncEach
+	pending []pendingAppend
+
+	// ends holds the end offset of every message, the one of sequence n at n-1
+	ends  []int64
+	state *gabbygrove.FeedState
+
+	// times are the claimed times of the messages once they are loaded, see loadTimes
+	times     []int64
+	timesFile *os.File
+}
+
+func openFeed(base string, author refs.FeedRef, content *sharedContent, segmentSize int, sl *sealer) (*feed, error) {
+	if err := recoverCompaction(base); err != nil {
+		return nil, err
+	}
+	log, err := os.OpenFile(base+logSuffix, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := os.OpenFile(base+indexSuffix, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		log.Close()
+		return nil, err
+	}
+	del, err := os.OpenFile(base+deletedSuffix, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		log.Close()
+		idx.Close()
+		return nil, err
+	}
+	f := &feed{
+		path:    base,
+		log:     log,
+		idxFile: idx,
+		delFile: del,
+		sealer:  sl,
+		content: content,
+		state:   gabbygrove.NewFeedState(author),
+	}
+	if err := f.loadDeleted(); err != nil {
+		f.close()
+		return nil, err
+	}
+	if err := f.recover(base, segmentSize); err != nil {
+		f.close()
+		return nil, err
+	}
+	if err := cutTimes(base, len(f.ends)); err != nil {
+		f.close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// recover loads the index and cuts off what was written after it
+func (f *feed) recover(base string, segmentSize int) error {
+	idxData, err := ioutil.ReadAll(f.idxFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to read index")
+	}
+	if rest := len(idxData) % indexEntrySize; rest != 0 {
+		idxData = idxData[:len(idxData)-rest]
+		if err := f.idxFile.Truncate(int64(len(idxData))); err != nil {
+			return errors.Wrap(err, "failed to cut partial index entry")
+		}
+	}
+
+	f.ends = make([]int64, len(idxData)/indexEntrySize)
+	var last int64
+	for i := range f.ends {
+		end := int64(binary.BigEndian.Uint64(idxData[i*indexEntrySize:]))
+		if end <= last {
+			return errors.Errorf("corrupted index at sequence %d", i+1)
+		}
+		f.ends[i] = end
+		last = end
+	}
+
+	if err := f.loadSegments(base, segmentSize); err != nil {
+		return err
+	}
+	if err := f.cutTorn(); err != nil {
+		return err
+	}
+	last = f.base
+	if n := len(f.ends); n > 0 {
+		last = f.ends[n-1]
+	}
+	last -= f.base
+
+	fi, err := f.log.Stat()
+	if err != nil {
+		return err
+	}
+	switch {
+	case fi.Size() < last:
+		return errors.Errorf("log is shorter then the index (%d < %d)", fi.Size(), last)
+	case fi.Size() > last:
+		if err := f.log.Truncate(last); err != nil {
+			return errors.Wrap(err, "failed to cut unindexed log tail")
+		}
+	}
+
+	if n := len(f.ends); n > 0 {
+		tr, err := f.readLogged(uint64(n))
+		if err != nil {
+			return err
+		}
+		key := tr.Key()
+		f.state.Sequence = uint64(n)
+		f.state.Tip = &key
+
+		// a tombstone is the last message, its content is never deleted
+		if tr, err := f.resolve(uint64(n), tr); err == nil {
+			if _, err := gabbygrove.ParseTombstone(tr); err == nil {
+				f.state.Terminated = true
+			}
+		}
+		if f.state.Revoked, err = f.findRevocation(uint64(n), f.state.Terminated); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findRevocation returns where the feed of n messages was revoked, if it was.
+// A revocation is the last message unless a tombstone followed it, so only a terminated feed is searched further back.
+func (f *feed) findRevocation(n uint64, terminated bool) (*gabbygrove.RevocationPoint, error) {
+	first := n
+	if terminated {
+		first = 1
+	}
+	for seq := n; seq >= first; seq-- {
+		logged, err := f.readLogged(seq)
+		if err != nil {
+			return nil, err
+		}
+		tr, err := f.resolve(seq, logged)
+		if err != nil {
+			continue
+		}
+		if rev, err := gabbygrove.ParseRevocation(tr); err == nil {
+			return &gabbygrove.RevocationPoint{Sequence: seq, Since: rev.Since}, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *feed) close() error {
+	err := f.log.Close()
+	if err2 := f.idxFile.Close(); err == nil {
+		err = err2
+	}
+	if err2 := f.delFile.Close(); err == nil {
+		err = err2
+	}
+	if f.segs != nil {
+		if err2 := f.segs.close(); err == nil {
+			err = err2
+		}
+	}
+	if err2 := f.closeTimes(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+func (f *feed) tip() gabbygrove.FeedState {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return *f.state
+}
+
+// append stores tr, if syncNow is set it's synced right away, otherwise with the next sync.
+// Once it's durable it's put in the index and passed on to the subscribers.
+// Content of at least shareMin bytes goes to the shared content, the log only keeps an empty marker then.
+func (f *feed) append(tr *gabbygrove.Transfer, shareMin int, syncNow bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// the signature is only verified once, next replaces the state after the message is written
+	next := *f.state
+	if err := next.Append(tr); err != nil {
+		return err
+	}
+	if tr.HasContent() && !tr.ContentMatches(tr.Content) {
+		return errors.Wrap(gabbygrove.ErrContentHash, "store")
+	}
+	if f.segs != nil {
+		if err := f.seal(); err != nil {
+			return err
+		}
+	}
+	logged := tr
+	if name, share := f.content.shareable(tr, shareMin); share {
+		if err := f.content.add(name, tr.Content); err != nil {
+			return err
+		}
+		marked := *tr
+		marked.Content = []byte{}
+		logged = &marked
+	}
+	data, err := logged.MarshalReceived()
+	if err != nil {
+		return errors.Wrap(err, "store: failed to marshal")
+	}
+	seq := uint64(len(f.ends)) + 1
+	if data, err = f.sealer.seal(seq, data); err != nil {
+		return err
+	}
+
+	start := f.start(seq)
+	if _, err := f.log.WriteAt(data, start-f.base); err != nil {
+		return errors.Wrap(err, "store: failed to write log")
+	}
+	if syncNow {
+		if err := f.log.Sync(); err != nil {
+			return errors.Wrap(err, "store: failed to sync log")
+		}
+	}
+
+	end := start + int64(len(data))
+	var entry [indexEntrySize]byte
+	binary.BigEndian.PutUint64(entry[:], uint64(end))
+	if _, err := f.idxFile.WriteAt(entry[:], int64(len(f.ends))*indexEntrySize); err != nil {
+		return errors.Wrap(err, "store: failed to write index")
+	}
+	if syncNow {
+		if err := f.idxFile.Sync(); err != nil {
+			return errors.Wrap(err, "store: failed to sync index")
+		}
+	}
+
+	f.ends = append(f.ends, end)
+	*f.state = next
+	if err := f.addTime(seq, tr); err != nil {
+		// the message is stored, the time index is loaded from the log again on the next query
+		f.closeTimes()
+	}
+	f.pending = append(f.pending, pendingAppend{tr: tr, seq: f.state.Sequence, start: start})
+	if syncNow {
+		return f.published()
+	}
+	return nil
+}
+
+func (f *feed) get(seq uint64) (*gabbygrove.Transfer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.read(seq)
+}
+
+// read returns the message at seq with its content, f.mu needs to be held
+func (f *feed) read(seq uint64) (*gabbygrove.Transfer, error) {
+	logged, err := f.readLogged(seq)
+	if err != nil {
+		return nil, err
+	}
+	return f.resolve(seq, logged)
+}
+
+// readLogged returns the message at seq as it is in the log
+func (f *feed) readLogged(seq uint64) (*gabbygrove.Transfer, error) {
+	if seq < 1 || seq > uint64(len(f.ends)) {
+		return nil, errors.Wrapf(ErrNotFound, "sequence %d", seq)
+	}
+	var data []byte
+	if seq <= f.segs.messages() {
+		var err error
+		if data, err = f.readSegment(seq); err != nil {
+			return nil, err
+		}
+	} else {
+		start := f.start(seq)
+		data = make([]byte, f.ends[seq-1]-start)
+		if _, err := f.log.ReadAt(data, start-f.base); err != nil {
+			return nil, errors.Wrapf(err, "store: failed to read %d", seq)
+		}
+	}
+	data, err := f.sealer.open(seq, data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "store: message %d", seq)
+	}
+	var tr gabbygrove.Transfer
+	if err := tr.UnmarshalReceived(data); err != nil {
+		return nil, errors.Wrapf(err, "store: failed to decode %d", seq)
+	}
+	return &tr, nil
+}
+
+// start returns the offset of the message at seq
+func (f *feed) start(seq uint64) int64 {
+	if seq <= 1 {
+		return 0
+	}
+	return f.ends[seq-2]
+}
@@ -0,0 +1,435 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+)
+
+func makeFeed(t *testing.T, seed string, n int) (refs.FeedRef, []*gabbygrove.Transfer) {
+	r := require.New(t)
+
+	pub, priv, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte(seed), 32/len(seed))))
+	r.NoError(err)
+	author, err := refs.NewFeedRefFromBytes(pub, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+
+	e := gabbygrove.NewEncoder(priv)
+	state := gabbygrove.NewFeedState(author)
+	var trs []*gabbygrove.Transfer
+	for i := 0; i < n; i++ {
+		seq, prev := state.Next()
+		tr, _, err := e.Encode(seq, prev, map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+		r.NoError(state.Append(tr))
+		trs = append(trs, tr)
+	}
+	return author, trs
+}
+
+func TestStore(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbystore")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	r.NoError(err)
+
+	alice, aliceTrs := makeFeed(t, "dead", 5)
+	bob, bobTrs := makeFeed(t, "beef", 2)
+
+	tip, err := s.Tip(alice)
+	r.NoError(err)
+	a.EqualValues(0, tip.Sequence)
+
+	a.Error(s.Append(aliceTrs[1]), "out of order")
+	for _, tr := range aliceTrs {
+		r.NoError(s.Append(tr))
+	}
+	r.NoError(s.Append(bobTrs[0]))
+	a.Error(s.Append(aliceTrs[4]), "already stored")
+
+	got, err := s.Get(alice, 3)
+	r.NoError(err)
+	a.Equal(aliceTrs[2].Key(), got.Key())
+	_, has := got.ReceivedAt()
+	a.False(has)
+	_, err = s.Get(alice, 6)
+	a.Equal(ErrNotFound, errors.Cause(err))
+
+	var seqs []int64
+	r.NoError(s.Iterate(alice, 2, 4, func(tr *gabbygrove.Transfer) error {
+		seqs = append(seqs, tr.Seq())
+		return nil
+	}))
+	a.Equal([]int64{2, 3, 4}, seqs)
+
+	var n int
+	r.NoError(s.Iterate(bob, 0, 0, func(*gabbygrove.Transfer) error { n++; return nil }))
+	a.Equal(1, n)
+	r.NoError(s.Close())
+
+	// reopen after a torn write at the end of alice's log and index
+	base := filepath.Join(dir, hex.EncodeToString(alice.PubKey()))
+	appendFile(t, base+logSuffix, []byte{0x83, 0x58})
+	appendFile(t, base+indexSuffix, []byte{0x00, 0x01})
+
+	s, err = Open(dir)
+	r.NoError(err)
+	defer s.Close()
+
+	tip, err = s.Tip(alice)
+	r.NoError(err)
+	a.EqualValues(5, tip.Sequence)
+	a.Equal(aliceTrs[4].Key(), *tip.Tip)
+
+	rcv := time.Unix(1600000000, 123e6)
+	bobTrs[1].SetReceived(rcv)
+	r.NoError(s.Append(bobTrs[1]))
+	tip, err = s.Tip(bob)
+	r.NoError(err)
+	a.EqualValues(2, tip.Sequence)
+	r.NoError(s.Close())
+
+	// the received time survives a restart
+	s, err = Open(dir)
+	r.NoError(err)
+	got, err = s.Get(bob, 2)
+	r.NoError(err)
+	a.Equal(bobTrs[1].Key(), got.Key())
+	a.True(rcv.Equal(got.Received()), "got %s", got.Received())
+	tip, err = s.Tip(bob)
+	r.NoError(err)
+	a.Equal(bobTrs[1].Key(), *tip.Tip)
+}
+
+func TestStoreUnknownFeed(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbystore")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	r.NoError(err)
+	defer s.Close()
+
+	alice, aliceTrs := makeFeed(t, "dead", 1)
+
+	// reading a feed that isn't stored doesn't create it
+	_, err = s.Get(alice, 1)
+	a.Equal(ErrNotFound, errors.Cause(err))
+	a.Equal(ErrNotFound, errors.Cause(s.DeleteContent(alice, 1)))
+	tip, err := s.Tip(alice)
+	r.NoError(err)
+	a.EqualValues(0, tip.Sequence)
+	a.True(tip.Author.Equal(alice))
+	r.NoError(s.Iterate(alice, 0, 0, func(*gabbygrove.Transfer) error {
+		return errors.New("unexpected message")
+	}))
+	c, err := s.Page(Cursor{Author: alice}, 0, func(*gabbygrove.Transfer) error {
+		return errors.New("unexpected message")
+	})
+	r.NoError(err)
+	a.EqualValues(0, c.Sequence)
+	entries, err := s.ClaimedBetween([]refs.FeedRef{alice}, time.Time{}, time.Time{})
+	r.NoError(err)
+	a.Empty(entries)
+
+	files, err := ioutil.ReadDir(dir)
+	r.NoError(err)
+	for _, fi := range files {
+		a.True(fi.IsDir(), "created %s", fi.Name())
+	}
+	feeds, err := s.Feeds()
+	r.NoError(err)
+	a.Empty(feeds)
+
+	// the content needs to match the event
+	tampered := *aliceTrs[0]
+	tampered.Content = bytes.Replace(aliceTrs[0].Content, []byte("0"), []byte("1"), 1)
+	a.Equal(gabbygrove.ErrContentHash, errors.Cause(s.Append(&tampered)))
+	tip, err = s.Tip(alice)
+	r.NoError(err)
+	a.EqualValues(0, tip.Sequence)
+
+	r.NoError(s.Append(aliceTrs[0]))
+	got, err := s.Get(alice, 1)
+	r.NoError(err)
+	a.Equal(aliceTrs[0].Key(), got.Key())
+}
+
+// verifyCounter counts the signature checks of the gabbygrove package
+type verifyCounter struct{ n int64 }
+
+func (vc *verifyCounter) Count(m gabbygrove.Metric, failed bool, bytes int) {
+	if m == gabbygrove.MetricVerify {
+		atomic.AddInt64(&vc.n, 1)
+	}
+}
+
+func TestStoreVerifiesOnce(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbystore")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	r.NoError(err)
+	defer s.Close()
+
+	_, trs := makeFeed(t, "dead", 3)
+	var vc verifyCounter
+	gabbygrove.SetMetricsSink(&vc)
+	defer gabbygrove.SetMetricsSink(nil)
+	for _, tr := range trs {
+		r.NoError(s.Append(tr))
+	}
+	a.EqualValues(len(trs), atomic.LoadInt64(&vc.n))
+}
+
+func TestStoreTerminated(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbystore")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	r.NoError(err)
+
+	// continue the feed of makeFeed with a tombstone and one message after it
+	alice, trs := makeFeed(t, "dead", 2)
+	_, priv, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	r.NoError(err)
+	e := gabbygrove.NewEncoder(priv)
+	state := gabbygrove.NewFeedState(alice)
+	for _, tr := range trs {
+		r.NoError(state.Append(tr))
+		r.NoError(s.Append(tr))
+	}
+	seq, prev := state.Next()
+	ts, _, err := e.EncodeTombstone(seq, prev, *gabbygrove.NewTombstone("bye"))
+	r.NoError(err)
+	r.NoError(state.Append(ts))
+	seq, prev = state.Next()
+	after, _, err := e.Encode(seq, prev, "after")
+	r.NoError(err)
+
+	r.NoError(s.Append(ts))
+	r.NoError(s.DeleteContent(alice, 3))
+	r.NoError(s.Close())
+
+	// the tombstone still ends the feed after a restart
+	s, err = Open(dir)
+	r.NoError(err)
+	defer s.Close()
+	tip, err := s.Tip(alice)
+	r.NoError(err)
+	a.True(tip.Terminated)
+	a.EqualValues(3, tip.Sequence)
+	a.Equal(gabbygrove.ErrFeedTerminated, errors.Cause(s.Append(after)))
+
+	got, err := s.Get(alice, 3)
+	r.NoError(err)
+	a.True(got.HasContent(), "deleted the content of the tombstone")
+}
+
+func TestStoreRevoked(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbystore")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	r.NoError(err)
+
+	alice, trs := makeFeed(t, "dead", 2)
+	_, priv, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	r.NoError(err)
+	e := gabbygrove.NewEncoder(priv)
+	state := gabbygrove.NewFeedState(alice)
+	for _, tr := range trs {
+		r.NoError(state.Append(tr))
+		r.NoError(s.Append(tr))
+	}
+	seq, prev := state.Next()
+	rev, _, err := e.EncodeRevocation(seq, prev, *gabbygrove.NewRevocation(2, "laptop stolen"))
+	r.NoError(err)
+	r.NoError(state.Append(rev))
+	seq, prev = state.Next()
+	after, _, err := e.Encode(seq, prev, "after")
+	r.NoError(err)
+
+	r.NoError(s.Append(rev))
+	r.NoError(s.DeleteContent(alice, 3))
+	r.NoError(s.Close())
+
+	// the revocation is restored after a restart
+	s, err = Open(dir)
+	r.NoError(err)
+	defer s.Close()
+	tip, err := s.Tip(alice)
+	r.NoError(err)
+	r.NotNil(tip.Revoked)
+	a.Equal(gabbygrove.RevocationPoint{Sequence: 3, Since: 2}, *tip.Revoked)
+	a.False(tip.Terminated)
+	a.Equal(gabbygrove.ErrKeyRevoked, errors.Cause(s.Append(after)))
+
+	got, err := s.Get(alice, 3)
+	r.NoError(err)
+	a.True(got.HasContent(), "deleted the content of the revocation")
+}
+
+func TestStoreRevokedTerminated(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbystore")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	r.NoError(err)
+
+	alice, trs := makeFeed(t, "dead", 2)
+	_, priv, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	r.NoError(err)
+	e := gabbygrove.NewEncoder(priv)
+	state := gabbygrove.NewFeedState(alice)
+	for _, tr := range trs {
+		r.NoError(state.Append(tr))
+		r.NoError(s.Append(tr))
+	}
+	seq, prev := state.Next()
+	rev, _, err := e.EncodeRevocation(seq, prev, *gabbygrove.NewRevocation(2, "laptop stolen"))
+	r.NoError(err)
+	r.NoError(state.Append(rev))
+	seq, prev = state.Next()
+	ts, _, err := e.EncodeTombstone(seq, prev, *gabbygrove.NewTombstone("bye"))
+	r.NoError(err)
+	r.NoError(s.Append(rev))
+
+	// the store refuses the tombstone after the revocation, a peer that accepts it passed it along
+	f, err := s.feed(alice, false)
+	r.NoError(err)
+	f.mu.Lock()
+	f.state.Revoked = nil
+	f.mu.Unlock()
+	r.NoError(s.Append(ts))
+	r.NoError(s.Close())
+
+	s, err = Open(dir)
+	r.NoError(err)
+	defer s.Close()
+	tip, err := s.Tip(alice)
+	r.NoError(err)
+	a.True(tip.Terminated)
+	r.NotNil(tip.Revoked)
+	a.Equal(gabbygrove.RevocationPoint{Sequence: 3, Since: 2}, *tip.Revoked)
+	r.NoError(s.DeleteContent(alice, 3))
+	got, err := s.Get(alice, 3)
+	r.NoError(err)
+	a.True(got.HasContent(), "deleted the content of the revocation")
+}
+
+func appendFile(t *testing.T, name string, data []byte) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func TestStoreMemIndex(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbystore")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	r.NoError(err)
+	defer s.Close()
+
+	_, err = s.GetByKey(refs.MessageRef{})
+	a.Error(err, "no index")
+
+	s.WithIndex(NewMemIndex())
+	_, trs := makeFeed(t, "dead", 3)
+	for _, tr := range trs {
+		r.NoError(s.Append(tr))
+	}
+
+	got, err := s.GetByKey(trs[1].Key())
+	r.NoError(err)
+	a.EqualValues(2, got.Seq())
+
+	_, otherTrs := makeFeed(t, "beef", 1)
+	_, err = s.GetByKey(otherTrs[0].Key())
+	a.Equal(ErrNotFound, errors.Cause(err))
+}
+
+func TestStoreSubscribe(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbystore")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	r.NoError(err)
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice, aliceTrs := makeFeed(t, "dead", 2)
+	_, bobTrs := makeFeed(t, "beef", 1)
+
+	ch := s.Subscribe(ctx, &alice, 5)
+	r.NoError(s.Append(bobTrs[0]))
+	for _, tr := range aliceTrs {
+		r.NoError(s.Append(tr))
+	}
+	// failed appends are not passed on
+	a.Error(s.Append(aliceTrs[0]))
+
+	for _, want := range aliceTrs {
+		got := <-ch
+		a.Equal(want.Key(), got.Key())
+	}
+	a.Len(ch, 0)
+
+	cancel()
+	_, ok := <-ch
+	a.False(ok)
+}
@@ -0,0 +1,202 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+)
+
+// SyncPolicy says when appended messages are synced to disk
+type SyncPolicy int
+
+const (
+	// SyncEach syncs every message before Append returns, this is the default
+	SyncEach SyncPolicy = iota
+
+	// SyncBatch syncs once at the end of AppendBatch and on Sync or Close
+	SyncBatch
+
+	// SyncPeriodic syncs in the background every interval, see WithSyncPolicy
+	SyncPeriodic
+)
+
+// WithSyncPolicy sets when appended messages are synced, interval is only used by SyncPeriodic.
+//
+// Without SyncEach, messages that aren't synced yet can be lost in a crash; they are only put in the index
+// and passed on to subscribers once they are synced. Losing published messages of a local author is dangerous:
+// the next message would fork the feed. Its author should be synced with Sync before the message is shared.
+// When the store is opened again, messages at the end of a log that weren't completely written are cut off.
+func (s *Store) WithSyncPolicy(p SyncPolicy, interval time.Duration) error {
+	if p == SyncPeriodic && interval <= 0 {
+		return errors.Errorf("store: periodic sync needs an interval")
+	}
+	s.stopPeriodicSync()
+
+	s.mu.Lock()
+	s.syncPolicy = p
+	if p == SyncPeriodic {
+		s.stopSync = make(chan struct{})
+		s.syncDone = make(chan struct{})
+		go s.syncEvery(interval, s.stopSync, s.syncDone)
+	}
+	s.mu.Unlock()
+
+	// what was appended before shouldn't wait for a sync that might not come
+	if p == SyncEach {
+		return s.Sync()
+	}
+	return nil
+}
+
+func (s *Store) syncEvery(interval time.Duration, stop, done chan struct{}) {
+	defer close(done)
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+			// errors come up again with the next explicit Sync or Close
+			s.Sync()
+		}
+	}
+}
+
+func (s *Store) stopPeriodicSync() {
+	s.mu.Lock()
+	stop, done := s.stopSync, s.syncDone
+	s.stopSync, s.syncDone = nil, nil
+	s.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// Sync syncs the messages of all feeds that were appended since the last sync
+func (s *Store) Sync() error {
+	s.mu.Lock()
+	feeds := make([]*feed, 0, len(s.feeds))
+	for _, f := range s.feeds {
+		feeds = append(feeds, f)
+	}
+	s.mu.Unlock()
+
+	var firstErr error
+	for _, f := range feeds {
+		f.mu.Lock()
+		err := f.sync()
+		f.mu.Unlock()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// AppendBatch appends trs in order and syncs every feed it appended to once at the end, unless the policy is SyncEach.
+// It stops at the first message that can't be appended, the ones before it are synced anyway.
+func (s *Store) AppendBatch(trs []*gabbygrove.Transfer) error {
+	s.mu.Lock()
+	shareMin, policy := s.shareMin, s.syncPolicy
+	s.mu.Unlock()
+
+	touched := make(map[*feed]struct{})
+	var appendErr error
+	for i, tr := range trs {
+		author, err := authorOf(tr)
+		if err != nil {
+			appendErr = errors.Wrapf(err, "store: batch message %d", i)
+			break
+		}
+		f, err := s.feed(author, true)
+		if err != nil {
+			appendErr = errors.Wrapf(err, "store: batch message %d", i)
+			break
+		}
+		if err := f.append(tr, shareMin, policy == SyncEach); err != nil {
+			appendErr = errors.Wrapf(err, "store: batch message %d", i)
+			break
+		}
+		touched[f] = struct{}{}
+	}
+	for f := range touched {
+		f.mu.Lock()
+		err := f.sync()
+		f.mu.Unlock()
+		if err != nil && appendErr == nil {
+			appendErr = err
+		}
+	}
+	return appendErr
+}
+
+// pendingAppend is a message that is written but not published to the index and subscribers
+type pendingAppend struct {
+	tr    *gabbygrove.Transfer
+	seq   uint64
+	start int64
+}
+
+// sync makes the pending messages durable and publishes them, f.mu needs to be held
+func (f *feed) sync() error {
+	if len(f.pending) == 0 {
+		return nil
+	}
+	if err := f.log.Sync(); err != nil {
+		return errors.Wrap(err, "store: failed to sync log")
+	}
+	if err := f.idxFile.Sync(); err != nil {
+		return errors.Wrap(err, "store: failed to sync index")
+	}
+	return f.published()
+}
+
+// published puts the pending messages in the index and passes them on to the subscribers, they need to be durable
+func (f *feed) published() error {
+	pending := f.pending
+	f.pending = nil
+	for i, p := range pending {
+		if f.index != nil {
+			if err := f.index.Put(f.state.Author, p.seq, p.tr.Key(), p.start); err != nil {
+				// the rest is caught up when the feed is opened again
+				f.pending = pending[i+1:]
+				return errors.Wrap(err, "store: failed to update index")
+			}
+		}
+		if f.subs != nil {
+			f.subs.Broadcast(p.tr)
+		}
+	}
+	return nil
+}
+
+// cutTorn drops messages from the end of the index that weren't completely written before a crash,
+// which can happen if the index made it to disk but the log didn't. f.ends and f.segs need to be loaded.
+func (f *feed) cutTorn() error {
+	n := uint64(len(f.ends))
+	for n > f.segs.messages() && !f.intact(n) {
+		n--
+	}
+	if n == uint64(len(f.ends)) {
+		return nil
+	}
+	f.ends = f.ends[:n]
+	if err := f.idxFile.Truncate(int64(n) * indexEntrySize); err != nil {
+		return errors.Wrap(err, "failed to cut torn index entries")
+	}
+	return f.idxFile.Sync()
+}
+
+// intact returns true if the message at seq was written completely.
+// It doesn't verify it, a broken signature or content is corruption that Fsck reports and not a torn write.
+func (f *feed) intact(seq uint64) bool {
+	tr, err := f.readLogged(seq)
+	return err == nil && uint64(tr.Seq()) == seq
+}
@@ -0,0 +1,162 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+)
+
+func TestStoreSyncBatch(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbystore")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	r.NoError(err)
+	s.WithIndex(NewMemIndex())
+	r.NoError(s.WithSyncPolicy(SyncBatch, 0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := s.Subscribe(ctx, nil, 10)
+
+	alice, trs := makeFeed(t, "dead", 5)
+	_, bobTrs := makeFeed(t, "beef", 1)
+
+	// readable right away, published once synced
+	r.NoError(s.Append(trs[0]))
+	got, err := s.Get(alice, 1)
+	r.NoError(err)
+	a.Equal(trs[0].Key(), got.Key())
+	a.Len(updates, 0)
+	_, err = s.GetByKey(trs[0].Key())
+	a.Equal(ErrNotFound, errors.Cause(err))
+
+	r.NoError(s.Sync())
+	a.Len(updates, 1)
+	_, err = s.GetByKey(trs[0].Key())
+	r.NoError(err)
+
+	r.NoError(s.AppendBatch([]*gabbygrove.Transfer{trs[1], bobTrs[0], trs[2]}))
+	a.Len(updates, 4)
+
+	// stops at the first broken one but keeps the others
+	a.Error(s.AppendBatch([]*gabbygrove.Transfer{trs[3], trs[3]}))
+	a.Len(updates, 5)
+
+	// garbage fails like any other broken message
+	a.Error(s.AppendBatch([]*gabbygrove.Transfer{{Event: []byte{0xff, 0x00}}}))
+	a.Len(updates, 5)
+
+	// pending messages are synced on close
+	r.NoError(s.Append(trs[4]))
+	r.NoError(s.Close())
+	s, err = Open(dir)
+	r.NoError(err)
+	defer s.Close()
+	tip, err := s.Tip(alice)
+	r.NoError(err)
+	a.EqualValues(5, tip.Sequence)
+}
+
+func TestStoreSyncPeriodic(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbystore")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	r.NoError(err)
+	defer s.Close()
+
+	a.Error(s.WithSyncPolicy(SyncPeriodic, 0))
+	r.NoError(s.WithSyncPolicy(SyncPeriodic, 10*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := s.Subscribe(ctx, nil, 10)
+
+	_, trs := makeFeed(t, "dead", 2)
+	r.NoError(s.Append(trs[0]))
+	select {
+	case got := <-updates:
+		a.Equal(trs[0].Key(), got.Key())
+	case <-time.After(5 * time.Second):
+		t.Fatal("not synced")
+	}
+
+	// going back syncs what is pending
+	r.NoError(s.WithSyncPolicy(SyncBatch, 0))
+	r.NoError(s.Append(trs[1]))
+	a.Len(updates, 0)
+	r.NoError(s.WithSyncPolicy(SyncEach, 0))
+	a.Len(updates, 1)
+}
+
+func TestStoreTornWrite(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbystore")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	r.NoError(err)
+	alice, trs := makeFeed(t, "dead", 4)
+	for _, tr := range trs[:3] {
+		r.NoError(s.Append(tr))
+	}
+	r.NoError(s.Close())
+	base := filepath.Join(dir, hex.EncodeToString(alice.PubKey()))
+
+	logStat := func() int64 {
+		fi, err := os.Stat(base + logSuffix)
+		r.NoError(err)
+		return fi.Size()
+	}
+	size := logStat()
+
+	// the index made it to disk, the log only partially or with a hole
+	for _, torn := range [][]byte{nil, make([]byte, 100), make([]byte, 300)} {
+		var entry [indexEntrySize]byte
+		binary.BigEndian.PutUint64(entry[:], uint64(size+200))
+		appendFile(t, base+indexSuffix, entry[:])
+		appendFile(t, base+logSuffix, torn)
+
+		s, err = Open(dir)
+		r.NoError(err)
+		tip, err := s.Tip(alice)
+		r.NoError(err)
+		a.EqualValues(3, tip.Sequence)
+		a.Equal(size, logStat())
+		r.NoError(s.Close())
+	}
+
+	s, err = Open(dir)
+	r.NoError(err)
+	defer s.Close()
+	r.NoError(s.Append(trs[3]))
+	got, err := s.Get(alice, 4)
+	r.NoError(err)
+	a.Equal(trs[3].Key(), got.Key())
+}
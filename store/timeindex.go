@@ -0,0 +1,187 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// The claimed time of every message is kept in a time index per feed, one big-endian int64 of unix seconds per sequence.
+// It's derived from the log, so it's only loaded on the first time query, caught up from the log then
+// and cut back to the log when the feed is opened. It isn't synced, nothing is lost if it's incomplete.
+// Encrypted stores only keep it in memory, the times would be readable in the file.
+const (
+	timesSuffix = ".tim"
+
+	timesEntrySize = 8
+)
+
+// TimeEntry is a message found by its claimed time
+type TimeEntry struct {
+	Author   refs.FeedRef
+	Sequence uint64
+	Claimed  time.Time
+}
+
+// ClaimedBetween returns the messages of authors that claim a time from from up to but excluding to,
+// ordered by that time and by author and sequence for the same time.
+// No authors means all feeds, a zero from means no start and a zero to no end.
+// Claimed times are what the authors say, they are not checked and not necessarily in order within a feed.
+func (s *Store) ClaimedBetween(authors []refs.FeedRef, from, to time.Time) ([]TimeEntry, error) {
+	if len(authors) == 0 {
+		var err error
+		if authors, err = s.Feeds(); err != nil {
+			return nil, err
+		}
+	}
+	var entries []TimeEntry
+	for _, author := range authors {
+		f, err := s.feed(author, false)
+		if errors.Cause(err) == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found, err := f.claimedBetween(author, from, to)
+		if err != nil {
+			return nil, errors.Wrapf(err, "store: failed to load times of %s", author.ShortSigil())
+		}
+		entries = append(entries, found...)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		ei, ej := entries[i], entries[j]
+		if !ei.Claimed.Equal(ej.Claimed) {
+			return ei.Claimed.Before(ej.Claimed)
+		}
+		if ei.Author.Equal(ej.Author) {
+			return ei.Sequence < ej.Sequence
+		}
+		return string(ei.Author.PubKey()) < string(ej.Author.PubKey())
+	})
+	return entries, nil
+}
+
+// IterateTime passes the messages that ClaimedBetween returns to fn, in its order.
+// It stops at the first error fn returns.
+func (s *Store) IterateTime(authors []refs.FeedRef, from, to time.Time, fn func(*gabbygrove.Transfer) error) error {
+	entries, err := s.ClaimedBetween(authors, from, to)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		tr, err := s.Get(e.Author, e.Sequence)
+		if err != nil {
+			return err
+		}
+		if err := fn(tr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *feed) claimedBetween(author refs.FeedRef, from, to time.Time) ([]TimeEntry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.loadTimes(); err != nil {
+		return nil, err
+	}
+	var entries []TimeEntry
+	for i, ts := range f.times {
+		claimed := time.Unix(ts, 0)
+		if (!from.IsZero() && claimed.Before(from)) || (!to.IsZero() && !claimed.Before(to)) {
+			continue
+		}
+		entries = append(entries, TimeEntry{Author: author, Sequence: uint64(i) + 1, Claimed: claimed})
+	}
+	return entries, nil
+}
+
+// loadTimes reads the time index and adds the messages it doesn't have yet, f.mu needs to be held
+func (f *feed) loadTimes() error {
+	if f.times != nil {
+		return nil
+	}
+	times := make([]int64, 0, len(f.ends))
+	if f.sealer == nil {
+		tf, err := os.OpenFile(f.path+timesSuffix, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadAll(tf)
+		if err != nil {
+			tf.Close()
+			return err
+		}
+		for i := 0; i+timesEntrySize <= len(data) && len(times) < len(f.ends); i += timesEntrySize {
+			times = append(times, int64(binary.BigEndian.Uint64(data[i:])))
+		}
+		f.timesFile = tf
+	}
+	f.times = times
+	for seq := uint64(len(times)) + 1; seq <= uint64(len(f.ends)); seq++ {
+		tr, err := f.readLogged(seq)
+		if err != nil {
+			f.closeTimes()
+			return err
+		}
+		if err := f.addTime(seq, tr); err != nil {
+			f.closeTimes()
+			return err
+		}
+	}
+	return nil
+}
+
+// addTime records the claimed time of tr at seq if the time index is loaded, f.mu needs to be held
+func (f *feed) addTime(seq uint64, tr *gabbygrove.Transfer) error {
+	if f.times == nil {
+		return nil
+	}
+	ts := tr.Claimed().Unix()
+	if f.timesFile != nil {
+		var entry [timesEntrySize]byte
+		binary.BigEndian.PutUint64(entry[:], uint64(ts))
+		if _, err := f.timesFile.WriteAt(entry[:], int64(seq-1)*timesEntrySize); err != nil {
+			return errors.Wrap(err, "store: failed to write time index")
+		}
+	}
+	f.times = append(f.times, ts)
+	return nil
+}
+
+// closeTimes unloads the time index
+func (f *feed) closeTimes() error {
+	f.times = nil
+	if f.timesFile == nil {
+		return nil
+	}
+	err := f.timesFile.Close()
+	f.timesFile = nil
+	return err
+}
+
+// cutTimes cuts the time index of the feed at base back to n messages, i.e. after the log was cut
+func cutTimes(base string, n int) error {
+	fi, err := os.Stat(base + timesSuffix)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if max := int64(n) * timesEntrySize; fi.Size() > max {
+		return os.Truncate(base+timesSuffix, max)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package store
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+)
+
+// makeTimedFeed makes a feed whose messages claim the unix times in times
+func makeTimedFeed(t *testing.T, seed string, times ...int64) (refs.FeedRef, []*gabbygrove.Transfer) {
+	r := require.New(t)
+
+	pub, priv, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte(seed), 32/len(seed))))
+	r.NoError(err)
+	author, err := refs.NewFeedRefFromBytes(pub, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+
+	e := gabbygrove.NewEncoder(priv)
+	state := gabbygrove.NewFeedState(author)
+	var trs []*gabbygrove.Transfer
+	for i, ts := range times {
+		claimed := time.Unix(ts, 0)
+		e.WithClock(func() time.Time { return claimed })
+		seq, prev := state.Next()
+		tr, _, err := e.Encode(seq, prev, map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+		r.NoError(state.Append(tr))
+		trs = append(trs, tr)
+	}
+	return author, trs
+}
+
+func TestStoreClaimedBetween(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "gabbystore")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	r.NoError(err)
+
+	alice, aliceTrs := makeTimedFeed(t, "dead", 100, 300, 200, 500)
+	bob, bobTrs := makeTimedFeed(t, "beef", 150, 300, 400)
+	for _, tr := range append(aliceTrs[:3], bobTrs...) {
+		r.NoError(s.Append(tr))
+	}
+
+	type found struct {
+		author refs.FeedRef
+		seq    uint64
+		ts     int64
+	}
+	query := func(authors []refs.FeedRef, from, to int64) []found {
+		var fromT, toT time.Time
+		if from > 0 {
+			fromT = time.Unix(from, 0)
+		}
+		if to > 0 {
+			toT = time.Unix(to, 0)
+		}
+		entries, err := s.ClaimedBetween(authors, fromT, toT)
+		r.NoError(err)
+		var fs []found
+		for _, e := range entries {
+			fs = append(fs, found{e.Author, e.Sequence, e.Claimed.Unix()})
+		}
+		return fs
+	}
+
+	// ties are ordered by public key, bob's is the smaller one
+	r.True(bytes.Compare(bob.PubKey(), alice.PubKey()) < 0)
+	a.Equal([]found{
+		{alice, 1, 100}, {bob, 1, 150}, {alice, 3, 200}, {bob, 2, 300}, {alice, 2, 300}, {bob, 3, 400},
+	}, query(nil, 0, 0))
+	a.Equal([]found{{alice, 3, 200}, {bob, 2, 300}, {alice, 2, 300}}, query(nil, 200, 400), "to is excluded")
+	a.Equal([]found{{alice, 1, 100}, {alice, 3, 200}}, query([]refs.FeedRef{alice}, 0, 300))
+
+	// appended after the time index was loaded
+	r.NoError(s.Append(aliceTrs[3]))
+	a.Equal([]found{{bob, 3, 400}, {alice, 4, 500}}, query(nil, 400, 0))
+
+	var seqs []int64
+	err = s.IterateTime([]refs.FeedRef{alice, bob}, time.Unix(250, 0), time.Time{}, func(tr *gabbygrove.Transfer) error {
+		seqs = append(seqs, tr.Seq())
+		a.False(tr.Claimed().Before(time.Unix(250, 0)))
+		return nil
+	})
+	r.NoError(err)
+	a.Equal([]int64{2, 2, 3, 4}, seqs)
+	r.NoError(s.Close())
+
+	// the time index is kept on disk and cut back to the log when it's longer
+	timesPath := filepath.Join(dir, hex.EncodeToString(alice.PubKey())+timesSuffix)
+	fi, err := os.Stat(timesPath)
+	r.NoError(err)
+	a.EqualValues(4*timesEntrySize, fi.Size())
+	tf, err := os.OpenFile(timesPath, os.O_WRONLY|os.O_APPEND, 0600)
+	r.NoError(err)
+	_, err = tf.Write(bytes.Repeat([]byte{0xff}, 2*timesEntrySize))
+	r.NoError(err)
+	r.NoError(tf.Close())
+
+	s, err = Open(dir)
+	r.NoError(err)
+	a.Equal([]found{{alice, 1, 100}, {alice, 3, 200}, {alice, 2, 300}, {alice, 4, 500}}, query([]refs.FeedRef{alice}, 0, 0))
+	fi, err = os.Stat(timesPath)
+	r.NoError(err)
+	a.EqualValues(4*timesEntrySize, fi.Size())
+
+	// a missing time index is rebuilt from the log
+	r.NoError(s.Close())
+	r.NoError(os.Remove(timesPath))
+	s, err = Open(dir)
+	r.NoError(err)
+	a.Len(query([]refs.FeedRef{alice}, 0, 0), 4)
+	r.NoError(s.Close())
+}
@@ -0,0 +1,90 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// DecodeFrom reads exactly one transfer from r, i.e. straight from a network connection.
+// No more then the maximum transfer size is read, bytes following the transfer are left in r.
+// At the end of the stream io.EOF is returned as is.
+func (tr *Transfer) DecodeFrom(r io.Reader) error {
+	_, err := tr.decodeStream(r, decodeOptions{})
+	return err
+}
+
+// writeToCopyLimit is the size of content up to which WriteTo copies it to write the transfer with a single call
+const writeToCopyLimit = 4096
+
+// WriteTo writes the encoding of the transfer to w, like MarshalCBOR does, and implements io.WriterTo.
+// Large content isn't copied but passed to w as is, so put a bufio.Writer in front of w if it makes a syscall for every write.
+// The content of a transfer made by EncodeFromFile isn't part of the encoding, see WriteContentTo.
+func (tr *Transfer) WriteTo(w io.Writer) (int64, error) {
+	if len(tr.Content) <= writeToCopyLimit {
+		n, err := w.Write(tr.appendCBOR(make([]byte, 0, tr.encodedLen())))
+		return int64(n), err
+	}
+
+	head := appendCBORHead(make([]byte, 0, 1+cborBytesLen(len(tr.Event))+cborBytesLen(len(tr.Signature))+3), cborMajorArray, uint64(transferFieldCount+len(tr.unknown)))
+	head = appendCBORBytes(head, tr.Event)
+	head = appendCBORBytes(head, tr.Signature)
+	head = appendCBORHead(head, cborMajorBytes, uint64(len(tr.Content)))
+	var tail []byte
+	for _, u := range tr.unknown {
+		tail = append(tail, u...)
+	}
+
+	var total int64
+	for _, b := range [][]byte{head, tr.Content, tail} {
+		if len(b) == 0 {
+			continue
+		}
+		n, err := w.Write(b)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// decodeStream returns the number of bytes read from r
+func (tr *Transfer) decodeStream(r io.Reader, opts decodeOptions) (_ int, err error) {
+	raw, err := readCBORItem(r, maxTransferSize, opts.budget)
+	defer func() { countMetric(MetricDecode, err != nil, len(raw)) }()
+	if err == io.EOF {
+		return 0, io.EOF
+	}
+	if err != nil {
+		debugLog("event", "decode", "bytes", len(raw), "err", err)
+		return len(raw), errors.Wrap(err, "failed to decode transfer object")
+	}
+
+	var newTr Transfer
+	if _, err = newTr.parseCBOR(raw, opts); err != nil {
+		debugLog("event", "decode", "bytes", len(raw), "err", err)
+		return len(raw), errors.Wrap(err, "failed to decode transfer object")
+	}
+	if err = newTr.checkSizes(DefaultLimits); err != nil {
+		return len(raw), err
+	}
+	*tr = newTr
+	return len(raw), nil
+}
+
+// DecodeFrom reads exactly one event from r
+func (evt *Event) DecodeFrom(r io.Reader) error {
+	raw, err := readCBORItem(r, maxEventSize, nil)
+	if err == io.EOF {
+		return io.EOF
+	}
+	if err != nil {
+		return errors.Wrapf(err, "gabbyGrove/Event: failed to decode")
+	}
+	return evt.decode(raw, decodeOptions{})
+}
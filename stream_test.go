@@ -0,0 +1,123 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeFromStream(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, trs := makeTestFeed(t, "dead", 3)
+
+	client, server := net.Pipe()
+	go func() {
+		for _, tr := range trs {
+			b, err := tr.MarshalCBOR()
+			if err != nil {
+				panic(err)
+			}
+			// write in small pieces, like a slow connection
+			for len(b) > 0 {
+				n := 7
+				if n > len(b) {
+					n = len(b)
+				}
+				client.Write(b[:n])
+				b = b[n:]
+			}
+		}
+		client.Close()
+	}()
+
+	for i := range trs {
+		var got Transfer
+		r.NoError(got.DecodeFrom(server), "transfer %d", i)
+		a.Equal(trs[i].Key(), got.Key())
+		a.True(got.Verify(nil))
+	}
+	var tr Transfer
+	a.Equal(io.EOF, tr.DecodeFrom(server))
+
+	// events from a buffer, leaving the rest in it
+	var buf bytes.Buffer
+	buf.Write(trs[0].Event)
+	buf.Write(trs[1].Event)
+	var evt Event
+	r.NoError(evt.DecodeFrom(&buf))
+	a.EqualValues(1, evt.Sequence)
+	a.Equal(len(trs[1].Event), buf.Len())
+	r.NoError(evt.DecodeFrom(&buf))
+	a.EqualValues(2, evt.Sequence)
+
+	// truncated
+	b, err := trs[0].MarshalCBOR()
+	r.NoError(err)
+	a.Error(tr.DecodeFrom(bytes.NewReader(b[:len(b)-3])))
+}
+
+// countingWriter records the writes it gets
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.writes++
+	return cw.Buffer.Write(p)
+}
+
+func TestWriteTo(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("wrto"), 8)))
+	e := NewEncoder(privKey)
+	small, _, err := e.Encode(1, BinaryRef{}, []byte("small"))
+	r.NoError(err)
+	large, _, err := e.Encode(2, BinaryRef{}, bytes.Repeat([]byte("L"), writeToCopyLimit+1))
+	r.NoError(err)
+
+	var _ io.WriterTo = small
+
+	var buf countingWriter
+	for i, tr := range []*Transfer{small, large} {
+		want, err := tr.MarshalCBOR()
+		r.NoError(err)
+
+		buf.Reset()
+		buf.writes = 0
+		n, err := tr.WriteTo(&buf)
+		r.NoError(err)
+		a.EqualValues(len(want), n)
+		a.Equal(want, buf.Bytes(), "transfer %d", i)
+		if i == 0 {
+			a.Equal(1, buf.writes, "small content is written at once")
+		}
+
+		var got Transfer
+		rd := bytes.NewReader(append(buf.Bytes(), 0xff))
+		r.NoError(got.DecodeFrom(rd))
+		a.Equal(1, rd.Len(), "only the transfer is read")
+		a.Equal(tr.Key(), got.Key())
+	}
+
+	var tr Transfer
+	a.Equal(io.EOF, tr.DecodeFrom(bytes.NewReader(nil)))
+
+	full, err := small.MarshalCBOR()
+	r.NoError(err)
+	err = tr.DecodeFrom(bytes.NewReader(full[:len(full)-1]))
+	a.Error(err)
+	a.NotEqual(io.EOF, err)
+}
@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+)
+
+// SignatureSuite names the algorithm the messages of a feed are signed with.
+// It's not encoded in the messages but follows from the format of the feed:
+// gabbygrove-v1 feeds are always signed with SuiteEd25519.
+// Another suite needs a new feed format like a gabbygrove-v2, a feed can't change its suite.
+type SignatureSuite string
+
+// SuiteEd25519 is the only suite of gabbygrove-v1
+const SuiteEd25519 SignatureSuite = "ed25519"
+
+// UnknownSuiteError is returned for suites this version can't sign or verify with
+type UnknownSuiteError struct {
+	Suite SignatureSuite
+}
+
+func (e *UnknownSuiteError) Error() string {
+	return fmt.Sprintf("gabbygrove: unknown signature suite %q", string(e.Suite))
+}
+
+// ParseSignatureSuite returns the suite named s, i.e. from a configuration file
+func ParseSignatureSuite(s string) (SignatureSuite, error) {
+	switch suite := SignatureSuite(s); suite {
+	case SuiteEd25519:
+		return suite, nil
+	default:
+		return "", &UnknownSuiteError{Suite: suite}
+	}
+}
+
+// SuiteOf returns the suite the messages of author are signed with
+func SuiteOf(author refs.FeedRef) (SignatureSuite, error) {
+	switch author.Algo() {
+	case refs.RefAlgoFeedGabby:
+		return SuiteEd25519, nil
+	default:
+		return "", errors.Wrapf(&UnknownSuiteError{Suite: SignatureSuite(author.Algo())}, "not a gabbygrove feed")
+	}
+}
+
+// Suite returns the suite tr is signed with, see SuiteOf, or SuiteHybridExperimental for messages of the hybrid mode
+func (tr *Transfer) Suite() (SignatureSuite, error) {
+	evt, err := tr.getEvent()
+	if err != nil {
+		return "", err
+	}
+	suite, err := SuiteOf(evt.AuthorFeedRef())
+	if err != nil {
+		return "", err
+	}
+	if _, hybrid := evt.Extensions[HybridExtension]; hybrid {
+		return SuiteHybridExperimental, nil
+	}
+	return suite, nil
+}
+
+// SuiteSigner is a Signer that tells which suite it signs with.
+// Signers that don't implement it are taken as SuiteEd25519.
+type SuiteSigner interface {
+	Signer
+	Suite() SignatureSuite
+}
+
+// signerSuite returns the suite of s, if the encoder can use it
+func signerSuite(s Signer) (SignatureSuite, error) {
+	ss, ok := s.(SuiteSigner)
+	if !ok {
+		return SuiteEd25519, nil
+	}
+	if suite := ss.Suite(); suite != SuiteEd25519 {
+		return "", &UnknownSuiteError{Suite: suite}
+	}
+	return SuiteEd25519, nil
+}
+
+// verifySignature checks sig of msg by pub with suite
+func verifySignature(suite SignatureSuite, pub ed25519.PublicKey, msg, sig []byte) error {
+	switch suite {
+	case SuiteEd25519:
+		if !ed25519.Verify(pub, msg, sig) {
+			return ErrInvalidSignature
+		}
+		return nil
+	default:
+		return &UnknownSuiteError{Suite: suite}
+	}
+}
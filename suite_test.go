@@ -0,0 +1,77 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// futureSigner claims a suite this version doesn't know
+type futureSigner struct {
+	Signer
+	suite SignatureSuite
+}
+
+func (fs futureSigner) Suite() SignatureSuite { return fs.suite }
+
+func TestSignatureSuite(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	author, trs := makeTestFeed(t, "suit", 1)
+
+	suite, err := SuiteOf(author)
+	r.NoError(err)
+	a.Equal(SuiteEd25519, suite)
+	suite, err = trs[0].Suite()
+	r.NoError(err)
+	a.Equal(SuiteEd25519, suite)
+
+	suite, err = ParseSignatureSuite("ed25519")
+	r.NoError(err)
+	a.Equal(SuiteEd25519, suite)
+
+	var unknown *UnknownSuiteError
+	_, err = ParseSignatureSuite("dilithium3")
+	r.True(errors.As(err, &unknown))
+	a.Equal(SignatureSuite("dilithium3"), unknown.Suite)
+
+	legacy, err := refs.NewFeedRefFromBytes(author.PubKey(), refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+	_, err = SuiteOf(legacy)
+	a.True(errors.As(err, &unknown))
+	_, err = NewVerifyKey(legacy)
+	a.True(errors.As(err, &unknown))
+
+	vk, err := NewVerifyKey(author)
+	r.NoError(err)
+	a.Equal(SuiteEd25519, vk.Suite())
+
+	a.Equal(&UnknownSuiteError{Suite: "hybrid"}, verifySignature("hybrid", author.PubKey(), trs[0].Event, trs[0].Signature))
+}
+
+func TestEncoderSuiteSigner(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("suit"), 8)))
+
+	e := NewSignerEncoder(futureSigner{Signer: privateKeySigner(privKey), suite: SuiteEd25519})
+	tr, _, err := e.Encode(1, BinaryRef{}, []byte("ok"))
+	r.NoError(err)
+	a.NoError(tr.VerifyAll(nil))
+
+	e = NewSignerEncoder(futureSigner{Signer: privateKeySigner(privKey), suite: "ed25519+dilithium3"})
+	_, _, err = e.Encode(1, BinaryRef{}, []byte("no"))
+	var unknown *UnknownSuiteError
+	r.True(errors.As(err, &unknown), "%v", err)
+	a.Equal(SignatureSuite("ed25519+dilithium3"), unknown.Suite)
+}
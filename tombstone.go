@@ -0,0 +1,86 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// TombstoneType is the content type of a published Tombstone
+const TombstoneType = "gabbygrove/tombstone"
+
+// maxTombstoneReasonLength bounds the reason of a tombstone in bytes
+const maxTombstoneReasonLength = 512
+
+// ErrFeedTerminated is returned for messages after the tombstone of a feed
+var ErrFeedTerminated = errors.New("gabbygrove: feed is terminated")
+
+// Tombstone is the conventional last message of a feed that its owner ended on purpose.
+// Unlike a Revocation, it vouches for all the messages before it.
+// Without one, a feed that doesn't grow anymore can't be told apart from one whose author went offline.
+type Tombstone struct {
+	Type string `json:"type"`
+
+	Reason string `json:"reason,omitempty"`
+}
+
+// NewTombstone returns a tombstone with an optional reason
+func NewTombstone(reason string) *Tombstone {
+	var ts Tombstone
+	ts.Type = TombstoneType
+	ts.Reason = reason
+	return &ts
+}
+
+// Validate checks that the tombstone follows the conventional layout
+func (ts Tombstone) Validate() error {
+	if ts.Type != TombstoneType {
+		return errors.Errorf("tombstone: wrong type: %q", ts.Type)
+	}
+	if n := len(ts.Reason); n > maxTombstoneReasonLength {
+		return errors.Errorf("tombstone: reason too long (%d bytes)", n)
+	}
+	return nil
+}
+
+// EncodeTombstone encodes ts as the final message sequence of the feed of e
+func (e *Encoder) EncodeTombstone(sequence uint64, prev BinaryRef, ts Tombstone) (*Transfer, refs.MessageRef, error) {
+	if err := ts.Validate(); err != nil {
+		return nil, refs.MessageRef{}, err
+	}
+	return e.Encode(sequence, prev, ts)
+}
+
+// ParseTombstone validates tr as a tombstone and returns it.
+// The transfer needs to carry the matching JSON content.
+// The signature isn't checked, a tombstone only ends a feed once FeedState.Append accepted it.
+func ParseTombstone(tr *Transfer) (*Tombstone, error) {
+	evt, err := tr.getEvent()
+	if err != nil {
+		return nil, errors.Wrap(err, "tombstone: invalid event")
+	}
+	if evt.Content.Type != ContentTypeJSON {
+		return nil, errors.Errorf("tombstone: content is not JSON")
+	}
+
+	if len(tr.Content) == 0 {
+		return nil, errors.Errorf("tombstone: content missing")
+	}
+	if !tr.ContentMatches(tr.Content) {
+		return nil, errors.Errorf("tombstone: content does not match the event")
+	}
+
+	var ts Tombstone
+	if err := json.Unmarshal(tr.Content, &ts); err != nil {
+		return nil, errors.Wrap(err, "tombstone: invalid JSON")
+	}
+	if err := ts.Validate(); err != nil {
+		return nil, err
+	}
+	return &ts, nil
+}
@@ -0,0 +1,153 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+func TestTombstone(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	author, trs := makeTestFeed(t, "dead", 2)
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	e := NewEncoder(privKey)
+
+	_, _, err := e.EncodeTombstone(3, BinaryRef{}, Tombstone{Type: "gabbygrove/end"})
+	a.Error(err, "wrong type")
+
+	state := NewFeedState(author)
+	for _, tr := range trs {
+		r.NoError(state.Append(tr))
+	}
+	seq, prev := state.Next()
+	ts, _, err := e.EncodeTombstone(seq, prev, *NewTombstone("moved on"))
+	r.NoError(err)
+	parsed, err := ParseTombstone(ts)
+	r.NoError(err)
+	a.Equal("moved on", parsed.Reason)
+	_, err = ParseTombstone(trs[0])
+	a.Error(err)
+
+	// the content of a tombstone needs to match its event
+	swapped := *ts
+	swapped.Content = []byte(`{"type":"gabbygrove/tombstone"}`)
+	a.Error(state.Append(&swapped))
+	a.False(state.Terminated)
+
+	r.NoError(state.Append(ts))
+	a.True(state.Terminated)
+
+	seq, prev = state.Next()
+	after, _, err := e.Encode(seq, prev, "still here")
+	r.NoError(err)
+	a.Equal(ErrFeedTerminated, errors.Cause(state.Append(after)))
+	a.EqualValues(3, state.Sequence)
+
+	// the end of the feed survives a snapshot
+	data, err := state.MarshalBinary()
+	r.NoError(err)
+	var restored FeedState
+	r.NoError(restored.UnmarshalBinary(data))
+	a.True(restored.Terminated)
+	a.Nil(restored.Revoked)
+	a.Equal(ErrFeedTerminated, errors.Cause(restored.Append(after)))
+
+	// a validator with a content policy still sees it
+	v := NewValidator(4)
+	v.WithContentPolicy(&ContentPolicy{None: true})
+	for _, tr := range append(trs, ts) {
+		_, err := v.Append(tr)
+		r.NoError(err)
+	}
+	a.True(v.Tip(author).Terminated)
+	_, err = v.Append(after)
+	a.Equal(ErrFeedTerminated, errors.Cause(err))
+}
+
+func TestOutboxTerminated(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	pubKey, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	author, err := refs.NewFeedRefFromBytes(pubKey, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+
+	ob := NewOutbox(NewEncoder(privKey), NewFeedState(author), NewMemOutboxStore())
+	_, _, err = ob.Publish("one")
+	r.NoError(err)
+	_, _, err = ob.Publish(NewTombstone(""))
+	r.NoError(err)
+	a.True(ob.State().Terminated)
+
+	var signed int
+	ob.enc.WithSigningAuditHook(func(uint64, *refs.MessageRef, ContentRef, refs.MessageRef) error {
+		signed++
+		return nil
+	})
+	_, _, err = ob.Publish("two")
+	a.Equal(ErrFeedTerminated, errors.Cause(err))
+	a.Zero(signed, "signed a message after the tombstone")
+}
+
+func TestOutboxTerminatedReopen(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	pubKey, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	author, err := refs.NewFeedRefFromBytes(pubKey, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+
+	for name, ss := range map[string]SequenceStore{
+		"mem":  NewMemSequenceStore(),
+		"file": NewFileSequenceStore(filepath.Join(dir, "tip.json")),
+	} {
+		ob, err := OpenOutbox(NewEncoder(privKey), author, ss, NewMemOutboxStore())
+		r.NoError(err, name)
+		_, _, err = ob.Publish("one")
+		r.NoError(err, name)
+		_, tsKey, err := ob.Publish(NewTombstone("done"))
+		r.NoError(err, name)
+
+		// the end of the feed is committed with its tip
+		ob, err = OpenOutbox(NewEncoder(privKey), author, ss, NewMemOutboxStore())
+		r.NoError(err, name)
+		state := ob.State()
+		a.True(state.Terminated, name)
+		a.EqualValues(2, state.Sequence, name)
+		a.Equal(tsKey, *state.Tip, name)
+		_, _, err = ob.Publish("two")
+		a.Equal(ErrFeedTerminated, errors.Cause(err), name)
+
+		// an encoder with only the sequence store refuses to sign as well
+		e := NewEncoder(privKey)
+		e.WithSequenceStore(ss)
+		prev, err := fromRef(tsKey)
+		r.NoError(err)
+		_, _, err = e.Encode(3, prev, "two")
+		a.Equal(ErrFeedTerminated, errors.Cause(err), name)
+
+		// plain commits keep the end
+		r.NoError(ss.Commit(2, tsKey))
+		loaded, err := LoadFeedState(author, ss)
+		r.NoError(err)
+		a.True(loaded.Terminated, name)
+		a.Nil(loaded.Revoked, name)
+	}
+}
@@ -0,0 +1,119 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"context"
+	"sync/atomic"
+
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// Tracer starts spans around encoding, verification and batch validation.
+// It is modeled after the OpenTelemetry trace API, so an adapter is a few lines:
+// Start wraps otel's Tracer.Start, converting the attributes with attribute.String/Int64,
+// and the returned Span forwards to RecordError/SetStatus and End.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...TraceAttribute) (context.Context, Span)
+}
+
+// Span is a single traced operation
+type Span interface {
+	// SetError marks the operation as failed
+	SetError(err error)
+	End()
+}
+
+// TraceAttribute is a key-value pair attached to a span, the value is either a string or an int64
+type TraceAttribute struct {
+	Key   string
+	Value interface{}
+}
+
+type tracerHolder struct{ Tracer }
+
+var pkgTracer atomic.Value
+
+// SetTracer installs t for all operations of this package. By default (or when passing nil) tracing is a no-op.
+func SetTracer(t Tracer) {
+	pkgTracer.Store(tracerHolder{t})
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetError(error) {}
+func (nopSpan) End()           {}
+
+// tracingEnabled is true if a tracer is installed, so span attributes are only computed when they are used
+func tracingEnabled() bool {
+	h, ok := pkgTracer.Load().(tracerHolder)
+	return ok && h.Tracer != nil
+}
+
+func startSpan(ctx context.Context, name string, attrs ...TraceAttribute) (context.Context, Span) {
+	h, ok := pkgTracer.Load().(tracerHolder)
+	if !ok || h.Tracer == nil {
+		return ctx, nopSpan{}
+	}
+	return h.Start(ctx, name, attrs...)
+}
+
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.SetError(err)
+	}
+	span.End()
+}
+
+// EncodeContext is Encode with a span named "gabbygrove.Encode" as a child of ctx
+func (e *Encoder) EncodeContext(ctx context.Context, sequence uint64, prev BinaryRef, val interface{}) (*Transfer, refs.MessageRef, error) {
+	if !tracingEnabled() {
+		return e.Encode(sequence, prev, val)
+	}
+	author, _ := refs.NewFeedRefFromBytes(e.signer.Public(), refs.RefAlgoFeedGabby)
+	_, span := startSpan(ctx, "gabbygrove.Encode",
+		TraceAttribute{"author", author.URI()},
+		TraceAttribute{"seq", int64(sequence)},
+	)
+	tr, key, err := e.Encode(sequence, prev, val)
+	endSpan(span, err)
+	return tr, key, err
+}
+
+// VerifyContext is Verify with a span named "gabbygrove.Verify" as a child of ctx
+func (tr *Transfer) VerifyContext(ctx context.Context, hmacKey *[32]byte) bool {
+	if !tracingEnabled() {
+		return tr.Verify(hmacKey)
+	}
+	attrs := []TraceAttribute{{"msg", tr.Key().URI()}}
+	if evt, err := tr.getEvent(); err == nil {
+		attrs = append(attrs,
+			TraceAttribute{"author", evt.Author.URI()},
+			TraceAttribute{"seq", int64(evt.Sequence)},
+		)
+	}
+	_, span := startSpan(ctx, "gabbygrove.Verify", attrs...)
+	err := tr.verifyKey(nil, hmacKey, false)
+	if err != nil {
+		span.SetError(err)
+	}
+	span.End()
+	return err == nil
+}
+
+// ValidateFeedContext is ValidateFeed with a span named "gabbygrove.ValidateFeed" as a child of ctx
+func ValidateFeedContext(ctx context.Context, state *FeedState, trs []*Transfer, report *VerificationReport) error {
+	if !tracingEnabled() {
+		return ValidateFeed(state, trs, report)
+	}
+	_, span := startSpan(ctx, "gabbygrove.ValidateFeed",
+		TraceAttribute{"author", state.Author.URI()},
+		TraceAttribute{"seq", int64(state.Sequence)},
+		TraceAttribute{"count", int64(len(trs))},
+	)
+	err := ValidateFeed(state, trs, report)
+	endSpan(span, err)
+	return err
+}
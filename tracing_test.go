@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordedSpan struct {
+	name  string
+	attrs map[string]interface{}
+	err   error
+	ended bool
+}
+
+type recordingTracer struct{ spans []*recordedSpan }
+
+func (rt *recordingTracer) Start(ctx context.Context, name string, attrs ...TraceAttribute) (context.Context, Span) {
+	s := &recordedSpan{name: name, attrs: make(map[string]interface{})}
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+	rt.spans = append(rt.spans, s)
+	return ctx, s
+}
+
+func (s *recordedSpan) SetError(err error) { s.err = err }
+func (s *recordedSpan) End()               { s.ended = true }
+
+func TestTracing(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	var rt recordingTracer
+	SetTracer(&rt)
+	defer SetTracer(nil)
+
+	ctx := context.Background()
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	e := NewEncoder(privKey)
+	tr, _, err := e.EncodeContext(ctx, 1, BinaryRef{}, "hello")
+	r.NoError(err)
+	a.True(tr.VerifyContext(ctx, nil))
+
+	state := NewFeedState(tr.Author())
+	r.NoError(ValidateFeedContext(ctx, state, []*Transfer{tr}, nil))
+	r.Error(ValidateFeedContext(ctx, state, []*Transfer{tr}, nil))
+
+	r.Len(rt.spans, 4)
+	a.Equal("gabbygrove.Encode", rt.spans[0].name)
+	a.Equal(tr.Author().URI(), rt.spans[0].attrs["author"])
+	a.EqualValues(1, rt.spans[0].attrs["seq"])
+	a.Equal("gabbygrove.Verify", rt.spans[1].name)
+	a.Equal("gabbygrove.ValidateFeed", rt.spans[2].name)
+	a.NoError(rt.spans[2].err)
+	a.Error(rt.spans[3].err)
+	for _, s := range rt.spans {
+		a.True(s.ended, s.name)
+	}
+}
+
+func TestTracingDisabled(t *testing.T) {
+	a := assert.New(t)
+
+	SetTracer(nil)
+	_, trs := makeTestFeed(t, "dead", 1)
+	ctx := context.Background()
+
+	// without a tracer, no span attributes like the key are computed
+	plain := testing.AllocsPerRun(10, func() { trs[0].Verify(nil) })
+	traced := testing.AllocsPerRun(10, func() { trs[0].VerifyContext(ctx, nil) })
+	a.Equal(plain, traced)
+}
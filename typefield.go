@@ -0,0 +1,141 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNoTypeField is returned by MessageType for content without a "type" field, i.e. arbitrary bytes.
+var ErrNoTypeField = errors.New("gabbygrove: content has no type field")
+
+// MessageType returns the conventional "type" field of JSON or CBOR content, like contact, post or vote.
+// Only the top-level keys up to the type are read, the rest of the content isn't decoded.
+// It fails with ErrNoContent if the content isn't attached and ErrNoTypeField if there is none.
+func (tr *Transfer) MessageType() (string, error) {
+	evt, err := tr.getEvent()
+	if err != nil {
+		return "", err
+	}
+	if len(tr.Content) == 0 {
+		return "", ErrNoContent
+	}
+	switch evt.Content.Type {
+	case ContentTypeJSON:
+		return jsonTypeField(tr.Content)
+	case ContentTypeCBOR:
+		return cborTypeField(tr.Content)
+	default:
+		return "", ErrNoTypeField
+	}
+}
+
+func jsonTypeField(data []byte) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return "", ErrNoTypeField
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return "", errors.Wrap(err, "gabbygrove: invalid json content")
+		}
+		if key == "type" {
+			tok, err := dec.Token()
+			if err != nil {
+				return "", errors.Wrap(err, "gabbygrove: invalid json content")
+			}
+			t, ok := tok.(string)
+			if !ok {
+				return "", errors.Wrap(ErrNoTypeField, "type is not a string")
+			}
+			return t, nil
+		}
+		if err := skipJSONValue(dec); err != nil {
+			return "", errors.Wrap(err, "gabbygrove: invalid json content")
+		}
+	}
+	return "", ErrNoTypeField
+}
+
+// skipJSONValue reads the next value from dec without keeping it
+func skipJSONValue(dec *json.Decoder) error {
+	var depth int
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+func cborTypeField(data []byte) (string, error) {
+	p := cborParser{data: data}
+	n, err := p.expect(cborMajorMap)
+	if err != nil {
+		return "", ErrNoTypeField
+	}
+	for i := uint64(0); i < n; i++ {
+		if p.off < len(p.data) && p.data[p.off]>>5 == cborMajorText {
+			key, err := p.text()
+			if err != nil {
+				return "", errors.Wrap(err, "gabbygrove: invalid cbor content")
+			}
+			if key == "type" {
+				t, err := p.text()
+				if err != nil {
+					return "", errors.Wrap(ErrNoTypeField, "type is not a string")
+				}
+				return t, nil
+			}
+		} else if err := p.skip(0); err != nil {
+			return "", errors.Wrap(err, "gabbygrove: invalid cbor content")
+		}
+		if err := p.skip(0); err != nil {
+			return "", errors.Wrap(err, "gabbygrove: invalid cbor content")
+		}
+	}
+	return "", ErrNoTypeField
+}
+
+// FilterByType returns the transfers of s with one of the message types, see MessageType.
+// Transfers without content or a type are left out.
+func FilterByType(s TransferStream, types ...string) TransferStream {
+	want := make(map[string]struct{}, len(types))
+	for _, t := range types {
+		want[t] = struct{}{}
+	}
+	return TransferStreamFunc(func() (*Transfer, error) {
+		for {
+			tr, err := s.Next()
+			if err != nil {
+				return nil, err
+			}
+			t, err := tr.MessageType()
+			if err != nil {
+				continue
+			}
+			if _, ok := want[t]; ok {
+				return tr, nil
+			}
+		}
+	})
+}
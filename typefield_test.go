@@ -0,0 +1,115 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageType(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	enc := NewEncoder(privKey)
+
+	encodeCBOR := func(content []byte) *Transfer {
+		ref, err := HashContent(content)
+		r.NoError(err)
+		tr, _, err := enc.EncodeWithContentHash(1, BinaryRef{}, ContentTypeCBOR, ref, len(content))
+		r.NoError(err)
+		tr.Content = content
+		return tr
+	}
+	encodeJSON := func(content string) *Transfer {
+		tr, _, err := enc.Encode(1, BinaryRef{}, map[string]interface{}{})
+		r.NoError(err)
+		// swapped in to keep the order of the keys
+		tr.Content = []byte(content)
+		return tr
+	}
+
+	cborMap := appendCBORHead(nil, cborMajorMap, 3)
+	cborMap = appendCBORInt(cborMap, 1)
+	cborMap = appendCBORText(cborMap, "numeric key")
+	cborMap = appendCBORText(cborMap, "nested")
+	cborMap = append(appendCBORHead(cborMap, cborMajorArray, 1), appendCBORText(nil, "type")...)
+	cborMap = appendCBORText(cborMap, "type")
+	cborMap = appendCBORText(cborMap, "vote")
+
+	cases := []struct {
+		tr   *Transfer
+		want string
+		err  error
+	}{
+		{encodeJSON(`{"type":"post","text":"hello"}`), "post", nil},
+		{encodeJSON(`{"nested":{"type":"nope","list":[1,{"type":"nope"}]},"type":"contact"}`), "contact", nil},
+		{encodeJSON(`{"text":"hello"}`), "", ErrNoTypeField},
+		{encodeJSON(`{"type":23}`), "", ErrNoTypeField},
+		{encodeJSON(`["type","post"]`), "", ErrNoTypeField},
+		{encodeCBOR(cborMap), "vote", nil},
+		{encodeCBOR(appendCBORText(nil, "type")), "", ErrNoTypeField},
+	}
+	for i, tc := range cases {
+		got, err := tc.tr.MessageType()
+		if tc.err != nil {
+			a.Equal(tc.err, errors.Cause(err), "case %d: %v", i, err)
+			continue
+		}
+		r.NoError(err, "case %d", i)
+		a.Equal(tc.want, got, "case %d", i)
+	}
+
+	_, err := encodeJSON(`{"text":"unterminated`).MessageType()
+	a.Error(err)
+
+	arbitrary, _, err := enc.Encode(1, BinaryRef{}, []byte(`{"type":"post"}`))
+	r.NoError(err)
+	_, err = arbitrary.MessageType()
+	a.Equal(ErrNoTypeField, err)
+
+	arbitrary.Content = nil
+	_, err = arbitrary.MessageType()
+	a.Equal(ErrNoContent, err)
+}
+
+func TestFilterByType(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, privKey := generatePrivateKey(t, bytes.NewReader(bytes.Repeat([]byte("beef"), 8)))
+	enc := NewEncoder(privKey)
+
+	var (
+		trs  []*Transfer
+		prev BinaryRef
+	)
+	for i, val := range []interface{}{
+		map[string]interface{}{"type": "post", "text": "hi"},
+		map[string]interface{}{"type": "contact", "following": true},
+		[]byte("arbitrary"),
+		map[string]interface{}{"type": "vote"},
+		map[string]interface{}{"type": "post", "text": "again"},
+	} {
+		tr, key, err := enc.Encode(uint64(i+1), prev, val)
+		r.NoError(err)
+		prev, err = fromRef(key)
+		r.NoError(err)
+		trs = append(trs, tr)
+	}
+
+	got := drain(t, FilterByType(SliceStream(trs), "post", "vote"))
+	r.Len(got, 3)
+	a.EqualValues(1, got[0].Seq())
+	a.EqualValues(4, got[1].Seq())
+	a.EqualValues(5, got[2].Seq())
+
+	a.Len(drain(t, FilterByType(SliceStream(trs))), 0)
+}
@@ -9,8 +9,6 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io"
-	"log"
 	"math"
 	"net/url"
 	"time"
@@ -18,11 +16,9 @@ import (
 	"go.mindeco.de/encodedTime"
 
 	"github.com/pkg/errors"
-	"github.com/ugorji/go/codec"
 	refs "go.mindeco.de/ssb-refs"
 	ssb "go.mindeco.de/ssb-refs"
 	"golang.org/x/crypto/ed25519"
-	"golang.org/x/crypto/nacl/auth"
 )
 
 type Event struct {
@@ -31,26 +27,98 @@ type Event struct {
 	Sequence  uint64
 	Timestamp int64
 	Content   Content
+
+	// Extensions are optional, see the type for details
+	Extensions Extensions `codec:"-"`
+
+	unknown [][]byte
 }
 
 // 1 byte to frame the array
 // 5 additional bytes for framing of a binRef
 // 1 additional byte to frame a (u)int64
-const maxEventSize = 1 + 2*(33+5) + 2*(8+1) + maxContentSize
+const maxEventSize = 1 + 2*(33+5) + 2*(8+1) + maxContentInfoSize + maxExtensionsSize
 
 func (evt Event) MarshalCBOR() ([]byte, error) {
-	var evtBuf bytes.Buffer
-	enc := codec.NewEncoder(&evtBuf, GetCBORHandle())
-	if err := enc.Encode(evt); err != nil {
+	b, err := evt.appendCBOR(nil)
+	if err != nil {
 		return nil, errors.Wrap(err, "gabbyGrove/Event: failed to encode to cbor")
 	}
-	return evtBuf.Bytes(), nil
+	return b, nil
 }
 
+// UnmarshalCBOR decodes a single event, unknown fields are kept, see Event.UnknownFields.
 func (evt *Event) UnmarshalCBOR(data []byte) error {
-	r := bytes.NewReader(data)
-	evtDec := codec.NewDecoder(io.LimitReader(r, maxEventSize), GetCBORHandle())
-	return errors.Wrapf(evtDec.Decode(evt), "gabbyGrove/Event: failed to decode")
+	return evt.decode(data, decodeOptions{})
+}
+
+func (evt *Event) decode(data []byte, opts decodeOptions) error {
+	if len(data) > maxEventSize {
+		return errors.Errorf("gabbyGrove/Event: too large (%d bytes)", len(data))
+	}
+	var newEvt Event
+	n, err := newEvt.parseCBOR(data, opts)
+	if err != nil {
+		return errors.Wrapf(err, "gabbyGrove/Event: failed to decode")
+	}
+	if rest := len(data) - n; rest != 0 {
+		return errors.Errorf("gabbyGrove/Event: %d trailing bytes after event", rest)
+	}
+	*evt = newEvt
+	return nil
+}
+
+// PreviousMessageRef returns the message this event points back to, false for the first one of a feed
+// or if Previous doesn't hold a message reference.
+func (evt Event) PreviousMessageRef() (refs.MessageRef, bool) {
+	if evt.Previous == nil {
+		return refs.MessageRef{}, false
+	}
+	mr, ok := evt.Previous.r.(refs.MessageRef)
+	return mr, ok
+}
+
+// AuthorFeedRef returns the feed of the author.
+// It's the zero value if Author doesn't hold a feed reference, which Verify rejects.
+func (evt Event) AuthorFeedRef() refs.FeedRef {
+	fr, _ := evt.Author.r.(refs.FeedRef)
+	return fr
+}
+
+// ContentRef returns the hash of the content as a message reference with the RefAlgoContentGabby algorithm.
+// It's the zero value if the hash doesn't hold a content reference.
+func (evt Event) ContentRef() refs.MessageRef {
+	cr, ok := evt.Content.Hash.r.(ContentRef)
+	if !ok {
+		return refs.MessageRef{}
+	}
+	mr, err := refs.NewMessageRefFromBytes(cr.hash[:], cr.algo)
+	if err != nil {
+		return refs.MessageRef{}
+	}
+	return mr
+}
+
+// clone returns a copy of evt that shares no memory with it
+func (evt Event) clone() Event {
+	cpy := evt
+	if evt.Previous != nil {
+		prev := *evt.Previous
+		cpy.Previous = &prev
+	}
+	if evt.Extensions != nil {
+		cpy.Extensions = make(Extensions, len(evt.Extensions))
+		for k, v := range evt.Extensions {
+			cpy.Extensions[k] = append([]byte(nil), v...)
+		}
+	}
+	if evt.unknown != nil {
+		cpy.unknown = make([][]byte, len(evt.unknown))
+		for i, u := range evt.unknown {
+			cpy.unknown[i] = append([]byte(nil), u...)
+		}
+	}
+	return cpy
 }
 
 type ContentType uint
@@ -71,7 +139,7 @@ type Content struct {
 // 1 byte for a valid type
 // 2 byte for the size
 // 5 additional bytes for framing a binRef
-const maxContentSize = 1 + 1 + 2 + (33 + 5)
+const maxContentInfoSize = 1 + 1 + 2 + (33 + 5)
 
 type Transfer struct {
 	Event   []byte
@@ -79,6 +147,21 @@ type Transfer struct {
 
 	Signature []byte
 	Content   []byte
+
+	unknown       [][]byte
+	rejectUnknown bool
+
+	// contentPath is where WriteContentTo reads the content from, see EncodeFromFile
+	contentPath string
+
+	// lazyContent is set by loadContent and ContentJSON
+	lazyContent *contentCache
+
+	// received is the local arrival time, it's not signed or part of the encoding, see MarshalReceived
+	received time.Time
+
+	// afterRevocation is set by a Validator with RevocationFlag, see AfterRevocation
+	afterRevocation bool
 }
 
 // 1 byte to frame the array
@@ -87,31 +170,77 @@ type Transfer struct {
 const maxTransferSize = 1 + (2 + maxEventSize) + (2 + ed25519.SignatureSize) + (3 + math.MaxUint16)
 
 func (tr Transfer) MarshalCBOR() ([]byte, error) {
-	var evtBuf bytes.Buffer
-	enc := codec.NewEncoder(&evtBuf, GetCBORHandle())
-	if err := enc.Encode(tr); err != nil {
-		return nil, errors.Wrap(err, "failed to encode transfer")
+	return tr.appendCBOR(nil), nil
+}
+
+// UnmarshalCBOR decodes a single transfer and fails if data holds more then that.
+// Use DecodeFirst to decode a transfer from the start of a buffer.
+// Unknown fields are kept, see Decoder to reject them instead.
+func (tr *Transfer) UnmarshalCBOR(data []byte) (err error) {
+	defer func() { countMetric(MetricDecode, err != nil, len(data)) }()
+
+	n, err := tr.decodeFrom(data, decodeOptions{})
+	if err != nil {
+		return err
+	}
+	if rest := len(data) - n; rest != 0 {
+		return errors.Errorf("gabbygrove/transfer: %d trailing bytes after transfer", rest)
 	}
-	return evtBuf.Bytes(), nil
+	return nil
 }
 
-func (tr *Transfer) UnmarshalCBOR(data []byte) error {
-	r := io.LimitReader(bytes.NewReader(data), maxTransferSize)
-	evtDec := codec.NewDecoder(r, GetCBORHandle())
-	if err := evtDec.Decode(tr); err != nil {
-		return errors.Wrap(err, "failed to decode transfer object")
+// DecodeFirst decodes the transfer at the start of data and returns the bytes following it
+func DecodeFirst(data []byte) (*Transfer, []byte, error) {
+	var tr Transfer
+	n, err := tr.decodeFrom(data, decodeOptions{})
+	countMetric(MetricDecode, err != nil, n)
+	if err != nil {
+		return nil, data, err
 	}
-	// check sizes
-	if len(tr.Content) > math.MaxUint16 {
-		return errors.Errorf("gabbygrove/transfer: content too large")
+	return &tr, data[n:], nil
+}
+
+// decodeFrom decodes one transfer from the start of data and returns the number of bytes it used
+func (tr *Transfer) decodeFrom(data []byte, opts decodeOptions) (int, error) {
+	if len(data) > maxTransferSize {
+		data = data[:maxTransferSize]
 	}
-	if len(tr.Signature) != ed25519.SignatureSize {
-		return errors.Errorf("gabbygrove/transfer: wrong signature size")
+	var newTr Transfer
+	n, err := newTr.parseCBOR(data, opts)
+	if err != nil {
+		debugLog("event", "decode", "bytes", len(data), "err", err)
+		return 0, errors.Wrap(err, "failed to decode transfer object")
 	}
-	if len(tr.Event) > maxEventSize {
-		return errors.Errorf("gabbygrove/transfer: event too large")
+	if err := newTr.checkSizes(DefaultLimits); err != nil {
+		return 0, err
 	}
-	return nil
+
+	// don't keep data alive or let changes to it leak into the transfer
+	if err := opts.budget.take(len(newTr.Event) + len(newTr.Signature) + len(newTr.Content)); err != nil {
+		return 0, err
+	}
+	fields := make([]byte, 0, len(newTr.Event)+len(newTr.Signature)+len(newTr.Content))
+	for _, f := range []*[]byte{&newTr.Event, &newTr.Signature, &newTr.Content} {
+		if *f == nil {
+			continue
+		}
+		start := len(fields)
+		fields = append(fields, *f...)
+		*f = fields[start:len(fields):len(fields)]
+	}
+	*tr = newTr
+	return n, nil
+}
+
+// DecodedEvent returns the decoded event of tr.
+// It's decoded once and cached, every call returns a copy that can be changed without affecting tr.
+func (tr *Transfer) DecodedEvent() (*Event, error) {
+	evt, err := tr.getEvent()
+	if err != nil {
+		return nil, err
+	}
+	cpy := evt.clone()
+	return &cpy, nil
 }
 
 func (tr *Transfer) UnmarshaledEvent() (*Event, error) {
@@ -123,7 +252,7 @@ func (tr *Transfer) getEvent() (*Event, error) {
 		return tr.lazyEvt, nil
 	}
 	var evt Event
-	err := evt.UnmarshalCBOR(tr.Event)
+	err := evt.decode(tr.Event, decodeOptions{rejectUnknown: tr.rejectUnknown})
 	if err != nil {
 		return nil, err
 	}
@@ -131,36 +260,14 @@ func (tr *Transfer) getEvent() (*Event, error) {
 	return &evt, nil
 }
 
-// Verify returns true if the Message was signed by the author specified by the meta portion of the message
-func (tr *Transfer) Verify(hmacKey *[32]byte) bool {
-	evt, err := tr.getEvent()
-	if err != nil {
-		log.Println("gabbygrove/verify event decoding failed:", err)
-		return false
-	}
-	aref, err := evt.Author.GetRef(RefTypeFeed)
-	if err != nil {
-		log.Println("gabbygrove/verify getRef failed:", err)
-		return false
-	}
-
-	pubKey := aref.(refs.FeedRef).PubKey()
-
-	toVerify := tr.Event
-	if hmacKey != nil {
-		mac := auth.Sum(tr.Event, hmacKey)
-		toVerify = mac[:]
-	}
-
-	return ed25519.Verify(pubKey, toVerify, tr.Signature)
-}
-
 var _ refs.Message = (*Transfer)(nil)
 
 func (tr *Transfer) Seq() int64 {
 	evt, err := tr.getEvent()
 	if err != nil {
-		log.Println("gabbygrove/verify event decoding failed:", err)
+		if debugEnabled() {
+			debugLog("event", "seq", "msg", tr.Key().URI(), "err", err)
+		}
 		return -1
 	}
 	return int64(evt.Sequence)
@@ -194,8 +301,14 @@ func (tr *Transfer) Previous() *refs.MessageRef {
 	return &prevKey
 }
 
+// Received returns when tr arrived if that was set (see SetReceived) and the claimed time otherwise.
 func (tr *Transfer) Received() time.Time {
-	log.Println("received time is spoofed to claimed")
+	if rcv, has := tr.ReceivedAt(); has {
+		return rcv
+	}
+	if debugEnabled() {
+		debugLog("event", "received", "msg", tr.Key().URI(), "note", "received time is spoofed to claimed")
+	}
 	return tr.Claimed()
 }
 
@@ -207,8 +320,23 @@ func (tr *Transfer) Claimed() time.Time {
 	return time.Unix(int64(evt.Timestamp), 0)
 }
 
+// ContentBytes returns the content of the transfer or nil if it isn't attached.
+// For transfers made by EncodeFromFile, the file is read and checked against the event on the first call.
+// The returned slice belongs to the transfer and must not be modified.
 func (tr *Transfer) ContentBytes() []byte {
-	return tr.Content
+	data, err := tr.loadContent()
+	if err != nil && err != ErrNoContent {
+		if debugEnabled() {
+			debugLog("event", "content", "msg", tr.Key().URI(), "err", err)
+		}
+	}
+	return data
+}
+
+// HasContent returns false if the content was left out of the transfer.
+// Empty content of size zero is still content.
+func (tr *Transfer) HasContent() bool {
+	return tr.Content != nil
 }
 
 // ValueContent returns a ssb.Value that can be represented as JSON.
@@ -313,6 +441,24 @@ func (ref ContentRef) MarshalText() ([]byte, error) {
 	return []byte(ref.URI()), nil
 }
 
+// UnmarshalText parses the URI form written by MarshalText
+func (ref *ContentRef) UnmarshalText(text []byte) error {
+	const prefix = "ssb:content/gabbygrove-v1/"
+	if !bytes.HasPrefix(text, []byte(prefix)) {
+		return errors.Errorf("contentRef: not a content URI: %q", text)
+	}
+	raw, err := base64.URLEncoding.DecodeString(string(text[len(prefix):]))
+	if err != nil {
+		return errors.Wrap(err, "contentRef: invalid base64")
+	}
+	newRef, err := NewContentRefFromBytes(raw)
+	if err != nil {
+		return err
+	}
+	*ref = newRef
+	return nil
+}
+
 func (ref ContentRef) MarshalBinary() ([]byte, error) {
 	switch ref.algo {
 	case RefAlgoContentGabby:
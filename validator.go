@@ -0,0 +1,297 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+const validatorShards = 64
+
+// Validator tracks the states of many feeds at once and can be used concurrently.
+// The feeds are spread over sharded maps and each feed has its own lock,
+// so messages of different authors are validated in parallel.
+// Messages that arrive out of order are held in a FeedBuffer per author.
+type Validator struct {
+	shards [validatorShards]validatorShard
+
+	bufferLimit int
+	hmacKey     *[32]byte
+	limiter     Limiter
+	filter      AuthorFilter
+	policy      *ContentPolicy
+	jsonPolicy  *JSONPolicy
+	revocation  RevocationPolicy
+
+	hashContent bool
+	quarantine  *Quarantine
+
+	subs Broadcaster
+}
+
+type validatorShard struct {
+	mu    sync.Mutex
+	feeds map[string]*validatorFeed
+}
+
+type validatorFeed struct {
+	mu  sync.Mutex
+	buf *FeedBuffer
+}
+
+// NewValidator returns a validator that buffers up to bufferLimit early messages per author
+func NewValidator(bufferLimit int) *Validator {
+	v := &Validator{bufferLimit: bufferLimit, jsonPolicy: defaultJSONPolicy}
+	for i := range v.shards {
+		v.shards[i].feeds = make(map[string]*validatorFeed)
+	}
+	return v
+}
+
+// WithHMAC sets the key the messages of all feeds are signed with.
+// Each feed keeps the key it was first seen with, so it's set before any message is appended.
+func (v *Validator) WithHMAC(in []byte) error {
+	var k [32]byte
+	n := copy(k[:], in)
+	if n != 32 {
+		return errors.Errorf("hmac key to short: %d", n)
+	}
+	v.hmacKey = &k
+	return nil
+}
+
+// Track continues the feed of state.Author from state, i.e. the tip of a store after a restart.
+// Messages buffered for that author are dropped.
+func (v *Validator) Track(state FeedState) {
+	state.hmacKey = v.hmacKey
+	state.acceptRevoked = v.revocation == RevocationFlag
+	vf := v.feed(state.Author)
+	vf.mu.Lock()
+	vf.buf = NewFeedBuffer(&state, v.bufferLimit)
+	vf.mu.Unlock()
+}
+
+// Append validates tr against the feed of its author.
+// It returns the messages that were appended because of it in order, see FeedBuffer.Add.
+// Messages of authors the AuthorFilter doesn't allow are rejected right away, before a feed is tracked for them.
+// With a Limiter, new messages are rejected before their signature is checked if the author exceeded its quota.
+// With a ContentPolicy, the returned messages might have their content dropped, as do JSON messages
+// with content the JSONPolicy rejects, see WithJSONPolicy.
+// Messages after the revocation of their feed are rejected or marked, see WithRevocationPolicy.
+// With content hashing, the returned messages have their content dropped if it doesn't match or are quarantined,
+// see WithContentHashing and WithQuarantine.
+func (v *Validator) Append(tr *Transfer) ([]*Transfer, error) {
+	evt, err := tr.getEvent()
+	if err != nil {
+		return nil, errors.Wrap(err, "gabbygrove/validator: invalid event")
+	}
+	aref, err := evt.Author.GetRef(RefTypeFeed)
+	if err != nil {
+		return nil, errors.Wrap(err, "gabbygrove/validator: invalid author")
+	}
+
+	author := aref.(refs.FeedRef)
+	if err := checkAuthor(v.filter, evt); err != nil {
+		return nil, err
+	}
+	tr = v.applyPolicy(tr)
+
+	vf := v.feed(author)
+	vf.mu.Lock()
+	defer vf.mu.Unlock()
+	if v.limiter != nil && evt.Sequence > vf.buf.State().Sequence {
+		if err := v.limiter.Allow(author, len(tr.Event)+len(tr.Content)); err != nil {
+			return nil, err
+		}
+	}
+	appended, err := vf.buf.Add(tr)
+	// still holding the lock of the feed, so subscribers see its messages in order
+	revoked := vf.buf.State().Revoked
+	for i, a := range appended {
+		if revoked != nil && a.Seq() > int64(revoked.Sequence) {
+			flagged := *a
+			flagged.afterRevocation = true
+			a = &flagged
+		}
+		appended[i] = v.checkContent(a)
+		v.subs.Broadcast(appended[i])
+	}
+	return appended, err
+}
+
+// WithContentHashing makes the validator hash the content of every appended message.
+// Content that doesn't match the hash in its event is dropped, the message is still appended.
+func (v *Validator) WithContentHashing(yes bool) {
+	v.hashContent = yes
+}
+
+// WithQuarantine defers the content hashing to q, messages are appended and returned as soon as their event
+// and signature are verified and their content is quarantined until one of the workers of q hashed it.
+// What to do with content that doesn't match is up to the rejection handler of q.
+// It's read without locking and can't be changed while messages are appended.
+func (v *Validator) WithQuarantine(q *Quarantine) {
+	v.quarantine = q
+}
+
+// checkContent hashes the content of an appended message or quarantines it
+func (v *Validator) checkContent(tr *Transfer) *Transfer {
+	if len(tr.Content) == 0 {
+		return tr
+	}
+	switch {
+	case v.quarantine != nil:
+		v.quarantine.Add(tr)
+	case v.hashContent && !tr.ContentMatches(tr.Content):
+		if debugEnabled() {
+			debugLog("event", "validate", "msg", tr.Key().URI(), "err", ErrContentHash)
+		}
+		stripped := *tr
+		stripped.Content = nil
+		return &stripped
+	}
+	return tr
+}
+
+// Subscribe returns a channel of the messages appended to the feed of author, or to all feeds if it's nil.
+// See Broadcaster for the semantics of buffer and when the channel is closed.
+func (v *Validator) Subscribe(ctx context.Context, author *refs.FeedRef, buffer int) <-chan *Transfer {
+	return v.subs.Subscribe(ctx, author, buffer)
+}
+
+// Tip returns a copy of the state of author's feed, which is empty for unknown authors
+func (v *Validator) Tip(author refs.FeedRef) FeedState {
+	vf, has := v.lookup(author)
+	if !has {
+		return FeedState{Author: author}
+	}
+	vf.mu.Lock()
+	defer vf.mu.Unlock()
+	return *vf.buf.State()
+}
+
+// Missing returns the sequences of author that keep buffered messages from being appended
+func (v *Validator) Missing(author refs.FeedRef) []SeqRange {
+	vf, has := v.lookup(author)
+	if !has {
+		return nil
+	}
+	vf.mu.Lock()
+	defer vf.mu.Unlock()
+	return vf.buf.Missing()
+}
+
+// Authors returns the number of tracked feeds
+func (v *Validator) Authors() int {
+	var n int
+	for i := range v.shards {
+		s := &v.shards[i]
+		s.mu.Lock()
+		n += len(s.feeds)
+		s.mu.Unlock()
+	}
+	return n
+}
+
+func (v *Validator) shard(author refs.FeedRef) (*validatorShard, string) {
+	pk := author.PubKey()
+	var idx int
+	if len(pk) > 0 {
+		idx = int(pk[0]) % validatorShards
+	}
+	return &v.shards[idx], string(pk)
+}
+
+func (v *Validator) lookup(author refs.FeedRef) (*validatorFeed, bool) {
+	s, key := v.shard(author)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vf, has := s.feeds[key]
+	return vf, has
+}
+
+// feed returns the tracked feed of author and starts tracking it if needed
+func (v *Validator) feed(author refs.FeedRef) *validatorFeed {
+	s, key := v.shard(author)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vf, has := s.feeds[key]
+	if !has {
+		state := NewFeedState(author)
+		state.hmacKey = v.hmacKey
+		state.acceptRevoked = v.revocation == RevocationFlag
+		vf = &validatorFeed{buf: NewFeedBuffer(state, v.bufferLimit)}
+		s.feeds[key] = vf
+	}
+	return vf
+}
+
+// MarshalBinary snapshots the states of all feeds, see FeedState.MarshalBinary.
+// Buffered messages are not part of it, they are reported as missing again after a restart.
+func (v *Validator) MarshalBinary() ([]byte, error) {
+	var states [][]byte
+	for i := range v.shards {
+		s := &v.shards[i]
+		s.mu.Lock()
+		for _, vf := range s.feeds {
+			vf.mu.Lock()
+			b, err := vf.buf.State().MarshalBinary()
+			vf.mu.Unlock()
+			if err != nil {
+				s.mu.Unlock()
+				return nil, err
+			}
+			states = append(states, b)
+		}
+		s.mu.Unlock()
+	}
+
+	var buf bytes.Buffer
+	var vbuf [binary.MaxVarintLen64]byte
+	buf.Write(vbuf[:binary.PutUvarint(vbuf[:], uint64(len(states)))])
+	for _, b := range states {
+		buf.Write(vbuf[:binary.PutUvarint(vbuf[:], uint64(len(b)))])
+		buf.Write(b)
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary tracks all the feeds of a snapshot made by MarshalBinary, v needs to come from NewValidator
+func (v *Validator) UnmarshalBinary(data []byte) error {
+	rd := bytes.NewReader(data)
+	n, err := binary.ReadUvarint(rd)
+	if err != nil {
+		return errors.Wrap(err, "gabbygrove/validator: count")
+	}
+
+	states := make([]FeedState, 0)
+	for i := uint64(0); i < n; i++ {
+		l, err := binary.ReadUvarint(rd)
+		if err != nil || l > uint64(rd.Len()) {
+			return errors.Errorf("gabbygrove/validator: invalid length of state %d", i)
+		}
+		b := make([]byte, l)
+		io.ReadFull(rd, b)
+		var fs FeedState
+		if err := fs.UnmarshalBinary(b); err != nil {
+			return errors.Wrapf(err, "gabbygrove/validator: state %d", i)
+		}
+		states = append(states, fs)
+	}
+	if rd.Len() != 0 {
+		return errors.Errorf("gabbygrove/validator: %d trailing bytes", rd.Len())
+	}
+
+	for _, fs := range states {
+		v.Track(fs)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+func TestValidator(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	const feedCount = 8
+	var (
+		authors []refs.FeedRef
+		feeds   [][]*Transfer
+	)
+	for i := 0; i < feedCount; i++ {
+		author, trs := makeTestFeed(t, fmt.Sprintf("%04d", i), 6)
+		authors = append(authors, author)
+		feeds = append(feeds, trs)
+	}
+
+	v := NewValidator(4)
+
+	var wg sync.WaitGroup
+	errc := make(chan error, feedCount)
+	for i := range feeds {
+		wg.Add(1)
+		go func(trs []*Transfer) {
+			defer wg.Done()
+			// leave out the fourth message
+			for _, idx := range []int{1, 0, 2, 4, 5} {
+				if _, err := v.Append(trs[idx]); err != nil {
+					errc <- err
+					return
+				}
+			}
+		}(feeds[i])
+	}
+	wg.Wait()
+	close(errc)
+	for err := range errc {
+		r.NoError(err)
+	}
+
+	a.Equal(feedCount, v.Authors())
+	for i, author := range authors {
+		tip := v.Tip(author)
+		a.EqualValues(3, tip.Sequence)
+		a.Equal(feeds[i][2].Key(), *tip.Tip)
+		a.Equal([]SeqRange{{Author: author, From: 4, To: 4}}, v.Missing(author))
+	}
+
+	appended, err := v.Append(feeds[0][3])
+	r.NoError(err)
+	a.Len(appended, 3)
+	a.EqualValues(6, v.Tip(authors[0]).Sequence)
+	a.Len(v.Missing(authors[0]), 0)
+
+	broken := *feeds[1][3]
+	broken.Signature = append([]byte{}, broken.Signature...)
+	broken.Signature[3] ^= 1
+	_, err = v.Append(&broken)
+	a.Equal(ErrInvalidSignature, errors.Cause(err))
+
+	unknown, _ := makeTestFeed(t, "nope", 1)
+	a.EqualValues(0, v.Tip(unknown).Sequence)
+	a.Nil(v.Missing(unknown))
+
+	// snapshot and resume
+	data, err := v.MarshalBinary()
+	r.NoError(err)
+	resumed := NewValidator(4)
+	r.NoError(resumed.UnmarshalBinary(data))
+	a.Equal(feedCount, resumed.Authors())
+	a.EqualValues(6, resumed.Tip(authors[0]).Sequence)
+	a.EqualValues(3, resumed.Tip(authors[1]).Sequence)
+	a.Len(resumed.Missing(authors[1]), 0, "buffered messages are not part of the snapshot")
+
+	appended, err = resumed.Append(feeds[1][3])
+	r.NoError(err)
+	a.Len(appended, 1)
+
+	a.Error(resumed.UnmarshalBinary(data[:len(data)-1]))
+}
@@ -0,0 +1,141 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/nacl/auth"
+)
+
+// ErrContentHash is returned by VerifyAll if the content doesn't hash to the reference of the event
+var ErrContentHash = errors.New("gabbygrove: content does not match the hash of the event")
+
+// VerifyStage names the step of the verification that failed.
+// The stages run in this order, cheapest first, so garbage is rejected before any signature is checked or content hashed.
+type VerifyStage uint
+
+const (
+	// VerifyStructure covers the encoded sizes and decoding the event
+	VerifyStructure VerifyStage = iota + 1
+
+	// VerifyContentSize compares the content with the size and type the event announces
+	VerifyContentSize
+
+	// VerifySignature checks the signature of the event with the suite of its author
+	VerifySignature
+
+	// VerifyContentHash hashes the content, only done by VerifyAll
+	VerifyContentHash
+)
+
+func (s VerifyStage) String() string {
+	switch s {
+	case VerifyStructure:
+		return "structure"
+	case VerifyContentSize:
+		return "content size"
+	case VerifySignature:
+		return "signature"
+	case VerifyContentHash:
+		return "content hash"
+	default:
+		return fmt.Sprintf("stage(%d)", uint(s))
+	}
+}
+
+// VerifyError is returned by VerifyAll
+type VerifyError struct {
+	Stage VerifyStage
+	Err   error
+}
+
+func (ve *VerifyError) Error() string {
+	return fmt.Sprintf("gabbygrove/verify: %s: %s", ve.Stage, ve.Err)
+}
+
+// Cause returns the underlying error, i.e. ErrInvalidSignature for VerifySignature
+func (ve *VerifyError) Cause() error { return ve.Err }
+
+func (ve *VerifyError) Unwrap() error { return ve.Err }
+
+// Verify returns true if the Message was signed by the author specified by the meta portion of the message.
+// Content that is present needs to have the announced size, its hash is not checked. Use VerifyAll for that and to see why a message failed.
+func (tr *Transfer) Verify(hmacKey *[32]byte) bool {
+	return tr.verifyKey(nil, hmacKey, false) == nil
+}
+
+// VerifyAll is Verify that also hashes the content, if present.
+// Errors are a *VerifyError of the first failed stage.
+func (tr *Transfer) VerifyAll(hmacKey *[32]byte) error {
+	return tr.verifyKey(nil, hmacKey, true)
+}
+
+// verifyKey checks tr against vk, or against the key of its author if vk is nil
+func (tr *Transfer) verifyKey(vk *VerifyKey, hmacKey *[32]byte, hashContent bool) (err error) {
+	defer func() {
+		countMetric(MetricVerify, err != nil, len(tr.Event))
+		if err == nil || !debugEnabled() {
+			return
+		}
+		if evt := tr.lazyEvt; evt != nil {
+			debugLog("event", "verify", "msg", tr.Key().URI(), "author", evt.Author.URI(), "seq", evt.Sequence, "err", err)
+		} else {
+			debugLog("event", "verify", "msg", tr.Key().URI(), "err", err)
+		}
+	}()
+
+	if err := tr.checkSizes(DefaultLimits); err != nil {
+		return &VerifyError{Stage: VerifyStructure, Err: err}
+	}
+	evt, err := tr.getEvent()
+	if err != nil {
+		return &VerifyError{Stage: VerifyStructure, Err: err}
+	}
+	var (
+		pub   ed25519.PublicKey
+		suite SignatureSuite
+	)
+	if vk != nil {
+		if !evt.AuthorFeedRef().Equal(vk.author) {
+			return &VerifyError{Stage: VerifyStructure, Err: ErrWrongAuthor}
+		}
+		pub, suite = vk.pub, vk.suite
+	} else {
+		aref, err := evt.Author.GetRef(RefTypeFeed)
+		if err != nil {
+			return &VerifyError{Stage: VerifyStructure, Err: err}
+		}
+		author := aref.(refs.FeedRef)
+		if suite, err = SuiteOf(author); err != nil {
+			return &VerifyError{Stage: VerifyStructure, Err: err}
+		}
+		pub = author.PubKey()
+	}
+
+	if evt.Content.Size == 0 && evt.Content.Type != ContentTypeArbitrary {
+		return &VerifyError{Stage: VerifyContentSize, Err: ErrEmptyContent}
+	}
+	if tr.HasContent() && len(tr.Content) != int(evt.Content.Size) {
+		return &VerifyError{Stage: VerifyContentSize, Err: ErrContentSize}
+	}
+
+	toVerify := tr.Event
+	if hmacKey != nil {
+		mac := auth.Sum(tr.Event, hmacKey)
+		toVerify = mac[:]
+	}
+	if err := verifySignature(suite, pub, toVerify, tr.Signature); err != nil {
+		return &VerifyError{Stage: VerifySignature, Err: err}
+	}
+
+	if hashContent && tr.HasContent() && !tr.ContentMatches(tr.Content) {
+		return &VerifyError{Stage: VerifyContentHash, Err: ErrContentHash}
+	}
+	return nil
+}
@@ -0,0 +1,364 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+// Package verify checks the signature and content hash of a single gabbygrove transfer.
+//
+// It only uses the standard library, so it can be audited on its own and used where the dependencies of the main package can't.
+// It doesn't track feeds, see gabbygrove.FeedState for that.
+package verify
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	// ErrMalformed is returned if the transfer or event can't be parsed
+	ErrMalformed = errors.New("gabbygrove/verify: malformed transfer")
+
+	// ErrSignature is returned if the signature doesn't match the event and its author
+	ErrSignature = errors.New("gabbygrove/verify: invalid signature")
+
+	// ErrContent is returned if the content doesn't match the size or hash of the event
+	ErrContent = errors.New("gabbygrove/verify: content doesn't match the event")
+)
+
+// Result holds the fields of a verified transfer
+type Result struct {
+	// Author is the ed25519 public key of the feed
+	Author [32]byte
+
+	// Previous is the hash of the message before, nil for the first message
+	Previous *[32]byte
+
+	Sequence  uint64
+	Timestamp int64
+
+	// Key is the sha256 hash of the event and signature, the message key
+	Key [32]byte
+
+	ContentHash [32]byte
+	ContentSize uint16
+	ContentType uint64
+
+	// HasContent is false if the content was left out of the transfer (encoded as null).
+	// An empty byte string is content of size zero.
+	HasContent bool
+}
+
+// Transfer verifies the encoded transfer in data.
+// hmacKey needs to be set for feeds that sign with an HMAC key and is nil otherwise.
+//
+// Unknown fields after the known ones are skipped.
+// The ones of the event are covered by the signature and not looked at further.
+func Transfer(data []byte, hmacKey *[32]byte) (*Result, error) {
+	p := parser{data: data}
+	n, err := p.expect(majorArray)
+	if err != nil || n < 3 {
+		return nil, malformed("transfer", err)
+	}
+	evtBytes, err := p.bytes()
+	if err != nil || evtBytes == nil {
+		return nil, malformed("event", err)
+	}
+	sig, err := p.bytes()
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return nil, malformed("signature", err)
+	}
+	content, err := p.bytes()
+	if err != nil {
+		return nil, malformed("content", err)
+	}
+	if err := p.skipN(n - 3); err != nil {
+		return nil, malformed("unknown transfer fields", err)
+	}
+	if p.off != len(data) {
+		return nil, malformed("trailing bytes after transfer", nil)
+	}
+
+	res, err := parseEvent(evtBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := evtBytes
+	if hmacKey != nil {
+		mac := hmac.New(sha512.New, hmacKey[:])
+		mac.Write(evtBytes)
+		signed = mac.Sum(nil)[:32]
+	}
+	if !ed25519.Verify(ed25519.PublicKey(res.Author[:]), signed, sig) {
+		return nil, ErrSignature
+	}
+
+	if content != nil {
+		if len(content) != int(res.ContentSize) {
+			return nil, fmt.Errorf("%w: size %d != %d", ErrContent, len(content), res.ContentSize)
+		}
+		if sha256.Sum256(content) != res.ContentHash {
+			return nil, fmt.Errorf("%w: wrong hash", ErrContent)
+		}
+		res.HasContent = true
+	}
+
+	h := sha256.New()
+	h.Write(evtBytes)
+	h.Write(sig)
+	copy(res.Key[:], h.Sum(nil))
+	return res, nil
+}
+
+func malformed(what string, err error) error {
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrMalformed, what, err)
+	}
+	return fmt.Errorf("%w: %s", ErrMalformed, what)
+}
+
+// the type prefixes of binary references
+const (
+	refFeed    = 0x01
+	refMessage = 0x02
+	refContent = 0x03
+)
+
+func parseEvent(data []byte) (*Result, error) {
+	p := parser{data: data}
+	n, err := p.expect(majorArray)
+	if err != nil || n < 5 {
+		return nil, malformed("event", err)
+	}
+
+	var res Result
+	if !p.null() {
+		var prev [32]byte
+		if err := p.ref(refMessage, &prev); err != nil {
+			return nil, malformed("previous", err)
+		}
+		res.Previous = &prev
+	}
+	if err := p.ref(refFeed, &res.Author); err != nil {
+		return nil, malformed("author", err)
+	}
+	if res.Sequence, err = p.expect(majorUint); err != nil {
+		return nil, malformed("sequence", err)
+	}
+	if res.Timestamp, err = p.int(); err != nil {
+		return nil, malformed("timestamp", err)
+	}
+
+	if cn, err := p.expect(majorArray); err != nil || cn != 3 {
+		return nil, malformed("content info", err)
+	}
+	if err := p.ref(refContent, &res.ContentHash); err != nil {
+		return nil, malformed("content hash", err)
+	}
+	size, err := p.expect(majorUint)
+	if err != nil || size > math.MaxUint16 {
+		return nil, malformed("content size", err)
+	}
+	res.ContentSize = uint16(size)
+	if res.ContentType, err = p.expect(majorUint); err != nil {
+		return nil, malformed("content type", err)
+	}
+
+	if res.ContentSize == 0 && res.ContentType != 0 {
+		return nil, malformed("only arbitrary content can be empty", nil)
+	}
+	if err := p.skipN(n - 5); err != nil {
+		return nil, malformed("event extensions", err)
+	}
+	if p.off != len(data) {
+		return nil, malformed("trailing bytes after event", nil)
+	}
+	if res.Sequence == 0 {
+		return nil, malformed("sequence zero", nil)
+	}
+	if (res.Sequence == 1) != (res.Previous == nil) {
+		return nil, malformed("previous doesn't match sequence", nil)
+	}
+	return &res, nil
+}
+
+const (
+	majorUint   = 0
+	majorNegInt = 1
+	majorBytes  = 2
+	majorText   = 3
+	majorArray  = 4
+	majorMap    = 5
+	majorTag    = 6
+
+	cypherLinkTag = 1050
+	null          = 0xf6
+	maxDepth      = 16
+	maxUnknown    = 16
+)
+
+var errShort = errors.New("unexpected end of data")
+
+// parser reads definite length CBOR items from data
+type parser struct {
+	data []byte
+	off  int
+}
+
+func (p *parser) head() (byte, uint64, error) {
+	if p.off >= len(p.data) {
+		return 0, 0, errShort
+	}
+	b := p.data[p.off]
+	major, info := b>>5, b&0x1f
+	p.off++
+
+	var size int
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, 0, fmt.Errorf("unsupported head %x", b)
+	}
+	if p.off+size > len(p.data) {
+		return 0, 0, errShort
+	}
+	var arg uint64
+	for _, b := range p.data[p.off : p.off+size] {
+		arg = arg<<8 | uint64(b)
+	}
+	p.off += size
+	return major, arg, nil
+}
+
+func (p *parser) expect(major byte) (uint64, error) {
+	got, arg, err := p.head()
+	if err != nil {
+		return 0, err
+	}
+	if got != major {
+		return 0, fmt.Errorf("expected major type %d, got %d", major, got)
+	}
+	return arg, nil
+}
+
+func (p *parser) null() bool {
+	if p.off < len(p.data) && p.data[p.off] == null {
+		p.off++
+		return true
+	}
+	return false
+}
+
+// bytes returns the next byte string or nil for null
+func (p *parser) bytes() ([]byte, error) {
+	if p.null() {
+		return nil, nil
+	}
+	n, err := p.expect(majorBytes)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(p.data)-p.off) {
+		return nil, errShort
+	}
+	b := p.data[p.off : p.off+int(n)]
+	p.off += int(n)
+	return b, nil
+}
+
+func (p *parser) int() (int64, error) {
+	major, arg, err := p.head()
+	if err != nil {
+		return 0, err
+	}
+	if arg > math.MaxInt64 {
+		return 0, errors.New("integer overflows int64")
+	}
+	switch major {
+	case majorUint:
+		return int64(arg), nil
+	case majorNegInt:
+		return -1 - int64(arg), nil
+	}
+	return 0, fmt.Errorf("expected an integer, got major type %d", major)
+}
+
+// ref reads a tagged cypherlink of the wanted type into hash
+func (p *parser) ref(typ byte, hash *[32]byte) error {
+	tag, err := p.expect(majorTag)
+	if err != nil {
+		return err
+	}
+	if tag != cypherLinkTag {
+		return fmt.Errorf("expected cypherlink tag, got %d", tag)
+	}
+	b, err := p.bytes()
+	if err != nil {
+		return err
+	}
+	if len(b) != 33 || b[0] != typ {
+		return fmt.Errorf("expected reference of type %d", typ)
+	}
+	copy(hash[:], b[1:])
+	return nil
+}
+
+func (p *parser) skipN(n uint64) error {
+	if n > maxUnknown {
+		return fmt.Errorf("more than %d unknown fields", maxUnknown)
+	}
+	for i := uint64(0); i < n; i++ {
+		if err := p.skip(0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *parser) skip(depth int) error {
+	if depth > maxDepth {
+		return errors.New("nested too deep")
+	}
+	major, arg, err := p.head()
+	if err != nil {
+		return err
+	}
+	switch major {
+	case majorBytes, majorText:
+		if arg > uint64(len(p.data)-p.off) {
+			return errShort
+		}
+		p.off += int(arg)
+	case majorArray, majorMap:
+		if arg > uint64(len(p.data)-p.off) {
+			return errShort
+		}
+		if major == majorMap {
+			arg *= 2
+		}
+		for i := uint64(0); i < arg; i++ {
+			if err := p.skip(depth + 1); err != nil {
+				return err
+			}
+		}
+	case majorTag:
+		if p.off < len(p.data) && p.data[p.off]>>5 == majorTag {
+			return errors.New("nested tags")
+		}
+		return p.skip(depth + 1)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package verify
+
+import (
+	"bytes"
+	"errors"
+	goparser "go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestVerify(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	pub, priv, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte("dead"), 8)))
+	r.NoError(err)
+	author, err := refs.NewFeedRefFromBytes(pub, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+
+	hmacKey := bytes.Repeat([]byte{0x23}, 32)
+	var key [32]byte
+	copy(key[:], hmacKey)
+
+	for _, withHMAC := range []bool{false, true} {
+		e := gabbygrove.NewEncoder(priv)
+		var macKey *[32]byte
+		if withHMAC {
+			r.NoError(e.WithHMAC(hmacKey))
+			macKey = &key
+		}
+		state := gabbygrove.NewFeedState(author)
+		if withHMAC {
+			r.NoError(state.WithHMAC(hmacKey))
+		}
+
+		for i := 0; i < 3; i++ {
+			seq, prev := state.Next()
+			tr, msgKey, err := e.Encode(seq, prev, map[string]interface{}{"type": "test", "i": i})
+			r.NoError(err)
+			r.NoError(state.Append(tr))
+
+			data, err := tr.MarshalCBOR()
+			r.NoError(err)
+
+			res, err := Transfer(data, macKey)
+			r.NoError(err)
+			a.Equal(pub, ed25519.PublicKey(res.Author[:]))
+			a.Equal(seq, res.Sequence)
+			a.True(res.HasContent)
+			a.EqualValues(len(tr.Content), res.ContentSize)
+			a.EqualValues(gabbygrove.ContentTypeJSON, res.ContentType)
+
+			var wantKey [32]byte
+			r.NoError(msgKey.CopyHashTo(wantKey[:]))
+			a.Equal(wantKey, res.Key)
+			if seq == 1 {
+				a.Nil(res.Previous)
+			} else {
+				r.NotNil(res.Previous)
+				var wantPrev [32]byte
+				mr, err := prev.GetRef(gabbygrove.RefTypeMessage)
+				r.NoError(err)
+				r.NoError(mr.(refs.MessageRef).CopyHashTo(wantPrev[:]))
+				a.Equal(wantPrev, *res.Previous)
+			}
+
+			// the wrong (or a missing) hmac key fails the signature
+			var other *[32]byte
+			if !withHMAC {
+				other = &key
+			}
+			_, err = Transfer(data, other)
+			a.True(errors.Is(err, ErrSignature), "%v", err)
+
+			// without content
+			stripped := gabbygrove.Transfer{Event: tr.Event, Signature: tr.Signature}
+			data, err = stripped.MarshalCBOR()
+			r.NoError(err)
+			res, err = Transfer(data, macKey)
+			r.NoError(err)
+			a.False(res.HasContent)
+
+			// tampered content
+			tampered := gabbygrove.Transfer{Event: tr.Event, Signature: tr.Signature, Content: bytes.ToUpper(tr.Content)}
+			data, err = tampered.MarshalCBOR()
+			r.NoError(err)
+			_, err = Transfer(data, macKey)
+			a.True(errors.Is(err, ErrContent), "%v", err)
+		}
+	}
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, priv, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte("beef"), 8)))
+	r.NoError(err)
+	tr, _, err := gabbygrove.NewEncoder(priv).Encode(1, gabbygrove.BinaryRef{}, []byte("hello"))
+	r.NoError(err)
+	data, err := tr.MarshalCBOR()
+	r.NoError(err)
+
+	for i := 0; i < len(data); i++ {
+		_, err := Transfer(data[:i], nil)
+		a.True(errors.Is(err, ErrMalformed), "truncated at %d: %v", i, err)
+	}
+	_, err = Transfer(append(data, 0), nil)
+	a.True(errors.Is(err, ErrMalformed))
+
+	flipped := append([]byte{}, data...)
+	flipped[len(flipped)-1] ^= 1
+	_, err = Transfer(flipped, nil)
+	a.True(errors.Is(err, ErrContent), "%v", err)
+}
+
+// the point of this package is to only depend on the standard library
+func TestStdlibOnly(t *testing.T) {
+	r := require.New(t)
+
+	files, err := filepath.Glob("*.go")
+	r.NoError(err)
+	for _, f := range files {
+		if strings.HasSuffix(f, "_test.go") {
+			continue
+		}
+		parsed, err := goparser.ParseFile(token.NewFileSet(), f, nil, goparser.ImportsOnly)
+		r.NoError(err)
+		for _, imp := range parsed.Imports {
+			path, err := strconv.Unquote(imp.Path.Value)
+			r.NoError(err)
+			r.NotContains(strings.SplitN(path, "/", 2)[0], ".", "%s imports %s", f, path)
+		}
+	}
+}
+
+func TestVerifyEmptyContent(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, priv, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte("beef"), 8)))
+	r.NoError(err)
+	tr, _, err := gabbygrove.NewEncoder(priv).Encode(1, gabbygrove.BinaryRef{}, []byte{})
+	r.NoError(err)
+	data, err := tr.MarshalCBOR()
+	r.NoError(err)
+
+	res, err := Transfer(data, nil)
+	r.NoError(err)
+	a.True(res.HasContent)
+	a.EqualValues(0, res.ContentSize)
+}
@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyStages(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	_, trs := makeTestFeed(t, "dead", 1)
+	good := trs[0]
+	r.NoError(good.VerifyAll(nil))
+	a.True(good.Verify(nil))
+
+	stageOf := func(err error) VerifyStage {
+		var ve *VerifyError
+		r.True(errors.As(err, &ve), "not a VerifyError: %v", err)
+		return ve.Stage
+	}
+
+	badSig := append([]byte{}, good.Signature...)
+	badSig[0] ^= 1
+	otherContent := bytes.ToUpper(good.Content)
+
+	type tcase struct {
+		tr    Transfer
+		stage VerifyStage
+		cause error
+	}
+	for i, tc := range []tcase{
+		{Transfer{Event: good.Event, Signature: good.Signature[:10]}, VerifyStructure, nil},
+		{Transfer{Event: good.Event[:10], Signature: good.Signature}, VerifyStructure, nil},
+		// the size is checked before the signature
+		{Transfer{Event: good.Event, Signature: badSig, Content: good.Content[1:]}, VerifyContentSize, ErrContentSize},
+		{Transfer{Event: good.Event, Signature: badSig, Content: good.Content}, VerifySignature, ErrInvalidSignature},
+		{Transfer{Event: good.Event, Signature: good.Signature, Content: otherContent}, VerifyContentHash, ErrContentHash},
+	} {
+		tr := tc.tr
+		err := tr.VerifyAll(nil)
+		r.Error(err, "case %d", i)
+		a.Equal(tc.stage, stageOf(err), "case %d: %s", i, err)
+		if tc.cause != nil {
+			a.Equal(tc.cause, errors.Cause(err), "case %d", i)
+		}
+	}
+
+	// Verify doesn't hash the content
+	tr := Transfer{Event: good.Event, Signature: good.Signature, Content: otherContent}
+	a.True(tr.Verify(nil))
+
+	// missing content is fine
+	tr = Transfer{Event: good.Event, Signature: good.Signature}
+	a.NoError(tr.VerifyAll(nil))
+
+	var key [32]byte
+	a.Equal(VerifySignature, stageOf(good.VerifyAll(&key)))
+	a.Equal("content size", VerifyContentSize.String())
+}
@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+)
+
+// VerifyKey is the parsed public key of a feed, for callers that verify many messages of the same author
+// and keep the keys of the authors around, like a FeedState does.
+// It only saves parsing the author of every event into a key and its suite. Nothing is precomputed:
+// crypto/ed25519 has no API to keep the decompressed point of a key, it's decoded on every signature check.
+type VerifyKey struct {
+	author refs.FeedRef
+	suite  SignatureSuite
+	pub    ed25519.PublicKey
+}
+
+// NewVerifyKey parses the key of author, which needs to be a gabbygrove feed
+func NewVerifyKey(author refs.FeedRef) (*VerifyKey, error) {
+	suite, err := SuiteOf(author)
+	if err != nil {
+		return nil, err
+	}
+	pub := author.PubKey()
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, errors.Errorf("gabbygrove: invalid public key length %d", len(pub))
+	}
+	return &VerifyKey{author: author, suite: suite, pub: append(ed25519.PublicKey{}, pub...)}, nil
+}
+
+// Author returns the feed of the key
+func (vk *VerifyKey) Author() refs.FeedRef {
+	return vk.author
+}
+
+// Suite returns the suite the key verifies with
+func (vk *VerifyKey) Suite() SignatureSuite {
+	return vk.suite
+}
+
+// VerifyWithKey is VerifyAll for a message that needs to be by the author of vk.
+// Errors are a *VerifyError, a message of another author fails in the VerifyStructure stage with ErrWrongAuthor.
+func (tr *Transfer) VerifyWithKey(vk *VerifyKey, hmacKey *[32]byte) error {
+	return tr.verifyKey(vk, hmacKey, true)
+}
+
+// verifyKey returns the key of fs' author, which is parsed once
+func (fs *FeedState) verifyKey() (*VerifyKey, error) {
+	if fs.key == nil || !fs.key.author.Equal(fs.Author) {
+		vk, err := NewVerifyKey(fs.Author)
+		if err != nil {
+			return nil, err
+		}
+		fs.key = vk
+	}
+	return fs.key, nil
+}
@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: 2021 Henry Bubert
+//
+// SPDX-License-Identifier: MIT
+
+package gabbygrove
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+func TestVerifyWithKey(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	alice, trs := makeTestFeed(t, "alic", 2)
+	bob, _ := makeTestFeed(t, "bobb", 0)
+
+	legacy, err := refs.NewFeedRefFromBytes(alice.PubKey(), refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+	_, err = NewVerifyKey(legacy)
+	a.Error(err)
+
+	vk, err := NewVerifyKey(alice)
+	r.NoError(err)
+	a.True(vk.Author().Equal(alice))
+	for _, tr := range trs {
+		a.NoError(tr.VerifyWithKey(vk, nil))
+	}
+
+	stageOf := func(err error) VerifyStage {
+		var ve *VerifyError
+		r.True(errors.As(err, &ve), "not a VerifyError: %v", err)
+		return ve.Stage
+	}
+
+	bobKey, err := NewVerifyKey(bob)
+	r.NoError(err)
+	err = trs[0].VerifyWithKey(bobKey, nil)
+	a.Equal(VerifyStructure, stageOf(err))
+	a.Equal(ErrWrongAuthor, errors.Cause(err))
+
+	badSig := *trs[1]
+	badSig.Signature = append([]byte{}, trs[1].Signature...)
+	badSig.Signature[3] ^= 1
+	a.Equal(VerifySignature, stageOf(badSig.VerifyWithKey(vk, nil)))
+
+	a.Equal(VerifyContentHash, stageOf(tampered(trs[1]).VerifyWithKey(vk, nil)))
+}
+
+func TestFeedStateKeyCached(t *testing.T) {
+	r := require.New(t)
+	a := assert.New(t)
+
+	author, trs := makeTestFeed(t, "alic", 3)
+	state := NewFeedState(author)
+	r.NoError(state.Append(trs[0]))
+	vk := state.key
+	r.NotNil(vk, "key not cached after Append")
+	a.True(vk.Author().Equal(author))
+
+	r.NoError(state.Check(trs[1]))
+	r.NoError(state.Append(trs[1]))
+	a.True(vk == state.key, "key parsed again")
+
+	fb := NewFeedBuffer(state, 4)
+	_, err := fb.Add(trs[2])
+	r.NoError(err)
+	a.True(vk == state.key, "key parsed again by the buffer")
+}
+
+func BenchmarkVerifyAll(b *testing.B) {
+	_, trs := makeTestFeed(b, "dead", 1)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := trs[0].VerifyAll(nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkVerifyWithKey(b *testing.B) {
+	author, trs := makeTestFeed(b, "dead", 1)
+	vk, err := NewVerifyKey(author)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := trs[0].VerifyWithKey(vk, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}